@@ -257,10 +257,13 @@ Messages is a list of data messages received from users between the previous and
   ...
 }
 
+Delta is the real time elapsed since the previous match_loop call started, in milliseconds. May exceed the tick interval
+if ticks were delayed, useful to drive a fixed timestep accumulator.
+
 Expected return these values (all required) in order:
 1. An (optionally) updated state. May be any non-nil Lua term, or nil to end the match.
 --]]
-local function match_loop(context, dispatcher, tick, state, messages)
+local function match_loop(context, dispatcher, tick, state, messages, delta)
   if state.debug then
     print("match " .. context.match_id .. " tick " .. tick)
     print("match " .. context.match_id .. " messages:\n" .. du.print_r(messages))
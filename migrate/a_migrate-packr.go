@@ -11,4 +11,8 @@ func init() {
 	packr.PackJSONBytes("./sql", "20180805174141-tournaments.sql", "\"H4sIAAAAAAAA/5xV3Y6bSBO95ylKvslMPvwzI0XfbqyNxNjMBgVDZHB+9sZqNzWmN9BNupvY3qdfNbYBT4zHWeQbTJ3TVeecguFrC17DRBQ7ydaphvvR3W8QpwgB+UZyAk6pUyGVBVWdzyhyhQmUPEEJOkVwCkJTPD6x4RNKxQSH+8EIbkxB7/Codzs2FDtRQk52wIWGUiHolCl4YhkCbikWGhgHKvIiY4RThA3TaXXOgWVgOL4eOMRKE8aBABXFDsRTuxCIPjSdal28HQ43m82AVM0OhFwPs32ZGvrexA0it38/GB0AC56hUiDxe8kkJrDaASmKjFGyyhAysgEhgawlYgJamIY3kmnG1zYo8aQ3RKKhSZjSkq1KfaLXsT2mTgoEB8Kh50TgRT14cCIvsg3JZy9+Hy5i+OzM504Qe24E4RwmYTD1Yi8MIggfwQm+wgcvmNqATKcoAbeFNBMICcwoiUklW4R40sKT2LekCqTsiVHICF+XZI2wFj9QcsbXUKDMmTKOKiA8MTQZy5kmuvrrp7nMQUPL6vchCGP3LcTG3pytZQWAnPCSZNnOWJwzrYx4CgsiiUbQknBF6J5ZC0CuSomGq+qSppgTKIuEaFRAJILC7yVyaixCSkyaqKDfpCA0TVaQCFRVzFRZFEJqQ0SSxEw1ee9OPgAVXGlJGNcKfjACPceP3TnEzoPvwmAwAGc6hUnoL2ZBD5QmGnPkWg2q8f63HwphUZhDmtatB/dPLxhbbbIMSYJyJYhMLGjRAiUa10LuoLqimeP7XhBXN1P30Vn4MYyMkhAsfN8+xSaoqGRFpSvAJ2c+ee/Mb+7fvLmtsa9edYLLgyPVdTyz62Do9/dOUcETNTilQp4sNctxj469mRvFzuxj/FdD9eru9/+P+qO7/ugORqO31Q8W8aSzvb8F48t6AR/C0Hed4LS9R8eP3C58TrZLxf7BC+PdjQ7XJQ5e5ktFhcSLHKdC5WQLJMvEBhPYY4nWmBf6uXCa6Qxrzl80sJnuGgOfYTWRuvbsrGNcbG5ua/zY6orzUiIVz1P9s3Jdgo2tSTibefHYunZtgiieO4aSpki/LesF2q/0TX3/7g8Y3dpdsDr+B1h9fxlWx+oAq+/fvYRq5GhBmz8NfmxZk7nrxC54wdT9At5jJZP7xYviqF7YZWPe8rh5tQpLlmwhDNrCNbPZLd/tZmunbjSx6xfRC12IDUe5ZMkStwWTu/3p7Tiw5EwPh5DAzRFuQwtvwynB7fVp+69C16HrTtsnx/emRobOyNkXq2rVL1YdA/RyVT3CNepcT9X+kk3FhlvTefixsf5Xw3e6vw3BqbbVGYd3RVPT1vV8ReuLd6GoJfz5imPn3RUnn5/usrZ73RW13N1l1Zeg+/HlMxpPxtZ5+67c2mfJOutek7ErBh5b/wYAAP//+YN9EF8MAAA=\"")
 	packr.PackJSONBytes("./sql", "20200116134800-facebook-instant-games.sql", "\"H4sIAAAAAAAA/3SSQW+bQBCF7/4VTz4lqWO7PlXNidhEQXWhBZw0p2gMA4wCu3R3KfG/r9ZxpFpVrszje2/e7OJqgiusdX8wUjcOq+VqibxhxPRCHSEYXKONneCo20rBynKJQZVs4BpG0FPR8Ptkhgc2VrTCar7EhRdMT6Pp5Y1HHPSAjg5Q2mGwDNeIRSUtg18L7h1EodBd3wqpgjGKa44+J8rcM55ODL13JAqEQvcH6OpfIcidQjfO9V8Xi3Ec53QMO9emXrRvMrvYRuswzsLr1Xx5+mGnWrYWhn8PYrjE/gDq+1YK2reMlkZoA6oNcwmnfeDRiBNVz2B15UYy7DGlWGdkP7izvt7jiT0TaAVSmAYZomyK2yCLspmHPEb5fbLL8RikaRDnUZghSbFO4k2UR0mcIblDED/hWxRvZmBxDRvwa2/8BtpAfJNcHmvLmM8iVPotku25kEoKtKTqgWpGrf+wUaJq9Gw6sf6iFqRKj2mlE0fu+Om/vbzRYjK5vsanTmpDjrHrJ8E2D1Pkwe029Ef37wlAsNlgnWx332NUVPBe65dnUdaRcs81dfwsJR6CdH0fpBefV18usYujn7vw5hy/0aP6wGCTJj/eHaI7hL+iLM8+9LqZ/A0AAP//Ai+1XA0DAAA=\"")
 	packr.PackJSONBytes("./sql", "20200615102232-apple.sql", "\"H4sIAAAAAAAA/3SSQXPTMBCF7/kVb3JqS5qEnBh6UhN36iHYYDstPTGKvbF3sCUhybj594zchCHDcNU+ffv27S5uJrjBWpuj5brxWC1XSxQNIZE/ZCchet9o6yYYdVsuSTmq0KuKLHxDEEaWDZ0rMzyRdawVVvMlroJgeipNr+8C4qh7dPIIpT16R/ANOxy4JdBrScaDFUrdmZalKgkD+2bsc6LMA+PlxNB7L1lBotTmCH34WwjpT6Yb783HxWIYhrkczc61rRftm8wttvE6SvLodjVfnj7sVEvOwdLPni1V2B8hjWm5lPuW0MoB2kLWlqiC18HwYNmzqmdw+uAHaSlgKnbe8r73F3md7bG7EGgFqTAVOeJ8inuRx/ksQJ7j4jHdFXgWWSaSIo5ypBnWabKJizhNcqQPEMkLPsXJZgZi35AFvRobJtAWHJKkaowtJ7qwcNBvlpyhkg9copWq7mVNqPUvsopVDUO2Yxc26iBVFTAtd+ylH5/+mSs0Wkwmt7d413FtpSfszERsiyhDIe63UVh6uCcAYrPBOt3uPidjvvSdKzyJbP0osqv3qw/X2CXx1110d4nb6EH9B7jJ0i9nYvyA6FucF/kf9t3kdwAAAP//oiQc7u0CAAA=\"")
+	packr.PackJSONBytes("./sql", "20201005180855-wallet-metadata.sql", "\"H4sIAAAAAAAC/3WSQY+bMBCF7/kVo1y23SYhyrE5kUBUWgpVgG73VE1gQqyCTW1TNlr1v3ecZaXNruoLMvP85ntje7cTuIWt6s5a1CcLq+VqCfmJIMFf2CL4vT0pbVjkdLEoSRqqoJcVabCs8zss+TNWZvCdtBFKwmqxhHdOMB1L0/drZ3FWPbR4Bqks9IbYQxg4ioaAHkrqLAgJpWq7RqAsCQZhT5c+o8vCedyPHupgkeXIBzreHV8KAe0IfbK2++h5wzAs8AK7ULr2mieZ8eJoGyZZOGfg8UAhGzIGNP3uheawhzNgx0AlHhizwQGUBqw1cc0qBzxoYYWsZ2DU0Q6oydlUwlgtDr29mtczHqd+KeCJoYSpn0GUTWHjZ1E2cyZ3Uf4pLXK48/d7P8mjMIN0D9s0CaI8ShPe7cBP7uFLlAQzIJ4W96GHTrsEjCncJKm6jC0jukI4qick01EpjqLkaLLusSao1R/SkhNBR7oVxt2oYcDK2TSiFRbt5debXK6RN5nM5/ChFbVGS1B0Ez/Owz3k/iYO3aW798TLDwJOEhdfExiwacj+bMlihRbhc5YmG0jSHJIijiEId34R53Dz+Pdmfe0eqEH+xz/Yp9+eG0Q7CH9EWZ69brWe/AMEGrqlAwMAAA==\"")
+	packr.PackJSONBytes("./sql", "20201110120000-users-create-time.sql", "\"H4sIAAAAAAACA32SQY/aMBCF7/kVT5y2WyCIY/eUQlaNukoqErq7J2SSIVhN7NR2Gvj3HUOqgir1ZNnz/OZ7Y4ePAR6x0t3ZyProsFwsFyiOhFT8EK1A1LujNpZFXvciS1KWKvSqIgPHuqgTJS9jZYrvZKzUCsv5Ag9eMBlLkw9P3uKse7TiDKUdekvsIS0OsiHQqaTOQSqUuu0aKVRJGKQ7XvqMLnPv8T566L0TLBd8oePd4VYI4Uboo3PdpzAchmEuLrBzbeqwucps+JKs4jSPZww8XtiqhqyFoZ+9NBx2f4boGKgUe8ZsxABtIGpDXHPaAw9GOqnqKaw+uEEY8jaVtM7Ife/u5vUHj1PfCnhiQmES5UjyCT5HeZJPvclrUnzJtgVeo80mSoskzpFtsMrSdVIkWcq7Z0TpO74m6XoK4mlxHzp1xidgTOknSdVlbDnRHcJBX5FsR6U8yJKjqboXNaHWv8goToSOTCutf1HLgJW3aWQrnXCXo39y+UZhEMxm+NjK2ghH2HbBahNHRQxmjN+QPCPNCsRvSV7k/g8YuysNsXTnZEs7WZ2QpdcCHm4q/IHujNd6UMF6k337a/w/06fgN53rMDPtAgAA\"")
+	packr.PackJSONBytes("./sql", "20201120120000-user-wallet-balance.sql", "\"H4sIAAAAAAAC/4VUYW/TMBD9nl9xmpBoIW3HpEmwgVCWuJtFSVCSMiYElZu6qVnqBNshrRD8ds5JCgyGiCpVsd+9e3fvLpNHDjwCv6z2SuQbAyfHJ8eQbjiE7JZtGXi12ZRKI8jiZiLjUvMV1HLFFRjEeRXL8K+/ceEtV1qUEk7GxzCwgKP+6mh4bin2ZQ1btgdZGqg1Rw6hYS0KDnyX8cqAkJCV26oQTGYcGmE2bZ6eZWw5bnqOcmkYwhkGVPi2/h0IzPSiN8ZUZ5NJ0zRj1oodlyqfFB1MT2bUJ2FCRii4D5jLgmsNin+uhcJil3tgFQrK2BJlFqyBUgHLFcc7U1rBjRJGyNwFXa5NwxS3NCuhjRLL2tzp10EeVv07ADvGJBx5CdDkCC68hCauJbmm6VU0T+Hai2MvTClJIIrBj8KApjQK8W0KXngDr2gYuMCxW5iH7yplK0CZwnaSr9q2JZzfkbAuO0m64plYiwxLk3nNcg55+YUriRVBxdVWaOuoRoErS1OIrTDMtEd/1WUTTRxnNILHW5ErZjjMK8ePiZcSSL2LGQE6hTBKgbyjSZrYGVCLhhUFN4slK1rTBw7g8yamr70YSyM3MGhhYuVCVivFZbYfui1oGsWEXoZ3QEOIyZTEJPRJx69hYE+jEAIyIyjE9xLfC4jrtBx9GMB8TgM4PFZjOJ/NujyHtPDWi/0rLx6cnJ4O/8Ac5MMFvaRh+gePg/Pf9wG9Iu/+34fFIenPg14p/na2mnt7dwhyD3rcQ4EowBpD0bMdzghOn3W4i9c4u7eiquwJk3v4woqaa3SWGcB5btdVSMNztLvn1WOH4uLEKdaTRveL+cu2n6qGbcsS9MNPwSJuOV62ac/OugZ2BsfR685EFz7pUi4XHHd4YfjODLpsHdP1FToOBZe52QyQawjPXwCahNsRdLTwHR5+HL18fzx69uHrE/fJ028PHrah2ElcqCl+CNL7Bg2CyBp1RcPL87uTHZSNdII4evNrsv/t5rnzAwuF8WRsBQAA\"")
+	packr.PackJSONBytes("./sql", "20201125120000-user-session-refresh.sql", "\"H4sIAAAAAAAC/31TwXKbMBC98xU7vthJHTuTYzM9YCM3tBgygJuml4yMF6wJICrJJp5O/70rTNI47UQXhPT27dvdp+m5A+cwl81BiWJr4Ory6hLSLULIH3nFwd2ZrVSaQBYXiAxrjRvY1RtUYAjnNjyjT38zhm+otJA1XE0uYWQBg/5qcHZtKQ5yBxU/QC0N7DQSh9CQixIBnzJsDIgaMlk1peB1htAKs+3y9CwTy3Hfc8i14QTnFNDQX/4aCNz0orfGNB+n07ZtJ7wTO5GqmJZHmJ4G/pyFCbsgwX3Aqi5Ra1D4cycUFbs+AG9IUMbXJLPkLUgFvFBId0Zawa0SRtTFGLTMTcsVWpqN0EaJ9c6c9OtZHlX9GkAd4zUM3AT8ZAAzN/GTsSW589ObaJXCnRvHbpj6LIEohnkUen7qRyH9LcAN7+GrH3pjQOoW5cGnRtkKSKawncRN17YE8URCLo+SdIOZyEVGpdXFjhcIhdyjqqkiaFBVQtuJahK4sTSlqIThpjv6py6baOo4FxfwoRKF4gZh1TjzmLkpg9SdBQz8BYRRCuy7n6SJ9YB6oDnYFA8KcxK+hZEDtG5jf+nGVBu7h1GHE5sx5LwS5YG2Z+MOtYhi5n8OT1BnELMFi1k4Z8cMGkb2NArBYwEjKXM3mbseGzsdRx9mt7Ba+R70y+oMV0FwzPSS+V2UkY9Y92T/RQE1J6rLQ9e2kjqkDTwX3gVbR9m7Y77O6Wu0Gu0Yae051dOvL0kUzvq9xxbuKkhh+Ov38I0ohXtiPhY4i6KAueFJSM5LMsRpDLlIqMODERVC6i9ZkrrL2/THC8qhB20HHZ9o70QL1NCiQiAf7oXcaSrjsX/cttegjVRktInTj2MRR8vnQ7i7odHRmy5LzKzN4BMM3zhkeH3qMU+2tePF0e1fj73jr2vnD+uLWwv3BAAA\"")
 }
@@ -0,0 +1,36 @@
+/*
+ * Copyright 2020 The Nakama Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS user_wallet_balance (
+    PRIMARY KEY (user_id, currency),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+
+    user_id  UUID         NOT NULL,
+    currency VARCHAR(255) NOT NULL,
+    balance  BIGINT       NOT NULL
+);
+CREATE INDEX IF NOT EXISTS user_wallet_balance_currency_balance_user_id_idx ON user_wallet_balance (currency, balance, user_id);
+
+-- Index existing wallets, skipping any values that are not integer balances.
+INSERT INTO user_wallet_balance (user_id, currency, balance)
+    SELECT id, key, value::BIGINT
+    FROM users, jsonb_each_text(wallet)
+    WHERE length(key) <= 255 AND value ~ '^-?[0-9]{1,18}$'
+    ON CONFLICT (user_id, currency) DO NOTHING;
+
+-- +migrate Down
+DROP TABLE IF EXISTS user_wallet_balance;
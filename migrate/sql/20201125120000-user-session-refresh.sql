@@ -0,0 +1,34 @@
+/*
+ * Copyright 2020 The Nakama Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS user_session_refresh (
+    PRIMARY KEY (user_id, family_id),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+
+    user_id     UUID        NOT NULL,
+    family_id   UUID        NOT NULL,
+    token_id    UUID        NOT NULL, -- Only the latest refresh token in the family may be used.
+    vars        JSONB       DEFAULT '{}' NOT NULL,
+    revoked     BOOLEAN     DEFAULT false NOT NULL,
+    expiry_time TIMESTAMPTZ NOT NULL
+);
+
+-- Refresh token families were previously kept in user storage.
+DELETE FROM storage WHERE collection = 'session_refresh';
+
+-- +migrate Down
+DROP TABLE IF EXISTS user_session_refresh;
@@ -41,6 +41,7 @@ type SessionTokenClaims struct {
 	Username  string            `json:"usn,omitempty"`
 	Vars      map[string]string `json:"vrs,omitempty"`
 	ExpiresAt int64             `json:"exp,omitempty"`
+	IssuedAt  int64             `json:"iat,omitempty"`
 }
 
 func (stc *SessionTokenClaims) Valid() error {
@@ -639,7 +640,7 @@ func (s *ApiServer) AuthenticateSteam(ctx context.Context, in *api.AuthenticateS
 
 	create := in.Create == nil || in.Create.Value
 
-	dbUserID, dbUsername, created, err := AuthenticateSteam(ctx, s.logger, s.db, s.socialClient, s.config.GetSocial().Steam.AppID, s.config.GetSocial().Steam.PublisherKey, in.Account.Token, username, create)
+	dbUserID, dbUsername, created, err := AuthenticateSteam(ctx, s.logger, s.db, s.socialClient, s.config.GetSocial().Steam, in.Account.Token, username, create)
 	if err != nil {
 		return nil, err
 	}
@@ -671,6 +672,7 @@ func generateTokenWithExpiry(config Config, userID, username string, vars map[st
 		Username:  username,
 		Vars:      vars,
 		ExpiresAt: exp,
+		IssuedAt:  time.Now().UTC().Unix(),
 	})
 	signedToken, _ := token.SignedString([]byte(config.GetSession().EncryptionKey))
 	return signedToken, exp
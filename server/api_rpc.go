@@ -51,7 +51,7 @@ func (s *ApiServer) RpcFuncHttp(w http.ResponseWriter, r *http.Request) {
 	var vars map[string]string
 	var expiry int64
 	if auth := r.Header["Authorization"]; len(auth) >= 1 {
-		userID, username, vars, expiry, tokenAuth = parseBearerAuth([]byte(s.config.GetSession().EncryptionKey), auth[0])
+		userID, username, vars, expiry, tokenAuth = parseBearerAuth([]byte(s.config.GetSession().EncryptionKey), s.sessionCache, auth[0])
 		if !tokenAuth {
 			// Auth token not valid or expired.
 			w.Header().Set("content-type", "application/json")
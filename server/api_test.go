@@ -81,6 +81,7 @@ func (d *DummySession) SetUsername(string) {}
 func (d *DummySession) Vars() map[string]string {
 	return nil
 }
+func (d *DummySession) SetVars(map[string]string) {}
 func (d *DummySession) Expiry() int64 {
 	return int64(0)
 }
@@ -94,6 +95,9 @@ func (d *DummySession) ClientIP() string {
 func (d *DummySession) ClientPort() string {
 	return ""
 }
+func (d *DummySession) CreateTime() int64 {
+	return 0
+}
 func (d *DummySession) Context() context.Context {
 	return context.Background()
 }
@@ -167,7 +171,7 @@ func NewAPIServer(t *testing.T, runtime *Runtime) (*ApiServer, *Pipeline) {
 	router := &DummyMessageRouter{}
 	tracker := &LocalTracker{}
 	pipeline := NewPipeline(logger, cfg, db, jsonpbMarshaler, jsonpbUnmarshaler, nil, nil, nil, tracker, router, runtime)
-	apiServer := StartApiServer(logger, logger, db, jsonpbMarshaler, jsonpbUnmarshaler, cfg, nil, nil, nil, nil, nil, nil, tracker, router, metrics, pipeline, runtime)
+	apiServer := StartApiServer(logger, logger, db, jsonpbMarshaler, jsonpbUnmarshaler, cfg, nil, nil, nil, nil, NewLocalSessionCache(cfg), nil, nil, tracker, router, metrics, pipeline, runtime)
 	return apiServer, pipeline
 }
 
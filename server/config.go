@@ -124,6 +124,15 @@ func CheckConfig(logger *zap.Logger, config Config) map[string]string {
 	if config.GetSession().EncryptionKey == "" {
 		logger.Fatal("Encryption key must be set", zap.String("param", "session.encryption_key"))
 	}
+	if config.GetSession().RefreshEncryptionKey == "" {
+		logger.Fatal("Refresh token encryption key must be set", zap.String("param", "session.refresh_encryption_key"))
+	}
+	if config.GetSession().RefreshEncryptionKey == config.GetSession().EncryptionKey {
+		logger.Fatal("Refresh token encryption key must differ from the session encryption key", zap.String("param", "session.refresh_encryption_key"))
+	}
+	if config.GetSession().RefreshTokenExpirySec < 1 {
+		logger.Fatal("Refresh token expiry seconds must be >= 1", zap.Int64("session.refresh_token_expiry_sec", config.GetSession().RefreshTokenExpirySec))
+	}
 	if config.GetRuntime().HTTPKey == "" {
 		logger.Fatal("Runtime HTTP key must be set", zap.String("param", "runtime.http_key"))
 	}
@@ -181,6 +190,16 @@ func CheckConfig(logger *zap.Logger, config Config) map[string]string {
 			logger.Fatal("Bad database connection URL", zap.String("database.address", address), zap.Error(err))
 		}
 	}
+	if config.GetSocial().Push.Enabled {
+		if config.GetSocial().Push.TokenCollection == "" {
+			logger.Fatal("Push token collection must be set when push delivery is enabled", zap.String("social.push.token_collection", config.GetSocial().Push.TokenCollection))
+		}
+		if config.GetSocial().Push.ApnsKey != "" {
+			if _, err := PushParseApnsKey(config.GetSocial().Push.ApnsKey); err != nil {
+				logger.Fatal("Invalid push configuration", zap.Error(err))
+			}
+		}
+	}
 	if config.GetRuntime().MinCount < 0 {
 		logger.Fatal("Minimum runtime instance count must be >= 0", zap.Int("runtime.min_count", config.GetRuntime().MinCount))
 	}
@@ -199,9 +218,36 @@ func CheckConfig(logger *zap.Logger, config Config) map[string]string {
 	if config.GetRuntime().EventQueueWorkers < 1 {
 		logger.Fatal("Runtime event queue workers must be >= 1", zap.Int("runtime.event_queue_workers", config.GetRuntime().EventQueueWorkers))
 	}
+	if config.GetRuntime().HTTPMaxIdleConns < 0 {
+		logger.Fatal("Runtime HTTP max idle connections must be >= 0", zap.Int("runtime.http_max_idle_conns", config.GetRuntime().HTTPMaxIdleConns))
+	}
+	if config.GetRuntime().HTTPMaxIdleConnsPerHost < 1 {
+		logger.Fatal("Runtime HTTP max idle connections per host must be >= 1", zap.Int("runtime.http_max_idle_conns_per_host", config.GetRuntime().HTTPMaxIdleConnsPerHost))
+	}
+	if config.GetRuntime().HTTPIdleConnTimeoutMs < 0 {
+		logger.Fatal("Runtime HTTP idle connection timeout must be >= 0", zap.Int("runtime.http_idle_conn_timeout_ms", config.GetRuntime().HTTPIdleConnTimeoutMs))
+	}
+	if config.GetRuntime().HTTPBreakerFailures < 0 {
+		logger.Fatal("Runtime HTTP breaker failures must be >= 0", zap.Int("runtime.http_breaker_failures", config.GetRuntime().HTTPBreakerFailures))
+	}
+	if config.GetRuntime().HTTPBreakerOpenMs < 1 {
+		logger.Fatal("Runtime HTTP breaker open time must be >= 1", zap.Int("runtime.http_breaker_open_ms", config.GetRuntime().HTTPBreakerOpenMs))
+	}
+	if config.GetRuntime().InstructionLimit < 0 {
+		logger.Fatal("Runtime instruction limit must be >= 0", zap.Int("runtime.instruction_limit", config.GetRuntime().InstructionLimit))
+	}
+	if config.GetRuntime().StorageMaxObjectBytes < 0 {
+		logger.Fatal("Runtime storage max object bytes must be >= 0", zap.Int("runtime.storage_max_object_bytes", config.GetRuntime().StorageMaxObjectBytes))
+	}
 	if config.GetRuntime().RegistrySize < 128 {
 		logger.Fatal("Runtime instance registry size must be >= 128", zap.Int("runtime.registry_size", config.GetRuntime().RegistrySize))
 	}
+	if config.GetRuntime().SlowQueryMs < 0 {
+		logger.Fatal("Runtime slow query threshold must be >= 0", zap.Int("runtime.slow_query_ms", config.GetRuntime().SlowQueryMs))
+	}
+	if _, err := ParseFeatureFlags(config.GetRuntime().FeatureFlags); err != nil {
+		logger.Fatal("Invalid runtime feature flags", zap.Strings("runtime.feature_flags", config.GetRuntime().FeatureFlags), zap.Error(err))
+	}
 	if config.GetMatch().InputQueueSize < 1 {
 		logger.Fatal("Match input queue size must be >= 1", zap.Int("match.input_queue_size", config.GetMatch().InputQueueSize))
 	}
@@ -217,6 +263,21 @@ func CheckConfig(logger *zap.Logger, config Config) map[string]string {
 	if config.GetMatch().JoinMarkerDeadlineMs < 1 {
 		logger.Fatal("Match join marker deadline must be >= 1", zap.Int("match.join_marker_deadline_ms", config.GetMatch().JoinMarkerDeadlineMs))
 	}
+	if config.GetMatch().SignalTimeoutMs < 1 {
+		logger.Fatal("Match signal timeout must be >= 1", zap.Int("match.signal_timeout_ms", config.GetMatch().SignalTimeoutMs))
+	}
+	if config.GetMatch().ReservationTimeoutMs < 1 {
+		logger.Fatal("Match reservation timeout must be >= 1", zap.Int("match.reservation_timeout_ms", config.GetMatch().ReservationTimeoutMs))
+	}
+	if config.GetMatch().MaxPendingReceipts < 1 {
+		logger.Fatal("Match max pending receipts must be >= 1", zap.Int("match.max_pending_receipts", config.GetMatch().MaxPendingReceipts))
+	}
+	if config.GetMatch().ReceiptTimeoutMs < 1 {
+		logger.Fatal("Match receipt timeout must be >= 1", zap.Int("match.receipt_timeout_ms", config.GetMatch().ReceiptTimeoutMs))
+	}
+	if config.GetMatch().DedupWindow < 1 {
+		logger.Fatal("Match dedup window must be >= 1", zap.Int("match.dedup_window", config.GetMatch().DedupWindow))
+	}
 	if config.GetMatch().MaxEmptySec < 0 {
 		logger.Fatal("Match max idle seconds must be >= 0", zap.Int("match.max_empty_sec", config.GetMatch().MaxEmptySec))
 	}
@@ -258,6 +319,10 @@ func CheckConfig(logger *zap.Logger, config Config) map[string]string {
 		logger.Warn("WARNING: insecure default parameter value, change this for production!", zap.String("param", "session.encryption_key"))
 		configWarnings["session.encryption_key"] = "Insecure default parameter value, change this for production!"
 	}
+	if config.GetSession().RefreshEncryptionKey == "defaultrefreshencryptionkey" {
+		logger.Warn("WARNING: insecure default parameter value, change this for production!", zap.String("param", "session.refresh_encryption_key"))
+		configWarnings["session.refresh_encryption_key"] = "Insecure default parameter value, change this for production!"
+	}
 	if config.GetRuntime().HTTPKey == "defaulthttpkey" {
 		logger.Warn("WARNING: insecure default parameter value, change this for production!", zap.String("param", "runtime.http_key"))
 		configWarnings["runtime.http_key"] = "Insecure default parameter value, change this for production!"
@@ -406,6 +471,8 @@ func (c *config) Clone() (Config, error) {
 	copy(nc.Database.Addresses, c.Database.Addresses)
 	nc.Runtime.Env = make([]string, len(c.Runtime.Env))
 	copy(nc.Runtime.Env, c.Runtime.Env)
+	nc.Runtime.FeatureFlags = make([]string, len(c.Runtime.FeatureFlags))
+	copy(nc.Runtime.FeatureFlags, c.Runtime.FeatureFlags)
 	nc.Runtime.Environment = make(map[string]string, len(c.Runtime.Environment))
 	for k, v := range c.Runtime.Environment {
 		nc.Runtime.Environment[k] = v
@@ -523,6 +590,9 @@ func NewMetricsConfig() *MetricsConfig {
 type SessionConfig struct {
 	EncryptionKey  string `yaml:"encryption_key" json:"encryption_key" usage:"The encryption key used to produce the client token."`
 	TokenExpirySec int64  `yaml:"token_expiry_sec" json:"token_expiry_sec" usage:"Token expiry in seconds."`
+
+	RefreshEncryptionKey  string `yaml:"refresh_encryption_key" json:"refresh_encryption_key" usage:"The encryption key used to produce refresh tokens issued by the runtime."`
+	RefreshTokenExpirySec int64  `yaml:"refresh_token_expiry_sec" json:"refresh_token_expiry_sec" usage:"Refresh token expiry in seconds. Default 3600."`
 }
 
 // NewSessionConfig creates a new SessionConfig struct.
@@ -530,6 +600,9 @@ func NewSessionConfig() *SessionConfig {
 	return &SessionConfig{
 		EncryptionKey:  "defaultencryptionkey",
 		TokenExpirySec: 60,
+
+		RefreshEncryptionKey:  "defaultrefreshencryptionkey",
+		RefreshTokenExpirySec: 3600,
 	}
 }
 
@@ -605,12 +678,14 @@ type SocialConfig struct {
 	Steam               *SocialConfigSteam               `yaml:"steam" json:"steam" usage:"Steam configuration."`
 	FacebookInstantGame *SocialConfigFacebookInstantGame `yaml:"facebook_instant_game" json:"facebook_instant_game" usage:"Facebook Instant Game configuration"`
 	Apple               *SocialConfigApple               `yaml:"apple" json:"apple" usage:"Apple Sign In configuration."`
+	Push                *SocialConfigPush                `yaml:"push" json:"push" usage:"Push notification delivery configuration."`
 }
 
 // SocialConfigSteam is configuration relevant to Steam.
 type SocialConfigSteam struct {
-	PublisherKey string `yaml:"publisher_key" json:"publisher_key" usage:"Steam Publisher Key value."`
-	AppID        int    `yaml:"app_id" json:"app_id" usage:"Steam App ID."`
+	PublisherKey      string `yaml:"publisher_key" json:"publisher_key" usage:"Steam Publisher Key value."`
+	AppID             int    `yaml:"app_id" json:"app_id" usage:"Steam App ID."`
+	CheckAppOwnership bool   `yaml:"check_app_ownership" json:"check_app_ownership" usage:"Reject Steam authentication and linking for users who do not own the configured app. Default false."`
 }
 
 // SocialConfigFacebookInstantGame is configuration relevant to Facebook Instant Games.
@@ -623,12 +698,25 @@ type SocialConfigApple struct {
 	BundleId string `yaml:"bundle_id" json:"bundle_id" usage:"Apple Sign In bundle ID."`
 }
 
+// SocialConfigPush is configuration relevant to delivering persistent notifications to offline users' devices.
+type SocialConfigPush struct {
+	Enabled         bool   `yaml:"enabled" json:"enabled" usage:"Forward persistent runtime notifications to the registered devices of offline users. Default false."`
+	TokenCollection string `yaml:"token_collection" json:"token_collection" usage:"Storage collection holding each user's device tokens under the 'tokens' key, as an object with 'fcm' and 'apns' token lists. Default 'push'."`
+	FcmServerKey    string `yaml:"fcm_server_key" json:"fcm_server_key" usage:"Firebase Cloud Messaging server key. FCM delivery is disabled if empty."`
+	ApnsKey         string `yaml:"apns_key" json:"apns_key" usage:"Apple Push Notification service PEM encoded authentication key. APNs delivery is disabled if empty."`
+	ApnsKeyId       string `yaml:"apns_key_id" json:"apns_key_id" usage:"Apple Push Notification service authentication key ID."`
+	ApnsTeamId      string `yaml:"apns_team_id" json:"apns_team_id" usage:"Apple developer team ID."`
+	ApnsTopic       string `yaml:"apns_topic" json:"apns_topic" usage:"Apple Push Notification service topic, usually the app bundle ID."`
+	ApnsProduction  bool   `yaml:"apns_production" json:"apns_production" usage:"Use the production Apple Push Notification service environment rather than development. Default false."`
+}
+
 // NewSocialConfig creates a new SocialConfig struct.
 func NewSocialConfig() *SocialConfig {
 	return &SocialConfig{
 		Steam: &SocialConfigSteam{
-			PublisherKey: "",
-			AppID:        0,
+			PublisherKey:      "",
+			AppID:             0,
+			CheckAppOwnership: false,
 		},
 		FacebookInstantGame: &SocialConfigFacebookInstantGame{
 			AppSecret: "",
@@ -636,49 +724,85 @@ func NewSocialConfig() *SocialConfig {
 		Apple: &SocialConfigApple{
 			BundleId: "",
 		},
+		Push: &SocialConfigPush{
+			Enabled:         false,
+			TokenCollection: "push",
+		},
 	}
 }
 
 // RuntimeConfig is configuration relevant to the Runtime Lua VM.
 type RuntimeConfig struct {
-	Environment       map[string]string `yaml:"-" json:"-"`
-	Env               []string          `yaml:"env" json:"env" usage:"Values to pass into Runtime as environment variables."`
-	Path              string            `yaml:"path" json:"path" usage:"Path for the server to scan for Lua and Go library files."`
-	HTTPKey           string            `yaml:"http_key" json:"http_key" usage:"Runtime HTTP Invocation key."`
-	MinCount          int               `yaml:"min_count" json:"min_count" usage:"Minimum number of runtime instances to allocate. Default 16."`
-	MaxCount          int               `yaml:"max_count" json:"max_count" usage:"Maximum number of runtime instances to allocate. Default 48."`
-	CallStackSize     int               `yaml:"call_stack_size" json:"call_stack_size" usage:"Size of each runtime instance's call stack. Default 128."`
-	RegistrySize      int               `yaml:"registry_size" json:"registry_size" usage:"Size of each runtime instance's registry. Default 512."`
-	EventQueueSize    int               `yaml:"event_queue_size" json:"event_queue_size" usage:"Size of the event queue buffer. Default 65536."`
-	EventQueueWorkers int               `yaml:"event_queue_workers" json:"event_queue_workers" usage:"Number of workers to use for concurrent processing of events. Default 8."`
-	ReadOnlyGlobals   bool              `yaml:"read_only_globals" json:"read_only_globals" usage:"When enabled marks all Lua runtime global tables as read-only to reduce memory footprint. Default true."`
+	Environment             map[string]string `yaml:"-" json:"-"`
+	Env                     []string          `yaml:"env" json:"env" usage:"Values to pass into Runtime as environment variables."`
+	Path                    string            `yaml:"path" json:"path" usage:"Path for the server to scan for Lua and Go library files."`
+	HTTPKey                 string            `yaml:"http_key" json:"http_key" usage:"Runtime HTTP Invocation key."`
+	MinCount                int               `yaml:"min_count" json:"min_count" usage:"Minimum number of runtime instances to allocate. Default 16."`
+	MaxCount                int               `yaml:"max_count" json:"max_count" usage:"Maximum number of runtime instances to allocate. Default 48."`
+	CallStackSize           int               `yaml:"call_stack_size" json:"call_stack_size" usage:"Size of each runtime instance's call stack. Default 128."`
+	RegistrySize            int               `yaml:"registry_size" json:"registry_size" usage:"Size of each runtime instance's registry. Default 512."`
+	EventQueueSize          int               `yaml:"event_queue_size" json:"event_queue_size" usage:"Size of the event queue buffer. Default 65536."`
+	EventQueueWorkers       int               `yaml:"event_queue_workers" json:"event_queue_workers" usage:"Number of workers to use for concurrent processing of events. Default 8."`
+	ReadOnlyGlobals         bool              `yaml:"read_only_globals" json:"read_only_globals" usage:"When enabled marks all Lua runtime global tables as read-only to reduce memory footprint. Default true."`
+	SlowQueryMs             int               `yaml:"slow_query_ms" json:"slow_query_ms" usage:"Duration in milliseconds after which runtime issued SQL queries are logged as slow and counted in metrics. 0 disables slow query logging. Default 0."`
+	WalletUpdateEvents      bool              `yaml:"wallet_update_events" json:"wallet_update_events" usage:"Emit a 'wallet_update' event with the changeset and resulting balances for each runtime wallet update. Default false."`
+	FeatureFlags            []string          `yaml:"feature_flags" json:"feature_flags" usage:"Feature flags and their variants users are bucketed into, each in the form 'flag=variant1,variant2'."`
+	HTTPMaxIdleConns        int               `yaml:"http_max_idle_conns" json:"http_max_idle_conns" usage:"Maximum number of idle connections kept open across all hosts by the runtime HTTP client. 0 means no limit. Default 100."`
+	HTTPMaxIdleConnsPerHost int               `yaml:"http_max_idle_conns_per_host" json:"http_max_idle_conns_per_host" usage:"Maximum number of idle connections kept open to each host by the runtime HTTP client. Default 16."`
+	HTTPIdleConnTimeoutMs   int               `yaml:"http_idle_conn_timeout_ms" json:"http_idle_conn_timeout_ms" usage:"Time in milliseconds an idle runtime HTTP client connection is kept open before being closed. 0 means no limit. Default 90000."`
+	HTTPKeepAliveMs         int               `yaml:"http_keep_alive_ms" json:"http_keep_alive_ms" usage:"Interval in milliseconds between TCP keep-alive probes on runtime HTTP client connections. Negative values disable keep-alive probes. Default 30000."`
+	HTTPBreakerFailures     int               `yaml:"http_breaker_failures" json:"http_breaker_failures" usage:"Number of consecutive failed runtime HTTP requests to a host, by error or 5xx response, after which requests to it fail fast. 0 disables the circuit breaker. Default 5."`
+	HTTPBreakerOpenMs       int               `yaml:"http_breaker_open_ms" json:"http_breaker_open_ms" usage:"Time in milliseconds runtime HTTP requests to a failing host fail fast before a probe request is allowed through. Default 30000."`
+	StorageMaxObjectBytes   int               `yaml:"storage_max_object_bytes" json:"storage_max_object_bytes" usage:"Maximum size in bytes of each storage object value written by the runtime. 0 means no limit. Default 0."`
+	InstructionLimit        int               `yaml:"instruction_limit" json:"instruction_limit" usage:"Maximum number of instructions a single Lua runtime function or match handler call may execute before it's stopped with an error. 0 means no limit. Default 0."`
 }
 
 // NewRuntimeConfig creates a new RuntimeConfig struct.
 func NewRuntimeConfig() *RuntimeConfig {
 	return &RuntimeConfig{
-		Environment:       make(map[string]string, 0),
-		Env:               make([]string, 0),
-		Path:              "",
-		HTTPKey:           "defaulthttpkey",
-		MinCount:          16,
-		MaxCount:          48,
-		CallStackSize:     128,
-		RegistrySize:      512,
-		EventQueueSize:    65536,
-		EventQueueWorkers: 8,
-		ReadOnlyGlobals:   true,
+		Environment:             make(map[string]string, 0),
+		Env:                     make([]string, 0),
+		Path:                    "",
+		HTTPKey:                 "defaulthttpkey",
+		MinCount:                16,
+		MaxCount:                48,
+		CallStackSize:           128,
+		RegistrySize:            512,
+		EventQueueSize:          65536,
+		EventQueueWorkers:       8,
+		ReadOnlyGlobals:         true,
+		SlowQueryMs:             0,
+		WalletUpdateEvents:      false,
+		FeatureFlags:            make([]string, 0),
+		HTTPMaxIdleConns:        100,
+		HTTPMaxIdleConnsPerHost: 16,
+		HTTPIdleConnTimeoutMs:   90000,
+		HTTPKeepAliveMs:         30000,
+		HTTPBreakerFailures:     5,
+		HTTPBreakerOpenMs:       30000,
+		StorageMaxObjectBytes:   0,
+		InstructionLimit:        0,
 	}
 }
 
 // MatchConfig is configuration relevant to authoritative realtime multiplayer matches.
 type MatchConfig struct {
-	InputQueueSize       int `yaml:"input_queue_size" json:"input_queue_size" usage:"Size of the authoritative match buffer that stores client messages until they can be processed by the next tick. Default 128."`
-	CallQueueSize        int `yaml:"call_queue_size" json:"call_queue_size" usage:"Size of the authoritative match buffer that sequences calls to match handler callbacks to ensure no overlaps. Default 128."`
-	JoinAttemptQueueSize int `yaml:"join_attempt_queue_size" json:"join_attempt_queue_size" usage:"Size of the authoritative match buffer that limits the number of in-progress join attempts. Default 128."`
-	DeferredQueueSize    int `yaml:"deferred_queue_size" json:"deferred_queue_size" usage:"Size of the authoritative match buffer that holds deferred message broadcasts until the end of each loop execution. Default 128."`
-	JoinMarkerDeadlineMs int `yaml:"join_marker_deadline_ms" json:"join_marker_deadline_ms" usage:"Deadline in milliseconds that client authoritative match joins will wait for match handlers to acknowledge joins. Default 15000."`
-	MaxEmptySec          int `yaml:"max_empty_sec" json:"max_empty_sec" usage:"Maximum number of consecutive seconds that authoritative matches are allowed to be empty before they are stopped. 0 indicates no maximum. Default 0."`
+	InputQueueSize       int    `yaml:"input_queue_size" json:"input_queue_size" usage:"Size of the authoritative match buffer that stores client messages until they can be processed by the next tick. Default 128."`
+	CallQueueSize        int    `yaml:"call_queue_size" json:"call_queue_size" usage:"Size of the authoritative match buffer that sequences calls to match handler callbacks to ensure no overlaps. Default 128."`
+	JoinAttemptQueueSize int    `yaml:"join_attempt_queue_size" json:"join_attempt_queue_size" usage:"Size of the authoritative match buffer that limits the number of in-progress join attempts. Default 128."`
+	DeferredQueueSize    int    `yaml:"deferred_queue_size" json:"deferred_queue_size" usage:"Size of the authoritative match buffer that holds deferred message broadcasts until the end of each loop execution. Default 128."`
+	JoinMarkerDeadlineMs int    `yaml:"join_marker_deadline_ms" json:"join_marker_deadline_ms" usage:"Deadline in milliseconds that client authoritative match joins will wait for match handlers to acknowledge joins. Default 15000."`
+	MaxEmptySec          int    `yaml:"max_empty_sec" json:"max_empty_sec" usage:"Maximum number of consecutive seconds that authoritative matches are allowed to be empty before they are stopped. 0 indicates no maximum. Default 0."`
+	StrictInitState      bool   `yaml:"strict_init_state" json:"strict_init_state" usage:"When enabled authoritative match init handlers must return an initial state. When disabled an omitted state defaults to an empty object. Default true."`
+	KickAuditCollection  string `yaml:"kick_audit_collection" json:"kick_audit_collection" usage:"Storage collection to record a system-owned audit entry in whenever an authoritative match kicks a presence. Kicks are not written to storage if empty. Default ''."`
+	KickAuditLog         bool   `yaml:"kick_audit_log" json:"kick_audit_log" usage:"Log an audit entry whenever an authoritative match kicks a presence. Default false."`
+	SignalTimeoutMs      int    `yaml:"signal_timeout_ms" json:"signal_timeout_ms" usage:"Maximum time in milliseconds that match signal callers wait for authoritative match handlers to respond, unless the caller sets its own timeout. Default 10000."`
+	ReservationTimeoutMs int    `yaml:"reservation_timeout_ms" json:"reservation_timeout_ms" usage:"Time in milliseconds that places reserved for sessions when an authoritative match is created are held before other sessions may take them. Default 30000."`
+	ReceiptOpCode        int64  `yaml:"receipt_op_code" json:"receipt_op_code" usage:"Op code clients send match data with to acknowledge delivery receipts requested by authoritative match broadcasts, with the receipt ID as data. Only intercepted in matches that request receipts. Default -1."`
+	MaxPendingReceipts   int    `yaml:"max_pending_receipts" json:"max_pending_receipts" usage:"Maximum number of delivery receipts an authoritative match may wait on at once. Default 128."`
+	ReceiptTimeoutMs     int    `yaml:"receipt_timeout_ms" json:"receipt_timeout_ms" usage:"Time in milliseconds an authoritative match waits for all acknowledgements of a delivery receipt before the receipt expires and is reported to the match loop. Default 10000."`
+	DedupWindow          int    `yaml:"dedup_window" json:"dedup_window" usage:"Number of most recent client sequence numbers remembered per presence by authoritative matches that drop duplicate match data. Older sequence numbers are dropped as duplicates. Default 64."`
+	LabelUpdateEvents    bool   `yaml:"label_update_events" json:"label_update_events" usage:"Emit a 'match_label_update' event with the match ID and the previous and new label whenever an authoritative match changes its label. Default false."`
 }
 
 // NewMatchConfig creates a new MatchConfig struct.
@@ -690,6 +814,14 @@ func NewMatchConfig() *MatchConfig {
 		DeferredQueueSize:    128,
 		JoinMarkerDeadlineMs: 15000,
 		MaxEmptySec:          0,
+		StrictInitState:      true,
+		SignalTimeoutMs:      10000,
+		ReservationTimeoutMs: 30000,
+		ReceiptOpCode:        -1,
+		MaxPendingReceipts:   128,
+		ReceiptTimeoutMs:     10000,
+		DedupWindow:          64,
+		LabelUpdateEvents:    false,
 	}
 }
 
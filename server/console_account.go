@@ -332,8 +332,8 @@ func (s *ConsoleServer) UpdateAccount(ctx context.Context, in *console.UpdateAcc
 		newPassword = string(hashedPassword)
 	}
 
+	var walletMap map[string]int64
 	if v := in.Wallet; v != nil && v.Value != "" {
-		var walletMap map[string]int64
 		if err := json.Unmarshal([]byte(v.Value), &walletMap); err != nil {
 			return nil, status.Error(codes.InvalidArgument, "Wallet must be a valid JSON object with only string keys and integer values.")
 		}
@@ -417,6 +417,13 @@ AND (EXISTS (SELECT id FROM users WHERE id = $1 AND
 			}
 		}
 
+		if walletMap != nil {
+			if err := writeWalletBalances(ctx, tx, userID.String(), walletMap); err != nil {
+				s.logger.Error("Could not update user wallet balances.", zap.Error(err), zap.Any("input", in))
+				return err
+			}
+		}
+
 		if removeCustomID && removeEmail {
 			query := `UPDATE users SET custom_id = NULL, email = NULL, update_time = now()
 WHERE id = $1
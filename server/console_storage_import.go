@@ -180,7 +180,7 @@ func importStorageJSON(ctx context.Context, logger *zap.Logger, db *sql.DB, file
 		return nil
 	}
 
-	acks, _, err := StorageWriteObjects(ctx, logger, db, true, ops)
+	acks, _, err := StorageWriteObjects(ctx, logger, db, true, 0, ops)
 	if err != nil {
 		logger.Warn("Failed to write imported records.", zap.Error(err))
 		return errors.New("could not import records due to an internal error - please consult server logs")
@@ -280,7 +280,7 @@ func importStorageCSV(ctx context.Context, logger *zap.Logger, db *sql.DB, fileB
 		return nil
 	}
 
-	acks, _, err := StorageWriteObjects(ctx, logger, db, true, ops)
+	acks, _, err := StorageWriteObjects(ctx, logger, db, true, 0, ops)
 	if err != nil {
 		logger.Warn("Failed to write imported records.", zap.Error(err))
 		return errors.New("could not import records due to an internal error - please consult server logs")
@@ -15,10 +15,15 @@
 package server
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
+	"encoding/base64"
+	"encoding/gob"
 	"encoding/json"
 	"github.com/gofrs/uuid"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/ptypes/timestamp"
 	"github.com/golang/protobuf/ptypes/wrappers"
 	"github.com/heroiclabs/nakama-common/api"
@@ -29,8 +34,22 @@ import (
 	"go.uber.org/zap"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"io"
 	"strconv"
 	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// Maximum username length in bytes, as allowed by the database schema.
+	usernameMaxLength = 128
+
+	// Each section of an account export is read in pages, up to a bounded total.
+	accountExportPageSize = 100
+	accountExportMaxItems = 10000
+
+	// Account exports written to storage are held in memory until written as a single object, and never grow past this.
+	accountExportToStorageMaxBytes = 16 * 1024 * 1024
 )
 
 var ErrAccountNotFound = errors.New("account not found")
@@ -47,6 +66,19 @@ type accountUpdate struct {
 	metadata    *wrappers.StringValue
 }
 
+// ValidateUsername checks a username is between 1 and maxLength bytes and contains no spaces or control characters.
+func ValidateUsername(username string, maxLength int) bool {
+	if username == "" || len(username) > maxLength || !utf8.ValidString(username) {
+		return false
+	}
+	return !invalidCharsRegex.MatchString(username)
+}
+
+// NormalizeUsername returns the canonical form of a username, so usernames differing only in case compare equal.
+func NormalizeUsername(username string) string {
+	return strings.ToLower(username)
+}
+
 func GetAccount(ctx context.Context, logger *zap.Logger, db *sql.DB, tracker Tracker, userID uuid.UUID) (*api.Account, error) {
 	var displayName sql.NullString
 	var username sql.NullString
@@ -135,6 +167,34 @@ WHERE u.id = $1`
 	}, nil
 }
 
+// Look up the account with the given linked device ID. Returns ErrAccountNotFound if no account has it linked.
+func GetAccountByDevice(ctx context.Context, logger *zap.Logger, db *sql.DB, tracker Tracker, deviceID string) (*api.Account, error) {
+	return getAccountByIdentity(ctx, logger, db, tracker, "SELECT user_id FROM user_device WHERE id = $1", deviceID)
+}
+
+// Look up the account with the given email. Returns ErrAccountNotFound if no account has it linked.
+func GetAccountByEmail(ctx context.Context, logger *zap.Logger, db *sql.DB, tracker Tracker, email string) (*api.Account, error) {
+	// Emails are always stored in lowercase.
+	return getAccountByIdentity(ctx, logger, db, tracker, "SELECT id FROM users WHERE email = $1", strings.ToLower(email))
+}
+
+// Look up the account with the given custom ID. Returns ErrAccountNotFound if no account has it linked.
+func GetAccountByCustom(ctx context.Context, logger *zap.Logger, db *sql.DB, tracker Tracker, customID string) (*api.Account, error) {
+	return getAccountByIdentity(ctx, logger, db, tracker, "SELECT id FROM users WHERE custom_id = $1", customID)
+}
+
+func getAccountByIdentity(ctx context.Context, logger *zap.Logger, db *sql.DB, tracker Tracker, query string, identity string) (*api.Account, error) {
+	var userID uuid.UUID
+	if err := db.QueryRowContext(ctx, query, identity).Scan(&userID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrAccountNotFound
+		}
+		logger.Error("Error looking up user account by linked identity.", zap.Error(err))
+		return nil, err
+	}
+	return GetAccount(ctx, logger, db, tracker, userID)
+}
+
 func GetAccounts(ctx context.Context, logger *zap.Logger, db *sql.DB, tracker Tracker, userIDs []string) ([]*api.Account, error) {
 	statements := make([]string, 0, len(userIDs))
 	parameters := make([]interface{}, 0, len(userIDs))
@@ -337,6 +397,8 @@ func updateAccounts(ctx context.Context, logger *zap.Logger, tx *sql.Tx, updates
 			}
 		}
 
+		// Account metadata is stored separately from the wallet and its metadata. Account updates must never write the
+		// wallet columns, so wallet updates in the same transaction always see and change the latest balances.
 		if update.metadata != nil {
 			params = append(params, update.metadata.GetValue())
 			updateStatements = append(updateStatements, "metadata = $"+strconv.Itoa(len(params)))
@@ -380,94 +442,465 @@ func ExportAccount(ctx context.Context, logger *zap.Logger, db *sql.DB, userID u
 		return nil, status.Error(codes.Internal, "An error occurred while trying to export user data.")
 	}
 
+	export := &console.AccountExport{
+		Account:            account,
+		Objects:            make([]*api.StorageObject, 0),
+		Friends:            make([]*api.Friend, 0),
+		Messages:           make([]*api.ChannelMessage, 0),
+		Groups:             make([]*api.Group, 0),
+		LeaderboardRecords: make([]*api.LeaderboardRecord, 0),
+		Notifications:      make([]*api.Notification, 0),
+		WalletLedgers:      make([]*console.WalletLedger, 0),
+	}
+
 	// Friends.
-	friends, err := GetFriendIDs(ctx, logger, db, userID)
-	if err != nil {
+	if err := accountExportFriends(ctx, logger, db, userID, func(f *api.Friend) error {
+		export.Friends = append(export.Friends, f)
+		return nil
+	}); err != nil {
 		logger.Error("Could not fetch friend IDs", zap.Error(err), zap.String("user_id", userID.String()))
 		return nil, status.Error(codes.Internal, "An error occurred while trying to export user data.")
 	}
 
 	// Messages.
-	messages, err := GetChannelMessages(ctx, logger, db, userID)
-	if err != nil {
+	if err := accountExportMessages(ctx, logger, db, userID, func(m *api.ChannelMessage) error {
+		export.Messages = append(export.Messages, m)
+		return nil
+	}); err != nil {
 		logger.Error("Could not fetch messages", zap.Error(err), zap.String("user_id", userID.String()))
 		return nil, status.Error(codes.Internal, "An error occurred while trying to export user data.")
 	}
 
 	// Leaderboard records.
-	leaderboardRecords, err := LeaderboardRecordReadAll(ctx, logger, db, userID)
-	if err != nil {
+	if err := accountExportLeaderboardRecords(ctx, logger, db, userID, func(r *api.LeaderboardRecord) error {
+		export.LeaderboardRecords = append(export.LeaderboardRecords, r)
+		return nil
+	}); err != nil {
 		logger.Error("Could not fetch leaderboard records", zap.Error(err), zap.String("user_id", userID.String()))
 		return nil, status.Error(codes.Internal, "An error occurred while trying to export user data.")
 	}
 
-	groups := make([]*api.Group, 0)
-	groupUsers, err := ListUserGroups(ctx, logger, db, userID, 0, nil, "")
-	if err != nil {
+	// Groups.
+	if err := accountExportGroups(ctx, logger, db, userID, func(g *api.Group) error {
+		export.Groups = append(export.Groups, g)
+		return nil
+	}); err != nil {
 		logger.Error("Could not fetch groups that belong to the user", zap.Error(err), zap.String("user_id", userID.String()))
 		return nil, status.Error(codes.Internal, "An error occurred while trying to export user data.")
 	}
-	for _, g := range groupUsers.UserGroups {
-		groups = append(groups, g.Group)
-	}
 
 	// Notifications.
-	notifications, err := NotificationList(ctx, logger, db, userID, 0, "", nil)
-	if err != nil {
+	if err := accountExportNotifications(ctx, logger, db, userID, func(n *api.Notification) error {
+		export.Notifications = append(export.Notifications, n)
+		return nil
+	}); err != nil {
 		logger.Error("Could not fetch notifications", zap.Error(err), zap.String("user_id", userID.String()))
 		return nil, status.Error(codes.Internal, "An error occurred while trying to export user data.")
 	}
 
 	// Storage objects where user is the owner.
-	storageObjects, err := StorageReadAllUserObjects(ctx, logger, db, userID)
-	if err != nil {
-		logger.Error("Could not fetch notifications", zap.Error(err), zap.String("user_id", userID.String()))
+	if err := accountExportStorageObjects(ctx, logger, db, userID, func(o *api.StorageObject) error {
+		export.Objects = append(export.Objects, o)
+		return nil
+	}); err != nil {
+		logger.Error("Could not fetch storage objects", zap.Error(err), zap.String("user_id", userID.String()))
 		return nil, status.Error(codes.Internal, "An error occurred while trying to export user data.")
 	}
 
 	// History of user's wallet.
-	walletLedgers, _, err := ListWalletLedger(ctx, logger, db, userID, nil, "")
-	if err != nil {
-		logger.Error("Could not fetch wallet ledger items", zap.Error(err), zap.String("user_id", userID.String()))
-		return nil, status.Error(codes.Internal, "An error occurred while trying to export user data.")
+	if err := accountExportWalletLedgers(ctx, logger, db, userID, func(w *console.WalletLedger) error {
+		export.WalletLedgers = append(export.WalletLedgers, w)
+		return nil
+	}); err != nil {
+		if _, ok := status.FromError(err); !ok {
+			logger.Error("Could not fetch wallet ledger items", zap.Error(err), zap.String("user_id", userID.String()))
+			err = status.Error(codes.Internal, "An error occurred while trying to export user data.")
+		}
+		return nil, err
 	}
-	wl := make([]*console.WalletLedger, len(walletLedgers))
-	for i, w := range walletLedgers {
-		changeset, err := json.Marshal(w.Changeset)
+
+	return export, nil
+}
+
+// accountExportPages reads one section of an account export a page at a time, up to accountExportMaxItems in total,
+// to bound memory use and query size for long-lived accounts. Each call to read is given the most items it may read,
+// and returns how many it read and whether there may be more.
+func accountExportPages(logger *zap.Logger, userID uuid.UUID, section string, read func(limit int) (int, bool, error)) error {
+	var count int
+	for {
+		limit := accountExportPageSize
+		if remaining := accountExportMaxItems - count; remaining < limit {
+			limit = remaining
+		}
+		n, more, err := read(limit)
+		if err != nil {
+			return err
+		}
+		count += n
+		if !more {
+			return nil
+		}
+		if count >= accountExportMaxItems {
+			logger.Warn("Account export items truncated", zap.String("user_id", userID.String()), zap.String("section", section), zap.Int("limit", accountExportMaxItems))
+			return nil
+		}
+	}
+}
+
+// accountExportFriends passes each of a user's friends to fn, identified only by user ID along with the friend state.
+func accountExportFriends(ctx context.Context, logger *zap.Logger, db *sql.DB, userID uuid.UUID, fn func(*api.Friend) error) error {
+	var cursor string
+	return accountExportPages(logger, userID, "friends", func(limit int) (int, bool, error) {
+		friends, err := ListFriends(ctx, logger, db, nil, userID, limit, nil, cursor)
+		if err != nil {
+			return 0, false, err
+		}
+		for _, f := range friends.Friends {
+			if err := fn(&api.Friend{User: &api.User{Id: f.User.Id}, State: f.State}); err != nil {
+				return 0, false, err
+			}
+		}
+		cursor = friends.Cursor
+		return len(friends.Friends), cursor != "", nil
+	})
+}
+
+// accountExportGroups passes each group a user belongs to, or has asked to join, to fn.
+func accountExportGroups(ctx context.Context, logger *zap.Logger, db *sql.DB, userID uuid.UUID, fn func(*api.Group) error) error {
+	var cursor string
+	return accountExportPages(logger, userID, "groups", func(limit int) (int, bool, error) {
+		groups, err := ListUserGroups(ctx, logger, db, userID, limit, nil, cursor)
+		if err != nil {
+			return 0, false, err
+		}
+		for _, g := range groups.UserGroups {
+			if err := fn(g.Group); err != nil {
+				return 0, false, err
+			}
+		}
+		cursor = groups.Cursor
+		return len(groups.UserGroups), cursor != "", nil
+	})
+}
+
+// accountExportMessages passes each channel message sent by a user to fn.
+func accountExportMessages(ctx context.Context, logger *zap.Logger, db *sql.DB, userID uuid.UUID, fn func(*api.ChannelMessage) error) error {
+	afterID := uuid.Nil
+	return accountExportPages(logger, userID, "messages", func(limit int) (int, bool, error) {
+		messages, err := GetChannelMessages(ctx, logger, db, userID, limit, afterID)
+		if err != nil {
+			return 0, false, err
+		}
+		for _, m := range messages {
+			if err := fn(m); err != nil {
+				return 0, false, err
+			}
+		}
+		if len(messages) < limit {
+			return len(messages), false, nil
+		}
+		afterID = uuid.FromStringOrNil(messages[len(messages)-1].MessageId)
+		return len(messages), true, nil
+	})
+}
+
+// accountExportLeaderboardRecords passes each of a user's leaderboard and tournament records to fn.
+func accountExportLeaderboardRecords(ctx context.Context, logger *zap.Logger, db *sql.DB, userID uuid.UUID, fn func(*api.LeaderboardRecord) error) error {
+	var after *api.LeaderboardRecord
+	return accountExportPages(logger, userID, "leaderboard_records", func(limit int) (int, bool, error) {
+		records, err := LeaderboardRecordReadAll(ctx, logger, db, userID, limit, after)
+		if err != nil {
+			return 0, false, err
+		}
+		for _, r := range records {
+			if err := fn(r); err != nil {
+				return 0, false, err
+			}
+		}
+		if len(records) < limit {
+			return len(records), false, nil
+		}
+		after = records[len(records)-1]
+		return len(records), true, nil
+	})
+}
+
+// accountExportNotifications passes each of a user's persistent notifications to fn.
+func accountExportNotifications(ctx context.Context, logger *zap.Logger, db *sql.DB, userID uuid.UUID, fn func(*api.Notification) error) error {
+	var nc *notificationCacheableCursor
+	return accountExportPages(logger, userID, "notifications", func(limit int) (int, bool, error) {
+		notifications, err := NotificationList(ctx, logger, db, userID, limit, "", nc)
+		if err != nil {
+			return 0, false, err
+		}
+		for _, n := range notifications.Notifications {
+			if err := fn(n); err != nil {
+				return 0, false, err
+			}
+		}
+		if len(notifications.Notifications) < limit {
+			return len(notifications.Notifications), false, nil
+		}
+		cb, err := base64.RawURLEncoding.DecodeString(notifications.CacheableCursor)
+		if err != nil {
+			return 0, false, err
+		}
+		nc = &notificationCacheableCursor{}
+		if err := gob.NewDecoder(bytes.NewReader(cb)).Decode(nc); err != nil {
+			return 0, false, err
+		}
+		return len(notifications.Notifications), true, nil
+	})
+}
+
+// accountExportStorageObjects passes each storage object owned by a user to fn.
+func accountExportStorageObjects(ctx context.Context, logger *zap.Logger, db *sql.DB, userID uuid.UUID, fn func(*api.StorageObject) error) error {
+	var afterCollection, afterKey string
+	return accountExportPages(logger, userID, "objects", func(limit int) (int, bool, error) {
+		objects, err := StorageReadAllUserObjects(ctx, logger, db, userID, limit, afterCollection, afterKey)
 		if err != nil {
-			logger.Error("Could not fetch wallet ledger items, error encoding changeset", zap.Error(err), zap.String("user_id", userID.String()))
-			return nil, status.Error(codes.Internal, "An error occurred while trying to export user data.")
+			return 0, false, err
 		}
-		metadata, err := json.Marshal(w.Metadata)
+		for _, o := range objects {
+			if err := fn(o); err != nil {
+				return 0, false, err
+			}
+		}
+		if len(objects) < limit {
+			return len(objects), false, nil
+		}
+		afterCollection, afterKey = objects[len(objects)-1].Collection, objects[len(objects)-1].Key
+		return len(objects), true, nil
+	})
+}
+
+// accountExportWalletLedgers passes each of a user's wallet ledger items to fn.
+func accountExportWalletLedgers(ctx context.Context, logger *zap.Logger, db *sql.DB, userID uuid.UUID, fn func(*console.WalletLedger) error) error {
+	var cursor string
+	return accountExportPages(logger, userID, "wallet_ledgers", func(limit int) (int, bool, error) {
+		page, nextCursor, err := ListWalletLedger(ctx, logger, db, userID, &limit, cursor)
 		if err != nil {
-			logger.Error("Could not fetch wallet ledger items, error encoding metadata", zap.Error(err), zap.String("user_id", userID.String()))
-			return nil, status.Error(codes.Internal, "An error occurred while trying to export user data.")
+			return 0, false, err
 		}
-		wl[i] = &console.WalletLedger{
-			Id:         w.ID,
-			UserId:     w.UserID,
-			Changeset:  string(changeset),
-			Metadata:   string(metadata),
-			CreateTime: &timestamp.Timestamp{Seconds: w.CreateTime},
-			UpdateTime: &timestamp.Timestamp{Seconds: w.UpdateTime},
+		for _, w := range page {
+			wl, err := exportWalletLedger(logger, userID, w)
+			if err != nil {
+				return 0, false, err
+			}
+			if err := fn(wl); err != nil {
+				return 0, false, err
+			}
 		}
+		cursor = nextCursor
+		return len(page), cursor != "", nil
+	})
+}
+
+func exportWalletLedger(logger *zap.Logger, userID uuid.UUID, w *walletLedger) (*console.WalletLedger, error) {
+	changeset, err := json.Marshal(w.Changeset)
+	if err != nil {
+		logger.Error("Could not fetch wallet ledger items, error encoding changeset", zap.Error(err), zap.String("user_id", userID.String()))
+		return nil, status.Error(codes.Internal, "An error occurred while trying to export user data.")
+	}
+	metadata, err := json.Marshal(w.Metadata)
+	if err != nil {
+		logger.Error("Could not fetch wallet ledger items, error encoding metadata", zap.Error(err), zap.String("user_id", userID.String()))
+		return nil, status.Error(codes.Internal, "An error occurred while trying to export user data.")
 	}
+	return &console.WalletLedger{
+		Id:         w.ID,
+		UserId:     w.UserID,
+		Changeset:  string(changeset),
+		Metadata:   string(metadata),
+		CreateTime: &timestamp.Timestamp{Seconds: w.CreateTime},
+		UpdateTime: &timestamp.Timestamp{Seconds: w.UpdateTime},
+	}, nil
+}
 
-	export := &console.AccountExport{
-		Account:            account,
-		Objects:            storageObjects,
-		Friends:            friends.GetFriends(),
-		Messages:           messages,
-		Groups:             groups,
-		LeaderboardRecords: leaderboardRecords,
-		Notifications:      notifications.GetNotifications(),
-		WalletLedgers:      wl,
+// accountExportWriter writes the sections of an account export JSON document. After the first error any further writes
+// are skipped, and the error is kept for the caller to check.
+type accountExportWriter struct {
+	w         io.Writer
+	marshaler *jsonpb.Marshaler
+	err       error
+	fields    int
+	items     int
+}
+
+func (a *accountExportWriter) write(s string) {
+	if a.err == nil {
+		_, a.err = io.WriteString(a.w, s)
 	}
+}
 
-	return export, nil
+// field starts a new top level field of the export document.
+func (a *accountExportWriter) field(name string) {
+	if a.fields > 0 {
+		a.write(",")
+	}
+	a.fields++
+	a.items = 0
+	a.write(strconv.Quote(name) + ":")
+}
+
+// item writes a single element of the array field currently being written.
+func (a *accountExportWriter) item(m proto.Message) {
+	if a.items > 0 {
+		a.write(",")
+	}
+	a.items++
+	if a.err == nil {
+		a.err = a.marshaler.Marshal(a.w, m)
+	}
+}
+
+// ExportAccountStream writes the same JSON document as an encoded ExportAccount result to w, one item at a time. Every
+// section is read a page at a time and written as it is read rather than collected first. Write errors from w are
+// returned as they are, so a size limited writer can stop the export early.
+func ExportAccountStream(ctx context.Context, logger *zap.Logger, db *sql.DB, marshaler *jsonpb.Marshaler, userID uuid.UUID, w io.Writer) error {
+	account, err := GetAccount(ctx, logger, db, nil, userID)
+	if err != nil {
+		if err == ErrAccountNotFound {
+			return status.Error(codes.NotFound, "Account not found.")
+		}
+		logger.Error("Could not export account data", zap.Error(err), zap.String("user_id", userID.String()))
+		return status.Error(codes.Internal, "An error occurred while trying to export user data.")
+	}
+
+	a := &accountExportWriter{w: w, marshaler: marshaler}
+	a.write("{")
+	a.field("account")
+	if a.err == nil {
+		a.err = marshaler.Marshal(w, account)
+	}
+
+	sections := []struct {
+		name    string
+		message string
+		read    func(fn func(proto.Message) error) error
+	}{
+		{"objects", "Could not fetch storage objects", func(fn func(proto.Message) error) error {
+			return accountExportStorageObjects(ctx, logger, db, userID, func(o *api.StorageObject) error { return fn(o) })
+		}},
+		{"friends", "Could not fetch friend IDs", func(fn func(proto.Message) error) error {
+			return accountExportFriends(ctx, logger, db, userID, func(f *api.Friend) error { return fn(f) })
+		}},
+		{"groups", "Could not fetch groups that belong to the user", func(fn func(proto.Message) error) error {
+			return accountExportGroups(ctx, logger, db, userID, func(g *api.Group) error { return fn(g) })
+		}},
+		{"messages", "Could not fetch messages", func(fn func(proto.Message) error) error {
+			return accountExportMessages(ctx, logger, db, userID, func(m *api.ChannelMessage) error { return fn(m) })
+		}},
+		{"leaderboard_records", "Could not fetch leaderboard records", func(fn func(proto.Message) error) error {
+			return accountExportLeaderboardRecords(ctx, logger, db, userID, func(r *api.LeaderboardRecord) error { return fn(r) })
+		}},
+		{"notifications", "Could not fetch notifications", func(fn func(proto.Message) error) error {
+			return accountExportNotifications(ctx, logger, db, userID, func(n *api.Notification) error { return fn(n) })
+		}},
+		{"wallet_ledgers", "Could not fetch wallet ledger items", func(fn func(proto.Message) error) error {
+			return accountExportWalletLedgers(ctx, logger, db, userID, func(w *console.WalletLedger) error { return fn(w) })
+		}},
+	}
+	for _, section := range sections {
+		if a.err != nil {
+			return a.err
+		}
+		a.field(section.name)
+		a.write("[")
+		err := section.read(func(m proto.Message) error {
+			a.item(m)
+			return a.err
+		})
+		if err != nil && a.err == nil {
+			if _, ok := status.FromError(err); ok {
+				return err
+			}
+			logger.Error(section.message, zap.Error(err), zap.String("user_id", userID.String()))
+			return status.Error(codes.Internal, "An error occurred while trying to export user data.")
+		}
+		a.write("]")
+	}
+	a.write("}")
+
+	return a.err
+}
+
+// ErrAccountExportTooLarge is returned when an account export does not fit in the size limit of its destination.
+var ErrAccountExportTooLarge = errors.New("account export exceeds maximum size")
+
+// accountExportLimitWriter buffers at most max bytes and fails any write that would go past it.
+type accountExportLimitWriter struct {
+	buf strings.Builder
+	max int
+}
+
+func (l *accountExportLimitWriter) Write(p []byte) (int, error) {
+	if l.buf.Len()+len(p) > l.max {
+		return 0, ErrAccountExportTooLarge
+	}
+	return l.buf.Write(p)
+}
+
+// ExportAccountToStorage streams a user's account export into a system owned storage object that only the runtime can
+// read or write. The export is abandoned as soon as it grows past maxBytes, or accountExportToStorageMaxBytes if maxBytes
+// is 0 or larger, without reading the remaining data.
+func ExportAccountToStorage(ctx context.Context, logger *zap.Logger, db *sql.DB, marshaler *jsonpb.Marshaler, userID uuid.UUID, collection, key string, maxBytes int) (*api.StorageObjectAck, error) {
+	if maxBytes <= 0 || maxBytes > accountExportToStorageMaxBytes {
+		maxBytes = accountExportToStorageMaxBytes
+	}
+	w := &accountExportLimitWriter{max: maxBytes}
+	if err := ExportAccountStream(ctx, logger, db, marshaler, userID, w); err != nil {
+		return nil, err
+	}
+
+	acks, _, err := StorageWriteObjects(ctx, logger, db, true, 0, StorageOpWrites{&StorageOpWrite{
+		OwnerID: uuid.Nil.String(),
+		Object: &api.WriteStorageObject{
+			Collection:      collection,
+			Key:             key,
+			Value:           w.buf.String(),
+			PermissionRead:  &wrappers.Int32Value{Value: 0},
+			PermissionWrite: &wrappers.Int32Value{Value: 0},
+		},
+	}})
+	if err != nil {
+		return nil, err
+	}
+	return acks.Acks[0], nil
+}
+
+// DeleteAccountOptions controls which categories of a user's data are removed when their account is deleted. When every
+// category is selected the account is purged entirely. Otherwise the account is anonymized instead: identifying profile
+// data and login methods are removed and the account is disabled, while unselected categories are left in place.
+type DeleteAccountOptions struct {
+	Storage            bool
+	Wallet             bool
+	LeaderboardRecords bool
+	GroupMemberships   bool
+	// Record the deletion in the user tombstone table. Only applies when the account is purged.
+	Recorded bool
+}
+
+// NewDeleteAccountOptions returns options that purge all of a user's data.
+func NewDeleteAccountOptions(recorded bool) *DeleteAccountOptions {
+	return &DeleteAccountOptions{
+		Storage:            true,
+		Wallet:             true,
+		LeaderboardRecords: true,
+		GroupMemberships:   true,
+		Recorded:           recorded,
+	}
+}
+
+func (o *DeleteAccountOptions) purge() bool {
+	return o.Storage && o.Wallet && o.LeaderboardRecords && o.GroupMemberships
 }
 
 func DeleteAccount(ctx context.Context, logger *zap.Logger, db *sql.DB, userID uuid.UUID, recorded bool) error {
+	return DeleteAccountWithOptions(ctx, logger, db, userID, NewDeleteAccountOptions(recorded))
+}
+
+func DeleteAccountWithOptions(ctx context.Context, logger *zap.Logger, db *sql.DB, userID uuid.UUID, options *DeleteAccountOptions) error {
 	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		logger.Error("Could not begin database transaction.", zap.Error(err))
@@ -475,7 +908,13 @@ func DeleteAccount(ctx context.Context, logger *zap.Logger, db *sql.DB, userID u
 	}
 
 	if err := ExecuteInTx(ctx, tx, func() error {
-		count, err := DeleteUser(ctx, tx, userID)
+		var count int64
+		var err error
+		if options.purge() {
+			count, err = DeleteUser(ctx, tx, userID)
+		} else {
+			count, err = anonymizeUser(ctx, tx, userID, options.Wallet)
+		}
 		if err != nil {
 			logger.Debug("Could not delete user", zap.Error(err), zap.String("user_id", userID.String()))
 			return err
@@ -484,19 +923,40 @@ func DeleteAccount(ctx context.Context, logger *zap.Logger, db *sql.DB, userID u
 			return nil
 		}
 
-		err = LeaderboardRecordsDeleteAll(ctx, logger, tx, userID)
-		if err != nil {
-			logger.Debug("Could not delete leaderboard records.", zap.Error(err), zap.String("user_id", userID.String()))
-			return err
+		if !options.purge() {
+			// Deleting the user row cascades to these, so they only need explicit removal when anonymizing.
+			if options.Storage {
+				if _, err = tx.ExecContext(ctx, "DELETE FROM storage WHERE user_id = $1", userID); err != nil {
+					logger.Debug("Could not delete storage objects.", zap.Error(err), zap.String("user_id", userID.String()))
+					return err
+				}
+			}
+			if options.Wallet {
+				if _, err = tx.ExecContext(ctx, "DELETE FROM wallet_ledger WHERE user_id = $1", userID); err != nil {
+					logger.Debug("Could not delete wallet ledger.", zap.Error(err), zap.String("user_id", userID.String()))
+					return err
+				}
+			}
 		}
 
-		err = GroupDeleteAll(ctx, logger, tx, userID)
-		if err != nil {
-			logger.Debug("Could not delete groups and relationships.", zap.Error(err), zap.String("user_id", userID.String()))
-			return err
+		if options.LeaderboardRecords {
+			err = LeaderboardRecordsDeleteAll(ctx, logger, tx, userID)
+			if err != nil {
+				logger.Debug("Could not delete leaderboard records.", zap.Error(err), zap.String("user_id", userID.String()))
+				return err
+			}
 		}
 
-		if recorded {
+		if options.GroupMemberships {
+			err = GroupDeleteAll(ctx, logger, tx, userID)
+			if err != nil {
+				logger.Debug("Could not delete groups and relationships.", zap.Error(err), zap.String("user_id", userID.String()))
+				return err
+			}
+		}
+
+		// Anonymized users still exist, so only purged users are recorded.
+		if options.Recorded && options.purge() {
 			_, err = tx.ExecContext(ctx, `INSERT INTO user_tombstone (user_id) VALUES ($1) ON CONFLICT(user_id) DO NOTHING`, userID)
 			if err != nil {
 				logger.Debug("Could not insert user ID into tombstone", zap.Error(err), zap.String("user_id", userID.String()))
@@ -512,3 +972,50 @@ func DeleteAccount(ctx context.Context, logger *zap.Logger, db *sql.DB, userID u
 
 	return nil
 }
+
+// Strip identifying profile data and all login methods from a user, and disable the account. The username is replaced
+// with the user ID since it must remain unique. Friend relationships, notifications and chat messages still point at the
+// user, so they are removed as deleting the user row would have done.
+func anonymizeUser(ctx context.Context, tx *sql.Tx, userID uuid.UUID, resetWallet bool) (int64, error) {
+	query := `
+UPDATE users SET username = $2, display_name = NULL, avatar_url = NULL, location = NULL, timezone = NULL,
+	metadata = '{}', email = NULL, password = NULL, apple_id = NULL, facebook_id = NULL, facebook_instant_game_id = NULL,
+	google_id = NULL, gamecenter_id = NULL, steam_id = NULL, custom_id = NULL, edge_count = 0, disable_time = now(),
+	update_time = now()`
+	if resetWallet {
+		query += ", wallet = '{}'"
+	}
+	query += " WHERE id = $1"
+
+	res, err := tx.ExecContext(ctx, query, userID, userID.String())
+	if err != nil {
+		return 0, err
+	}
+	count, err := res.RowsAffected()
+	if err != nil || count == 0 {
+		return count, err
+	}
+
+	if _, err = tx.ExecContext(ctx, "DELETE FROM user_device WHERE user_id = $1", userID); err != nil {
+		return 0, err
+	}
+	// Each other user has at most one edge to this user.
+	if _, err = tx.ExecContext(ctx, "UPDATE users SET edge_count = edge_count - 1, update_time = now() WHERE id IN (SELECT source_id FROM user_edge WHERE destination_id = $1)", userID); err != nil {
+		return 0, err
+	}
+	if _, err = tx.ExecContext(ctx, "DELETE FROM user_edge WHERE source_id = $1 OR destination_id = $1", userID); err != nil {
+		return 0, err
+	}
+	if _, err = tx.ExecContext(ctx, "DELETE FROM notification WHERE user_id = $1 OR sender_id = $1", userID); err != nil {
+		return 0, err
+	}
+	if _, err = tx.ExecContext(ctx, "DELETE FROM message WHERE sender_id = $1", userID); err != nil {
+		return 0, err
+	}
+	if resetWallet {
+		if err = writeWalletBalances(ctx, tx, userID.String(), nil); err != nil {
+			return 0, err
+		}
+	}
+	return count, nil
+}
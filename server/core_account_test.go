@@ -0,0 +1,451 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/heroiclabs/nakama-common/api"
+	"github.com/heroiclabs/nakama/v2/console"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportAccount(t *testing.T) {
+	db := NewDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	userID, username, _, err := AuthenticateCustom(ctx, logger, db, uuid.Must(uuid.NewV4()).String(), uuid.Must(uuid.NewV4()).String(), true)
+	if err != nil {
+		t.Fatalf("error creating user: %v", err.Error())
+	}
+	friendID, _, _, err := AuthenticateCustom(ctx, logger, db, uuid.Must(uuid.NewV4()).String(), uuid.Must(uuid.NewV4()).String(), true)
+	if err != nil {
+		t.Fatalf("error creating user: %v", err.Error())
+	}
+	uid := uuid.FromStringOrNil(userID)
+
+	// Storage, with more objects than fit in a single page.
+	ops := make(StorageOpWrites, 0, accountExportPageSize+1)
+	for i := 0; i < accountExportPageSize+1; i++ {
+		ops = append(ops, &StorageOpWrite{
+			OwnerID: userID,
+			Object: &api.WriteStorageObject{
+				Collection:      "export",
+				Key:             fmt.Sprintf("key%v", i),
+				Value:           `{"foo":"bar"}`,
+				PermissionRead:  &wrappers.Int32Value{Value: 1},
+				PermissionWrite: &wrappers.Int32Value{Value: 1},
+			},
+		})
+	}
+	if _, _, err = StorageWriteObjects(ctx, logger, db, true, 0, ops); err != nil {
+		t.Fatalf("error writing storage objects: %v", err.Error())
+	}
+
+	// Notifications, with more than fit in a single page.
+	notifications := make([]*api.Notification, 0, accountExportPageSize+1)
+	for i := 0; i < accountExportPageSize+1; i++ {
+		notifications = append(notifications, &api.Notification{Id: uuid.Must(uuid.NewV4()).String(), Subject: "export", Content: "{}", Code: 1, SenderId: uuid.Nil.String()})
+	}
+	if err := NotificationSave(ctx, logger, db, map[uuid.UUID][]*api.Notification{uid: notifications}); err != nil {
+		t.Fatalf("error saving notifications: %v", err.Error())
+	}
+
+	// Wallet ledger, with more items than fit in a single page.
+	updates := make([]*walletUpdate, 0, accountExportPageSize+1)
+	for i := 0; i < accountExportPageSize+1; i++ {
+		updates = append(updates, &walletUpdate{UserID: uid, Changeset: map[string]int64{"coins": 1}, Metadata: "{}"})
+	}
+	if _, err := UpdateWallets(ctx, logger, db, updates, true); err != nil {
+		t.Fatalf("error updating wallet: %v", err.Error())
+	}
+
+	// Friends.
+	if err := AddFriends(ctx, logger, db, &DummyMessageRouter{}, uid, username, []string{friendID}); err != nil {
+		t.Fatalf("error adding friend: %v", err.Error())
+	}
+
+	// Leaderboard records.
+	leaderboardCache := NewLocalLeaderboardCache(logger, logger, db)
+	rankCache := NewLocalLeaderboardRankCache(logger, db, NewConfig(logger).GetLeaderboard(), leaderboardCache)
+	leaderboardID := uuid.Must(uuid.NewV4()).String()
+	if _, err := leaderboardCache.Create(ctx, leaderboardID, true, LeaderboardSortOrderDescending, LeaderboardOperatorBest, "", "{}"); err != nil {
+		t.Fatalf("error creating leaderboard: %v", err.Error())
+	}
+	if _, err := LeaderboardRecordWrite(ctx, logger, db, leaderboardCache, rankCache, uuid.Nil, leaderboardID, userID, username, 10, 0, "{}"); err != nil {
+		t.Fatalf("error writing leaderboard record: %v", err.Error())
+	}
+
+	export, err := ExportAccount(ctx, logger, db, uid)
+	if err != nil {
+		t.Fatalf("error exporting account: %v", err.Error())
+	}
+
+	assert.Equal(t, userID, export.Account.User.Id)
+	assert.Len(t, export.Objects, accountExportPageSize+1)
+	assert.Equal(t, "export", export.Objects[0].Collection)
+	assert.Len(t, export.Notifications, accountExportPageSize+1)
+	assert.Len(t, export.WalletLedgers, accountExportPageSize+1)
+	assert.Len(t, export.Friends, 1)
+	assert.Equal(t, friendID, export.Friends[0].User.Id)
+	assert.Len(t, export.LeaderboardRecords, 1)
+	assert.Equal(t, leaderboardID, export.LeaderboardRecords[0].LeaderboardId)
+}
+
+func TestGetAccountByIdentity(t *testing.T) {
+	db := NewDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	deviceID := uuid.Must(uuid.NewV4()).String()
+	deviceUserID, _, _, err := AuthenticateDevice(ctx, logger, db, deviceID, uuid.Must(uuid.NewV4()).String(), true)
+	if err != nil {
+		t.Fatalf("error creating user: %v", err.Error())
+	}
+	email := uuid.Must(uuid.NewV4()).String() + "@example.com"
+	emailUserID, _, _, err := AuthenticateEmail(ctx, logger, db, email, "password", uuid.Must(uuid.NewV4()).String(), true)
+	if err != nil {
+		t.Fatalf("error creating user: %v", err.Error())
+	}
+	customID := uuid.Must(uuid.NewV4()).String()
+	customUserID, _, _, err := AuthenticateCustom(ctx, logger, db, customID, uuid.Must(uuid.NewV4()).String(), true)
+	if err != nil {
+		t.Fatalf("error creating user: %v", err.Error())
+	}
+
+	account, err := GetAccountByDevice(ctx, logger, db, nil, deviceID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, deviceUserID, account.User.Id)
+	}
+	account, err = GetAccountByEmail(ctx, logger, db, nil, email)
+	if assert.NoError(t, err) {
+		assert.Equal(t, emailUserID, account.User.Id)
+		assert.Equal(t, email, account.Email)
+	}
+	account, err = GetAccountByCustom(ctx, logger, db, nil, customID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, customUserID, account.User.Id)
+		assert.Equal(t, customID, account.CustomId)
+	}
+
+	// Identities are only matched against their own type.
+	_, err = GetAccountByDevice(ctx, logger, db, nil, customID)
+	assert.Equal(t, ErrAccountNotFound, err)
+	_, err = GetAccountByCustom(ctx, logger, db, nil, deviceID)
+	assert.Equal(t, ErrAccountNotFound, err)
+	_, err = GetAccountByEmail(ctx, logger, db, nil, uuid.Must(uuid.NewV4()).String()+"@example.com")
+	assert.Equal(t, ErrAccountNotFound, err)
+}
+
+func TestDeleteAccountSelective(t *testing.T) {
+	db := NewDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	userID, username, _, err := AuthenticateCustom(ctx, logger, db, uuid.Must(uuid.NewV4()).String(), uuid.Must(uuid.NewV4()).String(), true)
+	if err != nil {
+		t.Fatalf("error creating user: %v", err.Error())
+	}
+	uid := uuid.FromStringOrNil(userID)
+
+	_, _, err = StorageWriteObjects(ctx, logger, db, true, 0, StorageOpWrites{&StorageOpWrite{
+		OwnerID: userID,
+		Object: &api.WriteStorageObject{
+			Collection:      "delete",
+			Key:             "key",
+			Value:           `{"foo":"bar"}`,
+			PermissionRead:  &wrappers.Int32Value{Value: 1},
+			PermissionWrite: &wrappers.Int32Value{Value: 1},
+		},
+	}})
+	if err != nil {
+		t.Fatalf("error writing storage object: %v", err.Error())
+	}
+	if _, err := UpdateWallets(ctx, logger, db, []*walletUpdate{{UserID: uid, Changeset: map[string]int64{"coins": 10}, Metadata: "{}"}}, true); err != nil {
+		t.Fatalf("error updating wallet: %v", err.Error())
+	}
+	leaderboardCache := NewLocalLeaderboardCache(logger, logger, db)
+	rankCache := NewLocalLeaderboardRankCache(logger, db, NewConfig(logger).GetLeaderboard(), leaderboardCache)
+	leaderboardID := uuid.Must(uuid.NewV4()).String()
+	if _, err := leaderboardCache.Create(ctx, leaderboardID, true, LeaderboardSortOrderDescending, LeaderboardOperatorBest, "", "{}"); err != nil {
+		t.Fatalf("error creating leaderboard: %v", err.Error())
+	}
+	if _, err := LeaderboardRecordWrite(ctx, logger, db, leaderboardCache, rankCache, uuid.Nil, leaderboardID, userID, username, 10, 0, "{}"); err != nil {
+		t.Fatalf("error writing leaderboard record: %v", err.Error())
+	}
+	friendID, _, _, err := AuthenticateCustom(ctx, logger, db, uuid.Must(uuid.NewV4()).String(), uuid.Must(uuid.NewV4()).String(), true)
+	if err != nil {
+		t.Fatalf("error creating friend: %v", err.Error())
+	}
+	// Friend requests in both directions leave edges and notifications on each side.
+	if err := AddFriends(ctx, logger, db, &DummyMessageRouter{}, uid, username, []string{friendID}); err != nil {
+		t.Fatalf("error adding friend: %v", err.Error())
+	}
+	if err := AddFriends(ctx, logger, db, &DummyMessageRouter{}, uuid.FromStringOrNil(friendID), friendID, []string{userID}); err != nil {
+		t.Fatalf("error adding friend: %v", err.Error())
+	}
+	if _, err := db.ExecContext(ctx, `
+INSERT INTO message (id, code, sender_id, username, stream_mode, stream_subject, stream_descriptor, stream_label, content)
+VALUES ($1, 0, $2, $3, $4, $2, $5, '', '{}')`, uuid.Must(uuid.NewV4()), uid, username, StreamModeDM, uuid.FromStringOrNil(friendID)); err != nil {
+		t.Fatalf("error writing message: %v", err.Error())
+	}
+
+	// Keep storage, remove everything else.
+	options := NewDeleteAccountOptions(true)
+	options.Storage = false
+	if err := DeleteAccountWithOptions(ctx, logger, db, uid, options); err != nil {
+		t.Fatalf("error deleting account: %v", err.Error())
+	}
+
+	count := func(query string) int {
+		var c int
+		if err := db.QueryRowContext(ctx, query, uid).Scan(&c); err != nil {
+			t.Fatalf("error counting rows: %v", err.Error())
+		}
+		return c
+	}
+
+	// The account is anonymized rather than removed.
+	var anonUsername, wallet string
+	var customID sql.NullString
+	var disabled bool
+	if err := db.QueryRowContext(ctx, "SELECT username, custom_id, wallet, disable_time > '1970-01-01 00:00:00 UTC' FROM users WHERE id = $1", uid).Scan(&anonUsername, &customID, &wallet, &disabled); err != nil {
+		t.Fatalf("error reading anonymized user: %v", err.Error())
+	}
+	assert.Equal(t, userID, anonUsername)
+	assert.False(t, customID.Valid)
+	assert.Equal(t, "{}", wallet)
+	assert.True(t, disabled)
+
+	assert.Equal(t, 1, count("SELECT COUNT(*) FROM storage WHERE user_id = $1"))
+	assert.Equal(t, 0, count("SELECT COUNT(*) FROM wallet_ledger WHERE user_id = $1"))
+	assert.Equal(t, 0, count("SELECT COUNT(*) FROM leaderboard_record WHERE owner_id = $1"))
+	assert.Equal(t, 0, count("SELECT COUNT(*) FROM user_edge WHERE source_id = $1 OR destination_id = $1"))
+	assert.Equal(t, 0, count("SELECT COUNT(*) FROM notification WHERE user_id = $1 OR sender_id = $1"))
+	assert.Equal(t, 0, count("SELECT COUNT(*) FROM message WHERE sender_id = $1"))
+	assert.Equal(t, 0, count("SELECT edge_count FROM users WHERE id = $1"))
+	var friendEdgeCount int
+	if err := db.QueryRowContext(ctx, "SELECT edge_count FROM users WHERE id = $1", friendID).Scan(&friendEdgeCount); err != nil {
+		t.Fatalf("error reading friend: %v", err.Error())
+	}
+	assert.Equal(t, 0, friendEdgeCount)
+
+	// The user still exists, so no tombstone is recorded.
+	assert.Equal(t, 0, count("SELECT COUNT(*) FROM user_tombstone WHERE user_id = $1"))
+}
+
+func TestDeleteAccountPurge(t *testing.T) {
+	db := NewDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	userID, _, _, err := AuthenticateCustom(ctx, logger, db, uuid.Must(uuid.NewV4()).String(), uuid.Must(uuid.NewV4()).String(), true)
+	if err != nil {
+		t.Fatalf("error creating user: %v", err.Error())
+	}
+	uid := uuid.FromStringOrNil(userID)
+
+	_, _, err = StorageWriteObjects(ctx, logger, db, true, 0, StorageOpWrites{&StorageOpWrite{
+		OwnerID: userID,
+		Object: &api.WriteStorageObject{
+			Collection:      "delete",
+			Key:             "key",
+			Value:           `{"foo":"bar"}`,
+			PermissionRead:  &wrappers.Int32Value{Value: 1},
+			PermissionWrite: &wrappers.Int32Value{Value: 1},
+		},
+	}})
+	if err != nil {
+		t.Fatalf("error writing storage object: %v", err.Error())
+	}
+
+	if err := DeleteAccountWithOptions(ctx, logger, db, uid, NewDeleteAccountOptions(false)); err != nil {
+		t.Fatalf("error deleting account: %v", err.Error())
+	}
+
+	var users, objects int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users WHERE id = $1", uid).Scan(&users); err != nil {
+		t.Fatalf("error counting users: %v", err.Error())
+	}
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM storage WHERE user_id = $1", uid).Scan(&objects); err != nil {
+		t.Fatalf("error counting storage objects: %v", err.Error())
+	}
+	assert.Equal(t, 0, users)
+	assert.Equal(t, 0, objects)
+}
+
+func TestUpdateAccountsWalletUntouched(t *testing.T) {
+	db := NewDB(t)
+	defer db.Close()
+
+	userID, _, _, err := AuthenticateCustom(context.Background(), logger, db, uuid.Must(uuid.NewV4()).String(), uuid.Must(uuid.NewV4()).String(), true)
+	if err != nil {
+		t.Fatalf("error creating user: %v", err.Error())
+	}
+	uid := uuid.FromStringOrNil(userID)
+
+	_, err = UpdateWallets(context.Background(), logger, db, []*walletUpdate{{
+		UserID:         uid,
+		Changeset:      map[string]int64{"coins": 100},
+		Metadata:       "{}",
+		WalletMetadata: map[string]interface{}{"tier": "gold"},
+	}}, true)
+	if err != nil {
+		t.Fatalf("error updating wallet: %v", err.Error())
+	}
+
+	// Updating account metadata alone leaves the wallet as it was.
+	err = UpdateAccounts(context.Background(), logger, db, []*accountUpdate{{
+		userID:   uid,
+		metadata: &wrappers.StringValue{Value: `{"coins":0,"tier":"bronze"}`},
+	}})
+	if err != nil {
+		t.Fatalf("error updating account: %v", err.Error())
+	}
+
+	// Account and wallet updates in the same transaction apply independently of each other.
+	_, _, err = MultiUpdate(context.Background(), logger, db, []*accountUpdate{{
+		userID:   uid,
+		metadata: &wrappers.StringValue{Value: `{"coins":-1}`},
+	}}, nil, []*walletUpdate{{
+		UserID:    uid,
+		Changeset: map[string]int64{"coins": -30},
+		Metadata:  "{}",
+	}}, true)
+	if err != nil {
+		t.Fatalf("error running multi update: %v", err.Error())
+	}
+
+	account, err := GetAccount(context.Background(), logger, db, nil, uid)
+	if err != nil {
+		t.Fatalf("error getting account: %v", err.Error())
+	}
+	assert.Equal(t, `{"coins":-1}`, account.User.Metadata)
+	assert.JSONEq(t, `{"coins":70}`, account.Wallet)
+
+	walletMetadata, err := GetWalletMetadata(context.Background(), logger, db, uid)
+	if err != nil {
+		t.Fatalf("error getting wallet metadata: %v", err.Error())
+	}
+	assert.Equal(t, map[string]interface{}{"tier": "gold"}, walletMetadata)
+}
+
+func TestExportAccountStream(t *testing.T) {
+	db := NewDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	userID, _, _, err := AuthenticateCustom(ctx, logger, db, uuid.Must(uuid.NewV4()).String(), uuid.Must(uuid.NewV4()).String(), true)
+	if err != nil {
+		t.Fatalf("error creating user: %v", err.Error())
+	}
+	uid := uuid.FromStringOrNil(userID)
+
+	// Storage, with enough objects and data to make up a large export.
+	ops := make(StorageOpWrites, 0, 500)
+	for i := 0; i < 500; i++ {
+		ops = append(ops, &StorageOpWrite{
+			OwnerID: userID,
+			Object: &api.WriteStorageObject{
+				Collection:      "export",
+				Key:             fmt.Sprintf("key%v", i),
+				Value:           `{"data":"` + strings.Repeat("x", 1000) + `"}`,
+				PermissionRead:  &wrappers.Int32Value{Value: 1},
+				PermissionWrite: &wrappers.Int32Value{Value: 1},
+			},
+		})
+	}
+	if _, _, err := StorageWriteObjects(ctx, logger, db, true, 0, ops); err != nil {
+		t.Fatalf("error writing storage objects: %v", err.Error())
+	}
+
+	// Wallet ledger, over several pages.
+	updates := make([]*walletUpdate, 0, 3*accountExportPageSize+1)
+	for i := 0; i < 3*accountExportPageSize+1; i++ {
+		updates = append(updates, &walletUpdate{UserID: uid, Changeset: map[string]int64{"coins": 1}, Metadata: "{}"})
+	}
+	if _, err := UpdateWallets(ctx, logger, db, updates, true); err != nil {
+		t.Fatalf("error updating wallet: %v", err.Error())
+	}
+
+	var buf bytes.Buffer
+	if err := ExportAccountStream(ctx, logger, db, jsonpbMarshaler, uid, &buf); err != nil {
+		t.Fatalf("error streaming account export: %v", err.Error())
+	}
+	streamed := &console.AccountExport{}
+	if err := jsonpbUnmarshaler.Unmarshal(bytes.NewReader(buf.Bytes()), streamed); err != nil {
+		t.Fatalf("error decoding streamed account export: %v", err.Error())
+	}
+
+	export, err := ExportAccount(ctx, logger, db, uid)
+	if err != nil {
+		t.Fatalf("error exporting account: %v", err.Error())
+	}
+
+	// The streamed export holds everything the regular export does.
+	assert.Equal(t, userID, streamed.Account.User.Id)
+	assert.JSONEq(t, export.Account.Wallet, streamed.Account.Wallet)
+	assert.Len(t, streamed.Objects, 500)
+	keys := make(map[string]bool, len(streamed.Objects))
+	for _, o := range streamed.Objects {
+		keys[o.Key] = true
+	}
+	for _, o := range export.Objects {
+		assert.True(t, keys[o.Key], "missing storage object %v", o.Key)
+	}
+	assert.Len(t, streamed.WalletLedgers, 3*accountExportPageSize+1)
+	assert.Len(t, streamed.WalletLedgers, len(export.WalletLedgers))
+
+	// Exports that do not fit the size limit are rejected without writing anything.
+	_, err = ExportAccountToStorage(ctx, logger, db, jsonpbMarshaler, uid, "exports", userID, buf.Len()-1)
+	assert.Equal(t, ErrAccountExportTooLarge, err)
+
+	ack, err := ExportAccountToStorage(ctx, logger, db, jsonpbMarshaler, uid, "exports", userID, buf.Len())
+	if err != nil {
+		t.Fatalf("error exporting account to storage: %v", err.Error())
+	}
+	objects, err := StorageReadObjects(ctx, logger, db, uuid.Nil, []*api.ReadStorageObjectId{{Collection: ack.Collection, Key: ack.Key}})
+	if err != nil {
+		t.Fatalf("error reading account export: %v", err.Error())
+	}
+	if assert.Len(t, objects.Objects, 1) {
+		stored := &console.AccountExport{}
+		if err := jsonpbUnmarshaler.Unmarshal(strings.NewReader(objects.Objects[0].Value), stored); err != nil {
+			t.Fatalf("error decoding stored account export: %v", err.Error())
+		}
+		assert.Len(t, stored.Objects, 500)
+		assert.Len(t, stored.WalletLedgers, 3*accountExportPageSize+1)
+	}
+}
+
+func TestAccountExportLimitWriter(t *testing.T) {
+	w := &accountExportLimitWriter{max: 8}
+	if _, err := w.Write([]byte("{}")); err != nil {
+		t.Fatalf("error writing within limit: %v", err.Error())
+	}
+	if _, err := w.Write([]byte(`"abcdef"`)); err != ErrAccountExportTooLarge {
+		t.Fatalf("expected write past limit to fail, got: %v", err)
+	}
+	// Writes past the limit are not buffered.
+	assert.Equal(t, "{}", w.buf.String())
+}
@@ -687,11 +687,10 @@ func AuthenticateGoogle(ctx context.Context, logger *zap.Logger, db *sql.DB, cli
 	return userID, username, true, nil
 }
 
-func AuthenticateSteam(ctx context.Context, logger *zap.Logger, db *sql.DB, client *social.Client, appID int, publisherKey, token, username string, create bool) (string, string, bool, error) {
-	steamProfile, err := client.GetSteamProfile(ctx, publisherKey, appID, token)
+func AuthenticateSteam(ctx context.Context, logger *zap.Logger, db *sql.DB, client *social.Client, steamConfig *SocialConfigSteam, token, username string, create bool) (string, string, bool, error) {
+	steamProfile, err := getSteamProfile(ctx, logger, client, steamConfig, token)
 	if err != nil {
-		logger.Info("Could not authenticate Steam profile.", zap.Error(err))
-		return "", "", false, status.Error(codes.Unauthenticated, "Could not authenticate Steam profile.")
+		return "", "", false, err
 	}
 	steamID := strconv.FormatUint(steamProfile.SteamID, 10)
 	found := true
@@ -754,6 +753,29 @@ func AuthenticateSteam(ctx context.Context, logger *zap.Logger, db *sql.DB, clie
 	return userID, username, true, nil
 }
 
+// Fetch the Steam profile for a session ticket, optionally ensuring the user owns the configured app.
+func getSteamProfile(ctx context.Context, logger *zap.Logger, client *social.Client, steamConfig *SocialConfigSteam, token string) (*social.SteamProfile, error) {
+	steamProfile, err := client.GetSteamProfile(ctx, steamConfig.PublisherKey, steamConfig.AppID, token)
+	if err != nil {
+		logger.Info("Could not authenticate Steam profile.", zap.Error(err))
+		return nil, status.Error(codes.Unauthenticated, "Could not authenticate Steam profile.")
+	}
+
+	if steamConfig.CheckAppOwnership {
+		ownsApp, err := client.CheckSteamAppOwnership(ctx, steamConfig.PublisherKey, steamConfig.AppID, steamProfile.SteamID)
+		if err != nil {
+			logger.Info("Could not check Steam app ownership.", zap.Error(err))
+			return nil, status.Error(codes.Unauthenticated, "Could not authenticate Steam profile.")
+		}
+		if !ownsApp {
+			logger.Info("Steam user does not own app.", zap.Uint64("steamID", steamProfile.SteamID), zap.Int("appID", steamConfig.AppID))
+			return nil, status.Error(codes.PermissionDenied, "Steam user does not own this app.")
+		}
+	}
+
+	return steamProfile, nil
+}
+
 func importFacebookFriends(ctx context.Context, logger *zap.Logger, db *sql.DB, messageRouter MessageRouter, client *social.Client, userID uuid.UUID, username, token string, reset bool) error {
 	facebookProfiles, err := client.GetFacebookFriends(ctx, token)
 	if err != nil {
@@ -761,8 +783,48 @@ func importFacebookFriends(ctx context.Context, logger *zap.Logger, db *sql.DB,
 		return status.Error(codes.Unauthenticated, "Could not authenticate Facebook profile.")
 	}
 
-	if len(facebookProfiles) == 0 && !reset {
-		// No Facebook friends to import, and friend reset not requested - no work to do.
+	facebookIDs := make([]string, 0, len(facebookProfiles))
+	for _, facebookProfile := range facebookProfiles {
+		facebookIDs = append(facebookIDs, facebookProfile.ID)
+	}
+
+	return importFriends(ctx, logger, db, messageRouter, userID, username, "Facebook", "facebook_id", facebookIDs, reset)
+}
+
+func importSteamFriends(ctx context.Context, logger *zap.Logger, db *sql.DB, messageRouter MessageRouter, client *social.Client, publisherKey string, userID uuid.UUID, reset bool) error {
+	var dbUsername string
+	var dbSteamID sql.NullString
+	err := db.QueryRowContext(ctx, "SELECT username, steam_id FROM users WHERE id = $1", userID).Scan(&dbUsername, &dbSteamID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return status.Error(codes.NotFound, "User account not found.")
+		}
+		logger.Error("Error looking up user Steam ID.", zap.Error(err), zap.String("user_id", userID.String()))
+		return status.Error(codes.Internal, "Error importing Steam friends.")
+	}
+	steamID, err := strconv.ParseUint(dbSteamID.String, 10, 64)
+	if !dbSteamID.Valid || err != nil {
+		return status.Error(codes.FailedPrecondition, "User account has no linked Steam ID.")
+	}
+
+	steamProfiles, err := client.GetSteamFriends(ctx, publisherKey, steamID)
+	if err != nil {
+		logger.Info("Could not import Steam friends.", zap.Error(err))
+		return status.Error(codes.Unauthenticated, "Could not retrieve Steam friends.")
+	}
+
+	steamIDs := make([]string, 0, len(steamProfiles))
+	for _, steamProfile := range steamProfiles {
+		steamIDs = append(steamIDs, strconv.FormatUint(steamProfile.SteamID, 10))
+	}
+
+	return importFriends(ctx, logger, db, messageRouter, userID, dbUsername, "Steam", "steam_id", steamIDs, reset)
+}
+
+// Add friend edges between the user and any users matching the given social provider IDs in the given users column.
+func importFriends(ctx context.Context, logger *zap.Logger, db *sql.DB, messageRouter MessageRouter, userID uuid.UUID, username, provider, idColumn string, ids []string, reset bool) error {
+	if len(ids) == 0 && !reset {
+		// No friends to import, and friend reset not requested - no work to do.
 		return nil
 	}
 
@@ -771,12 +833,12 @@ func importFacebookFriends(ctx context.Context, logger *zap.Logger, db *sql.DB,
 	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		logger.Error("Could not begin database transaction.", zap.Error(err))
-		return status.Error(codes.Internal, "Error importing Facebook friends.")
+		return status.Error(codes.Internal, "Error importing "+provider+" friends.")
 	}
 
 	err = ExecuteInTx(ctx, tx, func() error {
 		if reset {
-			// Reset all friends for the current user, replacing them entirely with their Facebook friends.
+			// Reset all friends for the current user, replacing them entirely with their social provider friends.
 			// Note: will NOT remove blocked users.
 			query := "DELETE FROM user_edge WHERE source_id = $1 AND state != 3"
 			result, err := tx.ExecContext(ctx, query, userID)
@@ -828,21 +890,21 @@ func importFacebookFriends(ctx context.Context, logger *zap.Logger, db *sql.DB,
 			}
 		}
 
-		// A reset was requested, but now there are no Facebook friend profiles to look for.
-		if len(facebookProfiles) == 0 {
+		// A reset was requested, but now there are no friend IDs to look for.
+		if len(ids) == 0 {
 			return nil
 		}
 
-		statements := make([]string, 0, len(facebookProfiles))
-		params := make([]interface{}, 0, len(facebookProfiles))
+		statements := make([]string, 0, len(ids))
+		params := make([]interface{}, 0, len(ids))
 		count := 1
-		for _, facebookProfile := range facebookProfiles {
+		for _, id := range ids {
 			statements = append(statements, "$"+strconv.Itoa(count))
-			params = append(params, facebookProfile.ID)
+			params = append(params, id)
 			count++
 		}
 
-		query := "SELECT id FROM users WHERE facebook_id IN (" + strings.Join(statements, ", ") + ")"
+		query := "SELECT id FROM users WHERE " + idColumn + " IN (" + strings.Join(statements, ", ") + ")"
 
 		rows, err := tx.QueryContext(ctx, query, params...)
 		if err != nil {
@@ -878,7 +940,7 @@ func importFacebookFriends(ctx context.Context, logger *zap.Logger, db *sql.DB,
 			var state sql.NullInt64
 			err = tx.QueryRowContext(ctx, "SELECT state FROM user_edge WHERE source_id = $1 AND destination_id = $2 AND state = 3", userID, friendID).Scan(&state)
 			if err != nil && err != sql.ErrNoRows {
-				logger.Error("Error checking block status in Facebook friend import.", zap.Error(err))
+				logger.Error("Error checking block status in friend import.", zap.Error(err), zap.String("provider", provider))
 				continue
 			}
 
@@ -889,7 +951,7 @@ WHERE (source_id = $1 AND destination_id = $2 AND (state = 1 OR state = 2))
 OR (source_id = $2 AND destination_id = $1 AND (state = 1 OR state = 2))
 `, friendID, userID)
 			if err != nil {
-				logger.Error("Error accepting invite in Facebook friend import.", zap.Error(err))
+				logger.Error("Error accepting invite in friend import.", zap.Error(err), zap.String("provider", provider))
 				continue
 			}
 			if rowsAffected, _ := res.RowsAffected(); rowsAffected == 2 {
@@ -913,7 +975,7 @@ AND NOT EXISTS
 ON CONFLICT (source_id, destination_id) DO NOTHING
 `, userID, friendID, position)
 			if err != nil {
-				logger.Error("Error adding new edges in Facebook friend import.", zap.Error(err))
+				logger.Error("Error adding new edges in friend import.", zap.Error(err), zap.String("provider", provider))
 				continue
 			}
 
@@ -928,7 +990,7 @@ AND EXISTS
    OR (source_id = $2::UUID AND destination_id = $1::UUID AND position = $3))
 `, userID, friendID, position)
 			if err != nil {
-				logger.Error("Error updating edge count in Facebook friend import.", zap.Error(err))
+				logger.Error("Error updating edge count in friend import.", zap.Error(err), zap.String("provider", provider))
 				continue
 			}
 			if rowsAffected, _ := res.RowsAffected(); rowsAffected == 2 {
@@ -940,8 +1002,8 @@ AND EXISTS
 		return nil
 	})
 	if err != nil {
-		logger.Error("Error importing Facebook friends.", zap.Error(err))
-		return status.Error(codes.Internal, "Error importing Facebook friends.")
+		logger.Error("Error importing friends.", zap.Error(err), zap.String("provider", provider))
+		return status.Error(codes.Internal, "Error importing "+provider+" friends.")
 	}
 
 	if len(friendUserIDs) != 0 {
@@ -0,0 +1,152 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/heroiclabs/nakama/v2/social"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const testSteamID = uint64(76561197960287930)
+
+// Start a mock Steam Web API responding to each known path with the given body, and to any other path with a 404.
+func newTestSteamServer(responses map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := responses[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+}
+
+func TestGetSteamProfileAppOwnership(t *testing.T) {
+	profile := `{"response":{"params":{"result":"OK","steamid":"` + strconv.FormatUint(testSteamID, 10) + `"}}}`
+	steamConfig := &SocialConfigSteam{PublisherKey: "publisher-key", AppID: 480, CheckAppOwnership: true}
+
+	for _, tc := range []struct {
+		name      string
+		ownership string
+		code      codes.Code
+	}{
+		{"owned", `{"appownership":{"ownsapp":true,"result":"OK"}}`, codes.OK},
+		{"not owned", `{"appownership":{"ownsapp":false,"result":"OK"}}`, codes.PermissionDenied},
+		{"check failed", `{"appownership":{"ownsapp":false,"result":"Failure"}}`, codes.Unauthenticated},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := newTestSteamServer(map[string]string{
+				"/ISteamUserAuth/AuthenticateUserTicket/v1/": profile,
+				"/ISteamUser/CheckAppOwnership/v2/":          tc.ownership,
+			})
+			defer server.Close()
+			client := social.NewClient(logger, 5*time.Second)
+			client.SetSteamURL(server.URL)
+
+			steamProfile, err := getSteamProfile(context.Background(), logger, client, steamConfig, "ticket")
+			assert.Equal(t, tc.code, status.Code(err))
+			if tc.code == codes.OK {
+				assert.Equal(t, testSteamID, steamProfile.SteamID)
+			}
+		})
+	}
+
+	// Ownership is not checked unless enabled.
+	server := newTestSteamServer(map[string]string{
+		"/ISteamUserAuth/AuthenticateUserTicket/v1/": profile,
+	})
+	defer server.Close()
+	client := social.NewClient(logger, 5*time.Second)
+	client.SetSteamURL(server.URL)
+
+	steamProfile, err := getSteamProfile(context.Background(), logger, client, &SocialConfigSteam{PublisherKey: "publisher-key", AppID: 480}, "ticket")
+	if err != nil {
+		t.Fatalf("error getting steam profile: %v", err.Error())
+	}
+	assert.Equal(t, testSteamID, steamProfile.SteamID)
+}
+
+func TestImportSteamFriends(t *testing.T) {
+	db := NewDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+
+	// The importing user, a Steam friend with an account, and an existing friend who is not a Steam friend.
+	uids := make([]uuid.UUID, 3)
+	for i := range uids {
+		uids[i] = uuid.Must(uuid.NewV4())
+		InsertUser(t, db, uids[i])
+	}
+	userID, steamFriendID, otherFriendID := uids[0], uids[1], uids[2]
+	userSteamID := strconv.FormatUint(testSteamID, 10)
+	friendSteamID := "76561197960265731"
+	for id, steamID := range map[uuid.UUID]string{userID: userSteamID, steamFriendID: friendSteamID} {
+		if _, err := db.Exec("UPDATE users SET steam_id = $1 WHERE id = $2", steamID, id); err != nil {
+			t.Fatalf("error linking steam id: %v", err.Error())
+		}
+	}
+	for source, destination := range map[uuid.UUID]uuid.UUID{userID: otherFriendID, otherFriendID: userID} {
+		if err := AddFriends(ctx, logger, db, &DummyMessageRouter{}, source, source.String(), []string{destination.String()}); err != nil {
+			t.Fatalf("error adding friend: %v", err.Error())
+		}
+	}
+
+	server := newTestSteamServer(map[string]string{
+		"/ISteamUser/GetFriendList/v1/": `{"friendslist":{"friends":[{"steamid":"` + friendSteamID + `"},{"steamid":"76561197960265738"}]}}`,
+	})
+	defer server.Close()
+	client := social.NewClient(logger, 5*time.Second)
+	client.SetSteamURL(server.URL)
+
+	friendIDs := func() []string {
+		friends, err := ListFriends(ctx, logger, db, &LocalTracker{}, userID, 100, &wrappers.Int32Value{Value: 0}, "")
+		if err != nil {
+			t.Fatalf("error listing friends: %v", err.Error())
+		}
+		ids := make([]string, 0, len(friends.Friends))
+		for _, friend := range friends.Friends {
+			ids = append(ids, friend.User.Id)
+		}
+		return ids
+	}
+
+	// Steam friends with accounts are added as mutual friends alongside existing friends.
+	if err := importSteamFriends(ctx, logger, db, &DummyMessageRouter{}, client, "publisher-key", userID, false); err != nil {
+		t.Fatalf("error importing steam friends: %v", err.Error())
+	}
+	assert.ElementsMatch(t, []string{steamFriendID.String(), otherFriendID.String()}, friendIDs())
+
+	// A reset replaces existing friends with the Steam friends.
+	if err := importSteamFriends(ctx, logger, db, &DummyMessageRouter{}, client, "publisher-key", userID, true); err != nil {
+		t.Fatalf("error importing steam friends: %v", err.Error())
+	}
+	assert.ElementsMatch(t, []string{steamFriendID.String()}, friendIDs())
+
+	// Users without a linked Steam ID cannot import.
+	err := importSteamFriends(ctx, logger, db, &DummyMessageRouter{}, client, "publisher-key", otherFriendID, false)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
@@ -20,6 +20,7 @@ import (
 	"database/sql"
 	"encoding/base64"
 	"encoding/gob"
+	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
@@ -28,15 +29,19 @@ import (
 	"github.com/golang/protobuf/ptypes/timestamp"
 	"github.com/golang/protobuf/ptypes/wrappers"
 	"github.com/heroiclabs/nakama-common/api"
+	"github.com/heroiclabs/nakama-common/rtapi"
 	"github.com/jackc/pgx/pgtype"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 )
 
 var (
-	ErrChannelIDInvalid     = errors.New("invalid channel id")
-	ErrChannelCursorInvalid = errors.New("invalid channel cursor")
-	ErrChannelGroupNotFound = errors.New("group not found")
+	ErrChannelIDInvalid             = errors.New("invalid channel id")
+	ErrChannelCursorInvalid         = errors.New("invalid channel cursor")
+	ErrChannelGroupNotFound         = errors.New("group not found")
+	ErrChannelMessageIDInvalid      = errors.New("invalid channel message id")
+	ErrChannelMessageContentInvalid = errors.New("channel message content must be a valid JSON object")
+	ErrChannelMessageNotFound       = errors.New("channel message not found")
 )
 
 // Wrapper type to avoid allocating a stream struct when the input is invalid.
@@ -240,16 +245,135 @@ WHERE stream_mode = $1 AND stream_subject = $2::UUID AND stream_descriptor = $3:
 	}, nil
 }
 
-func GetChannelMessages(ctx context.Context, logger *zap.Logger, db *sql.DB, userID uuid.UUID) ([]*api.ChannelMessage, error) {
-	query := "SELECT id, code, username, stream_mode, stream_subject, stream_descriptor, stream_label, content, create_time, update_time FROM message WHERE sender_id = $1::UUID"
-	rows, err := db.QueryContext(ctx, query, userID)
+// ChannelMessageUpdate replaces the content of a persisted channel message, and broadcasts the update to the channel.
+// If callerID is not uuid.Nil the caller must be the original sender of the message.
+func ChannelMessageUpdate(ctx context.Context, logger *zap.Logger, db *sql.DB, router MessageRouter, callerID uuid.UUID, channelID, messageID, content string) (*api.ChannelMessage, error) {
+	if _, err := uuid.FromString(messageID); err != nil {
+		return nil, ErrChannelMessageIDInvalid
+	}
+	streamConversionResult, err := ChannelIdToStream(channelID)
+	if err != nil {
+		return nil, ErrChannelIDInvalid
+	}
+	if maybeJSON := []byte(content); !json.Valid(maybeJSON) || bytes.TrimSpace(maybeJSON)[0] != byteBracket {
+		return nil, ErrChannelMessageContentInvalid
+	}
+	stream := streamConversionResult.Stream
+
+	query := `UPDATE message SET content = $6, update_time = now()
+WHERE id = $1 AND stream_mode = $2 AND stream_subject = $3::UUID AND stream_descriptor = $4::UUID AND stream_label = $5`
+	params := []interface{}{messageID, stream.Mode, stream.Subject, stream.Subcontext, stream.Label, content}
+	if callerID != uuid.Nil {
+		query += " AND sender_id = $7"
+		params = append(params, callerID)
+	}
+	query += " RETURNING sender_id, username, create_time, update_time"
+
+	var dbSenderID string
+	var dbUsername string
+	var dbCreateTime pgtype.Timestamptz
+	var dbUpdateTime pgtype.Timestamptz
+	if err := db.QueryRowContext(ctx, query, params...).Scan(&dbSenderID, &dbUsername, &dbCreateTime, &dbUpdateTime); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrChannelMessageNotFound
+		}
+		logger.Error("Error updating channel message", zap.Error(err), zap.String("channel_id", channelID), zap.String("message_id", messageID))
+		return nil, err
+	}
+
+	message := &api.ChannelMessage{
+		ChannelId:  channelID,
+		MessageId:  messageID,
+		Code:       &wrappers.Int32Value{Value: ChannelMessageTypeChatUpdate},
+		SenderId:   dbSenderID,
+		Username:   dbUsername,
+		Content:    content,
+		CreateTime: &timestamp.Timestamp{Seconds: dbCreateTime.Time.Unix()},
+		UpdateTime: &timestamp.Timestamp{Seconds: dbUpdateTime.Time.Unix()},
+		Persistent: &wrappers.BoolValue{Value: true},
+	}
+	channelMessageSetStream(message, stream)
+
+	router.SendToStream(logger, stream, &rtapi.Envelope{Message: &rtapi.Envelope_ChannelMessage{ChannelMessage: message}}, true)
+
+	return message, nil
+}
+
+// ChannelMessageRemove deletes a persisted channel message, and broadcasts the removal to the channel.
+// If callerID is not uuid.Nil the caller must be the original sender of the message.
+func ChannelMessageRemove(ctx context.Context, logger *zap.Logger, db *sql.DB, router MessageRouter, callerID uuid.UUID, channelID, messageID string) (*api.ChannelMessage, error) {
+	if _, err := uuid.FromString(messageID); err != nil {
+		return nil, ErrChannelMessageIDInvalid
+	}
+	streamConversionResult, err := ChannelIdToStream(channelID)
+	if err != nil {
+		return nil, ErrChannelIDInvalid
+	}
+	stream := streamConversionResult.Stream
+
+	query := `DELETE FROM message
+WHERE id = $1 AND stream_mode = $2 AND stream_subject = $3::UUID AND stream_descriptor = $4::UUID AND stream_label = $5`
+	params := []interface{}{messageID, stream.Mode, stream.Subject, stream.Subcontext, stream.Label}
+	if callerID != uuid.Nil {
+		query += " AND sender_id = $6"
+		params = append(params, callerID)
+	}
+	query += " RETURNING sender_id, username, create_time"
+
+	var dbSenderID string
+	var dbUsername string
+	var dbCreateTime pgtype.Timestamptz
+	if err := db.QueryRowContext(ctx, query, params...).Scan(&dbSenderID, &dbUsername, &dbCreateTime); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrChannelMessageNotFound
+		}
+		logger.Error("Error removing channel message", zap.Error(err), zap.String("channel_id", channelID), zap.String("message_id", messageID))
+		return nil, err
+	}
+
+	message := &api.ChannelMessage{
+		ChannelId:  channelID,
+		MessageId:  messageID,
+		Code:       &wrappers.Int32Value{Value: ChannelMessageTypeChatRemove},
+		SenderId:   dbSenderID,
+		Username:   dbUsername,
+		Content:    "{}",
+		CreateTime: &timestamp.Timestamp{Seconds: dbCreateTime.Time.Unix()},
+		UpdateTime: &timestamp.Timestamp{Seconds: time.Now().Unix()},
+		Persistent: &wrappers.BoolValue{Value: true},
+	}
+	channelMessageSetStream(message, stream)
+
+	router.SendToStream(logger, stream, &rtapi.Envelope{Message: &rtapi.Envelope_ChannelMessage{ChannelMessage: message}}, true)
+
+	return message, nil
+}
+
+// Set the room, group, or direct message user fields of a channel message based on the channel's stream.
+func channelMessageSetStream(message *api.ChannelMessage, stream PresenceStream) {
+	switch stream.Mode {
+	case StreamModeChannel:
+		message.RoomName = stream.Label
+	case StreamModeGroup:
+		message.GroupId = stream.Subject.String()
+	case StreamModeDM:
+		message.UserIdOne = stream.Subject.String()
+		message.UserIdTwo = stream.Subcontext.String()
+	}
+}
+
+// GetChannelMessages returns up to limit messages sent by a user, ordered by message ID and starting after afterID. Use
+// uuid.Nil to start from the first message.
+func GetChannelMessages(ctx context.Context, logger *zap.Logger, db *sql.DB, userID uuid.UUID, limit int, afterID uuid.UUID) ([]*api.ChannelMessage, error) {
+	query := "SELECT id, code, username, stream_mode, stream_subject, stream_descriptor, stream_label, content, create_time, update_time FROM message WHERE sender_id = $1::UUID AND id > $2::UUID ORDER BY id ASC LIMIT $3"
+	rows, err := db.QueryContext(ctx, query, userID, afterID, limit)
 	if err != nil {
 		logger.Error("Error listing channel messages for user", zap.String("user_id", userID.String()), zap.Error(err))
 		return nil, err
 	}
 	defer rows.Close()
 
-	messages := make([]*api.ChannelMessage, 0, 100)
+	messages := make([]*api.ChannelMessage, 0, limit)
 	var dbID string
 	var dbCode int32
 	var dbUsername string
@@ -0,0 +1,120 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/heroiclabs/nakama-common/api"
+	"github.com/heroiclabs/nakama-common/rtapi"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+type streamRecordingMessageRouter struct {
+	DummyMessageRouter
+	streams  []PresenceStream
+	messages []*api.ChannelMessage
+}
+
+func (r *streamRecordingMessageRouter) SendToStream(logger *zap.Logger, stream PresenceStream, envelope *rtapi.Envelope, reliable bool) {
+	r.streams = append(r.streams, stream)
+	r.messages = append(r.messages, envelope.GetChannelMessage())
+}
+
+func TestChannelMessageUpdateRemove(t *testing.T) {
+	db := NewDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	router := &streamRecordingMessageRouter{}
+
+	senderID := uuid.Must(uuid.NewV4())
+	otherID := uuid.Must(uuid.NewV4())
+	InsertUser(t, db, senderID)
+	InsertUser(t, db, otherID)
+
+	stream := PresenceStream{Mode: StreamModeChannel, Label: uuid.Must(uuid.NewV4()).String()}
+	channelID, err := StreamToChannelId(stream)
+	if err != nil {
+		t.Fatalf("error building channel id: %v", err)
+	}
+
+	messageID := uuid.Must(uuid.NewV4()).String()
+	_, err = db.ExecContext(ctx, `INSERT INTO message (id, code, sender_id, username, stream_mode, stream_subject, stream_descriptor, stream_label, content)
+VALUES ($1, $2, $3, $4, $5, $6::UUID, $7::UUID, $8, $9)`, messageID, ChannelMessageTypeChat, senderID, senderID.String(), stream.Mode, stream.Subject, stream.Subcontext, stream.Label, `{"text":"hello"}`)
+	if err != nil {
+		t.Fatalf("error inserting message: %v", err)
+	}
+
+	// Content must be an object.
+	_, err = ChannelMessageUpdate(ctx, logger, db, router, uuid.Nil, channelID, messageID, `"text"`)
+	assert.Equal(t, ErrChannelMessageContentInvalid, err)
+
+	// Only the sender may update their message when a sender is given.
+	_, err = ChannelMessageUpdate(ctx, logger, db, router, otherID, channelID, messageID, `{"text":"edited"}`)
+	assert.Equal(t, ErrChannelMessageNotFound, err)
+	assert.Empty(t, router.messages)
+
+	message, err := ChannelMessageUpdate(ctx, logger, db, router, senderID, channelID, messageID, `{"text":"edited"}`)
+	if err != nil {
+		t.Fatalf("error updating message: %v", err)
+	}
+	assert.Equal(t, senderID.String(), message.SenderId)
+	assert.Equal(t, ChannelMessageTypeChatUpdate, message.Code.Value)
+	assert.Equal(t, stream.Label, message.RoomName)
+
+	// The update is broadcast to the channel.
+	if assert.Len(t, router.messages, 1) {
+		assert.Equal(t, stream, router.streams[0])
+		assert.Equal(t, messageID, router.messages[0].MessageId)
+		assert.Equal(t, `{"text":"edited"}`, router.messages[0].Content)
+	}
+
+	list, err := ChannelMessagesList(ctx, logger, db, senderID, stream, channelID, 10, true, "")
+	if err != nil {
+		t.Fatalf("error listing messages: %v", err)
+	}
+	if assert.Len(t, list.Messages, 1) {
+		assert.JSONEq(t, `{"text":"edited"}`, list.Messages[0].Content)
+	}
+
+	// Messages must belong to the given channel.
+	otherChannelID, _ := StreamToChannelId(PresenceStream{Mode: StreamModeChannel, Label: "other"})
+	_, err = ChannelMessageRemove(ctx, logger, db, router, uuid.Nil, otherChannelID, messageID)
+	assert.Equal(t, ErrChannelMessageNotFound, err)
+
+	// Without a sender the runtime may moderate any message.
+	message, err = ChannelMessageRemove(ctx, logger, db, router, uuid.Nil, channelID, messageID)
+	if err != nil {
+		t.Fatalf("error removing message: %v", err)
+	}
+	assert.Equal(t, ChannelMessageTypeChatRemove, message.Code.Value)
+	if assert.Len(t, router.messages, 2) {
+		assert.Equal(t, messageID, router.messages[1].MessageId)
+		assert.Equal(t, ChannelMessageTypeChatRemove, router.messages[1].Code.Value)
+	}
+
+	list, err = ChannelMessagesList(ctx, logger, db, senderID, stream, channelID, 10, true, "")
+	if err != nil {
+		t.Fatalf("error listing messages: %v", err)
+	}
+	assert.Empty(t, list.Messages)
+
+	_, err = ChannelMessageRemove(ctx, logger, db, router, uuid.Nil, channelID, messageID)
+	assert.Equal(t, ErrChannelMessageNotFound, err)
+}
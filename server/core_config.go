@@ -0,0 +1,50 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import "errors"
+
+var ErrConfigKeyNotAllowed = errors.New("config key not allowed")
+
+// Configuration values runtime code is allowed to read, keyed by their dotted YAML path. Keys are added individually
+// so secrets such as encryption keys, server keys, credentials and database addresses are never exposed.
+var safeConfigValues = map[string]func(Config) interface{}{
+	"name":                            func(c Config) interface{} { return c.GetName() },
+	"shutdown_grace_sec":              func(c Config) interface{} { return c.GetShutdownGraceSec() },
+	"logger.level":                    func(c Config) interface{} { return c.GetLogger().Level },
+	"session.token_expiry_sec":        func(c Config) interface{} { return c.GetSession().TokenExpirySec },
+	"socket.max_message_size_bytes":   func(c Config) interface{} { return c.GetSocket().MaxMessageSizeBytes },
+	"socket.max_request_size_bytes":   func(c Config) interface{} { return c.GetSocket().MaxRequestSizeBytes },
+	"social.steam.app_id":             func(c Config) interface{} { return c.GetSocial().Steam.AppID },
+	"social.apple.bundle_id":          func(c Config) interface{} { return c.GetSocial().Apple.BundleId },
+	"runtime.event_queue_size":        func(c Config) interface{} { return c.GetRuntime().EventQueueSize },
+	"match.input_queue_size":          func(c Config) interface{} { return c.GetMatch().InputQueueSize },
+	"match.call_queue_size":           func(c Config) interface{} { return c.GetMatch().CallQueueSize },
+	"match.join_attempt_queue_size":   func(c Config) interface{} { return c.GetMatch().JoinAttemptQueueSize },
+	"match.deferred_queue_size":       func(c Config) interface{} { return c.GetMatch().DeferredQueueSize },
+	"match.max_empty_sec":             func(c Config) interface{} { return c.GetMatch().MaxEmptySec },
+	"tracker.event_queue_size":        func(c Config) interface{} { return c.GetTracker().EventQueueSize },
+	"leaderboard.callback_queue_size": func(c Config) interface{} { return c.GetLeaderboard().CallbackQueueSize },
+}
+
+// GetSafeConfigValue returns the value of a single configuration key if it's on the allowlist of values that are safe
+// to expose to runtime code, or ErrConfigKeyNotAllowed otherwise.
+func GetSafeConfigValue(config Config, key string) (interface{}, error) {
+	fn, found := safeConfigValues[key]
+	if !found {
+		return nil, ErrConfigKeyNotAllowed
+	}
+	return fn(config), nil
+}
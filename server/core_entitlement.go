@@ -0,0 +1,145 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/heroiclabs/nakama-common/api"
+	"go.uber.org/zap"
+)
+
+// Storage collection validated entitlements are recorded in, as system-owned objects that clients cannot read or write.
+const EntitlementStorageCollection = "entitlements"
+
+var (
+	ErrEntitlementProviderNotFound = errors.New("entitlement provider not found")
+	ErrEntitlementInvalid          = errors.New("entitlement validator returned no product id")
+)
+
+// Entitlement is the result of validating a receipt with a store provider.
+type Entitlement struct {
+	ProductID string `json:"product_id"`
+	// Optional, identifies the purchase within the provider. Entitlements with the same transaction ID replace each
+	// other when recorded, otherwise the product ID is used.
+	TransactionID string `json:"transaction_id,omitempty"`
+	// Optional unix time the entitlement expires at, or 0 if it does not.
+	ExpiryTime int64                  `json:"expiry_time,omitempty"`
+	Metadata   map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// ValidatedEntitlement is the entitlement record stored for a user.
+type ValidatedEntitlement struct {
+	*Entitlement
+	Provider     string `json:"provider"`
+	UserID       string `json:"user_id"`
+	ValidateTime int64  `json:"validate_time"`
+}
+
+// RuntimeEntitlementValidatorFunction validates a receipt for a user with a store provider. An error means the
+// receipt was rejected or could not be checked.
+type RuntimeEntitlementValidatorFunction func(ctx context.Context, userID, receipt string) (*Entitlement, error)
+
+// RuntimeEntitlementValidators holds the entitlement validators registered by runtime modules, by provider name. It
+// is shared across runtimes so validators registered in one are usable from all of them.
+type RuntimeEntitlementValidators struct {
+	sync.RWMutex
+	validators map[string]RuntimeEntitlementValidatorFunction
+}
+
+func NewRuntimeEntitlementValidators() *RuntimeEntitlementValidators {
+	return &RuntimeEntitlementValidators{
+		validators: make(map[string]RuntimeEntitlementValidatorFunction),
+	}
+}
+
+// Register a validator for a provider, replacing any existing validator for that provider.
+func (v *RuntimeEntitlementValidators) Register(provider string, fn RuntimeEntitlementValidatorFunction) {
+	v.Lock()
+	v.validators[provider] = fn
+	v.Unlock()
+}
+
+func (v *RuntimeEntitlementValidators) Get(provider string) RuntimeEntitlementValidatorFunction {
+	v.RLock()
+	fn := v.validators[provider]
+	v.RUnlock()
+	return fn
+}
+
+// ValidateEntitlement dispatches a receipt to the validator registered for the provider and records the resulting
+// entitlement in storage for the user.
+func ValidateEntitlement(ctx context.Context, logger *zap.Logger, db *sql.DB, validators *RuntimeEntitlementValidators, provider string, userID uuid.UUID, receipt string) (*ValidatedEntitlement, error) {
+	fn := validators.Get(provider)
+	if fn == nil {
+		return nil, ErrEntitlementProviderNotFound
+	}
+
+	entitlement, err := fn(ctx, userID.String(), receipt)
+	if err != nil {
+		return nil, err
+	}
+	if entitlement == nil || entitlement.ProductID == "" {
+		return nil, ErrEntitlementInvalid
+	}
+
+	validated := &ValidatedEntitlement{
+		Entitlement:  entitlement,
+		Provider:     provider,
+		UserID:       userID.String(),
+		ValidateTime: time.Now().UTC().Unix(),
+	}
+	value, err := json.Marshal(validated)
+	if err != nil {
+		logger.Error("Could not encode validated entitlement", zap.Error(err))
+		return nil, err
+	}
+
+	key := entitlement.TransactionID
+	if key == "" {
+		key = entitlement.ProductID
+	}
+	ops := StorageOpWrites{&StorageOpWrite{
+		OwnerID: uuid.Nil.String(),
+		Object: &api.WriteStorageObject{
+			Collection:      EntitlementStorageCollection,
+			Key:             entitlementStorageKey(userID, provider, key),
+			Value:           string(value),
+			PermissionRead:  &wrappers.Int32Value{Value: 0},
+			PermissionWrite: &wrappers.Int32Value{Value: 0},
+		},
+	}}
+	if _, _, err := StorageWriteObjects(ctx, logger, db, true, 0, ops); err != nil {
+		logger.Error("Could not record validated entitlement", zap.String("provider", provider), zap.String("user_id", userID.String()), zap.Error(err))
+		return nil, err
+	}
+
+	return validated, nil
+}
+
+// Entitlement keys start with the user ID. Providers and transaction IDs are not bounded in length, so they are hashed
+// to keep the key within the storage key length limit.
+func entitlementStorageKey(userID uuid.UUID, provider, key string) string {
+	return fmt.Sprintf("%v.%x", userID, md5.Sum([]byte(provider+":"+key)))
+}
@@ -0,0 +1,93 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/heroiclabs/nakama-common/api"
+	"github.com/heroiclabs/nakama-common/runtime"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateEntitlement(t *testing.T) {
+	db := NewDB(t)
+
+	userID, _, _, err := AuthenticateCustom(context.Background(), logger, db, uuid.Must(uuid.NewV4()).String(), uuid.Must(uuid.NewV4()).String(), true)
+	if err != nil {
+		t.Fatalf("error creating user: %v", err.Error())
+	}
+	uid := uuid.FromStringOrNil(userID)
+
+	// Register a fake store through the Go runtime initializer.
+	validators := NewRuntimeEntitlementValidators()
+	initializer := &RuntimeGoInitializer{logger: NewRuntimeGoLogger(logger), db: db, entitlementValidators: validators}
+	err = initializer.RegisterEntitlementValidator("fakestore", func(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, userID, receipt string) (string, string, int64, map[string]interface{}, error) {
+		if receipt != "valid-receipt" {
+			return "", "", 0, nil, errors.New("receipt rejected")
+		}
+		return "gold_pack", "tx-1", 0, map[string]interface{}{"user_id": userID}, nil
+	})
+	if err != nil {
+		t.Fatalf("error registering entitlement validator: %v", err.Error())
+	}
+
+	_, err = ValidateEntitlement(context.Background(), logger, db, validators, "otherstore", uid, "valid-receipt")
+	assert.Equal(t, ErrEntitlementProviderNotFound, err)
+
+	_, err = ValidateEntitlement(context.Background(), logger, db, validators, "fakestore", uid, "invalid-receipt")
+	assert.EqualError(t, err, "receipt rejected")
+
+	validated, err := ValidateEntitlement(context.Background(), logger, db, validators, "fakestore", uid, "valid-receipt")
+	if err != nil {
+		t.Fatalf("error validating entitlement: %v", err.Error())
+	}
+	assert.Equal(t, "fakestore", validated.Provider)
+	assert.Equal(t, userID, validated.UserID)
+	assert.Equal(t, "gold_pack", validated.ProductID)
+	assert.Equal(t, map[string]interface{}{"user_id": userID}, validated.Metadata)
+
+	// The validated entitlement is recorded in system-owned storage, hidden from the user.
+	readIDs := []*api.ReadStorageObjectId{{
+		Collection: EntitlementStorageCollection,
+		Key:        entitlementStorageKey(uid, "fakestore", "tx-1"),
+		UserId:     uuid.Nil.String(),
+	}}
+	objects, err := StorageReadObjects(context.Background(), logger, db, uid, readIDs)
+	if err != nil {
+		t.Fatalf("error reading entitlement: %v", err.Error())
+	}
+	assert.Len(t, objects.Objects, 0)
+	objects, err = StorageReadObjects(context.Background(), logger, db, uuid.Nil, readIDs)
+	if err != nil {
+		t.Fatalf("error reading entitlement: %v", err.Error())
+	}
+	if assert.Len(t, objects.Objects, 1) {
+		assert.EqualValues(t, 0, objects.Objects[0].PermissionRead)
+		assert.EqualValues(t, 0, objects.Objects[0].PermissionWrite)
+		recorded := &ValidatedEntitlement{}
+		if err := json.Unmarshal([]byte(objects.Objects[0].Value), recorded); err != nil {
+			t.Fatalf("error decoding entitlement: %v", err.Error())
+		}
+		assert.Equal(t, validated.ProductID, recorded.ProductID)
+		assert.Equal(t, validated.TransactionID, recorded.TransactionID)
+		assert.Equal(t, validated.Provider, recorded.Provider)
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+// ParseFeatureFlags converts feature flag config entries in the form "flag=variant1,variant2" into a map of flag
+// names to their ordered variants.
+func ParseFeatureFlags(entries []string) (map[string][]string, error) {
+	flags := make(map[string][]string, len(entries))
+	for _, entry := range entries {
+		kv := strings.SplitN(entry, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("feature flag '%v' must be in the form 'flag=variant1,variant2'", entry)
+		}
+		if _, found := flags[kv[0]]; found {
+			return nil, fmt.Errorf("feature flag '%v' is defined more than once", kv[0])
+		}
+		variants := strings.Split(kv[1], ",")
+		for _, variant := range variants {
+			if variant == "" {
+				return nil, fmt.Errorf("feature flag '%v' must not have empty variants", kv[0])
+			}
+		}
+		flags[kv[0]] = variants
+	}
+	return flags, nil
+}
+
+// FeatureFlagVariant deterministically assigns a user to one of the given variants of a flag.
+// The same user always gets the same variant as long as the flag's variants don't change.
+func FeatureFlagVariant(userID, flag string, variants []string) string {
+	if len(variants) == 0 {
+		return ""
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(flag))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write([]byte(userID))
+	return variants[h.Sum64()%uint64(len(variants))]
+}
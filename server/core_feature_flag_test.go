@@ -0,0 +1,60 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFeatureFlags(t *testing.T) {
+	flags, err := ParseFeatureFlags([]string{"shop_layout=control,grid,carousel", "new_tutorial=off,on"})
+	if err != nil {
+		t.Fatalf("error parsing feature flags: %v", err)
+	}
+	assert.Equal(t, []string{"control", "grid", "carousel"}, flags["shop_layout"])
+	assert.Equal(t, []string{"off", "on"}, flags["new_tutorial"])
+
+	for _, entries := range [][]string{{"no_variants"}, {"=a,b"}, {"flag=a,,b"}, {"flag=a", "flag=b"}} {
+		_, err := ParseFeatureFlags(entries)
+		assert.Error(t, err, entries)
+	}
+}
+
+func TestFeatureFlagVariant(t *testing.T) {
+	variants := []string{"control", "grid", "carousel"}
+	counts := make(map[string]int, len(variants))
+
+	const users = 30000
+	for i := 0; i < users; i++ {
+		userID := uuid.Must(uuid.NewV4()).String()
+		variant := FeatureFlagVariant(userID, "shop_layout", variants)
+		assert.Contains(t, variants, variant)
+
+		// The same user always gets the same variant.
+		assert.Equal(t, variant, FeatureFlagVariant(userID, "shop_layout", variants))
+		counts[variant]++
+	}
+
+	// Roughly even distribution, each variant within 10% of its expected share.
+	expected := users / len(variants)
+	for _, variant := range variants {
+		assert.InDelta(t, expected, counts[variant], float64(expected)/10, variant)
+	}
+
+	assert.Equal(t, "", FeatureFlagVariant(uuid.Must(uuid.NewV4()).String(), "empty", nil))
+}
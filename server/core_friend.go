@@ -38,6 +38,14 @@ import (
 
 var ErrFriendInvalidCursor = errors.New("friend cursor invalid")
 
+// Number of relationships a user has in each friend state.
+type FriendsCount struct {
+	Friends        int
+	InviteSent     int
+	InviteReceived int
+	Blocked        int
+}
+
 type edgeListCursor struct {
 	// ID fields.
 	State    int64
@@ -208,6 +216,44 @@ FROM users, user_edge WHERE id = destination_id AND source_id = $1`
 	return &api.FriendList{Friends: friends, Cursor: outgoingCursor}, nil
 }
 
+func CountFriends(ctx context.Context, logger *zap.Logger, db *sql.DB, userID uuid.UUID) (*FriendsCount, error) {
+	query := "SELECT state, count(*) FROM user_edge WHERE source_id = $1 GROUP BY state"
+
+	rows, err := db.QueryContext(ctx, query, userID)
+	if err != nil {
+		logger.Error("Error counting friends.", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := &FriendsCount{}
+	for rows.Next() {
+		var state int64
+		var count int
+		if err = rows.Scan(&state, &count); err != nil {
+			logger.Error("Error counting friends.", zap.Error(err))
+			return nil, err
+		}
+
+		switch state {
+		case 0:
+			counts.Friends = count
+		case 1:
+			counts.InviteSent = count
+		case 2:
+			counts.InviteReceived = count
+		case 3:
+			counts.Blocked = count
+		}
+	}
+	if err = rows.Err(); err != nil {
+		logger.Error("Error counting friends.", zap.Error(err))
+		return nil, err
+	}
+
+	return counts, nil
+}
+
 func AddFriends(ctx context.Context, logger *zap.Logger, db *sql.DB, messageRouter MessageRouter, userID uuid.UUID, username string, friendIDs []string) error {
 	uniqueFriendIDs := make(map[string]struct{})
 	for _, fid := range friendIDs {
@@ -0,0 +1,70 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountFriends(t *testing.T) {
+	db := NewDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	router := &DummyMessageRouter{}
+
+	uids := make([]uuid.UUID, 5)
+	for i := range uids {
+		uids[i] = uuid.Must(uuid.NewV4())
+		InsertUser(t, db, uids[i])
+	}
+	userID, friendID, sentID, receivedID, blockedID := uids[0], uids[1], uids[2], uids[3], uids[4]
+
+	// Mutual friend.
+	if err := AddFriends(ctx, logger, db, router, userID, userID.String(), []string{friendID.String()}); err != nil {
+		t.Fatalf("error adding friend: %v", err.Error())
+	}
+	if err := AddFriends(ctx, logger, db, router, friendID, friendID.String(), []string{userID.String()}); err != nil {
+		t.Fatalf("error adding friend: %v", err.Error())
+	}
+	// Outgoing and incoming invites.
+	if err := AddFriends(ctx, logger, db, router, userID, userID.String(), []string{sentID.String()}); err != nil {
+		t.Fatalf("error adding friend: %v", err.Error())
+	}
+	if err := AddFriends(ctx, logger, db, router, receivedID, receivedID.String(), []string{userID.String()}); err != nil {
+		t.Fatalf("error adding friend: %v", err.Error())
+	}
+	// Blocked user.
+	if err := BlockFriends(ctx, logger, db, userID, []string{blockedID.String()}); err != nil {
+		t.Fatalf("error blocking friend: %v", err.Error())
+	}
+
+	counts, err := CountFriends(ctx, logger, db, userID)
+	if err != nil {
+		t.Fatalf("error counting friends: %v", err.Error())
+	}
+	assert.Equal(t, &FriendsCount{Friends: 1, InviteSent: 1, InviteReceived: 1, Blocked: 1}, counts)
+
+	// The blocked user sees no relationship.
+	counts, err = CountFriends(ctx, logger, db, blockedID)
+	if err != nil {
+		t.Fatalf("error counting friends: %v", err.Error())
+	}
+	assert.Equal(t, &FriendsCount{}, counts)
+}
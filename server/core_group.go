@@ -22,6 +22,7 @@ import (
 	"encoding/gob"
 	"encoding/json"
 	"fmt"
+	"math/rand"
 	"strconv"
 	"strings"
 	"time"
@@ -409,10 +410,19 @@ WHERE (id = $1) AND (disable_time = '1970-01-01 00:00:00 UTC')`
 		}
 
 		query = "UPDATE groups SET edge_count = edge_count + 1, update_time = now() WHERE id = $1::UUID AND edge_count+1 <= max_count"
-		if _, err = tx.ExecContext(ctx, query, groupID); err != nil {
+		res, err := tx.ExecContext(ctx, query, groupID)
+		if err != nil {
 			logger.Debug("Could not update group edge_count.", zap.String("group_id", groupID.String()), zap.String("user_id", userID.String()))
 			return err
 		}
+		if rowsAffected, err := res.RowsAffected(); err != nil {
+			logger.Debug("Could not update group edge_count.", zap.Error(err), zap.String("group_id", groupID.String()), zap.String("user_id", userID.String()))
+			return err
+		} else if rowsAffected == 0 {
+			// The group filled up since it was checked above, don't add the user without counting them.
+			logger.Info("Could not join group as group maximum count was reached.", zap.String("group_id", groupID.String()), zap.String("user_id", userID.String()))
+			return ErrGroupFull
+		}
 
 		query = `INSERT INTO message (id, code, sender_id, username, stream_mode, stream_subject, stream_descriptor, stream_label, content, create_time, update_time)
 VALUES ($1, $2, $3, $4, $5, $6::UUID, $7::UUID, $8, $9, $10, $10)`
@@ -1466,6 +1476,49 @@ WHERE u.id = ge.destination_id AND ge.source_id = $1`
 	return &api.GroupUserList{GroupUsers: groupUsers, Cursor: outgoingCursor}, nil
 }
 
+// Look up the presences of each listed online group member, one per connected session, keyed by user ID. Sessions
+// that follow their own status stream are represented by that presence so their current status is included.
+// Offline members have no entry.
+func ListGroupUsersPresences(tracker Tracker, groupUsers []*api.GroupUserList_GroupUser) map[string][]*Presence {
+	presences := make(map[string][]*Presence, len(groupUsers))
+	for _, groupUser := range groupUsers {
+		if !groupUser.User.Online {
+			continue
+		}
+		userID, err := uuid.FromString(groupUser.User.Id)
+		if err != nil {
+			continue
+		}
+
+		sessions := make([]uuid.UUID, 0, 1)
+		bySession := make(map[uuid.UUID]*Presence, 1)
+		for _, p := range tracker.ListByUserID(userID) {
+			switch p.Stream.Mode {
+			case StreamModeNotifications:
+				if _, found := bySession[p.ID.SessionID]; !found {
+					sessions = append(sessions, p.ID.SessionID)
+					bySession[p.ID.SessionID] = p
+				}
+			case StreamModeStatus:
+				if p.Stream.Subject != userID {
+					continue
+				}
+				if _, found := bySession[p.ID.SessionID]; !found {
+					sessions = append(sessions, p.ID.SessionID)
+				}
+				bySession[p.ID.SessionID] = p
+			}
+		}
+
+		userPresences := make([]*Presence, 0, len(sessions))
+		for _, sessionID := range sessions {
+			userPresences = append(userPresences, bySession[sessionID])
+		}
+		presences[groupUser.User.Id] = userPresences
+	}
+	return presences
+}
+
 func ListUserGroups(ctx context.Context, logger *zap.Logger, db *sql.DB, userID uuid.UUID, limit int, state *wrappers.Int32Value, cursor string) (*api.UserGroupList, error) {
 	var incomingCursor *edgeListCursor
 	if cursor != "" {
@@ -1595,6 +1648,21 @@ WHERE g.id = ge.destination_id AND ge.source_id = $1`
 	return &api.UserGroupList{UserGroups: userGroups, Cursor: outgoingCursor}, nil
 }
 
+// GetGroupEdgeCount returns the number of members in a group, excluding join requests and banned users, without
+// listing them.
+func GetGroupEdgeCount(ctx context.Context, logger *zap.Logger, db *sql.DB, groupID uuid.UUID) (int, error) {
+	var edgeCount int
+	query := "SELECT edge_count FROM groups WHERE id = $1::UUID AND disable_time = '1970-01-01 00:00:00 UTC'"
+	if err := db.QueryRowContext(ctx, query, groupID).Scan(&edgeCount); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, ErrGroupNotFound
+		}
+		logger.Error("Could not look up group edge count.", zap.Error(err), zap.String("group_id", groupID.String()))
+		return 0, err
+	}
+	return edgeCount, nil
+}
+
 func GetGroups(ctx context.Context, logger *zap.Logger, db *sql.DB, ids []string) ([]*api.Group, error) {
 	if len(ids) == 0 {
 		return make([]*api.Group, 0), nil
@@ -1633,6 +1701,51 @@ AND id IN (` + strings.Join(statements, ",") + `)`
 	return groups, nil
 }
 
+func GetRandomGroups(ctx context.Context, logger *zap.Logger, db *sql.DB, count int) ([]*api.Group, error) {
+	if count <= 0 {
+		return make([]*api.Group, 0), nil
+	}
+
+	// Only open groups with space for new members are eligible.
+	query := `SELECT id, creator_id, name, description, avatar_url, state, edge_count, lang_tag, max_count, metadata, create_time, update_time
+FROM groups
+WHERE disable_time = '1970-01-01 00:00:00 UTC'
+AND state = 0
+AND edge_count < max_count
+AND id %v $1
+ORDER BY id ASC
+LIMIT $2`
+
+	// Scan forward from a random point in the group ID space, then wrap around to the start if needed.
+	pivot := uuid.Must(uuid.NewV4())
+	groups := make([]*api.Group, 0, count)
+	for _, op := range []string{">=", "<"} {
+		rows, err := db.QueryContext(ctx, fmt.Sprintf(query, op), pivot, count-len(groups))
+		if err != nil {
+			logger.Error("Could not get random groups.", zap.Error(err))
+			return nil, err
+		}
+		// Rows closed in groupConvertRows()
+
+		results, err := groupConvertRows(rows)
+		if err != nil {
+			logger.Error("Could not get random groups.", zap.Error(err))
+			return nil, err
+		}
+		groups = append(groups, results...)
+
+		if len(groups) >= count {
+			break
+		}
+	}
+
+	rand.Shuffle(len(groups), func(i, j int) {
+		groups[i], groups[j] = groups[j], groups[i]
+	})
+
+	return groups, nil
+}
+
 func ListGroups(ctx context.Context, logger *zap.Logger, db *sql.DB, name string, limit int, cursorStr string) (*api.GroupList, error) {
 	var cursor *groupListCursor
 	if cursorStr != "" {
@@ -1882,11 +1995,9 @@ func deleteRelationship(ctx context.Context, logger *zap.Logger, tx *sql.Tx, use
 	query := `
 DELETE FROM group_edge
 WHERE
-	(
-		(source_id = $1::UUID AND destination_id = $2::UUID AND state > 1)
-		OR
-		(source_id = $2::UUID AND destination_id = $1::UUID AND state > 1)
-	)
+	(source_id = $1::UUID AND destination_id = $2::UUID)
+	OR
+	(source_id = $2::UUID AND destination_id = $1::UUID)
 RETURNING state`
 
 	var deletedState sql.NullInt64
@@ -1898,7 +2009,8 @@ RETURNING state`
 		}
 	}
 
-	if deletedState.Int64 < 3 {
+	// Only members count towards the group edge count, not join requests or banned users.
+	if deletedState.Valid && deletedState.Int64 < 3 {
 		query = "UPDATE groups SET edge_count = edge_count - 1, update_time = now() WHERE id = $1::UUID"
 		_, err := tx.ExecContext(ctx, query, groupID)
 		if err != nil {
@@ -0,0 +1,205 @@
+// Copyright 2019 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/heroiclabs/nakama-common/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetRandomGroups(t *testing.T) {
+	db := NewDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	userID, _, _, err := AuthenticateCustom(ctx, logger, db, uuid.Must(uuid.NewV4()).String(), uuid.Must(uuid.NewV4()).String(), true)
+	if err != nil {
+		t.Fatalf("error creating user: %v", err.Error())
+	}
+	uid := uuid.FromStringOrNil(userID)
+
+	openIDs := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		group, err := CreateGroup(ctx, logger, db, uid, uid, uuid.Must(uuid.NewV4()).String(), "en", "", "", "{}", true, 10)
+		if err != nil {
+			t.Fatalf("error creating group: %v", err.Error())
+		}
+		openIDs[group.Id] = true
+	}
+	closedIDs := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		group, err := CreateGroup(ctx, logger, db, uid, uid, uuid.Must(uuid.NewV4()).String(), "en", "", "", "{}", false, 10)
+		if err != nil {
+			t.Fatalf("error creating group: %v", err.Error())
+		}
+		closedIDs[group.Id] = true
+	}
+	// The creator is the only member, so this open group is already full.
+	fullGroup, err := CreateGroup(ctx, logger, db, uid, uid, uuid.Must(uuid.NewV4()).String(), "en", "", "", "{}", true, 1)
+	if err != nil {
+		t.Fatalf("error creating group: %v", err.Error())
+	}
+
+	groups, err := GetRandomGroups(ctx, logger, db, 100)
+	if err != nil {
+		t.Fatalf("error getting random groups: %v", err.Error())
+	}
+
+	found := 0
+	for _, group := range groups {
+		assert.False(t, closedIDs[group.Id], "closed group returned")
+		assert.NotEqual(t, fullGroup.Id, group.Id, "full group returned")
+		assert.True(t, group.Open.Value)
+		assert.True(t, group.EdgeCount < group.MaxCount)
+		if openIDs[group.Id] {
+			found++
+		}
+	}
+	assert.Equal(t, len(openIDs), found, "expected all seeded open groups")
+
+	groups, err = GetRandomGroups(ctx, logger, db, 1)
+	if err != nil {
+		t.Fatalf("error getting random groups: %v", err.Error())
+	}
+	assert.Len(t, groups, 1)
+}
+
+func TestListGroupUsersPresences(t *testing.T) {
+	tracker := StartLocalTracker(logger, cfg, NewLocalSessionRegistry(metrics), metrics, jsonpbMarshaler)
+	defer tracker.Stop()
+
+	statusUserID := uuid.Must(uuid.NewV4())
+	onlineUserID := uuid.Must(uuid.NewV4())
+	offlineUserID := uuid.Must(uuid.NewV4())
+	statusSessionID := uuid.Must(uuid.NewV4())
+	onlineSessionID := uuid.Must(uuid.NewV4())
+
+	// A member following their own status stream, and a member who is connected but has not set a status.
+	tracker.Track(statusSessionID, PresenceStream{Mode: StreamModeNotifications, Subject: statusUserID}, statusUserID, PresenceMeta{Format: SessionFormatJson, Username: "status", Hidden: true}, true)
+	tracker.Track(statusSessionID, PresenceStream{Mode: StreamModeStatus, Subject: statusUserID}, statusUserID, PresenceMeta{Format: SessionFormatJson, Username: "status", Status: "in lobby"}, false)
+	tracker.Track(statusSessionID, PresenceStream{Mode: StreamModeStatus, Subject: onlineUserID}, statusUserID, PresenceMeta{Format: SessionFormatJson, Username: "status", Hidden: true}, false)
+	tracker.Track(onlineSessionID, PresenceStream{Mode: StreamModeNotifications, Subject: onlineUserID}, onlineUserID, PresenceMeta{Format: SessionFormatJson, Username: "online", Hidden: true}, true)
+
+	groupUsers := make([]*api.GroupUserList_GroupUser, 0, 3)
+	for _, userID := range []uuid.UUID{statusUserID, onlineUserID, offlineUserID} {
+		groupUsers = append(groupUsers, &api.GroupUserList_GroupUser{User: &api.User{
+			Id:     userID.String(),
+			Online: tracker.StreamExists(PresenceStream{Mode: StreamModeNotifications, Subject: userID}),
+		}})
+	}
+
+	presences := ListGroupUsersPresences(tracker, groupUsers)
+	assert.Len(t, presences, 2)
+
+	if assert.Len(t, presences[statusUserID.String()], 1) {
+		p := presences[statusUserID.String()][0]
+		assert.Equal(t, statusSessionID, p.ID.SessionID)
+		assert.Equal(t, "in lobby", p.Meta.Status)
+	}
+	if assert.Len(t, presences[onlineUserID.String()], 1) {
+		p := presences[onlineUserID.String()][0]
+		assert.Equal(t, onlineSessionID, p.ID.SessionID)
+		assert.Equal(t, "", p.Meta.Status)
+	}
+	assert.NotContains(t, presences, offlineUserID.String())
+}
+
+func TestGroupEdgeCount(t *testing.T) {
+	db := NewDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	router := &DummyMessageRouter{}
+	newUser := func() uuid.UUID {
+		userID, _, _, err := AuthenticateCustom(ctx, logger, db, uuid.Must(uuid.NewV4()).String(), uuid.Must(uuid.NewV4()).String(), true)
+		if err != nil {
+			t.Fatalf("error creating user: %v", err.Error())
+		}
+		return uuid.FromStringOrNil(userID)
+	}
+	creator := newUser()
+	group, err := CreateGroup(ctx, logger, db, creator, creator, uuid.Must(uuid.NewV4()).String(), "en", "", "", "{}", true, 10)
+	if err != nil {
+		t.Fatalf("error creating group: %v", err.Error())
+	}
+	groupID := uuid.FromStringOrNil(group.Id)
+	assertCount := func(expected int) {
+		count, err := GetGroupEdgeCount(ctx, logger, db, groupID)
+		if err != nil {
+			t.Fatalf("error getting group count: %v", err.Error())
+		}
+		assert.Equal(t, expected, count)
+	}
+	assertCount(1)
+
+	members := []uuid.UUID{newUser(), newUser(), newUser()}
+	if err := AddGroupUsers(ctx, logger, db, router, uuid.Nil, groupID, members); err != nil {
+		t.Fatalf("error adding group users: %v", err.Error())
+	}
+	// Adding existing members again does not count them twice.
+	if err := AddGroupUsers(ctx, logger, db, router, uuid.Nil, groupID, members[:1]); err != nil {
+		t.Fatalf("error adding group users: %v", err.Error())
+	}
+	assertCount(4)
+
+	joiner := newUser()
+	if err := JoinGroup(ctx, logger, db, router, groupID, joiner, ""); err != nil {
+		t.Fatalf("error joining group: %v", err.Error())
+	}
+	assertCount(5)
+
+	if err := KickGroupUsers(ctx, logger, db, router, uuid.Nil, groupID, []uuid.UUID{members[0]}); err != nil {
+		t.Fatalf("error kicking group users: %v", err.Error())
+	}
+	assertCount(4)
+
+	// Banned users stop counting, and banning or kicking them again changes nothing.
+	if err := BanGroupUsers(ctx, logger, db, router, uuid.Nil, groupID, []uuid.UUID{members[1]}); err != nil {
+		t.Fatalf("error banning group users: %v", err.Error())
+	}
+	if err := BanGroupUsers(ctx, logger, db, router, uuid.Nil, groupID, []uuid.UUID{members[1]}); err != nil {
+		t.Fatalf("error banning group users: %v", err.Error())
+	}
+	if err := KickGroupUsers(ctx, logger, db, router, uuid.Nil, groupID, []uuid.UUID{members[1]}); err != nil {
+		t.Fatalf("error kicking group users: %v", err.Error())
+	}
+	assertCount(3)
+
+	if err := LeaveGroup(ctx, logger, db, router, groupID, joiner, ""); err != nil {
+		t.Fatalf("error leaving group: %v", err.Error())
+	}
+	assertCount(2)
+
+	// Deleting an admin's account removes them from the count.
+	if err := PromoteGroupUsers(ctx, logger, db, router, uuid.Nil, groupID, []uuid.UUID{members[2]}); err != nil {
+		t.Fatalf("error promoting group users: %v", err.Error())
+	}
+	if err := DeleteAccount(ctx, logger, db, members[2], false); err != nil {
+		t.Fatalf("error deleting account: %v", err.Error())
+	}
+	assertCount(1)
+
+	groups, err := GetGroups(ctx, logger, db, []string{group.Id})
+	if err != nil {
+		t.Fatalf("error getting groups: %v", err.Error())
+	}
+	if assert.Len(t, groups, 1) {
+		assert.Equal(t, int32(1), groups[0].EdgeCount)
+	}
+}
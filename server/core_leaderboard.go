@@ -20,6 +20,8 @@ import (
 	"database/sql"
 	"encoding/base64"
 	"encoding/gob"
+	"math"
+	"math/rand"
 	"strconv"
 	"strings"
 	"time"
@@ -28,17 +30,25 @@ import (
 	"github.com/golang/protobuf/ptypes/timestamp"
 	"github.com/golang/protobuf/ptypes/wrappers"
 	"github.com/heroiclabs/nakama-common/api"
+	"github.com/jackc/pgx"
 	"github.com/jackc/pgx/pgtype"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 )
 
 var (
-	ErrLeaderboardNotFound      = errors.New("leaderboard not found")
-	ErrLeaderboardAuthoritative = errors.New("leaderboard only allows authoritative submissions")
-	ErrLeaderboardInvalidCursor = errors.New("leaderboard cursor invalid")
+	ErrLeaderboardNotFound        = errors.New("leaderboard not found")
+	ErrLeaderboardAuthoritative   = errors.New("leaderboard only allows authoritative submissions")
+	ErrLeaderboardInvalidCursor   = errors.New("leaderboard cursor invalid")
+	ErrLeaderboardNotExpired      = errors.New("leaderboard window has not expired")
+	ErrLeaderboardScoreOutOfRange = errors.New("leaderboard record score or subscore out of range")
+	ErrLeaderboardTooManyOwners   = errors.New("too many leaderboard record owners")
+	ErrLeaderboardInvalidOwner    = errors.New("leaderboard record owner ID invalid")
 )
 
+// Maximum number of owners whose records may be deleted in a single batch, in line with other batch limits.
+const LeaderboardRecordsDeleteMaxOwners = 100
+
 type leaderboardRecordListCursor struct {
 	// Query hint.
 	IsNext bool
@@ -57,10 +67,38 @@ func LeaderboardRecordsList(ctx context.Context, logger *zap.Logger, db *sql.DB,
 		return nil, ErrLeaderboardNotFound
 	}
 
-	expiryTime, recordsPossible := calculateExpiryOverride(overrideExpiry, leaderboard)
-	if !recordsPossible {
-		// If the expiry time is in the past, we wont have any records to return.
-		return &api.LeaderboardRecordList{}, nil
+	var incomingCursor *leaderboardRecordListCursor
+	if limit != nil && cursor != "" {
+		cb, err := base64.StdEncoding.DecodeString(cursor)
+		if err != nil {
+			return nil, ErrLeaderboardInvalidCursor
+		}
+		incomingCursor = &leaderboardRecordListCursor{}
+		if err := gob.NewDecoder(bytes.NewReader(cb)).Decode(incomingCursor); err != nil {
+			return nil, ErrLeaderboardInvalidCursor
+		}
+
+		if leaderboardId != incomingCursor.LeaderboardId {
+			// Cursor is for a different leaderboard.
+			return nil, ErrLeaderboardInvalidCursor
+		} else if overrideExpiry != 0 && overrideExpiry != incomingCursor.ExpiryTime {
+			// Cursor is for a different expiry than the one requested.
+			return nil, ErrLeaderboardInvalidCursor
+		}
+	}
+
+	var expiryTime int64
+	if incomingCursor != nil {
+		// Cursors encode the reset window they were generated in, keep paging through that window even if the
+		// leaderboard has reset since the listing started.
+		expiryTime = incomingCursor.ExpiryTime
+	} else {
+		var recordsPossible bool
+		expiryTime, recordsPossible = calculateExpiryOverride(overrideExpiry, leaderboard)
+		if !recordsPossible {
+			// If the expiry time is in the past, we wont have any records to return.
+			return &api.LeaderboardRecordList{}, nil
+		}
 	}
 
 	records := make([]*api.LeaderboardRecord, 0)
@@ -69,25 +107,6 @@ func LeaderboardRecordsList(ctx context.Context, logger *zap.Logger, db *sql.DB,
 
 	if limit != nil {
 		limitNumber := int(limit.Value)
-		var incomingCursor *leaderboardRecordListCursor
-		if cursor != "" {
-			cb, err := base64.StdEncoding.DecodeString(cursor)
-			if err != nil {
-				return nil, ErrLeaderboardInvalidCursor
-			}
-			incomingCursor = &leaderboardRecordListCursor{}
-			if err := gob.NewDecoder(bytes.NewReader(cb)).Decode(incomingCursor); err != nil {
-				return nil, ErrLeaderboardInvalidCursor
-			}
-
-			if leaderboardId != incomingCursor.LeaderboardId {
-				// Cursor is for a different leaderboard.
-				return nil, ErrLeaderboardInvalidCursor
-			} else if expiryTime != incomingCursor.ExpiryTime {
-				// Leaderboard expiry has rolled over since this cursor was generated.
-				return nil, ErrLeaderboardInvalidCursor
-			}
-		}
 
 		query := "SELECT owner_id, username, score, subscore, num_score, max_num_score, metadata, create_time, update_time FROM leaderboard_record WHERE leaderboard_id = $1 AND expiry_time = $2"
 		if incomingCursor == nil {
@@ -303,6 +322,17 @@ func LeaderboardRecordsList(ctx context.Context, logger *zap.Logger, db *sql.DB,
 	}, nil
 }
 
+// LeaderboardRecordsListExpired lists the records of a leaderboard or tournament window that has already ended, in rank
+// order, so rewards can be granted once a reset has happened. The expiry is the end of the window, as passed to reset
+// callbacks. Ranks are taken from each record's position in the window, since rank caches only hold current windows.
+func LeaderboardRecordsListExpired(ctx context.Context, logger *zap.Logger, db *sql.DB, leaderboardCache LeaderboardCache, rankCache LeaderboardRankCache, leaderboardId string, expiry int64, limit int, cursor string) (*api.LeaderboardRecordList, error) {
+	if expiry <= 0 || expiry > time.Now().UTC().Unix() {
+		return nil, ErrLeaderboardNotExpired
+	}
+
+	return LeaderboardRecordsList(ctx, logger, db, leaderboardCache, rankCache, leaderboardId, &wrappers.Int32Value{Value: int32(limit)}, cursor, nil, expiry)
+}
+
 func LeaderboardRecordWrite(ctx context.Context, logger *zap.Logger, db *sql.DB, leaderboardCache LeaderboardCache, rankCache LeaderboardRankCache, caller uuid.UUID, leaderboardId, ownerID, username string, score, subscore int64, metadata string) (*api.LeaderboardRecord, error) {
 	leaderboard := leaderboardCache.Get(leaderboardId)
 	if leaderboard == nil {
@@ -344,11 +374,11 @@ func LeaderboardRecordWrite(ctx context.Context, logger *zap.Logger, db *sql.DB,
 	default:
 		if leaderboard.SortOrder == LeaderboardSortOrderAscending {
 			// Lower score is better.
-			opSQL = "score = div((leaderboard_record.score + $8 - abs(leaderboard_record.score - $8)), 2), subscore = div((leaderboard_record.subscore + $9 - abs(leaderboard_record.subscore - $9)), 2)"
+			opSQL = "score = LEAST(leaderboard_record.score, $8), subscore = LEAST(leaderboard_record.subscore, $9)"
 			filterSQL = " WHERE leaderboard_record.score > $8 OR leaderboard_record.subscore > $9"
 		} else {
 			// Higher score is better.
-			opSQL = "score = div((leaderboard_record.score + $8 + abs(leaderboard_record.score - $8)), 2), subscore = div((leaderboard_record.subscore + $9 + abs(leaderboard_record.subscore - $9)), 2)"
+			opSQL = "score = GREATEST(leaderboard_record.score, $8), subscore = GREATEST(leaderboard_record.subscore, $9)"
 			filterSQL = " WHERE leaderboard_record.score < $8 OR leaderboard_record.subscore < $9"
 		}
 		scoreDelta = score
@@ -378,6 +408,10 @@ func LeaderboardRecordWrite(ctx context.Context, logger *zap.Logger, db *sql.DB,
 
 	_, err := db.ExecContext(ctx, query, params...)
 	if err != nil {
+		if err = leaderboardScoreError(err); err == ErrLeaderboardScoreOutOfRange {
+			logger.Debug("Rejected leaderboard record write out of score range", zap.String("leaderboard_id", leaderboardId), zap.String("owner_id", ownerID))
+			return nil, err
+		}
 		logger.Error("Error writing leaderboard record", zap.Error(err))
 		return nil, err
 	}
@@ -422,6 +456,15 @@ func LeaderboardRecordWrite(ctx context.Context, logger *zap.Logger, db *sql.DB,
 	return record, nil
 }
 
+// leaderboardScoreError converts database errors from score or subscore arithmetic that would overflow the 64 bit range
+// of leaderboard scores, such as incrementing a score past its maximum, to ErrLeaderboardScoreOutOfRange.
+func leaderboardScoreError(err error) error {
+	if e, ok := err.(pgx.PgError); ok && e.Code == dbErrorNumericValueOutOfRange {
+		return ErrLeaderboardScoreOutOfRange
+	}
+	return err
+}
+
 func LeaderboardRecordDelete(ctx context.Context, logger *zap.Logger, db *sql.DB, leaderboardCache LeaderboardCache, rankCache LeaderboardRankCache, caller uuid.UUID, leaderboardId, ownerID string) error {
 	leaderboard := leaderboardCache.Get(leaderboardId)
 	if leaderboard == nil {
@@ -448,9 +491,68 @@ func LeaderboardRecordDelete(ctx context.Context, logger *zap.Logger, db *sql.DB
 	return nil
 }
 
-func LeaderboardRecordReadAll(ctx context.Context, logger *zap.Logger, db *sql.DB, userID uuid.UUID) ([]*api.LeaderboardRecord, error) {
-	query := "SELECT leaderboard_id, owner_id, username, score, subscore, num_score, max_num_score, metadata, create_time, update_time, expiry_time FROM leaderboard_record WHERE owner_id = $1"
-	rows, err := db.QueryContext(ctx, query, userID.String())
+func LeaderboardRecordsDelete(ctx context.Context, logger *zap.Logger, db *sql.DB, leaderboardCache LeaderboardCache, rankCache LeaderboardRankCache, caller uuid.UUID, leaderboardId string, ownerIDs []string) error {
+	leaderboard := leaderboardCache.Get(leaderboardId)
+	if leaderboard == nil {
+		return nil
+	}
+
+	if leaderboard.Authoritative && caller != uuid.Nil {
+		return ErrLeaderboardAuthoritative
+	}
+
+	if len(ownerIDs) == 0 {
+		return nil
+	}
+	if len(ownerIDs) > LeaderboardRecordsDeleteMaxOwners {
+		return ErrLeaderboardTooManyOwners
+	}
+	ownerUUIDs := make([]uuid.UUID, 0, len(ownerIDs))
+	for _, ownerID := range ownerIDs {
+		ownerUUID, err := uuid.FromString(ownerID)
+		if err != nil {
+			return ErrLeaderboardInvalidOwner
+		}
+		ownerUUIDs = append(ownerUUIDs, ownerUUID)
+	}
+
+	expiryTime := int64(0)
+	if leaderboard.ResetSchedule != nil {
+		expiryTime = leaderboard.ResetSchedule.Next(time.Now().UTC()).UTC().Unix()
+	}
+
+	params := make([]interface{}, 0, len(ownerIDs)+2)
+	params = append(params, leaderboardId, time.Unix(expiryTime, 0).UTC())
+	statements := make([]string, len(ownerIDs))
+	for i, ownerID := range ownerIDs {
+		params = append(params, ownerID)
+		statements[i] = "$" + strconv.Itoa(i+3)
+	}
+
+	query := "DELETE FROM leaderboard_record WHERE leaderboard_id = $1 AND expiry_time = $2 AND owner_id IN (" + strings.Join(statements, ", ") + ")"
+	_, err := db.ExecContext(ctx, query, params...)
+	if err != nil {
+		logger.Error("Error deleting leaderboard records", zap.Error(err))
+		return err
+	}
+
+	for _, ownerUUID := range ownerUUIDs {
+		rankCache.Delete(leaderboardId, expiryTime, ownerUUID)
+	}
+	return nil
+}
+
+// LeaderboardRecordReadAll returns up to limit of a user's records across all leaderboards, ordered by leaderboard ID and
+// expiry time and starting after the given record. Use nil to start from the first record.
+func LeaderboardRecordReadAll(ctx context.Context, logger *zap.Logger, db *sql.DB, userID uuid.UUID, limit int, after *api.LeaderboardRecord) ([]*api.LeaderboardRecord, error) {
+	var afterLeaderboardID string
+	afterExpiryTime := time.Unix(0, 0).UTC()
+	if after != nil {
+		afterLeaderboardID = after.LeaderboardId
+		afterExpiryTime = time.Unix(after.GetExpiryTime().GetSeconds(), 0).UTC()
+	}
+	query := "SELECT leaderboard_id, owner_id, username, score, subscore, num_score, max_num_score, metadata, create_time, update_time, expiry_time FROM leaderboard_record WHERE owner_id = $1 AND (leaderboard_id, expiry_time) > ($2, $3) ORDER BY leaderboard_id ASC, expiry_time ASC LIMIT $4"
+	rows, err := db.QueryContext(ctx, query, userID.String(), afterLeaderboardID, afterExpiryTime, limit)
 	if err != nil {
 		logger.Error("Error reading all leaderboard records for user", zap.String("user_id", userID.String()), zap.Error(err))
 		return nil, err
@@ -612,6 +714,193 @@ func getLeaderboardRecordsHaystack(ctx context.Context, logger *zap.Logger, db *
 	return records, nil
 }
 
+// LeaderboardRecordsAroundScore lists up to limit records bracketing the given score and subscore. Records ranked better
+// than the score fill up to half of the results, and records ranked equal or worse fill the remainder, per the
+// leaderboard's sort order. Records are returned in rank order.
+func LeaderboardRecordsAroundScore(ctx context.Context, logger *zap.Logger, db *sql.DB, leaderboardCache LeaderboardCache, rankCache LeaderboardRankCache, leaderboardId string, score, subscore int64, limit int, overrideExpiry int64) ([]*api.LeaderboardRecord, error) {
+	leaderboard := leaderboardCache.Get(leaderboardId)
+	if leaderboard == nil {
+		return nil, ErrLeaderboardNotFound
+	}
+
+	expiryTime, recordsPossible := calculateExpiryOverride(overrideExpiry, leaderboard)
+	if !recordsPossible {
+		// If the expiry time is in the past, we wont have any records to return.
+		return make([]*api.LeaderboardRecord, 0), nil
+	}
+
+	return getLeaderboardRecordsAroundScore(ctx, logger, db, rankCache, score, subscore, limit, leaderboard.Id, leaderboard.SortOrder, time.Unix(expiryTime, 0).UTC())
+}
+
+func getLeaderboardRecordsAroundScore(ctx context.Context, logger *zap.Logger, db *sql.DB, rankCache LeaderboardRankCache, score, subscore int64, limit int, leaderboardId string, sortOrder int, expiryTime time.Time) ([]*api.LeaderboardRecord, error) {
+	query := `SELECT leaderboard_id, owner_id, username, score, subscore, num_score, max_num_score, metadata, create_time, update_time, expiry_time
+	FROM leaderboard_record
+	WHERE leaderboard_id = $1
+	AND expiry_time = $2`
+	params := []interface{}{leaderboardId, expiryTime, score, subscore, limit}
+
+	// Records ranked better than the given score, nearest first.
+	betterQuery := query
+	if sortOrder == LeaderboardSortOrderAscending {
+		// Lower score is better.
+		betterQuery += " AND (score, subscore) < ($3, $4) ORDER BY score DESC, subscore DESC, owner_id DESC LIMIT $5"
+	} else {
+		// Higher score is better.
+		betterQuery += " AND (score, subscore) > ($3, $4) ORDER BY score ASC, subscore ASC, owner_id ASC LIMIT $5"
+	}
+	betterRows, err := db.QueryContext(ctx, betterQuery, params...)
+	if err != nil {
+		logger.Error("Could not execute leaderboard records around score query", zap.Error(err))
+		return nil, err
+	}
+	// betterRows.Close() called in parseLeaderboardRecords
+
+	betterRecords, err := parseLeaderboardRecords(logger, betterRows)
+	if err != nil {
+		return nil, err
+	}
+
+	// Records ranked equal to or worse than the given score, nearest first.
+	worseQuery := query
+	if sortOrder == LeaderboardSortOrderAscending {
+		// Lower score is better.
+		worseQuery += " AND (score, subscore) >= ($3, $4) ORDER BY score ASC, subscore ASC, owner_id ASC LIMIT $5"
+	} else {
+		// Higher score is better.
+		worseQuery += " AND (score, subscore) <= ($3, $4) ORDER BY score DESC, subscore DESC, owner_id DESC LIMIT $5"
+	}
+	worseRows, err := db.QueryContext(ctx, worseQuery, params...)
+	if err != nil {
+		logger.Error("Could not execute leaderboard records around score query", zap.Error(err))
+		return nil, err
+	}
+	// worseRows.Close() called in parseLeaderboardRecords
+
+	worseRecords, err := parseLeaderboardRecords(logger, worseRows)
+	if err != nil {
+		return nil, err
+	}
+
+	// Take up to half the results from the better records, more if there are not enough worse records to fill the limit.
+	betterCount := limit / 2
+	if l := len(worseRecords); l < limit-betterCount {
+		betterCount = limit - l
+	}
+	if l := len(betterRecords); l < betterCount {
+		betterCount = l
+	}
+	worseCount := limit - betterCount
+	if l := len(worseRecords); l < worseCount {
+		worseCount = l
+	}
+
+	records := make([]*api.LeaderboardRecord, 0, betterCount+worseCount)
+	// We went 'up' on the leaderboard for the better records, so add them in reverse.
+	for i := betterCount - 1; i >= 0; i-- {
+		records = append(records, betterRecords[i])
+	}
+	records = append(records, worseRecords[:worseCount]...)
+
+	rankCache.Fill(leaderboardId, expiryTime.Unix(), records)
+
+	return records, nil
+}
+
+// LeaderboardRecordsSample returns up to count distinct records chosen at random from the current window of a
+// leaderboard, in no particular order. Each record is found with an index seek to a random point between the lowest
+// and highest scores, so the cost depends on count rather than leaderboard size. As a result the sample is not
+// uniform: records that follow large gaps in score are more likely to be chosen than those in dense score ranges.
+func LeaderboardRecordsSample(ctx context.Context, logger *zap.Logger, db *sql.DB, leaderboardCache LeaderboardCache, rankCache LeaderboardRankCache, leaderboardId string, count int) ([]*api.LeaderboardRecord, error) {
+	leaderboard := leaderboardCache.Get(leaderboardId)
+	if leaderboard == nil {
+		return nil, ErrLeaderboardNotFound
+	}
+
+	expiry, recordsPossible := calculateExpiryOverride(0, leaderboard)
+	if !recordsPossible {
+		// If the expiry time is in the past, we wont have any records to return.
+		return make([]*api.LeaderboardRecord, 0), nil
+	}
+	expiryTime := time.Unix(expiry, 0).UTC()
+
+	// Find the score range of the window, both ends are index lookups.
+	var minScore, minSubscore, maxScore, maxSubscore int64
+	boundsQuery := "SELECT score, subscore FROM leaderboard_record WHERE leaderboard_id = $1 AND expiry_time = $2 ORDER BY "
+	err := db.QueryRowContext(ctx, boundsQuery+"score ASC, subscore ASC, owner_id ASC LIMIT 1", leaderboardId, expiryTime).Scan(&minScore, &minSubscore)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return make([]*api.LeaderboardRecord, 0), nil
+		}
+		logger.Error("Could not execute leaderboard records sample query", zap.Error(err))
+		return nil, err
+	}
+	if err = db.QueryRowContext(ctx, boundsQuery+"score DESC, subscore DESC, owner_id DESC LIMIT 1", leaderboardId, expiryTime).Scan(&maxScore, &maxSubscore); err != nil {
+		logger.Error("Could not execute leaderboard records sample query", zap.Error(err))
+		return nil, err
+	}
+	if minSubscore > maxSubscore {
+		maxSubscore = minSubscore
+	}
+
+	query := `SELECT leaderboard_id, owner_id, username, score, subscore, num_score, max_num_score, metadata, create_time, update_time, expiry_time
+	FROM leaderboard_record
+	WHERE leaderboard_id = $1
+	AND expiry_time = $2`
+	seekQuery := query + " AND (score, subscore, owner_id) >= ($3, $4, $5) ORDER BY score ASC, subscore ASC, owner_id ASC LIMIT 1"
+	// Seeks past the last record wrap around to the first.
+	wrapQuery := query + " ORDER BY score ASC, subscore ASC, owner_id ASC LIMIT 1"
+
+	records := make([]*api.LeaderboardRecord, 0, count)
+	seen := make(map[string]struct{}, count)
+	// Seeks may land on records already in the sample, allow a bounded number of extra attempts.
+	for attempts := 0; len(records) < count && attempts < count*3; attempts++ {
+		rows, err := db.QueryContext(ctx, seekQuery, leaderboardId, expiryTime, randomInt63Between(minScore, maxScore), randomInt63Between(0, maxSubscore), uuid.Must(uuid.NewV4()))
+		if err != nil {
+			logger.Error("Could not execute leaderboard records sample query", zap.Error(err))
+			return nil, err
+		}
+		// rows.Close() called in parseLeaderboardRecords
+		found, err := parseLeaderboardRecords(logger, rows)
+		if err != nil {
+			return nil, err
+		}
+		if len(found) == 0 {
+			rows, err = db.QueryContext(ctx, wrapQuery, leaderboardId, expiryTime)
+			if err != nil {
+				logger.Error("Could not execute leaderboard records sample query", zap.Error(err))
+				return nil, err
+			}
+			if found, err = parseLeaderboardRecords(logger, rows); err != nil {
+				return nil, err
+			}
+		}
+
+		for _, record := range found {
+			if _, ok := seen[record.OwnerId]; ok {
+				continue
+			}
+			seen[record.OwnerId] = struct{}{}
+			records = append(records, record)
+		}
+	}
+
+	rankCache.Fill(leaderboardId, expiry, records)
+
+	return records, nil
+}
+
+// randomInt63Between returns a random value between min and max inclusive, both of which must be non-negative.
+func randomInt63Between(min, max int64) int64 {
+	span := max - min
+	if span <= 0 {
+		return min
+	}
+	if span < math.MaxInt64 {
+		span++
+	}
+	return min + rand.Int63n(span)
+}
+
 func parseLeaderboardRecords(logger *zap.Logger, rows *sql.Rows) ([]*api.LeaderboardRecord, error) {
 	defer rows.Close()
 	records := make([]*api.LeaderboardRecord, 0, 10)
@@ -0,0 +1,436 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/heroiclabs/nakama-common/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeaderboardRecordsDelete(t *testing.T) {
+	db := NewDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	leaderboardCache := NewLocalLeaderboardCache(logger, logger, db)
+	rankCache := NewLocalLeaderboardRankCache(logger, db, NewConfig(logger).GetLeaderboard(), leaderboardCache)
+
+	leaderboardID := uuid.Must(uuid.NewV4()).String()
+	if _, err := leaderboardCache.Create(ctx, leaderboardID, true, LeaderboardSortOrderDescending, LeaderboardOperatorBest, "", "{}"); err != nil {
+		t.Fatalf("error creating leaderboard: %v", err.Error())
+	}
+
+	ownerIDs := make([]string, 0, 4)
+	for i := 0; i < 4; i++ {
+		ownerID := uuid.Must(uuid.NewV4()).String()
+		if _, err := LeaderboardRecordWrite(ctx, logger, db, leaderboardCache, rankCache, uuid.Nil, leaderboardID, ownerID, "", int64(i+1), 0, "{}"); err != nil {
+			t.Fatalf("error writing leaderboard record: %v", err.Error())
+		}
+		ownerIDs = append(ownerIDs, ownerID)
+	}
+
+	// Batches are capped.
+	tooMany := make([]string, LeaderboardRecordsDeleteMaxOwners+1)
+	for i := range tooMany {
+		tooMany[i] = uuid.Must(uuid.NewV4()).String()
+	}
+	err := LeaderboardRecordsDelete(ctx, logger, db, leaderboardCache, rankCache, uuid.Nil, leaderboardID, tooMany)
+	assert.Equal(t, ErrLeaderboardTooManyOwners, err)
+
+	// Invalid owner IDs are rejected before any record is deleted.
+	err = LeaderboardRecordsDelete(ctx, logger, db, leaderboardCache, rankCache, uuid.Nil, leaderboardID, []string{ownerIDs[0], "invalid"})
+	assert.Equal(t, ErrLeaderboardInvalidOwner, err)
+
+	// Authoritative leaderboards reject deletes from clients.
+	err = LeaderboardRecordsDelete(ctx, logger, db, leaderboardCache, rankCache, uuid.Must(uuid.NewV4()), leaderboardID, ownerIDs[:2])
+	assert.Equal(t, ErrLeaderboardAuthoritative, err)
+
+	if err := LeaderboardRecordsDelete(ctx, logger, db, leaderboardCache, rankCache, uuid.Nil, leaderboardID, ownerIDs[:2]); err != nil {
+		t.Fatalf("error deleting leaderboard records: %v", err.Error())
+	}
+
+	list, err := LeaderboardRecordsList(ctx, logger, db, leaderboardCache, rankCache, leaderboardID, nil, "", ownerIDs, 0)
+	if err != nil {
+		t.Fatalf("error listing leaderboard records: %v", err.Error())
+	}
+
+	remaining := make([]string, 0, len(list.OwnerRecords))
+	for _, record := range list.OwnerRecords {
+		remaining = append(remaining, record.OwnerId)
+	}
+	assert.ElementsMatch(t, ownerIDs[2:], remaining)
+
+	// Remaining records are re-ranked without the deleted owners.
+	for _, record := range list.OwnerRecords {
+		switch record.OwnerId {
+		case ownerIDs[3]:
+			assert.Equal(t, int64(1), record.Rank)
+		case ownerIDs[2]:
+			assert.Equal(t, int64(2), record.Rank)
+		}
+	}
+}
+
+func TestLeaderboardRecordsListCursorAcrossReset(t *testing.T) {
+	db := NewDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	leaderboardCache := NewLocalLeaderboardCache(logger, logger, db)
+	rankCache := NewLocalLeaderboardRankCache(logger, db, NewConfig(logger).GetLeaderboard(), leaderboardCache)
+
+	leaderboardID := uuid.Must(uuid.NewV4()).String()
+	if _, err := leaderboardCache.Create(ctx, leaderboardID, false, LeaderboardSortOrderDescending, LeaderboardOperatorBest, "0 0 * * *", "{}"); err != nil {
+		t.Fatalf("error creating leaderboard: %v", err.Error())
+	}
+
+	// Records from a reset window that has already expired.
+	previousExpiry := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+	for i := 0; i < 3; i++ {
+		_, err := db.ExecContext(ctx, "INSERT INTO leaderboard_record (leaderboard_id, owner_id, username, score, subscore, metadata, expiry_time) VALUES ($1, $2, $3, $4, 0, '{}', $5)",
+			leaderboardID, uuid.Must(uuid.NewV4()), uuid.Must(uuid.NewV4()).String(), i+1, previousExpiry)
+		if err != nil {
+			t.Fatalf("error writing leaderboard record: %v", err.Error())
+		}
+	}
+
+	// Start listing the previous window.
+	list, err := LeaderboardRecordsList(ctx, logger, db, leaderboardCache, rankCache, leaderboardID, &wrappers.Int32Value{Value: 2}, "", nil, previousExpiry.Unix())
+	if err != nil {
+		t.Fatalf("error listing leaderboard records: %v", err.Error())
+	}
+	assert.Len(t, list.Records, 2)
+	assert.NotEmpty(t, list.NextCursor)
+
+	// The cursor keeps paging through the window it was generated in, even without an explicit expiry.
+	list, err = LeaderboardRecordsList(ctx, logger, db, leaderboardCache, rankCache, leaderboardID, &wrappers.Int32Value{Value: 2}, list.NextCursor, nil, 0)
+	if err != nil {
+		t.Fatalf("error listing leaderboard records: %v", err.Error())
+	}
+	assert.Len(t, list.Records, 1)
+	assert.Equal(t, int64(1), list.Records[0].Score)
+	assert.Equal(t, int64(3), list.Records[0].Rank)
+	assert.Equal(t, previousExpiry.Unix(), list.Records[0].ExpiryTime.Seconds)
+	assert.Empty(t, list.NextCursor)
+
+	// Cursors cannot be used with a different explicit expiry.
+	_, err = LeaderboardRecordsList(ctx, logger, db, leaderboardCache, rankCache, leaderboardID, &wrappers.Int32Value{Value: 2}, list.PrevCursor, nil, previousExpiry.Unix()+1)
+	assert.Equal(t, ErrLeaderboardInvalidCursor, err)
+}
+
+func TestLeaderboardRecordsListExpired(t *testing.T) {
+	db := NewDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	leaderboardCache := NewLocalLeaderboardCache(logger, logger, db)
+	rankCache := NewLocalLeaderboardRankCache(logger, db, NewConfig(logger).GetLeaderboard(), leaderboardCache)
+
+	leaderboardID := uuid.Must(uuid.NewV4()).String()
+	if _, err := leaderboardCache.Create(ctx, leaderboardID, true, LeaderboardSortOrderDescending, LeaderboardOperatorBest, "0 0 * * *", "{}"); err != nil {
+		t.Fatalf("error creating leaderboard: %v", err.Error())
+	}
+
+	var expiry int64
+	for i := 0; i < 3; i++ {
+		record, err := LeaderboardRecordWrite(ctx, logger, db, leaderboardCache, rankCache, uuid.Nil, leaderboardID, uuid.Must(uuid.NewV4()).String(), "", int64(i+1), 0, "{}")
+		if err != nil {
+			t.Fatalf("error writing leaderboard record: %v", err.Error())
+		}
+		expiry = record.ExpiryTime.Seconds
+	}
+
+	// The current window has not ended yet.
+	_, err := LeaderboardRecordsListExpired(ctx, logger, db, leaderboardCache, rankCache, leaderboardID, expiry, 10, "")
+	assert.Equal(t, ErrLeaderboardNotExpired, err)
+
+	// Simulate a reset by moving the window the records were written in into the past.
+	previousExpiry := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+	if _, err := db.ExecContext(ctx, "UPDATE leaderboard_record SET expiry_time = $1 WHERE leaderboard_id = $2", previousExpiry, leaderboardID); err != nil {
+		t.Fatalf("error updating leaderboard records: %v", err.Error())
+	}
+
+	list, err := LeaderboardRecordsListExpired(ctx, logger, db, leaderboardCache, rankCache, leaderboardID, previousExpiry.Unix(), 2, "")
+	if err != nil {
+		t.Fatalf("error listing expired leaderboard records: %v", err.Error())
+	}
+	if assert.Len(t, list.Records, 2) {
+		assert.Equal(t, int64(3), list.Records[0].Score)
+		assert.Equal(t, int64(1), list.Records[0].Rank)
+		assert.Equal(t, int64(2), list.Records[1].Rank)
+	}
+	list, err = LeaderboardRecordsListExpired(ctx, logger, db, leaderboardCache, rankCache, leaderboardID, previousExpiry.Unix(), 2, list.NextCursor)
+	if err != nil {
+		t.Fatalf("error listing expired leaderboard records: %v", err.Error())
+	}
+	if assert.Len(t, list.Records, 1) {
+		assert.Equal(t, int64(1), list.Records[0].Score)
+		assert.Equal(t, int64(3), list.Records[0].Rank)
+	}
+	assert.Empty(t, list.NextCursor)
+
+	// The current window is empty after the reset.
+	list, err = LeaderboardRecordsList(ctx, logger, db, leaderboardCache, rankCache, leaderboardID, &wrappers.Int32Value{Value: 10}, "", nil, 0)
+	if err != nil {
+		t.Fatalf("error listing leaderboard records: %v", err.Error())
+	}
+	assert.Empty(t, list.Records)
+}
+
+func TestLeaderboardRecordsSample(t *testing.T) {
+	db := NewDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	leaderboardCache := NewLocalLeaderboardCache(logger, logger, db)
+	rankCache := NewLocalLeaderboardRankCache(logger, db, NewConfig(logger).GetLeaderboard(), leaderboardCache)
+
+	leaderboardID := uuid.Must(uuid.NewV4()).String()
+	otherLeaderboardID := uuid.Must(uuid.NewV4()).String()
+	for _, id := range []string{leaderboardID, otherLeaderboardID} {
+		if _, err := leaderboardCache.Create(ctx, id, true, LeaderboardSortOrderDescending, LeaderboardOperatorBest, "", "{}"); err != nil {
+			t.Fatalf("error creating leaderboard: %v", err.Error())
+		}
+	}
+
+	records, err := LeaderboardRecordsSample(ctx, logger, db, leaderboardCache, rankCache, leaderboardID, 5)
+	if err != nil {
+		t.Fatalf("error sampling leaderboard records: %v", err.Error())
+	}
+	assert.Empty(t, records)
+
+	for i := 0; i < 20; i++ {
+		for _, id := range []string{leaderboardID, otherLeaderboardID} {
+			if _, err := LeaderboardRecordWrite(ctx, logger, db, leaderboardCache, rankCache, uuid.Nil, id, uuid.Must(uuid.NewV4()).String(), "", int64(i+1), 0, "{}"); err != nil {
+				t.Fatalf("error writing leaderboard record: %v", err.Error())
+			}
+		}
+	}
+
+	records, err = LeaderboardRecordsSample(ctx, logger, db, leaderboardCache, rankCache, leaderboardID, 5)
+	if err != nil {
+		t.Fatalf("error sampling leaderboard records: %v", err.Error())
+	}
+	assert.Len(t, records, 5)
+	owners := make(map[string]struct{}, len(records))
+	for _, record := range records {
+		assert.Equal(t, leaderboardID, record.LeaderboardId)
+		assert.Equal(t, 21-record.Score, record.Rank)
+		owners[record.OwnerId] = struct{}{}
+	}
+	assert.Len(t, owners, len(records), "sampled records should be distinct")
+
+	_, err = LeaderboardRecordsSample(ctx, logger, db, leaderboardCache, rankCache, uuid.Must(uuid.NewV4()).String(), 5)
+	assert.Equal(t, ErrLeaderboardNotFound, err)
+}
+
+func TestRandomInt63Between(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		v := randomInt63Between(5, 7)
+		assert.True(t, v >= 5 && v <= 7)
+	}
+	assert.Equal(t, int64(3), randomInt63Between(3, 3))
+	assert.True(t, randomInt63Between(0, math.MaxInt64) >= 0)
+}
+
+func TestLeaderboardRecordsAroundScore(t *testing.T) {
+	db := NewDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	leaderboardCache := NewLocalLeaderboardCache(logger, logger, db)
+	rankCache := NewLocalLeaderboardRankCache(logger, db, NewConfig(logger).GetLeaderboard(), leaderboardCache)
+
+	descID := uuid.Must(uuid.NewV4()).String()
+	if _, err := leaderboardCache.Create(ctx, descID, true, LeaderboardSortOrderDescending, LeaderboardOperatorBest, "", "{}"); err != nil {
+		t.Fatalf("error creating leaderboard: %v", err.Error())
+	}
+	ascID := uuid.Must(uuid.NewV4()).String()
+	if _, err := leaderboardCache.Create(ctx, ascID, true, LeaderboardSortOrderAscending, LeaderboardOperatorBest, "", "{}"); err != nil {
+		t.Fatalf("error creating leaderboard: %v", err.Error())
+	}
+
+	// Empty leaderboards have no records around any score.
+	records, err := LeaderboardRecordsAroundScore(ctx, logger, db, leaderboardCache, rankCache, descID, 50, 0, 5, 0)
+	if err != nil {
+		t.Fatalf("error listing leaderboard records: %v", err.Error())
+	}
+	assert.Empty(t, records)
+
+	// Scores 10, 20, ... 100.
+	for i := 1; i <= 10; i++ {
+		ownerID := uuid.Must(uuid.NewV4()).String()
+		for _, id := range []string{descID, ascID} {
+			if _, err := LeaderboardRecordWrite(ctx, logger, db, leaderboardCache, rankCache, uuid.Nil, id, ownerID, "", int64(i*10), 0, "{}"); err != nil {
+				t.Fatalf("error writing leaderboard record: %v", err.Error())
+			}
+		}
+	}
+
+	scores := func(records []*api.LeaderboardRecord) []int64 {
+		s := make([]int64, 0, len(records))
+		for _, record := range records {
+			s = append(s, record.Score)
+		}
+		return s
+	}
+
+	// Higher scores are better, a score between records is bracketed by both sides.
+	records, err = LeaderboardRecordsAroundScore(ctx, logger, db, leaderboardCache, rankCache, descID, 55, 0, 4, 0)
+	if err != nil {
+		t.Fatalf("error listing leaderboard records: %v", err.Error())
+	}
+	assert.Equal(t, []int64{70, 60, 50, 40}, scores(records))
+	assert.Equal(t, int64(4), records[0].Rank)
+	assert.Equal(t, int64(7), records[3].Rank)
+
+	// A matching score is placed with the records at or below it.
+	records, err = LeaderboardRecordsAroundScore(ctx, logger, db, leaderboardCache, rankCache, descID, 50, 0, 3, 0)
+	if err != nil {
+		t.Fatalf("error listing leaderboard records: %v", err.Error())
+	}
+	assert.Equal(t, []int64{60, 50, 40}, scores(records))
+
+	// Near the bottom the remainder is filled from better records.
+	records, err = LeaderboardRecordsAroundScore(ctx, logger, db, leaderboardCache, rankCache, descID, 5, 0, 4, 0)
+	if err != nil {
+		t.Fatalf("error listing leaderboard records: %v", err.Error())
+	}
+	assert.Equal(t, []int64{40, 30, 20, 10}, scores(records))
+
+	// Near the top the remainder is filled from worse records.
+	records, err = LeaderboardRecordsAroundScore(ctx, logger, db, leaderboardCache, rankCache, descID, 1000, 0, 3, 0)
+	if err != nil {
+		t.Fatalf("error listing leaderboard records: %v", err.Error())
+	}
+	assert.Equal(t, []int64{100, 90, 80}, scores(records))
+	assert.Equal(t, int64(1), records[0].Rank)
+
+	// Lower scores are better.
+	records, err = LeaderboardRecordsAroundScore(ctx, logger, db, leaderboardCache, rankCache, ascID, 55, 0, 4, 0)
+	if err != nil {
+		t.Fatalf("error listing leaderboard records: %v", err.Error())
+	}
+	assert.Equal(t, []int64{40, 50, 60, 70}, scores(records))
+	assert.Equal(t, int64(4), records[0].Rank)
+
+	_, err = LeaderboardRecordsAroundScore(ctx, logger, db, leaderboardCache, rankCache, uuid.Must(uuid.NewV4()).String(), 55, 0, 4, 0)
+	assert.Equal(t, ErrLeaderboardNotFound, err)
+}
+
+func TestLeaderboardSetResetSchedule(t *testing.T) {
+	db := NewDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	leaderboardCache := NewLocalLeaderboardCache(logger, logger, db)
+	rankCache := NewLocalLeaderboardRankCache(logger, db, NewConfig(logger).GetLeaderboard(), leaderboardCache)
+
+	leaderboardID := uuid.Must(uuid.NewV4()).String()
+	if _, err := leaderboardCache.Create(ctx, leaderboardID, true, LeaderboardSortOrderDescending, LeaderboardOperatorBest, "0 0 * * *", "{}"); err != nil {
+		t.Fatalf("error creating leaderboard: %v", err.Error())
+	}
+
+	_, err := leaderboardCache.SetResetSchedule(ctx, leaderboardID, "not a schedule")
+	assert.Error(t, err)
+	_, err = leaderboardCache.SetResetSchedule(ctx, uuid.Must(uuid.NewV4()).String(), "0 0 1 * *")
+	assert.Equal(t, ErrLeaderboardNotFound, err)
+
+	// Move from a daily to a monthly reset.
+	leaderboard, err := leaderboardCache.SetResetSchedule(ctx, leaderboardID, "0 0 1 * *")
+	if err != nil {
+		t.Fatalf("error setting leaderboard reset schedule: %v", err.Error())
+	}
+	now := time.Now().UTC()
+	nextReset := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, 1, 0)
+	assert.Equal(t, "0 0 1 * *", leaderboard.ResetScheduleStr)
+	assert.Equal(t, nextReset, leaderboard.ResetSchedule.Next(now).UTC())
+	assert.Equal(t, leaderboard, leaderboardCache.Get(leaderboardID))
+
+	// New records expire at the next reset of the new schedule.
+	record, err := LeaderboardRecordWrite(ctx, logger, db, leaderboardCache, rankCache, uuid.Nil, leaderboardID, uuid.Must(uuid.NewV4()).String(), "", 1, 0, "{}")
+	if err != nil {
+		t.Fatalf("error writing leaderboard record: %v", err.Error())
+	}
+	assert.Equal(t, nextReset.Unix(), record.ExpiryTime.Seconds)
+
+	// The new schedule survives a reload from the database.
+	if err := leaderboardCache.RefreshAllLeaderboards(ctx); err != nil {
+		t.Fatalf("error refreshing leaderboards: %v", err.Error())
+	}
+	assert.Equal(t, "0 0 1 * *", leaderboardCache.Get(leaderboardID).ResetScheduleStr)
+
+	// Removing the schedule stops resets entirely.
+	leaderboard, err = leaderboardCache.SetResetSchedule(ctx, leaderboardID, "")
+	if err != nil {
+		t.Fatalf("error removing leaderboard reset schedule: %v", err.Error())
+	}
+	assert.Nil(t, leaderboard.ResetSchedule)
+}
+
+func TestLeaderboardRecordWriteScoreRange(t *testing.T) {
+	db := NewDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	leaderboardCache := NewLocalLeaderboardCache(logger, logger, db)
+	rankCache := NewLocalLeaderboardRankCache(logger, db, NewConfig(logger).GetLeaderboard(), leaderboardCache)
+
+	bestID := uuid.Must(uuid.NewV4()).String()
+	if _, err := leaderboardCache.Create(ctx, bestID, true, LeaderboardSortOrderDescending, LeaderboardOperatorBest, "", "{}"); err != nil {
+		t.Fatalf("error creating leaderboard: %v", err.Error())
+	}
+	incrID := uuid.Must(uuid.NewV4()).String()
+	if _, err := leaderboardCache.Create(ctx, incrID, true, LeaderboardSortOrderDescending, LeaderboardOperatorIncrement, "", "{}"); err != nil {
+		t.Fatalf("error creating leaderboard: %v", err.Error())
+	}
+	ownerID := uuid.Must(uuid.NewV4()).String()
+
+	// The maximum score can be written, and kept as the best score when a close score is submitted after it.
+	for _, score := range []int64{math.MaxInt64 - 1, math.MaxInt64, math.MaxInt64 - 1} {
+		record, err := LeaderboardRecordWrite(ctx, logger, db, leaderboardCache, rankCache, uuid.Nil, bestID, ownerID, "", score, math.MaxInt64, "{}")
+		if err != nil {
+			t.Fatalf("error writing leaderboard record: %v", err.Error())
+		}
+		assert.True(t, record.Score >= score)
+	}
+	list, err := LeaderboardRecordsList(ctx, logger, db, leaderboardCache, rankCache, bestID, nil, "", []string{ownerID}, 0)
+	if err != nil {
+		t.Fatalf("error listing leaderboard records: %v", err.Error())
+	}
+	assert.Len(t, list.OwnerRecords, 1)
+	assert.Equal(t, int64(math.MaxInt64), list.OwnerRecords[0].Score)
+	assert.Equal(t, int64(math.MaxInt64), list.OwnerRecords[0].Subscore)
+
+	// Incrementing past the maximum is rejected and leaves the record unchanged.
+	if _, err := LeaderboardRecordWrite(ctx, logger, db, leaderboardCache, rankCache, uuid.Nil, incrID, ownerID, "", math.MaxInt64, 0, "{}"); err != nil {
+		t.Fatalf("error writing leaderboard record: %v", err.Error())
+	}
+	_, err = LeaderboardRecordWrite(ctx, logger, db, leaderboardCache, rankCache, uuid.Nil, incrID, ownerID, "", 1, 0, "{}")
+	assert.Equal(t, ErrLeaderboardScoreOutOfRange, err)
+	list, err = LeaderboardRecordsList(ctx, logger, db, leaderboardCache, rankCache, incrID, nil, "", []string{ownerID}, 0)
+	if err != nil {
+		t.Fatalf("error listing leaderboard records: %v", err.Error())
+	}
+	assert.Len(t, list.OwnerRecords, 1)
+	assert.Equal(t, int64(math.MaxInt64), list.OwnerRecords[0].Score)
+}
@@ -345,10 +345,9 @@ func LinkSteam(ctx context.Context, logger *zap.Logger, db *sql.DB, config Confi
 		return status.Error(codes.InvalidArgument, "Steam access token is required.")
 	}
 
-	steamProfile, err := socialClient.GetSteamProfile(ctx, config.GetSocial().Steam.PublisherKey, config.GetSocial().Steam.AppID, token)
+	steamProfile, err := getSteamProfile(ctx, logger, socialClient, config.GetSocial().Steam, token)
 	if err != nil {
-		logger.Info("Could not authenticate Steam profile.", zap.Error(err))
-		return status.Error(codes.Unauthenticated, "Could not authenticate Steam profile.")
+		return err
 	}
 
 	res, err := db.ExecContext(ctx, `
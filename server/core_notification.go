@@ -20,6 +20,8 @@ import (
 	"database/sql"
 	"encoding/base64"
 	"encoding/gob"
+	"errors"
+	"fmt"
 	"strconv"
 	"strings"
 
@@ -41,6 +43,39 @@ const (
 	NotificationCodeFriendJoinGame   int32 = -6
 )
 
+// Reserved notification content fields carrying a localization key and its parameters, for clients to render localized
+// text in place of the subject.
+const (
+	NotificationContentLocKey    = "loc_key"
+	NotificationContentLocParams = "loc_params"
+)
+
+// NotificationContentLocalize returns a copy of the given content with a localization key and its parameters added.
+// Parameters must form a flat object, each value a string, number or boolean, and may be nil if the key has none.
+func NotificationContentLocalize(content map[string]interface{}, key string, params map[string]interface{}) (map[string]interface{}, error) {
+	if key == "" {
+		return nil, errors.New("localization key must be a non-empty string")
+	}
+	for k, v := range params {
+		switch v.(type) {
+		case string, bool, int, int32, int64, float32, float64:
+		default:
+			return nil, fmt.Errorf("localization param '%v' must be a string, number or boolean", k)
+		}
+	}
+
+	localized := make(map[string]interface{}, len(content)+2)
+	for k, v := range content {
+		localized[k] = v
+	}
+	localized[NotificationContentLocKey] = key
+	if params == nil {
+		params = make(map[string]interface{})
+	}
+	localized[NotificationContentLocParams] = params
+	return localized, nil
+}
+
 type notificationCacheableCursor struct {
 	NotificationID []byte
 	CreateTime     int64
@@ -0,0 +1,98 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/heroiclabs/nakama-common/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotificationContentLocalize(t *testing.T) {
+	content := map[string]interface{}{"reward_coins": 1000}
+	localized, err := NotificationContentLocalize(content, "level_up", map[string]interface{}{"level": int64(100), "name": "Hero", "bonus": true})
+	if err != nil {
+		t.Fatalf("error localizing content: %v", err.Error())
+	}
+	assert.Equal(t, map[string]interface{}{
+		"reward_coins": 1000,
+		"loc_key":      "level_up",
+		"loc_params":   map[string]interface{}{"level": int64(100), "name": "Hero", "bonus": true},
+	}, localized)
+	assert.Len(t, content, 1, "original content should be unchanged")
+
+	localized, err = NotificationContentLocalize(nil, "welcome", nil)
+	if err != nil {
+		t.Fatalf("error localizing content: %v", err.Error())
+	}
+	assert.Equal(t, map[string]interface{}{"loc_key": "welcome", "loc_params": map[string]interface{}{}}, localized)
+
+	_, err = NotificationContentLocalize(content, "", nil)
+	assert.EqualError(t, err, "localization key must be a non-empty string")
+	_, err = NotificationContentLocalize(content, "level_up", map[string]interface{}{"nested": map[string]interface{}{"a": 1}})
+	assert.EqualError(t, err, "localization param 'nested' must be a string, number or boolean")
+	_, err = NotificationContentLocalize(content, "level_up", map[string]interface{}{"list": []interface{}{1}})
+	assert.EqualError(t, err, "localization param 'list' must be a string, number or boolean")
+}
+
+func TestNotificationSendLocalized(t *testing.T) {
+	db := NewDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	userID, _, _, err := AuthenticateCustom(ctx, logger, db, uuid.Must(uuid.NewV4()).String(), uuid.Must(uuid.NewV4()).String(), true)
+	if err != nil {
+		t.Fatalf("error creating user: %v", err.Error())
+	}
+	uid := uuid.FromStringOrNil(userID)
+
+	content, err := NotificationContentLocalize(map[string]interface{}{"reward_coins": 1000}, "level_up", map[string]interface{}{"level": 100, "name": "Hero"})
+	if err != nil {
+		t.Fatalf("error localizing content: %v", err.Error())
+	}
+	contentBytes, err := json.Marshal(content)
+	if err != nil {
+		t.Fatalf("error converting content: %v", err.Error())
+	}
+
+	notifications := map[uuid.UUID][]*api.Notification{
+		uid: {{
+			Id:         uuid.Must(uuid.NewV4()).String(),
+			Subject:    "You've unlocked level 100!",
+			Content:    string(contentBytes),
+			Code:       1,
+			SenderId:   uuid.Nil.String(),
+			Persistent: true,
+			CreateTime: &timestamp.Timestamp{Seconds: time.Now().UTC().Unix()},
+		}},
+	}
+	if err := NotificationSend(ctx, logger, db, &DummyMessageRouter{}, notifications); err != nil {
+		t.Fatalf("error sending notification: %v", err.Error())
+	}
+
+	list, err := NotificationList(ctx, logger, db, uid, 10, "", nil)
+	if err != nil {
+		t.Fatalf("error listing notifications: %v", err.Error())
+	}
+	if assert.Len(t, list.Notifications, 1) {
+		assert.JSONEq(t, `{"reward_coins":1000,"loc_key":"level_up","loc_params":{"level":100,"name":"Hero"}}`, list.Notifications[0].Content)
+	}
+}
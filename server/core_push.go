@@ -0,0 +1,370 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"database/sql"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gofrs/uuid"
+	"github.com/heroiclabs/nakama-common/api"
+	"go.uber.org/zap"
+)
+
+// Storage key, within the configured push token collection, of each user's registered device tokens.
+const PushTokensStorageKey = "tokens"
+
+const (
+	pushFcmURL             = "https://fcm.googleapis.com/fcm/send"
+	pushApnsURL            = "https://api.push.apple.com/3/device/"
+	pushApnsDevelopmentURL = "https://api.development.push.apple.com/3/device/"
+	// APNs rejects provider tokens older than an hour, and refreshing more than once every 20 minutes.
+	pushApnsTokenRefresh = 30 * time.Minute
+
+	// Pending push deliveries, beyond which further notifications are not pushed, and the workers delivering them.
+	pushQueueSize    = 1024
+	pushQueueWorkers = 8
+)
+
+var ErrPushApnsKeyInvalid = errors.New("apns key must be a PEM encoded PKCS8 ECDSA private key")
+
+// Device tokens a user has registered for push delivery, grouped by provider.
+type PushTokens struct {
+	Fcm  []string `json:"fcm"`
+	Apns []string `json:"apns"`
+}
+
+// PushProvider delivers a notification to a user's devices.
+type PushProvider interface {
+	Push(ctx context.Context, tokens *PushTokens, notification *api.Notification) error
+}
+
+type LocalPushProvider struct {
+	sync.Mutex
+	config  *SocialConfigPush
+	client  *http.Client
+	fcmURL  string
+	apnsURL string
+
+	apnsKey          *ecdsa.PrivateKey
+	apnsToken        string
+	apnsTokenExpires time.Time
+}
+
+// NewPushProvider returns a push provider for the given configuration, or nil if push delivery is disabled.
+func NewPushProvider(config *SocialConfigPush) (PushProvider, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+
+	p := &LocalPushProvider{
+		config: config,
+		client: &http.Client{
+			Timeout: 5 * time.Second,
+		},
+		fcmURL:  pushFcmURL,
+		apnsURL: pushApnsDevelopmentURL,
+	}
+	if config.ApnsProduction {
+		p.apnsURL = pushApnsURL
+	}
+
+	if config.ApnsKey != "" {
+		key, err := PushParseApnsKey(config.ApnsKey)
+		if err != nil {
+			return nil, err
+		}
+		p.apnsKey = key
+	}
+
+	return p, nil
+}
+
+// PushParseApnsKey parses a PEM encoded PKCS8 ECDSA private key used to sign APNs provider tokens.
+func PushParseApnsKey(apnsKey string) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(apnsKey))
+	if block == nil {
+		return nil, ErrPushApnsKeyInvalid
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, ErrPushApnsKeyInvalid
+	}
+	ecdsaKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, ErrPushApnsKeyInvalid
+	}
+	return ecdsaKey, nil
+}
+
+func (p *LocalPushProvider) Push(ctx context.Context, tokens *PushTokens, notification *api.Notification) error {
+	var errs []error
+	if p.config.FcmServerKey != "" {
+		for _, token := range tokens.Fcm {
+			if err := p.pushFcm(ctx, token, notification); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if p.apnsKey != nil {
+		for _, token := range tokens.Apns {
+			if err := p.pushApns(ctx, token, notification); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	if len(errs) != 0 {
+		return fmt.Errorf("push failed for %v device tokens, first error: %v", len(errs), errs[0].Error())
+	}
+	return nil
+}
+
+func (p *LocalPushProvider) pushFcm(ctx context.Context, token string, notification *api.Notification) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"to": token,
+		"notification": map[string]string{
+			"title": notification.Subject,
+		},
+		"data": map[string]interface{}{
+			"id":      notification.Id,
+			"code":    notification.Code,
+			"content": notification.Content,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return p.request(ctx, p.fcmURL, map[string]string{"Authorization": "key=" + p.config.FcmServerKey}, body)
+}
+
+func (p *LocalPushProvider) pushApns(ctx context.Context, token string, notification *api.Notification) error {
+	providerToken, err := p.apnsProviderToken()
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"aps": map[string]interface{}{
+			"alert": map[string]string{
+				"title": notification.Subject,
+			},
+		},
+		"id":      notification.Id,
+		"code":    notification.Code,
+		"content": notification.Content,
+	})
+	if err != nil {
+		return err
+	}
+
+	return p.request(ctx, p.apnsURL+token, map[string]string{
+		"Authorization": "bearer " + providerToken,
+		"apns-topic":    p.config.ApnsTopic,
+	}, body)
+}
+
+// Provider tokens are signed with the APNs key and reused until close to their expiry.
+func (p *LocalPushProvider) apnsProviderToken() (string, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	now := time.Now().UTC()
+	if p.apnsToken != "" && now.Before(p.apnsTokenExpires) {
+		return p.apnsToken, nil
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss": p.config.ApnsTeamId,
+		"iat": now.Unix(),
+	})
+	token.Header["kid"] = p.config.ApnsKeyId
+	signed, err := token.SignedString(p.apnsKey)
+	if err != nil {
+		return "", err
+	}
+
+	p.apnsToken = signed
+	p.apnsTokenExpires = now.Add(pushApnsTokenRefresh)
+	return signed, nil
+}
+
+func (p *LocalPushProvider) request(ctx context.Context, url string, headers map[string]string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("push provider returned status %v", resp.StatusCode)
+	}
+	return nil
+}
+
+// PushQueue forwards notifications to the devices of offline users in the background. A fixed number of workers deliver
+// queued notifications, and notifications are dropped rather than queued without bound if delivery falls behind.
+type PushQueue struct {
+	logger     *zap.Logger
+	db         *sql.DB
+	tracker    Tracker
+	provider   PushProvider
+	collection string
+
+	ch chan map[uuid.UUID][]*api.Notification
+
+	ctx         context.Context
+	ctxCancelFn context.CancelFunc
+}
+
+// StartPushQueue starts delivering queued notifications with the push provider for the given configuration. If push
+// delivery is disabled notifications are never queued.
+func StartPushQueue(logger *zap.Logger, db *sql.DB, config Config, tracker Tracker) (*PushQueue, error) {
+	provider, err := NewPushProvider(config.GetSocial().Push)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &PushQueue{
+		logger:     logger,
+		db:         db,
+		tracker:    tracker,
+		provider:   provider,
+		collection: config.GetSocial().Push.TokenCollection,
+
+		ch: make(chan map[uuid.UUID][]*api.Notification, pushQueueSize),
+	}
+	q.ctx, q.ctxCancelFn = context.WithCancel(context.Background())
+
+	if provider != nil {
+		for i := 0; i < pushQueueWorkers; i++ {
+			go func() {
+				for {
+					select {
+					case <-q.ctx.Done():
+						return
+					case notifications := <-q.ch:
+						NotificationPush(q.ctx, q.logger, q.db, q.tracker, q.provider, q.collection, notifications)
+					}
+				}
+			}()
+		}
+	}
+
+	return q, nil
+}
+
+// Queue notifications to be pushed to any recipients that are offline, without waiting for delivery.
+func (q *PushQueue) Queue(notifications map[uuid.UUID][]*api.Notification) {
+	if q == nil || q.provider == nil {
+		return
+	}
+
+	select {
+	case q.ch <- notifications:
+		// Notifications queued successfully.
+	default:
+		// Push queue is full, drop the notifications to avoid blocking the caller.
+		q.logger.Warn("Push queue full, notifications will not be pushed", zap.Int("users", len(notifications)))
+	}
+}
+
+func (q *PushQueue) Stop() {
+	q.ctxCancelFn()
+}
+
+// NotificationPush forwards persistent notifications to the registered devices of any recipients that are not
+// currently connected. Delivery is best-effort, failures are logged and do not affect the notifications themselves.
+func NotificationPush(ctx context.Context, logger *zap.Logger, db *sql.DB, tracker Tracker, provider PushProvider, collection string, notifications map[uuid.UUID][]*api.Notification) {
+	if provider == nil {
+		return
+	}
+
+	for userID, ns := range notifications {
+		if tracker.CountByStream(PresenceStream{Mode: StreamModeNotifications, Subject: userID}) != 0 {
+			// User is online and received the notifications live.
+			continue
+		}
+
+		var persistent []*api.Notification
+		for _, n := range ns {
+			if n.Persistent {
+				persistent = append(persistent, n)
+			}
+		}
+		if len(persistent) == 0 {
+			continue
+		}
+
+		tokens, err := pushTokensGet(ctx, logger, db, collection, userID)
+		if err != nil {
+			logger.Warn("Could not read push device tokens.", zap.String("user_id", userID.String()), zap.Error(err))
+			continue
+		}
+		if tokens == nil || len(tokens.Fcm)+len(tokens.Apns) == 0 {
+			continue
+		}
+
+		for _, n := range persistent {
+			if err := provider.Push(ctx, tokens, n); err != nil {
+				logger.Warn("Could not push notification.", zap.String("user_id", userID.String()), zap.String("notification_id", n.Id), zap.Error(err))
+			}
+		}
+	}
+}
+
+func pushTokensGet(ctx context.Context, logger *zap.Logger, db *sql.DB, collection string, userID uuid.UUID) (*PushTokens, error) {
+	objects, err := StorageReadObjects(ctx, logger, db, uuid.Nil, []*api.ReadStorageObjectId{{
+		Collection: collection,
+		Key:        PushTokensStorageKey,
+		UserId:     userID.String(),
+	}})
+	if err != nil {
+		return nil, err
+	}
+	if len(objects.Objects) == 0 {
+		return nil, nil
+	}
+
+	var tokens PushTokens
+	if err := json.Unmarshal([]byte(objects.Objects[0].Value), &tokens); err != nil {
+		return nil, err
+	}
+	return &tokens, nil
+}
@@ -0,0 +1,224 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gofrs/uuid"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/heroiclabs/nakama-common/api"
+	"github.com/stretchr/testify/assert"
+)
+
+type testPushProvider struct {
+	sync.Mutex
+	pushed map[string][]string
+}
+
+func (p *testPushProvider) Push(ctx context.Context, tokens *PushTokens, notification *api.Notification) error {
+	p.Lock()
+	p.pushed[notification.Id] = append(tokens.Fcm, tokens.Apns...)
+	p.Unlock()
+	return nil
+}
+
+func TestNewPushProvider(t *testing.T) {
+	config := NewSocialConfig().Push
+
+	provider, err := NewPushProvider(config)
+	assert.NoError(t, err)
+	assert.Nil(t, provider, "push delivery should be disabled by default")
+
+	config.Enabled = true
+	provider, err = NewPushProvider(config)
+	assert.NoError(t, err)
+	assert.NotNil(t, provider)
+
+	config.ApnsKey = "not a key"
+	_, err = NewPushProvider(config)
+	assert.Equal(t, ErrPushApnsKeyInvalid, err)
+}
+
+func TestPushProviderRequests(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating apns key: %v", err.Error())
+	}
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("error encoding apns key: %v", err.Error())
+	}
+
+	type pushRequest struct {
+		path   string
+		header http.Header
+		body   map[string]interface{}
+	}
+	var mu sync.Mutex
+	var requests []*pushRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		body := make(map[string]interface{})
+		assert.NoError(t, json.Unmarshal(b, &body))
+		mu.Lock()
+		requests = append(requests, &pushRequest{path: r.URL.Path, header: r.Header, body: body})
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	config := NewSocialConfig().Push
+	config.Enabled = true
+	config.FcmServerKey = "fcm-server-key"
+	config.ApnsKey = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes}))
+	config.ApnsKeyId = "key-id"
+	config.ApnsTeamId = "team-id"
+	config.ApnsTopic = "com.example.game"
+	provider, err := NewPushProvider(config)
+	if err != nil {
+		t.Fatalf("error creating push provider: %v", err.Error())
+	}
+	p := provider.(*LocalPushProvider)
+	p.fcmURL = srv.URL + "/fcm/send"
+	p.apnsURL = srv.URL + "/3/device/"
+
+	err = p.Push(context.Background(), &PushTokens{Fcm: []string{"fcm-token"}, Apns: []string{"apns-token"}}, &api.Notification{
+		Id:      "notification-id",
+		Subject: "subject",
+		Content: `{"reward":10}`,
+		Code:    101,
+	})
+	if err != nil {
+		t.Fatalf("error pushing notification: %v", err.Error())
+	}
+
+	if !assert.Len(t, requests, 2) {
+		return
+	}
+
+	fcm := requests[0]
+	assert.Equal(t, "/fcm/send", fcm.path)
+	assert.Equal(t, "key=fcm-server-key", fcm.header.Get("Authorization"))
+	assert.Equal(t, "application/json", fcm.header.Get("Content-Type"))
+	assert.Equal(t, map[string]interface{}{
+		"to":           "fcm-token",
+		"notification": map[string]interface{}{"title": "subject"},
+		"data":         map[string]interface{}{"id": "notification-id", "code": float64(101), "content": `{"reward":10}`},
+	}, fcm.body)
+
+	apns := requests[1]
+	assert.Equal(t, "/3/device/apns-token", apns.path)
+	assert.Equal(t, "com.example.game", apns.header.Get("apns-topic"))
+	assert.Equal(t, map[string]interface{}{
+		"aps":     map[string]interface{}{"alert": map[string]interface{}{"title": "subject"}},
+		"id":      "notification-id",
+		"code":    float64(101),
+		"content": `{"reward":10}`,
+	}, apns.body)
+
+	// The provider token is signed with the APNs key and identifies the key and team.
+	authorization := apns.header.Get("Authorization")
+	if assert.Regexp(t, "^bearer ", authorization) {
+		token, err := jwt.Parse(authorization[len("bearer "):], func(token *jwt.Token) (interface{}, error) {
+			return &key.PublicKey, nil
+		})
+		if assert.NoError(t, err) {
+			assert.Equal(t, "key-id", token.Header["kid"])
+			assert.Equal(t, "ES256", token.Header["alg"])
+			assert.Equal(t, "team-id", token.Claims.(jwt.MapClaims)["iss"])
+		}
+	}
+}
+
+func TestPushQueueDisabled(t *testing.T) {
+	queue, err := StartPushQueue(logger, nil, cfg, nil)
+	if err != nil {
+		t.Fatalf("error starting push queue: %v", err.Error())
+	}
+	defer queue.Stop()
+
+	// Nothing is queued when push delivery is disabled.
+	queue.Queue(map[uuid.UUID][]*api.Notification{uuid.Must(uuid.NewV4()): {{Id: "id", Persistent: true}}})
+	assert.Len(t, queue.ch, 0)
+}
+
+func TestNotificationPushOfflineUser(t *testing.T) {
+	db := NewDB(t)
+	defer db.Close()
+
+	collection := GenerateString()
+	offlineID := uuid.Must(uuid.NewV4())
+	InsertUser(t, db, offlineID)
+	onlineID := uuid.Must(uuid.NewV4())
+	InsertUser(t, db, onlineID)
+
+	// Both users have registered device tokens.
+	ops := StorageOpWrites{
+		&StorageOpWrite{
+			OwnerID: offlineID.String(),
+			Object: &api.WriteStorageObject{
+				Collection:      collection,
+				Key:             PushTokensStorageKey,
+				Value:           `{"fcm":["fcm-offline"],"apns":["apns-offline"]}`,
+				PermissionRead:  &wrappers.Int32Value{Value: 1},
+				PermissionWrite: &wrappers.Int32Value{Value: 1},
+			},
+		},
+		&StorageOpWrite{
+			OwnerID: onlineID.String(),
+			Object: &api.WriteStorageObject{
+				Collection:      collection,
+				Key:             PushTokensStorageKey,
+				Value:           `{"fcm":["fcm-online"]}`,
+				PermissionRead:  &wrappers.Int32Value{Value: 1},
+				PermissionWrite: &wrappers.Int32Value{Value: 1},
+			},
+		},
+	}
+	if _, _, err := StorageWriteObjects(context.Background(), logger, db, true, 0, ops); err != nil {
+		t.Fatalf("error writing push tokens: %v", err.Error())
+	}
+
+	sessionRegistry := NewLocalSessionRegistry(metrics)
+	tracker := StartLocalTracker(logger, cfg, sessionRegistry, metrics, jsonpbMarshaler)
+	defer tracker.Stop()
+	tracker.Track(uuid.Must(uuid.NewV4()), PresenceStream{Mode: StreamModeNotifications, Subject: onlineID}, onlineID, PresenceMeta{}, false)
+
+	provider := &testPushProvider{pushed: make(map[string][]string)}
+	NotificationPush(context.Background(), logger, db, tracker, provider, collection, map[uuid.UUID][]*api.Notification{
+		offlineID: {
+			{Id: "persistent-offline", Subject: "subject", Persistent: true},
+			{Id: "transient-offline", Subject: "subject", Persistent: false},
+		},
+		onlineID: {
+			{Id: "persistent-online", Subject: "subject", Persistent: true},
+		},
+	})
+
+	assert.Len(t, provider.pushed, 1, "only persistent notifications for offline users should be pushed")
+	assert.ElementsMatch(t, []string{"fcm-offline", "apns-offline"}, provider.pushed["persistent-offline"])
+}
@@ -0,0 +1,88 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/rand"
+	"errors"
+)
+
+// Uppercase letters and digits, without the easily confused I, O, 0 and 1.
+const RandomStringDefaultAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+const (
+	RandomStringMaxLength   = 1024
+	randomStringMaxAlphabet = 256
+
+	// Invite codes are two groups of characters from the default alphabet, for example "K7QX-M2RD".
+	inviteCodeGroupLength = 4
+)
+
+var (
+	ErrRandomStringLength   = errors.New("random string length must be between 1 and 1024")
+	ErrRandomStringAlphabet = errors.New("random string alphabet must have between 2 and 256 distinct characters")
+)
+
+// RandomString returns a string of the given length with characters picked uniformly at random from the alphabet,
+// using a cryptographically secure source. An empty alphabet selects the default one.
+func RandomString(length int, alphabet string) (string, error) {
+	if length < 1 || length > RandomStringMaxLength {
+		return "", ErrRandomStringLength
+	}
+	if alphabet == "" {
+		alphabet = RandomStringDefaultAlphabet
+	}
+	chars := []rune(alphabet)
+	if len(chars) < 2 || len(chars) > randomStringMaxAlphabet {
+		return "", ErrRandomStringAlphabet
+	}
+	// Repeated characters would be picked more often than the others.
+	seen := make(map[rune]struct{}, len(chars))
+	for _, c := range chars {
+		if _, found := seen[c]; found {
+			return "", ErrRandomStringAlphabet
+		}
+		seen[c] = struct{}{}
+	}
+
+	// Discard random bytes at or above the largest multiple of the alphabet size, so each character is equally likely.
+	limit := 256 - 256%len(chars)
+	result := make([]rune, 0, length)
+	buf := make([]byte, length)
+	for len(result) < length {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) >= limit {
+				continue
+			}
+			result = append(result, chars[int(b)%len(chars)])
+			if len(result) == length {
+				break
+			}
+		}
+	}
+	return string(result), nil
+}
+
+// InviteCode returns a short random code that is easy to read out and type, such as for room codes or invites.
+func InviteCode() (string, error) {
+	code, err := RandomString(inviteCodeGroupLength*2, RandomStringDefaultAlphabet)
+	if err != nil {
+		return "", err
+	}
+	return code[:inviteCodeGroupLength] + "-" + code[inviteCodeGroupLength:], nil
+}
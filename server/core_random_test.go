@@ -0,0 +1,84 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRandomString(t *testing.T) {
+	for _, length := range []int{1, 6, RandomStringMaxLength} {
+		s, err := RandomString(length, "")
+		if err != nil {
+			t.Fatalf("error generating random string: %v", err.Error())
+		}
+		assert.Equal(t, length, len(s))
+		for _, c := range s {
+			assert.True(t, strings.ContainsRune(RandomStringDefaultAlphabet, c), "unexpected character %q", c)
+		}
+	}
+
+	// Custom alphabets, including multi-byte characters.
+	s, err := RandomString(200, "ab")
+	if err != nil {
+		t.Fatalf("error generating random string: %v", err.Error())
+	}
+	assert.Regexp(t, "^[ab]{200}$", s)
+	s, err = RandomString(10, "αβγ")
+	if err != nil {
+		t.Fatalf("error generating random string: %v", err.Error())
+	}
+	assert.Regexp(t, "^[αβγ]{10}$", s)
+
+	for _, length := range []int{-1, 0, RandomStringMaxLength + 1} {
+		_, err := RandomString(length, "")
+		assert.Equal(t, ErrRandomStringLength, err, length)
+	}
+	for _, alphabet := range []string{"a", "aba", strings.Repeat("x", 300)} {
+		_, err := RandomString(8, alphabet)
+		assert.Equal(t, ErrRandomStringAlphabet, err, alphabet)
+	}
+}
+
+func TestRandomStringUnique(t *testing.T) {
+	seen := make(map[string]struct{}, 10000)
+	for i := 0; i < 10000; i++ {
+		s, err := RandomString(12, "")
+		if err != nil {
+			t.Fatalf("error generating random string: %v", err.Error())
+		}
+		_, found := seen[s]
+		assert.False(t, found, "duplicate random string %v", s)
+		seen[s] = struct{}{}
+	}
+}
+
+func TestInviteCode(t *testing.T) {
+	re := regexp.MustCompile("^[" + RandomStringDefaultAlphabet + "]{4}-[" + RandomStringDefaultAlphabet + "]{4}$")
+	seen := make(map[string]struct{}, 1000)
+	for i := 0; i < 1000; i++ {
+		code, err := InviteCode()
+		if err != nil {
+			t.Fatalf("error generating invite code: %v", err.Error())
+		}
+		assert.Regexp(t, re, code)
+		seen[code] = struct{}{}
+	}
+	assert.Len(t, seen, 1000)
+}
@@ -0,0 +1,211 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"crypto"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/pgtype"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrSessionRefreshInvalid = errors.New("refresh token invalid")
+	ErrSessionRefreshReused  = errors.New("refresh token reused, session revoked")
+)
+
+// Refresh tokens only identify their family and position in it. The username and vars of refreshed sessions are read
+// when the session is refreshed, so changes made since the family was started are reflected.
+type SessionRefreshTokenClaims struct {
+	TokenId   string `json:"tid,omitempty"`
+	FamilyId  string `json:"fid,omitempty"`
+	UserId    string `json:"uid,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+}
+
+func (c *SessionRefreshTokenClaims) Valid() error {
+	// Verify expiry.
+	if c.ExpiresAt <= time.Now().UTC().Unix() {
+		vErr := new(jwt.ValidationError)
+		vErr.Inner = errors.New("Token is expired")
+		vErr.Errors |= jwt.ValidationErrorExpired
+		return vErr
+	}
+	return nil
+}
+
+// SessionRefreshTokens is a session token and the refresh token that can be exchanged for its replacement.
+type SessionRefreshTokens struct {
+	Token        string
+	Exp          int64
+	RefreshToken string
+	RefreshExp   int64
+}
+
+// SessionRefreshGenerate issues a session token along with a refresh token starting a new refresh token family. Each
+// family is a chain of refresh tokens rotated from the same original token, and only its latest token may be used.
+func SessionRefreshGenerate(ctx context.Context, logger *zap.Logger, db *sql.DB, config Config, userID uuid.UUID, username string, vars map[string]string) (*SessionRefreshTokens, error) {
+	varsData, err := json.Marshal(vars)
+	if err != nil {
+		logger.Error("Could not encode refresh token family vars", zap.Error(err))
+		return nil, err
+	}
+	if vars == nil {
+		varsData = []byte("{}")
+	}
+
+	familyID := uuid.Must(uuid.NewV4())
+	tokenID := uuid.Must(uuid.NewV4())
+	refreshExp := time.Now().UTC().Add(time.Duration(config.GetSession().RefreshTokenExpirySec) * time.Second)
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Error("Could not begin database transaction.", zap.Error(err))
+		return nil, err
+	}
+	if err := ExecuteInTx(ctx, tx, func() error {
+		// Families are only needed until their latest refresh token expires.
+		if _, err := tx.ExecContext(ctx, "DELETE FROM user_session_refresh WHERE user_id = $1 AND expiry_time <= now()", userID); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, "INSERT INTO user_session_refresh (user_id, family_id, token_id, vars, expiry_time) VALUES ($1, $2, $3, $4, $5)", userID, familyID, tokenID, varsData, refreshExp)
+		return err
+	}); err != nil {
+		logger.Error("Could not create refresh token family", zap.Error(err), zap.String("user_id", userID.String()))
+		return nil, err
+	}
+
+	return sessionRefreshIssue(logger, config, userID, username, vars, familyID, tokenID, refreshExp)
+}
+
+// SessionRefresh exchanges a refresh token for a new session token and refresh token, invalidating the one presented.
+// The new session carries the user's current username, and the given vars if any, otherwise those of the family.
+// Presenting a refresh token that has already been exchanged revokes its whole family and every session token issued
+// to the user so far, so neither the holder of the stolen token nor the legitimate user can continue without
+// authenticating again.
+func SessionRefresh(ctx context.Context, logger *zap.Logger, db *sql.DB, config Config, sessionCache SessionCache, refreshToken string, vars map[string]string) (*SessionRefreshTokens, error) {
+	claims, ok := parseSessionRefreshToken([]byte(config.GetSession().RefreshEncryptionKey), refreshToken)
+	if !ok {
+		return nil, ErrSessionRefreshInvalid
+	}
+	userID, err := uuid.FromString(claims.UserId)
+	if err != nil {
+		return nil, ErrSessionRefreshInvalid
+	}
+	familyID, err := uuid.FromString(claims.FamilyId)
+	if err != nil {
+		return nil, ErrSessionRefreshInvalid
+	}
+
+	query := `
+SELECT u.username, u.disable_time, r.token_id, r.vars, r.revoked
+FROM user_session_refresh r JOIN users u ON u.id = r.user_id
+WHERE r.user_id = $1 AND r.family_id = $2 AND r.expiry_time > now()`
+	var username string
+	var disableTime pgtype.Timestamptz
+	var tokenID string
+	var varsData []byte
+	var revoked bool
+	if err := db.QueryRowContext(ctx, query, userID, familyID).Scan(&username, &disableTime, &tokenID, &varsData, &revoked); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSessionRefreshInvalid
+		}
+		logger.Error("Could not read refresh token family", zap.Error(err), zap.String("user_id", claims.UserId))
+		return nil, err
+	}
+	if revoked || (disableTime.Status == pgtype.Present && disableTime.Time.Unix() != 0) {
+		return nil, ErrSessionRefreshInvalid
+	}
+
+	if tokenID != claims.TokenId {
+		// An older token in the family was presented again, assume it was stolen.
+		return nil, sessionRefreshRevoke(ctx, logger, db, sessionCache, userID, familyID)
+	}
+
+	if vars == nil {
+		if err := json.Unmarshal(varsData, &vars); err != nil {
+			logger.Error("Could not decode refresh token family vars", zap.Error(err), zap.String("user_id", claims.UserId))
+			return nil, err
+		}
+	} else if varsData, err = json.Marshal(vars); err != nil {
+		logger.Error("Could not encode refresh token family vars", zap.Error(err))
+		return nil, err
+	}
+
+	// Rotate only if the family is unchanged since it was read, a concurrent refresh with the same token is a reuse.
+	newTokenID := uuid.Must(uuid.NewV4())
+	refreshExp := time.Now().UTC().Add(time.Duration(config.GetSession().RefreshTokenExpirySec) * time.Second)
+	res, err := db.ExecContext(ctx, "UPDATE user_session_refresh SET token_id = $3, vars = $4, expiry_time = $5 WHERE user_id = $1 AND family_id = $2 AND token_id = $6 AND NOT revoked", userID, familyID, newTokenID, varsData, refreshExp, tokenID)
+	if err != nil {
+		logger.Error("Could not rotate refresh token family", zap.Error(err), zap.String("user_id", claims.UserId))
+		return nil, err
+	}
+	if rowsAffected, _ := res.RowsAffected(); rowsAffected != 1 {
+		return nil, sessionRefreshRevoke(ctx, logger, db, sessionCache, userID, familyID)
+	}
+
+	return sessionRefreshIssue(logger, config, userID, username, vars, familyID, newTokenID, refreshExp)
+}
+
+func sessionRefreshIssue(logger *zap.Logger, config Config, userID uuid.UUID, username string, vars map[string]string, familyID, tokenID uuid.UUID, refreshExp time.Time) (*SessionRefreshTokens, error) {
+	token, exp := generateToken(config, userID.String(), username, vars)
+	refreshToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &SessionRefreshTokenClaims{
+		TokenId:   tokenID.String(),
+		FamilyId:  familyID.String(),
+		UserId:    userID.String(),
+		ExpiresAt: refreshExp.Unix(),
+	}).SignedString([]byte(config.GetSession().RefreshEncryptionKey))
+	if err != nil {
+		logger.Error("Could not sign refresh token", zap.Error(err))
+		return nil, err
+	}
+
+	return &SessionRefreshTokens{Token: token, Exp: exp, RefreshToken: refreshToken, RefreshExp: refreshExp.Unix()}, nil
+}
+
+// Revoke a refresh token family along with all session tokens issued to its user, and report the reuse.
+func sessionRefreshRevoke(ctx context.Context, logger *zap.Logger, db *sql.DB, sessionCache SessionCache, userID, familyID uuid.UUID) error {
+	if _, err := db.ExecContext(ctx, "UPDATE user_session_refresh SET revoked = true WHERE user_id = $1 AND family_id = $2", userID, familyID); err != nil {
+		logger.Error("Could not revoke refresh token family", zap.Error(err), zap.String("user_id", userID.String()))
+		return err
+	}
+	sessionCache.RemoveAll(userID)
+	logger.Warn("Refresh token reused, revoked refresh token family and sessions", zap.String("user_id", userID.String()), zap.String("family_id", familyID.String()))
+	return ErrSessionRefreshReused
+}
+
+func parseSessionRefreshToken(hmacSecretByte []byte, tokenString string) (*SessionRefreshTokenClaims, bool) {
+	token, err := jwt.ParseWithClaims(tokenString, &SessionRefreshTokenClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if s, ok := token.Method.(*jwt.SigningMethodHMAC); !ok || s.Hash != crypto.SHA256 {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return hmacSecretByte, nil
+	})
+	if err != nil {
+		return nil, false
+	}
+	claims, ok := token.Claims.(*SessionRefreshTokenClaims)
+	if !ok || !token.Valid || claims.TokenId == "" || claims.FamilyId == "" {
+		return nil, false
+	}
+	return claims, true
+}
@@ -0,0 +1,133 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func createSessionRefreshTestUser(t *testing.T, db *sql.DB) uuid.UUID {
+	userID, _, _, err := AuthenticateCustom(context.Background(), logger, db, uuid.Must(uuid.NewV4()).String(), uuid.Must(uuid.NewV4()).String(), true)
+	if err != nil {
+		t.Fatalf("error creating user: %v", err.Error())
+	}
+	return uuid.FromStringOrNil(userID)
+}
+
+func TestSessionRefresh(t *testing.T) {
+	db := NewDB(t)
+	defer db.Close()
+	sessionCache := NewLocalSessionCache(cfg)
+	defer sessionCache.Stop()
+
+	ctx := context.Background()
+	userID := createSessionRefreshTestUser(t, db)
+	vars := map[string]string{"region": "eu"}
+
+	tokens, err := SessionRefreshGenerate(ctx, logger, db, cfg, userID, "alice", vars)
+	if err != nil {
+		t.Fatalf("error generating session: %v", err.Error())
+	}
+
+	// Refresh tokens are not accepted as session tokens.
+	_, _, _, _, ok := parseToken([]byte(cfg.GetSession().EncryptionKey), sessionCache, tokens.RefreshToken)
+	assert.False(t, ok)
+
+	// Refreshed sessions carry the user's current username and the family's vars.
+	if _, err := db.ExecContext(ctx, "UPDATE users SET username = $2 WHERE id = $1", userID, userID.String()); err != nil {
+		t.Fatalf("error updating username: %v", err.Error())
+	}
+	refreshed, err := SessionRefresh(ctx, logger, db, cfg, sessionCache, tokens.RefreshToken, nil)
+	if err != nil {
+		t.Fatalf("error refreshing session: %v", err.Error())
+	}
+	assert.NotEqual(t, tokens.RefreshToken, refreshed.RefreshToken)
+	parsedUserID, username, parsedVars, _, ok := parseToken([]byte(cfg.GetSession().EncryptionKey), sessionCache, refreshed.Token)
+	if assert.True(t, ok) {
+		assert.Equal(t, userID, parsedUserID)
+		assert.Equal(t, userID.String(), username)
+		assert.Equal(t, vars, parsedVars)
+	}
+
+	// New vars replace those of the family for this and later refreshes.
+	newVars := map[string]string{"region": "us"}
+	refreshed, err = SessionRefresh(ctx, logger, db, cfg, sessionCache, refreshed.RefreshToken, newVars)
+	if err != nil {
+		t.Fatalf("error refreshing session: %v", err.Error())
+	}
+	refreshed, err = SessionRefresh(ctx, logger, db, cfg, sessionCache, refreshed.RefreshToken, nil)
+	if err != nil {
+		t.Fatalf("error refreshing session: %v", err.Error())
+	}
+	_, _, parsedVars, _, ok = parseToken([]byte(cfg.GetSession().EncryptionKey), sessionCache, refreshed.Token)
+	if assert.True(t, ok) {
+		assert.Equal(t, newVars, parsedVars)
+	}
+
+	_, err = SessionRefresh(ctx, logger, db, cfg, sessionCache, "not a token", nil)
+	assert.Equal(t, ErrSessionRefreshInvalid, err)
+}
+
+func TestSessionRefreshReuse(t *testing.T) {
+	db := NewDB(t)
+	defer db.Close()
+	sessionCache := NewLocalSessionCache(cfg)
+	defer sessionCache.Stop()
+
+	ctx := context.Background()
+	userID := createSessionRefreshTestUser(t, db)
+	tokens, err := SessionRefreshGenerate(ctx, logger, db, cfg, userID, "bob", nil)
+	if err != nil {
+		t.Fatalf("error generating session: %v", err.Error())
+	}
+	other, err := SessionRefreshGenerate(ctx, logger, db, cfg, userID, "bob", nil)
+	if err != nil {
+		t.Fatalf("error generating session: %v", err.Error())
+	}
+
+	// Make sure the sessions issued so far predate the revocation.
+	time.Sleep(time.Second)
+	refreshed, err := SessionRefresh(ctx, logger, db, cfg, sessionCache, tokens.RefreshToken, nil)
+	if err != nil {
+		t.Fatalf("error refreshing session: %v", err.Error())
+	}
+	_, _, _, _, ok := parseToken([]byte(cfg.GetSession().EncryptionKey), sessionCache, refreshed.Token)
+	assert.True(t, ok)
+
+	// A stolen copy of the original refresh token is presented again.
+	time.Sleep(time.Second)
+	_, err = SessionRefresh(ctx, logger, db, cfg, sessionCache, tokens.RefreshToken, nil)
+	assert.Equal(t, ErrSessionRefreshReused, err)
+
+	// The whole family is revoked, including the latest refresh token.
+	_, err = SessionRefresh(ctx, logger, db, cfg, sessionCache, refreshed.RefreshToken, nil)
+	assert.Equal(t, ErrSessionRefreshInvalid, err)
+
+	// Session tokens already issued to the user are revoked too.
+	_, _, _, _, ok = parseToken([]byte(cfg.GetSession().EncryptionKey), sessionCache, refreshed.Token)
+	assert.False(t, ok)
+	_, _, _, _, ok = parseToken([]byte(cfg.GetSession().EncryptionKey), sessionCache, other.Token)
+	assert.False(t, ok)
+
+	// Other refresh token families for the same user can still be used.
+	_, err = SessionRefresh(ctx, logger, db, cfg, sessionCache, other.RefreshToken, nil)
+	assert.NoError(t, err)
+}
@@ -24,6 +24,7 @@ import (
 	"fmt"
 	"github.com/jackc/pgx"
 	"sort"
+	"time"
 
 	"context"
 
@@ -36,14 +37,31 @@ import (
 )
 
 var (
-	ErrStorageRejectedVersion    = errors.New("Storage write rejected - version check failed.")
-	ErrStorageRejectedPermission = errors.New("Storage write rejected - permission denied.")
+	ErrStorageRejectedVersion       = errors.New("Storage write rejected - version check failed.")
+	ErrStorageRejectedPermission    = errors.New("Storage write rejected - permission denied.")
+	ErrStorageDeleteRejectedVersion = errors.New("Storage delete rejected - version check failed.")
+	ErrStorageListOrderInvalid      = errors.New("Invalid storage list order.")
+)
+
+// StorageListOrder selects the ordering of storage listings. Orders other than the default are only
+// available to runtime callers.
+type StorageListOrder string
+
+const (
+	// Ordered by read permission, key, then owner.
+	StorageListOrderDefault StorageListOrder = ""
+	// Ordered by key, then owner.
+	StorageListOrderKey StorageListOrder = "key"
+	// Most recently updated first.
+	StorageListOrderUpdateTime StorageListOrder = "update_time"
 )
 
 type storageCursor struct {
-	Key    string
-	UserID uuid.UUID
-	Read   int32
+	Key        string
+	UserID     uuid.UUID
+	Read       int32
+	UpdateTime time.Time
+	Order      StorageListOrder
 }
 
 // Internal representation for a batch of storage write operations.
@@ -96,7 +114,18 @@ func (s StorageOpDeletes) Less(i, j int) bool {
 	return s1.OwnerID < s2.OwnerID
 }
 
-func StorageListObjects(ctx context.Context, logger *zap.Logger, db *sql.DB, caller uuid.UUID, ownerID *uuid.UUID, collection string, limit int, cursor string) (*api.StorageObjectList, codes.Code, error) {
+func StorageListObjects(ctx context.Context, logger *zap.Logger, db *sql.DB, caller uuid.UUID, ownerID *uuid.UUID, collection string, limit int, cursor string, order StorageListOrder) (*api.StorageObjectList, codes.Code, error) {
+	switch order {
+	case StorageListOrderDefault:
+	case StorageListOrderKey, StorageListOrderUpdateTime:
+		if caller != uuid.Nil {
+			// Custom orders are only available to the runtime.
+			return nil, codes.InvalidArgument, ErrStorageListOrderInvalid
+		}
+	default:
+		return nil, codes.InvalidArgument, ErrStorageListOrderInvalid
+	}
+
 	var sc *storageCursor
 	if cursor != "" {
 		sc = &storageCursor{}
@@ -109,6 +138,10 @@ func StorageListObjects(ctx context.Context, logger *zap.Logger, db *sql.DB, cal
 			logger.Warn("Could not decode storage cursor.", zap.String("cursor", cursor))
 			return nil, codes.InvalidArgument, errors.New("Malformed cursor was used.")
 		}
+		if sc.Order != order {
+			logger.Warn("Storage cursor used with a different order.", zap.String("cursor", cursor))
+			return nil, codes.InvalidArgument, errors.New("Malformed cursor was used.")
+		}
 	}
 
 	var result *api.StorageObjectList
@@ -116,9 +149,11 @@ func StorageListObjects(ctx context.Context, logger *zap.Logger, db *sql.DB, cal
 
 	if caller == uuid.Nil {
 		// Call from the runtime.
-		if ownerID == nil {
+		if order != StorageListOrderDefault {
+			// List in a custom order, regardless of user if no owner is given.
+			result, resultErr = StorageListObjectsOrdered(ctx, logger, db, ownerID, collection, limit, cursor, sc, order)
+		} else if ownerID == nil {
 			// List storage regardless of user.
-			// TODO
 			result, resultErr = StorageListObjectsAll(ctx, logger, db, true, collection, limit, cursor, sc)
 		} else {
 			// List for a particular user ID.
@@ -190,7 +225,7 @@ LIMIT $2`
 		}
 		// rows.Close() called in storageListObjects
 
-		objects, err = storageListObjects(rows, limit)
+		objects, err = storageListObjects(rows, limit, StorageListOrderDefault)
 		if err != nil {
 			logger.Error("Could not list storage.", zap.Error(err), zap.String("collection", collection), zap.Int("limit", limit), zap.String("cursor", cursor))
 			return err
@@ -230,7 +265,7 @@ LIMIT $3`
 		}
 		// rows.Close() called in storageListObjects
 
-		objects, err = storageListObjects(rows, limit)
+		objects, err = storageListObjects(rows, limit, StorageListOrderDefault)
 		if err != nil {
 			logger.Error("Could not list storage.", zap.Error(err), zap.String("collection", collection), zap.Int("limit", limit), zap.String("cursor", cursor))
 			return err
@@ -279,7 +314,7 @@ LIMIT $3`
 		}
 		// rows.Close() called in storageListObjects
 
-		objects, err = storageListObjects(rows, limit)
+		objects, err = storageListObjects(rows, limit, StorageListOrderDefault)
 		if err != nil {
 			logger.Error("Could not list storage.", zap.Error(err), zap.String("collection", collection), zap.Int("limit", limit), zap.String("cursor", cursor))
 			return err
@@ -290,15 +325,76 @@ LIMIT $3`
 	return objects, err
 }
 
-func StorageReadAllUserObjects(ctx context.Context, logger *zap.Logger, db *sql.DB, userID uuid.UUID) ([]*api.StorageObject, error) {
+func StorageListObjectsOrdered(ctx context.Context, logger *zap.Logger, db *sql.DB, ownerID *uuid.UUID, collection string, limit int, cursor string, storageCursor *storageCursor, order StorageListOrder) (*api.StorageObjectList, error) {
+	ownerQuery := ""
+	params := []interface{}{collection, limit + 1}
+	if ownerID != nil {
+		ownerQuery = " AND user_id = $3"
+		params = append(params, *ownerID)
+	}
+
+	var cursorQuery, orderQuery string
+	switch order {
+	case StorageListOrderKey:
+		if storageCursor != nil {
+			cursorQuery = fmt.Sprintf(" AND (key, user_id) > ($%v, $%v)", len(params)+1, len(params)+2)
+			params = append(params, storageCursor.Key, storageCursor.UserID)
+		}
+		orderQuery = "ORDER BY key ASC, user_id ASC"
+	case StorageListOrderUpdateTime:
+		if storageCursor != nil {
+			cursorQuery = fmt.Sprintf(" AND (update_time, key, user_id) < ($%v, $%v, $%v)", len(params)+1, len(params)+2, len(params)+3)
+			params = append(params, storageCursor.UpdateTime, storageCursor.Key, storageCursor.UserID)
+		}
+		orderQuery = "ORDER BY update_time DESC, key DESC, user_id DESC"
+	default:
+		return nil, ErrStorageListOrderInvalid
+	}
+
 	query := `
 SELECT collection, key, user_id, value, version, read, write, create_time, update_time
 FROM storage
-WHERE user_id = $1`
+WHERE collection = $1` + ownerQuery + cursorQuery + `
+` + orderQuery + `
+LIMIT $2`
+
+	var objects *api.StorageObjectList
+	err := ExecuteRetryable(func() error {
+		rows, err := db.QueryContext(ctx, query, params...)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				objects = &api.StorageObjectList{Objects: make([]*api.StorageObject, 0)}
+				return nil
+			}
+			logger.Error("Could not list storage.", zap.Error(err), zap.String("collection", collection), zap.Int("limit", limit), zap.String("cursor", cursor))
+			return err
+		}
+		// rows.Close() called in storageListObjects
+
+		objects, err = storageListObjects(rows, limit, order)
+		if err != nil {
+			logger.Error("Could not list storage.", zap.Error(err), zap.String("collection", collection), zap.Int("limit", limit), zap.String("cursor", cursor))
+			return err
+		}
+		return nil
+	})
+
+	return objects, err
+}
+
+// StorageReadAllUserObjects returns up to limit storage objects owned by a user across all collections, ordered by
+// collection and key and starting after the given collection and key. Use empty strings to start from the first object.
+func StorageReadAllUserObjects(ctx context.Context, logger *zap.Logger, db *sql.DB, userID uuid.UUID, limit int, afterCollection, afterKey string) ([]*api.StorageObject, error) {
+	query := `
+SELECT collection, key, user_id, value, version, read, write, create_time, update_time
+FROM storage
+WHERE user_id = $1 AND (collection, key) > ($2, $3)
+ORDER BY collection ASC, key ASC
+LIMIT $4`
 
 	var objects []*api.StorageObject
 	err := ExecuteRetryable(func() error {
-		rows, err := db.QueryContext(ctx, query, userID)
+		rows, err := db.QueryContext(ctx, query, userID, afterCollection, afterKey, limit)
 		if err != nil {
 			if err == sql.ErrNoRows {
 				objects = make([]*api.StorageObject, 0)
@@ -336,16 +432,40 @@ WHERE user_id = $1`
 	return objects, err
 }
 
-func storageListObjects(rows *sql.Rows, limit int) (*api.StorageObjectList, error) {
+// StorageSize returns the number of storage objects owned by a user and the total size in bytes of their values, as
+// stored. If collection is empty all of the user's collections are counted. Lookups use the storage indexes leading
+// with the collection and user ID, or only the user ID when counting all collections.
+func StorageSize(ctx context.Context, logger *zap.Logger, db *sql.DB, userID uuid.UUID, collection string) (int64, int64, error) {
+	query := "SELECT count(*), COALESCE(sum(octet_length(value::TEXT)), 0) FROM storage WHERE user_id = $1"
+	params := []interface{}{userID}
+	if collection != "" {
+		query += " AND collection = $2"
+		params = append(params, collection)
+	}
+
+	var count int64
+	var size int64
+	if err := db.QueryRowContext(ctx, query, params...).Scan(&count, &size); err != nil {
+		logger.Error("Could not read storage size.", zap.Error(err), zap.String("user_id", userID.String()), zap.String("collection", collection))
+		return 0, 0, err
+	}
+
+	return size, count, nil
+}
+
+func storageListObjects(rows *sql.Rows, limit int, order StorageListOrder) (*api.StorageObjectList, error) {
 	var lastObject *api.StorageObject
+	var lastUpdateTime time.Time
 	var newCursor *storageCursor
 	objects := make([]*api.StorageObject, 0, limit)
 	for rows.Next() {
 		// If we've read enough, but there is at least 1 more, use the last read as the cursor and stop here.
 		if len(objects) >= limit && lastObject != nil {
 			newCursor = &storageCursor{
-				Key:  lastObject.Key,
-				Read: lastObject.PermissionRead,
+				Key:        lastObject.Key,
+				Read:       lastObject.PermissionRead,
+				UpdateTime: lastUpdateTime,
+				Order:      order,
 			}
 			if lastObject.UserId != "" {
 				newCursor.UserID = uuid.FromStringOrNil(lastObject.UserId)
@@ -368,6 +488,7 @@ func storageListObjects(rows *sql.Rows, limit int) (*api.StorageObjectList, erro
 
 		objects = append(objects, o)
 		lastObject = o
+		lastUpdateTime = updateTime.Time
 	}
 	_ = rows.Close()
 
@@ -462,7 +583,50 @@ WHERE
 	return objects, err
 }
 
-func StorageWriteObjects(ctx context.Context, logger *zap.Logger, db *sql.DB, authoritativeWrite bool, ops StorageOpWrites) (*api.StorageObjectAcks, codes.Code, error) {
+// StorageCheckObjectSizes rejects a set of writes if any object value is larger than maxBytes, unless maxBytes is 0.
+func StorageCheckObjectSizes(ops StorageOpWrites, maxBytes int) error {
+	if maxBytes <= 0 {
+		return nil
+	}
+	for _, op := range ops {
+		if size := len(op.Object.Value); size > maxBytes {
+			return fmt.Errorf("storage object value in collection '%v' with key '%v' is %v bytes, exceeds the maximum of %v bytes", op.Object.Collection, op.Object.Key, size, maxBytes)
+		}
+	}
+	return nil
+}
+
+// StorageAckVersions summarises a set of write acks as a map of collection, then key, then owner user ID, to each
+// object's new version. System owned objects are listed under the nil UUID.
+func StorageAckVersions(acks *api.StorageObjectAcks) map[string]map[string]map[string]string {
+	versions := make(map[string]map[string]map[string]string)
+	for _, ack := range acks.GetAcks() {
+		keys, found := versions[ack.Collection]
+		if !found {
+			keys = make(map[string]map[string]string)
+			versions[ack.Collection] = keys
+		}
+		owners, found := keys[ack.Key]
+		if !found {
+			owners = make(map[string]string)
+			keys[ack.Key] = owners
+		}
+		ownerID := ack.UserId
+		if ownerID == "" {
+			ownerID = uuid.Nil.String()
+		}
+		owners[ownerID] = ack.Version
+	}
+	return versions
+}
+
+// StorageWriteObjects writes a set of storage objects in a single transaction. Any object value larger than
+// maxObjectBytes rejects the whole set before it's written, unless maxObjectBytes is 0.
+func StorageWriteObjects(ctx context.Context, logger *zap.Logger, db *sql.DB, authoritativeWrite bool, maxObjectBytes int, ops StorageOpWrites) (*api.StorageObjectAcks, codes.Code, error) {
+	if err := StorageCheckObjectSizes(ops, maxObjectBytes); err != nil {
+		return nil, codes.InvalidArgument, err
+	}
+
 	// Ensure writes are processed in a consistent order.
 	sort.Sort(ops)
 
@@ -628,12 +792,15 @@ func StorageDeleteObjects(ctx context.Context, logger *zap.Logger, db *sql.DB, a
 		for _, op := range ops {
 			params := []interface{}{op.ObjectID.Collection, op.ObjectID.Key, op.OwnerID}
 			var query string
+			var existsQuery string
 			if authoritativeDelete {
 				// Deleting from the runtime.
 				query = "DELETE FROM storage WHERE collection = $1 AND key = $2 AND user_id = $3"
+				existsQuery = "SELECT EXISTS (SELECT 1 FROM storage WHERE collection = $1 AND key = $2 AND user_id = $3)"
 			} else {
 				// Direct client request to delete.
 				query = "DELETE FROM storage WHERE collection = $1 AND key = $2 AND user_id = $3 AND write > 0"
+				existsQuery = "SELECT EXISTS (SELECT 1 FROM storage WHERE collection = $1 AND key = $2 AND user_id = $3 AND write > 0)"
 			}
 			if op.ObjectID.GetVersion() != "" {
 				// Conditional delete.
@@ -648,6 +815,17 @@ func StorageDeleteObjects(ctx context.Context, logger *zap.Logger, db *sql.DB, a
 			}
 
 			if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+				if op.ObjectID.GetVersion() != "" {
+					// Tell a stale version apart from an object the caller could not have deleted at all.
+					var exists bool
+					if err := tx.QueryRowContext(ctx, existsQuery, params[:3]...).Scan(&exists); err != nil {
+						logger.Debug("Could not check storage object.", zap.Error(err), zap.String("query", existsQuery), zap.Any("object_id", op.ObjectID))
+						return err
+					}
+					if exists {
+						return StatusError(codes.InvalidArgument, "Storage delete rejected.", ErrStorageDeleteRejectedVersion)
+					}
+				}
 				return StatusError(codes.InvalidArgument, "Storage delete rejected.", errors.New("Storage delete rejected - not found, version check failed, or permission denied."))
 			}
 		}
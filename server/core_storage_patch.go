@@ -0,0 +1,398 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/heroiclabs/nakama-common/api"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+)
+
+var ErrStorageObjectNotFound = errors.New("Storage object not found.")
+
+// StoragePatchOp is a single RFC 6902 JSON Patch operation.
+type StoragePatchOp struct {
+	Op    string
+	Path  string
+	From  string
+	Value interface{}
+	// Value is required by some operations, and may legitimately be a JSON null.
+	HasValue bool
+}
+
+// Validate checks the operation is well formed, without reference to any document it may be applied to.
+func (o *StoragePatchOp) Validate() error {
+	switch o.Op {
+	case "add", "replace", "test":
+		if !o.HasValue {
+			return fmt.Errorf("patch operation '%v' requires a value", o.Op)
+		}
+	case "remove":
+	case "move", "copy":
+		if _, err := parseJSONPointer(o.From); err != nil {
+			return fmt.Errorf("patch operation '%v' has invalid from: %v", o.Op, err.Error())
+		}
+	default:
+		return fmt.Errorf("invalid patch operation '%v'", o.Op)
+	}
+	if _, err := parseJSONPointer(o.Path); err != nil {
+		return fmt.Errorf("patch operation '%v' has invalid path: %v", o.Op, err.Error())
+	}
+	if o.Op == "move" && strings.HasPrefix(o.Path, o.From+"/") {
+		return fmt.Errorf("patch operation 'move' cannot move a value into one of its children")
+	}
+	return nil
+}
+
+// StoragePatchObject applies a JSON Patch to an existing storage object. The object is read and rewritten in a single
+// transaction, and the write is conditional on the version read so concurrent modifications cause the patch to be
+// rejected rather than lost. If a version is given the patch is also rejected unless it matches the stored object, and
+// a patched value larger than maxObjectBytes is rejected unless maxObjectBytes is 0.
+func StoragePatchObject(ctx context.Context, logger *zap.Logger, db *sql.DB, maxObjectBytes int, ownerID, collection, key, version string, ops []*StoragePatchOp) (*api.StorageObjectAck, codes.Code, error) {
+	for _, op := range ops {
+		if err := op.Validate(); err != nil {
+			return nil, codes.InvalidArgument, err
+		}
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Error("Could not begin database transaction.", zap.Error(err))
+		return nil, codes.Internal, err
+	}
+
+	var ack *api.StorageObjectAck
+	if err = ExecuteInTx(ctx, tx, func() error {
+		var dbValue string
+		var dbVersion string
+		var dbPermissionRead int32
+		var dbPermissionWrite int32
+		err := tx.QueryRowContext(ctx, "SELECT value, version, read, write FROM storage WHERE collection = $1 AND key = $2 AND user_id = $3", collection, key, ownerID).Scan(&dbValue, &dbVersion, &dbPermissionRead, &dbPermissionWrite)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return StatusError(codes.NotFound, "Storage object not found.", ErrStorageObjectNotFound)
+			}
+			return err
+		}
+		if version != "" && version != dbVersion {
+			return StatusError(codes.InvalidArgument, "Storage write rejected.", ErrStorageRejectedVersion)
+		}
+
+		value, err := ApplyStoragePatch(dbValue, ops)
+		if err != nil {
+			return StatusError(codes.InvalidArgument, "Storage patch failed.", err)
+		}
+
+		writeOps := StorageOpWrites{&StorageOpWrite{
+			OwnerID: ownerID,
+			Object: &api.WriteStorageObject{
+				Collection:      collection,
+				Key:             key,
+				Value:           value,
+				Version:         dbVersion,
+				PermissionRead:  &wrappers.Int32Value{Value: dbPermissionRead},
+				PermissionWrite: &wrappers.Int32Value{Value: dbPermissionWrite},
+			},
+		}}
+		if err := StorageCheckObjectSizes(writeOps, maxObjectBytes); err != nil {
+			return StatusError(codes.InvalidArgument, "Storage patch rejected.", err)
+		}
+
+		acks, err := storageWriteObjects(ctx, logger, tx, true, writeOps)
+		if err != nil {
+			return err
+		}
+		ack = acks[0]
+		return nil
+	}); err != nil {
+		if e, ok := err.(*statusError); ok {
+			return nil, e.Code(), e.Cause()
+		}
+		logger.Error("Error patching storage object.", zap.Error(err))
+		return nil, codes.Internal, err
+	}
+
+	return ack, codes.OK, nil
+}
+
+// ApplyStoragePatch applies JSON Patch operations in order to a JSON object, returning the patched object. Either all
+// operations are applied or an error is returned. The result must still be a JSON object to be a valid storage value.
+func ApplyStoragePatch(value string, ops []*StoragePatchOp) (string, error) {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(value), &doc); err != nil {
+		return "", fmt.Errorf("could not decode value: %v", err.Error())
+	}
+
+	for i, op := range ops {
+		if err := op.Validate(); err != nil {
+			return "", err
+		}
+		var err error
+		if doc, err = applyStoragePatchOp(doc, op); err != nil {
+			return "", fmt.Errorf("patch operation %v '%v' failed: %v", i, op.Op, err.Error())
+		}
+	}
+
+	if _, ok := doc.(map[string]interface{}); !ok {
+		return "", errors.New("patched value must be a JSON object")
+	}
+	patched, err := json.Marshal(doc)
+	if err != nil {
+		return "", fmt.Errorf("could not encode patched value: %v", err.Error())
+	}
+	return string(patched), nil
+}
+
+func applyStoragePatchOp(doc interface{}, op *StoragePatchOp) (interface{}, error) {
+	// Pointers are already validated.
+	path, _ := parseJSONPointer(op.Path)
+
+	switch op.Op {
+	case "add":
+		value, err := jsonPatchNormalize(op.Value)
+		if err != nil {
+			return nil, err
+		}
+		return jsonPatchAdd(doc, path, value)
+	case "remove":
+		return jsonPatchRemove(doc, path)
+	case "replace":
+		value, err := jsonPatchNormalize(op.Value)
+		if err != nil {
+			return nil, err
+		}
+		if len(path) == 0 {
+			return value, nil
+		}
+		return jsonPatchUpdate(doc, path, func(container interface{}, token string) (interface{}, error) {
+			switch c := container.(type) {
+			case map[string]interface{}:
+				if _, found := c[token]; !found {
+					return nil, fmt.Errorf("path '%v' not found", op.Path)
+				}
+				c[token] = value
+				return c, nil
+			case []interface{}:
+				idx, err := jsonPatchIndex(token, len(c), false)
+				if err != nil {
+					return nil, err
+				}
+				c[idx] = value
+				return c, nil
+			default:
+				return nil, fmt.Errorf("path '%v' not found", op.Path)
+			}
+		})
+	case "move":
+		from, _ := parseJSONPointer(op.From)
+		value, err := jsonPatchGet(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		if doc, err = jsonPatchRemove(doc, from); err != nil {
+			return nil, err
+		}
+		return jsonPatchAdd(doc, path, value)
+	case "copy":
+		from, _ := parseJSONPointer(op.From)
+		value, err := jsonPatchGet(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		// Copy the value so later operations on either location don't affect the other.
+		if value, err = jsonPatchNormalize(value); err != nil {
+			return nil, err
+		}
+		return jsonPatchAdd(doc, path, value)
+	case "test":
+		expected, err := jsonPatchNormalize(op.Value)
+		if err != nil {
+			return nil, err
+		}
+		actual, err := jsonPatchGet(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(expected, actual) {
+			return nil, fmt.Errorf("value at path '%v' does not match", op.Path)
+		}
+		return doc, nil
+	default:
+		return nil, fmt.Errorf("invalid patch operation '%v'", op.Op)
+	}
+}
+
+// Round trip a value through JSON so it has the same representation as decoded documents, which also deep copies it.
+func jsonPatchNormalize(value interface{}) (interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value: %v", err.Error())
+	}
+	var normalized interface{}
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return nil, fmt.Errorf("invalid value: %v", err.Error())
+	}
+	return normalized, nil
+}
+
+func jsonPatchAdd(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return jsonPatchUpdate(doc, path, func(container interface{}, token string) (interface{}, error) {
+		switch c := container.(type) {
+		case map[string]interface{}:
+			c[token] = value
+			return c, nil
+		case []interface{}:
+			idx, err := jsonPatchIndex(token, len(c), true)
+			if err != nil {
+				return nil, err
+			}
+			c = append(c, nil)
+			copy(c[idx+1:], c[idx:])
+			c[idx] = value
+			return c, nil
+		default:
+			return nil, errors.New("parent is not an object or array")
+		}
+	})
+}
+
+func jsonPatchRemove(doc interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, errors.New("cannot remove the whole document")
+	}
+	return jsonPatchUpdate(doc, path, func(container interface{}, token string) (interface{}, error) {
+		switch c := container.(type) {
+		case map[string]interface{}:
+			if _, found := c[token]; !found {
+				return nil, fmt.Errorf("key '%v' not found", token)
+			}
+			delete(c, token)
+			return c, nil
+		case []interface{}:
+			idx, err := jsonPatchIndex(token, len(c), false)
+			if err != nil {
+				return nil, err
+			}
+			return append(c[:idx], c[idx+1:]...), nil
+		default:
+			return nil, errors.New("parent is not an object or array")
+		}
+	})
+}
+
+func jsonPatchGet(doc interface{}, path []string) (interface{}, error) {
+	node := doc
+	for _, token := range path {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			child, found := n[token]
+			if !found {
+				return nil, fmt.Errorf("key '%v' not found", token)
+			}
+			node = child
+		case []interface{}:
+			idx, err := jsonPatchIndex(token, len(n), false)
+			if err != nil {
+				return nil, err
+			}
+			node = n[idx]
+		default:
+			return nil, fmt.Errorf("key '%v' not found", token)
+		}
+	}
+	return node, nil
+}
+
+// Walk to the container holding the last path token and apply fn to it. Containers are returned from each step since
+// arrays may be reallocated and must be stored back into their parent.
+func jsonPatchUpdate(node interface{}, path []string, fn func(container interface{}, token string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return fn(node, path[0])
+	}
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		child, found := n[path[0]]
+		if !found {
+			return nil, fmt.Errorf("key '%v' not found", path[0])
+		}
+		updated, err := jsonPatchUpdate(child, path[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		n[path[0]] = updated
+		return n, nil
+	case []interface{}:
+		idx, err := jsonPatchIndex(path[0], len(n), false)
+		if err != nil {
+			return nil, err
+		}
+		updated, err := jsonPatchUpdate(n[idx], path[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		n[idx] = updated
+		return n, nil
+	default:
+		return nil, fmt.Errorf("key '%v' not found", path[0])
+	}
+}
+
+// Resolve an array index token. Adds may also target the end of the array, either with its length or "-".
+func jsonPatchIndex(token string, length int, add bool) (int, error) {
+	if add && token == "-" {
+		return length, nil
+	}
+	// Leading zeros are not permitted.
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index '%v'", token)
+	}
+	idx, err := strconv.Atoi(token)
+	if err != nil || idx < 0 {
+		return 0, fmt.Errorf("invalid array index '%v'", token)
+	}
+	if idx > length || (idx == length && !add) {
+		return 0, fmt.Errorf("array index '%v' out of bounds", token)
+	}
+	return idx, nil
+}
+
+// Parse an RFC 6901 JSON Pointer into its unescaped reference tokens. The empty pointer refers to the whole document.
+func parseJSONPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if pointer[0] != '/' {
+		return nil, errors.New("pointer must be empty or start with '/'")
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens, nil
+}
@@ -0,0 +1,138 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/heroiclabs/nakama-common/api"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+)
+
+func TestApplyStoragePatch(t *testing.T) {
+	value := `{"name":"a","stats":{"hp":10,"mp":5},"items":["sword","shield"],"a/b":1}`
+
+	patched, err := ApplyStoragePatch(value, []*StoragePatchOp{
+		{Op: "test", Path: "/name", Value: "a", HasValue: true},
+		{Op: "add", Path: "/stats/xp", Value: 100, HasValue: true},
+		{Op: "replace", Path: "/stats/hp", Value: 20, HasValue: true},
+		{Op: "remove", Path: "/stats/mp"},
+		{Op: "add", Path: "/items/1", Value: "bow", HasValue: true},
+		{Op: "add", Path: "/items/-", Value: map[string]interface{}{"id": "potion"}, HasValue: true},
+		{Op: "remove", Path: "/items/0"},
+		{Op: "copy", From: "/name", Path: "/nickname"},
+		{Op: "move", From: "/a~1b", Path: "/c"},
+	})
+	if err != nil {
+		t.Fatalf("error applying patch: %v", err)
+	}
+	assert.JSONEq(t, `{"name":"a","nickname":"a","stats":{"hp":20,"xp":100},"items":["bow","shield",{"id":"potion"}],"c":1}`, patched)
+}
+
+func TestApplyStoragePatchFailures(t *testing.T) {
+	value := `{"name":"a","items":["sword"]}`
+
+	for _, ops := range [][]*StoragePatchOp{
+		// Invalid operations.
+		{{Op: "merge", Path: "/name"}},
+		{{Op: "add", Path: "/name"}},
+		{{Op: "remove", Path: "name"}},
+		{{Op: "move", From: "/items", Path: "/items/0"}},
+		// Operations that don't apply to the document.
+		{{Op: "remove", Path: "/missing"}},
+		{{Op: "replace", Path: "/missing", Value: 1, HasValue: true}},
+		{{Op: "add", Path: "/items/5", Value: 1, HasValue: true}},
+		{{Op: "add", Path: "/missing/key", Value: 1, HasValue: true}},
+		{{Op: "test", Path: "/name", Value: "b", HasValue: true}},
+		// The result must remain an object.
+		{{Op: "replace", Path: "", Value: []interface{}{}, HasValue: true}},
+		// A failure in any operation fails the whole patch.
+		{{Op: "replace", Path: "/name", Value: "b", HasValue: true}, {Op: "remove", Path: "/missing"}},
+	} {
+		_, err := ApplyStoragePatch(value, ops)
+		assert.Error(t, err, "expected patch %v '%v' to fail", ops[0].Op, ops[0].Path)
+	}
+}
+
+func TestStoragePatchObject(t *testing.T) {
+	db := NewDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	userID := uuid.Must(uuid.NewV4())
+	InsertUser(t, db, userID)
+
+	acks, _, err := StorageWriteObjects(ctx, logger, db, true, 0, StorageOpWrites{&StorageOpWrite{
+		OwnerID: userID.String(),
+		Object: &api.WriteStorageObject{
+			Collection:      "patch",
+			Key:             "key",
+			Value:           `{"hp":10,"items":["sword"]}`,
+			PermissionRead:  &wrappers.Int32Value{Value: 2},
+			PermissionWrite: &wrappers.Int32Value{Value: 0},
+		},
+	}})
+	if err != nil {
+		t.Fatalf("error writing storage object: %v", err)
+	}
+	originalVersion := acks.Acks[0].Version
+
+	ack, _, err := StoragePatchObject(ctx, logger, db, 0, userID.String(), "patch", "key", originalVersion, []*StoragePatchOp{
+		{Op: "replace", Path: "/hp", Value: 20, HasValue: true},
+		{Op: "add", Path: "/items/-", Value: "shield", HasValue: true},
+	})
+	if err != nil {
+		t.Fatalf("error patching storage object: %v", err)
+	}
+	assert.NotEqual(t, originalVersion, ack.Version)
+
+	objects, err := StorageReadObjects(ctx, logger, db, uuid.Nil, []*api.ReadStorageObjectId{{Collection: "patch", Key: "key", UserId: userID.String()}})
+	if err != nil {
+		t.Fatalf("error reading storage object: %v", err)
+	}
+	if assert.Len(t, objects.Objects, 1) {
+		assert.JSONEq(t, `{"hp":20,"items":["sword","shield"]}`, objects.Objects[0].Value)
+		assert.Equal(t, ack.Version, objects.Objects[0].Version)
+		// Permissions are preserved.
+		assert.Equal(t, int32(2), objects.Objects[0].PermissionRead)
+		assert.Equal(t, int32(0), objects.Objects[0].PermissionWrite)
+	}
+
+	// A patch based on the original version was concurrently modified, and is rejected.
+	_, code, err := StoragePatchObject(ctx, logger, db, 0, userID.String(), "patch", "key", originalVersion, []*StoragePatchOp{
+		{Op: "replace", Path: "/hp", Value: 30, HasValue: true},
+	})
+	assert.Equal(t, ErrStorageRejectedVersion, err)
+	assert.Equal(t, codes.InvalidArgument, code)
+
+	// A patch growing the object beyond the size limit is rejected.
+	_, code, err = StoragePatchObject(ctx, logger, db, 64, userID.String(), "patch", "key", "", []*StoragePatchOp{
+		{Op: "add", Path: "/name", Value: strings.Repeat("x", 64), HasValue: true},
+	})
+	assert.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, code)
+
+	// Missing objects can't be patched.
+	_, code, err = StoragePatchObject(ctx, logger, db, 0, userID.String(), "patch", "missing", "", []*StoragePatchOp{
+		{Op: "replace", Path: "/hp", Value: 30, HasValue: true},
+	})
+	assert.Equal(t, ErrStorageObjectNotFound, err)
+	assert.Equal(t, codes.NotFound, code)
+}
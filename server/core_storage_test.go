@@ -18,7 +18,9 @@ import (
 	"context"
 	"crypto/md5"
 	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gofrs/uuid"
 	"github.com/golang/protobuf/ptypes/wrappers"
@@ -42,7 +44,7 @@ func TestStorageWriteRuntimeGlobalSingle(t *testing.T) {
 			PermissionWrite: &wrappers.Int32Value{Value: 1},
 		},
 	}}
-	acks, code, err := StorageWriteObjects(context.Background(), logger, db, true, ops)
+	acks, code, err := StorageWriteObjects(context.Background(), logger, db, true, 0, ops)
 
 	assert.Nil(t, err, "err was not nil")
 	assert.Equal(t, codes.OK, code, "code was not OK")
@@ -113,7 +115,7 @@ func TestStorageWriteRuntimeUserMultiple(t *testing.T) {
 			},
 		},
 	}
-	acks, code, err := StorageWriteObjects(context.Background(), logger, db, false, ops)
+	acks, code, err := StorageWriteObjects(context.Background(), logger, db, false, 0, ops)
 
 	assert.Nil(t, err, "err was not nil")
 	assert.Equal(t, codes.OK, code, "code was not OK")
@@ -146,7 +148,7 @@ func TestStorageWriteRuntimeGlobalSingleIfMatchNotExists(t *testing.T) {
 		},
 	}
 
-	acks, code, err := StorageWriteObjects(context.Background(), logger, db, true, ops)
+	acks, code, err := StorageWriteObjects(context.Background(), logger, db, true, 0, ops)
 
 	assert.Nil(t, acks, "acks was not nil")
 	assert.Equal(t, codes.InvalidArgument, code, "code did not match")
@@ -171,7 +173,7 @@ func TestStorageWriteRuntimeGlobalSingleIfMatchExists(t *testing.T) {
 		},
 	}
 
-	acks, code, err := StorageWriteObjects(context.Background(), logger, db, true, ops)
+	acks, code, err := StorageWriteObjects(context.Background(), logger, db, true, 0, ops)
 
 	assert.Nil(t, err, "err was not nil")
 	assert.Equal(t, codes.OK, code, "code was not OK")
@@ -196,7 +198,7 @@ func TestStorageWriteRuntimeGlobalSingleIfMatchExists(t *testing.T) {
 		},
 	}
 
-	acks, code, err = StorageWriteObjects(context.Background(), logger, db, true, ops)
+	acks, code, err = StorageWriteObjects(context.Background(), logger, db, true, 0, ops)
 
 	assert.Nil(t, err, "err was not nil")
 	assert.Equal(t, codes.OK, code, "code was not 0")
@@ -225,7 +227,7 @@ func TestStorageWriteRuntimeGlobalSingleIfMatchExistsFail(t *testing.T) {
 		},
 	}
 
-	acks, code, err := StorageWriteObjects(context.Background(), logger, db, true, ops)
+	acks, code, err := StorageWriteObjects(context.Background(), logger, db, true, 0, ops)
 
 	assert.Nil(t, err, "err was not nil")
 	assert.Equal(t, codes.OK, code, "code was not OK")
@@ -250,7 +252,7 @@ func TestStorageWriteRuntimeGlobalSingleIfMatchExistsFail(t *testing.T) {
 		},
 	}
 
-	acks, code, err = StorageWriteObjects(context.Background(), logger, db, true, ops)
+	acks, code, err = StorageWriteObjects(context.Background(), logger, db, true, 0, ops)
 
 	assert.Nil(t, acks, "acks was not nil")
 	assert.Equal(t, codes.InvalidArgument, code, "code did not match")
@@ -276,7 +278,7 @@ func TestStorageWriteRuntimeGlobalSingleIfNoneMatchNotExists(t *testing.T) {
 		},
 	}
 
-	acks, _, err := StorageWriteObjects(context.Background(), logger, db, true, ops)
+	acks, _, err := StorageWriteObjects(context.Background(), logger, db, true, 0, ops)
 
 	assert.Nil(t, err, "err was not nil")
 	assert.NotNil(t, acks, "acks was nil")
@@ -304,7 +306,7 @@ func TestStorageWriteRuntimeGlobalSingleIfNoneMatchExists(t *testing.T) {
 		},
 	}
 
-	acks, _, err := StorageWriteObjects(context.Background(), logger, db, true, ops)
+	acks, _, err := StorageWriteObjects(context.Background(), logger, db, true, 0, ops)
 
 	assert.Nil(t, err, "err was not nil")
 	assert.NotNil(t, acks, "acks was nil")
@@ -328,7 +330,7 @@ func TestStorageWriteRuntimeGlobalSingleIfNoneMatchExists(t *testing.T) {
 		},
 	}
 
-	acks, code, err := StorageWriteObjects(context.Background(), logger, db, true, ops)
+	acks, code, err := StorageWriteObjects(context.Background(), logger, db, true, 0, ops)
 
 	assert.Nil(t, acks, "acks was not nil")
 	assert.Equal(t, codes.InvalidArgument, code, "code did not match")
@@ -364,7 +366,7 @@ func TestStorageWriteRuntimeGlobalMultipleIfMatchNotExists(t *testing.T) {
 		},
 	}
 
-	acks, code, err := StorageWriteObjects(context.Background(), logger, db, true, ops)
+	acks, code, err := StorageWriteObjects(context.Background(), logger, db, true, 0, ops)
 
 	assert.Nil(t, acks, "acks was not nil")
 	assert.Equal(t, codes.InvalidArgument, code, "code did not match")
@@ -392,7 +394,7 @@ func TestStorageWritePipelineUserSingle(t *testing.T) {
 		},
 	}
 
-	acks, _, err := StorageWriteObjects(context.Background(), logger, db, false, ops)
+	acks, _, err := StorageWriteObjects(context.Background(), logger, db, false, 0, ops)
 
 	assert.Nil(t, err, "err was not nil")
 	assert.NotNil(t, acks, "acks was nil")
@@ -443,7 +445,7 @@ func TestStorageWritePipelineUserMultiple(t *testing.T) {
 		},
 	}
 
-	allAcks, code, err := StorageWriteObjects(context.Background(), logger, db, false, ops)
+	allAcks, code, err := StorageWriteObjects(context.Background(), logger, db, false, 0, ops)
 	if err != nil {
 		t.Fatal(err.Error())
 	}
@@ -506,7 +508,7 @@ func TestStorageWriteRuntimeGlobalMultipleSameKey(t *testing.T) {
 		},
 	}
 
-	acks, code, err := StorageWriteObjects(context.Background(), logger, db, true, ops)
+	acks, code, err := StorageWriteObjects(context.Background(), logger, db, true, 0, ops)
 
 	assert.Nil(t, err, "err was not nil")
 	assert.Equal(t, codes.OK, code, "code was not OK")
@@ -575,7 +577,7 @@ func TestStorageWritePipelineUserMultipleSameKey(t *testing.T) {
 		},
 	}
 
-	acks, code, err := StorageWriteObjects(context.Background(), logger, db, false, ops)
+	acks, code, err := StorageWriteObjects(context.Background(), logger, db, false, 0, ops)
 
 	assert.Nil(t, err, "err was not nil")
 	assert.Equal(t, codes.OK, code, "code was not 0")
@@ -629,7 +631,7 @@ func TestStorageWritePipelineIfMatchNotExists(t *testing.T) {
 		},
 	}
 
-	acks, code, err := StorageWriteObjects(context.Background(), logger, db, false, ops)
+	acks, code, err := StorageWriteObjects(context.Background(), logger, db, false, 0, ops)
 
 	assert.Nil(t, acks, "acks was not nil")
 	assert.Equal(t, codes.InvalidArgument, code, "code did not match")
@@ -657,7 +659,7 @@ func TestStorageWritePipelineIfMatchExistsFail(t *testing.T) {
 		},
 	}
 
-	acks, _, err := StorageWriteObjects(context.Background(), logger, db, false, ops)
+	acks, _, err := StorageWriteObjects(context.Background(), logger, db, false, 0, ops)
 
 	assert.Nil(t, err, "err was not nil")
 	assert.NotNil(t, acks, "acks was nil")
@@ -681,7 +683,7 @@ func TestStorageWritePipelineIfMatchExistsFail(t *testing.T) {
 		},
 	}
 
-	acks, code, err := StorageWriteObjects(context.Background(), logger, db, false, ops)
+	acks, code, err := StorageWriteObjects(context.Background(), logger, db, false, 0, ops)
 
 	assert.Nil(t, acks, "acks was not nil")
 	assert.Equal(t, codes.InvalidArgument, code, "code did not match")
@@ -710,7 +712,7 @@ func TestStorageWritePipelineIfMatchExists(t *testing.T) {
 		},
 	}
 
-	acks, _, err := StorageWriteObjects(context.Background(), logger, db, false, ops)
+	acks, _, err := StorageWriteObjects(context.Background(), logger, db, false, 0, ops)
 
 	assert.Nil(t, err, "err was not nil")
 	assert.NotNil(t, acks, "acks was nil")
@@ -734,7 +736,7 @@ func TestStorageWritePipelineIfMatchExists(t *testing.T) {
 		},
 	}
 
-	acks, _, err = StorageWriteObjects(context.Background(), logger, db, false, ops)
+	acks, _, err = StorageWriteObjects(context.Background(), logger, db, false, 0, ops)
 
 	assert.Nil(t, err, "err was not nil")
 	assert.NotNil(t, acks, "acks was nil")
@@ -766,7 +768,7 @@ func TestStorageWritePipelineIfNoneMatchNotExists(t *testing.T) {
 		},
 	}
 
-	acks, _, err := StorageWriteObjects(context.Background(), logger, db, false, ops)
+	acks, _, err := StorageWriteObjects(context.Background(), logger, db, false, 0, ops)
 
 	assert.Nil(t, err, "err was not nil")
 	assert.NotNil(t, acks, "acks was nil")
@@ -798,7 +800,7 @@ func TestStorageWritePipelineIfNoneMatchExists(t *testing.T) {
 		},
 	}
 
-	acks, _, err := StorageWriteObjects(context.Background(), logger, db, false, ops)
+	acks, _, err := StorageWriteObjects(context.Background(), logger, db, false, 0, ops)
 
 	assert.Nil(t, err, "err was not nil")
 	assert.NotNil(t, acks, "acks was nil")
@@ -822,7 +824,7 @@ func TestStorageWritePipelineIfNoneMatchExists(t *testing.T) {
 		},
 	}
 
-	acks, code, err := StorageWriteObjects(context.Background(), logger, db, false, ops)
+	acks, code, err := StorageWriteObjects(context.Background(), logger, db, false, 0, ops)
 
 	assert.Nil(t, acks, "acks was not nil")
 	assert.Equal(t, codes.InvalidArgument, code, "code did not match")
@@ -851,7 +853,7 @@ func TestStorageWritePipelinePermissionFail(t *testing.T) {
 		},
 	}
 
-	acks, _, err := StorageWriteObjects(context.Background(), logger, db, false, ops)
+	acks, _, err := StorageWriteObjects(context.Background(), logger, db, false, 0, ops)
 
 	assert.Nil(t, err, "err was not nil")
 	assert.NotNil(t, acks, "acks was nil")
@@ -874,7 +876,7 @@ func TestStorageWritePipelinePermissionFail(t *testing.T) {
 		},
 	}
 
-	acks, code, err := StorageWriteObjects(context.Background(), logger, db, false, ops)
+	acks, code, err := StorageWriteObjects(context.Background(), logger, db, false, 0, ops)
 
 	assert.Nil(t, acks, "acks was not nil")
 	assert.Equal(t, codes.InvalidArgument, code, "code did not match")
@@ -901,7 +903,7 @@ func TestStorageFetchRuntimeGlobalPrivate(t *testing.T) {
 		},
 	}
 
-	acks, code, err := StorageWriteObjects(context.Background(), logger, db, true, ops)
+	acks, code, err := StorageWriteObjects(context.Background(), logger, db, true, 0, ops)
 
 	assert.Nil(t, err, "err was not nil")
 	assert.Equal(t, codes.OK, code, "code was not OK")
@@ -949,7 +951,7 @@ func TestStorageFetchRuntimeMixed(t *testing.T) {
 		},
 	}
 
-	acks, code, err := StorageWriteObjects(context.Background(), logger, db, true, ops)
+	acks, code, err := StorageWriteObjects(context.Background(), logger, db, true, 0, ops)
 
 	assert.Nil(t, err, "err was not nil")
 	assert.Equal(t, codes.OK, code, "code was not OK")
@@ -1004,7 +1006,7 @@ func TestStorageFetchRuntimeUserPrivate(t *testing.T) {
 		},
 	}
 
-	acks, code, err := StorageWriteObjects(context.Background(), logger, db, true, ops)
+	acks, code, err := StorageWriteObjects(context.Background(), logger, db, true, 0, ops)
 
 	assert.Nil(t, err, "err was not nil")
 	assert.Equal(t, codes.OK, code, "code was not OK")
@@ -1055,7 +1057,7 @@ func TestStorageFetchPipelineGlobalPrivate(t *testing.T) {
 		},
 	}
 
-	acks, code, err := StorageWriteObjects(context.Background(), logger, db, true, ops)
+	acks, code, err := StorageWriteObjects(context.Background(), logger, db, true, 0, ops)
 
 	assert.Nil(t, err, "err was not nil")
 	assert.Equal(t, codes.OK, code, "code was not OK")
@@ -1100,7 +1102,7 @@ func TestStorageFetchPipelineUserPrivate(t *testing.T) {
 		},
 	}
 
-	acks, code, err := StorageWriteObjects(context.Background(), logger, db, false, ops)
+	acks, code, err := StorageWriteObjects(context.Background(), logger, db, false, 0, ops)
 
 	assert.Nil(t, err, "err was not nil")
 	assert.Equal(t, codes.OK, code, "code was not OK")
@@ -1146,7 +1148,7 @@ func TestStorageFetchPipelineUserRead(t *testing.T) {
 		},
 	}
 
-	acks, code, err := StorageWriteObjects(context.Background(), logger, db, false, ops)
+	acks, code, err := StorageWriteObjects(context.Background(), logger, db, false, 0, ops)
 
 	assert.Nil(t, err, "err was not nil")
 	assert.Equal(t, codes.OK, code, "code was not OK")
@@ -1199,7 +1201,7 @@ func TestStorageFetchPipelineUserPublic(t *testing.T) {
 		},
 	}
 
-	acks, code, err := StorageWriteObjects(context.Background(), logger, db, false, ops)
+	acks, code, err := StorageWriteObjects(context.Background(), logger, db, false, 0, ops)
 
 	assert.Nil(t, err, "err was not nil")
 	assert.Equal(t, codes.OK, code, "code was not OK")
@@ -1251,7 +1253,7 @@ func TestStorageFetchPipelineUserOtherRead(t *testing.T) {
 		},
 	}
 
-	acks, code, err := StorageWriteObjects(context.Background(), logger, db, false, ops)
+	acks, code, err := StorageWriteObjects(context.Background(), logger, db, false, 0, ops)
 
 	assert.Nil(t, err, "err was not nil")
 	assert.Equal(t, codes.OK, code, "code was not OK")
@@ -1296,7 +1298,7 @@ func TestStorageFetchPipelineUserOtherPublic(t *testing.T) {
 		},
 	}
 
-	acks, code, err := StorageWriteObjects(context.Background(), logger, db, false, ops)
+	acks, code, err := StorageWriteObjects(context.Background(), logger, db, false, 0, ops)
 
 	assert.Nil(t, err, "err was not nil")
 	assert.Equal(t, codes.OK, code, "code was not OK")
@@ -1360,7 +1362,7 @@ func TestStorageFetchPipelineUserOtherPublicMixed(t *testing.T) {
 		},
 	}
 
-	acks, code, err := StorageWriteObjects(context.Background(), logger, db, false, ops)
+	acks, code, err := StorageWriteObjects(context.Background(), logger, db, false, 0, ops)
 
 	assert.Nil(t, err, "err was not nil")
 	assert.Equal(t, codes.OK, code, "code was not OK")
@@ -1426,7 +1428,7 @@ func TestStorageRemoveRuntimeGlobalPublic(t *testing.T) {
 		},
 	}
 
-	acks, code, err := StorageWriteObjects(context.Background(), logger, db, true, ops)
+	acks, code, err := StorageWriteObjects(context.Background(), logger, db, true, 0, ops)
 
 	assert.Nil(t, err, "err was not nil")
 	assert.Equal(t, codes.OK, code, "code was not OK")
@@ -1471,7 +1473,7 @@ func TestStorageRemoveRuntimeGlobalPrivate(t *testing.T) {
 		},
 	}
 
-	acks, code, err := StorageWriteObjects(context.Background(), logger, db, true, ops)
+	acks, code, err := StorageWriteObjects(context.Background(), logger, db, true, 0, ops)
 
 	assert.Nil(t, err, "err was not nil")
 	assert.Equal(t, codes.OK, code, "code was not OK")
@@ -1518,7 +1520,7 @@ func TestStorageRemoveRuntimeUserPublic(t *testing.T) {
 		},
 	}
 
-	acks, code, err := StorageWriteObjects(context.Background(), logger, db, true, ops)
+	acks, code, err := StorageWriteObjects(context.Background(), logger, db, true, 0, ops)
 
 	assert.Nil(t, err, "err was not nil")
 	assert.Equal(t, codes.OK, code, "code was not OK")
@@ -1565,7 +1567,7 @@ func TestStorageRemoveRuntimeUserPrivate(t *testing.T) {
 		},
 	}
 
-	acks, code, err := StorageWriteObjects(context.Background(), logger, db, false, ops)
+	acks, code, err := StorageWriteObjects(context.Background(), logger, db, false, 0, ops)
 
 	assert.Nil(t, err, "err was not nil")
 	assert.Equal(t, codes.OK, code, "code was not OK")
@@ -1622,7 +1624,7 @@ func TestStorageRemovePipelineUserWrite(t *testing.T) {
 		},
 	}
 
-	acks, code, err := StorageWriteObjects(context.Background(), logger, db, false, ops)
+	acks, code, err := StorageWriteObjects(context.Background(), logger, db, false, 0, ops)
 
 	assert.Nil(t, err, "err was not nil")
 	assert.Equal(t, codes.OK, code, "code was not OK")
@@ -1669,7 +1671,7 @@ func TestStorageRemovePipelineUserDenied(t *testing.T) {
 		},
 	}
 
-	acks, code, err := StorageWriteObjects(context.Background(), logger, db, false, ops)
+	acks, code, err := StorageWriteObjects(context.Background(), logger, db, false, 0, ops)
 
 	assert.Nil(t, err, "err was not nil")
 	assert.Equal(t, codes.OK, code, "code was not OK")
@@ -1735,7 +1737,7 @@ func TestStorageRemoveRuntimeGlobalIfMatchRejected(t *testing.T) {
 		},
 	}
 
-	acks, code, err := StorageWriteObjects(context.Background(), logger, db, true, ops)
+	acks, code, err := StorageWriteObjects(context.Background(), logger, db, true, 0, ops)
 
 	assert.Nil(t, err, "err was not nil")
 	assert.Equal(t, codes.OK, code, "code was not OK")
@@ -1759,10 +1761,71 @@ func TestStorageRemoveRuntimeGlobalIfMatchRejected(t *testing.T) {
 	}
 
 	code, err = StorageDeleteObjects(context.Background(), logger, db, true, deleteOps)
-	assert.NotNil(t, err, "err was not nil")
+	assert.Equal(t, ErrStorageDeleteRejectedVersion, err, "err did not match version check failure")
 	assert.Equal(t, code, codes.InvalidArgument, "code did not match InvalidArgument.")
 }
 
+func TestStorageRemoveRuntimeUserIfMatchStale(t *testing.T) {
+	db := NewDB(t)
+	defer db.Close()
+
+	uid := uuid.Must(uuid.NewV4())
+	InsertUser(t, db, uid)
+	key := GenerateString()
+
+	write := func(value string) string {
+		ops := StorageOpWrites{
+			&StorageOpWrite{
+				OwnerID: uid.String(),
+				Object: &api.WriteStorageObject{
+					Collection:      "testcollection",
+					Key:             key,
+					Value:           value,
+					PermissionRead:  &wrappers.Int32Value{Value: 1},
+					PermissionWrite: &wrappers.Int32Value{Value: 1},
+				},
+			},
+		}
+		acks, _, err := StorageWriteObjects(context.Background(), logger, db, true, 0, ops)
+		if err != nil {
+			t.Fatalf("error writing storage object: %v", err.Error())
+		}
+		return acks.Acks[0].Version
+	}
+	deleteOps := func(version string) StorageOpDeletes {
+		return StorageOpDeletes{
+			&StorageOpDelete{
+				OwnerID: uid.String(),
+				ObjectID: &api.DeleteStorageObjectId{
+					Collection: "testcollection",
+					Key:        key,
+					Version:    version,
+				},
+			},
+		}
+	}
+
+	// The object is updated after the caller read it.
+	staleVersion := write("{\"foo\":\"bar\"}")
+	currentVersion := write("{\"foo\":\"baz\"}")
+
+	code, err := StorageDeleteObjects(context.Background(), logger, db, false, deleteOps(staleVersion))
+	assert.Equal(t, ErrStorageDeleteRejectedVersion, err, "err did not match version check failure")
+	assert.Equal(t, codes.InvalidArgument, code, "code did not match InvalidArgument.")
+
+	readData, err := StorageReadObjects(context.Background(), logger, db, uid, []*api.ReadStorageObjectId{{
+		Collection: "testcollection",
+		Key:        key,
+		UserId:     uid.String(),
+	}})
+	assert.Nil(t, err, "err was not nil")
+	assert.Len(t, readData.Objects, 1, "readData length was not 1")
+
+	code, err = StorageDeleteObjects(context.Background(), logger, db, false, deleteOps(currentVersion))
+	assert.Nil(t, err, "err was not nil")
+	assert.Equal(t, codes.OK, code, "code was not OK")
+}
+
 func TestStorageRemoveRuntimeGlobalIfMatch(t *testing.T) {
 	db := NewDB(t)
 	defer db.Close()
@@ -1782,7 +1845,7 @@ func TestStorageRemoveRuntimeGlobalIfMatch(t *testing.T) {
 		},
 	}
 
-	acks, code, err := StorageWriteObjects(context.Background(), logger, db, true, ops)
+	acks, code, err := StorageWriteObjects(context.Background(), logger, db, true, 0, ops)
 
 	assert.Nil(t, err, "err was not nil")
 	assert.Equal(t, codes.OK, code, "code was not OK")
@@ -1850,14 +1913,14 @@ func TestStorageListRuntimeUser(t *testing.T) {
 		},
 	}
 
-	acks, code, err := StorageWriteObjects(context.Background(), logger, db, false, ops)
+	acks, code, err := StorageWriteObjects(context.Background(), logger, db, false, 0, ops)
 
 	assert.Nil(t, err, "err was not nil")
 	assert.Equal(t, codes.OK, code, "code was not OK")
 	assert.NotNil(t, acks, "acks was nil")
 	assert.Len(t, acks.Acks, 3, "acks length was not 3")
 
-	list, code, err := StorageListObjects(context.Background(), logger, db, uuid.Nil, &uid, "testcollection", 10, "")
+	list, code, err := StorageListObjects(context.Background(), logger, db, uuid.Nil, &uid, "testcollection", 10, "", StorageListOrderDefault)
 
 	assert.Nil(t, err, "err was not nil")
 	assert.Equal(t, codes.OK, code, "code was not OK")
@@ -1907,14 +1970,14 @@ func TestStorageListPipelineUserSelf(t *testing.T) {
 		},
 	}
 
-	acks, code, err := StorageWriteObjects(context.Background(), logger, db, false, ops)
+	acks, code, err := StorageWriteObjects(context.Background(), logger, db, false, 0, ops)
 
 	assert.Nil(t, err, "err was not nil")
 	assert.Equal(t, codes.OK, code, "code was not OK")
 	assert.NotNil(t, acks, "acks was nil")
 	assert.Len(t, acks.Acks, 3, "acks length was not 3")
 
-	list, code, err := StorageListObjects(context.Background(), logger, db, uid, &uid, collection, 10, "")
+	list, code, err := StorageListObjects(context.Background(), logger, db, uid, &uid, collection, 10, "", StorageListOrderDefault)
 
 	assert.Nil(t, err, "err was not nil")
 	assert.Equal(t, codes.OK, code, "code was not OK")
@@ -1966,14 +2029,14 @@ func TestStorageListPipelineUserOther(t *testing.T) {
 		},
 	}
 
-	acks, code, err := StorageWriteObjects(context.Background(), logger, db, false, ops)
+	acks, code, err := StorageWriteObjects(context.Background(), logger, db, false, 0, ops)
 
 	assert.Nil(t, err, "err was not nil")
 	assert.Equal(t, codes.OK, code, "code was not OK")
 	assert.NotNil(t, acks, "acks was nil")
 	assert.Len(t, acks.Acks, 3, "acks length was not 3")
 
-	values, code, err := StorageListObjects(context.Background(), logger, db, uuid.Must(uuid.NewV4()), &uid, collection, 10, "")
+	values, code, err := StorageListObjects(context.Background(), logger, db, uuid.Must(uuid.NewV4()), &uid, collection, 10, "", StorageListOrderDefault)
 
 	assert.Nil(t, err, "err was not nil")
 	assert.Equal(t, codes.OK, code, "code was not OK")
@@ -2063,14 +2126,14 @@ func TestStorageListNoRepeats(t *testing.T) {
 		},
 	}
 
-	acks, code, err := StorageWriteObjects(context.Background(), logger, db, false, ops)
+	acks, code, err := StorageWriteObjects(context.Background(), logger, db, false, 0, ops)
 
 	assert.Nil(t, err, "err was not nil")
 	assert.Equal(t, codes.OK, code, "code was not OK")
 	assert.NotNil(t, acks, "acks was nil")
 	assert.Len(t, acks.Acks, 7, "acks length was not 7")
 
-	values, code, err := StorageListObjects(context.Background(), logger, db, uuid.Must(uuid.NewV4()), &uid, collection, 10, "")
+	values, code, err := StorageListObjects(context.Background(), logger, db, uuid.Must(uuid.NewV4()), &uid, collection, 10, "", StorageListOrderDefault)
 
 	assert.Nil(t, err, "err was not nil")
 	assert.Equal(t, codes.OK, code, "code was not OK")
@@ -2078,3 +2141,245 @@ func TestStorageListNoRepeats(t *testing.T) {
 	assert.Len(t, values.Objects, 7, "values length was not 7")
 	assert.Equal(t, "", values.Cursor, "cursor was not nil")
 }
+
+func TestStorageListRuntimeOrderedOwnerScope(t *testing.T) {
+	db := NewDB(t)
+	defer db.Close()
+
+	uid1 := uuid.Must(uuid.NewV4())
+	InsertUser(t, db, uid1)
+	uid2 := uuid.Must(uuid.NewV4())
+	InsertUser(t, db, uid2)
+	collection := GenerateString()
+
+	ops := StorageOpWrites{
+		&StorageOpWrite{
+			OwnerID: uid1.String(),
+			Object: &api.WriteStorageObject{
+				Collection:      collection,
+				Key:             "b",
+				Value:           "{}",
+				PermissionRead:  &wrappers.Int32Value{Value: 0},
+				PermissionWrite: &wrappers.Int32Value{Value: 0},
+			},
+		},
+		&StorageOpWrite{
+			OwnerID: uid1.String(),
+			Object: &api.WriteStorageObject{
+				Collection:      collection,
+				Key:             "c",
+				Value:           "{}",
+				PermissionRead:  &wrappers.Int32Value{Value: 2},
+				PermissionWrite: &wrappers.Int32Value{Value: 1},
+			},
+		},
+		&StorageOpWrite{
+			OwnerID: uid2.String(),
+			Object: &api.WriteStorageObject{
+				Collection:      collection,
+				Key:             "a",
+				Value:           "{}",
+				PermissionRead:  &wrappers.Int32Value{Value: 1},
+				PermissionWrite: &wrappers.Int32Value{Value: 1},
+			},
+		},
+	}
+
+	acks, code, err := StorageWriteObjects(context.Background(), logger, db, true, 0, ops)
+
+	assert.Nil(t, err, "err was not nil")
+	assert.Equal(t, codes.OK, code, "code was not OK")
+	assert.Len(t, acks.Acks, 3, "acks length was not 3")
+
+	// Owner-scoped listing only returns that owner's objects.
+	list, code, err := StorageListObjects(context.Background(), logger, db, uuid.Nil, &uid1, collection, 10, "", StorageListOrderKey)
+
+	assert.Nil(t, err, "err was not nil")
+	assert.Equal(t, codes.OK, code, "code was not OK")
+	assert.Len(t, list.Objects, 2, "values length was not 2")
+	assert.Equal(t, "b", list.Objects[0].Key)
+	assert.Equal(t, "c", list.Objects[1].Key)
+
+	// All-owners listing returns every object, paged in key order.
+	list, code, err = StorageListObjects(context.Background(), logger, db, uuid.Nil, nil, collection, 2, "", StorageListOrderKey)
+
+	assert.Nil(t, err, "err was not nil")
+	assert.Equal(t, codes.OK, code, "code was not OK")
+	assert.Len(t, list.Objects, 2, "values length was not 2")
+	assert.Equal(t, "a", list.Objects[0].Key)
+	assert.Equal(t, uid2.String(), list.Objects[0].UserId)
+	assert.Equal(t, "b", list.Objects[1].Key)
+	assert.NotEmpty(t, list.Cursor, "cursor was empty")
+
+	// Cursors cannot be reused with a different order.
+	_, code, err = StorageListObjects(context.Background(), logger, db, uuid.Nil, nil, collection, 2, list.Cursor, StorageListOrderUpdateTime)
+	assert.NotNil(t, err, "err was nil")
+	assert.Equal(t, codes.InvalidArgument, code, "code was not InvalidArgument")
+
+	list, code, err = StorageListObjects(context.Background(), logger, db, uuid.Nil, nil, collection, 2, list.Cursor, StorageListOrderKey)
+
+	assert.Nil(t, err, "err was not nil")
+	assert.Equal(t, codes.OK, code, "code was not OK")
+	assert.Len(t, list.Objects, 1, "values length was not 1")
+	assert.Equal(t, "c", list.Objects[0].Key)
+	assert.Empty(t, list.Cursor, "cursor was not empty")
+
+	// Clients cannot request a custom order.
+	_, code, err = StorageListObjects(context.Background(), logger, db, uid1, &uid1, collection, 10, "", StorageListOrderKey)
+	assert.Equal(t, ErrStorageListOrderInvalid, err)
+	assert.Equal(t, codes.InvalidArgument, code, "code was not InvalidArgument")
+}
+
+func TestStorageListRuntimeOrderedUpdateTime(t *testing.T) {
+	db := NewDB(t)
+	defer db.Close()
+
+	uid := uuid.Must(uuid.NewV4())
+	InsertUser(t, db, uid)
+	collection := GenerateString()
+
+	for _, key := range []string{"a", "b", "c"} {
+		ops := StorageOpWrites{
+			&StorageOpWrite{
+				OwnerID: uid.String(),
+				Object: &api.WriteStorageObject{
+					Collection:      collection,
+					Key:             key,
+					Value:           "{}",
+					PermissionRead:  &wrappers.Int32Value{Value: 1},
+					PermissionWrite: &wrappers.Int32Value{Value: 1},
+				},
+			},
+		}
+		_, _, err := StorageWriteObjects(context.Background(), logger, db, true, 0, ops)
+		assert.Nil(t, err, "err was not nil")
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	list, code, err := StorageListObjects(context.Background(), logger, db, uuid.Nil, &uid, collection, 2, "", StorageListOrderUpdateTime)
+
+	assert.Nil(t, err, "err was not nil")
+	assert.Equal(t, codes.OK, code, "code was not OK")
+	assert.Len(t, list.Objects, 2, "values length was not 2")
+	assert.Equal(t, "c", list.Objects[0].Key)
+	assert.Equal(t, "b", list.Objects[1].Key)
+
+	list, code, err = StorageListObjects(context.Background(), logger, db, uuid.Nil, &uid, collection, 2, list.Cursor, StorageListOrderUpdateTime)
+
+	assert.Nil(t, err, "err was not nil")
+	assert.Equal(t, codes.OK, code, "code was not OK")
+	assert.Len(t, list.Objects, 1, "values length was not 1")
+	assert.Equal(t, "a", list.Objects[0].Key)
+}
+
+func TestStorageSize(t *testing.T) {
+	db := NewDB(t)
+	defer db.Close()
+
+	u0 := uuid.Must(uuid.NewV4())
+	InsertUser(t, db, u0)
+	u1 := uuid.Must(uuid.NewV4())
+	InsertUser(t, db, u1)
+
+	// Users with no objects have no usage.
+	size, count, err := StorageSize(context.Background(), logger, db, u0, "")
+	assert.Nil(t, err, "err was not nil")
+	assert.EqualValues(t, 0, size)
+	assert.EqualValues(t, 0, count)
+
+	ops := StorageOpWrites{}
+	for i, collection := range []string{"testsize", "testsize", "testsize", "othersize"} {
+		ops = append(ops, &StorageOpWrite{
+			OwnerID: u0.String(),
+			Object: &api.WriteStorageObject{
+				Collection: collection,
+				Key:        GenerateString(),
+				Value:      fmt.Sprintf(`{"index":%v,"padding":"%v"}`, i, strings.Repeat("x", 10*i)),
+			},
+		})
+	}
+	// Objects owned by other users are not counted.
+	ops = append(ops, &StorageOpWrite{
+		OwnerID: u1.String(),
+		Object: &api.WriteStorageObject{
+			Collection: "testsize",
+			Key:        GenerateString(),
+			Value:      `{"foo":"bar"}`,
+		},
+	})
+	_, _, err = StorageWriteObjects(context.Background(), logger, db, true, 0, ops)
+	assert.Nil(t, err, "err was not nil")
+
+	// Sizes are measured on values as stored, read them back to find the expected totals.
+	ids := make([]*api.ReadStorageObjectId, 0, len(ops)-1)
+	for _, op := range ops[:len(ops)-1] {
+		ids = append(ids, &api.ReadStorageObjectId{Collection: op.Object.Collection, Key: op.Object.Key, UserId: u0.String()})
+	}
+	objects, err := StorageReadObjects(context.Background(), logger, db, uuid.Nil, ids)
+	assert.Nil(t, err, "err was not nil")
+	assert.Len(t, objects.Objects, 4)
+	expectedSizes := make(map[string]int64, 2)
+	for _, object := range objects.Objects {
+		expectedSizes[object.Collection] += int64(len(object.Value))
+	}
+
+	size, count, err = StorageSize(context.Background(), logger, db, u0, "testsize")
+	assert.Nil(t, err, "err was not nil")
+	assert.Equal(t, expectedSizes["testsize"], size)
+	assert.EqualValues(t, 3, count)
+
+	size, count, err = StorageSize(context.Background(), logger, db, u0, "")
+	assert.Nil(t, err, "err was not nil")
+	assert.Equal(t, expectedSizes["testsize"]+expectedSizes["othersize"], size)
+	assert.EqualValues(t, 4, count)
+
+	size, count, err = StorageSize(context.Background(), logger, db, u0, "missing")
+	assert.Nil(t, err, "err was not nil")
+	assert.EqualValues(t, 0, size)
+	assert.EqualValues(t, 0, count)
+}
+
+func TestStorageCheckObjectSizes(t *testing.T) {
+	ops := StorageOpWrites{
+		&StorageOpWrite{
+			OwnerID: uuid.Nil.String(),
+			Object:  &api.WriteStorageObject{Collection: "testcollection", Key: "small", Value: `{"a":1}`},
+		},
+	}
+
+	// An object within the limit, or with no limit set, is allowed.
+	assert.NoError(t, StorageCheckObjectSizes(ops, 7))
+	assert.NoError(t, StorageCheckObjectSizes(ops, 0))
+
+	ops = append(ops, &StorageOpWrite{
+		OwnerID: uuid.Nil.String(),
+		Object:  &api.WriteStorageObject{Collection: "testcollection", Key: "large", Value: `{"a":"` + strings.Repeat("x", 100) + `"}`},
+	})
+	assert.EqualError(t, StorageCheckObjectSizes(ops, 64), "storage object value in collection 'testcollection' with key 'large' is 108 bytes, exceeds the maximum of 64 bytes")
+	assert.NoError(t, StorageCheckObjectSizes(ops, 0))
+
+	// Writes are rejected before reaching the database.
+	acks, code, err := StorageWriteObjects(context.Background(), logger, nil, true, 64, ops)
+	assert.Nil(t, acks)
+	assert.Equal(t, codes.InvalidArgument, code)
+	assert.EqualError(t, err, "storage object value in collection 'testcollection' with key 'large' is 108 bytes, exceeds the maximum of 64 bytes")
+}
+
+func TestStorageAckVersions(t *testing.T) {
+	userID := uuid.Must(uuid.NewV4()).String()
+	acks := &api.StorageObjectAcks{Acks: []*api.StorageObjectAck{
+		{Collection: "testcollection", Key: "key", UserId: userID, Version: "v1"},
+		{Collection: "testcollection", Key: "key", Version: "v2"},
+		{Collection: "othercollection", Key: "key", UserId: userID, Version: "v3"},
+	}}
+
+	// The same key in different collections and for different owners is summarised separately.
+	assert.Equal(t, map[string]map[string]map[string]string{
+		"testcollection": {
+			"key": {userID: "v1", uuid.Nil.String(): "v2"},
+		},
+		"othercollection": {
+			"key": {userID: "v3"},
+		},
+	}, StorageAckVersions(acks))
+}
@@ -17,11 +17,14 @@ package server
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
 	"database/sql"
 	"encoding/base64"
 	"encoding/gob"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 	"time"
@@ -37,12 +40,36 @@ import (
 
 var (
 	ErrTournamentNotFound                = errors.New("tournament not found")
+	ErrTournamentInvalidOwner            = errors.New("tournament record owner ID invalid")
 	ErrTournamentMaxSizeReached          = errors.New("tournament max size reached")
 	ErrTournamentOutsideDuration         = errors.New("tournament outside of duration")
 	ErrTournamentWriteMaxNumScoreReached = errors.New("max number score count reached")
 	ErrTournamentWriteJoinRequired       = errors.New("required to join before writing tournament record")
 )
 
+// Storage collection tournament waitlists are kept in. Each waitlisted user has a system-owned object per tournament
+// period that clients cannot read or write, and the oldest object for a period is promoted when a participant leaves.
+const TournamentWaitlistStorageCollection = "tournament_waitlist"
+
+type TournamentJoinStatus int
+
+const (
+	TournamentJoinStatusJoined TournamentJoinStatus = iota
+	TournamentJoinStatusWaitlisted
+)
+
+func (s TournamentJoinStatus) String() string {
+	if s == TournamentJoinStatusWaitlisted {
+		return "waitlisted"
+	}
+	return "joined"
+}
+
+type tournamentWaitlistEntry struct {
+	UserID   string `json:"user_id"`
+	Username string `json:"username"`
+}
+
 type TournamentListCursor struct {
 	Id string
 }
@@ -90,19 +117,23 @@ func TournamentDelete(ctx context.Context, cache LeaderboardCache, rankCache Lea
 	return nil
 }
 
-func TournamentAddAttempt(ctx context.Context, logger *zap.Logger, db *sql.DB, cache LeaderboardCache, leaderboardId string, owner string, count int) error {
-	if count == 0 {
-		// No-op.
-		return nil
-	}
+// Upper bound on the number of attempts a tournament record may be granted, matching the range of the database column.
+const TournamentMaxNumScoreLimit = math.MaxInt32
+
+// Grant (positive count) or deduct (negative count) score attempts for the owner's current tournament record, and return
+// their new maximum number of attempts. A maximum of 0 means unlimited attempts and is left unchanged by both grants and
+// deductions. Otherwise deductions never leave the owner with fewer attempts than they have already used, and always leave
+// at least 1, while grants are clamped at TournamentMaxNumScoreLimit. Returns 0 if the owner has no record in the current
+// tournament period.
+func TournamentAddAttempt(ctx context.Context, logger *zap.Logger, db *sql.DB, cache LeaderboardCache, leaderboardId string, owner string, count int) (int, error) {
 	leaderboard := cache.Get(leaderboardId)
 	if leaderboard == nil {
 		// If it does not exist treat it as success.
-		return ErrTournamentNotFound
+		return 0, ErrTournamentNotFound
 	}
 	if !leaderboard.IsTournament() {
 		// Leaderboard exists but is not a tournament, treat it as success.
-		return ErrTournamentNotFound
+		return 0, ErrTournamentNotFound
 	}
 
 	expiryTime := int64(0)
@@ -113,14 +144,22 @@ func TournamentAddAttempt(ctx context.Context, logger *zap.Logger, db *sql.DB, c
 		}
 	}
 
-	query := `UPDATE leaderboard_record SET max_num_score = (max_num_score + $1) WHERE leaderboard_id = $2 AND owner_id = $3 AND expiry_time = $4`
-	_, err := db.ExecContext(ctx, query, count, leaderboardId, owner, time.Unix(expiryTime, 0).UTC())
+	query := `UPDATE leaderboard_record SET max_num_score = CASE WHEN max_num_score = 0 THEN 0 ELSE GREATEST(LEAST(max_num_score::BIGINT + $1, $5), num_score, 1) END
+WHERE leaderboard_id = $2 AND owner_id = $3 AND expiry_time = $4
+RETURNING max_num_score`
+	var maxNumScore int
+	err := db.QueryRowContext(ctx, query, count, leaderboardId, owner, time.Unix(expiryTime, 0).UTC(), TournamentMaxNumScoreLimit).Scan(&maxNumScore)
 	if err != nil {
-		logger.Error("Could not increment max attempt counter", zap.Error(err))
-	} else {
-		logger.Info("Max attempt count was increased", zap.Int("new_count", count), zap.String("owner", owner), zap.String("leaderboard_id", leaderboardId))
+		if err == sql.ErrNoRows {
+			// No record to adjust, treat it as success.
+			return 0, nil
+		}
+		logger.Error("Could not update max attempt counter", zap.Error(err))
+		return 0, err
 	}
-	return nil
+
+	logger.Info("Max attempt count was updated", zap.Int("count", count), zap.Int("new_max_num_score", maxNumScore), zap.String("owner", owner), zap.String("leaderboard_id", leaderboardId))
+	return maxNumScore, nil
 }
 
 func TournamentJoin(ctx context.Context, logger *zap.Logger, db *sql.DB, cache LeaderboardCache, owner, username, tournamentId string) error {
@@ -197,6 +236,145 @@ ON CONFLICT(owner_id, leaderboard_id, expiry_time) DO NOTHING`
 	return nil
 }
 
+// TournamentJoinWaitlist joins a tournament like TournamentJoin, but when the tournament is full the owner is added to
+// the waitlist for its current period instead of being rejected. Joining again while waitlisted keeps their place.
+func TournamentJoinWaitlist(ctx context.Context, logger *zap.Logger, db *sql.DB, cache LeaderboardCache, owner, username, tournamentId string) (TournamentJoinStatus, error) {
+	if err := TournamentJoin(ctx, logger, db, cache, owner, username, tournamentId); err != ErrTournamentMaxSizeReached {
+		return TournamentJoinStatusJoined, err
+	}
+
+	leaderboard := cache.Get(tournamentId)
+	if leaderboard == nil {
+		return TournamentJoinStatusJoined, ErrTournamentNotFound
+	}
+	expiryTime, _ := calculateExpiryOverride(0, leaderboard)
+
+	value, err := json.Marshal(&tournamentWaitlistEntry{UserID: owner, Username: username})
+	if err != nil {
+		logger.Error("Could not encode tournament waitlist entry.", zap.Error(err))
+		return TournamentJoinStatusJoined, err
+	}
+	ops := StorageOpWrites{&StorageOpWrite{
+		OwnerID: uuid.Nil.String(),
+		Object: &api.WriteStorageObject{
+			Collection: TournamentWaitlistStorageCollection,
+			Key:        tournamentWaitlistKey(tournamentId, expiryTime, owner),
+			Value:      string(value),
+			// Only write if not already waitlisted, keeping the original place.
+			Version:         "*",
+			PermissionRead:  &wrappers.Int32Value{Value: 0},
+			PermissionWrite: &wrappers.Int32Value{Value: 0},
+		},
+	}}
+	if _, _, err := StorageWriteObjects(ctx, logger, db, true, 0, ops); err != nil && err != ErrStorageRejectedVersion {
+		return TournamentJoinStatusJoined, err
+	}
+
+	logger.Info("Waitlisted for tournament.", zap.String("tournament_id", tournamentId), zap.String("owner", owner), zap.String("username", username))
+	return TournamentJoinStatusWaitlisted, nil
+}
+
+// TournamentLeave removes the owner from the current period of a tournament, deleting their record and freeing their
+// place for the oldest waitlisted user. Owners who are only waitlisted are removed from the waitlist instead.
+func TournamentLeave(ctx context.Context, logger *zap.Logger, db *sql.DB, cache LeaderboardCache, rankCache LeaderboardRankCache, owner, tournamentId string) error {
+	leaderboard := cache.Get(tournamentId)
+	if leaderboard == nil || !leaderboard.IsTournament() {
+		return ErrTournamentNotFound
+	}
+
+	expiryTime, recordsPossible := calculateExpiryOverride(0, leaderboard)
+	if !recordsPossible {
+		// Tournament has ended, there is nothing to leave.
+		return nil
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Error("Could not begin database transaction.", zap.Error(err))
+		return err
+	}
+
+	var left bool
+	if err = ExecuteInTx(ctx, tx, func() error {
+		left = false
+		query := "DELETE FROM leaderboard_record WHERE leaderboard_id = $1 AND owner_id = $2 AND expiry_time = $3"
+		result, err := tx.ExecContext(ctx, query, tournamentId, owner, time.Unix(expiryTime, 0).UTC())
+		if err != nil {
+			return err
+		}
+		if rowsAffected, err := result.RowsAffected(); err != nil {
+			return err
+		} else if rowsAffected == 0 {
+			return nil
+		}
+
+		if _, err = tx.ExecContext(ctx, "UPDATE leaderboard SET size = size - 1 WHERE id = $1 AND size > 0", tournamentId); err != nil {
+			return err
+		}
+		left = true
+		return nil
+	}); err != nil {
+		logger.Error("Could not leave tournament.", zap.Error(err))
+		return err
+	}
+
+	if !left {
+		// Not a participant, but may be waiting for a place.
+		if _, err := db.ExecContext(ctx, "DELETE FROM storage WHERE collection = $1 AND key = $2 AND user_id = $3", TournamentWaitlistStorageCollection, tournamentWaitlistKey(tournamentId, expiryTime, owner), uuid.Nil); err != nil {
+			logger.Error("Could not remove tournament waitlist entry.", zap.Error(err))
+			return err
+		}
+		return nil
+	}
+
+	rankCache.Delete(tournamentId, expiryTime, uuid.Must(uuid.FromString(owner)))
+	logger.Info("Left tournament.", zap.String("tournament_id", tournamentId), zap.String("owner", owner))
+
+	return tournamentWaitlistPromote(ctx, logger, db, cache, tournamentId, expiryTime)
+}
+
+func tournamentWaitlistPromote(ctx context.Context, logger *zap.Logger, db *sql.DB, cache LeaderboardCache, tournamentId string, expiryTime int64) error {
+	var key, value string
+	query := "SELECT key, value FROM storage WHERE collection = $1 AND user_id = $2 AND key LIKE $3 ORDER BY create_time ASC, key ASC LIMIT 1"
+	if err := db.QueryRowContext(ctx, query, TournamentWaitlistStorageCollection, uuid.Nil, tournamentWaitlistPrefix(tournamentId, expiryTime)+"%").Scan(&key, &value); err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		logger.Error("Could not read tournament waitlist.", zap.Error(err))
+		return err
+	}
+	entry := &tournamentWaitlistEntry{}
+	if err := json.Unmarshal([]byte(value), entry); err != nil {
+		logger.Error("Could not decode tournament waitlist entry.", zap.Error(err))
+		return err
+	}
+
+	// Join before leaving the waitlist, so a user who loses the place to a concurrent join keeps their position.
+	if err := TournamentJoin(ctx, logger, db, cache, entry.UserID, entry.Username, tournamentId); err != nil {
+		if err == ErrTournamentMaxSizeReached {
+			return nil
+		}
+		return err
+	}
+	if _, err := db.ExecContext(ctx, "DELETE FROM storage WHERE collection = $1 AND key = $2 AND user_id = $3", TournamentWaitlistStorageCollection, key, uuid.Nil); err != nil {
+		logger.Error("Could not remove tournament waitlist entry.", zap.Error(err))
+		return err
+	}
+
+	logger.Info("Promoted from tournament waitlist.", zap.String("tournament_id", tournamentId), zap.String("owner", entry.UserID))
+	return nil
+}
+
+// Waitlist keys share a prefix per tournament period. Tournament IDs may be as long as a storage key, so the period is
+// hashed to keep the prefix and owner within the key length limit.
+func tournamentWaitlistPrefix(tournamentId string, expiryTime int64) string {
+	return fmt.Sprintf("%x.", md5.Sum([]byte(fmt.Sprintf("%v.%v", tournamentId, expiryTime))))
+}
+
+func tournamentWaitlistKey(tournamentId string, expiryTime int64, owner string) string {
+	return tournamentWaitlistPrefix(tournamentId, expiryTime) + owner
+}
+
 func TournamentsGet(ctx context.Context, logger *zap.Logger, db *sql.DB, tournamentIDs []string) ([]*api.Tournament, error) {
 	now := time.Now().UTC()
 
@@ -356,6 +534,64 @@ func TournamentRecordsList(ctx context.Context, logger *zap.Logger, db *sql.DB,
 	return recordList, nil
 }
 
+// TournamentRecordDelete deletes the owner's record in the current period of a tournament, freeing their place for the
+// oldest waitlisted user.
+func TournamentRecordDelete(ctx context.Context, logger *zap.Logger, db *sql.DB, leaderboardCache LeaderboardCache, rankCache LeaderboardRankCache, tournamentId, ownerID string) error {
+	leaderboard := leaderboardCache.Get(tournamentId)
+	if leaderboard == nil || !leaderboard.IsTournament() {
+		return ErrTournamentNotFound
+	}
+
+	ownerUUID, err := uuid.FromString(ownerID)
+	if err != nil {
+		return ErrTournamentInvalidOwner
+	}
+
+	expiryTime, recordsPossible := calculateExpiryOverride(0, leaderboard)
+	if !recordsPossible {
+		// Tournament has ended, there is no current record to delete.
+		return nil
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Error("Could not begin database transaction.", zap.Error(err))
+		return err
+	}
+
+	var deleted bool
+	if err = ExecuteInTx(ctx, tx, func() error {
+		deleted = false
+		query := "DELETE FROM leaderboard_record WHERE leaderboard_id = $1 AND owner_id = $2 AND expiry_time = $3"
+		result, err := tx.ExecContext(ctx, query, tournamentId, ownerUUID, time.Unix(expiryTime, 0).UTC())
+		if err != nil {
+			return err
+		}
+		if rowsAffected, err := result.RowsAffected(); err != nil {
+			return err
+		} else if rowsAffected == 0 {
+			return nil
+		}
+
+		// The owner no longer counts towards the tournament size.
+		if _, err = tx.ExecContext(ctx, "UPDATE leaderboard SET size = size - 1 WHERE id = $1 AND size > 0", tournamentId); err != nil {
+			return err
+		}
+		deleted = true
+		return nil
+	}); err != nil {
+		logger.Error("Error deleting tournament record", zap.Error(err))
+		return err
+	}
+
+	if !deleted {
+		return nil
+	}
+
+	rankCache.Delete(tournamentId, expiryTime, ownerUUID)
+	return tournamentWaitlistPromote(ctx, logger, db, leaderboardCache, tournamentId, expiryTime)
+}
+
 func TournamentRecordWrite(ctx context.Context, logger *zap.Logger, db *sql.DB, leaderboardCache LeaderboardCache, rankCache LeaderboardRankCache, tournamentId string, ownerId uuid.UUID, username string, score, subscore int64, metadata string) (*api.LeaderboardRecord, error) {
 	leaderboard := leaderboardCache.Get(tournamentId)
 
@@ -396,11 +632,11 @@ func TournamentRecordWrite(ctx context.Context, logger *zap.Logger, db *sql.DB,
 	default:
 		if leaderboard.SortOrder == LeaderboardSortOrderAscending {
 			// Lower score is better.
-			opSQL = "score = div((leaderboard_record.score + $5 - abs(leaderboard_record.score - $5)), 2), subscore = div((leaderboard_record.subscore + $6 - abs(leaderboard_record.subscore - $6)), 2)"
+			opSQL = "score = LEAST(leaderboard_record.score, $5), subscore = LEAST(leaderboard_record.subscore, $6)"
 			filterSQL = " WHERE (leaderboard_record.score > $5 OR leaderboard_record.subscore > $6)"
 		} else {
 			// Higher score is better.
-			opSQL = "score = div((leaderboard_record.score + $5 + abs(leaderboard_record.score - $5)), 2), subscore = div((leaderboard_record.subscore + $6 + abs(leaderboard_record.subscore - $6)), 2)"
+			opSQL = "score = GREATEST(leaderboard_record.score, $5), subscore = GREATEST(leaderboard_record.subscore, $6)"
 			filterSQL = " WHERE (leaderboard_record.score < $5 OR leaderboard_record.subscore < $6)"
 		}
 		scoreDelta = score
@@ -444,6 +680,9 @@ func TournamentRecordWrite(ctx context.Context, logger *zap.Logger, db *sql.DB,
 		logger.Debug("Tournament update query", zap.String("query", query), zap.Any("params", params))
 		_, err = db.ExecContext(ctx, query, params...)
 		if err != nil {
+			if err = leaderboardScoreError(err); err == ErrLeaderboardScoreOutOfRange {
+				return nil, err
+			}
 			logger.Error("Error writing tournament record", zap.Error(err))
 			return nil, err
 		}
@@ -491,7 +730,8 @@ func TournamentRecordWrite(ctx context.Context, logger *zap.Logger, db *sql.DB,
 
 			return nil
 		}); err != nil {
-			if err == ErrTournamentWriteMaxNumScoreReached || err == ErrTournamentMaxSizeReached {
+			err = leaderboardScoreError(err)
+			if err == ErrTournamentWriteMaxNumScoreReached || err == ErrTournamentMaxSizeReached || err == ErrLeaderboardScoreOutOfRange {
 				logger.Info("Aborted writing tournament record", zap.String("reason", err.Error()), zap.String("tournament_id", tournamentId), zap.String("owner_id", ownerId.String()))
 			} else {
 				logger.Error("Could not write tournament record", zap.Error(err), zap.String("tournament_id", tournamentId), zap.String("owner_id", ownerId.String()))
@@ -623,9 +863,9 @@ func calculateTournamentDeadlines(startTime, endTime, duration int64, resetSched
 		endActiveUnix = startTime + duration
 	}
 	expiryUnix := endTime
-	if endActiveUnix > expiryUnix {
-		// Cap the end active to the same time as the expiry.
-		endActiveUnix = expiryUnix
+	if endTime > 0 && endActiveUnix > endTime {
+		// Cap the end active to the same time as the expiry. Tournaments without an end time never expire.
+		endActiveUnix = endTime
 	}
 	return startTime, endActiveUnix, expiryUnix
 }
@@ -0,0 +1,271 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/heroiclabs/nakama/v2/internal/cronexpr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTournamentRecordDelete(t *testing.T) {
+	db := NewDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	leaderboardCache := NewLocalLeaderboardCache(logger, logger, db)
+	rankCache := NewLocalLeaderboardRankCache(logger, db, NewConfig(logger).GetLeaderboard(), leaderboardCache)
+
+	tournamentID := uuid.Must(uuid.NewV4()).String()
+	if _, err := leaderboardCache.CreateTournament(ctx, tournamentID, LeaderboardSortOrderDescending, LeaderboardOperatorBest, "", "{}", "", "", 0, 0, 0, 3600, 2, 0, false); err != nil {
+		t.Fatalf("error creating tournament: %v", err.Error())
+	}
+
+	ownerIDs := []uuid.UUID{uuid.Must(uuid.NewV4()), uuid.Must(uuid.NewV4())}
+	for i, ownerID := range ownerIDs {
+		if _, err := TournamentRecordWrite(ctx, logger, db, leaderboardCache, rankCache, tournamentID, ownerID, "", int64(i+1), 0, "{}"); err != nil {
+			t.Fatalf("error writing tournament record: %v", err.Error())
+		}
+	}
+
+	// The tournament is full.
+	newOwnerID := uuid.Must(uuid.NewV4())
+	_, err := TournamentRecordWrite(ctx, logger, db, leaderboardCache, rankCache, tournamentID, newOwnerID, "", 3, 0, "{}")
+	assert.Equal(t, ErrTournamentMaxSizeReached, err)
+
+	err = TournamentRecordDelete(ctx, logger, db, leaderboardCache, rankCache, tournamentID, "invalid")
+	assert.Equal(t, ErrTournamentInvalidOwner, err)
+
+	if err := TournamentRecordDelete(ctx, logger, db, leaderboardCache, rankCache, tournamentID, ownerIDs[1].String()); err != nil {
+		t.Fatalf("error deleting tournament record: %v", err.Error())
+	}
+
+	var size int
+	if err := db.QueryRowContext(ctx, "SELECT size FROM leaderboard WHERE id = $1", tournamentID).Scan(&size); err != nil {
+		t.Fatalf("error reading tournament size: %v", err.Error())
+	}
+	assert.Equal(t, 1, size, "deleting a record should free its place")
+
+	list, err := TournamentRecordsList(ctx, logger, db, leaderboardCache, rankCache, tournamentID, nil, &wrappers.Int32Value{Value: 10}, "", 0)
+	if err != nil {
+		t.Fatalf("error listing tournament records: %v", err.Error())
+	}
+	assert.Len(t, list.Records, 1)
+	assert.Equal(t, ownerIDs[0].String(), list.Records[0].OwnerId)
+	assert.Equal(t, int64(1), list.Records[0].Rank)
+
+	// A new owner can take the freed place.
+	if _, err := TournamentRecordWrite(ctx, logger, db, leaderboardCache, rankCache, tournamentID, newOwnerID, "", 3, 0, "{}"); err != nil {
+		t.Fatalf("error writing tournament record: %v", err.Error())
+	}
+
+	err = TournamentRecordDelete(ctx, logger, db, leaderboardCache, rankCache, uuid.Must(uuid.NewV4()).String(), ownerIDs[0].String())
+	assert.Equal(t, ErrTournamentNotFound, err)
+}
+
+func TestTournamentAddAttempt(t *testing.T) {
+	db := NewDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	leaderboardCache := NewLocalLeaderboardCache(logger, logger, db)
+	rankCache := NewLocalLeaderboardRankCache(logger, db, NewConfig(logger).GetLeaderboard(), leaderboardCache)
+
+	tournamentID := uuid.Must(uuid.NewV4()).String()
+	if _, err := leaderboardCache.CreateTournament(ctx, tournamentID, LeaderboardSortOrderDescending, LeaderboardOperatorBest, "", "{}", "", "", 0, 0, 0, 3600, 0, 3, false); err != nil {
+		t.Fatalf("error creating tournament: %v", err.Error())
+	}
+
+	ownerID := uuid.Must(uuid.NewV4())
+	if _, err := TournamentRecordWrite(ctx, logger, db, leaderboardCache, rankCache, tournamentID, ownerID, "", 1, 0, "{}"); err != nil {
+		t.Fatalf("error writing tournament record: %v", err.Error())
+	}
+
+	// Grant extra attempts.
+	maxNumScore, err := TournamentAddAttempt(ctx, logger, db, leaderboardCache, tournamentID, ownerID.String(), 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, maxNumScore)
+
+	// Deduct attempts.
+	maxNumScore, err = TournamentAddAttempt(ctx, logger, db, leaderboardCache, tournamentID, ownerID.String(), -3)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, maxNumScore)
+
+	// Deductions are clamped so the 1 attempt already used leaves no remaining attempts.
+	maxNumScore, err = TournamentAddAttempt(ctx, logger, db, leaderboardCache, tournamentID, ownerID.String(), -10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, maxNumScore)
+	_, err = TournamentRecordWrite(ctx, logger, db, leaderboardCache, rankCache, tournamentID, ownerID, "", 2, 0, "{}")
+	assert.Equal(t, ErrTournamentWriteMaxNumScoreReached, err)
+
+	// Grants are clamped at the upper limit.
+	maxNumScore, err = TournamentAddAttempt(ctx, logger, db, leaderboardCache, tournamentID, ownerID.String(), TournamentMaxNumScoreLimit)
+	assert.NoError(t, err)
+	assert.Equal(t, TournamentMaxNumScoreLimit, maxNumScore)
+
+	// Owners without a record have nothing to adjust.
+	maxNumScore, err = TournamentAddAttempt(ctx, logger, db, leaderboardCache, tournamentID, uuid.Must(uuid.NewV4()).String(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, maxNumScore)
+
+	_, err = TournamentAddAttempt(ctx, logger, db, leaderboardCache, uuid.Must(uuid.NewV4()).String(), ownerID.String(), 1)
+	assert.Equal(t, ErrTournamentNotFound, err)
+
+	// Unlimited attempts stay unlimited through both grants and deductions.
+	unlimitedID := uuid.Must(uuid.NewV4()).String()
+	if _, err := leaderboardCache.CreateTournament(ctx, unlimitedID, LeaderboardSortOrderDescending, LeaderboardOperatorBest, "", "{}", "", "", 0, 0, 0, 3600, 0, 0, false); err != nil {
+		t.Fatalf("error creating tournament: %v", err.Error())
+	}
+	if _, err := TournamentRecordWrite(ctx, logger, db, leaderboardCache, rankCache, unlimitedID, ownerID, "", 1, 0, "{}"); err != nil {
+		t.Fatalf("error writing tournament record: %v", err.Error())
+	}
+	for _, count := range []int{-1, 2} {
+		maxNumScore, err = TournamentAddAttempt(ctx, logger, db, leaderboardCache, unlimitedID, ownerID.String(), count)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, maxNumScore)
+	}
+	_, err = TournamentRecordWrite(ctx, logger, db, leaderboardCache, rankCache, unlimitedID, ownerID, "", 2, 0, "{}")
+	assert.NoError(t, err)
+}
+
+func TestTournamentJoinWaitlist(t *testing.T) {
+	db := NewDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	leaderboardCache := NewLocalLeaderboardCache(logger, logger, db)
+	rankCache := NewLocalLeaderboardRankCache(logger, db, NewConfig(logger).GetLeaderboard(), leaderboardCache)
+
+	tournamentID := uuid.Must(uuid.NewV4()).String()
+	if _, err := leaderboardCache.CreateTournament(ctx, tournamentID, LeaderboardSortOrderDescending, LeaderboardOperatorBest, "", "{}", "", "", 0, 0, 0, 3600, 2, 0, true); err != nil {
+		t.Fatalf("error creating tournament: %v", err.Error())
+	}
+
+	ownerIDs := make([]string, 0, 4)
+	for i := 0; i < 4; i++ {
+		ownerIDs = append(ownerIDs, uuid.Must(uuid.NewV4()).String())
+	}
+	participants := func() []string {
+		list, err := TournamentRecordsList(ctx, logger, db, leaderboardCache, rankCache, tournamentID, ownerIDs, &wrappers.Int32Value{Value: 10}, "", 0)
+		if err != nil {
+			t.Fatalf("error listing tournament records: %v", err.Error())
+		}
+		owners := make([]string, 0, len(list.OwnerRecords))
+		for _, record := range list.OwnerRecords {
+			owners = append(owners, record.OwnerId)
+		}
+		return owners
+	}
+
+	// Join up to capacity, then further joins are waitlisted in order.
+	expected := []TournamentJoinStatus{TournamentJoinStatusJoined, TournamentJoinStatusJoined, TournamentJoinStatusWaitlisted, TournamentJoinStatusWaitlisted}
+	for i, ownerID := range ownerIDs {
+		status, err := TournamentJoinWaitlist(ctx, logger, db, leaderboardCache, ownerID, "user", tournamentID)
+		if err != nil {
+			t.Fatalf("error joining tournament: %v", err.Error())
+		}
+		assert.Equal(t, expected[i], status, "join status for owner %v", i)
+	}
+	assert.ElementsMatch(t, ownerIDs[:2], participants())
+
+	// Waitlist entries are system-owned and hidden from clients.
+	var count int
+	query := "SELECT count(*) FROM storage WHERE collection = $1 AND user_id = $2 AND key LIKE $3 AND read = 0 AND write = 0"
+	if err := db.QueryRowContext(ctx, query, TournamentWaitlistStorageCollection, uuid.Nil, tournamentWaitlistPrefix(tournamentID, 0)+"%").Scan(&count); err != nil {
+		t.Fatalf("error counting waitlist entries: %v", err.Error())
+	}
+	assert.Equal(t, 2, count)
+
+	// Joining again keeps the owner's place, and joins without a waitlist are still rejected.
+	status, err := TournamentJoinWaitlist(ctx, logger, db, leaderboardCache, ownerIDs[2], "user", tournamentID)
+	assert.NoError(t, err)
+	assert.Equal(t, TournamentJoinStatusWaitlisted, status)
+	err = TournamentJoin(ctx, logger, db, leaderboardCache, ownerIDs[3], "user", tournamentID)
+	assert.Equal(t, ErrTournamentMaxSizeReached, err)
+
+	// A participant leaving promotes the first waitlisted owner.
+	assert.NoError(t, TournamentLeave(ctx, logger, db, leaderboardCache, rankCache, ownerIDs[0], tournamentID))
+	assert.ElementsMatch(t, []string{ownerIDs[1], ownerIDs[2]}, participants())
+
+	// A waitlisted owner leaving gives up their place in the waitlist, so nobody is promoted into the next free place.
+	assert.NoError(t, TournamentLeave(ctx, logger, db, leaderboardCache, rankCache, ownerIDs[3], tournamentID))
+	assert.NoError(t, TournamentLeave(ctx, logger, db, leaderboardCache, rankCache, ownerIDs[1], tournamentID))
+	assert.ElementsMatch(t, []string{ownerIDs[2]}, participants())
+
+	// The freed place can be joined directly again.
+	status, err = TournamentJoinWaitlist(ctx, logger, db, leaderboardCache, ownerIDs[0], "user", tournamentID)
+	assert.NoError(t, err)
+	assert.Equal(t, TournamentJoinStatusJoined, status)
+}
+
+func TestCalculateTournamentDeadlinesDailyReset(t *testing.T) {
+	// Resets daily at noon, with each round active for an hour.
+	schedule := cronexpr.MustParse("0 12 * * *")
+	day := time.Date(2020, 10, 16, 0, 0, 0, 0, time.UTC)
+	noon := day.Add(12 * time.Hour).Unix()
+	nextNoon := day.Add(36 * time.Hour).Unix()
+
+	// Within the active window.
+	startActive, endActive, expiry := calculateTournamentDeadlines(0, 0, 3600, schedule, day.Add(12*time.Hour+30*time.Minute))
+	assert.Equal(t, noon, startActive)
+	assert.Equal(t, noon+3600, endActive)
+	assert.Equal(t, nextNoon, expiry)
+
+	// After the active window closes, the window reported is the one that just ended.
+	startActive, endActive, expiry = calculateTournamentDeadlines(0, 0, 3600, schedule, day.Add(15*time.Hour))
+	assert.Equal(t, noon, startActive)
+	assert.Equal(t, noon+3600, endActive)
+	assert.Equal(t, nextNoon, expiry)
+
+	// Before the first reset of the day, the previous day's window is current.
+	startActive, endActive, expiry = calculateTournamentDeadlines(0, 0, 3600, schedule, day.Add(11*time.Hour))
+	assert.Equal(t, noon-86400, startActive)
+	assert.Equal(t, noon-86400+3600, endActive)
+	assert.Equal(t, noon, expiry)
+
+	// An end time caps both the active window and expiry.
+	startActive, endActive, expiry = calculateTournamentDeadlines(0, noon+1800, 3600, schedule, day.Add(12*time.Hour+10*time.Minute))
+	assert.Equal(t, noon, startActive)
+	assert.Equal(t, noon+1800, endActive)
+	assert.Equal(t, noon+1800, expiry)
+}
+
+func TestCalculateTournamentDeadlinesNoSchedule(t *testing.T) {
+	now := time.Date(2020, 10, 16, 12, 0, 0, 0, time.UTC)
+	start := now.Add(-time.Hour).Unix()
+
+	// No end time means the tournament never expires, and stays active for its duration.
+	startActive, endActive, expiry := calculateTournamentDeadlines(start, 0, 7200, nil, now)
+	assert.Equal(t, start, startActive)
+	assert.Equal(t, start+7200, endActive)
+	assert.Equal(t, int64(0), expiry)
+
+	// An end time caps the active window.
+	startActive, endActive, expiry = calculateTournamentDeadlines(start, start+3600, 7200, nil, now)
+	assert.Equal(t, start, startActive)
+	assert.Equal(t, start+3600, endActive)
+	assert.Equal(t, start+3600, expiry)
+
+	// Tournaments that haven't started yet are not active.
+	future := now.Add(time.Hour).Unix()
+	startActive, endActive, _ = calculateTournamentDeadlines(future, 0, 7200, nil, now)
+	assert.Equal(t, future, startActive)
+	assert.Equal(t, int64(0), endActive)
+}
@@ -19,6 +19,7 @@ import (
 	"database/sql"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gofrs/uuid"
 	"github.com/golang/protobuf/ptypes/timestamp"
@@ -146,6 +147,28 @@ func UnbanUsers(ctx context.Context, logger *zap.Logger, db *sql.DB, ids []strin
 	return nil
 }
 
+// CountUsers returns the number of user accounts, excluding the system user.
+func CountUsers(ctx context.Context, logger *zap.Logger, db *sql.DB) (int64, error) {
+	var count int64
+	if err := db.QueryRowContext(ctx, "SELECT count(id) FROM users WHERE id <> $1", uuid.Nil).Scan(&count); err != nil {
+		logger.Error("Could not count users.", zap.Error(err))
+		return 0, err
+	}
+	return count, nil
+}
+
+// CountUsersByCreateTime returns the number of user accounts created at or after start and before end, using the index
+// on user creation time.
+func CountUsersByCreateTime(ctx context.Context, logger *zap.Logger, db *sql.DB, start, end time.Time) (int64, error) {
+	var count int64
+	query := "SELECT count(id) FROM users WHERE create_time >= $1 AND create_time < $2 AND id <> $3"
+	if err := db.QueryRowContext(ctx, query, start.UTC(), end.UTC(), uuid.Nil).Scan(&count); err != nil {
+		logger.Error("Could not count users by create time.", zap.Error(err), zap.Time("start", start), zap.Time("end", end))
+		return 0, err
+	}
+	return count, nil
+}
+
 func UserExistsAndDoesNotBlock(ctx context.Context, db *sql.DB, checkUserID, blocksUserID uuid.UUID) (bool, error) {
 	var count int
 	err := db.QueryRowContext(ctx, `
@@ -0,0 +1,73 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountUsers(t *testing.T) {
+	db := NewDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	// Count in a window around the current time, the database clock may differ slightly from the local one.
+	start := time.Now().Add(-time.Hour)
+	end := time.Now().Add(time.Hour)
+
+	total, err := CountUsers(ctx, logger, db)
+	if err != nil {
+		t.Fatalf("error counting users: %v", err.Error())
+	}
+	recent, err := CountUsersByCreateTime(ctx, logger, db, start, end)
+	if err != nil {
+		t.Fatalf("error counting users by create time: %v", err.Error())
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, _, _, err := AuthenticateCustom(ctx, logger, db, uuid.Must(uuid.NewV4()).String(), uuid.Must(uuid.NewV4()).String(), true); err != nil {
+			t.Fatalf("error creating user: %v", err.Error())
+		}
+	}
+
+	count, err := CountUsers(ctx, logger, db)
+	if err != nil {
+		t.Fatalf("error counting users: %v", err.Error())
+	}
+	assert.Equal(t, total+3, count)
+
+	count, err = CountUsersByCreateTime(ctx, logger, db, start, end)
+	if err != nil {
+		t.Fatalf("error counting users by create time: %v", err.Error())
+	}
+	assert.Equal(t, recent+3, count)
+
+	// Windows that do not include the new users, or the system user, do not count them.
+	count, err = CountUsersByCreateTime(ctx, logger, db, end, end.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("error counting users by create time: %v", err.Error())
+	}
+	assert.EqualValues(t, 0, count)
+	count, err = CountUsersByCreateTime(ctx, logger, db, time.Unix(0, 0), time.Unix(1, 0))
+	if err != nil {
+		t.Fatalf("error counting users by create time: %v", err.Error())
+	}
+	assert.EqualValues(t, 0, count)
+}
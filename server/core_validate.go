@@ -0,0 +1,50 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/mail"
+	"net/url"
+
+	"github.com/gofrs/uuid"
+)
+
+// ValidateEmail checks an email address is a bare address of at most 255 bytes, matching the email authentication pattern.
+func ValidateEmail(email string) bool {
+	if len(email) > 255 || invalidCharsRegex.MatchString(email) || !emailRegex.MatchString(email) {
+		return false
+	}
+	// Reject display names, comments and other forms that are not a plain address.
+	address, err := mail.ParseAddress(email)
+	return err == nil && address.Address == email
+}
+
+// ValidateUUID checks a string is a UUID in its canonical hyphenated form.
+func ValidateUUID(id string) bool {
+	if len(id) != 36 {
+		return false
+	}
+	_, err := uuid.FromString(id)
+	return err == nil
+}
+
+// ValidateURL checks a string is an absolute http or https URL with a host.
+func ValidateURL(rawURL string) bool {
+	u, err := url.ParseRequestURI(rawURL)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
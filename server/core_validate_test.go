@@ -0,0 +1,48 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateEmail(t *testing.T) {
+	for _, email := range []string{"player@example.com", "a@b.co", "first.last+tag@sub.example.org"} {
+		assert.True(t, ValidateEmail(email), email)
+	}
+	for _, email := range []string{"", "player@.com", "player.example.com", "player @example.com", "Player <player@example.com>", "player@example"} {
+		assert.False(t, ValidateEmail(email), email)
+	}
+}
+
+func TestValidateUUID(t *testing.T) {
+	for _, id := range []string{"9a51cf3a-2377-11eb-b713-e7d403afe081", "00000000-0000-0000-0000-000000000000"} {
+		assert.True(t, ValidateUUID(id), id)
+	}
+	for _, id := range []string{"", "not-a-uuid", "9a51cf3a237711ebb713e7d403afe081", "{9a51cf3a-2377-11eb-b713-e7d403afe081}", "9a51cf3a-2377-11eb-b713-e7d403afe08z"} {
+		assert.False(t, ValidateUUID(id), id)
+	}
+}
+
+func TestValidateURL(t *testing.T) {
+	for _, rawURL := range []string{"https://example.com", "http://example.com:8080/path?query=1"} {
+		assert.True(t, ValidateURL(rawURL), rawURL)
+	}
+	for _, rawURL := range []string{"", "example.com", "/relative/path", "ftp://example.com/file", "https://", "javascript:alert(1)"} {
+		assert.False(t, ValidateURL(rawURL), rawURL)
+	}
+}
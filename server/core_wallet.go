@@ -30,11 +30,26 @@ import (
 	"time"
 
 	"github.com/gofrs/uuid"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/heroiclabs/nakama-common/api"
 	"github.com/jackc/pgx/pgtype"
 	"go.uber.org/zap"
 )
 
-var ErrWalletLedgerInvalidCursor = errors.New("wallet ledger cursor invalid")
+const WalletUpdateEventName = "wallet_update"
+
+var (
+	ErrWalletLedgerInvalidCursor  = errors.New("wallet ledger cursor invalid")
+	ErrWalletBalanceInvalidCursor = errors.New("wallet balance cursor invalid")
+)
+
+type walletBalanceListCursor struct {
+	Currency string
+	Min      int64
+	Max      int64
+	Balance  int64
+	UserId   string
+}
 
 type walletLedgerListCursor struct {
 	UserId     string
@@ -48,6 +63,45 @@ type walletUpdate struct {
 	Changeset map[string]int64
 	// Metadata is expected to be a valid JSON string already.
 	Metadata string
+	// Optional wallet-level metadata, merged into any existing wallet metadata for the user.
+	WalletMetadata map[string]interface{}
+	// Optional bounds on the resulting balance of individual currencies.
+	Limits map[string]*WalletCurrencyLimit
+	// Set to the ID of the ledger item written for this update, if the ledger is updated.
+	LedgerID uuid.UUID
+}
+
+// WalletCurrencyLimit bounds the balance of a single wallet currency. A change that would move the balance past either
+// bound rejects the whole wallet update, or is clamped to the bound instead if Clamp is set. Balances already outside
+// the bounds are left as they are, only changes pushing them further out are affected.
+type WalletCurrencyLimit struct {
+	Min   *int64
+	Max   *int64
+	Clamp bool
+}
+
+// Apply returns the new balance for a currency after a change, honouring the limit, or false if it must be rejected.
+func (l *WalletCurrencyLimit) Apply(current, change int64) (int64, bool) {
+	value := current + change
+	if l.Max != nil && change > 0 && value > *l.Max {
+		if !l.Clamp {
+			return 0, false
+		}
+		value = *l.Max
+		if value < current {
+			value = current
+		}
+	}
+	if l.Min != nil && change < 0 && value < *l.Min {
+		if !l.Clamp {
+			return 0, false
+		}
+		value = *l.Min
+		if value > current {
+			value = current
+		}
+	}
+	return value, true
 }
 
 // Not an API entity, only used to send data to runtime environment.
@@ -84,6 +138,16 @@ func (w *walletLedger) GetMetadata() map[string]interface{} {
 	return w.Metadata
 }
 
+// Not an API entity, only used to send data to runtime environment.
+type WalletLedgerTotal struct {
+	// Sum of all positive changes.
+	Credit int64
+	// Sum of all negative changes, as a positive amount.
+	Debit int64
+	// Credit minus debit.
+	Net int64
+}
+
 func UpdateWallets(ctx context.Context, logger *zap.Logger, db *sql.DB, updates []*walletUpdate, updateLedger bool) ([]*runtime.WalletUpdateResult, error) {
 	if len(updates) == 0 {
 		return nil, nil
@@ -115,6 +179,98 @@ func UpdateWallets(ctx context.Context, logger *zap.Logger, db *sql.DB, updates
 	return results, nil
 }
 
+// UpdateWalletsPerUser applies each wallet update in its own transaction, so a failed update does not affect any other.
+// Results and errors are returned in the same order as the given updates, with a nil error for each successful update.
+func UpdateWalletsPerUser(ctx context.Context, logger *zap.Logger, db *sql.DB, updates []*walletUpdate, updateLedger bool) ([]*runtime.WalletUpdateResult, []error) {
+	results := make([]*runtime.WalletUpdateResult, 0, len(updates))
+	errs := make([]error, 0, len(updates))
+	for _, update := range updates {
+		updateResults, err := UpdateWallets(ctx, logger, db, []*walletUpdate{update}, updateLedger)
+		if len(updateResults) > 0 {
+			results = append(results, updateResults[0])
+		} else {
+			results = append(results, &runtime.WalletUpdateResult{UserID: update.UserID.String()})
+		}
+		errs = append(errs, err)
+	}
+	return results, errs
+}
+
+// EmitWalletUpdateEvents reports each applied wallet update as an event, if enabled in the runtime configuration.
+// Results must be in the order returned by UpdateWallets or UpdateWalletsPerUser for the same updates.
+func EmitWalletUpdateEvents(ctx context.Context, logger *zap.Logger, config Config, eventFn RuntimeEventCustomFunction, updates []*walletUpdate, results []*runtime.WalletUpdateResult) {
+	if eventFn == nil || !config.GetRuntime().WalletUpdateEvents {
+		return
+	}
+
+	ts := &timestamp.Timestamp{Seconds: time.Now().UTC().Unix()}
+	// Updates for unknown users produce no result, so pair updates and results in order.
+	var i int
+	for _, update := range updates {
+		if i >= len(results) {
+			return
+		}
+		result := results[i]
+		if result.UserID != update.UserID.String() {
+			continue
+		}
+		i++
+		if result.Updated == nil {
+			// Update was not applied.
+			continue
+		}
+
+		changeset, err := json.Marshal(update.Changeset)
+		if err != nil {
+			logger.Warn("Could not encode wallet update event changeset.", zap.String("user_id", result.UserID), zap.Error(err))
+			continue
+		}
+		updated, err := json.Marshal(result.Updated)
+		if err != nil {
+			logger.Warn("Could not encode wallet update event balances.", zap.String("user_id", result.UserID), zap.Error(err))
+			continue
+		}
+		previous, err := json.Marshal(result.Previous)
+		if err != nil {
+			logger.Warn("Could not encode wallet update event balances.", zap.String("user_id", result.UserID), zap.Error(err))
+			continue
+		}
+
+		eventFn(ctx, &api.Event{
+			Name: WalletUpdateEventName,
+			Properties: map[string]string{
+				"user_id":   result.UserID,
+				"changeset": string(changeset),
+				"updated":   string(updated),
+				"previous":  string(previous),
+			},
+			Timestamp: ts,
+		})
+	}
+}
+
+// WalletUpdateLedgerIDs returns the ID of the ledger item written for each result, or an empty string if there is none.
+// Results must be in the order returned by UpdateWallets or UpdateWalletsPerUser for the same updates.
+func WalletUpdateLedgerIDs(updates []*walletUpdate, results []*runtime.WalletUpdateResult) []string {
+	ids := make([]string, len(results))
+	// Updates for unknown users produce no result, so pair updates and results in order.
+	var i int
+	for _, update := range updates {
+		if i >= len(results) {
+			break
+		}
+		result := results[i]
+		if result.UserID != update.UserID.String() {
+			continue
+		}
+		if result.Updated != nil && update.LedgerID != uuid.Nil {
+			ids[i] = update.LedgerID.String()
+		}
+		i++
+	}
+	return ids
+}
+
 func updateWallets(ctx context.Context, logger *zap.Logger, tx *sql.Tx, updates []*walletUpdate, updateLedger bool) ([]*runtime.WalletUpdateResult, error) {
 	if len(updates) == 0 {
 		return nil, nil
@@ -127,10 +283,11 @@ func updateWallets(ctx context.Context, logger *zap.Logger, tx *sql.Tx, updates
 		initialStatements = append(initialStatements, "$"+strconv.Itoa(len(initialParams))+"::UUID")
 	}
 
-	initialQuery := "SELECT id, wallet FROM users WHERE id IN (" + strings.Join(initialStatements, ",") + ")"
+	initialQuery := "SELECT id, wallet, wallet_metadata FROM users WHERE id IN (" + strings.Join(initialStatements, ",") + ")"
 
 	// Select the wallets from the DB and decode them.
 	wallets := make(map[string]map[string]int64, len(updates))
+	walletMetadatas := make(map[string]map[string]interface{}, len(updates))
 	rows, err := tx.QueryContext(ctx, initialQuery, initialParams...)
 	if err != nil {
 		logger.Debug("Error retrieving user wallets.", zap.Error(err))
@@ -139,7 +296,8 @@ func updateWallets(ctx context.Context, logger *zap.Logger, tx *sql.Tx, updates
 	for rows.Next() {
 		var id string
 		var wallet sql.NullString
-		err = rows.Scan(&id, &wallet)
+		var walletMetadata sql.NullString
+		err = rows.Scan(&id, &wallet, &walletMetadata)
 		if err != nil {
 			_ = rows.Close()
 			logger.Debug("Error reading user wallets.", zap.Error(err))
@@ -154,7 +312,16 @@ func updateWallets(ctx context.Context, logger *zap.Logger, tx *sql.Tx, updates
 			return nil, err
 		}
 
+		var walletMetadataMap map[string]interface{}
+		err = json.Unmarshal([]byte(walletMetadata.String), &walletMetadataMap)
+		if err != nil {
+			_ = rows.Close()
+			logger.Debug("Error converting user wallet metadata.", zap.String("user_id", id), zap.Error(err))
+			return nil, err
+		}
+
 		wallets[id] = walletMap
+		walletMetadatas[id] = walletMetadataMap
 	}
 	_ = rows.Close()
 
@@ -162,6 +329,7 @@ func updateWallets(ctx context.Context, logger *zap.Logger, tx *sql.Tx, updates
 
 	// Prepare the set of wallet updates and ledger updates.
 	updatedWallets := make(map[string][]byte, len(updates))
+	updatedWalletMetadatas := make(map[string][]byte)
 	updateOrder := make([]string, 0, len(updates))
 	var statements []string
 	var params []interface{}
@@ -187,9 +355,31 @@ func updateWallets(ctx context.Context, logger *zap.Logger, tx *sql.Tx, updates
 		}
 		result := &runtime.WalletUpdateResult{UserID: userID, Previous: previousMap}
 
+		// Changeset actually applied, only differs from the requested one if a limit clamped a change.
+		changeset := update.Changeset
+		clamped := false
 		for k, v := range update.Changeset {
 			// Existing value may be 0 or missing.
 			newValue := walletMap[k] + v
+			if limit := update.Limits[k]; limit != nil {
+				var allowed bool
+				newValue, allowed = limit.Apply(walletMap[k], v)
+				if !allowed {
+					// Programmer error, no need to log.
+					changesetErr = fmt.Errorf("wallet update rejected value outside limits at path '%v'", k)
+					continue
+				}
+				if applied := newValue - walletMap[k]; applied != v {
+					if !clamped {
+						clamped = true
+						changeset = make(map[string]int64, len(update.Changeset))
+						for ck, cv := range update.Changeset {
+							changeset[ck] = cv
+						}
+					}
+					changeset[k] = applied
+				}
+			}
 			if newValue < 0 {
 				// Programmer error, no need to log.
 				changesetErr = fmt.Errorf("wallet update rejected negative value at path '%v'", k)
@@ -212,15 +402,36 @@ func updateWallets(ctx context.Context, logger *zap.Logger, tx *sql.Tx, updates
 		updatedWallets[userID] = walletData
 		updateOrder = append(updateOrder, userID)
 
+		if update.WalletMetadata != nil {
+			walletMetadataMap := walletMetadatas[userID]
+			if walletMetadataMap == nil {
+				walletMetadataMap = make(map[string]interface{}, len(update.WalletMetadata))
+				walletMetadatas[userID] = walletMetadataMap
+			}
+			for k, v := range update.WalletMetadata {
+				walletMetadataMap[k] = v
+			}
+			walletMetadataData, err := json.Marshal(walletMetadataMap)
+			if err != nil {
+				logger.Debug("Error converting new user wallet metadata.", zap.String("user_id", userID), zap.Error(err))
+				return nil, err
+			}
+			updatedWalletMetadatas[userID] = walletMetadataData
+		}
+
 		// Prepare ledger updates if needed.
 		if updateLedger {
-			changesetData, err := json.Marshal(update.Changeset)
+			changesetData, err := json.Marshal(changeset)
 			if err != nil {
 				logger.Debug("Error converting new user wallet changeset.", zap.String("user_id", update.UserID.String()), zap.Error(err))
 				return nil, err
 			}
 
-			params = append(params, uuid.Must(uuid.NewV4()), userID, changesetData, update.Metadata)
+			// Keep the same ID if the transaction is retried.
+			if update.LedgerID == uuid.Nil {
+				update.LedgerID = uuid.Must(uuid.NewV4())
+			}
+			params = append(params, update.LedgerID, userID, changesetData, update.Metadata)
 			statements = append(statements, fmt.Sprintf("($%v::UUID, $%v, $%v, $%v)", strconv.Itoa(len(params)-3), strconv.Itoa(len(params)-2), strconv.Itoa(len(params)-1), strconv.Itoa(len(params))))
 		}
 	}
@@ -240,11 +451,19 @@ func updateWallets(ctx context.Context, logger *zap.Logger, tx *sql.Tx, updates
 				logger.Warn("Missing wallet update for user.", zap.String("user_id", userID))
 				continue
 			}
-			_, err = tx.ExecContext(ctx, "UPDATE users SET update_time = now(), wallet = $2 WHERE id = $1", userID, updatedWallet)
+			if updatedWalletMetadata, ok := updatedWalletMetadatas[userID]; ok {
+				_, err = tx.ExecContext(ctx, "UPDATE users SET update_time = now(), wallet = $2, wallet_metadata = $3 WHERE id = $1", userID, updatedWallet, updatedWalletMetadata)
+			} else {
+				_, err = tx.ExecContext(ctx, "UPDATE users SET update_time = now(), wallet = $2 WHERE id = $1", userID, updatedWallet)
+			}
 			if err != nil {
 				logger.Debug("Error writing user wallet.", zap.String("user_id", userID), zap.Error(err))
 				return nil, err
 			}
+			if err = writeWalletBalances(ctx, tx, userID, wallets[userID]); err != nil {
+				logger.Debug("Error writing user wallet balances.", zap.String("user_id", userID), zap.Error(err))
+				return nil, err
+			}
 		}
 
 		// Write the ledger updates, if any.
@@ -260,6 +479,25 @@ func updateWallets(ctx context.Context, logger *zap.Logger, tx *sql.Tx, updates
 	return results, nil
 }
 
+func GetWalletMetadata(ctx context.Context, logger *zap.Logger, db *sql.DB, userID uuid.UUID) (map[string]interface{}, error) {
+	var walletMetadata sql.NullString
+	if err := db.QueryRowContext(ctx, "SELECT wallet_metadata FROM users WHERE id = $1", userID).Scan(&walletMetadata); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrAccountNotFound
+		}
+		logger.Error("Error retrieving user wallet metadata.", zap.Error(err))
+		return nil, err
+	}
+
+	var walletMetadataMap map[string]interface{}
+	if err := json.Unmarshal([]byte(walletMetadata.String), &walletMetadataMap); err != nil {
+		logger.Error("Error converting user wallet metadata.", zap.String("user_id", userID.String()), zap.Error(err))
+		return nil, err
+	}
+
+	return walletMetadataMap, nil
+}
+
 func UpdateWalletLedger(ctx context.Context, logger *zap.Logger, db *sql.DB, id uuid.UUID, metadata string) (*walletLedger, error) {
 	// Metadata is expected to already be a valid JSON string.
 	var userID string
@@ -316,7 +554,7 @@ func ListWalletLedger(ctx context.Context, logger *zap.Logger, db *sql.DB, userI
 	} else {
 		query += " AND (user_id, create_time, id) < ($1::UUID, now(), '00000000-0000-0000-0000-000000000000'::UUID)"
 	}
-	query += " ORDER BY create_time DESC"
+	query += " ORDER BY create_time DESC, id DESC"
 	if limit != nil {
 		params = append(params, *limit+1)
 		query += " LIMIT $" + strconv.Itoa(len(params))
@@ -384,3 +622,162 @@ func ListWalletLedger(ctx context.Context, logger *zap.Logger, db *sql.DB, userI
 
 	return results, outgoingCursorStr, nil
 }
+
+// WalletLedgerTotals sums the changes recorded in a user's wallet ledger per currency, for entries created within the
+// given range. A zero start or end time leaves that side of the range unbounded, the end time is exclusive.
+func WalletLedgerTotals(ctx context.Context, logger *zap.Logger, db *sql.DB, userID uuid.UUID, startTime, endTime time.Time) (map[string]*WalletLedgerTotal, error) {
+	params := []interface{}{userID}
+	query := "SELECT changeset FROM wallet_ledger WHERE user_id = $1::UUID"
+	if !startTime.IsZero() {
+		params = append(params, startTime)
+		query += " AND create_time >= $" + strconv.Itoa(len(params))
+	}
+	if !endTime.IsZero() {
+		params = append(params, endTime)
+		query += " AND create_time < $" + strconv.Itoa(len(params))
+	}
+	rows, err := db.QueryContext(ctx, query, params...)
+	if err != nil {
+		logger.Error("Error retrieving user wallet ledger totals.", zap.String("user_id", userID.String()), zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := make(map[string]*WalletLedgerTotal)
+	var changeset sql.NullString
+	for rows.Next() {
+		if err := rows.Scan(&changeset); err != nil {
+			logger.Error("Error converting user wallet ledger.", zap.String("user_id", userID.String()), zap.Error(err))
+			return nil, err
+		}
+
+		var changesetMap map[string]int64
+		if err := json.Unmarshal([]byte(changeset.String), &changesetMap); err != nil {
+			logger.Error("Error converting user wallet ledger changeset.", zap.String("user_id", userID.String()), zap.Error(err))
+			return nil, err
+		}
+
+		for currency, change := range changesetMap {
+			total, found := totals[currency]
+			if !found {
+				total = &WalletLedgerTotal{}
+				totals[currency] = total
+			}
+			if change > 0 {
+				total.Credit += change
+			} else {
+				total.Debit -= change
+			}
+			total.Net += change
+		}
+	}
+	if err := rows.Err(); err != nil {
+		logger.Error("Error retrieving user wallet ledger totals.", zap.String("user_id", userID.String()), zap.Error(err))
+		return nil, err
+	}
+
+	return totals, nil
+}
+
+// Replace the indexed balances of a user's wallet, keeping lookups by currency balance in step with the wallet itself.
+func writeWalletBalances(ctx context.Context, tx *sql.Tx, userID string, wallet map[string]int64) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM user_wallet_balance WHERE user_id = $1::UUID", userID); err != nil {
+		return err
+	}
+	if len(wallet) == 0 {
+		return nil
+	}
+
+	params := make([]interface{}, 0, 1+len(wallet)*2)
+	params = append(params, userID)
+	statements := make([]string, 0, len(wallet))
+	for currency, balance := range wallet {
+		params = append(params, currency, balance)
+		statements = append(statements, fmt.Sprintf("($1::UUID, $%v, $%v)", len(params)-1, len(params)))
+	}
+	_, err := tx.ExecContext(ctx, "INSERT INTO user_wallet_balance (user_id, currency, balance) VALUES "+strings.Join(statements, ", "), params...)
+	return err
+}
+
+// GetUsersByWalletBalance returns IDs of users whose balance of a currency is between min and max inclusive, in order
+// of balance then user ID. Balances are read from their own index rather than from the wallets, so each page is a
+// single bounded range scan. A cursor is returned while more users remain.
+func GetUsersByWalletBalance(ctx context.Context, logger *zap.Logger, db *sql.DB, currency string, min, max int64, limit int, cursor string) ([]string, string, error) {
+	var incomingCursor *walletBalanceListCursor
+	if cursor != "" {
+		cb, err := base64.StdEncoding.DecodeString(cursor)
+		if err != nil {
+			return nil, "", ErrWalletBalanceInvalidCursor
+		}
+		incomingCursor = &walletBalanceListCursor{}
+		if err := gob.NewDecoder(bytes.NewReader(cb)).Decode(incomingCursor); err != nil {
+			return nil, "", ErrWalletBalanceInvalidCursor
+		}
+
+		// Cursor and filter mismatch. Perhaps the caller has sent an old cursor with a changed filter.
+		if currency != incomingCursor.Currency || min != incomingCursor.Min || max != incomingCursor.Max {
+			return nil, "", ErrWalletBalanceInvalidCursor
+		}
+	}
+
+	query := `
+SELECT user_id, balance
+FROM user_wallet_balance
+WHERE currency = $1 AND balance BETWEEN $2 AND $3`
+	params := []interface{}{currency, min, max, limit + 1}
+	if incomingCursor != nil {
+		query += " AND (balance, user_id) > ($5, $6::UUID)"
+		params = append(params, incomingCursor.Balance, incomingCursor.UserId)
+	}
+	query += `
+ORDER BY balance, user_id
+LIMIT $4`
+	rows, err := db.QueryContext(ctx, query, params...)
+	if err != nil {
+		logger.Error("Error retrieving users by wallet balance.", zap.String("currency", currency), zap.Error(err))
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	userIDs := make([]string, 0, limit)
+	var outgoingCursor *walletBalanceListCursor
+	var more bool
+	for rows.Next() {
+		var userID string
+		var balance int64
+		if err := rows.Scan(&userID, &balance); err != nil {
+			logger.Error("Error retrieving users by wallet balance.", zap.String("currency", currency), zap.Error(err))
+			return nil, "", err
+		}
+
+		if len(userIDs) >= limit {
+			// There is at least one more user after this page.
+			more = true
+			break
+		}
+		userIDs = append(userIDs, userID)
+		outgoingCursor = &walletBalanceListCursor{
+			Currency: currency,
+			Min:      min,
+			Max:      max,
+			Balance:  balance,
+			UserId:   userID,
+		}
+	}
+	if err := rows.Err(); err != nil {
+		logger.Error("Error retrieving users by wallet balance.", zap.String("currency", currency), zap.Error(err))
+		return nil, "", err
+	}
+
+	var outgoingCursorStr string
+	if more {
+		cursorBuf := new(bytes.Buffer)
+		if err := gob.NewEncoder(cursorBuf).Encode(outgoingCursor); err != nil {
+			logger.Error("Error creating wallet balance list cursor", zap.Error(err))
+			return nil, "", err
+		}
+		outgoingCursorStr = base64.StdEncoding.EncodeToString(cursorBuf.Bytes())
+	}
+
+	return userIDs, outgoingCursorStr, nil
+}
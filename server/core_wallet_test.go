@@ -18,8 +18,10 @@ import (
 	"context"
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/gofrs/uuid"
+	"github.com/heroiclabs/nakama-common/api"
 	"github.com/heroiclabs/nakama-common/runtime"
 	"github.com/stretchr/testify/assert"
 )
@@ -70,7 +72,7 @@ func TestUpdateWalletSingleUser(t *testing.T) {
 	}
 
 	db := NewDB(t)
-	nk := NewRuntimeGoNakamaModule(logger, db, nil, cfg, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	nk := NewRuntimeGoNakamaModule(logger, db, nil, cfg, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
 
 	userID, _, _, err := AuthenticateCustom(context.Background(), logger, db, uuid.Must(uuid.NewV4()).String(), uuid.Must(uuid.NewV4()).String(), true)
 	if err != nil {
@@ -148,7 +150,7 @@ func TestUpdateWalletMultiUser(t *testing.T) {
 	}
 
 	db := NewDB(t)
-	nk := NewRuntimeGoNakamaModule(logger, db, nil, cfg, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	nk := NewRuntimeGoNakamaModule(logger, db, nil, cfg, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
 	count := 5
 
 	userIDs := make([]string, 0, count)
@@ -235,7 +237,7 @@ func TestUpdateWalletsMultiUser(t *testing.T) {
 	}
 
 	db := NewDB(t)
-	nk := NewRuntimeGoNakamaModule(logger, db, nil, cfg, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	nk := NewRuntimeGoNakamaModule(logger, db, nil, cfg, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
 	count := 5
 
 	userIDs := make([]string, 0, count)
@@ -327,7 +329,7 @@ func TestUpdateWalletsMultiUserSharedChangeset(t *testing.T) {
 	}
 
 	db := NewDB(t)
-	nk := NewRuntimeGoNakamaModule(logger, db, nil, cfg, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	nk := NewRuntimeGoNakamaModule(logger, db, nil, cfg, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
 	count := 5
 
 	userIDs := make([]string, 0, count)
@@ -423,7 +425,7 @@ func TestUpdateWalletsMultiUserSharedChangesetDeductions(t *testing.T) {
 	}
 
 	db := NewDB(t)
-	nk := NewRuntimeGoNakamaModule(logger, db, nil, cfg, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	nk := NewRuntimeGoNakamaModule(logger, db, nil, cfg, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
 	count := 5
 
 	userIDs := make([]string, 0, count)
@@ -478,7 +480,7 @@ func TestUpdateWalletsMultiUserSharedChangesetDeductions(t *testing.T) {
 
 func TestUpdateWalletsSingleUser(t *testing.T) {
 	db := NewDB(t)
-	nk := NewRuntimeGoNakamaModule(logger, db, nil, cfg, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	nk := NewRuntimeGoNakamaModule(logger, db, nil, cfg, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
 
 	userID, _, _, err := AuthenticateCustom(context.Background(), logger, db, uuid.Must(uuid.NewV4()).String(), uuid.Must(uuid.NewV4()).String(), true)
 	if err != nil {
@@ -525,7 +527,7 @@ func TestUpdateWalletsSingleUser(t *testing.T) {
 
 func TestUpdateWalletRepeatedSingleUser(t *testing.T) {
 	db := NewDB(t)
-	nk := NewRuntimeGoNakamaModule(logger, db, nil, cfg, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	nk := NewRuntimeGoNakamaModule(logger, db, nil, cfg, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
 
 	userID, _, _, err := AuthenticateCustom(context.Background(), logger, db, uuid.Must(uuid.NewV4()).String(), uuid.Must(uuid.NewV4()).String(), true)
 	if err != nil {
@@ -562,3 +564,426 @@ func TestUpdateWalletRepeatedSingleUser(t *testing.T) {
 	assert.IsType(t, float64(0), wallet["value"], "wallet value was not float64")
 	assert.Equal(t, float64(6), wallet["value"].(float64), "wallet value did not match")
 }
+
+func TestUpdateWalletsAtomicFailure(t *testing.T) {
+	db := NewDB(t)
+
+	userID1, _, _, err := AuthenticateCustom(context.Background(), logger, db, uuid.Must(uuid.NewV4()).String(), uuid.Must(uuid.NewV4()).String(), true)
+	if err != nil {
+		t.Fatalf("error creating user: %v", err.Error())
+	}
+	userID2, _, _, err := AuthenticateCustom(context.Background(), logger, db, uuid.Must(uuid.NewV4()).String(), uuid.Must(uuid.NewV4()).String(), true)
+	if err != nil {
+		t.Fatalf("error creating user: %v", err.Error())
+	}
+
+	updates := []*walletUpdate{
+		{
+			UserID:    uuid.FromStringOrNil(userID1),
+			Changeset: map[string]int64{"value": 10},
+			Metadata:  "{}",
+		},
+		{
+			// Would result in a negative balance.
+			UserID:    uuid.FromStringOrNil(userID2),
+			Changeset: map[string]int64{"value": -10},
+			Metadata:  "{}",
+		},
+	}
+
+	_, err = UpdateWallets(context.Background(), logger, db, updates, true)
+	assert.NotNil(t, err, "expected wallet update error")
+
+	// Neither wallet is changed when the batch fails.
+	for _, userID := range []string{userID1, userID2} {
+		account, err := GetAccount(context.Background(), logger, db, nil, uuid.FromStringOrNil(userID))
+		if err != nil {
+			t.Fatalf("error getting user: %v", err.Error())
+		}
+
+		var wallet map[string]interface{}
+		err = json.Unmarshal([]byte(account.Wallet), &wallet)
+		if err != nil {
+			t.Fatalf("json unmarshal error: %v", err.Error())
+		}
+		assert.NotContains(t, wallet, "value", "wallet should not contain value")
+	}
+}
+
+func TestUpdateWalletsPerUserFailure(t *testing.T) {
+	db := NewDB(t)
+
+	userID1, _, _, err := AuthenticateCustom(context.Background(), logger, db, uuid.Must(uuid.NewV4()).String(), uuid.Must(uuid.NewV4()).String(), true)
+	if err != nil {
+		t.Fatalf("error creating user: %v", err.Error())
+	}
+	userID2, _, _, err := AuthenticateCustom(context.Background(), logger, db, uuid.Must(uuid.NewV4()).String(), uuid.Must(uuid.NewV4()).String(), true)
+	if err != nil {
+		t.Fatalf("error creating user: %v", err.Error())
+	}
+
+	updates := []*walletUpdate{
+		{
+			UserID:    uuid.FromStringOrNil(userID1),
+			Changeset: map[string]int64{"value": 10},
+			Metadata:  "{}",
+		},
+		{
+			// Would result in a negative balance.
+			UserID:    uuid.FromStringOrNil(userID2),
+			Changeset: map[string]int64{"value": -10},
+			Metadata:  "{}",
+		},
+	}
+
+	results, errs := UpdateWalletsPerUser(context.Background(), logger, db, updates, true)
+	assert.Len(t, results, 2)
+	assert.Len(t, errs, 2)
+
+	assert.Nil(t, errs[0], "expected first update to succeed")
+	assert.Equal(t, userID1, results[0].UserID)
+	assert.Equal(t, int64(10), results[0].Updated["value"])
+
+	assert.NotNil(t, errs[1], "expected second update to fail")
+	assert.Equal(t, userID2, results[1].UserID)
+	assert.Nil(t, results[1].Updated)
+
+	account, err := GetAccount(context.Background(), logger, db, nil, uuid.FromStringOrNil(userID1))
+	if err != nil {
+		t.Fatalf("error getting user: %v", err.Error())
+	}
+
+	var wallet map[string]interface{}
+	err = json.Unmarshal([]byte(account.Wallet), &wallet)
+	if err != nil {
+		t.Fatalf("json unmarshal error: %v", err.Error())
+	}
+	assert.Equal(t, float64(10), wallet["value"].(float64), "wallet value did not match")
+}
+
+func TestUpdateWalletsWalletMetadata(t *testing.T) {
+	db := NewDB(t)
+
+	userID, _, _, err := AuthenticateCustom(context.Background(), logger, db, uuid.Must(uuid.NewV4()).String(), uuid.Must(uuid.NewV4()).String(), true)
+	if err != nil {
+		t.Fatalf("error creating user: %v", err.Error())
+	}
+	uid := uuid.FromStringOrNil(userID)
+
+	walletMetadata, err := GetWalletMetadata(context.Background(), logger, db, uid)
+	if err != nil {
+		t.Fatalf("error getting wallet metadata: %v", err.Error())
+	}
+	assert.Empty(t, walletMetadata, "new wallet should have no metadata")
+
+	_, err = UpdateWallets(context.Background(), logger, db, []*walletUpdate{{
+		UserID:         uid,
+		Changeset:      map[string]int64{"value": 10},
+		Metadata:       "{}",
+		WalletMetadata: map[string]interface{}{"tier": "gold", "since": "2020"},
+	}}, true)
+	if err != nil {
+		t.Fatalf("error updating wallet: %v", err.Error())
+	}
+
+	// Later updates merge into existing wallet metadata, and updates without wallet metadata leave it unchanged.
+	_, err = UpdateWallets(context.Background(), logger, db, []*walletUpdate{{
+		UserID:         uid,
+		Changeset:      map[string]int64{"value": 5},
+		Metadata:       "{}",
+		WalletMetadata: map[string]interface{}{"tier": "vip"},
+	}}, true)
+	if err != nil {
+		t.Fatalf("error updating wallet: %v", err.Error())
+	}
+	_, err = UpdateWallets(context.Background(), logger, db, []*walletUpdate{{
+		UserID:    uid,
+		Changeset: map[string]int64{"value": 1},
+		Metadata:  "{}",
+	}}, true)
+	if err != nil {
+		t.Fatalf("error updating wallet: %v", err.Error())
+	}
+
+	walletMetadata, err = GetWalletMetadata(context.Background(), logger, db, uid)
+	if err != nil {
+		t.Fatalf("error getting wallet metadata: %v", err.Error())
+	}
+	assert.Equal(t, map[string]interface{}{"tier": "vip", "since": "2020"}, walletMetadata)
+
+	_, err = GetWalletMetadata(context.Background(), logger, db, uuid.Must(uuid.NewV4()))
+	assert.Equal(t, ErrAccountNotFound, err)
+}
+
+func TestUpdateWalletsLimits(t *testing.T) {
+	db := NewDB(t)
+
+	userID, _, _, err := AuthenticateCustom(context.Background(), logger, db, uuid.Must(uuid.NewV4()).String(), uuid.Must(uuid.NewV4()).String(), true)
+	if err != nil {
+		t.Fatalf("error creating user: %v", err.Error())
+	}
+	uid := uuid.FromStringOrNil(userID)
+
+	max := int64(100)
+	_, err = UpdateWallets(context.Background(), logger, db, []*walletUpdate{{
+		UserID:    uid,
+		Changeset: map[string]int64{"gems": 90, "coins": 500},
+		Metadata:  "{}",
+	}}, true)
+	if err != nil {
+		t.Fatalf("error updating wallet: %v", err.Error())
+	}
+
+	// A clamped credit stops at the cap, and the ledger records the change actually applied.
+	results, err := UpdateWallets(context.Background(), logger, db, []*walletUpdate{{
+		UserID:    uid,
+		Changeset: map[string]int64{"gems": 50, "coins": 50},
+		Metadata:  "{}",
+		Limits:    map[string]*WalletCurrencyLimit{"gems": {Max: &max, Clamp: true}},
+	}}, true)
+	if err != nil {
+		t.Fatalf("error updating wallet: %v", err.Error())
+	}
+	assert.Equal(t, map[string]int64{"gems": 100, "coins": 550}, results[0].Updated)
+
+	items, _, err := ListWalletLedger(context.Background(), logger, db, uid, nil, "")
+	if err != nil {
+		t.Fatalf("error listing wallet ledger: %v", err.Error())
+	}
+	changesets := make([]map[string]int64, 0, len(items))
+	for _, item := range items {
+		changesets = append(changesets, item.Changeset)
+	}
+	assert.Contains(t, changesets, map[string]int64{"gems": 10, "coins": 50})
+
+	// A rejected over-cap credit fails the whole update and leaves every currency unchanged.
+	_, err = UpdateWallets(context.Background(), logger, db, []*walletUpdate{{
+		UserID:    uid,
+		Changeset: map[string]int64{"gems": 1, "coins": 50},
+		Metadata:  "{}",
+		Limits:    map[string]*WalletCurrencyLimit{"gems": {Max: &max}},
+	}}, true)
+	assert.EqualError(t, err, "wallet update rejected value outside limits at path 'gems'")
+
+	results, err = UpdateWallets(context.Background(), logger, db, []*walletUpdate{{
+		UserID:    uid,
+		Changeset: map[string]int64{},
+		Metadata:  "{}",
+	}}, false)
+	if err != nil {
+		t.Fatalf("error updating wallet: %v", err.Error())
+	}
+	assert.Equal(t, map[string]int64{"gems": 100, "coins": 550}, results[0].Updated)
+}
+
+func TestUpdateWalletsLedgerIDs(t *testing.T) {
+	db := NewDB(t)
+
+	userID, _, _, err := AuthenticateCustom(context.Background(), logger, db, uuid.Must(uuid.NewV4()).String(), uuid.Must(uuid.NewV4()).String(), true)
+	if err != nil {
+		t.Fatalf("error creating user: %v", err.Error())
+	}
+	uid := uuid.FromStringOrNil(userID)
+
+	updates := []*walletUpdate{
+		{UserID: uid, Changeset: map[string]int64{"coins": 10}, Metadata: "{}"},
+		// Updates for unknown users produce no result.
+		{UserID: uuid.Must(uuid.NewV4()), Changeset: map[string]int64{"coins": 10}, Metadata: "{}"},
+		{UserID: uid, Changeset: map[string]int64{"coins": 5}, Metadata: "{}"},
+	}
+	results, err := UpdateWallets(context.Background(), logger, db, updates, true)
+	if err != nil {
+		t.Fatalf("error updating wallet: %v", err.Error())
+	}
+	ledgerIDs := WalletUpdateLedgerIDs(updates, results)
+	assert.Len(t, ledgerIDs, 2)
+
+	items, _, err := ListWalletLedger(context.Background(), logger, db, uid, nil, "")
+	if err != nil {
+		t.Fatalf("error listing wallet ledger: %v", err.Error())
+	}
+	changesets := make(map[string]map[string]int64, len(items))
+	for _, item := range items {
+		changesets[item.ID] = item.Changeset
+	}
+	assert.Equal(t, map[string]map[string]int64{
+		ledgerIDs[0]: {"coins": 10},
+		ledgerIDs[1]: {"coins": 5},
+	}, changesets)
+
+	// No ledger item is written, so none is reported.
+	updates = []*walletUpdate{{UserID: uid, Changeset: map[string]int64{"coins": 1}, Metadata: "{}"}}
+	results, err = UpdateWallets(context.Background(), logger, db, updates, false)
+	if err != nil {
+		t.Fatalf("error updating wallet: %v", err.Error())
+	}
+	assert.Equal(t, []string{""}, WalletUpdateLedgerIDs(updates, results))
+}
+
+func TestWalletCurrencyLimitApply(t *testing.T) {
+	min, max := int64(10), int64(100)
+	reject := &WalletCurrencyLimit{Min: &min, Max: &max}
+	clamp := &WalletCurrencyLimit{Min: &min, Max: &max, Clamp: true}
+
+	value, ok := reject.Apply(50, 50)
+	assert.True(t, ok)
+	assert.Equal(t, int64(100), value)
+	_, ok = reject.Apply(50, 51)
+	assert.False(t, ok)
+	_, ok = reject.Apply(50, -41)
+	assert.False(t, ok)
+
+	value, ok = clamp.Apply(50, 51)
+	assert.True(t, ok)
+	assert.Equal(t, int64(100), value)
+	value, ok = clamp.Apply(50, -41)
+	assert.True(t, ok)
+	assert.Equal(t, int64(10), value)
+
+	// Balances already outside the bounds may move back towards them, but never further out.
+	value, ok = reject.Apply(150, -10)
+	assert.True(t, ok)
+	assert.Equal(t, int64(140), value)
+	value, ok = clamp.Apply(150, 10)
+	assert.True(t, ok)
+	assert.Equal(t, int64(150), value)
+}
+
+func TestEmitWalletUpdateEvents(t *testing.T) {
+	userID := uuid.Must(uuid.NewV4())
+	unknownUserID := uuid.Must(uuid.NewV4())
+	updates := []*walletUpdate{
+		{UserID: unknownUserID, Changeset: map[string]int64{"coins": 1}},
+		{UserID: userID, Changeset: map[string]int64{"coins": 10, "gems": -1}},
+	}
+	// No result for the unknown user, as returned by UpdateWallets.
+	results := []*runtime.WalletUpdateResult{
+		{UserID: userID.String(), Updated: map[string]int64{"coins": 15, "gems": 0}, Previous: map[string]int64{"coins": 5, "gems": 1}},
+	}
+
+	var events []*api.Event
+	eventFn := func(ctx context.Context, evt *api.Event) {
+		events = append(events, evt)
+	}
+
+	config := NewConfig(logger)
+	EmitWalletUpdateEvents(context.Background(), logger, config, eventFn, updates, results)
+	assert.Empty(t, events, "events should not fire when disabled")
+
+	config.GetRuntime().WalletUpdateEvents = true
+	EmitWalletUpdateEvents(context.Background(), logger, config, eventFn, updates, results)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, WalletUpdateEventName, events[0].Name)
+		assert.NotNil(t, events[0].Timestamp)
+		assert.Equal(t, userID.String(), events[0].Properties["user_id"])
+		assert.JSONEq(t, `{"coins":10,"gems":-1}`, events[0].Properties["changeset"])
+		assert.JSONEq(t, `{"coins":15,"gems":0}`, events[0].Properties["updated"])
+		assert.JSONEq(t, `{"coins":5,"gems":1}`, events[0].Properties["previous"])
+	}
+
+	// Updates that were not applied do not fire events.
+	events = nil
+	EmitWalletUpdateEvents(context.Background(), logger, config, eventFn, updates[1:], []*runtime.WalletUpdateResult{{UserID: userID.String()}})
+	assert.Empty(t, events)
+}
+
+func TestWalletLedgerTotals(t *testing.T) {
+	db := NewDB(t)
+
+	userID, _, _, err := AuthenticateCustom(context.Background(), logger, db, uuid.Must(uuid.NewV4()).String(), uuid.Must(uuid.NewV4()).String(), true)
+	if err != nil {
+		t.Fatalf("error creating user: %v", err.Error())
+	}
+	uid := uuid.FromStringOrNil(userID)
+
+	changesets := []map[string]int64{
+		{"coins": 100, "gems": 10},
+		{"coins": -30},
+		{"coins": 20, "gems": -4},
+		{"coins": -50, "tokens": 1},
+	}
+	for _, changeset := range changesets {
+		_, err = UpdateWallets(context.Background(), logger, db, []*walletUpdate{{
+			UserID:    uid,
+			Changeset: changeset,
+			Metadata:  "{}",
+		}}, true)
+		if err != nil {
+			t.Fatalf("error updating wallet: %v", err.Error())
+		}
+	}
+
+	totals, err := WalletLedgerTotals(context.Background(), logger, db, uid, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("error getting wallet ledger totals: %v", err.Error())
+	}
+	assert.Equal(t, map[string]*WalletLedgerTotal{
+		"coins":  {Credit: 120, Debit: 80, Net: 40},
+		"gems":   {Credit: 10, Debit: 4, Net: 6},
+		"tokens": {Credit: 1, Debit: 0, Net: 1},
+	}, totals)
+
+	// Entries outside the range are not counted.
+	totals, err = WalletLedgerTotals(context.Background(), logger, db, uid, time.Now().Add(time.Hour), time.Time{})
+	if err != nil {
+		t.Fatalf("error getting wallet ledger totals: %v", err.Error())
+	}
+	assert.Empty(t, totals)
+}
+
+func TestGetUsersByWalletBalance(t *testing.T) {
+	db := NewDB(t)
+	defer db.Close()
+	nk := NewRuntimeGoNakamaModule(logger, db, nil, cfg, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+
+	// A currency unique to this test keeps users from other tests out of the results.
+	currency := "coins" + uuid.Must(uuid.NewV4()).String()
+	balances := []int64{5, 10, 50, 100, 500}
+	matched := make([]string, 0, 3)
+	for _, balance := range balances {
+		userID, _, _, err := AuthenticateCustom(context.Background(), logger, db, uuid.Must(uuid.NewV4()).String(), uuid.Must(uuid.NewV4()).String(), true)
+		if err != nil {
+			t.Fatalf("error creating user: %v", err.Error())
+		}
+		if _, _, err := nk.WalletUpdate(context.Background(), userID, map[string]int64{currency: balance}, nil, false); err != nil {
+			t.Fatalf("error updating wallet: %v", err.Error())
+		}
+		if balance >= 10 && balance <= 100 {
+			matched = append(matched, userID)
+		}
+	}
+
+	// Page through all users two at a time, in order of balance.
+	list := func() []string {
+		userIDs := make([]string, 0, len(matched))
+		var cursor string
+		for {
+			page, nextCursor, err := GetUsersByWalletBalance(context.Background(), logger, db, currency, 10, 100, 2, cursor)
+			if err != nil {
+				t.Fatalf("error getting users by wallet balance: %v", err.Error())
+			}
+			assert.True(t, len(page) <= 2, "page exceeded limit")
+			userIDs = append(userIDs, page...)
+			if nextCursor == "" {
+				break
+			}
+			cursor = nextCursor
+		}
+		return userIDs
+	}
+	assert.Equal(t, matched, list())
+
+	// Balance changes are reflected in the results.
+	if _, _, err := nk.WalletUpdate(context.Background(), matched[0], map[string]int64{currency: 1000}, nil, false); err != nil {
+		t.Fatalf("error updating wallet: %v", err.Error())
+	}
+	assert.Equal(t, matched[1:], list())
+
+	// Cursors only continue the query they were issued for.
+	_, nextCursor, err := GetUsersByWalletBalance(context.Background(), logger, db, currency, 10, 100, 1, "")
+	if err != nil {
+		t.Fatalf("error getting users by wallet balance: %v", err.Error())
+	}
+	_, _, err = GetUsersByWalletBalance(context.Background(), logger, db, currency, 0, 100, 1, nextCursor)
+	assert.Equal(t, ErrWalletBalanceInvalidCursor, err)
+}
@@ -17,7 +17,10 @@ package server
 import (
 	"context"
 	"database/sql"
+	"time"
+
 	"github.com/jackc/pgx"
+	"go.uber.org/zap"
 )
 
 // Tx is used to permit clients to implement custom transaction logic.
@@ -44,6 +47,23 @@ func ExecuteRetryable(fn func() error) error {
 	return nil
 }
 
+// Retry functions that perform non-transactional database operations, and report the query if it's slower than the
+// given threshold. A threshold of 0 disables slow query reporting. Only the number of query arguments is logged, never
+// their values.
+func ExecuteRetryableReportSlow(logger *zap.Logger, metrics *Metrics, threshold time.Duration, query string, argCount int, fn func() error) error {
+	if threshold <= 0 {
+		return ExecuteRetryable(fn)
+	}
+
+	start := time.Now()
+	err := ExecuteRetryable(fn)
+	if elapsed := time.Since(start); elapsed > threshold {
+		logger.Warn("Slow query", zap.String("query", query), zap.Int("arg_count", argCount), zap.Duration("duration", elapsed))
+		metrics.CountSlowQueries(1)
+	}
+	return err
+}
+
 // ExecuteInTx runs fn inside tx which should already have begun.
 // *WARNING*: Do not execute any statements on the supplied tx before calling this function.
 // ExecuteInTx will only retry statements that are performed within the supplied
@@ -22,7 +22,8 @@ import (
 )
 
 const (
-	dbErrorUniqueViolation = "23505"
+	dbErrorUniqueViolation        = "23505"
+	dbErrorNumericValueOutOfRange = "22003"
 )
 
 var ErrRowsAffectedCount = errors.New("rows_affected_count")
@@ -0,0 +1,73 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestExecuteRetryableReportSlow(t *testing.T) {
+	core, logs := observer.New(zap.WarnLevel)
+	slowLogger := zap.New(core)
+
+	// Slow queries take well over the threshold they're checked against.
+	threshold := 10 * time.Millisecond
+	slowDuration := 5 * threshold
+	slowQuery := func() error {
+		time.Sleep(slowDuration)
+		return nil
+	}
+	fastQuery := func() error {
+		return nil
+	}
+
+	// Queries under the threshold are not reported.
+	err := ExecuteRetryableReportSlow(slowLogger, metrics, time.Second, "SELECT 1", 0, fastQuery)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, logs.Len())
+
+	// A threshold of 0 disables reporting, regardless of query duration.
+	err = ExecuteRetryableReportSlow(slowLogger, metrics, 0, "SELECT 1", 0, slowQuery)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, logs.Len())
+
+	// Queries over the threshold are reported with their text, argument count, and duration.
+	err = ExecuteRetryableReportSlow(slowLogger, metrics, threshold, "SELECT * FROM users WHERE id = $1 AND username = $2", 2, slowQuery)
+	assert.NoError(t, err)
+	entries := logs.TakeAll()
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, zap.WarnLevel, entries[0].Level)
+		assert.Equal(t, "Slow query", entries[0].Message)
+		fields := entries[0].ContextMap()
+		assert.Equal(t, "SELECT * FROM users WHERE id = $1 AND username = $2", fields["query"])
+		assert.EqualValues(t, 2, fields["arg_count"])
+		assert.True(t, fields["duration"].(time.Duration) >= slowDuration)
+	}
+
+	// Query errors are still reported to the caller.
+	queryErr := errors.New("query failed")
+	err = ExecuteRetryableReportSlow(slowLogger, metrics, threshold, "SELECT 1", 0, func() error {
+		time.Sleep(slowDuration)
+		return queryErr
+	})
+	assert.Equal(t, queryErr, err)
+	assert.Equal(t, 1, logs.Len())
+}
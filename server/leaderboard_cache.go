@@ -144,6 +144,7 @@ type LeaderboardCache interface {
 	RefreshAllLeaderboards(ctx context.Context) error
 	Create(ctx context.Context, id string, authoritative bool, sortOrder, operator int, resetSchedule, metadata string) (*Leaderboard, error)
 	Insert(id string, authoritative bool, sortOrder, operator int, resetSchedule, metadata string, createTime int64)
+	SetResetSchedule(ctx context.Context, id, resetSchedule string) (*Leaderboard, error)
 	CreateTournament(ctx context.Context, id string, sortOrder, operator int, resetSchedule, metadata, title, description string, category, startTime, endTime, duration, maxSize, maxNumScore int, joinRequired bool) (*Leaderboard, error)
 	InsertTournament(id string, sortOrder, operator int, resetSchedule, metadata, title, description string, category, duration, maxSize, maxNumScore int, joinRequired bool, createTime, startTime, endTime int64)
 	ListTournaments(now int64, categoryStart, categoryEnd int, startTime, endTime int64, limit int, cursor *TournamentListCursor) ([]*Leaderboard, *TournamentListCursor, error)
@@ -379,6 +380,48 @@ func (l *LocalLeaderboardCache) Insert(id string, authoritative bool, sortOrder,
 	l.Unlock()
 }
 
+// SetResetSchedule replaces the reset schedule of a leaderboard, or removes it if the schedule is empty. Tournaments
+// are not affected. Callers should update the leaderboard scheduler afterwards so it picks up the new schedule.
+func (l *LocalLeaderboardCache) SetResetSchedule(ctx context.Context, id, resetSchedule string) (*Leaderboard, error) {
+	leaderboard := l.Get(id)
+	if leaderboard == nil || leaderboard.IsTournament() {
+		return nil, ErrLeaderboardNotFound
+	}
+
+	var expr *cronexpr.Expression
+	var err error
+	if resetSchedule != "" {
+		expr, err = cronexpr.Parse(resetSchedule)
+		if err != nil {
+			l.logger.Error("Error parsing leaderboard reset schedule", zap.Error(err))
+			return nil, err
+		}
+	}
+
+	// Update the database first.
+	query := "UPDATE leaderboard SET reset_schedule = $2 WHERE id = $1"
+	params := []interface{}{id, sql.NullString{String: resetSchedule, Valid: resetSchedule != ""}}
+	if _, err := l.db.ExecContext(ctx, query, params...); err != nil {
+		l.logger.Error("Error updating leaderboard reset schedule", zap.Error(err))
+		return nil, err
+	}
+
+	// Then replace the cached leaderboard, leaving any copies already handed out unchanged.
+	l.Lock()
+	if leaderboard = l.leaderboards[id]; leaderboard == nil {
+		// Deleted concurrently.
+		l.Unlock()
+		return nil, ErrLeaderboardNotFound
+	}
+	updated := *leaderboard
+	updated.ResetScheduleStr = resetSchedule
+	updated.ResetSchedule = expr
+	l.leaderboards[id] = &updated
+	l.Unlock()
+
+	return &updated, nil
+}
+
 func (l *LocalLeaderboardCache) CreateTournament(ctx context.Context, id string, sortOrder, operator int, resetSchedule, metadata, title, description string, category, startTime, endTime, duration, maxSize, maxNumScore int, joinRequired bool) (*Leaderboard, error) {
 	resetCron, err := checkTournamentConfig(resetSchedule, startTime, endTime, duration, maxSize, maxNumScore)
 	if err != nil {
@@ -20,6 +20,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/gofrs/uuid"
 	"go.uber.org/atomic"
 
 	"go.uber.org/zap"
@@ -387,6 +388,10 @@ WHERE id = $1`
 					if _, err := ls.db.ExecContext(ls.ctx, "UPDATE leaderboard SET size = 0 WHERE id = $1", callback.id); err != nil {
 						ls.logger.Error("Could not reset leaderboard size", zap.Error(err), zap.String("id", callback.id))
 					}
+					// Anyone still waitlisted for the period that ended can no longer get a place.
+					if _, err := ls.db.ExecContext(ls.ctx, "DELETE FROM storage WHERE collection = $1 AND user_id = $2 AND key LIKE $3", TournamentWaitlistStorageCollection, uuid.Nil, tournamentWaitlistPrefix(callback.id, callback.ts)+"%"); err != nil {
+						ls.logger.Error("Could not clear tournament waitlist", zap.Error(err), zap.String("id", callback.id))
+					}
 
 					if ls.fnTournamentReset != nil {
 						if err := ls.fnTournamentReset(ls.ctx, tournament, int64(tournament.EndActive), int64(tournament.NextReset)); err != nil {
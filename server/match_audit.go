@@ -0,0 +1,91 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/heroiclabs/nakama-common/api"
+	"go.uber.org/zap"
+)
+
+// MatchKickAudit is the audit entry recorded for each presence kicked from an authoritative match.
+type MatchKickAudit struct {
+	MatchID   string `json:"match_id"`
+	Kicker    string `json:"kicker,omitempty"`
+	UserID    string `json:"user_id"`
+	SessionID string `json:"session_id"`
+	Reason    string `json:"reason,omitempty"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// AuditMatchKick records an audit entry for each kicked presence, if kick auditing is enabled. The kicker is optional
+// and identifies whoever requested the kick, otherwise the kick is attributed to the match itself. Storage entries are
+// system-owned, hidden from clients, and written in the background so the match loop never waits on the database.
+// Failures to record are logged but never prevent the kick.
+func AuditMatchKick(logger *zap.Logger, db *sql.DB, config *MatchConfig, matchID, kicker, reason string, presences []*MatchPresence) {
+	if config.KickAuditCollection == "" && !config.KickAuditLog {
+		return
+	}
+
+	now := time.Now().UTC().Unix()
+	ops := make(StorageOpWrites, 0, len(presences))
+	for _, presence := range presences {
+		entry := &MatchKickAudit{
+			MatchID:   matchID,
+			Kicker:    kicker,
+			UserID:    presence.UserID.String(),
+			SessionID: presence.SessionID.String(),
+			Reason:    reason,
+			Timestamp: now,
+		}
+
+		if config.KickAuditLog {
+			logger.Info("Match kick", zap.String("mid", entry.MatchID), zap.String("kicker", entry.Kicker), zap.String("uid", entry.UserID), zap.String("sid", entry.SessionID), zap.String("reason", entry.Reason))
+		}
+
+		if config.KickAuditCollection != "" {
+			value, err := json.Marshal(entry)
+			if err != nil {
+				logger.Error("Could not encode match kick audit entry", zap.Error(err))
+				continue
+			}
+			ops = append(ops, &StorageOpWrite{
+				OwnerID: uuid.Nil.String(),
+				Object: &api.WriteStorageObject{
+					Collection:      config.KickAuditCollection,
+					Key:             uuid.Must(uuid.NewV4()).String(),
+					Value:           string(value),
+					PermissionRead:  &wrappers.Int32Value{Value: 0},
+					PermissionWrite: &wrappers.Int32Value{Value: 0},
+				},
+			})
+		}
+	}
+
+	if len(ops) == 0 {
+		return
+	}
+	go func() {
+		if _, _, err := StorageWriteObjects(context.Background(), logger, db, true, 0, ops); err != nil {
+			logger.Error("Could not write match kick audit entries", zap.String("mid", matchID), zap.Error(err))
+		}
+	}()
+}
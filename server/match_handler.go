@@ -17,9 +17,12 @@ package server
 import (
 	"context"
 	"fmt"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/gofrs/uuid"
+	"github.com/heroiclabs/nakama-common/rtapi"
 	"github.com/pkg/errors"
 	"go.uber.org/atomic"
 	"go.uber.org/zap"
@@ -34,6 +37,8 @@ type MatchDataMessage struct {
 	Data        []byte
 	Reliable    bool
 	ReceiveTime int64
+	// Client sequence number, 0 if the client did not provide one.
+	Seq uint64
 }
 
 func (m *MatchDataMessage) GetUserId() string {
@@ -70,11 +75,293 @@ func (m *MatchDataMessage) GetReceiveTime() int64 {
 	return m.ReceiveTime
 }
 
+// MatchOpCodeFilter restricts which client input op codes reach an authoritative match loop.
+// Until an allowed set is configured all op codes are accepted.
+type MatchOpCodeFilter struct {
+	allowed *atomic.Value
+	metrics *Metrics
+}
+
+func NewMatchOpCodeFilter(metrics *Metrics) *MatchOpCodeFilter {
+	return &MatchOpCodeFilter{
+		allowed: &atomic.Value{},
+		metrics: metrics,
+	}
+}
+
+// SetAllowed replaces the set of accepted op codes. A nil list removes the filter and accepts all op codes again.
+func (f *MatchOpCodeFilter) SetAllowed(opCodes []int64) {
+	if opCodes == nil {
+		f.allowed.Store(map[int64]struct{}(nil))
+		return
+	}
+	allowed := make(map[int64]struct{}, len(opCodes))
+	for _, opCode := range opCodes {
+		allowed[opCode] = struct{}{}
+	}
+	f.allowed.Store(allowed)
+}
+
+// Allow reports whether the op code is accepted, counting it as dropped if not.
+func (f *MatchOpCodeFilter) Allow(opCode int64) bool {
+	allowed, _ := f.allowed.Load().(map[int64]struct{})
+	if allowed == nil {
+		return true
+	}
+	if _, found := allowed[opCode]; found {
+		return true
+	}
+	f.metrics.CountMatchOpCodeDropped(1)
+	return false
+}
+
+type matchReceipt struct {
+	sessionIDs map[uuid.UUID]struct{}
+	expiry     time.Time
+}
+
+// MatchReceiptTracker tracks delivery receipts requested for reliable authoritative match broadcasts. Each receipt
+// waits for an acknowledgement from every presence the broadcast was sent to, until it expires. Clients acknowledge a
+// receipt by sending match data with the configured receipt op code and the receipt ID as data, and only
+// acknowledgements of outstanding receipts reach the match loop. Until a match requests its first receipt the receipt
+// op code is not intercepted.
+type MatchReceiptTracker struct {
+	sync.Mutex
+	maxPending int
+	timeout    time.Duration
+	// Receipt ID to the receipt still waiting on acknowledgements, nil until a receipt is requested.
+	pending map[string]*matchReceipt
+}
+
+func NewMatchReceiptTracker(maxPending int, timeout time.Duration) *MatchReceiptTracker {
+	return &MatchReceiptTracker{
+		maxPending: maxPending,
+		timeout:    timeout,
+	}
+}
+
+// Add starts waiting for acknowledgements of a receipt from the given presences.
+func (t *MatchReceiptTracker) Add(receiptID string, presenceIDs []*PresenceID) error {
+	t.Lock()
+	defer t.Unlock()
+	if t.pending == nil {
+		t.pending = make(map[string]*matchReceipt)
+	}
+	if _, found := t.pending[receiptID]; found {
+		return ErrMatchReceiptExists
+	}
+	if len(t.pending) >= t.maxPending {
+		return ErrMatchReceiptsFull
+	}
+	sessionIDs := make(map[uuid.UUID]struct{}, len(presenceIDs))
+	for _, presenceID := range presenceIDs {
+		sessionIDs[presenceID.SessionID] = struct{}{}
+	}
+	t.pending[receiptID] = &matchReceipt{sessionIDs: sessionIDs, expiry: time.Now().Add(t.timeout)}
+	return nil
+}
+
+// Expire stops waiting on receipts that have not been fully acknowledged by the given time, and returns their IDs in
+// the order they expired.
+func (t *MatchReceiptTracker) Expire(now time.Time) []string {
+	t.Lock()
+	defer t.Unlock()
+	var expired []string
+	for receiptID, receipt := range t.pending {
+		if !now.Before(receipt.expiry) {
+			expired = append(expired, receiptID)
+		}
+	}
+	sort.Slice(expired, func(i, j int) bool {
+		return t.pending[expired[i]].expiry.Before(t.pending[expired[j]].expiry)
+	})
+	for _, receiptID := range expired {
+		delete(t.pending, receiptID)
+	}
+	return expired
+}
+
+// Intercepts reports whether messages with the op code are receipt acknowledgements for this match.
+func (t *MatchReceiptTracker) Intercepts(opCode, receiptOpCode int64) bool {
+	if opCode != receiptOpCode {
+		return false
+	}
+	t.Lock()
+	enabled := t.pending != nil
+	t.Unlock()
+	return enabled
+}
+
+// Ack records an acknowledgement of a receipt from a session, and reports whether it was outstanding.
+func (t *MatchReceiptTracker) Ack(receiptID string, sessionID uuid.UUID) bool {
+	t.Lock()
+	defer t.Unlock()
+	receipt, found := t.pending[receiptID]
+	if !found {
+		return false
+	}
+	if _, found := receipt.sessionIDs[sessionID]; !found {
+		return false
+	}
+	delete(receipt.sessionIDs, sessionID)
+	if len(receipt.sessionIDs) == 0 {
+		delete(t.pending, receiptID)
+	}
+	return true
+}
+
+// Leave stops waiting for acknowledgements from sessions that left the match.
+func (t *MatchReceiptTracker) Leave(leaves []*MatchPresence) {
+	t.Lock()
+	defer t.Unlock()
+	for receiptID, receipt := range t.pending {
+		for _, leave := range leaves {
+			delete(receipt.sessionIDs, leave.SessionID)
+		}
+		if len(receipt.sessionIDs) == 0 {
+			delete(t.pending, receiptID)
+		}
+	}
+}
+
+// Pending returns the number of receipts still waiting for acknowledgements.
+func (t *MatchReceiptTracker) Pending() int {
+	t.Lock()
+	defer t.Unlock()
+	return len(t.pending)
+}
+
+// MatchDedupFilter drops duplicate client input to an authoritative match, identified by the sequence number clients
+// may send as the collation ID of each match data message. Each presence keeps its own window of recently seen sequence
+// numbers, and sequence numbers too far behind the highest one seen are dropped as they can no longer be checked.
+// Messages without a sequence number are always accepted. Until a match enables it no messages are dropped.
+type MatchDedupFilter struct {
+	sync.Mutex
+	window   uint64
+	sessions map[uuid.UUID]*matchDedupWindow
+	dropped  *atomic.Int64
+}
+
+type matchDedupWindow struct {
+	highest uint64
+	seen    map[uint64]struct{}
+}
+
+func NewMatchDedupFilter(window int) *MatchDedupFilter {
+	return &MatchDedupFilter{
+		window:  uint64(window),
+		dropped: atomic.NewInt64(0),
+	}
+}
+
+// SetEnabled turns duplicate detection on or off. Disabling it forgets all sequence numbers seen so far.
+func (f *MatchDedupFilter) SetEnabled(enabled bool) {
+	f.Lock()
+	if !enabled {
+		f.sessions = nil
+	} else if f.sessions == nil {
+		f.sessions = make(map[uuid.UUID]*matchDedupWindow)
+	}
+	f.Unlock()
+}
+
+// Allow reports whether a message with the sequence number from a session should be delivered, counting it as dropped
+// if not.
+func (f *MatchDedupFilter) Allow(sessionID uuid.UUID, seq uint64) bool {
+	if seq == 0 {
+		return true
+	}
+	f.Lock()
+	defer f.Unlock()
+	if f.sessions == nil {
+		return true
+	}
+
+	w, found := f.sessions[sessionID]
+	if !found {
+		w = &matchDedupWindow{seen: make(map[uint64]struct{}, f.window)}
+		f.sessions[sessionID] = w
+	}
+	if w.highest > f.window && seq <= w.highest-f.window {
+		f.dropped.Inc()
+		return false
+	}
+	if _, found := w.seen[seq]; found {
+		f.dropped.Inc()
+		return false
+	}
+	w.seen[seq] = struct{}{}
+	if seq > w.highest {
+		w.highest = seq
+		if w.highest > f.window {
+			for s := range w.seen {
+				if s <= w.highest-f.window {
+					delete(w.seen, s)
+				}
+			}
+		}
+	}
+	return true
+}
+
+// Leave forgets the sequence numbers seen from sessions that left the match.
+func (f *MatchDedupFilter) Leave(leaves []*MatchPresence) {
+	f.Lock()
+	defer f.Unlock()
+	for _, leave := range leaves {
+		delete(f.sessions, leave.SessionID)
+	}
+}
+
+// Dropped returns the total number of messages dropped as duplicates.
+func (f *MatchDedupFilter) Dropped() int64 {
+	return f.dropped.Load()
+}
+
+// MatchFinalMessage holds a message an authoritative match wants delivered when it stops, such as match results. The
+// match handler sends it reliably once the match stops for any reason, after any deferred broadcasts and before the
+// match presences are removed. Registering a message again replaces the previous one.
+type MatchFinalMessage struct {
+	sync.Mutex
+	presenceIDs []*PresenceID
+	envelope    *rtapi.Envelope
+	// Send to every presence in the match when it stops, rather than to the selected presences.
+	all bool
+}
+
+func (f *MatchFinalMessage) Set(presenceIDs []*PresenceID, envelope *rtapi.Envelope, all bool) {
+	f.Lock()
+	f.presenceIDs = presenceIDs
+	f.envelope = envelope
+	f.all = all
+	f.Unlock()
+}
+
+// Resolve returns the recipients of the final message that are still in the match, and the message to send them.
+func (f *MatchFinalMessage) Resolve(presenceList *MatchPresenceList) ([]*PresenceID, *rtapi.Envelope) {
+	f.Lock()
+	defer f.Unlock()
+	if f.envelope == nil {
+		return nil, nil
+	}
+	if f.all {
+		return presenceList.ListPresenceIDs(), f.envelope
+	}
+	presenceIDs := make([]*PresenceID, 0, len(f.presenceIDs))
+	for _, presenceID := range f.presenceIDs {
+		if presenceList.Contains(presenceID) {
+			presenceIDs = append(presenceIDs, presenceID)
+		}
+	}
+	return presenceIDs, f.envelope
+}
+
 type MatchHandler struct {
 	logger          *zap.Logger
 	sessionRegistry SessionRegistry
 	matchRegistry   MatchRegistry
 	router          MessageRouter
+	metrics         *Metrics
 
 	JoinMarkerList *MatchJoinMarkerList
 	PresenceList   *MatchPresenceList
@@ -85,9 +372,13 @@ type MatchHandler struct {
 	Node   string
 	IDStr  string
 	Stream PresenceStream
+	// Time the match was created, in UTC nanoseconds.
+	CreateTime int64
 
 	// Internal state.
 	tick int64
+	// Time the previous match loop started, used to report real elapsed time to the next loop.
+	lastLoopTime time.Time
 
 	// Control elements.
 	emptyTicks    int
@@ -108,7 +399,7 @@ type MatchHandler struct {
 	state interface{}
 }
 
-func NewMatchHandler(logger *zap.Logger, config Config, sessionRegistry SessionRegistry, matchRegistry MatchRegistry, router MessageRouter, core RuntimeMatchCore, id uuid.UUID, node string, stopped *atomic.Bool, params map[string]interface{}) (*MatchHandler, error) {
+func NewMatchHandler(logger *zap.Logger, config Config, sessionRegistry SessionRegistry, matchRegistry MatchRegistry, router MessageRouter, metrics *Metrics, core RuntimeMatchCore, id uuid.UUID, node string, stopped *atomic.Bool, params map[string]interface{}, reservedSessions []uuid.UUID) (*MatchHandler, error) {
 	presenceList := NewMatchPresenceList()
 
 	deferredCh := make(chan *DeferredMessage, config.GetMatch().DeferredQueueSize)
@@ -137,6 +428,7 @@ func NewMatchHandler(logger *zap.Logger, config Config, sessionRegistry SessionR
 		sessionRegistry: sessionRegistry,
 		matchRegistry:   matchRegistry,
 		router:          router,
+		metrics:         metrics,
 
 		JoinMarkerList: NewMatchJoinMarkerList(config, int64(rateInt)),
 		PresenceList:   presenceList,
@@ -150,6 +442,7 @@ func NewMatchHandler(logger *zap.Logger, config Config, sessionRegistry SessionR
 			Subject: id,
 			Label:   node,
 		},
+		CreateTime: time.Now().UTC().UnixNano(),
 
 		tick: 0,
 
@@ -168,7 +461,13 @@ func NewMatchHandler(logger *zap.Logger, config Config, sessionRegistry SessionR
 		state: state,
 	}
 
+	if len(reservedSessions) != 0 {
+		expiry := time.Now().Add(time.Duration(config.GetMatch().ReservationTimeoutMs) * time.Millisecond)
+		mh.PresenceList.Reserve(reservedSessions, expiry)
+	}
+
 	// Set up the ticker that governs the match loop.
+	mh.lastLoopTime = time.Now()
 	mh.ticker = time.NewTicker(time.Second / time.Duration(mh.Rate))
 
 	// Continuously run queued actions until the match stops.
@@ -212,12 +511,15 @@ func (mh *MatchHandler) Stop() {
 		return
 	}
 
+	// Ensure any remaining deferred broadcasts and the final message are sent while presences are still tracked.
+	mh.processDeferred()
+	if presenceIDs, envelope := mh.core.MatchFinalMessage(); len(presenceIDs) != 0 {
+		mh.router.SendToPresenceIDs(mh.logger, presenceIDs, envelope, true)
+	}
+
 	// Drop the match handler from the match registry.
 	mh.matchRegistry.RemoveMatch(mh.ID, mh.Stream)
 
-	// Ensure any remaining deferred broadcasts are sent.
-	mh.processDeferred()
-
 	mh.core.Cancel()
 	close(mh.stopCh)
 	mh.ticker.Stop()
@@ -255,6 +557,7 @@ func (mh *MatchHandler) QueueData(m *MatchDataMessage) {
 	default:
 		// Match input queue is full, the handler isn't processing fast enough or there's too much incoming data.
 		mh.logger.Warn("Match handler data processing too slow, dropping data message", zap.Any("m", m))
+		mh.metrics.CountMatchInputDropped(1)
 		return
 	}
 }
@@ -264,8 +567,14 @@ func loop(mh *MatchHandler) {
 		return
 	}
 
+	// Measure real time elapsed since the previous loop, this may exceed the tick interval if ticks were delayed.
+	now := time.Now()
+	delta := now.Sub(mh.lastLoopTime)
+	mh.lastLoopTime = now
+	mh.metrics.MatchInputQueueDepth(len(mh.inputCh))
+
 	// Execute the loop.
-	state, err := mh.core.MatchLoop(mh.tick, mh.state, mh.inputCh)
+	state, err := mh.core.MatchLoop(mh.tick, mh.state, mh.inputCh, delta)
 	if err != nil {
 		mh.Stop()
 		mh.disconnectClients()
@@ -313,6 +622,9 @@ func loop(mh *MatchHandler) {
 	mh.tick++
 }
 
+// Flush deferred messages queued so far. This runs after each match handler function returns, so within a single
+// call every immediate broadcast is delivered before any deferred broadcast, and deferred broadcasts are delivered
+// to each presence in the order they were queued.
 func (mh *MatchHandler) processDeferred() {
 	deferredCount := len(mh.deferredCh)
 	if deferredCount != 0 {
@@ -346,7 +658,13 @@ func (mh *MatchHandler) QueueJoinAttempt(ctx context.Context, resultCh chan<- *M
 			return
 		}
 
-		state, allow, reason, err := mh.core.MatchJoinAttempt(mh.tick, mh.state, userID, sessionID, username, sessionExpiry, vars, clientIP, clientPort, node, metadata)
+		reserved := mh.PresenceList.CheckReservation(sessionID)
+		if !reserved && mh.isFull(sessionID) {
+			resultCh <- &MatchJoinResult{Allow: false, Reason: "match full", Label: mh.core.Label()}
+			return
+		}
+
+		state, allow, reason, err := mh.core.MatchJoinAttempt(mh.tick, mh.state, userID, sessionID, username, sessionExpiry, vars, clientIP, clientPort, node, metadata, reserved)
 		if err != nil {
 			mh.Stop()
 			resultCh <- &MatchJoinResult{Allow: false}
@@ -367,7 +685,10 @@ func (mh *MatchHandler) QueueJoinAttempt(ctx context.Context, resultCh chan<- *M
 
 		mh.state = state
 		if allow {
-			presence := &MatchPresence{Node: node, UserID: userID, SessionID: sessionID, Username: username}
+			if reserved {
+				mh.PresenceList.ReleaseReservation(sessionID)
+			}
+			presence := &MatchPresence{Node: node, UserID: userID, SessionID: sessionID, Username: username, Metadata: metadata, Vars: vars, Reserved: reserved}
 			mh.JoinMarkerList.Add(presence, mh.tick)
 			mh.QueueJoin([]*MatchPresence{presence}, false)
 		}
@@ -386,6 +707,13 @@ func (mh *MatchHandler) QueueJoinAttempt(ctx context.Context, resultCh chan<- *M
 	}
 }
 
+// Report whether the current presences plus reservations held by other sessions fill the match, according to the
+// maximum size set through its dispatcher. Matches that do not set a maximum size are never full.
+func (mh *MatchHandler) isFull(sessionID uuid.UUID) bool {
+	maxSize := mh.PresenceList.MaxSize()
+	return maxSize > 0 && !mh.PresenceList.HasSpace(sessionID, maxSize)
+}
+
 func (mh *MatchHandler) QueueJoin(joins []*MatchPresence, mark bool) bool {
 	if mh.stopped.Load() {
 		return false
@@ -504,3 +832,102 @@ func (mh *MatchHandler) QueueTerminate(graceSeconds int) bool {
 
 	return mh.queueCall(terminate)
 }
+
+func (mh *MatchHandler) QueueSignal(ctx context.Context, resultCh chan<- *MatchSignalResult, data string) bool {
+	if mh.stopped.Load() {
+		return false
+	}
+
+	signal := func(mh *MatchHandler) {
+		select {
+		case <-ctx.Done():
+			// The caller has gone away, do not process the signal.
+			resultCh <- &MatchSignalResult{Success: false}
+			return
+		default:
+		}
+
+		if mh.stopped.Load() {
+			resultCh <- &MatchSignalResult{Success: false}
+			return
+		}
+
+		state, result, err := mh.core.MatchSignal(mh.tick, mh.state, data)
+		if err != nil {
+			mh.Stop()
+			resultCh <- &MatchSignalResult{Success: false}
+			mh.disconnectClients()
+			mh.logger.Warn("Stopping match after error from match_signal execution", zap.Int64("tick", mh.tick), zap.Error(err))
+			return
+		}
+		if state != nil {
+			// Broadcast any deferred messages. If match will be stopped broadcasting will be handled as part of the match end cycle.
+			mh.processDeferred()
+		} else {
+			mh.Stop()
+			resultCh <- &MatchSignalResult{Success: false}
+			mh.logger.Info("Match signal returned nil or no state, stopping match")
+			return
+		}
+
+		mh.state = state
+
+		// Signal caller.
+		resultCh <- &MatchSignalResult{Success: true, Result: result}
+	}
+
+	return mh.queueCall(signal)
+}
+
+// Ask the match signal handler for a snapshot of the match state. Unlike other signals the match keeps running if the
+// handler fails or returns no state, and any state it returns is discarded.
+func (mh *MatchHandler) QueueGetState(ctx context.Context, resultCh chan<- *MatchSignalResult) bool {
+	if mh.stopped.Load() {
+		return false
+	}
+
+	getState := func(mh *MatchHandler) {
+		select {
+		case <-ctx.Done():
+			// The caller has gone away, do not process the signal.
+			resultCh <- &MatchSignalResult{Success: false}
+			return
+		default:
+		}
+
+		if mh.stopped.Load() {
+			resultCh <- &MatchSignalResult{Success: false}
+			return
+		}
+
+		_, result, err := mh.core.MatchSignal(mh.tick, mh.state, MatchGetStateSignal)
+		if err != nil {
+			// The match provides no snapshot, but is otherwise unaffected.
+			resultCh <- &MatchSignalResult{Success: true}
+			mh.logger.Warn("Error from match_signal execution getting match state", zap.Int64("tick", mh.tick), zap.Error(err))
+			return
+		}
+
+		// Signal caller.
+		resultCh <- &MatchSignalResult{Success: true, Result: result}
+	}
+
+	return mh.queueCall(getState)
+}
+
+func (mh *MatchHandler) QueueStop() bool {
+	if mh.stopped.Load() {
+		return false
+	}
+
+	stop := func(mh *MatchHandler) {
+		if mh.stopped.Load() {
+			return
+		}
+
+		mh.Stop()
+		mh.logger.Info("Match stopped after terminate grace period expired")
+	}
+
+	return mh.queueCall(stop)
+}
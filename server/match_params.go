@@ -0,0 +1,100 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	MatchParamTypeString  = "string"
+	MatchParamTypeNumber  = "number"
+	MatchParamTypeBoolean = "boolean"
+	MatchParamTypeTable   = "table"
+)
+
+// MatchParamsSchema maps each param name a match accepts to its expected type. Types may be suffixed with "?" to mark
+// the param as optional, for example "string?". Params not listed in the schema are rejected.
+type MatchParamsSchema map[string]string
+
+// Check the schema itself is well formed, so mistakes surface when the match is created rather than silently accepted.
+func (s MatchParamsSchema) Validate() error {
+	for name, paramType := range s {
+		switch strings.TrimSuffix(paramType, "?") {
+		case MatchParamTypeString, MatchParamTypeNumber, MatchParamTypeBoolean, MatchParamTypeTable:
+		default:
+			return fmt.Errorf("match params schema has invalid type '%v' for param '%v'", paramType, name)
+		}
+	}
+	return nil
+}
+
+// ValidateParams checks the given match params against the schema, returning an error describing the first mismatch.
+func (s MatchParamsSchema) ValidateParams(params map[string]interface{}) error {
+	// Check names in a stable order so the reported error is deterministic.
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		paramType := s[name]
+		optional := strings.HasSuffix(paramType, "?")
+		paramType = strings.TrimSuffix(paramType, "?")
+
+		value, found := params[name]
+		if !found || value == nil {
+			if optional {
+				continue
+			}
+			return fmt.Errorf("invalid match params: missing required param '%v'", name)
+		}
+
+		if actualType := matchParamType(value); actualType != paramType {
+			return fmt.Errorf("invalid match params: param '%v' must be %v, got %v", name, paramType, actualType)
+		}
+	}
+
+	unknown := make([]string, 0)
+	for name := range params {
+		if _, found := s[name]; !found {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) != 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("invalid match params: unknown param '%v'", unknown[0])
+	}
+
+	return nil
+}
+
+func matchParamType(value interface{}) string {
+	switch value.(type) {
+	case string:
+		return MatchParamTypeString
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return MatchParamTypeNumber
+	case bool:
+		return MatchParamTypeBoolean
+	case map[string]interface{}, []interface{}:
+		return MatchParamTypeTable
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
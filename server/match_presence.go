@@ -18,6 +18,7 @@ import (
 	"github.com/gofrs/uuid"
 	"go.uber.org/atomic"
 	"sync"
+	"time"
 )
 
 // Represents routing and identify information for a single match participant.
@@ -26,6 +27,13 @@ type MatchPresence struct {
 	UserID    uuid.UUID
 	SessionID uuid.UUID
 	Username  string
+	Reason    PresenceReason
+	// Optional metadata supplied with the join attempt, only set on presences passed to match join.
+	Metadata map[string]string
+	// Session vars of the joining session, only set on presences that joined through a join attempt.
+	Vars map[string]string
+	// True if the presence joined using a place reserved for its session when the match was created.
+	Reserved bool
 }
 
 func (p *MatchPresence) GetUserId() string {
@@ -49,6 +57,18 @@ func (p *MatchPresence) GetUsername() string {
 func (p *MatchPresence) GetStatus() string {
 	return ""
 }
+func (p *MatchPresence) GetReason() PresenceReason {
+	return p.Reason
+}
+func (p *MatchPresence) GetMetadata() map[string]string {
+	return p.Metadata
+}
+func (p *MatchPresence) GetVars() map[string]string {
+	return p.Vars
+}
+func (p *MatchPresence) GetReserved() bool {
+	return p.Reserved
+}
 
 // Used to monitor when match presences begin and complete their match join process.
 type MatchJoinMarker struct {
@@ -105,6 +125,11 @@ type MatchPresenceList struct {
 	size        *atomic.Int32
 	presences   []*MatchPresenceListItem
 	presenceMap map[uuid.UUID]string
+	// Maximum number of presences the match accepts as set through its dispatcher, 0 if there is no limit.
+	maxSize *atomic.Int32
+
+	// Sessions reserved a place in the match when it was created, with the time each reservation expires.
+	reservations map[uuid.UUID]time.Time
 }
 
 type MatchPresenceListItem struct {
@@ -117,6 +142,7 @@ func NewMatchPresenceList() *MatchPresenceList {
 		size:        atomic.NewInt32(0),
 		presences:   make([]*MatchPresenceListItem, 0, 10),
 		presenceMap: make(map[uuid.UUID]string, 10),
+		maxSize:     atomic.NewInt32(0),
 	}
 }
 
@@ -195,6 +221,92 @@ func (m *MatchPresenceList) ListPresences() []*MatchPresence {
 	return list
 }
 
+// ListPresencesByVar returns presences whose session vars contain the given key set to the given value.
+func (m *MatchPresenceList) ListPresencesByVar(key, value string) []*MatchPresence {
+	m.RLock()
+	list := make([]*MatchPresence, 0)
+	for _, presence := range m.presences {
+		if v, found := presence.Presence.Vars[key]; found && v == value {
+			list = append(list, presence.Presence)
+		}
+	}
+	m.RUnlock()
+	return list
+}
+
 func (m *MatchPresenceList) Size() int {
 	return int(m.size.Load())
 }
+
+// Reserve holds a place for each of the given sessions until the expiry time.
+func (m *MatchPresenceList) Reserve(sessionIDs []uuid.UUID, expiry time.Time) {
+	m.Lock()
+	if m.reservations == nil {
+		m.reservations = make(map[uuid.UUID]time.Time, len(sessionIDs))
+	}
+	for _, sessionID := range sessionIDs {
+		m.reservations[sessionID] = expiry
+	}
+	m.Unlock()
+}
+
+// CheckReservation drops expired reservations and reports whether the session holds a reservation.
+func (m *MatchPresenceList) CheckReservation(sessionID uuid.UUID) bool {
+	m.Lock()
+	m.clearExpiredReservations()
+	_, found := m.reservations[sessionID]
+	m.Unlock()
+	return found
+}
+
+// ReleaseReservation drops the reservation held by the session, if any.
+func (m *MatchPresenceList) ReleaseReservation(sessionID uuid.UUID) {
+	m.Lock()
+	delete(m.reservations, sessionID)
+	m.Unlock()
+}
+
+// Reservations returns the number of reservations that have not been used or expired yet.
+func (m *MatchPresenceList) Reservations() int {
+	m.Lock()
+	m.clearExpiredReservations()
+	count := len(m.reservations)
+	m.Unlock()
+	return count
+}
+
+// HasSpace reports whether the current presences plus outstanding reservations leave room for the given session in a
+// match of the given maximum size. A reservation held by the session itself is its own place, so it is not counted.
+func (m *MatchPresenceList) HasSpace(sessionID uuid.UUID, max int) bool {
+	m.Lock()
+	m.clearExpiredReservations()
+	reservations := len(m.reservations)
+	if _, found := m.reservations[sessionID]; found {
+		reservations--
+	}
+	m.Unlock()
+	return m.Size()+reservations < max
+}
+
+// SetMaxSize sets the maximum number of presences the match accepts, 0 removes the limit.
+func (m *MatchPresenceList) SetMaxSize(max int) {
+	m.maxSize.Store(int32(max))
+}
+
+// MaxSize returns the maximum number of presences the match accepts, 0 if there is no limit.
+func (m *MatchPresenceList) MaxSize() int {
+	return int(m.maxSize.Load())
+}
+
+// Must be called with the lock held.
+func (m *MatchPresenceList) clearExpiredReservations() {
+	if len(m.reservations) == 0 {
+		return
+	}
+	now := time.Now()
+	for sessionID, expiry := range m.reservations {
+		if now.After(expiry) {
+			delete(m.reservations, sessionID)
+		}
+	}
+}
@@ -20,6 +20,8 @@ import (
 	"encoding/gob"
 	"encoding/json"
 	"fmt"
+	"math/rand"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -28,6 +30,7 @@ import (
 	"github.com/blevesearch/bleve/analysis/analyzer/keyword"
 	"github.com/blevesearch/bleve/search/query"
 	"github.com/gofrs/uuid"
+	"github.com/golang/protobuf/ptypes/timestamp"
 	"github.com/golang/protobuf/ptypes/wrappers"
 	"github.com/heroiclabs/nakama-common/api"
 	"github.com/heroiclabs/nakama-common/runtime"
@@ -36,6 +39,8 @@ import (
 	"go.uber.org/zap"
 )
 
+const MatchLabelUpdateEventName = "match_label_update"
+
 func init() {
 	// Ensure gob can deal with typical types that might be used in match parameters.
 	gob.Register(map[string]interface{}(nil))
@@ -53,11 +58,34 @@ var (
 	MatchFilterRelayed = map[uint8]*uint8{StreamModeMatchRelayed: MatchFilterPtr}
 
 	MatchLabelMaxBytes = 2048
+	// Upper bound on the number of matches a single signal by label can fan out to.
+	MatchSignalMaxMatches = 100
+	// Signal data starting with this prefix is reserved for signals sent by the server itself.
+	MatchSignalReservedPrefix = "nakama:"
+	// Signal data asking a match for a snapshot of its state, which its match signal handler returns as the result.
+	MatchGetStateSignal = MatchSignalReservedPrefix + "get_state"
 
 	ErrCannotEncodeParams    = errors.New("error creating match: cannot encode params")
 	ErrMatchIdInvalid        = errors.New("match id invalid")
+	ErrMatchNotFound         = errors.New("match not found")
+	ErrMatchGraceInvalid     = errors.New("match grace seconds invalid, must be >= 0")
 	ErrMatchLabelTooLong     = errors.New("match label too long, must be 0-2048 bytes")
+	ErrMatchSignalLimit      = errors.New("match signal limit invalid, must be 1-100")
+	ErrMatchSignalTimeout    = errors.New("match signal timed out waiting for match handlers")
+	ErrMatchSignalReserved   = errors.New("match signal data must not start with reserved prefix \"nakama:\"")
+	ErrMatchListSortInvalid  = errors.New("match list sort invalid, must be one of: size_asc, size_desc, age_asc, age_desc")
 	ErrDeferredBroadcastFull = errors.New("too many deferred message broadcasts per tick")
+	ErrMatchReceiptsFull     = errors.New("too many outstanding match delivery receipts")
+	ErrMatchReceiptExists    = errors.New("match delivery receipt id already outstanding")
+)
+
+// Orderings supported when listing matches. Age ascending lists the most recently created matches first. Relayed matches
+// have no known creation time and are treated as older than any authoritative match.
+const (
+	MatchListSortSizeAsc  = "size_asc"
+	MatchListSortSizeDesc = "size_desc"
+	MatchListSortAgeAsc   = "age_asc"
+	MatchListSortAgeDesc  = "age_desc"
 )
 
 type MatchIndexEntry struct {
@@ -72,21 +100,45 @@ type MatchJoinResult struct {
 	Label  string
 }
 
+type MatchSignalResult struct {
+	Success bool
+	Result  string
+}
+
 type MatchRegistry interface {
 	// Create and start a new match, given a Lua module name or registered Go match function.
 	CreateMatch(ctx context.Context, logger *zap.Logger, createFn RuntimeMatchCreateFunction, module string, params map[string]interface{}) (string, error)
+	// Create and start a new match that reserves a place for each of the given sessions. Reserved sessions may join
+	// even when the match is full, and other sessions may not take their places until the reservations expire.
+	CreateMatchWithReservations(ctx context.Context, logger *zap.Logger, createFn RuntimeMatchCreateFunction, module string, params map[string]interface{}, reservedSessions []uuid.UUID) (string, error)
 	// Register and initialise a match that's ready to run.
-	NewMatch(logger *zap.Logger, id uuid.UUID, core RuntimeMatchCore, stopped *atomic.Bool, params map[string]interface{}) (*MatchHandler, error)
+	NewMatch(logger *zap.Logger, id uuid.UUID, core RuntimeMatchCore, stopped *atomic.Bool, params map[string]interface{}, reservedSessions []uuid.UUID) (*MatchHandler, error)
 	// Return a match by ID.
 	GetMatch(ctx context.Context, id string) (*api.Match, error)
 	// Remove a tracked match and ensure all its presences are cleaned up.
 	// Does not ensure the match process itself is no longer running, that must be handled separately.
 	RemoveMatch(id uuid.UUID, stream PresenceStream)
+	// Run the terminate callback for a match and stop it once its grace period expires.
+	TerminateMatch(ctx context.Context, id string, graceSeconds int) error
+	// Deliver a signal to all authoritative matches on this node with labels matching the given query.
+	// Returns the number of matches that processed the signal, up to the given limit. Waits for match handlers to
+	// respond for at most the given timeout, or the configured match signal timeout if 0.
+	SignalMatchesByLabel(ctx context.Context, query string, data string, limit int, timeout time.Duration) (int, error)
+	// Ask an authoritative match on this node for a snapshot of its current state by sending it the get state signal.
+	// Returns false if the match signal handler returns no snapshot.
+	GetMatchState(ctx context.Context, id string) (string, bool, error)
 	// Update the label entry for a given match.
 	UpdateMatchLabel(id uuid.UUID, label string) error
 	// List (and optionally filter) currently running matches.
 	// This can list across both authoritative and relayed matches.
 	ListMatches(ctx context.Context, limit int, authoritative *wrappers.BoolValue, label *wrappers.StringValue, minSize *wrappers.Int32Value, maxSize *wrappers.Int32Value, query *wrappers.StringValue) ([]*api.Match, error)
+	// List (and optionally filter) currently running matches, ordered by the given sort before the limit is applied.
+	// An empty sort leaves results in arbitrary order, the same as ListMatches.
+	ListMatchesSorted(ctx context.Context, limit int, sortBy string, authoritative *wrappers.BoolValue, label *wrappers.StringValue, minSize *wrappers.Int32Value, maxSize *wrappers.Int32Value, query *wrappers.StringValue) ([]*api.Match, error)
+	// Pick a random authoritative match on this node with a label matching the given query and room for at least
+	// minSpace more presences, according to the maximum size declared in its label. Matches that do not declare a
+	// maximum size always have room. Returns nil if no match qualifies.
+	GetRandomMatch(ctx context.Context, query string, minSpace int) (*api.Match, error)
 	// Stop the match registry and close all matches it's tracking.
 	Stop(graceSeconds int) chan struct{}
 	// Returns the total number of currently active authoritative matches.
@@ -104,7 +156,7 @@ type MatchRegistry interface {
 	Kick(stream PresenceStream, presences []*MatchPresence)
 	// Pass a data payload (usually from a user) to the appropriate match handler.
 	// Assumes that the data sender has already been validated as a match participant before this call.
-	SendData(id uuid.UUID, node string, userID, sessionID uuid.UUID, username, fromNode string, opCode int64, data []byte, reliable bool, receiveTime int64)
+	SendData(id uuid.UUID, node string, userID, sessionID uuid.UUID, username, fromNode string, opCode int64, data []byte, reliable bool, receiveTime int64, seq uint64)
 }
 
 type LocalMatchRegistry struct {
@@ -152,6 +204,10 @@ func NewLocalMatchRegistry(logger, startupLogger *zap.Logger, config Config, ses
 }
 
 func (r *LocalMatchRegistry) CreateMatch(ctx context.Context, logger *zap.Logger, createFn RuntimeMatchCreateFunction, module string, params map[string]interface{}) (string, error) {
+	return r.CreateMatchWithReservations(ctx, logger, createFn, module, params, nil)
+}
+
+func (r *LocalMatchRegistry) CreateMatchWithReservations(ctx context.Context, logger *zap.Logger, createFn RuntimeMatchCreateFunction, module string, params map[string]interface{}, reservedSessions []uuid.UUID) (string, error) {
 	if err := gob.NewEncoder(&bytes.Buffer{}).Encode(params); err != nil {
 		return "", ErrCannotEncodeParams
 	}
@@ -169,7 +225,7 @@ func (r *LocalMatchRegistry) CreateMatch(ctx context.Context, logger *zap.Logger
 	}
 
 	// Start the match.
-	mh, err := r.NewMatch(matchLogger, id, core, stopped, params)
+	mh, err := r.NewMatch(matchLogger, id, core, stopped, params, reservedSessions)
 	if err != nil {
 		return "", fmt.Errorf("error creating match: %v", err.Error())
 	}
@@ -177,13 +233,13 @@ func (r *LocalMatchRegistry) CreateMatch(ctx context.Context, logger *zap.Logger
 	return mh.IDStr, nil
 }
 
-func (r *LocalMatchRegistry) NewMatch(logger *zap.Logger, id uuid.UUID, core RuntimeMatchCore, stopped *atomic.Bool, params map[string]interface{}) (*MatchHandler, error) {
+func (r *LocalMatchRegistry) NewMatch(logger *zap.Logger, id uuid.UUID, core RuntimeMatchCore, stopped *atomic.Bool, params map[string]interface{}, reservedSessions []uuid.UUID) (*MatchHandler, error) {
 	if r.stopped.Load() {
 		// Server is shutting down, reject new matches.
 		return nil, errors.New("shutdown in progress")
 	}
 
-	match, err := NewMatchHandler(logger, r.config, r.sessionRegistry, r, r.router, core, id, r.node, stopped, params)
+	match, err := NewMatchHandler(logger, r.config, r.sessionRegistry, r, r.router, r.metrics, core, id, r.node, stopped, params, reservedSessions)
 	if err != nil {
 		return nil, err
 	}
@@ -259,6 +315,137 @@ func (r *LocalMatchRegistry) RemoveMatch(id uuid.UUID, stream PresenceStream) {
 	}
 }
 
+func (r *LocalMatchRegistry) TerminateMatch(ctx context.Context, id string, graceSeconds int) error {
+	if graceSeconds < 0 {
+		return ErrMatchGraceInvalid
+	}
+
+	// Validate the match ID.
+	idComponents := strings.SplitN(id, ".", 2)
+	if len(idComponents) != 2 {
+		return ErrMatchIdInvalid
+	}
+	matchID, err := uuid.FromString(idComponents[0])
+	if err != nil {
+		return ErrMatchIdInvalid
+	}
+
+	// Only authoritative matches hosted on this node can be terminated.
+	if idComponents[1] != r.node {
+		return ErrMatchNotFound
+	}
+
+	mh, ok := r.matches.Load(matchID)
+	if !ok {
+		return ErrMatchNotFound
+	}
+	handler := mh.(*MatchHandler)
+
+	if !handler.QueueTerminate(graceSeconds) {
+		// The match has already stopped, or was closed because its call queue was full.
+		return nil
+	}
+
+	if graceSeconds > 0 {
+		// The terminate callback may keep the match running, ensure it's stopped when the grace period expires.
+		time.AfterFunc(time.Duration(graceSeconds)*time.Second, func() {
+			handler.QueueStop()
+		})
+	}
+
+	return nil
+}
+
+func (r *LocalMatchRegistry) SignalMatchesByLabel(ctx context.Context, query string, data string, limit int, timeout time.Duration) (int, error) {
+	if limit < 1 || limit > MatchSignalMaxMatches {
+		return 0, ErrMatchSignalLimit
+	}
+	if strings.HasPrefix(data, MatchSignalReservedPrefix) {
+		return 0, ErrMatchSignalReserved
+	}
+
+	// Bound the wait for slow or hung match handlers. Signals not yet processed when the timeout expires are dropped.
+	if timeout <= 0 {
+		timeout = time.Duration(r.config.GetMatch().SignalTimeoutMs) * time.Millisecond
+	}
+	ctx, ctxCancelFn := context.WithTimeout(ctx, timeout)
+	defer ctxCancelFn()
+
+	matches, err := r.ListMatches(ctx, limit, &wrappers.BoolValue{Value: true}, nil, nil, nil, &wrappers.StringValue{Value: query})
+	if err != nil {
+		return 0, err
+	}
+
+	// Queue the signal to all matching matches first so they process it concurrently, then collect results.
+	resultCh := make(chan *MatchSignalResult, len(matches))
+	var queued int
+	for _, match := range matches {
+		matchID := uuid.FromStringOrNil(strings.SplitN(match.MatchId, ".", 2)[0])
+		mh, ok := r.matches.Load(matchID)
+		if !ok {
+			continue
+		}
+		if mh.(*MatchHandler).QueueSignal(ctx, resultCh, data) {
+			queued++
+		}
+	}
+
+	var count int
+	for i := 0; i < queued; i++ {
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return count, ErrMatchSignalTimeout
+			}
+			return count, ctx.Err()
+		case result := <-resultCh:
+			if result.Success {
+				count++
+			}
+		}
+	}
+
+	return count, nil
+}
+
+func (r *LocalMatchRegistry) GetMatchState(ctx context.Context, id string) (string, bool, error) {
+	// Validate the match ID.
+	idComponents := strings.SplitN(id, ".", 2)
+	if len(idComponents) != 2 {
+		return "", false, ErrMatchIdInvalid
+	}
+	matchID, err := uuid.FromString(idComponents[0])
+	if err != nil {
+		return "", false, ErrMatchIdInvalid
+	}
+
+	// Only authoritative matches hosted on this node have state to inspect.
+	if idComponents[1] != r.node {
+		return "", false, ErrMatchNotFound
+	}
+
+	mh, ok := r.matches.Load(matchID)
+	if !ok {
+		return "", false, ErrMatchNotFound
+	}
+
+	// Snapshots are requested through the match signal handler, processed in sequence with the match's other calls.
+	resultCh := make(chan *MatchSignalResult, 1)
+	if !mh.(*MatchHandler).QueueGetState(ctx, resultCh) {
+		return "", false, ErrMatchNotFound
+	}
+
+	select {
+	case <-ctx.Done():
+		return "", false, ctx.Err()
+	case result := <-resultCh:
+		if !result.Success {
+			return "", false, ErrMatchNotFound
+		}
+		return result.Result, result.Result != "", nil
+	}
+}
+
 func (r *LocalMatchRegistry) UpdateMatchLabel(id uuid.UUID, label string) error {
 	if len(label) > MatchLabelMaxBytes {
 		return ErrMatchLabelTooLong
@@ -274,6 +461,24 @@ func (r *LocalMatchRegistry) UpdateMatchLabel(id uuid.UUID, label string) error
 	})
 }
 
+// EmitMatchLabelUpdateEvent reports an authoritative match label change as an event, if enabled in the match
+// configuration. Updates that leave the label unchanged are not reported.
+func EmitMatchLabelUpdateEvent(ctx context.Context, config Config, eventFn RuntimeEventCustomFunction, matchID, previous, label string) {
+	if eventFn == nil || !config.GetMatch().LabelUpdateEvents || previous == label {
+		return
+	}
+
+	eventFn(ctx, &api.Event{
+		Name: MatchLabelUpdateEventName,
+		Properties: map[string]string{
+			"match_id": matchID,
+			"previous": previous,
+			"label":    label,
+		},
+		Timestamp: &timestamp.Timestamp{Seconds: time.Now().UTC().Unix()},
+	})
+}
+
 func (r *LocalMatchRegistry) ListMatches(ctx context.Context, limit int, authoritative *wrappers.BoolValue, label *wrappers.StringValue, minSize *wrappers.Int32Value, maxSize *wrappers.Int32Value, queryString *wrappers.StringValue) ([]*api.Match, error) {
 	if limit == 0 {
 		return make([]*api.Match, 0), nil
@@ -454,6 +659,139 @@ func (r *LocalMatchRegistry) ListMatches(ctx context.Context, limit int, authori
 	return results, nil
 }
 
+func (r *LocalMatchRegistry) ListMatchesSorted(ctx context.Context, limit int, sortBy string, authoritative *wrappers.BoolValue, label *wrappers.StringValue, minSize *wrappers.Int32Value, maxSize *wrappers.Int32Value, queryString *wrappers.StringValue) ([]*api.Match, error) {
+	switch sortBy {
+	case "":
+		return r.ListMatches(ctx, limit, authoritative, label, minSize, maxSize, queryString)
+	case MatchListSortSizeAsc, MatchListSortSizeDesc, MatchListSortAgeAsc, MatchListSortAgeDesc:
+	default:
+		return nil, ErrMatchListSortInvalid
+	}
+
+	if limit == 0 {
+		return make([]*api.Match, 0), nil
+	}
+
+	// Sorting must consider every eligible match, not just the first ones found, for the limit to be meaningful.
+	count := int(r.matchCount.Load()) + len(r.tracker.CountByStreamModeFilter(MatchFilterRelayed))
+	if count == 0 {
+		return make([]*api.Match, 0), nil
+	}
+	results, err := r.ListMatches(ctx, count, authoritative, label, minSize, maxSize, queryString)
+	if err != nil {
+		return nil, err
+	}
+
+	var createTimes map[string]int64
+	if sortBy == MatchListSortAgeAsc || sortBy == MatchListSortAgeDesc {
+		createTimes = make(map[string]int64, len(results))
+		for _, result := range results {
+			if !result.Authoritative {
+				continue
+			}
+			if mh, ok := r.matches.Load(uuid.FromStringOrNil(strings.SplitN(result.MatchId, ".", 2)[0])); ok {
+				createTimes[result.MatchId] = mh.(*MatchHandler).CreateTime
+			}
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		switch sortBy {
+		case MatchListSortSizeAsc:
+			if a.Size != b.Size {
+				return a.Size < b.Size
+			}
+		case MatchListSortSizeDesc:
+			if a.Size != b.Size {
+				return a.Size > b.Size
+			}
+		case MatchListSortAgeAsc:
+			if createTimes[a.MatchId] != createTimes[b.MatchId] {
+				return createTimes[a.MatchId] > createTimes[b.MatchId]
+			}
+		case MatchListSortAgeDesc:
+			if createTimes[a.MatchId] != createTimes[b.MatchId] {
+				return createTimes[a.MatchId] < createTimes[b.MatchId]
+			}
+		}
+		// Keep the ordering of otherwise equal matches consistent between listings.
+		return a.MatchId < b.MatchId
+	})
+
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+func (r *LocalMatchRegistry) GetRandomMatch(ctx context.Context, queryString string, minSpace int) (*api.Match, error) {
+	if r.matchCount.Load() == 0 {
+		return nil, nil
+	}
+
+	var q query.Query
+	if queryString == "" {
+		q = bleve.NewMatchAllQuery()
+	} else {
+		q = bleve.NewQueryStringQuery(queryString)
+	}
+
+	// Count the matching entries without loading any of them.
+	countReq := bleve.NewSearchRequestOptions(q, 0, 0, false)
+	countResults, err := r.index.SearchInContext(ctx, countReq)
+	if err != nil {
+		return nil, fmt.Errorf("error finding random match by query: %v", err.Error())
+	}
+	total := int(countResults.Total)
+	if total == 0 {
+		return nil, nil
+	}
+
+	// Load one entry at a time starting from a random offset, moving on to the next entry until one has enough room.
+	start := rand.Intn(total)
+	for i := 0; i < total; i++ {
+		searchReq := bleve.NewSearchRequestOptions(q, 1, (start+i)%total, false)
+		searchReq.Fields = []string{"label_string"}
+		labelResults, err := r.index.SearchInContext(ctx, searchReq)
+		if err != nil {
+			return nil, fmt.Errorf("error finding random match by query: %v", err.Error())
+		}
+		if len(labelResults.Hits) == 0 {
+			// Matches ended since the entries were counted.
+			continue
+		}
+		hit := labelResults.Hits[0]
+
+		mh, ok := r.matches.Load(uuid.FromStringOrNil(strings.SplitN(hit.ID, ".", 2)[0]))
+		if !ok {
+			continue
+		}
+		presenceList := mh.(*MatchHandler).PresenceList
+		size := presenceList.Size()
+
+		// Places reserved for sessions count as taken, matches that do not set a maximum size always have room.
+		if maxSize := presenceList.MaxSize(); maxSize > 0 && maxSize-size-presenceList.Reservations() < minSpace {
+			continue
+		}
+
+		labelString, ok := hit.Fields["label_string"].(string)
+		if !ok {
+			r.logger.Warn("Field not found in match registry label cache: label_string")
+			continue
+		}
+
+		return &api.Match{
+			MatchId:       hit.ID,
+			Authoritative: true,
+			Label:         &wrappers.StringValue{Value: labelString},
+			Size:          int32(size),
+		}, nil
+	}
+
+	return nil, nil
+}
+
 func (r *LocalMatchRegistry) Stop(graceSeconds int) chan struct{} {
 	// Mark the match registry as stopped, but allow further calls here to signal periodic termination to any matches still running.
 	r.stopped.Store(true)
@@ -559,11 +897,11 @@ func (r *LocalMatchRegistry) Kick(stream PresenceStream, presences []*MatchPrese
 		if presence.Node != r.node {
 			continue
 		}
-		r.tracker.Untrack(presence.SessionID, stream, presence.UserID)
+		r.tracker.Untrack(presence.SessionID, stream, presence.UserID, PresenceReasonKick)
 	}
 }
 
-func (r *LocalMatchRegistry) SendData(id uuid.UUID, node string, userID, sessionID uuid.UUID, username, fromNode string, opCode int64, data []byte, reliable bool, receiveTime int64) {
+func (r *LocalMatchRegistry) SendData(id uuid.UUID, node string, userID, sessionID uuid.UUID, username, fromNode string, opCode int64, data []byte, reliable bool, receiveTime int64, seq uint64) {
 	if node != r.node {
 		return
 	}
@@ -582,5 +920,6 @@ func (r *LocalMatchRegistry) SendData(id uuid.UUID, node string, userID, session
 		Data:        data,
 		Reliable:    reliable,
 		ReceiveTime: receiveTime,
+		Seq:         seq,
 	})
 }
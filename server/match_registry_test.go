@@ -16,10 +16,22 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"database/sql"
 	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
 	"github.com/gofrs/uuid"
+	"github.com/heroiclabs/nakama-common/api"
+	"github.com/heroiclabs/nakama-common/rtapi"
 	"github.com/heroiclabs/nakama-common/runtime"
-	"testing"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 func TestEncode(t *testing.T) {
@@ -33,3 +45,1000 @@ func TestEncode(t *testing.T) {
 	}
 	t.Log("ok")
 }
+
+// A minimal match that accepts all joins and leaves its state untouched. Tests embed it in small handlers overriding
+// only the callbacks they exercise.
+type testMatch struct{}
+
+func (m *testMatch) MatchInit(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, params map[string]interface{}) (interface{}, int, string) {
+	label := "test"
+	if l, ok := params["label"].(string); ok {
+		label = l
+	}
+	return map[string]interface{}{"label": label}, 10, label
+}
+func (m *testMatch) MatchJoinAttempt(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, dispatcher runtime.MatchDispatcher, tick int64, state interface{}, presence runtime.Presence, metadata map[string]string) (interface{}, bool, string) {
+	return state, true, ""
+}
+func (m *testMatch) MatchJoin(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, dispatcher runtime.MatchDispatcher, tick int64, state interface{}, presences []runtime.Presence) interface{} {
+	return state
+}
+func (m *testMatch) MatchLeave(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, dispatcher runtime.MatchDispatcher, tick int64, state interface{}, presences []runtime.Presence) interface{} {
+	return state
+}
+func (m *testMatch) MatchLoop(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, dispatcher runtime.MatchDispatcher, tick int64, state interface{}, messages []runtime.MatchData) interface{} {
+	return state
+}
+func (m *testMatch) MatchTerminate(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, dispatcher runtime.MatchDispatcher, tick int64, state interface{}, graceSeconds int) interface{} {
+	return state
+}
+func (m *testMatch) MatchSignal(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, dispatcher runtime.MatchDispatcher, tick int64, state interface{}, data string) (interface{}, string) {
+	return state, ""
+}
+
+// Reports the grace period of each terminate call.
+type testTerminateMatch struct {
+	testMatch
+	terminateCh chan int
+}
+
+func (m *testTerminateMatch) MatchTerminate(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, dispatcher runtime.MatchDispatcher, tick int64, state interface{}, graceSeconds int) interface{} {
+	m.terminateCh <- graceSeconds
+	return state
+}
+
+// Reports the presences of each join call.
+type testJoinMatch struct {
+	testMatch
+	joinCh chan []runtime.Presence
+}
+
+func (m *testJoinMatch) MatchJoin(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, dispatcher runtime.MatchDispatcher, tick int64, state interface{}, presences []runtime.Presence) interface{} {
+	m.joinCh <- presences
+	return state
+}
+
+// Sets the max size given in its params on its first loop.
+type testMaxSizeMatch struct {
+	testMatch
+	readyCh chan struct{}
+}
+
+func (m *testMaxSizeMatch) MatchInit(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, params map[string]interface{}) (interface{}, int, string) {
+	state, tickRate, label := m.testMatch.MatchInit(ctx, logger, db, nk, params)
+	state.(map[string]interface{})["max_size"] = params["max_size"]
+	return state, tickRate, label
+}
+func (m *testMaxSizeMatch) MatchLoop(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, dispatcher runtime.MatchDispatcher, tick int64, state interface{}, messages []runtime.MatchData) interface{} {
+	s := state.(map[string]interface{})
+	if maxSize, ok := s["max_size"].(int); ok {
+		if err := dispatcher.(*RuntimeGoMatchCore).MatchMaxSize(maxSize); err != nil {
+			return nil
+		}
+		delete(s, "max_size")
+		m.readyCh <- struct{}{}
+	}
+	return state
+}
+
+// Sets the max size given in its params on its first loop, and reports the presences of each join call.
+type testReservedJoinMatch struct {
+	testMaxSizeMatch
+	joinCh chan []runtime.Presence
+}
+
+func (m *testReservedJoinMatch) MatchJoin(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, dispatcher runtime.MatchDispatcher, tick int64, state interface{}, presences []runtime.Presence) interface{} {
+	m.joinCh <- presences
+	return state
+}
+
+// Reports the presences of each leave call.
+type testLeaveMatch struct {
+	testMatch
+	leaveCh chan []runtime.Presence
+}
+
+func (m *testLeaveMatch) MatchLeave(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, dispatcher runtime.MatchDispatcher, tick int64, state interface{}, presences []runtime.Presence) interface{} {
+	m.leaveCh <- presences
+	return state
+}
+
+// Logs on every loop.
+type testLogMatch struct {
+	testMatch
+}
+
+func (m *testLogMatch) MatchLoop(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, dispatcher runtime.MatchDispatcher, tick int64, state interface{}, messages []runtime.MatchData) interface{} {
+	logger.Info("match loop")
+	return state
+}
+
+// Reports the messages of each loop call.
+type testLoopMatch struct {
+	testMatch
+	loopCh chan []runtime.MatchData
+}
+
+func (m *testLoopMatch) MatchLoop(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, dispatcher runtime.MatchDispatcher, tick int64, state interface{}, messages []runtime.MatchData) interface{} {
+	m.loopCh <- messages
+	return state
+}
+
+// Reports each signal received along with the match label.
+type testSignalMatch struct {
+	testMatch
+	signalCh chan string
+}
+
+func (m *testSignalMatch) MatchSignal(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, dispatcher runtime.MatchDispatcher, tick int64, state interface{}, data string) (interface{}, string) {
+	m.signalCh <- state.(map[string]interface{})["label"].(string) + ":" + data
+	return state, data
+}
+
+// Reports each signal received along with the match label, then takes a second to process it.
+type testSlowSignalMatch struct {
+	testSignalMatch
+}
+
+func (m *testSlowSignalMatch) MatchSignal(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, dispatcher runtime.MatchDispatcher, tick int64, state interface{}, data string) (interface{}, string) {
+	state, result := m.testSignalMatch.MatchSignal(ctx, logger, db, nk, dispatcher, tick, state, data)
+	time.Sleep(time.Second)
+	return state, result
+}
+
+func newTestMatchRegistry(matches map[string]runtime.Match) (MatchRegistry, Tracker, RuntimeMatchCreateFunction) {
+	sessionRegistry := NewLocalSessionRegistry(metrics)
+	tracker := StartLocalTracker(logger, cfg, sessionRegistry, metrics, jsonpbMarshaler)
+	router := NewLocalMessageRouter(sessionRegistry, tracker, jsonpbMarshaler)
+	matchRegistry := NewLocalMatchRegistry(logger, logger, cfg, sessionRegistry, tracker, router, metrics, cfg.GetName())
+	tracker.SetMatchJoinListener(matchRegistry.Join)
+	tracker.SetMatchLeaveListener(matchRegistry.Leave)
+
+	createFn := func(ctx context.Context, logger *zap.Logger, id uuid.UUID, node string, stopped *atomic.Bool, name string) (RuntimeMatchCore, error) {
+		match, ok := matches[name]
+		if !ok {
+			return nil, nil
+		}
+		return NewRuntimeGoMatchCore(logger, cfg, matchRegistry, metrics, router, id, node, stopped, nil, nil, nil, match)
+	}
+
+	return matchRegistry, tracker, createFn
+}
+
+func TestMatchRegistryTerminateMatch(t *testing.T) {
+	match := &testTerminateMatch{terminateCh: make(chan int, 1)}
+	matchRegistry, _, createFn := newTestMatchRegistry(map[string]runtime.Match{"test": match})
+
+	id, err := matchRegistry.CreateMatch(context.Background(), logger, createFn, "test", nil)
+	if err != nil {
+		t.Fatalf("error creating match: %v", err)
+	}
+
+	if err := matchRegistry.TerminateMatch(context.Background(), "invalid", 0); err != ErrMatchIdInvalid {
+		t.Fatalf("expected invalid match id error, got: %v", err)
+	}
+	if err := matchRegistry.TerminateMatch(context.Background(), id, -1); err != ErrMatchGraceInvalid {
+		t.Fatalf("expected invalid grace seconds error, got: %v", err)
+	}
+
+	if err := matchRegistry.TerminateMatch(context.Background(), id, 0); err != nil {
+		t.Fatalf("error terminating match: %v", err)
+	}
+
+	select {
+	case graceSeconds := <-match.terminateCh:
+		if graceSeconds != 0 {
+			t.Fatalf("expected grace seconds 0, got: %v", graceSeconds)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected match terminate to be called")
+	}
+
+	for i := 0; i < 100; i++ {
+		m, err := matchRegistry.GetMatch(context.Background(), id)
+		if err != nil {
+			t.Fatalf("error getting match: %v", err)
+		}
+		if m == nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("expected match to be stopped")
+}
+
+func TestMatchRegistryLeaveReason(t *testing.T) {
+	match := &testLeaveMatch{leaveCh: make(chan []runtime.Presence, 2)}
+	matchRegistry, tracker, createFn := newTestMatchRegistry(map[string]runtime.Match{"test": match})
+
+	id, err := matchRegistry.CreateMatch(context.Background(), logger, createFn, "test", nil)
+	if err != nil {
+		t.Fatalf("error creating match: %v", err)
+	}
+	matchID := uuid.FromStringOrNil(id[:36])
+	stream := PresenceStream{Mode: StreamModeMatchAuthoritative, Subject: matchID, Label: cfg.GetName()}
+
+	kicked := &MatchPresence{Node: cfg.GetName(), UserID: uuid.Must(uuid.NewV4()), SessionID: uuid.Must(uuid.NewV4()), Username: "kicked"}
+	left := &MatchPresence{Node: cfg.GetName(), UserID: uuid.Must(uuid.NewV4()), SessionID: uuid.Must(uuid.NewV4()), Username: "left"}
+	for _, p := range []*MatchPresence{kicked, left} {
+		found, allow, _, _, _, _ := matchRegistry.JoinAttempt(context.Background(), matchID, cfg.GetName(), p.UserID, p.SessionID, p.Username, 0, nil, "", "", cfg.GetName(), nil)
+		if !found || !allow {
+			t.Fatalf("expected join attempt to be allowed")
+		}
+		tracker.Track(p.SessionID, stream, p.UserID, PresenceMeta{Username: p.Username}, true)
+	}
+
+	matchRegistry.Kick(stream, []*MatchPresence{kicked})
+	tracker.Untrack(left.SessionID, stream, left.UserID, PresenceReasonLeave)
+
+	reasons := make(map[string]PresenceReason, 2)
+	for len(reasons) < 2 {
+		select {
+		case presences := <-match.leaveCh:
+			for _, p := range presences {
+				reasons[p.GetUsername()] = p.(*MatchPresence).GetReason()
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected match leave to be called")
+		}
+	}
+
+	if reasons["kicked"] != PresenceReasonKick {
+		t.Fatalf("expected kick reason, got: %v", reasons["kicked"])
+	}
+	if reasons["left"] != PresenceReasonLeave {
+		t.Fatalf("expected leave reason, got: %v", reasons["left"])
+	}
+}
+
+func TestMatchRegistryJoinMetadata(t *testing.T) {
+	match := &testJoinMatch{joinCh: make(chan []runtime.Presence, 2)}
+	matchRegistry, _, createFn := newTestMatchRegistry(map[string]runtime.Match{"test": match})
+
+	id, err := matchRegistry.CreateMatch(context.Background(), logger, createFn, "test", nil)
+	if err != nil {
+		t.Fatalf("error creating match: %v", err)
+	}
+	defer matchRegistry.TerminateMatch(context.Background(), id, 0)
+	matchID := uuid.FromStringOrNil(id[:36])
+
+	withMetadata := &MatchPresence{Node: cfg.GetName(), UserID: uuid.Must(uuid.NewV4()), SessionID: uuid.Must(uuid.NewV4()), Username: "with"}
+	withoutMetadata := &MatchPresence{Node: cfg.GetName(), UserID: uuid.Must(uuid.NewV4()), SessionID: uuid.Must(uuid.NewV4()), Username: "without"}
+	for p, metadata := range map[*MatchPresence]map[string]string{withMetadata: {"team": "red"}, withoutMetadata: nil} {
+		found, allow, _, _, _, _ := matchRegistry.JoinAttempt(context.Background(), matchID, cfg.GetName(), p.UserID, p.SessionID, p.Username, 0, nil, "", "", cfg.GetName(), metadata)
+		if !found || !allow {
+			t.Fatalf("expected join attempt to be allowed")
+		}
+	}
+
+	metadata := make(map[string]map[string]string, 2)
+	for len(metadata) < 2 {
+		select {
+		case presences := <-match.joinCh:
+			for _, p := range presences {
+				metadata[p.GetUsername()] = p.(*MatchPresence).GetMetadata()
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected match join to be called")
+		}
+	}
+
+	if metadata["with"]["team"] != "red" {
+		t.Fatalf("expected join metadata in match join, got: %v", metadata["with"])
+	}
+	if metadata["without"] != nil {
+		t.Fatalf("expected no join metadata in match join, got: %v", metadata["without"])
+	}
+}
+
+func TestMatchRegistryLoggerFields(t *testing.T) {
+	match := &testLogMatch{}
+	matchRegistry, _, createFn := newTestMatchRegistry(map[string]runtime.Match{"test": match})
+
+	core, logs := observer.New(zap.InfoLevel)
+	id, err := matchRegistry.CreateMatch(context.Background(), zap.New(core), createFn, "test", nil)
+	if err != nil {
+		t.Fatalf("error creating match: %v", err)
+	}
+	defer matchRegistry.TerminateMatch(context.Background(), id, 0)
+
+	var loopLogs []observer.LoggedEntry
+	for i := 0; i < 100; i++ {
+		loopLogs = logs.FilterMessage("match loop").All()
+		if len(loopLogs) >= 2 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if len(loopLogs) < 2 {
+		t.Fatalf("expected at least 2 match loop logs, got: %v", len(loopLogs))
+	}
+
+	for i, entry := range loopLogs[:2] {
+		fields := entry.ContextMap()
+		if fields["match_id"] != id {
+			t.Fatalf("expected match_id field %v, got: %v", id, fields["match_id"])
+		}
+		// Ticks start at 0 for the first loop and increase by one each loop.
+		if fields["tick"] != int64(i) {
+			t.Fatalf("expected tick field %v, got: %v", i, fields["tick"])
+		}
+	}
+}
+
+func TestMatchRegistrySignalMatchesByLabel(t *testing.T) {
+	match := &testSignalMatch{signalCh: make(chan string, 10)}
+	matchRegistry, _, createFn := newTestMatchRegistry(map[string]runtime.Match{"test": match})
+
+	labels := []string{`{"mode":"ffa"}`, `{"mode":"ffa"}`, `{"mode":"ffa"}`, `{"mode":"teams"}`, `{"mode":"teams"}`}
+	for _, label := range labels {
+		id, err := matchRegistry.CreateMatch(context.Background(), logger, createFn, "test", map[string]interface{}{"label": label})
+		if err != nil {
+			t.Fatalf("error creating match: %v", err)
+		}
+		defer matchRegistry.TerminateMatch(context.Background(), id, 0)
+	}
+
+	if _, err := matchRegistry.SignalMatchesByLabel(context.Background(), "+label.mode:ffa", "maintenance", MatchSignalMaxMatches+1, 0); err != ErrMatchSignalLimit {
+		t.Fatalf("expected signal limit error, got: %v", err)
+	}
+	if _, err := matchRegistry.SignalMatchesByLabel(context.Background(), "+label.mode:ffa", MatchGetStateSignal, MatchSignalMaxMatches, 0); err != ErrMatchSignalReserved {
+		t.Fatalf("expected reserved signal error, got: %v", err)
+	}
+
+	count, err := matchRegistry.SignalMatchesByLabel(context.Background(), "+label.mode:ffa", "maintenance", MatchSignalMaxMatches, 0)
+	if err != nil {
+		t.Fatalf("error signalling matches: %v", err)
+	}
+	if count != 3 {
+		t.Fatalf("expected 3 matches signalled, got: %v", count)
+	}
+	if len(match.signalCh) != 3 {
+		t.Fatalf("expected 3 signals received, got: %v", len(match.signalCh))
+	}
+	for i := 0; i < 3; i++ {
+		if signal := <-match.signalCh; signal != `{"mode":"ffa"}:maintenance` {
+			t.Fatalf("expected signal only to matching matches, got: %v", signal)
+		}
+	}
+
+	// The fan-out is bounded by the limit.
+	count, err = matchRegistry.SignalMatchesByLabel(context.Background(), "+label.mode:ffa", "maintenance", 2, 0)
+	if err != nil {
+		t.Fatalf("error signalling matches: %v", err)
+	}
+	if count != 2 {
+		t.Fatalf("expected 2 matches signalled, got: %v", count)
+	}
+}
+
+func TestMatchRegistrySignalMatchesByLabelTimeout(t *testing.T) {
+	match := &testSlowSignalMatch{testSignalMatch{signalCh: make(chan string, 10)}}
+	matchRegistry, _, createFn := newTestMatchRegistry(map[string]runtime.Match{"test": match})
+
+	id, err := matchRegistry.CreateMatch(context.Background(), logger, createFn, "test", map[string]interface{}{"label": `{"mode":"ffa"}`})
+	if err != nil {
+		t.Fatalf("error creating match: %v", err)
+	}
+	defer matchRegistry.TerminateMatch(context.Background(), id, 0)
+
+	// The slow match handler receives the signal, but the caller stops waiting once the timeout expires.
+	start := time.Now()
+	count, err := matchRegistry.SignalMatchesByLabel(context.Background(), "+label.mode:ffa", "maintenance", MatchSignalMaxMatches, 100*time.Millisecond)
+	elapsed := time.Since(start)
+	assert.Equal(t, ErrMatchSignalTimeout, err)
+	assert.Equal(t, 0, count)
+	assert.True(t, elapsed < time.Second, "expected signal to time out before the match responded, took %v", elapsed)
+
+	select {
+	case signal := <-match.signalCh:
+		assert.Equal(t, `{"mode":"ffa"}:maintenance`, signal)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected match signal to be called")
+	}
+}
+
+// Answers the get state signal with a JSON snapshot of its state.
+type testGetStateMatch struct {
+	testMatch
+}
+
+func (m *testGetStateMatch) MatchSignal(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, dispatcher runtime.MatchDispatcher, tick int64, state interface{}, data string) (interface{}, string) {
+	if data != MatchGetStateSignal {
+		return state, ""
+	}
+	snapshot, err := json.Marshal(state)
+	if err != nil {
+		return state, ""
+	}
+	// Any state returned for a get state signal is discarded, so returning none must not stop the match.
+	return nil, string(snapshot)
+}
+
+func TestMatchRegistryGetMatchState(t *testing.T) {
+	matchRegistry, _, createFn := newTestMatchRegistry(map[string]runtime.Match{"snapshot": &testGetStateMatch{}})
+
+	id, err := matchRegistry.CreateMatch(context.Background(), logger, createFn, "snapshot", map[string]interface{}{"label": "running"})
+	if err != nil {
+		t.Fatalf("error creating match: %v", err)
+	}
+	defer matchRegistry.TerminateMatch(context.Background(), id, 0)
+
+	for i := 0; i < 2; i++ {
+		snapshot, ok, err := matchRegistry.GetMatchState(context.Background(), id)
+		if err != nil {
+			t.Fatalf("error getting match state: %v", err)
+		}
+		assert.True(t, ok, "expected match to provide a snapshot")
+		assert.JSONEq(t, `{"label":"running"}`, snapshot)
+	}
+
+	if _, _, err := matchRegistry.GetMatchState(context.Background(), "invalid"); err != ErrMatchIdInvalid {
+		t.Fatalf("expected invalid match id error, got: %v", err)
+	}
+	if _, _, err := matchRegistry.GetMatchState(context.Background(), uuid.Must(uuid.NewV4()).String()+"."+cfg.GetName()); err != ErrMatchNotFound {
+		t.Fatalf("expected match not found error, got: %v", err)
+	}
+}
+
+func TestMatchRegistryListMatchesSorted(t *testing.T) {
+	matchRegistry, tracker, createFn := newTestMatchRegistry(map[string]runtime.Match{"test": &testMatch{}})
+
+	// Create matches oldest to newest, each with a different number of participants.
+	sizes := map[string]int{"a": 0, "b": 2, "c": 1}
+	ids := make(map[string]string, len(sizes))
+	for _, label := range []string{"a", "b", "c"} {
+		id, err := matchRegistry.CreateMatch(context.Background(), logger, createFn, "test", map[string]interface{}{"label": label})
+		if err != nil {
+			t.Fatalf("error creating match: %v", err)
+		}
+		defer matchRegistry.TerminateMatch(context.Background(), id, 0)
+		ids[label] = id
+
+		matchID := uuid.FromStringOrNil(id[:36])
+		stream := PresenceStream{Mode: StreamModeMatchAuthoritative, Subject: matchID, Label: cfg.GetName()}
+		for i := 0; i < sizes[label]; i++ {
+			p := &MatchPresence{Node: cfg.GetName(), UserID: uuid.Must(uuid.NewV4()), SessionID: uuid.Must(uuid.NewV4()), Username: fmt.Sprintf("%v%v", label, i)}
+			found, allow, _, _, _, _ := matchRegistry.JoinAttempt(context.Background(), matchID, cfg.GetName(), p.UserID, p.SessionID, p.Username, 0, nil, "", "", cfg.GetName(), nil)
+			if !found || !allow {
+				t.Fatalf("expected join attempt to be allowed")
+			}
+			tracker.Track(p.SessionID, stream, p.UserID, PresenceMeta{Username: p.Username}, true)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Wait for all joins to be processed by the match handlers.
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		matches, err := matchRegistry.ListMatches(context.Background(), 10, nil, nil, nil, nil, nil)
+		if err != nil {
+			t.Fatalf("error listing matches: %v", err)
+		}
+		total := 0
+		for _, match := range matches {
+			total += int(match.Size)
+		}
+		if total == 3 {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("expected match joins to be processed")
+		}
+	}
+
+	labels := func(matches []*api.Match) []string {
+		result := make([]string, 0, len(matches))
+		for _, match := range matches {
+			result = append(result, match.Label.Value)
+		}
+		return result
+	}
+
+	for sortBy, expected := range map[string][]string{
+		MatchListSortSizeAsc:  {"a", "c", "b"},
+		MatchListSortSizeDesc: {"b", "c", "a"},
+		MatchListSortAgeAsc:   {"c", "b", "a"},
+		MatchListSortAgeDesc:  {"a", "b", "c"},
+	} {
+		matches, err := matchRegistry.ListMatchesSorted(context.Background(), 10, sortBy, nil, nil, nil, nil, nil)
+		if err != nil {
+			t.Fatalf("error listing matches: %v", err)
+		}
+		assert.Equal(t, expected, labels(matches), sortBy)
+
+		// The sort is applied before the limit.
+		matches, err = matchRegistry.ListMatchesSorted(context.Background(), 1, sortBy, nil, nil, nil, nil, nil)
+		if err != nil {
+			t.Fatalf("error listing matches: %v", err)
+		}
+		assert.Equal(t, expected[:1], labels(matches), sortBy)
+	}
+
+	_, err := matchRegistry.ListMatchesSorted(context.Background(), 10, "label_asc", nil, nil, nil, nil, nil)
+	assert.Equal(t, ErrMatchListSortInvalid, err)
+}
+
+func TestMatchRegistryGetRandomMatch(t *testing.T) {
+	match := &testMaxSizeMatch{readyCh: make(chan struct{}, 5)}
+	matchRegistry, tracker, createFn := newTestMatchRegistry(map[string]runtime.Match{"test": match})
+
+	result, err := matchRegistry.GetRandomMatch(context.Background(), "", 1)
+	if err != nil {
+		t.Fatalf("error getting random match: %v", err)
+	}
+	assert.Nil(t, result)
+
+	// Seed full and non-full matches, one filled by reservations, and one in another mode.
+	matches := []struct {
+		label    string
+		maxSize  int
+		size     int
+		reserved int
+	}{
+		{`{"mode":"ffa","name":"full1"}`, 2, 2, 0},
+		{`{"mode":"ffa","name":"full2"}`, 1, 1, 0},
+		{`{"mode":"ffa","name":"reserved"}`, 3, 1, 2},
+		{`{"mode":"ffa","name":"open"}`, 4, 2, 0},
+		{`{"mode":"teams","name":"other"}`, 4, 0, 0},
+	}
+	for _, m := range matches {
+		reservedSessions := make([]uuid.UUID, 0, m.reserved)
+		for i := 0; i < m.reserved; i++ {
+			reservedSessions = append(reservedSessions, uuid.Must(uuid.NewV4()))
+		}
+		id, err := matchRegistry.CreateMatchWithReservations(context.Background(), logger, createFn, "test", map[string]interface{}{"label": m.label, "max_size": m.maxSize}, reservedSessions)
+		if err != nil {
+			t.Fatalf("error creating match: %v", err)
+		}
+		defer matchRegistry.TerminateMatch(context.Background(), id, 0)
+		select {
+		case <-match.readyCh:
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected match max size to be set")
+		}
+
+		matchID := uuid.FromStringOrNil(id[:36])
+		stream := PresenceStream{Mode: StreamModeMatchAuthoritative, Subject: matchID, Label: cfg.GetName()}
+		for i := 0; i < m.size; i++ {
+			p := &MatchPresence{Node: cfg.GetName(), UserID: uuid.Must(uuid.NewV4()), SessionID: uuid.Must(uuid.NewV4()), Username: fmt.Sprintf("%v%v", m.label, i)}
+			found, allow, _, _, _, _ := matchRegistry.JoinAttempt(context.Background(), matchID, cfg.GetName(), p.UserID, p.SessionID, p.Username, 0, nil, "", "", cfg.GetName(), nil)
+			if !found || !allow {
+				t.Fatalf("expected join attempt to be allowed")
+			}
+			tracker.Track(p.SessionID, stream, p.UserID, PresenceMeta{Username: p.Username}, true)
+		}
+	}
+
+	// Wait for all joins to be processed by the match handlers.
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		listed, err := matchRegistry.ListMatches(context.Background(), 10, nil, nil, nil, nil, nil)
+		if err != nil {
+			t.Fatalf("error listing matches: %v", err)
+		}
+		total := 0
+		for _, m := range listed {
+			total += int(m.Size)
+		}
+		if total == 6 {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("expected match joins to be processed")
+		}
+	}
+
+	// Only the match with room left is ever picked.
+	for i := 0; i < 20; i++ {
+		result, err = matchRegistry.GetRandomMatch(context.Background(), "+label.mode:ffa", 1)
+		if err != nil {
+			t.Fatalf("error getting random match: %v", err)
+		}
+		if assert.NotNil(t, result) {
+			assert.Equal(t, `{"mode":"ffa","name":"open"}`, result.Label.Value)
+			assert.Equal(t, int32(2), result.Size)
+		}
+	}
+
+	// Not enough room for the requested space.
+	result, err = matchRegistry.GetRandomMatch(context.Background(), "+label.mode:ffa", 3)
+	if err != nil {
+		t.Fatalf("error getting random match: %v", err)
+	}
+	assert.Nil(t, result)
+}
+
+func TestMatchRegistryCreateMatchWithReservations(t *testing.T) {
+	match := &testReservedJoinMatch{testMaxSizeMatch: testMaxSizeMatch{readyCh: make(chan struct{}, 1)}, joinCh: make(chan []runtime.Presence, 3)}
+	matchRegistry, tracker, createFn := newTestMatchRegistry(map[string]runtime.Match{"test": match})
+
+	reserved := &MatchPresence{Node: cfg.GetName(), UserID: uuid.Must(uuid.NewV4()), SessionID: uuid.Must(uuid.NewV4()), Username: "reserved"}
+	id, err := matchRegistry.CreateMatchWithReservations(context.Background(), logger, createFn, "test", map[string]interface{}{"max_size": 2}, []uuid.UUID{reserved.SessionID})
+	if err != nil {
+		t.Fatalf("error creating match: %v", err)
+	}
+	defer matchRegistry.TerminateMatch(context.Background(), id, 0)
+	select {
+	case <-match.readyCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected match max size to be set")
+	}
+	matchID := uuid.FromStringOrNil(id[:36])
+	stream := PresenceStream{Mode: StreamModeMatchAuthoritative, Subject: matchID, Label: cfg.GetName()}
+
+	join := func(p *MatchPresence) (bool, string) {
+		found, allow, _, reason, _, _ := matchRegistry.JoinAttempt(context.Background(), matchID, cfg.GetName(), p.UserID, p.SessionID, p.Username, 0, nil, "", "", cfg.GetName(), nil)
+		if !found {
+			t.Fatalf("expected match to be found")
+		}
+		if allow {
+			tracker.Track(p.SessionID, stream, p.UserID, PresenceMeta{Username: p.Username}, true)
+		}
+		return allow, reason
+	}
+	waitForSize := func(size int32) {
+		for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+			m, err := matchRegistry.GetMatch(context.Background(), id)
+			if err != nil {
+				t.Fatalf("error getting match: %v", err)
+			}
+			if m.Size == size {
+				return
+			}
+			if time.Since(start) > 5*time.Second {
+				t.Fatal("expected match joins to be processed")
+			}
+		}
+	}
+
+	// One place is free for anyone, the other is held for the reserved session.
+	first := &MatchPresence{Node: cfg.GetName(), UserID: uuid.Must(uuid.NewV4()), SessionID: uuid.Must(uuid.NewV4()), Username: "first"}
+	allow, _ := join(first)
+	assert.True(t, allow)
+	waitForSize(1)
+
+	second := &MatchPresence{Node: cfg.GetName(), UserID: uuid.Must(uuid.NewV4()), SessionID: uuid.Must(uuid.NewV4()), Username: "second"}
+	allow, reason := join(second)
+	assert.False(t, allow)
+	assert.Equal(t, "match full", reason)
+
+	allow, _ = join(reserved)
+	assert.True(t, allow)
+	waitForSize(2)
+
+	// Once the reservation is used the match is full for everyone.
+	allow, _ = join(second)
+	assert.False(t, allow)
+
+	joined := make(map[string]bool, 2)
+	for len(joined) < 2 {
+		select {
+		case presences := <-match.joinCh:
+			for _, p := range presences {
+				joined[p.GetUsername()] = p.(interface{ GetReserved() bool }).GetReserved()
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected match join to be called")
+		}
+	}
+	assert.Equal(t, map[string]bool{"first": false, "reserved": true}, joined)
+}
+
+type recordingMessageRouter struct {
+	opCodeCh chan int64
+}
+
+func (r *recordingMessageRouter) SendToPresenceIDs(logger *zap.Logger, presenceIDs []*PresenceID, envelope *rtapi.Envelope, reliable bool) {
+	for range presenceIDs {
+		select {
+		case r.opCodeCh <- envelope.GetMatchData().OpCode:
+		default:
+		}
+	}
+}
+func (r *recordingMessageRouter) SendToStream(*zap.Logger, PresenceStream, *rtapi.Envelope, bool) {}
+func (r *recordingMessageRouter) SendDeferred(logger *zap.Logger, messages []*DeferredMessage) {
+	for _, message := range messages {
+		r.SendToPresenceIDs(logger, message.PresenceIDs, message.Envelope, message.Reliable)
+	}
+}
+
+// Interleaves deferred and immediate broadcasts on its first two loops, numbering op codes by loop.
+type testDeferredMatch struct {
+	testMatch
+	loops int64
+}
+
+func (m *testDeferredMatch) MatchLoop(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, dispatcher runtime.MatchDispatcher, tick int64, state interface{}, messages []runtime.MatchData) interface{} {
+	if m.loops < 2 {
+		base := m.loops * 10
+		_ = dispatcher.BroadcastMessageDeferred(base+1, nil, nil, nil, true)
+		_ = dispatcher.BroadcastMessage(base+2, nil, nil, nil, true)
+		_ = dispatcher.BroadcastMessageDeferred(base+3, nil, nil, nil, true)
+		m.loops++
+	}
+	return state
+}
+
+func TestMatchHandlerDeferredOrdering(t *testing.T) {
+	matchRegistry, _, _ := newTestMatchRegistry(map[string]runtime.Match{})
+	router := &recordingMessageRouter{opCodeCh: make(chan int64, 64)}
+
+	id := uuid.Must(uuid.NewV4())
+	stopped := atomic.NewBool(false)
+	core, err := NewRuntimeGoMatchCore(logger, cfg, matchRegistry, metrics, router, id, cfg.GetName(), stopped, nil, nil, nil, &testDeferredMatch{})
+	if err != nil {
+		t.Fatalf("error creating match core: %v", err)
+	}
+	mh, err := NewMatchHandler(logger, cfg, nil, matchRegistry, router, metrics, core, id, cfg.GetName(), stopped, nil, nil)
+	if err != nil {
+		t.Fatalf("error creating match handler: %v", err)
+	}
+	defer mh.Stop()
+
+	mh.PresenceList.Join([]*MatchPresence{{
+		Node:      cfg.GetName(),
+		UserID:    uuid.Must(uuid.NewV4()),
+		SessionID: uuid.Must(uuid.NewV4()),
+		Username:  "a",
+	}})
+
+	// On each tick immediate broadcasts go out first, then deferred broadcasts in the order they were queued,
+	// and everything from one tick is delivered before anything from the next.
+	expected := []int64{2, 1, 3, 12, 11, 13}
+	for i, opCode := range expected {
+		select {
+		case received := <-router.opCodeCh:
+			assert.Equal(t, opCode, received, "message %v out of order", i)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for message %v", i)
+		}
+	}
+}
+
+// Sends a final message and a deferred message when terminated.
+type testFinalMessageMatch struct {
+	testMatch
+}
+
+func (m *testFinalMessageMatch) MatchTerminate(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, dispatcher runtime.MatchDispatcher, tick int64, state interface{}, graceSeconds int) interface{} {
+	_ = dispatcher.(*RuntimeGoMatchCore).BroadcastFinalMessage(9, []byte("results"), nil, nil)
+	_ = dispatcher.BroadcastMessageDeferred(8, nil, nil, nil, true)
+	return state
+}
+
+func TestMatchHandlerTerminateFinalMessage(t *testing.T) {
+	for _, graceSeconds := range []int{0, 10} {
+		matchRegistry, _, _ := newTestMatchRegistry(map[string]runtime.Match{})
+		router := &recordingMessageRouter{opCodeCh: make(chan int64, 64)}
+
+		id := uuid.Must(uuid.NewV4())
+		stopped := atomic.NewBool(false)
+		core, err := NewRuntimeGoMatchCore(logger, cfg, matchRegistry, metrics, router, id, cfg.GetName(), stopped, nil, nil, nil, &testFinalMessageMatch{})
+		if err != nil {
+			t.Fatalf("error creating match core: %v", err)
+		}
+		mh, err := NewMatchHandler(logger, cfg, nil, matchRegistry, router, metrics, core, id, cfg.GetName(), stopped, nil, nil)
+		if err != nil {
+			t.Fatalf("error creating match handler: %v", err)
+		}
+
+		mh.PresenceList.Join([]*MatchPresence{{
+			Node:      cfg.GetName(),
+			UserID:    uuid.Must(uuid.NewV4()),
+			SessionID: uuid.Must(uuid.NewV4()),
+			Username:  "a",
+		}})
+
+		if !mh.QueueTerminate(graceSeconds) {
+			t.Fatal("expected terminate to be queued")
+		}
+		// Deferred broadcasts from terminate go out first.
+		select {
+		case received := <-router.opCodeCh:
+			assert.EqualValues(t, 8, received)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for deferred message")
+		}
+		if graceSeconds > 0 {
+			// The final message waits until the match stops at the end of the grace period.
+			select {
+			case received := <-router.opCodeCh:
+				t.Fatalf("unexpected message before match stopped: %v", received)
+			case <-time.After(100 * time.Millisecond):
+			}
+			if !mh.QueueStop() {
+				t.Fatal("expected stop to be queued")
+			}
+		}
+
+		select {
+		case received := <-router.opCodeCh:
+			assert.EqualValues(t, 9, received, "expected final message with grace seconds %v", graceSeconds)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for final message with grace seconds %v", graceSeconds)
+		}
+		assert.True(t, stopped.Load())
+	}
+}
+
+// How long testLoopDeltaMatch stalls its first tick for.
+const testLoopDeltaStall = 350 * time.Millisecond
+
+// Reports the loop delta on every loop, stalling on the first tick to delay the following ones.
+type testLoopDeltaMatch struct {
+	testMatch
+	deltaCh chan time.Duration
+}
+
+func (m *testLoopDeltaMatch) MatchLoop(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, dispatcher runtime.MatchDispatcher, tick int64, state interface{}, messages []runtime.MatchData) interface{} {
+	m.deltaCh <- dispatcher.(*RuntimeGoMatchCore).MatchLoopDelta()
+	if tick == 1 {
+		time.Sleep(testLoopDeltaStall)
+	}
+	return state
+}
+
+func TestMatchHandlerLoopDelta(t *testing.T) {
+	match := &testLoopDeltaMatch{deltaCh: make(chan time.Duration, 10)}
+	matchRegistry, _, createFn := newTestMatchRegistry(map[string]runtime.Match{"test": match})
+
+	start := time.Now()
+	id, err := matchRegistry.CreateMatch(context.Background(), logger, createFn, "test", nil)
+	if err != nil {
+		t.Fatalf("error creating match: %v", err)
+	}
+	defer matchRegistry.TerminateMatch(context.Background(), id, 0)
+
+	// Tick rate is 10, so loops are normally 100ms apart.
+	deltas := make([]time.Duration, 0, 4)
+	for len(deltas) < 4 {
+		select {
+		case delta := <-match.deltaCh:
+			deltas = append(deltas, delta)
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected match loop to be called")
+		}
+	}
+	elapsed := time.Since(start)
+
+	assert.True(t, deltas[1] >= 50*time.Millisecond && deltas[1] < testLoopDeltaStall, "expected regular delta, got %v", deltas[1])
+	// The loop after the stall reports the full real time elapsed, not the tick interval.
+	assert.True(t, deltas[2] >= testLoopDeltaStall, "expected delta to include stall, got %v", deltas[2])
+
+	// Deltas account for all real time elapsed across loops.
+	var total time.Duration
+	for _, delta := range deltas {
+		total += delta
+	}
+	assert.True(t, total <= elapsed, "expected total delta %v to be within elapsed %v", total, elapsed)
+	assert.True(t, total >= testLoopDeltaStall+200*time.Millisecond, "expected total delta to cover ticks and stall, got %v", total)
+}
+
+func TestMatchDedupFilter(t *testing.T) {
+	f := NewMatchDedupFilter(4)
+	sessionID := uuid.Must(uuid.NewV4())
+
+	// Nothing is dropped until enabled.
+	assert.True(t, f.Allow(sessionID, 1))
+	assert.True(t, f.Allow(sessionID, 1))
+
+	f.SetEnabled(true)
+	assert.True(t, f.Allow(sessionID, 1))
+	assert.False(t, f.Allow(sessionID, 1))
+	// Messages without a sequence number, or from other sessions, are not affected.
+	assert.True(t, f.Allow(sessionID, 0))
+	assert.True(t, f.Allow(sessionID, 0))
+	assert.True(t, f.Allow(uuid.Must(uuid.NewV4()), 1))
+
+	// Out of order sequence numbers within the window are accepted once.
+	assert.True(t, f.Allow(sessionID, 5))
+	assert.True(t, f.Allow(sessionID, 3))
+	assert.False(t, f.Allow(sessionID, 3))
+	// Sequence numbers behind the window are dropped.
+	assert.False(t, f.Allow(sessionID, 1))
+	assert.EqualValues(t, 3, f.Dropped())
+
+	// Leaving forgets the session.
+	f.Leave([]*MatchPresence{{SessionID: sessionID}})
+	assert.True(t, f.Allow(sessionID, 3))
+}
+
+func TestParseMatchDataSequence(t *testing.T) {
+	seq, err := parseMatchDataSequence("")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), seq, "no collation ID means no sequence number")
+
+	seq, err = parseMatchDataSequence("5")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(5), seq)
+
+	// Malformed sequence numbers are rejected rather than read as no sequence number.
+	for _, cid := range []string{"abc", "-1", "1.5", "18446744073709551616"} {
+		_, err = parseMatchDataSequence(cid)
+		assert.Error(t, err, cid)
+	}
+}
+
+func TestMatchHandlerDedup(t *testing.T) {
+	matchRegistry, _, _ := newTestMatchRegistry(map[string]runtime.Match{})
+	router := &recordingMessageRouter{opCodeCh: make(chan int64, 64)}
+	match := &testLoopMatch{loopCh: make(chan []runtime.MatchData, 64)}
+
+	id := uuid.Must(uuid.NewV4())
+	stopped := atomic.NewBool(false)
+	core, err := NewRuntimeGoMatchCore(logger, cfg, matchRegistry, metrics, router, id, cfg.GetName(), stopped, nil, nil, nil, match)
+	if err != nil {
+		t.Fatalf("error creating match core: %v", err)
+	}
+	core.(*RuntimeGoMatchCore).MatchDedup(true)
+	mh, err := NewMatchHandler(logger, cfg, nil, matchRegistry, router, metrics, core, id, cfg.GetName(), stopped, nil, nil)
+	if err != nil {
+		t.Fatalf("error creating match handler: %v", err)
+	}
+	defer mh.Stop()
+
+	sessionID := uuid.Must(uuid.NewV4())
+	for _, seq := range []uint64{1, 2, 2, 3} {
+		mh.QueueData(&MatchDataMessage{SessionID: sessionID, OpCode: int64(seq), Seq: seq})
+	}
+
+	// The duplicate is dropped before it reaches the match loop.
+	received := make([]int64, 0, 3)
+	for len(received) < 3 {
+		select {
+		case messages := <-match.loopCh:
+			for _, message := range messages {
+				received = append(received, message.GetOpCode())
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for match data, received %v", received)
+		}
+	}
+	assert.Equal(t, []int64{1, 2, 3}, received)
+	assert.EqualValues(t, 1, core.(*RuntimeGoMatchCore).dedupFilter.Dropped())
+}
+
+// Reports the input queue depth and size on every loop.
+type testQueueDepthMatch struct {
+	testMatch
+	depthCh chan [2]int
+}
+
+func (m *testQueueDepthMatch) MatchLoop(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, dispatcher runtime.MatchDispatcher, tick int64, state interface{}, messages []runtime.MatchData) interface{} {
+	depth, size := dispatcher.(*RuntimeGoMatchCore).MatchInputQueueDepth()
+	m.depthCh <- [2]int{depth, size}
+	return state
+}
+
+func TestMatchHandlerInputQueueDepth(t *testing.T) {
+	matchRegistry, _, _ := newTestMatchRegistry(map[string]runtime.Match{})
+	router := &recordingMessageRouter{opCodeCh: make(chan int64, 64)}
+	match := &testQueueDepthMatch{depthCh: make(chan [2]int, 64)}
+
+	id := uuid.Must(uuid.NewV4())
+	stopped := atomic.NewBool(false)
+	core, err := NewRuntimeGoMatchCore(logger, cfg, matchRegistry, metrics, router, id, cfg.GetName(), stopped, nil, nil, nil, match)
+	if err != nil {
+		t.Fatalf("error creating match core: %v", err)
+	}
+	mh, err := NewMatchHandler(logger, cfg, nil, matchRegistry, router, metrics, core, id, cfg.GetName(), stopped, nil, nil)
+	if err != nil {
+		t.Fatalf("error creating match handler: %v", err)
+	}
+	defer mh.Stop()
+
+	// Messages queued before the first tick are all waiting when it starts.
+	for i := 0; i < 5; i++ {
+		mh.QueueData(&MatchDataMessage{SessionID: uuid.Must(uuid.NewV4()), OpCode: 1})
+	}
+
+	for _, expected := range []int{5, 0} {
+		select {
+		case depth := <-match.depthCh:
+			assert.Equal(t, expected, depth[0])
+			assert.Equal(t, cfg.GetMatch().InputQueueSize, depth[1])
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for match loop")
+		}
+	}
+}
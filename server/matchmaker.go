@@ -15,18 +15,28 @@
 package server
 
 import (
+	"context"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/blevesearch/bleve/analysis/analyzer/keyword"
 
 	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
 	"github.com/gofrs/uuid"
 	"github.com/heroiclabs/nakama-common/runtime"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 )
 
-var ErrMatchmakerTicketNotFound = errors.New("ticket not found")
+var (
+	ErrMatchmakerTicketNotFound        = errors.New("ticket not found")
+	ErrMatchmakerOverrideTicketUnknown = errors.New("matchmaker override returned an unknown ticket")
+	ErrMatchmakerOverrideCount         = errors.New("matchmaker override returned a grouping outside the min and max count")
+	ErrMatchmakerPartyEmpty            = errors.New("matchmaker party has no members")
+	ErrMatchmakerPartyTooLarge         = errors.New("matchmaker party is larger than the maximum count")
+)
 
 type MatchmakerPresence struct {
 	UserId    string `json:"user_id"`
@@ -65,6 +75,11 @@ type MatchmakerEntry struct {
 	StringProperties  map[string]string  `json:"-"`
 	NumericProperties map[string]float64 `json:"-"`
 	SessionID         uuid.UUID          `json:"-"`
+	CreateTime        time.Time          `json:"-"`
+	Query             string             `json:"-"`
+	// Set on the indexed entry of a party ticket only, lists all members including the leader.
+	PartySessionIds []string           `json:"party_session_ids,omitempty"`
+	Party           []*MatchmakerEntry `json:"-"`
 }
 
 func (m *MatchmakerEntry) GetPresence() runtime.Presence {
@@ -77,10 +92,89 @@ func (m *MatchmakerEntry) GetProperties() map[string]interface{} {
 	return m.Properties
 }
 
+// Number of players the ticket represents.
+func (m *MatchmakerEntry) size() int {
+	if len(m.Party) == 0 {
+		return 1
+	}
+	return len(m.Party)
+}
+
+// One entry per player the ticket represents.
+func (m *MatchmakerEntry) members() []*MatchmakerEntry {
+	if len(m.Party) == 0 {
+		return []*MatchmakerEntry{m}
+	}
+	return m.Party
+}
+
+func (m *MatchmakerEntry) sessionIDs() []string {
+	if len(m.PartySessionIds) == 0 {
+		return []string{m.Presence.SessionId}
+	}
+	return m.PartySessionIds
+}
+
+// Matches tickets the given session is part of, either alone or as a party member.
+func (m *MatchmakerEntry) hasSession(sessionID string) bool {
+	for _, id := range m.sessionIDs() {
+		if id == sessionID {
+			return true
+		}
+	}
+	return false
+}
+
+func matchmakerSessionQuery(sessionID string) query.Query {
+	sessionQuery := bleve.NewTermQuery(sessionID)
+	sessionQuery.SetField("presence.session_id")
+	partyQuery := bleve.NewTermQuery(sessionID)
+	partyQuery.SetField("party_session_ids")
+	return bleve.NewDisjunctionQuery(sessionQuery, partyQuery)
+}
+
+// Upper bounds of the ticket age histogram buckets reported in matchmaker stats.
+var matchmakerStatsAgeBuckets = []time.Duration{10 * time.Second, 30 * time.Second, time.Minute, 5 * time.Minute}
+
+type MatchmakerStatsAgeBucket struct {
+	// Upper bound of ticket ages counted in this bucket, or 0 for the final unbounded bucket.
+	MaxAge time.Duration
+	Count  int
+}
+
+type MatchmakerStats struct {
+	ActiveTickets int
+	TicketAges    []*MatchmakerStatsAgeBucket
+}
+
+const (
+	MatchmakerTicketStateWaiting = "waiting"
+	MatchmakerTicketStateMatched = "matched"
+	MatchmakerTicketStateExpired = "expired"
+)
+
+// Number of matched or removed tickets remembered for status lookups, oldest are forgotten first.
+const matchmakerTicketHistorySize = 10000
+
+type MatchmakerTicketStatus struct {
+	// One of "waiting", "matched", or "expired" if the ticket was removed before being matched.
+	State string
+	Entry *MatchmakerEntry
+}
+
 type Matchmaker interface {
 	Add(session Session, query string, minCount int, maxCount int, stringProperties map[string]string, numericProperties map[string]float64) (string, []*MatchmakerEntry, error)
+	AddBatch(ctx context.Context, sessions []Session, query string, minCount int, maxCount int, stringProperties map[string]string, numericProperties map[string]float64) (string, []*MatchmakerEntry, error)
 	Remove(sessionID uuid.UUID, ticket string) error
 	RemoveAll(sessionID uuid.UUID) error
+	Stats() *MatchmakerStats
+	// Look up the current state of a ticket, returns nil if the ticket is unknown or has been forgotten.
+	GetTicket(ticket string) *MatchmakerTicketStatus
+	// List the tickets a user is currently waiting on across all their sessions, oldest first.
+	UserTickets(userID uuid.UUID) []*MatchmakerEntry
+	SetOverrideFunction(fn RuntimeMatchmakerOverrideFunction)
+	SetMatchedFunction(fn RuntimeMatchmakerMatchedFunction)
+	MatchedFunction() RuntimeMatchmakerMatchedFunction
 }
 
 type LocalMatchmaker struct {
@@ -88,6 +182,11 @@ type LocalMatchmaker struct {
 	node    string
 	entries map[string]*MatchmakerEntry
 	index   bleve.Index
+	// Recently matched or removed tickets, and their order of completion.
+	history      map[string]*MatchmakerTicketStatus
+	historyOrder []string
+	overrideFn   RuntimeMatchmakerOverrideFunction
+	matchedFn    RuntimeMatchmakerMatchedFunction
 }
 
 func NewLocalMatchmaker(startupLogger *zap.Logger, node string) Matchmaker {
@@ -103,10 +202,42 @@ func NewLocalMatchmaker(startupLogger *zap.Logger, node string) Matchmaker {
 		node:    node,
 		entries: make(map[string]*MatchmakerEntry),
 		index:   index,
+		history: make(map[string]*MatchmakerTicketStatus),
 	}
 }
 
 func (m *LocalMatchmaker) Add(session Session, query string, minCount int, maxCount int, stringProperties map[string]string, numericProperties map[string]float64) (string, []*MatchmakerEntry, error) {
+	entry := m.newEntry(uuid.Must(uuid.NewV4()).String(), session, query, stringProperties, numericProperties)
+	return m.add(session.Context(), entry, query, minCount, maxCount)
+}
+
+// AddBatch submits a party of sessions as a single ticket. Party members are only ever matched together, and a match
+// returns one entry per member, all sharing the party ticket. The first session is the party leader.
+func (m *LocalMatchmaker) AddBatch(ctx context.Context, sessions []Session, query string, minCount int, maxCount int, stringProperties map[string]string, numericProperties map[string]float64) (string, []*MatchmakerEntry, error) {
+	if len(sessions) == 0 {
+		return "", nil, ErrMatchmakerPartyEmpty
+	}
+	if len(sessions) > maxCount {
+		return "", nil, ErrMatchmakerPartyTooLarge
+	}
+
+	ticket := uuid.Must(uuid.NewV4()).String()
+	party := make([]*MatchmakerEntry, 0, len(sessions))
+	sessionIDs := make([]string, 0, len(sessions))
+	for _, session := range sessions {
+		party = append(party, m.newEntry(ticket, session, query, stringProperties, numericProperties))
+		sessionIDs = append(sessionIDs, session.ID().String())
+	}
+
+	// The leader's entry stands in for the whole party in the index.
+	entry := party[0]
+	entry.PartySessionIds = sessionIDs
+	entry.Party = party
+
+	return m.add(ctx, entry, query, minCount, maxCount)
+}
+
+func (m *LocalMatchmaker) newEntry(ticket string, session Session, query string, stringProperties map[string]string, numericProperties map[string]float64) *MatchmakerEntry {
 	// Merge incoming properties.
 	properties := make(map[string]interface{}, len(stringProperties)+len(numericProperties))
 	for k, v := range stringProperties {
@@ -116,16 +247,7 @@ func (m *LocalMatchmaker) Add(session Session, query string, minCount int, maxCo
 		properties[k] = v
 	}
 
-	filterQuery := bleve.NewTermQuery(session.ID().String())
-	filterQuery.SetField("presence.session_id")
-	indexQuery := bleve.NewBooleanQuery()
-	indexQuery.AddMust(bleve.NewQueryStringQuery(query))
-	indexQuery.AddMustNot(filterQuery)
-
-	searchRequest := bleve.NewSearchRequestOptions(indexQuery, maxCount-1, 0, false)
-
-	ticket := uuid.Must(uuid.NewV4()).String()
-	entry := &MatchmakerEntry{
+	return &MatchmakerEntry{
 		Ticket: ticket,
 		Presence: &MatchmakerPresence{
 			UserId:    session.UserID().String(),
@@ -137,18 +259,49 @@ func (m *LocalMatchmaker) Add(session Session, query string, minCount int, maxCo
 		StringProperties:  stringProperties,
 		NumericProperties: numericProperties,
 		SessionID:         session.ID(),
+		CreateTime:        time.Now(),
+		Query:             query,
+	}
+}
+
+func (m *LocalMatchmaker) add(ctx context.Context, entry *MatchmakerEntry, query string, minCount int, maxCount int) (string, []*MatchmakerEntry, error) {
+	ticket := entry.Ticket
+
+	// Never match any of the incoming sessions with tickets they are already part of.
+	indexQuery := bleve.NewBooleanQuery()
+	indexQuery.AddMust(bleve.NewQueryStringQuery(query))
+	for _, sessionID := range entry.sessionIDs() {
+		indexQuery.AddMustNot(matchmakerSessionQuery(sessionID))
 	}
 
+	searchRequest := bleve.NewSearchRequestOptions(indexQuery, maxCount-1, 0, false)
+
 	m.Lock()
-	result, err := m.index.SearchInContext(session.Context(), searchRequest)
+	result, err := m.index.SearchInContext(ctx, searchRequest)
 	if err != nil {
 		m.Unlock()
 		return ticket, nil, err
 	}
 
+	// Gather as many tickets as fit, parties only count if all their members fit.
+	candidates := make([]*MatchmakerEntry, 0, result.Hits.Len()+1)
+	count := entry.size()
+	for _, hit := range result.Hits {
+		candidate, ok := m.entries[hit.ID]
+		if !ok {
+			// Index and entries map are out of sync, should not happen but check to be sure.
+			m.Unlock()
+			return ticket, nil, ErrMatchmakerTicketNotFound
+		}
+		if count+candidate.size() > maxCount {
+			continue
+		}
+		candidates = append(candidates, candidate)
+		count += candidate.size()
+	}
+
 	// Check if we have enough results to return them, or if we just add a new entry to the matchmaker.
-	resultCount := result.Hits.Len()
-	if resultCount < minCount-1 {
+	if count < minCount {
 		if err := m.index.Index(ticket, entry); err != nil {
 			m.Unlock()
 			return ticket, nil, err
@@ -159,20 +312,58 @@ func (m *LocalMatchmaker) Add(session Session, query string, minCount int, maxCo
 		return ticket, nil, nil
 	}
 
-	// We have enough entries to satisfy the request.
-	entries := make([]*MatchmakerEntry, 0, resultCount+1)
-	tickets := make([]string, 0, resultCount)
+	// We have enough entries to satisfy the request, add the current ticket.
+	candidates = append(candidates, entry)
+
+	if overrideFn := m.overrideFn; overrideFn != nil {
+		// Let the runtime accept, modify, or reject the candidate grouping. The lock is released while the override
+		// runs so it may call back into the matchmaker, and so other matchmaking does not wait on it.
+		m.Unlock()
+		selected, err := matchmakerOverride(ctx, overrideFn, candidates, minCount, maxCount)
+		m.Lock()
+		if err != nil {
+			m.Unlock()
+			return ticket, nil, err
+		}
+
+		// Candidate tickets matched or removed elsewhere while the override ran invalidate the grouping.
+		for _, candidate := range selected {
+			if _, ok := m.entries[candidate.Ticket]; !ok && candidate != entry {
+				selected = nil
+				break
+			}
+		}
+		candidates = selected
+	}
+
+	entries := make([]*MatchmakerEntry, 0, count)
+	for _, candidate := range candidates {
+		entries = append(entries, candidate.members()...)
+	}
+
+	var currentMatched bool
+	tickets := make([]string, 0, len(candidates))
 	batch := m.index.NewBatch()
-	for _, hit := range result.Hits {
-		entry, ok := m.entries[hit.ID]
-		if !ok {
-			// Index and entries map are out of sync, should not happen but check to be sure.
+	for _, c := range candidates {
+		if c == entry {
+			currentMatched = true
+			continue
+		}
+		tickets = append(tickets, c.Ticket)
+		batch.Delete(c.Ticket)
+	}
+
+	if !currentMatched {
+		// The override rejected the grouping or left the current ticket out of it, keep it waiting.
+		if err := m.index.Index(ticket, entry); err != nil {
 			m.Unlock()
-			return ticket, nil, ErrMatchmakerTicketNotFound
+			return ticket, nil, err
 		}
-		entries = append(entries, entry)
-		tickets = append(tickets, hit.ID)
-		batch.Delete(hit.ID)
+		m.entries[ticket] = entry
+	}
+	if len(entries) == 0 {
+		m.Unlock()
+		return ticket, nil, nil
 	}
 
 	// Only remove the entries after we've processed each one to make sure
@@ -184,19 +375,84 @@ func (m *LocalMatchmaker) Add(session Session, query string, minCount int, maxCo
 	for _, ticket := range tickets {
 		delete(m.entries, ticket)
 	}
+	for _, c := range candidates {
+		m.recordHistory(c, MatchmakerTicketStateMatched)
+	}
 
 	m.Unlock()
 
-	// Add the current user.
-	entries = append(entries, entry)
-
 	return ticket, entries, nil
 }
 
+// Consult the runtime override function on a candidate grouping and return the candidate tickets it selects, in their
+// original order. Selecting any member of a party selects the whole party. An empty selection rejects the grouping.
+func matchmakerOverride(ctx context.Context, fn RuntimeMatchmakerOverrideFunction, candidates []*MatchmakerEntry, minCount int, maxCount int) ([]*MatchmakerEntry, error) {
+	entries := make([]*MatchmakerEntry, 0, len(candidates))
+	for _, candidate := range candidates {
+		entries = append(entries, candidate.members()...)
+	}
+
+	overridden, err := fn(ctx, entries)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only tickets from the candidate grouping may be returned.
+	selectedTickets := make(map[string]struct{}, len(overridden))
+	for _, o := range overridden {
+		var found bool
+		for _, candidate := range candidates {
+			if o != nil && o.Ticket == candidate.Ticket {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, ErrMatchmakerOverrideTicketUnknown
+		}
+		selectedTickets[o.Ticket] = struct{}{}
+	}
+
+	selected := make([]*MatchmakerEntry, 0, len(selectedTickets))
+	count := 0
+	for _, candidate := range candidates {
+		if _, ok := selectedTickets[candidate.Ticket]; ok {
+			selected = append(selected, candidate)
+			count += candidate.size()
+		}
+	}
+	if count != 0 && (count < minCount || count > maxCount) {
+		return nil, ErrMatchmakerOverrideCount
+	}
+
+	return selected, nil
+}
+
+// SetOverrideFunction sets a runtime function consulted whenever a candidate grouping is formed.
+func (m *LocalMatchmaker) SetOverrideFunction(fn RuntimeMatchmakerOverrideFunction) {
+	m.Lock()
+	m.overrideFn = fn
+	m.Unlock()
+}
+
+// SetMatchedFunction sets the runtime function called with each completed grouping, if any.
+func (m *LocalMatchmaker) SetMatchedFunction(fn RuntimeMatchmakerMatchedFunction) {
+	m.Lock()
+	m.matchedFn = fn
+	m.Unlock()
+}
+
+func (m *LocalMatchmaker) MatchedFunction() RuntimeMatchmakerMatchedFunction {
+	m.Lock()
+	defer m.Unlock()
+	return m.matchedFn
+}
+
 func (m *LocalMatchmaker) Remove(sessionID uuid.UUID, ticket string) error {
 	m.Lock()
 
-	if entry, ok := m.entries[ticket]; !ok || entry.Presence.SessionId != sessionID.String() {
+	entry, ok := m.entries[ticket]
+	if !ok || !entry.hasSession(sessionID.String()) {
 		// Ticket does not exist or does not belong to this session.
 		m.Unlock()
 		return ErrMatchmakerTicketNotFound
@@ -206,14 +462,15 @@ func (m *LocalMatchmaker) Remove(sessionID uuid.UUID, ticket string) error {
 		return err
 	}
 	delete(m.entries, ticket)
+	m.recordHistory(entry, MatchmakerTicketStateExpired)
 
 	m.Unlock()
 	return nil
 }
 
 func (m *LocalMatchmaker) RemoveAll(sessionID uuid.UUID) error {
-	query := bleve.NewMatchQuery(sessionID.String())
-	query.SetField("presence.session_id")
+	// Party tickets are removed when any of their members go away.
+	sessionQuery := matchmakerSessionQuery(sessionID.String())
 	queuedRemoves := 0
 	batch := m.index.NewBatch()
 	tickets := make([]string, 0, 10)
@@ -223,7 +480,7 @@ func (m *LocalMatchmaker) RemoveAll(sessionID uuid.UUID) error {
 	// Look up and accumulate all required removes to be executed as a batch later.
 	for {
 		// Load a set of matchmaker entries for the given session.
-		search := bleve.NewSearchRequestOptions(query, 10, queuedRemoves, false)
+		search := bleve.NewSearchRequestOptions(sessionQuery, 10, queuedRemoves, false)
 		result, err := m.index.Search(search)
 		if err != nil {
 			m.Unlock()
@@ -248,6 +505,9 @@ func (m *LocalMatchmaker) RemoveAll(sessionID uuid.UUID) error {
 			return err
 		}
 		for _, ticket := range tickets {
+			if entry, ok := m.entries[ticket]; ok {
+				m.recordHistory(entry, MatchmakerTicketStateExpired)
+			}
 			delete(m.entries, ticket)
 		}
 	}
@@ -255,3 +515,80 @@ func (m *LocalMatchmaker) RemoveAll(sessionID uuid.UUID) error {
 	m.Unlock()
 	return nil
 }
+
+func (m *LocalMatchmaker) Stats() *MatchmakerStats {
+	ages := make([]*MatchmakerStatsAgeBucket, 0, len(matchmakerStatsAgeBuckets)+1)
+	for _, maxAge := range matchmakerStatsAgeBuckets {
+		ages = append(ages, &MatchmakerStatsAgeBucket{MaxAge: maxAge})
+	}
+	// Final bucket for tickets older than all others.
+	ages = append(ages, &MatchmakerStatsAgeBucket{})
+
+	now := time.Now()
+
+	m.Lock()
+	stats := &MatchmakerStats{
+		ActiveTickets: len(m.entries),
+		TicketAges:    ages,
+	}
+	for _, entry := range m.entries {
+		age := now.Sub(entry.CreateTime)
+		bucket := ages[len(ages)-1]
+		for _, b := range ages[:len(ages)-1] {
+			if age < b.MaxAge {
+				bucket = b
+				break
+			}
+		}
+		bucket.Count++
+	}
+	m.Unlock()
+
+	return stats
+}
+
+func (m *LocalMatchmaker) GetTicket(ticket string) *MatchmakerTicketStatus {
+	m.Lock()
+	defer m.Unlock()
+
+	if entry, ok := m.entries[ticket]; ok {
+		return &MatchmakerTicketStatus{State: MatchmakerTicketStateWaiting, Entry: entry}
+	}
+	return m.history[ticket]
+}
+
+func (m *LocalMatchmaker) UserTickets(userID uuid.UUID) []*MatchmakerEntry {
+	uid := userID.String()
+	entries := make([]*MatchmakerEntry, 0, 1)
+
+	m.Lock()
+	for _, entry := range m.entries {
+		for _, member := range entry.members() {
+			if member.Presence.UserId == uid {
+				entries = append(entries, member)
+			}
+		}
+	}
+	m.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].CreateTime.Equal(entries[j].CreateTime) {
+			return entries[i].Ticket < entries[j].Ticket
+		}
+		return entries[i].CreateTime.Before(entries[j].CreateTime)
+	})
+	return entries
+}
+
+// Must be called with the lock held.
+func (m *LocalMatchmaker) recordHistory(entry *MatchmakerEntry, state string) {
+	if _, found := m.history[entry.Ticket]; !found {
+		m.historyOrder = append(m.historyOrder, entry.Ticket)
+	}
+	m.history[entry.Ticket] = &MatchmakerTicketStatus{State: state, Entry: entry}
+
+	if len(m.historyOrder) > matchmakerTicketHistorySize {
+		delete(m.history, m.historyOrder[0])
+		m.historyOrder = m.historyOrder[1:]
+	}
+}
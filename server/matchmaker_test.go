@@ -0,0 +1,390 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/heroiclabs/nakama-common/rtapi"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+type testSession struct {
+	id         uuid.UUID
+	userID     uuid.UUID
+	vars       map[string]string
+	clientIP   string
+	createTime int64
+}
+
+func newTestSession() *testSession {
+	return &testSession{
+		id:     uuid.Must(uuid.NewV4()),
+		userID: uuid.Must(uuid.NewV4()),
+	}
+}
+
+func (s *testSession) Logger() *zap.Logger                                { return logger }
+func (s *testSession) ID() uuid.UUID                                      { return s.id }
+func (s *testSession) UserID() uuid.UUID                                  { return s.userID }
+func (s *testSession) Vars() map[string]string                            { return s.vars }
+func (s *testSession) SetVars(vars map[string]string)                     { s.vars = vars }
+func (s *testSession) ClientIP() string                                   { return s.clientIP }
+func (s *testSession) ClientPort() string                                 { return "" }
+func (s *testSession) CreateTime() int64                                  { return s.createTime }
+func (s *testSession) Context() context.Context                           { return context.Background() }
+func (s *testSession) Username() string                                   { return s.userID.String() }
+func (s *testSession) SetUsername(string)                                 {}
+func (s *testSession) Expiry() int64                                      { return 0 }
+func (s *testSession) Consume()                                           {}
+func (s *testSession) Format() SessionFormat                              { return SessionFormatJson }
+func (s *testSession) Send(envelope *rtapi.Envelope, reliable bool) error { return nil }
+func (s *testSession) SendBytes(payload []byte, reliable bool) error      { return nil }
+func (s *testSession) Close(reason string)                                {}
+
+func TestMatchmakerStats(t *testing.T) {
+	matchmaker := NewLocalMatchmaker(logger, "node1")
+
+	stats := matchmaker.Stats()
+	assert.Equal(t, 0, stats.ActiveTickets)
+
+	for i := 0; i < 3; i++ {
+		// Tickets that cannot be matched yet stay active.
+		_, entries, err := matchmaker.Add(newTestSession(), "*", 10, 10, map[string]string{"mode": "test"}, nil)
+		if err != nil {
+			t.Fatalf("error adding matchmaker ticket: %v", err.Error())
+		}
+		assert.Nil(t, entries)
+	}
+
+	stats = matchmaker.Stats()
+	assert.Equal(t, 3, stats.ActiveTickets)
+	assert.Len(t, stats.TicketAges, len(matchmakerStatsAgeBuckets)+1)
+	assert.Equal(t, 10*time.Second, stats.TicketAges[0].MaxAge)
+	assert.Equal(t, 3, stats.TicketAges[0].Count, "new tickets should be in the first age bucket")
+
+	total := 0
+	for _, bucket := range stats.TicketAges {
+		total += bucket.Count
+	}
+	assert.Equal(t, stats.ActiveTickets, total)
+
+	session := newTestSession()
+	ticket, _, err := matchmaker.Add(session, "*", 10, 10, map[string]string{"mode": "test"}, nil)
+	if err != nil {
+		t.Fatalf("error adding matchmaker ticket: %v", err.Error())
+	}
+	assert.Equal(t, 4, matchmaker.Stats().ActiveTickets)
+
+	if err := matchmaker.Remove(session.ID(), ticket); err != nil {
+		t.Fatalf("error removing matchmaker ticket: %v", err.Error())
+	}
+	assert.Equal(t, 3, matchmaker.Stats().ActiveTickets)
+}
+
+func TestMatchmakerOverrideReject(t *testing.T) {
+	matchmaker := NewLocalMatchmaker(logger, "node1")
+
+	var calls int
+	matchmaker.SetOverrideFunction(func(ctx context.Context, entries []*MatchmakerEntry) ([]*MatchmakerEntry, error) {
+		calls++
+		assert.Len(t, entries, 2)
+		// Reject every grouping.
+		return nil, nil
+	})
+
+	_, entries, err := matchmaker.Add(newTestSession(), "*", 2, 2, map[string]string{"mode": "test"}, nil)
+	if err != nil {
+		t.Fatalf("error adding matchmaker ticket: %v", err.Error())
+	}
+	assert.Nil(t, entries)
+	assert.Equal(t, 0, calls, "override should not run until a grouping is formed")
+
+	_, entries, err = matchmaker.Add(newTestSession(), "*", 2, 2, map[string]string{"mode": "test"}, nil)
+	if err != nil {
+		t.Fatalf("error adding matchmaker ticket: %v", err.Error())
+	}
+	assert.Nil(t, entries, "rejected grouping should not form a match")
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, 2, matchmaker.Stats().ActiveTickets, "rejected tickets should stay active")
+}
+
+func TestMatchmakerOverrideModify(t *testing.T) {
+	matchmaker := NewLocalMatchmaker(logger, "node1")
+
+	first, _, err := matchmaker.Add(newTestSession(), "*", 3, 3, map[string]string{"mode": "test"}, nil)
+	if err != nil {
+		t.Fatalf("error adding matchmaker ticket: %v", err.Error())
+	}
+	second, _, err := matchmaker.Add(newTestSession(), "*", 3, 3, map[string]string{"mode": "test"}, nil)
+	if err != nil {
+		t.Fatalf("error adding matchmaker ticket: %v", err.Error())
+	}
+
+	matchmaker.SetOverrideFunction(func(ctx context.Context, entries []*MatchmakerEntry) ([]*MatchmakerEntry, error) {
+		// Keep only the waiting tickets, leaving the current one out of the match.
+		kept := make([]*MatchmakerEntry, 0, 2)
+		for _, entry := range entries {
+			if entry.Ticket == first || entry.Ticket == second {
+				kept = append(kept, entry)
+			}
+		}
+		return kept, nil
+	})
+
+	_, entries, err := matchmaker.Add(newTestSession(), "*", 2, 3, map[string]string{"mode": "test"}, nil)
+	if err != nil {
+		t.Fatalf("error adding matchmaker ticket: %v", err.Error())
+	}
+	if assert.Len(t, entries, 2) {
+		assert.ElementsMatch(t, []string{first, second}, []string{entries[0].Ticket, entries[1].Ticket})
+	}
+	assert.Equal(t, 1, matchmaker.Stats().ActiveTickets, "excluded current ticket should stay active")
+
+	matchmaker.SetOverrideFunction(func(ctx context.Context, entries []*MatchmakerEntry) ([]*MatchmakerEntry, error) {
+		return []*MatchmakerEntry{{Ticket: "unknown"}}, nil
+	})
+	_, _, err = matchmaker.Add(newTestSession(), "*", 2, 2, map[string]string{"mode": "test"}, nil)
+	assert.Equal(t, ErrMatchmakerOverrideTicketUnknown, err)
+	assert.Equal(t, 1, matchmaker.Stats().ActiveTickets)
+}
+
+func TestMatchmakerOverrideCount(t *testing.T) {
+	matchmaker := NewLocalMatchmaker(logger, "node1")
+
+	if _, _, err := matchmaker.Add(newTestSession(), "*", 2, 2, map[string]string{"mode": "test"}, nil); err != nil {
+		t.Fatalf("error adding matchmaker ticket: %v", err.Error())
+	}
+
+	matchmaker.SetOverrideFunction(func(ctx context.Context, entries []*MatchmakerEntry) ([]*MatchmakerEntry, error) {
+		// A single ticket is below the minimum count.
+		return entries[:1], nil
+	})
+	_, entries, err := matchmaker.Add(newTestSession(), "*", 2, 2, map[string]string{"mode": "test"}, nil)
+	assert.Equal(t, ErrMatchmakerOverrideCount, err)
+	assert.Nil(t, entries)
+	assert.Equal(t, 1, matchmaker.Stats().ActiveTickets)
+}
+
+func TestMatchmakerOverrideReentrant(t *testing.T) {
+	matchmaker := NewLocalMatchmaker(logger, "node1")
+
+	waiting := newTestSession()
+	first, _, err := matchmaker.Add(waiting, "*", 2, 2, map[string]string{"mode": "test"}, nil)
+	if err != nil {
+		t.Fatalf("error adding matchmaker ticket: %v", err.Error())
+	}
+
+	matchmaker.SetOverrideFunction(func(ctx context.Context, entries []*MatchmakerEntry) ([]*MatchmakerEntry, error) {
+		// The override may use the matchmaker, here it removes a candidate ticket before accepting the grouping.
+		assert.Equal(t, 1, matchmaker.Stats().ActiveTickets)
+		if err := matchmaker.Remove(waiting.ID(), first); err != nil {
+			return nil, err
+		}
+		return entries, nil
+	})
+
+	current, entries, err := matchmaker.Add(newTestSession(), "*", 2, 2, map[string]string{"mode": "test"}, nil)
+	if err != nil {
+		t.Fatalf("error adding matchmaker ticket: %v", err.Error())
+	}
+	assert.Nil(t, entries, "grouping with a removed ticket should not form a match")
+	if status := matchmaker.GetTicket(current); assert.NotNil(t, status) {
+		assert.Equal(t, MatchmakerTicketStateWaiting, status.State)
+	}
+	if status := matchmaker.GetTicket(first); assert.NotNil(t, status) {
+		assert.Equal(t, MatchmakerTicketStateExpired, status.State)
+	}
+}
+
+func TestMatchmakerGetTicket(t *testing.T) {
+	matchmaker := NewLocalMatchmaker(logger, "node1")
+
+	assert.Nil(t, matchmaker.GetTicket("unknown"))
+
+	// Waiting.
+	first, _, err := matchmaker.Add(newTestSession(), "+properties.mode:test", 2, 2, map[string]string{"mode": "test"}, nil)
+	if err != nil {
+		t.Fatalf("error adding matchmaker ticket: %v", err.Error())
+	}
+	status := matchmaker.GetTicket(first)
+	if assert.NotNil(t, status) {
+		assert.Equal(t, MatchmakerTicketStateWaiting, status.State)
+		assert.Equal(t, "+properties.mode:test", status.Entry.Query)
+		assert.Equal(t, map[string]interface{}{"mode": "test"}, status.Entry.Properties)
+	}
+
+	// Matched.
+	second, entries, err := matchmaker.Add(newTestSession(), "+properties.mode:test", 2, 2, map[string]string{"mode": "test"}, nil)
+	if err != nil {
+		t.Fatalf("error adding matchmaker ticket: %v", err.Error())
+	}
+	assert.Len(t, entries, 2)
+	for _, ticket := range []string{first, second} {
+		status = matchmaker.GetTicket(ticket)
+		if assert.NotNil(t, status) {
+			assert.Equal(t, MatchmakerTicketStateMatched, status.State)
+		}
+	}
+
+	// Expired, by removal of a single ticket or all of a session's tickets.
+	session := newTestSession()
+	third, _, err := matchmaker.Add(session, "+properties.mode:other", 2, 2, map[string]string{"mode": "other"}, nil)
+	if err != nil {
+		t.Fatalf("error adding matchmaker ticket: %v", err.Error())
+	}
+	fourth, _, err := matchmaker.Add(newTestSession(), "+properties.mode:another", 2, 2, map[string]string{"mode": "another"}, nil)
+	if err != nil {
+		t.Fatalf("error adding matchmaker ticket: %v", err.Error())
+	}
+	if err := matchmaker.Remove(session.ID(), third); err != nil {
+		t.Fatalf("error removing matchmaker ticket: %v", err.Error())
+	}
+	if err := matchmaker.RemoveAll(uuid.FromStringOrNil(matchmaker.GetTicket(fourth).Entry.Presence.SessionId)); err != nil {
+		t.Fatalf("error removing matchmaker tickets: %v", err.Error())
+	}
+	for _, ticket := range []string{third, fourth} {
+		status = matchmaker.GetTicket(ticket)
+		if assert.NotNil(t, status) {
+			assert.Equal(t, MatchmakerTicketStateExpired, status.State)
+		}
+	}
+}
+
+func TestMatchmakerUserTickets(t *testing.T) {
+	matchmaker := NewLocalMatchmaker(logger, "node1")
+
+	session := newTestSession()
+	// A second session for the same user, its tickets are listed too.
+	otherSession := &testSession{id: uuid.Must(uuid.NewV4()), userID: session.UserID()}
+
+	assert.Empty(t, matchmaker.UserTickets(session.UserID()))
+
+	first, _, err := matchmaker.Add(session, "+properties.mode:a", 2, 2, map[string]string{"mode": "a"}, nil)
+	if err != nil {
+		t.Fatalf("error adding matchmaker ticket: %v", err.Error())
+	}
+	second, _, err := matchmaker.Add(otherSession, "+properties.mode:b", 2, 2, map[string]string{"mode": "b"}, nil)
+	if err != nil {
+		t.Fatalf("error adding matchmaker ticket: %v", err.Error())
+	}
+	if _, _, err := matchmaker.Add(newTestSession(), "+properties.mode:c", 2, 2, map[string]string{"mode": "c"}, nil); err != nil {
+		t.Fatalf("error adding matchmaker ticket: %v", err.Error())
+	}
+
+	entries := matchmaker.UserTickets(session.UserID())
+	tickets := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		assert.Equal(t, session.UserID().String(), entry.Presence.UserId)
+		tickets = append(tickets, entry.Ticket)
+	}
+	assert.ElementsMatch(t, []string{first, second}, tickets)
+
+	// Removed tickets are no longer listed.
+	if err := matchmaker.Remove(session.ID(), first); err != nil {
+		t.Fatalf("error removing matchmaker ticket: %v", err.Error())
+	}
+	entries = matchmaker.UserTickets(session.UserID())
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, second, entries[0].Ticket)
+	}
+}
+
+func TestMatchmakerAddBatchOverride(t *testing.T) {
+	matchmaker := NewLocalMatchmaker(logger, "node1")
+
+	leader := newTestSession()
+	member := newTestSession()
+	if _, _, err := matchmaker.AddBatch(context.Background(), []Session{leader, member}, "*", 3, 3, nil, nil); err != nil {
+		t.Fatalf("error adding matchmaker party ticket: %v", err.Error())
+	}
+
+	matchmaker.SetOverrideFunction(func(ctx context.Context, entries []*MatchmakerEntry) ([]*MatchmakerEntry, error) {
+		// The matchmaker stays usable while the override runs.
+		assert.Len(t, matchmaker.UserTickets(member.UserID()), 1)
+		// Keeping only the party leaves the grouping below the minimum count.
+		return entries[:1], nil
+	})
+
+	_, entries, err := matchmaker.AddBatch(context.Background(), []Session{newTestSession()}, "*", 3, 3, nil, nil)
+	assert.Equal(t, ErrMatchmakerOverrideCount, err)
+	assert.Nil(t, entries)
+	assert.Equal(t, 1, matchmaker.Stats().ActiveTickets)
+}
+
+func TestMatchmakerAddBatchParty(t *testing.T) {
+	matchmaker := NewLocalMatchmaker(logger, "node1")
+
+	leader := newTestSession()
+	member := newTestSession()
+	party, entries, err := matchmaker.AddBatch(context.Background(), []Session{leader, member}, "+properties.mode:party", 3, 3, map[string]string{"mode": "party"}, nil)
+	if err != nil {
+		t.Fatalf("error adding matchmaker party ticket: %v", err.Error())
+	}
+	assert.Nil(t, entries)
+	assert.Equal(t, 1, matchmaker.Stats().ActiveTickets)
+
+	// Every member can see the party ticket.
+	memberTickets := matchmaker.UserTickets(member.UserID())
+	if assert.Len(t, memberTickets, 1) {
+		assert.Equal(t, party, memberTickets[0].Ticket)
+		assert.Equal(t, member.ID().String(), memberTickets[0].Presence.SessionId)
+	}
+
+	// A grouping with room for only one party member must not split the party.
+	solo, entries, err := matchmaker.Add(newTestSession(), "+properties.mode:party", 2, 2, map[string]string{"mode": "solo"}, nil)
+	if err != nil {
+		t.Fatalf("error adding matchmaker ticket: %v", err.Error())
+	}
+	assert.Nil(t, entries)
+	assert.Equal(t, 2, matchmaker.Stats().ActiveTickets)
+
+	session := newTestSession()
+	ticket, entries, err := matchmaker.Add(session, "+properties.mode:party", 3, 3, map[string]string{"mode": "solo"}, nil)
+	if err != nil {
+		t.Fatalf("error adding matchmaker ticket: %v", err.Error())
+	}
+	if assert.Len(t, entries, 3) {
+		sessionIDs := make(map[string]string, len(entries))
+		for _, entry := range entries {
+			sessionIDs[entry.Presence.SessionId] = entry.Ticket
+		}
+		assert.Equal(t, map[string]string{
+			leader.ID().String():  party,
+			member.ID().String():  party,
+			session.ID().String(): ticket,
+		}, sessionIDs)
+	}
+	assert.Equal(t, MatchmakerTicketStateMatched, matchmaker.GetTicket(party).State)
+	assert.Equal(t, MatchmakerTicketStateWaiting, matchmaker.GetTicket(solo).State)
+
+	// Party tickets are removed when any member leaves.
+	party, _, err = matchmaker.AddBatch(context.Background(), []Session{leader, member}, "+properties.mode:other", 3, 3, nil, nil)
+	if err != nil {
+		t.Fatalf("error adding matchmaker party ticket: %v", err.Error())
+	}
+	if err := matchmaker.RemoveAll(member.ID()); err != nil {
+		t.Fatalf("error removing matchmaker tickets: %v", err.Error())
+	}
+	assert.Equal(t, MatchmakerTicketStateExpired, matchmaker.GetTicket(party).State)
+
+	// Parties may not exceed the maximum count.
+	_, _, err = matchmaker.AddBatch(context.Background(), []Session{leader, member, session}, "*", 2, 2, nil, nil)
+	assert.Equal(t, ErrMatchmakerPartyTooLarge, err)
+}
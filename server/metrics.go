@@ -20,6 +20,7 @@ import (
 	"go.uber.org/atomic"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -29,6 +30,9 @@ import (
 	"go.uber.org/zap"
 )
 
+// Input queue depth buckets, 1 to 2048 by powers of 2.
+var matchInputQueueDepthBuckets = tally.MustMakeExponentialValueBuckets(1, 2, 12)
+
 type Metrics struct {
 	logger *zap.Logger
 	config Config
@@ -267,16 +271,52 @@ func (m *Metrics) GaugeRuntimes(value float64) {
 	m.prometheusScope.Gauge("lua_runtimes").Update(value)
 }
 
+// Set the circuit breaker state for a host the runtime HTTP client sends requests to. 0 is closed, 1 open, 2 half-open.
+func (m *Metrics) GaugeRuntimeHTTPBreaker(host string, value float64) {
+	m.prometheusScope.Tagged(map[string]string{"host": host}).Gauge("runtime_http_breaker_state").Update(value)
+}
+
 // Set the absolute value of currently running authoritative matches.
+// Record an outgoing runtime HTTP request. A status code of 0 means no response was received.
+func (m *Metrics) RuntimeHTTPRequest(method, host string, statusCode int, elapsed time.Duration) {
+	status := "error"
+	if statusCode != 0 {
+		status = strconv.Itoa(statusCode)
+	}
+	scope := m.prometheusScope.Tagged(map[string]string{"method": method, "host": host})
+	scope.Tagged(map[string]string{"status": status}).Counter("runtime_http_request_count").Inc(1)
+	scope.Timer("runtime_http_request_latency_ms").Record(elapsed / time.Millisecond)
+}
+
 func (m *Metrics) GaugeAuthoritativeMatches(value float64) {
 	m.prometheusScope.Gauge("authoritative_matches").Update(value)
 }
 
+// Record the number of client messages waiting in an authoritative match input queue when a match loop starts.
+func (m *Metrics) MatchInputQueueDepth(depth int) {
+	m.prometheusScope.Histogram("authoritative_match_input_queue_depth", matchInputQueueDepthBuckets).RecordValue(float64(depth))
+}
+
+// Increment the number of client messages dropped because an authoritative match input queue was full.
+func (m *Metrics) CountMatchInputDropped(delta int64) {
+	m.prometheusScope.Counter("authoritative_match_input_dropped").Inc(delta)
+}
+
+// Increment the number of client messages dropped because an authoritative match does not allow their op code.
+func (m *Metrics) CountMatchOpCodeDropped(delta int64) {
+	m.prometheusScope.Counter("authoritative_match_op_code_dropped").Inc(delta)
+}
+
 // Increment the number of dropped events.
 func (m *Metrics) CountDroppedEvents(delta int64) {
 	m.prometheusScope.Counter("dropped_events").Inc(delta)
 }
 
+// Increment the number of runtime queries that exceeded the slow query threshold.
+func (m *Metrics) CountSlowQueries(delta int64) {
+	m.prometheusScope.Counter("runtime_slow_queries").Inc(delta)
+}
+
 // Increment the number of opened WS connections.
 func (m *Metrics) CountWebsocketOpened(delta int64) {
 	m.prometheusScope.Counter("socket_ws_opened").Inc(delta)
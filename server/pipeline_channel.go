@@ -303,7 +303,7 @@ func (p *Pipeline) channelLeave(logger *zap.Logger, session Session, envelope *r
 		return
 	}
 
-	p.tracker.Untrack(session.ID(), streamConversionResult.Stream, session.UserID())
+	p.tracker.Untrack(session.ID(), streamConversionResult.Stream, session.UserID(), PresenceReasonLeave)
 
 	session.Send(&rtapi.Envelope{Cid: envelope.Cid}, true)
 }
@@ -16,6 +16,7 @@ package server
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 
 	"crypto"
@@ -282,7 +283,7 @@ func (p *Pipeline) matchLeave(logger *zap.Logger, session Session, envelope *rta
 	// Check and drop the presence if possible, will always succeed.
 	stream := PresenceStream{Mode: mode, Subject: matchID, Label: matchIDComponents[1]}
 
-	p.tracker.Untrack(session.ID(), stream, session.UserID())
+	p.tracker.Untrack(session.ID(), stream, session.UserID(), PresenceReasonLeave)
 
 	session.Send(&rtapi.Envelope{Cid: envelope.Cid}, true)
 }
@@ -315,7 +316,16 @@ func (p *Pipeline) matchDataSend(logger *zap.Logger, session Session, envelope *
 			return
 		}
 
-		p.matchRegistry.SendData(matchID, matchIDComponents[1], session.UserID(), session.ID(), session.Username(), p.node, incoming.OpCode, incoming.Data, incoming.Reliable, time.Now().UTC().UnixNano()/int64(time.Millisecond))
+		seq, err := parseMatchDataSequence(envelope.Cid)
+		if err != nil {
+			session.Send(&rtapi.Envelope{Message: &rtapi.Envelope_Error{Error: &rtapi.Error{
+				Code:    int32(rtapi.Error_BAD_INPUT),
+				Message: "Invalid match data sequence number",
+			}}}, true)
+			return
+		}
+
+		p.matchRegistry.SendData(matchID, matchIDComponents[1], session.UserID(), session.ID(), session.Username(), p.node, incoming.OpCode, incoming.Data, incoming.Reliable, time.Now().UTC().UnixNano()/int64(time.Millisecond), seq)
 		return
 	}
 
@@ -401,3 +411,12 @@ func (p *Pipeline) matchDataSend(logger *zap.Logger, session Session, envelope *
 
 	p.router.SendToPresenceIDs(logger, presenceIDs, outgoing, incoming.Reliable)
 }
+
+// Match data sends never get a response, so clients may number them through the collation ID to let authoritative
+// matches drop duplicates. An empty collation ID means no sequence number, anything else must be an unsigned integer.
+func parseMatchDataSequence(cid string) (uint64, error) {
+	if cid == "" {
+		return 0, nil
+	}
+	return strconv.ParseUint(cid, 10, 64)
+}
@@ -74,15 +74,21 @@ func (p *Pipeline) matchmakerAdd(logger *zap.Logger, session Session, envelope *
 		return
 	}
 
+	MatchmakerDeliver(logger, p.config, p.router, p.runtime.MatchmakerMatched(), entries)
+}
+
+// MatchmakerDeliver notifies all users in a completed matchmaker grouping, after consulting the matched runtime function
+// if there is one for a match ID to join.
+func MatchmakerDeliver(logger *zap.Logger, config Config, router MessageRouter, fn RuntimeMatchmakerMatchedFunction, entries []*MatchmakerEntry) {
 	var tokenOrMatchID string
 	var isMatchID bool
 
 	// Check if there's a matchmaker matched runtime callback, call it, and see if it returns a match ID.
-	fn := p.runtime.MatchmakerMatched()
 	if fn != nil {
+		var err error
 		tokenOrMatchID, isMatchID, err = fn(context.Background(), entries)
 		if err != nil {
-			p.logger.Error("Error running Matchmaker Matched hook.", zap.Error(err))
+			logger.Error("Error running Matchmaker Matched hook.", zap.Error(err))
 		}
 	}
 
@@ -92,7 +98,7 @@ func (p *Pipeline) matchmakerAdd(logger *zap.Logger, session Session, envelope *
 			"mid": fmt.Sprintf("%v.", uuid.Must(uuid.NewV4()).String()),
 			"exp": time.Now().UTC().Add(30 * time.Second).Unix(),
 		})
-		tokenOrMatchID, _ = token.SignedString([]byte(p.config.GetSession().EncryptionKey))
+		tokenOrMatchID, _ = token.SignedString([]byte(config.GetSession().EncryptionKey))
 	}
 
 	users := make([]*rtapi.MatchmakerMatched_MatchmakerUser, 0, len(entries))
@@ -125,7 +131,7 @@ func (p *Pipeline) matchmakerAdd(logger *zap.Logger, session Session, envelope *
 		outgoing.GetMatchmakerMatched().Ticket = entry.Ticket
 
 		// Route outgoing message.
-		p.router.SendToPresenceIDs(logger, []*PresenceID{{Node: entry.Presence.Node, SessionID: entry.SessionID}}, outgoing, true)
+		router.SendToPresenceIDs(logger, []*PresenceID{{Node: entry.Presence.Node, SessionID: entry.SessionID}}, outgoing, true)
 	}
 }
 
@@ -219,7 +219,7 @@ func (p *Pipeline) statusUnfollow(logger *zap.Logger, session Session, envelope
 	}
 
 	for _, userID := range userIDs {
-		p.tracker.Untrack(session.ID(), PresenceStream{Mode: StreamModeStatus, Subject: userID}, session.UserID())
+		p.tracker.Untrack(session.ID(), PresenceStream{Mode: StreamModeStatus, Subject: userID}, session.UserID(), PresenceReasonLeave)
 	}
 
 	session.Send(&rtapi.Envelope{Cid: envelope.Cid}, true)
@@ -229,7 +229,7 @@ func (p *Pipeline) statusUpdate(logger *zap.Logger, session Session, envelope *r
 	incoming := envelope.GetStatusUpdate()
 
 	if incoming.Status == nil {
-		p.tracker.Untrack(session.ID(), PresenceStream{Mode: StreamModeStatus, Subject: session.UserID()}, session.UserID())
+		p.tracker.Untrack(session.ID(), PresenceStream{Mode: StreamModeStatus, Subject: session.UserID()}, session.UserID(), PresenceReasonLeave)
 
 		session.Send(&rtapi.Envelope{Cid: envelope.Cid}, true)
 		return
@@ -0,0 +1,79 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRuntimeCompressRoundTrip(t *testing.T) {
+	input := []byte(`{"positions":[[1,2],[3,4],[5,6]],"message":"hello hello hello hello"}`)
+
+	compressed, err := RuntimeGzipCompress(input)
+	if err != nil {
+		t.Fatalf("error compressing: %v", err)
+	}
+	output, err := RuntimeGzipDecompress(compressed, RuntimeDecompressMaxBytes)
+	assert.NoError(t, err)
+	assert.Equal(t, input, output)
+
+	compressed, err = RuntimeZlibCompress(input)
+	if err != nil {
+		t.Fatalf("error compressing: %v", err)
+	}
+	output, err = RuntimeZlibDecompress(compressed, RuntimeDecompressMaxBytes)
+	assert.NoError(t, err)
+	assert.Equal(t, input, output)
+
+	// Input in the wrong format is rejected.
+	_, err = RuntimeZlibDecompress(input, RuntimeDecompressMaxBytes)
+	assert.Error(t, err)
+}
+
+func TestRuntimeDecompressBomb(t *testing.T) {
+	// Highly repetitive data compresses to a tiny fraction of its decompressed size.
+	bomb := bytes.Repeat([]byte{0}, RuntimeDecompressMaxBytes+1)
+
+	compressed, err := RuntimeGzipCompress(bomb)
+	if err != nil {
+		t.Fatalf("error compressing: %v", err)
+	}
+	assert.True(t, len(compressed) < len(bomb)/100)
+	_, err = RuntimeGzipDecompress(compressed, RuntimeDecompressMaxBytes)
+	assert.Equal(t, ErrDecompressTooLarge, err)
+
+	compressed, err = RuntimeZlibCompress(bomb)
+	if err != nil {
+		t.Fatalf("error compressing: %v", err)
+	}
+	_, err = RuntimeZlibDecompress(compressed, RuntimeDecompressMaxBytes)
+	assert.Equal(t, ErrDecompressTooLarge, err)
+
+	// Data exactly at the limit is allowed.
+	output, err := RuntimeGzipDecompress(mustGzip(t, bomb[:1024]), 1024)
+	assert.NoError(t, err)
+	assert.Len(t, output, 1024)
+}
+
+func mustGzip(t *testing.T, data []byte) []byte {
+	compressed, err := RuntimeGzipCompress(data)
+	if err != nil {
+		t.Fatalf("error compressing: %v", err)
+	}
+	return compressed
+}
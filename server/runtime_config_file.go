@@ -0,0 +1,90 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// Minimum time between checks of a config file for modifications.
+const runtimeConfigFileCheckInterval = 5 * time.Second
+
+type runtimeConfigFileEntry struct {
+	data      interface{}
+	modTime   time.Time
+	checkTime time.Time
+}
+
+// RuntimeConfigFileCache holds decoded JSON config files, reloading each one when it's modified on disk.
+// Files are checked for modifications at most once per check interval, so frequent reads stay cheap.
+type RuntimeConfigFileCache struct {
+	sync.Mutex
+	checkInterval time.Duration
+	entries       map[string]*runtimeConfigFileEntry
+}
+
+func NewRuntimeConfigFileCache(checkInterval time.Duration) *RuntimeConfigFileCache {
+	return &RuntimeConfigFileCache{
+		checkInterval: checkInterval,
+		entries:       make(map[string]*runtimeConfigFileEntry),
+	}
+}
+
+// Get returns the decoded contents of the JSON file at the given path, loading or reloading it if needed.
+func (c *RuntimeConfigFileCache) Get(path string) (interface{}, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	now := time.Now()
+	entry, found := c.entries[path]
+	if found && now.Sub(entry.checkTime) < c.checkInterval {
+		return entry.data, nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if found && info.ModTime().Equal(entry.modTime) {
+		// Not modified since it was last loaded.
+		entry.checkTime = now
+		return entry.data, nil
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var data interface{}
+	if err := json.Unmarshal(content, &data); err != nil {
+		if found {
+			// Keep serving the last valid contents if the file is mid-write or invalid, and retry on the next check.
+			entry.checkTime = now
+			return entry.data, nil
+		}
+		return nil, err
+	}
+
+	c.entries[path] = &runtimeConfigFileEntry{
+		data:      data,
+		modTime:   info.ModTime(),
+		checkTime: now,
+	}
+	return data, nil
+}
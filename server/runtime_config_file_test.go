@@ -0,0 +1,73 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRuntimeConfigFileCacheReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nakama_runtime_config_file_test")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "balance.json")
+
+	if err := ioutil.WriteFile(path, []byte(`{"drop_rate": 0.1}`), 0644); err != nil {
+		t.Fatalf("error writing config file: %v", err)
+	}
+
+	checkInterval := 100 * time.Millisecond
+	cache := NewRuntimeConfigFileCache(checkInterval)
+
+	data, err := cache.Get(path)
+	if err != nil {
+		t.Fatalf("error reading config file: %v", err)
+	}
+	assert.Equal(t, 0.1, data.(map[string]interface{})["drop_rate"])
+
+	// Ensure the modification time changes even on filesystems with coarse timestamps.
+	if err := ioutil.WriteFile(path, []byte(`{"drop_rate": 0.5}`), 0644); err != nil {
+		t.Fatalf("error writing config file: %v", err)
+	}
+	modTime := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("error updating config file modification time: %v", err)
+	}
+
+	// Changes are not observed until the check interval has passed.
+	data, err = cache.Get(path)
+	if err != nil {
+		t.Fatalf("error reading config file: %v", err)
+	}
+	assert.Equal(t, 0.1, data.(map[string]interface{})["drop_rate"])
+
+	time.Sleep(checkInterval)
+	data, err = cache.Get(path)
+	if err != nil {
+		t.Fatalf("error reading config file: %v", err)
+	}
+	assert.Equal(t, 0.5, data.(map[string]interface{})["drop_rate"])
+
+	_, err = cache.Get(filepath.Join(dir, "missing.json"))
+	assert.Error(t, err)
+}
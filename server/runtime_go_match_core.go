@@ -19,8 +19,11 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"runtime/debug"
+	"time"
 
 	"github.com/gofrs/uuid"
+	"github.com/heroiclabs/nakama-common/api"
 	"github.com/heroiclabs/nakama-common/rtapi"
 	"github.com/heroiclabs/nakama-common/runtime"
 	"go.uber.org/atomic"
@@ -29,15 +32,40 @@ import (
 
 var ErrMatchStopped = errors.New("match stopped")
 
+// RuntimeGoMatchSignal may optionally be implemented by Go matches that want to receive signals. Matches that do not
+// implement it keep their state unchanged and reply with an empty result to any signals sent to them.
+type RuntimeGoMatchSignal interface {
+	MatchSignal(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, dispatcher runtime.MatchDispatcher, tick int64, state interface{}, data string) (interface{}, string)
+}
+
+// RuntimeGoMatchParamsSchema may optionally be implemented by Go matches that want their params validated against a
+// schema before MatchInit runs. Match creation fails with a descriptive error if the params don't match.
+type RuntimeGoMatchParamsSchema interface {
+	MatchParamsSchema() map[string]string
+}
+
+// Functions not in runtime.MatchDispatcher are server-side extensions, they are not part of the public runtime interface.
 type RuntimeGoMatchCore struct {
 	logger        *zap.Logger
+	config        Config
 	matchRegistry MatchRegistry
 	router        MessageRouter
 
 	deferMessageFn RuntimeMatchDeferMessageFunction
 	presenceList   *MatchPresenceList
+	opCodeFilter   *MatchOpCodeFilter
+	dedupFilter    *MatchDedupFilter
+	receipts       *MatchReceiptTracker
+	finalMessage   *MatchFinalMessage
 
 	match runtime.Match
+	// Real time elapsed since the previous match loop, only valid during a match loop invocation.
+	loopDelta time.Duration
+	// Input queue depth when the current match loop started, and the queue size.
+	inputQueueDepth int
+	inputQueueSize  int
+	// Receipts that expired before the current match loop started.
+	expiredReceipts []string
 
 	id      uuid.UUID
 	node    string
@@ -54,7 +82,7 @@ type RuntimeGoMatchCore struct {
 	ctxCancelFn context.CancelFunc
 }
 
-func NewRuntimeGoMatchCore(logger *zap.Logger, matchRegistry MatchRegistry, router MessageRouter, id uuid.UUID, node string, stopped *atomic.Bool, db *sql.DB, env map[string]string, nk runtime.NakamaModule, match runtime.Match) (RuntimeMatchCore, error) {
+func NewRuntimeGoMatchCore(logger *zap.Logger, config Config, matchRegistry MatchRegistry, metrics *Metrics, router MessageRouter, id uuid.UUID, node string, stopped *atomic.Bool, db *sql.DB, env map[string]string, nk runtime.NakamaModule, match runtime.Match) (RuntimeMatchCore, error) {
 	ctx, ctxCancelFn := context.WithCancel(context.Background())
 	ctx = NewRuntimeGoContext(ctx, node, env, RuntimeExecutionModeMatch, nil, 0, "", "", nil, "", "", "")
 	ctx = context.WithValue(ctx, runtime.RUNTIME_CTX_MATCH_ID, fmt.Sprintf("%v.%v", id.String(), node))
@@ -62,11 +90,16 @@ func NewRuntimeGoMatchCore(logger *zap.Logger, matchRegistry MatchRegistry, rout
 
 	return &RuntimeGoMatchCore{
 		logger:        logger,
+		config:        config,
 		matchRegistry: matchRegistry,
 		router:        router,
 
 		// deferMessageFn set in MatchInit.
 		// presenceList set in MatchInit.
+		opCodeFilter: NewMatchOpCodeFilter(metrics),
+		dedupFilter:  NewMatchDedupFilter(config.GetMatch().DedupWindow),
+		receipts:     NewMatchReceiptTracker(config.GetMatch().MaxPendingReceipts, time.Duration(config.GetMatch().ReceiptTimeoutMs)*time.Millisecond),
+		finalMessage: &MatchFinalMessage{},
 
 		match: match,
 
@@ -81,7 +114,8 @@ func NewRuntimeGoMatchCore(logger *zap.Logger, matchRegistry MatchRegistry, rout
 		},
 		label: atomic.NewString(""),
 
-		runtimeLogger: NewRuntimeGoLogger(logger),
+		// Tag all log output from match callbacks with the match ID, callbacks also add the current tick.
+		runtimeLogger: NewRuntimeGoLogger(logger).WithField("match_id", fmt.Sprintf("%v.%v", id.String(), node)),
 		db:            db,
 		nk:            nk,
 		ctx:           ctx,
@@ -90,8 +124,25 @@ func NewRuntimeGoMatchCore(logger *zap.Logger, matchRegistry MatchRegistry, rout
 	}, nil
 }
 
-func (r *RuntimeGoMatchCore) MatchInit(presenceList *MatchPresenceList, deferMessageFn RuntimeMatchDeferMessageFunction, params map[string]interface{}) (interface{}, int, error) {
+func (r *RuntimeGoMatchCore) MatchInit(presenceList *MatchPresenceList, deferMessageFn RuntimeMatchDeferMessageFunction, params map[string]interface{}) (_ interface{}, _ int, err error) {
+	defer r.recoverPanic("MatchInit", &err)
+
+	// Reject params that don't match the declared schema before the handler sees them.
+	if m, ok := r.match.(RuntimeGoMatchParamsSchema); ok {
+		schema := MatchParamsSchema(m.MatchParamsSchema())
+		if err := schema.Validate(); err != nil {
+			return nil, 0, err
+		}
+		if err := schema.ValidateParams(params); err != nil {
+			return nil, 0, err
+		}
+	}
+
 	state, tickRate, label := r.match.MatchInit(r.ctx, r.runtimeLogger, r.db, r.nk, params)
+	if state == nil && !r.config.GetMatch().StrictInitState {
+		// Lenient mode allows matches with no meaningful initial state to omit it.
+		state = make(map[string]interface{})
+	}
 
 	if len(label) > MatchLabelMaxBytes {
 		return nil, 0, fmt.Errorf("MatchInit returned invalid label, must be %v bytes or less", MatchLabelMaxBytes)
@@ -114,12 +165,15 @@ func (r *RuntimeGoMatchCore) MatchInit(presenceList *MatchPresenceList, deferMes
 	return state, tickRate, nil
 }
 
-func (r *RuntimeGoMatchCore) MatchJoinAttempt(tick int64, state interface{}, userID, sessionID uuid.UUID, username string, sessionExpiry int64, vars map[string]string, clientIP, clientPort, node string, metadata map[string]string) (interface{}, bool, string, error) {
+func (r *RuntimeGoMatchCore) MatchJoinAttempt(tick int64, state interface{}, userID, sessionID uuid.UUID, username string, sessionExpiry int64, vars map[string]string, clientIP, clientPort, node string, metadata map[string]string, reserved bool) (_ interface{}, _ bool, _ string, err error) {
+	defer r.recoverPanic("MatchJoinAttempt", &err)
+
 	presence := &MatchPresence{
 		Node:      node,
 		UserID:    userID,
 		SessionID: sessionID,
 		Username:  username,
+		Reserved:  reserved,
 	}
 
 	// Prepare a temporary context that includes the user's session info on top of the base match context.
@@ -137,48 +191,118 @@ func (r *RuntimeGoMatchCore) MatchJoinAttempt(tick int64, state interface{}, use
 		ctx = context.WithValue(ctx, runtime.RUNTIME_CTX_CLIENT_PORT, clientPort)
 	}
 
-	newState, allow, reason := r.match.MatchJoinAttempt(ctx, r.runtimeLogger, r.db, r.nk, r, tick, state, presence, metadata)
+	newState, allow, reason := r.match.MatchJoinAttempt(ctx, r.runtimeLogger.WithField("tick", tick), r.db, r.nk, r, tick, state, presence, metadata)
 	return newState, allow, reason, nil
 }
 
-func (r *RuntimeGoMatchCore) MatchJoin(tick int64, state interface{}, joins []*MatchPresence) (interface{}, error) {
+func (r *RuntimeGoMatchCore) MatchJoin(tick int64, state interface{}, joins []*MatchPresence) (_ interface{}, err error) {
+	defer r.recoverPanic("MatchJoin", &err)
+
 	presences := make([]runtime.Presence, len(joins))
 	for i, join := range joins {
 		presences[i] = runtime.Presence(join)
 	}
 
-	newState := r.match.MatchJoin(r.ctx, r.runtimeLogger, r.db, r.nk, r, tick, state, presences)
+	newState := r.match.MatchJoin(r.ctx, r.runtimeLogger.WithField("tick", tick), r.db, r.nk, r, tick, state, presences)
 	return newState, nil
 }
 
-func (r *RuntimeGoMatchCore) MatchLeave(tick int64, state interface{}, leaves []*MatchPresence) (interface{}, error) {
+func (r *RuntimeGoMatchCore) MatchLeave(tick int64, state interface{}, leaves []*MatchPresence) (_ interface{}, err error) {
+	defer r.recoverPanic("MatchLeave", &err)
+
+	r.receipts.Leave(leaves)
+	r.dedupFilter.Leave(leaves)
+
 	presences := make([]runtime.Presence, len(leaves))
 	for i, leave := range leaves {
 		presences[i] = runtime.Presence(leave)
 	}
 
-	newState := r.match.MatchLeave(r.ctx, r.runtimeLogger, r.db, r.nk, r, tick, state, presences)
+	newState := r.match.MatchLeave(r.ctx, r.runtimeLogger.WithField("tick", tick), r.db, r.nk, r, tick, state, presences)
 	return newState, nil
 }
 
-func (r *RuntimeGoMatchCore) MatchLoop(tick int64, state interface{}, inputCh <-chan *MatchDataMessage) (interface{}, error) {
-	// Drain the input queue into a slice.
+func (r *RuntimeGoMatchCore) MatchLoop(tick int64, state interface{}, inputCh <-chan *MatchDataMessage, delta time.Duration) (_ interface{}, err error) {
+	defer r.recoverPanic("MatchLoop", &err)
+
+	r.loopDelta = delta
+
+	// Drain the input queue into a slice, dropping any duplicates or op codes the match does not accept.
 	size := len(inputCh)
-	messages := make([]runtime.MatchData, size)
+	r.inputQueueDepth, r.inputQueueSize = size, cap(inputCh)
+	r.expiredReceipts = r.receipts.Expire(time.Now())
+	messages := make([]runtime.MatchData, 0, size)
 	for i := 0; i < size; i++ {
 		msg := <-inputCh
-		messages[i] = runtime.MatchData(msg)
+		if !r.dedupFilter.Allow(msg.SessionID, msg.Seq) {
+			r.logger.Debug("Dropping duplicate match data", zap.Uint64("seq", msg.Seq), zap.String("uid", msg.UserID.String()))
+			continue
+		}
+		if r.receipts.Intercepts(msg.OpCode, r.config.GetMatch().ReceiptOpCode) {
+			if !r.receipts.Ack(string(msg.Data), msg.SessionID) {
+				r.logger.Debug("Dropping unknown match receipt acknowledgement", zap.String("uid", msg.UserID.String()))
+				continue
+			}
+		} else if !r.opCodeFilter.Allow(msg.OpCode) {
+			r.logger.Debug("Dropping match data with disallowed op code", zap.Int64("op_code", msg.OpCode), zap.String("uid", msg.UserID.String()))
+			continue
+		}
+		messages = append(messages, runtime.MatchData(msg))
 	}
 
-	newState := r.match.MatchLoop(r.ctx, r.runtimeLogger, r.db, r.nk, r, tick, state, messages)
+	newState := r.match.MatchLoop(r.ctx, r.runtimeLogger.WithField("tick", tick), r.db, r.nk, r, tick, state, messages)
 	return newState, nil
 }
 
-func (r *RuntimeGoMatchCore) MatchTerminate(tick int64, state interface{}, graceSeconds int) (interface{}, error) {
-	newState := r.match.MatchTerminate(r.ctx, r.runtimeLogger, r.db, r.nk, r, tick, state, graceSeconds)
+// MatchLoopDelta returns the real time elapsed since the previous match loop started.
+func (r *RuntimeGoMatchCore) MatchLoopDelta() time.Duration {
+	return r.loopDelta
+}
+
+// MatchInputQueueDepth returns the number of messages queued when the current loop started, and the queue size.
+func (r *RuntimeGoMatchCore) MatchInputQueueDepth() (int, int) {
+	return r.inputQueueDepth, r.inputQueueSize
+}
+
+// MatchExpiredReceipts returns the IDs of delivery receipts that expired unacknowledged before the current loop.
+func (r *RuntimeGoMatchCore) MatchExpiredReceipts() []string {
+	return r.expiredReceipts
+}
+
+func (r *RuntimeGoMatchCore) MatchTerminate(tick int64, state interface{}, graceSeconds int) (_ interface{}, err error) {
+	defer r.recoverPanic("MatchTerminate", &err)
+
+	newState := r.match.MatchTerminate(r.ctx, r.runtimeLogger.WithField("tick", tick), r.db, r.nk, r, tick, state, graceSeconds)
 	return newState, nil
 }
 
+func (r *RuntimeGoMatchCore) MatchSignal(tick int64, state interface{}, data string) (_ interface{}, _ string, err error) {
+	defer r.recoverPanic("MatchSignal", &err)
+
+	signalMatch, ok := r.match.(RuntimeGoMatchSignal)
+	if !ok {
+		return state, "", nil
+	}
+
+	newState, result := signalMatch.MatchSignal(r.ctx, r.runtimeLogger.WithField("tick", tick), r.db, r.nk, r, tick, state, data)
+	return newState, result, nil
+}
+
+// recoverPanic converts a panic raised inside a match handler callback into an error carrying the match ID and stack
+// trace, so the match handler stops this match cleanly instead of the panic taking down the node. It must be deferred
+// directly by the callback it guards.
+func (r *RuntimeGoMatchCore) recoverPanic(callback string, err *error) {
+	if p := recover(); p != nil {
+		stack := debug.Stack()
+		r.logger.Error("Match handler panicked", zap.String("callback", callback), zap.Any("panic", p), zap.ByteString("stack", stack))
+		*err = fmt.Errorf("%v panicked in match %v: %v\n%s", callback, r.idStr, p, stack)
+	}
+}
+
+func (r *RuntimeGoMatchCore) MatchFinalMessage() ([]*PresenceID, *rtapi.Envelope) {
+	return r.finalMessage.Resolve(r.presenceList)
+}
+
 func (r *RuntimeGoMatchCore) Label() string {
 	return r.label.Load()
 }
@@ -205,6 +329,46 @@ func (r *RuntimeGoMatchCore) BroadcastMessage(opCode int64, data []byte, presenc
 	return nil
 }
 
+// BroadcastFinalMessage registers a reliable message to be sent when the match stops.
+func (r *RuntimeGoMatchCore) BroadcastFinalMessage(opCode int64, data []byte, presences []runtime.Presence, sender runtime.Presence) error {
+	if r.stopped.Load() {
+		return ErrMatchStopped
+	}
+
+	presenceIDs, msg, err := r.validateBroadcast(opCode, data, presences, sender, true)
+	if err != nil {
+		return err
+	}
+	r.finalMessage.Set(presenceIDs, msg, presences == nil)
+
+	return nil
+}
+
+// BroadcastMessageWithReceipt sends a reliable message and requests a delivery receipt for it.
+func (r *RuntimeGoMatchCore) BroadcastMessageWithReceipt(opCode int64, data []byte, presences []runtime.Presence, sender runtime.Presence, receiptID string) error {
+	if r.stopped.Load() {
+		return ErrMatchStopped
+	}
+	if receiptID == "" {
+		return errors.New("expects a receipt ID")
+	}
+
+	presenceIDs, msg, err := r.validateBroadcast(opCode, data, presences, sender, true)
+	if err != nil {
+		return err
+	}
+	if len(presenceIDs) == 0 {
+		return nil
+	}
+	if err := r.receipts.Add(receiptID, presenceIDs); err != nil {
+		return err
+	}
+
+	r.router.SendToPresenceIDs(r.logger, presenceIDs, msg, true)
+
+	return nil
+}
+
 func (r *RuntimeGoMatchCore) BroadcastMessageDeferred(opCode int64, data []byte, presences []runtime.Presence, sender runtime.Presence, reliable bool) error {
 	if r.stopped.Load() {
 		return ErrMatchStopped
@@ -225,6 +389,54 @@ func (r *RuntimeGoMatchCore) BroadcastMessageDeferred(opCode int64, data []byte,
 	})
 }
 
+// BroadcastMessageByVar sends a message to all match presences with a session var key set to the given value.
+func (r *RuntimeGoMatchCore) BroadcastMessageByVar(opCode int64, data []byte, key, value string, sender runtime.Presence, reliable bool) error {
+	matches := r.presenceList.ListPresencesByVar(key, value)
+	if len(matches) == 0 {
+		return nil
+	}
+
+	presences := make([]runtime.Presence, 0, len(matches))
+	for _, presence := range matches {
+		presences = append(presences, presence)
+	}
+	return r.BroadcastMessage(opCode, data, presences, sender, reliable)
+}
+
+// MatchPresenceList returns the current match presences, including their session vars.
+func (r *RuntimeGoMatchCore) MatchPresenceList() []runtime.Presence {
+	matchPresences := r.presenceList.ListPresences()
+	presences := make([]runtime.Presence, 0, len(matchPresences))
+	for _, presence := range matchPresences {
+		presences = append(presences, presence)
+	}
+	return presences
+}
+
+// MatchHasSpace reports whether the match has room for the given session under the given maximum size.
+func (r *RuntimeGoMatchCore) MatchHasSpace(sessionID string, max int) bool {
+	return r.presenceList.HasSpace(uuid.FromStringOrNil(sessionID), max)
+}
+
+// MatchMaxSize sets the maximum number of presences the match accepts, 0 removes the limit.
+func (r *RuntimeGoMatchCore) MatchMaxSize(max int) error {
+	if max < 0 {
+		return errors.New("expects max size to be >= 0")
+	}
+	r.presenceList.SetMaxSize(max)
+	return nil
+}
+
+// MatchAllowedOpCodes restricts the client input op codes delivered to the match loop, nil accepts all again.
+func (r *RuntimeGoMatchCore) MatchAllowedOpCodes(opCodes []int64) {
+	r.opCodeFilter.SetAllowed(opCodes)
+}
+
+// MatchDedup enables or disables dropping duplicate client input based on match data sequence numbers.
+func (r *RuntimeGoMatchCore) MatchDedup(enabled bool) {
+	r.dedupFilter.SetEnabled(enabled)
+}
+
 func (r *RuntimeGoMatchCore) validateBroadcast(opCode int64, data []byte, presences []runtime.Presence, sender runtime.Presence, reliable bool) ([]*PresenceID, *rtapi.Envelope, error) {
 	var presenceIDs []*PresenceID
 	if presences != nil {
@@ -329,6 +541,11 @@ func (r *RuntimeGoMatchCore) validateBroadcast(opCode int64, data []byte, presen
 }
 
 func (r *RuntimeGoMatchCore) MatchKick(presences []runtime.Presence) error {
+	return r.MatchKickWithReason(presences, "", "")
+}
+
+// MatchKickWithReason kicks presences from the match, recording the kicker and reason in the kick audit.
+func (r *RuntimeGoMatchCore) MatchKickWithReason(presences []runtime.Presence, kicker, reason string) error {
 	if r.stopped.Load() {
 		return ErrMatchStopped
 	}
@@ -358,6 +575,7 @@ func (r *RuntimeGoMatchCore) MatchKick(presences []runtime.Presence) error {
 		}
 	}
 
+	AuditMatchKick(r.logger, r.db, r.config.GetMatch(), r.idStr, kicker, reason, matchPresences)
 	r.matchRegistry.Kick(r.stream, matchPresences)
 	return nil
 }
@@ -370,7 +588,13 @@ func (r *RuntimeGoMatchCore) MatchLabelUpdate(label string) error {
 	if err := r.matchRegistry.UpdateMatchLabel(r.id, label); err != nil {
 		return fmt.Errorf("error updating match label: %v", err.Error())
 	}
+	previous := r.label.Load()
 	r.label.Store(label)
+	if r.nk != nil {
+		EmitMatchLabelUpdateEvent(r.ctx, r.config, func(ctx context.Context, evt *api.Event) {
+			_ = r.nk.Event(ctx, evt)
+		}, r.idStr, previous, label)
+	}
 
 	// This must be executed from inside a match call so safe to update here.
 	r.ctx = context.WithValue(r.ctx, runtime.RUNTIME_CTX_MATCH_LABEL, label)
@@ -0,0 +1,525 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/heroiclabs/nakama-common/api"
+	"github.com/heroiclabs/nakama-common/rtapi"
+	"github.com/heroiclabs/nakama-common/runtime"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/tally"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+type testMessageRouter struct {
+	DummyMessageRouter
+	presenceIDs []*PresenceID
+}
+
+func (r *testMessageRouter) SendToPresenceIDs(logger *zap.Logger, presenceIDs []*PresenceID, envelope *rtapi.Envelope, reliable bool) {
+	r.presenceIDs = append(r.presenceIDs, presenceIDs...)
+}
+
+func TestRuntimeGoMatchCoreBroadcastMessageByVar(t *testing.T) {
+	matchRegistry, _, _ := newTestMatchRegistry(nil)
+	router := &testMessageRouter{}
+	core, err := NewRuntimeGoMatchCore(logger, cfg, matchRegistry, metrics, router, uuid.Must(uuid.NewV4()), cfg.GetName(), atomic.NewBool(false), nil, nil, nil, &testMatch{})
+	if err != nil {
+		t.Fatalf("error creating match core: %v", err)
+	}
+	presenceList := NewMatchPresenceList()
+	if _, _, err := core.MatchInit(presenceList, nil, nil); err != nil {
+		t.Fatalf("error initialising match: %v", err)
+	}
+
+	red1 := &MatchPresence{Node: cfg.GetName(), UserID: uuid.Must(uuid.NewV4()), SessionID: uuid.Must(uuid.NewV4()), Username: "red1", Vars: map[string]string{"team": "red"}}
+	red2 := &MatchPresence{Node: cfg.GetName(), UserID: uuid.Must(uuid.NewV4()), SessionID: uuid.Must(uuid.NewV4()), Username: "red2", Vars: map[string]string{"team": "red"}}
+	blue := &MatchPresence{Node: cfg.GetName(), UserID: uuid.Must(uuid.NewV4()), SessionID: uuid.Must(uuid.NewV4()), Username: "blue", Vars: map[string]string{"team": "blue"}}
+	noVars := &MatchPresence{Node: cfg.GetName(), UserID: uuid.Must(uuid.NewV4()), SessionID: uuid.Must(uuid.NewV4()), Username: "none"}
+	presenceList.Join([]*MatchPresence{red1, blue, red2, noVars})
+
+	goCore := core.(*RuntimeGoMatchCore)
+	presences := goCore.MatchPresenceList()
+	assert.Len(t, presences, 4)
+	assert.Equal(t, "red", presences[0].(*MatchPresence).GetVars()["team"])
+
+	if err := goCore.BroadcastMessageByVar(1, []byte("attack"), "team", "red", nil, true); err != nil {
+		t.Fatalf("error broadcasting message: %v", err)
+	}
+	sessionIDs := make([]uuid.UUID, 0, len(router.presenceIDs))
+	for _, presenceID := range router.presenceIDs {
+		sessionIDs = append(sessionIDs, presenceID.SessionID)
+	}
+	assert.ElementsMatch(t, []uuid.UUID{red1.SessionID, red2.SessionID}, sessionIDs)
+
+	// No matching presences means no broadcast at all.
+	router.presenceIDs = nil
+	if err := goCore.BroadcastMessageByVar(1, []byte("attack"), "team", "green", nil, true); err != nil {
+		t.Fatalf("error broadcasting message: %v", err)
+	}
+	assert.Empty(t, router.presenceIDs)
+}
+
+func TestRuntimeGoMatchCoreMatchHasSpace(t *testing.T) {
+	matchRegistry, _, _ := newTestMatchRegistry(nil)
+	core, err := NewRuntimeGoMatchCore(logger, cfg, matchRegistry, metrics, &testMessageRouter{}, uuid.Must(uuid.NewV4()), cfg.GetName(), atomic.NewBool(false), nil, nil, nil, &testMatch{})
+	if err != nil {
+		t.Fatalf("error creating match core: %v", err)
+	}
+	presenceList := NewMatchPresenceList()
+	if _, _, err := core.MatchInit(presenceList, nil, nil); err != nil {
+		t.Fatalf("error initialising match: %v", err)
+	}
+	goCore := core.(*RuntimeGoMatchCore)
+
+	presenceList.Join([]*MatchPresence{
+		{Node: cfg.GetName(), UserID: uuid.Must(uuid.NewV4()), SessionID: uuid.Must(uuid.NewV4()), Username: "a"},
+		{Node: cfg.GetName(), UserID: uuid.Must(uuid.NewV4()), SessionID: uuid.Must(uuid.NewV4()), Username: "b"},
+	})
+	assert.True(t, goCore.MatchHasSpace("", 3))
+	assert.False(t, goCore.MatchHasSpace("", 2))
+
+	// Outstanding reservations take up places until they are used or expire.
+	reserved := uuid.Must(uuid.NewV4())
+	presenceList.Reserve([]uuid.UUID{reserved}, time.Now().Add(time.Minute))
+	assert.False(t, goCore.MatchHasSpace("", 3))
+	presenceList.ReleaseReservation(reserved)
+	assert.True(t, goCore.MatchHasSpace("", 3))
+
+	presenceList.Reserve([]uuid.UUID{reserved}, time.Now().Add(-time.Second))
+	assert.True(t, goCore.MatchHasSpace("", 3))
+}
+
+func TestRuntimeGoMatchCoreMatchHasSpaceOwnReservation(t *testing.T) {
+	matchRegistry, _, _ := newTestMatchRegistry(nil)
+	core, err := NewRuntimeGoMatchCore(logger, cfg, matchRegistry, metrics, &testMessageRouter{}, uuid.Must(uuid.NewV4()), cfg.GetName(), atomic.NewBool(false), nil, nil, nil, &testMatch{})
+	if err != nil {
+		t.Fatalf("error creating match core: %v", err)
+	}
+	presenceList := NewMatchPresenceList()
+	if _, _, err := core.MatchInit(presenceList, nil, nil); err != nil {
+		t.Fatalf("error initialising match: %v", err)
+	}
+	goCore := core.(*RuntimeGoMatchCore)
+
+	// Both places in a match of 2 are reserved, so only the reserved sessions themselves have space.
+	first := uuid.Must(uuid.NewV4())
+	second := uuid.Must(uuid.NewV4())
+	presenceList.Reserve([]uuid.UUID{first, second}, time.Now().Add(time.Minute))
+	assert.True(t, goCore.MatchHasSpace(first.String(), 2))
+	assert.True(t, goCore.MatchHasSpace(second.String(), 2))
+	assert.False(t, goCore.MatchHasSpace(uuid.Must(uuid.NewV4()).String(), 2))
+
+	// Once the first reserved session joins, the second still has its place.
+	presenceList.ReleaseReservation(first)
+	presenceList.Join([]*MatchPresence{{Node: cfg.GetName(), UserID: uuid.Must(uuid.NewV4()), SessionID: first, Username: "a"}})
+	assert.True(t, goCore.MatchHasSpace(second.String(), 2))
+	assert.False(t, goCore.MatchHasSpace(uuid.Must(uuid.NewV4()).String(), 2))
+}
+
+// Returns no state from match init.
+type testNoStateMatch struct {
+	testMatch
+}
+
+func (m *testNoStateMatch) MatchInit(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, params map[string]interface{}) (interface{}, int, string) {
+	return nil, 10, "test"
+}
+
+func TestRuntimeGoMatchCoreInitStateStrict(t *testing.T) {
+	matchRegistry, _, _ := newTestMatchRegistry(nil)
+	core, err := NewRuntimeGoMatchCore(logger, cfg, matchRegistry, metrics, &testMessageRouter{}, uuid.Must(uuid.NewV4()), cfg.GetName(), atomic.NewBool(false), nil, nil, nil, &testNoStateMatch{})
+	if err != nil {
+		t.Fatalf("error creating match core: %v", err)
+	}
+
+	// Strict mode passes the omitted state through, which the match handler rejects.
+	_, err = NewMatchHandler(logger, cfg, nil, matchRegistry, &testMessageRouter{}, metrics, core, uuid.Must(uuid.NewV4()), cfg.GetName(), atomic.NewBool(false), nil, nil)
+	assert.EqualError(t, err, "Match initial state must not be nil")
+}
+
+func TestRuntimeGoMatchCoreInitStateLenient(t *testing.T) {
+	lenientCfg, err := cfg.Clone()
+	if err != nil {
+		t.Fatalf("error cloning config: %v", err)
+	}
+	lenientCfg.GetMatch().StrictInitState = false
+
+	matchRegistry, _, _ := newTestMatchRegistry(nil)
+	core, err := NewRuntimeGoMatchCore(logger, lenientCfg, matchRegistry, metrics, &testMessageRouter{}, uuid.Must(uuid.NewV4()), cfg.GetName(), atomic.NewBool(false), nil, nil, nil, &testNoStateMatch{})
+	if err != nil {
+		t.Fatalf("error creating match core: %v", err)
+	}
+
+	// Lenient mode defaults the omitted state to an empty object.
+	state, tickRate, err := core.MatchInit(NewMatchPresenceList(), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{}, state)
+	assert.Equal(t, 10, tickRate)
+}
+
+func TestRuntimeGoMatchCoreAllowedOpCodes(t *testing.T) {
+	match := &testLoopMatch{loopCh: make(chan []runtime.MatchData, 1)}
+	matchRegistry, _, _ := newTestMatchRegistry(nil)
+	scope := tally.NewTestScope("", nil)
+	core, err := NewRuntimeGoMatchCore(logger, cfg, matchRegistry, &Metrics{prometheusScope: scope}, &testMessageRouter{}, uuid.Must(uuid.NewV4()), cfg.GetName(), atomic.NewBool(false), nil, nil, nil, match)
+	if err != nil {
+		t.Fatalf("error creating match core: %v", err)
+	}
+	state, _, err := core.MatchInit(NewMatchPresenceList(), nil, nil)
+	if err != nil {
+		t.Fatalf("error initialising match: %v", err)
+	}
+
+	loopOpCodes := func(opCodes ...int64) []int64 {
+		inputCh := make(chan *MatchDataMessage, len(opCodes))
+		for _, opCode := range opCodes {
+			inputCh <- &MatchDataMessage{UserID: uuid.Must(uuid.NewV4()), SessionID: uuid.Must(uuid.NewV4()), OpCode: opCode}
+		}
+		if _, err := core.MatchLoop(1, state, inputCh, 0); err != nil {
+			t.Fatalf("error running match loop: %v", err)
+		}
+		received := make([]int64, 0, len(opCodes))
+		for _, message := range <-match.loopCh {
+			received = append(received, message.GetOpCode())
+		}
+		return received
+	}
+
+	// All op codes are accepted until an allowed set is configured.
+	assert.Equal(t, []int64{1, 2, 3}, loopOpCodes(1, 2, 3))
+
+	core.(*RuntimeGoMatchCore).MatchAllowedOpCodes([]int64{1, 2})
+	assert.Equal(t, []int64{1, 2, 1}, loopOpCodes(1, 2, 3, 1, 4))
+	assert.EqualValues(t, 2, countMatchOpCodeDropped(scope))
+
+	// Removing the filter accepts all op codes again.
+	core.(*RuntimeGoMatchCore).MatchAllowedOpCodes(nil)
+	assert.Equal(t, []int64{3, 4}, loopOpCodes(3, 4))
+	assert.EqualValues(t, 2, countMatchOpCodeDropped(scope))
+}
+
+// Sum the dropped op code counter reported to a test metrics scope.
+func countMatchOpCodeDropped(scope tally.TestScope) int64 {
+	var dropped int64
+	for _, counter := range scope.Snapshot().Counters() {
+		if counter.Name() == "authoritative_match_op_code_dropped" {
+			dropped += counter.Value()
+		}
+	}
+	return dropped
+}
+
+func TestRuntimeGoMatchCoreBroadcastMessageWithReceipt(t *testing.T) {
+	receiptCfg, err := cfg.Clone()
+	if err != nil {
+		t.Fatalf("error cloning config: %v", err)
+	}
+	receiptCfg.GetMatch().ReceiptOpCode = 99
+	receiptCfg.GetMatch().MaxPendingReceipts = 2
+	receiptCfg.GetMatch().ReceiptTimeoutMs = 200
+
+	match := &testLoopMatch{loopCh: make(chan []runtime.MatchData, 1)}
+	matchRegistry, _, _ := newTestMatchRegistry(nil)
+	router := &testMessageRouter{}
+	core, err := NewRuntimeGoMatchCore(logger, receiptCfg, matchRegistry, metrics, router, uuid.Must(uuid.NewV4()), cfg.GetName(), atomic.NewBool(false), nil, nil, nil, match)
+	if err != nil {
+		t.Fatalf("error creating match core: %v", err)
+	}
+	presenceList := NewMatchPresenceList()
+	state, _, err := core.MatchInit(presenceList, nil, nil)
+	if err != nil {
+		t.Fatalf("error initialising match: %v", err)
+	}
+	alice := &MatchPresence{Node: cfg.GetName(), UserID: uuid.Must(uuid.NewV4()), SessionID: uuid.Must(uuid.NewV4()), Username: "alice"}
+	bob := &MatchPresence{Node: cfg.GetName(), UserID: uuid.Must(uuid.NewV4()), SessionID: uuid.Must(uuid.NewV4()), Username: "bob"}
+	presenceList.Join([]*MatchPresence{alice, bob})
+	goCore := core.(*RuntimeGoMatchCore)
+
+	loop := func(messages ...*MatchDataMessage) []runtime.MatchData {
+		inputCh := make(chan *MatchDataMessage, len(messages))
+		for _, message := range messages {
+			inputCh <- message
+		}
+		if _, err := core.MatchLoop(1, state, inputCh, 0); err != nil {
+			t.Fatalf("error running match loop: %v", err)
+		}
+		return <-match.loopCh
+	}
+	ack := func(presence *MatchPresence, receiptID string) *MatchDataMessage {
+		return &MatchDataMessage{UserID: presence.UserID, SessionID: presence.SessionID, Username: presence.Username, OpCode: 99, Data: []byte(receiptID)}
+	}
+
+	// The receipt op code is ordinary match data until a receipt is requested.
+	assert.Len(t, loop(ack(alice, "r1")), 1)
+
+	if err := goCore.BroadcastMessageWithReceipt(1, []byte("r1"), nil, nil, "r1"); err != nil {
+		t.Fatalf("error broadcasting message: %v", err)
+	}
+	assert.Len(t, router.presenceIDs, 2)
+	assert.Equal(t, ErrMatchReceiptExists, goCore.BroadcastMessageWithReceipt(1, []byte("r1"), nil, nil, "r1"))
+
+	// Each recipient's ack produces a receipt in the match loop, unknown and repeated acks are dropped.
+	receipts := loop(ack(alice, "r1"), ack(alice, "r1"), ack(alice, "unknown"))
+	if assert.Len(t, receipts, 1) {
+		assert.Equal(t, alice.SessionID.String(), receipts[0].GetSessionId())
+		assert.EqualValues(t, 99, receipts[0].GetOpCode())
+		assert.Equal(t, []byte("r1"), receipts[0].GetData())
+	}
+	assert.Equal(t, 1, goCore.receipts.Pending())
+	assert.Len(t, loop(ack(bob, "r1")), 1)
+	assert.Equal(t, 0, goCore.receipts.Pending())
+
+	// Outstanding receipts are bounded, and recipients leaving the match release them.
+	assert.NoError(t, goCore.BroadcastMessageWithReceipt(1, nil, nil, nil, "r2"))
+	assert.NoError(t, goCore.BroadcastMessageWithReceipt(1, nil, nil, nil, "r3"))
+	assert.Equal(t, ErrMatchReceiptsFull, goCore.BroadcastMessageWithReceipt(1, nil, nil, nil, "r4"))
+	presenceList.Leave([]*MatchPresence{alice, bob})
+	if _, err := core.MatchLeave(1, state, []*MatchPresence{alice, bob}); err != nil {
+		t.Fatalf("error leaving match: %v", err)
+	}
+	assert.Equal(t, 0, goCore.receipts.Pending())
+
+	// Receipts not acknowledged in time expire, are reported to the next match loop, and release their slot.
+	presenceList.Join([]*MatchPresence{alice})
+	assert.NoError(t, goCore.BroadcastMessageWithReceipt(1, nil, nil, nil, "r5"))
+	loop()
+	assert.Empty(t, goCore.MatchExpiredReceipts())
+	time.Sleep(250 * time.Millisecond)
+	loop()
+	assert.Equal(t, []string{"r5"}, goCore.MatchExpiredReceipts())
+	assert.Equal(t, 0, goCore.receipts.Pending())
+	loop()
+	assert.Empty(t, goCore.MatchExpiredReceipts())
+}
+
+type testSchemaMatch struct {
+	testMatch
+}
+
+func (m *testSchemaMatch) MatchParamsSchema() map[string]string {
+	return map[string]string{
+		"label":  "string",
+		"rounds": "number",
+		"ranked": "boolean?",
+	}
+}
+
+func TestRuntimeGoMatchCoreParamsSchema(t *testing.T) {
+	matchRegistry, _, _ := newTestMatchRegistry(nil)
+	newCore := func() RuntimeMatchCore {
+		core, err := NewRuntimeGoMatchCore(logger, cfg, matchRegistry, metrics, &testMessageRouter{}, uuid.Must(uuid.NewV4()), cfg.GetName(), atomic.NewBool(false), nil, nil, nil, &testSchemaMatch{})
+		if err != nil {
+			t.Fatalf("error creating match core: %v", err)
+		}
+		return core
+	}
+
+	_, _, err := newCore().MatchInit(NewMatchPresenceList(), nil, map[string]interface{}{"label": "ranked", "rounds": int64(3)})
+	assert.NoError(t, err)
+	_, _, err = newCore().MatchInit(NewMatchPresenceList(), nil, map[string]interface{}{"label": "ranked", "rounds": 3.0, "ranked": true})
+	assert.NoError(t, err)
+
+	_, _, err = newCore().MatchInit(NewMatchPresenceList(), nil, map[string]interface{}{"label": "ranked"})
+	assert.EqualError(t, err, "invalid match params: missing required param 'rounds'")
+	_, _, err = newCore().MatchInit(NewMatchPresenceList(), nil, map[string]interface{}{"label": "ranked", "rounds": "3"})
+	assert.EqualError(t, err, "invalid match params: param 'rounds' must be number, got string")
+	_, _, err = newCore().MatchInit(NewMatchPresenceList(), nil, map[string]interface{}{"label": "ranked", "rounds": 3, "mode": "ffa"})
+	assert.EqualError(t, err, "invalid match params: unknown param 'mode'")
+}
+
+type testPanicMatch struct {
+	testMatch
+}
+
+func (m *testPanicMatch) MatchLoop(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, dispatcher runtime.MatchDispatcher, tick int64, state interface{}, messages []runtime.MatchData) interface{} {
+	var nilState map[string]interface{}
+	nilState["tick"] = tick
+	return state
+}
+
+func (m *testPanicMatch) MatchTerminate(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, dispatcher runtime.MatchDispatcher, tick int64, state interface{}, graceSeconds int) interface{} {
+	panic("terminate failed")
+}
+
+func TestRuntimeGoMatchCorePanicRecovery(t *testing.T) {
+	matchRegistry, _, _ := newTestMatchRegistry(nil)
+	id := uuid.Must(uuid.NewV4())
+	core, err := NewRuntimeGoMatchCore(logger, cfg, matchRegistry, metrics, &testMessageRouter{}, id, cfg.GetName(), atomic.NewBool(false), nil, nil, nil, &testPanicMatch{})
+	if err != nil {
+		t.Fatalf("error creating match core: %v", err)
+	}
+	state, _, err := core.MatchInit(NewMatchPresenceList(), nil, nil)
+	if err != nil {
+		t.Fatalf("error initialising match: %v", err)
+	}
+
+	// Panics surface as errors naming the callback and match, with the stack trace attached.
+	_, err = core.MatchLoop(1, state, make(chan *MatchDataMessage), 0)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "MatchLoop panicked in match "+id.String()+"."+cfg.GetName()+": assignment to entry in nil map")
+		assert.Contains(t, err.Error(), "testPanicMatch")
+	}
+	_, err = core.MatchTerminate(2, state, 0)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "MatchTerminate panicked in match "+id.String()+"."+cfg.GetName()+": terminate failed")
+	}
+
+	// Callbacks that don't panic are unaffected.
+	_, err = core.MatchJoin(3, state, nil)
+	assert.NoError(t, err)
+}
+
+func TestMatchParamsSchemaValidate(t *testing.T) {
+	assert.NoError(t, MatchParamsSchema{"a": "string", "b": "number?", "c": "boolean", "d": "table?"}.Validate())
+	assert.EqualError(t, MatchParamsSchema{"a": "int"}.Validate(), "match params schema has invalid type 'int' for param 'a'")
+}
+
+func TestRuntimeGoMatchCoreKickAuditLog(t *testing.T) {
+	auditCfg, err := cfg.Clone()
+	if err != nil {
+		t.Fatalf("error cloning config: %v", err)
+	}
+	auditCfg.GetMatch().KickAuditLog = true
+
+	observerCore, logs := observer.New(zap.InfoLevel)
+	matchRegistry, _, _ := newTestMatchRegistry(nil)
+	core, err := NewRuntimeGoMatchCore(zap.New(observerCore), auditCfg, matchRegistry, metrics, &testMessageRouter{}, uuid.Must(uuid.NewV4()), cfg.GetName(), atomic.NewBool(false), nil, nil, nil, &testMatch{})
+	if err != nil {
+		t.Fatalf("error creating match core: %v", err)
+	}
+	if _, _, err := core.MatchInit(NewMatchPresenceList(), nil, nil); err != nil {
+		t.Fatalf("error initialising match: %v", err)
+	}
+
+	kicked := &MatchPresence{Node: cfg.GetName(), UserID: uuid.Must(uuid.NewV4()), SessionID: uuid.Must(uuid.NewV4()), Username: "kicked"}
+	kicker := uuid.Must(uuid.NewV4()).String()
+	if err := core.(*RuntimeGoMatchCore).MatchKickWithReason([]runtime.Presence{kicked}, kicker, "cheating"); err != nil {
+		t.Fatalf("error kicking presence: %v", err)
+	}
+
+	entries := logs.FilterMessage("Match kick").All()
+	if assert.Len(t, entries, 1) {
+		fields := entries[0].ContextMap()
+		assert.Equal(t, core.(*RuntimeGoMatchCore).idStr, fields["mid"])
+		assert.Equal(t, kicker, fields["kicker"])
+		assert.Equal(t, kicked.UserID.String(), fields["uid"])
+		assert.Equal(t, kicked.SessionID.String(), fields["sid"])
+		assert.Equal(t, "cheating", fields["reason"])
+	}
+}
+
+func TestRuntimeGoMatchCoreKickAuditStorage(t *testing.T) {
+	db := NewDB(t)
+	defer db.Close()
+
+	auditCfg, err := cfg.Clone()
+	if err != nil {
+		t.Fatalf("error cloning config: %v", err)
+	}
+	collection := "match_kick_audit_" + uuid.Must(uuid.NewV4()).String()
+	auditCfg.GetMatch().KickAuditCollection = collection
+
+	kickedUserID := uuid.Must(uuid.NewV4())
+
+	matchRegistry, _, _ := newTestMatchRegistry(nil)
+	core, err := NewRuntimeGoMatchCore(logger, auditCfg, matchRegistry, metrics, &testMessageRouter{}, uuid.Must(uuid.NewV4()), cfg.GetName(), atomic.NewBool(false), db, nil, nil, &testMatch{})
+	if err != nil {
+		t.Fatalf("error creating match core: %v", err)
+	}
+	if _, _, err := core.MatchInit(NewMatchPresenceList(), nil, nil); err != nil {
+		t.Fatalf("error initialising match: %v", err)
+	}
+
+	kicked := &MatchPresence{Node: cfg.GetName(), UserID: kickedUserID, SessionID: uuid.Must(uuid.NewV4()), Username: "kicked"}
+	if err := core.(*RuntimeGoMatchCore).MatchKickWithReason([]runtime.Presence{kicked}, "", "afk"); err != nil {
+		t.Fatalf("error kicking presence: %v", err)
+	}
+
+	// Entries are written in the background, as system-owned objects hidden from clients.
+	var value string
+	query := "SELECT value FROM storage WHERE collection = $1 AND user_id = $2 AND read = 0 AND write = 0"
+	if !assert.Eventually(t, func() bool {
+		return db.QueryRow(query, collection, uuid.Nil).Scan(&value) == nil
+	}, 5*time.Second, 10*time.Millisecond) {
+		t.Fatal("audit entry was not written")
+	}
+	var entry MatchKickAudit
+	if err := json.Unmarshal([]byte(value), &entry); err != nil {
+		t.Fatalf("error decoding audit entry: %v", err)
+	}
+	assert.Equal(t, core.(*RuntimeGoMatchCore).idStr, entry.MatchID)
+	assert.Equal(t, kickedUserID.String(), entry.UserID)
+	assert.Equal(t, kicked.SessionID.String(), entry.SessionID)
+	assert.Equal(t, "afk", entry.Reason)
+	assert.NotZero(t, entry.Timestamp)
+}
+
+func TestRuntimeGoMatchCoreLabelUpdateEvents(t *testing.T) {
+	eventsCfg, err := cfg.Clone()
+	if err != nil {
+		t.Fatalf("error cloning config: %v", err)
+	}
+	eventsCfg.GetMatch().LabelUpdateEvents = true
+
+	var events []*api.Event
+	nk := &RuntimeGoNakamaModule{eventFn: func(ctx context.Context, evt *api.Event) {
+		events = append(events, evt)
+	}}
+
+	matchRegistry, _, _ := newTestMatchRegistry(nil)
+	core, err := NewRuntimeGoMatchCore(logger, eventsCfg, matchRegistry, metrics, &testMessageRouter{}, uuid.Must(uuid.NewV4()), cfg.GetName(), atomic.NewBool(false), nil, nil, nk, &testMatch{})
+	if err != nil {
+		t.Fatalf("error creating match core: %v", err)
+	}
+	if _, _, err := core.MatchInit(NewMatchPresenceList(), nil, nil); err != nil {
+		t.Fatalf("error initialising match: %v", err)
+	}
+	goCore := core.(*RuntimeGoMatchCore)
+	initial := goCore.label.Load()
+
+	for _, label := range []string{`{"mode":"ranked"}`, `{"mode":"ranked"}`, `{"mode":"casual"}`} {
+		if err := goCore.MatchLabelUpdate(label); err != nil {
+			t.Fatalf("error updating match label: %v", err)
+		}
+	}
+
+	// Updates that leave the label unchanged are not reported.
+	if assert.Len(t, events, 2) {
+		for _, evt := range events {
+			assert.Equal(t, MatchLabelUpdateEventName, evt.Name)
+			assert.Equal(t, goCore.idStr, evt.Properties["match_id"])
+			assert.NotNil(t, evt.Timestamp)
+		}
+		assert.Equal(t, initial, events[0].Properties["previous"])
+		assert.Equal(t, `{"mode":"ranked"}`, events[0].Properties["label"])
+		assert.Equal(t, `{"mode":"ranked"}`, events[1].Properties["previous"])
+		assert.Equal(t, `{"mode":"casual"}`, events[1].Properties["label"])
+	}
+
+	// Nothing is reported unless enabled.
+	events = nil
+	EmitMatchLabelUpdateEvent(context.Background(), cfg, nk.eventFn, goCore.idStr, `{"mode":"casual"}`, `{"mode":"ranked"}`)
+	assert.Empty(t, events)
+}
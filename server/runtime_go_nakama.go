@@ -38,6 +38,7 @@ import (
 	"go.uber.org/zap"
 )
 
+// Functions not in runtime.NakamaModule are server-side extensions, they are not part of the public runtime interface.
 type RuntimeGoNakamaModule struct {
 	sync.RWMutex
 	logger               *zap.Logger
@@ -49,19 +50,23 @@ type RuntimeGoNakamaModule struct {
 	leaderboardRankCache LeaderboardRankCache
 	leaderboardScheduler LeaderboardScheduler
 	sessionRegistry      SessionRegistry
+	sessionCache         SessionCache
 	matchRegistry        MatchRegistry
 	tracker              Tracker
 	streamManager        StreamManager
 	router               MessageRouter
+	pushQueue            *PushQueue
 
 	eventFn RuntimeEventCustomFunction
 
 	node string
 
 	matchCreateFn RuntimeMatchCreateFunction
+
+	entitlementValidators *RuntimeEntitlementValidators
 }
 
-func NewRuntimeGoNakamaModule(logger *zap.Logger, db *sql.DB, jsonpbMarshaler *jsonpb.Marshaler, config Config, socialClient *social.Client, leaderboardCache LeaderboardCache, leaderboardRankCache LeaderboardRankCache, leaderboardScheduler LeaderboardScheduler, sessionRegistry SessionRegistry, matchRegistry MatchRegistry, tracker Tracker, streamManager StreamManager, router MessageRouter) *RuntimeGoNakamaModule {
+func NewRuntimeGoNakamaModule(logger *zap.Logger, db *sql.DB, jsonpbMarshaler *jsonpb.Marshaler, config Config, socialClient *social.Client, leaderboardCache LeaderboardCache, leaderboardRankCache LeaderboardRankCache, leaderboardScheduler LeaderboardScheduler, sessionRegistry SessionRegistry, sessionCache SessionCache, matchRegistry MatchRegistry, tracker Tracker, streamManager StreamManager, router MessageRouter, pushQueue *PushQueue) *RuntimeGoNakamaModule {
 	return &RuntimeGoNakamaModule{
 		logger:               logger,
 		db:                   db,
@@ -72,10 +77,12 @@ func NewRuntimeGoNakamaModule(logger *zap.Logger, db *sql.DB, jsonpbMarshaler *j
 		leaderboardRankCache: leaderboardRankCache,
 		leaderboardScheduler: leaderboardScheduler,
 		sessionRegistry:      sessionRegistry,
+		sessionCache:         sessionCache,
 		matchRegistry:        matchRegistry,
 		tracker:              tracker,
 		streamManager:        streamManager,
 		router:               router,
+		pushQueue:            pushQueue,
 
 		node: config.GetName(),
 	}
@@ -283,7 +290,7 @@ func (n *RuntimeGoNakamaModule) AuthenticateSteam(ctx context.Context, token, us
 		return "", "", false, errors.New("expects id to be valid, must be 1-128 bytes")
 	}
 
-	return AuthenticateSteam(ctx, n.logger, n.db, n.socialClient, n.config.GetSocial().Steam.AppID, n.config.GetSocial().Steam.PublisherKey, token, username, create)
+	return AuthenticateSteam(ctx, n.logger, n.db, n.socialClient, n.config.GetSocial().Steam, token, username, create)
 }
 
 func (n *RuntimeGoNakamaModule) AuthenticateTokenGenerate(userID, username string, exp int64, vars map[string]string) (string, int64, error) {
@@ -308,6 +315,29 @@ func (n *RuntimeGoNakamaModule) AuthenticateTokenGenerate(userID, username strin
 	return token, exp, nil
 }
 
+// SessionRefreshGenerate issues a session token along with a refresh token that can be exchanged for its replacement.
+func (n *RuntimeGoNakamaModule) SessionRefreshGenerate(ctx context.Context, userID, username string, vars map[string]string) (*SessionRefreshTokens, error) {
+	uid, err := uuid.FromString(userID)
+	if err != nil {
+		return nil, errors.New("expects valid user id")
+	}
+
+	if username == "" {
+		return nil, errors.New("expects username")
+	}
+
+	return SessionRefreshGenerate(ctx, n.logger, n.db, n.config, uid, username, vars)
+}
+
+// SessionRefresh exchanges a refresh token for new tokens, revoking the user's sessions if it was already used.
+func (n *RuntimeGoNakamaModule) SessionRefresh(ctx context.Context, refreshToken string, vars map[string]string) (*SessionRefreshTokens, error) {
+	if refreshToken == "" {
+		return nil, errors.New("expects refresh token")
+	}
+
+	return SessionRefresh(ctx, n.logger, n.db, n.config, n.sessionCache, refreshToken, vars)
+}
+
 func (n *RuntimeGoNakamaModule) AccountGetId(ctx context.Context, userID string) (*api.Account, error) {
 	u, err := uuid.FromString(userID)
 	if err != nil {
@@ -393,6 +423,19 @@ func (n *RuntimeGoNakamaModule) AccountDeleteId(ctx context.Context, userID stri
 	return DeleteAccount(ctx, n.logger, n.db, u, recorded)
 }
 
+// AccountDeleteIdWithOptions deletes an account, anonymizing it instead if any category of data is kept.
+func (n *RuntimeGoNakamaModule) AccountDeleteIdWithOptions(ctx context.Context, userID string, options *DeleteAccountOptions) error {
+	u, err := uuid.FromString(userID)
+	if err != nil {
+		return errors.New("expects user ID to be a valid identifier")
+	}
+	if options == nil {
+		return errors.New("expects options to be set")
+	}
+
+	return DeleteAccountWithOptions(ctx, n.logger, n.db, u, options)
+}
+
 func (n *RuntimeGoNakamaModule) AccountExportId(ctx context.Context, userID string) (string, error) {
 	u, err := uuid.FromString(userID)
 	if err != nil {
@@ -450,6 +493,20 @@ func (n *RuntimeGoNakamaModule) UsersGetUsername(ctx context.Context, usernames
 	return users.Users, nil
 }
 
+// UsersCount returns the number of user accounts, excluding the system user.
+func (n *RuntimeGoNakamaModule) UsersCount(ctx context.Context) (int64, error) {
+	return CountUsers(ctx, n.logger, n.db)
+}
+
+// UsersCountByDate returns the number of user accounts created between the given unix times.
+func (n *RuntimeGoNakamaModule) UsersCountByDate(ctx context.Context, start, end int64) (int64, error) {
+	if end <= start {
+		return 0, errors.New("expects end time to be after start time")
+	}
+
+	return CountUsersByCreateTime(ctx, n.logger, n.db, time.Unix(start, 0), time.Unix(end, 0))
+}
+
 func (n *RuntimeGoNakamaModule) UsersBanId(ctx context.Context, userIDs []string) error {
 	if len(userIDs) == 0 {
 		return nil
@@ -559,6 +616,20 @@ func (n *RuntimeGoNakamaModule) LinkSteam(ctx context.Context, userID, token str
 	return LinkSteam(ctx, n.logger, n.db, n.config, n.socialClient, id, token)
 }
 
+// FriendsImportSteam adds friend edges between the user and any of their Steam friends with accounts.
+func (n *RuntimeGoNakamaModule) FriendsImportSteam(ctx context.Context, userID string, reset bool) error {
+	if n.config.GetSocial().Steam.PublisherKey == "" || n.config.GetSocial().Steam.AppID == 0 {
+		return errors.New("Steam authentication is not configured")
+	}
+
+	id, err := uuid.FromString(userID)
+	if err != nil {
+		return errors.New("user ID must be a valid identifier")
+	}
+
+	return importSteamFriends(ctx, n.logger, n.db, n.router, n.socialClient, n.config.GetSocial().Steam.PublisherKey, id, reset)
+}
+
 func (n *RuntimeGoNakamaModule) UnlinkApple(ctx context.Context, userID, token string) error {
 	id, err := uuid.FromString(userID)
 	if err != nil {
@@ -1007,6 +1078,37 @@ func (n *RuntimeGoNakamaModule) StreamSendRaw(mode uint8, subject, subcontext, l
 	return nil
 }
 
+// ChannelMessageUpdate replaces the content of a persisted channel message and broadcasts the update.
+func (n *RuntimeGoNakamaModule) ChannelMessageUpdate(ctx context.Context, channelID, messageID string, content map[string]interface{}, senderID string) (*api.ChannelMessage, error) {
+	sid := uuid.Nil
+	if senderID != "" {
+		var err error
+		if sid, err = uuid.FromString(senderID); err != nil {
+			return nil, errors.New("expects valid sender id")
+		}
+	}
+
+	contentBytes, err := json.Marshal(content)
+	if err != nil {
+		return nil, errors.Errorf("failed to convert content: %s", err.Error())
+	}
+
+	return ChannelMessageUpdate(ctx, n.logger, n.db, n.router, sid, channelID, messageID, string(contentBytes))
+}
+
+// ChannelMessageRemove deletes a persisted channel message and broadcasts the removal.
+func (n *RuntimeGoNakamaModule) ChannelMessageRemove(ctx context.Context, channelID, messageID, senderID string) (*api.ChannelMessage, error) {
+	sid := uuid.Nil
+	if senderID != "" {
+		var err error
+		if sid, err = uuid.FromString(senderID); err != nil {
+			return nil, errors.New("expects valid sender id")
+		}
+	}
+
+	return ChannelMessageRemove(ctx, n.logger, n.db, n.router, sid, channelID, messageID)
+}
+
 func (n *RuntimeGoNakamaModule) SessionDisconnect(ctx context.Context, sessionID string) error {
 	sid, err := uuid.FromString(sessionID)
 	if err != nil {
@@ -1016,6 +1118,11 @@ func (n *RuntimeGoNakamaModule) SessionDisconnect(ctx context.Context, sessionID
 	return n.sessionRegistry.Disconnect(ctx, sid)
 }
 
+// UsersOnlineCount returns the number of sessions currently connected to this node.
+func (n *RuntimeGoNakamaModule) UsersOnlineCount() int {
+	return n.sessionRegistry.Count()
+}
+
 func (n *RuntimeGoNakamaModule) MatchCreate(ctx context.Context, module string, params map[string]interface{}) (string, error) {
 	if module == "" {
 		return "", errors.New("expects module name")
@@ -1028,6 +1135,28 @@ func (n *RuntimeGoNakamaModule) MatchCreate(ctx context.Context, module string,
 	return n.matchRegistry.CreateMatch(ctx, n.logger, fn, module, params)
 }
 
+// MatchCreateWithReservations creates a match that reserves a place for each of the given session IDs.
+func (n *RuntimeGoNakamaModule) MatchCreateWithReservations(ctx context.Context, module string, params map[string]interface{}, reservedSessionIDs []string) (string, error) {
+	if module == "" {
+		return "", errors.New("expects module name")
+	}
+
+	reservedSessions := make([]uuid.UUID, 0, len(reservedSessionIDs))
+	for _, id := range reservedSessionIDs {
+		sessionID, err := uuid.FromString(id)
+		if err != nil {
+			return "", errors.New("expects reserved sessions to be valid session IDs")
+		}
+		reservedSessions = append(reservedSessions, sessionID)
+	}
+
+	n.RLock()
+	fn := n.matchCreateFn
+	n.RUnlock()
+
+	return n.matchRegistry.CreateMatchWithReservations(ctx, n.logger, fn, module, params, reservedSessions)
+}
+
 func (n *RuntimeGoNakamaModule) MatchGet(ctx context.Context, id string) (*api.Match, error) {
 	return n.matchRegistry.GetMatch(ctx, id)
 }
@@ -1054,6 +1183,15 @@ func (n *RuntimeGoNakamaModule) MatchList(ctx context.Context, limit int, author
 	return n.matchRegistry.ListMatches(ctx, limit, authoritativeWrapper, labelWrapper, minSizeWrapper, maxSizeWrapper, queryWrapper)
 }
 
+// MatchGetRandom returns a random authoritative match matching the query with room for minSpace presences.
+func (n *RuntimeGoNakamaModule) MatchGetRandom(ctx context.Context, query string, minSpace int) (*api.Match, error) {
+	if minSpace < 0 {
+		return nil, errors.New("expects min space to be >= 0")
+	}
+
+	return n.matchRegistry.GetRandomMatch(ctx, query, minSpace)
+}
+
 func (n *RuntimeGoNakamaModule) NotificationSend(ctx context.Context, userID, subject string, content map[string]interface{}, code int, sender string, persistent bool) error {
 	uid, err := uuid.FromString(userID)
 	if err != nil {
@@ -1096,7 +1234,22 @@ func (n *RuntimeGoNakamaModule) NotificationSend(ctx context.Context, userID, su
 		uid: nots,
 	}
 
-	return NotificationSend(ctx, n.logger, n.db, n.router, notifications)
+	if err := NotificationSend(ctx, n.logger, n.db, n.router, notifications); err != nil {
+		return err
+	}
+	n.pushQueue.Queue(notifications)
+
+	return nil
+}
+
+// NotificationSendLocalized sends a notification carrying a localization key and its parameters.
+func (n *RuntimeGoNakamaModule) NotificationSendLocalized(ctx context.Context, userID, subject string, content map[string]interface{}, locKey string, locParams map[string]interface{}, code int, sender string, persistent bool) error {
+	localized, err := NotificationContentLocalize(content, locKey, locParams)
+	if err != nil {
+		return err
+	}
+
+	return n.NotificationSend(ctx, userID, subject, localized, code, sender, persistent)
 }
 
 func (n *RuntimeGoNakamaModule) NotificationsSend(ctx context.Context, notifications []*runtime.NotificationSend) error {
@@ -1147,15 +1300,71 @@ func (n *RuntimeGoNakamaModule) NotificationsSend(ctx context.Context, notificat
 		ns[uid] = no
 	}
 
-	return NotificationSend(ctx, n.logger, n.db, n.router, ns)
+	if err := NotificationSend(ctx, n.logger, n.db, n.router, ns); err != nil {
+		return err
+	}
+	n.pushQueue.Queue(ns)
+
+	return nil
 }
 
 func (n *RuntimeGoNakamaModule) WalletUpdate(ctx context.Context, userID string, changeset map[string]int64, metadata map[string]interface{}, updateLedger bool) (map[string]int64, map[string]int64, error) {
+	updated, previous, _, err := n.WalletUpdateWithLedgerID(ctx, userID, changeset, metadata, updateLedger)
+	return updated, previous, err
+}
+
+// WalletUpdateWithLedgerID behaves like WalletUpdate, but also returns the ID of the ledger item written.
+func (n *RuntimeGoNakamaModule) WalletUpdateWithLedgerID(ctx context.Context, userID string, changeset map[string]int64, metadata map[string]interface{}, updateLedger bool) (map[string]int64, map[string]int64, string, error) {
+	uid, err := uuid.FromString(userID)
+	if err != nil {
+		return nil, nil, "", errors.New("expects a valid user id")
+	}
+
+	metadataBytes := []byte("{}")
+	if metadata != nil {
+		metadataBytes, err = json.Marshal(metadata)
+		if err != nil {
+			return nil, nil, "", errors.Errorf("failed to convert metadata: %s", err.Error())
+		}
+	}
+
+	updates := []*walletUpdate{{
+		UserID:    uid,
+		Changeset: changeset,
+		Metadata:  string(metadataBytes),
+	}}
+	results, err := UpdateWallets(ctx, n.logger, n.db, updates, updateLedger)
+	if err != nil {
+		if len(results) == 0 {
+			return nil, nil, "", err
+		}
+		return results[0].Updated, results[0].Previous, "", err
+	}
+	if len(results) == 0 {
+		return nil, nil, "", ErrAccountNotFound
+	}
+
+	n.RLock()
+	eventFn := n.eventFn
+	n.RUnlock()
+	EmitWalletUpdateEvents(ctx, n.logger, n.config, eventFn, updates, results)
+
+	return results[0].Updated, results[0].Previous, WalletUpdateLedgerIDs(updates, results)[0], nil
+}
+
+// WalletUpdateWithLimits behaves like WalletUpdate, but also clamps or rejects balances that cross the limits.
+func (n *RuntimeGoNakamaModule) WalletUpdateWithLimits(ctx context.Context, userID string, changeset map[string]int64, limits map[string]*WalletCurrencyLimit, metadata map[string]interface{}, updateLedger bool) (map[string]int64, map[string]int64, error) {
 	uid, err := uuid.FromString(userID)
 	if err != nil {
 		return nil, nil, errors.New("expects a valid user id")
 	}
 
+	for currency, limit := range limits {
+		if limit != nil && limit.Min != nil && limit.Max != nil && *limit.Min > *limit.Max {
+			return nil, nil, errors.Errorf("expects limit min for '%v' to not exceed max", currency)
+		}
+	}
+
 	metadataBytes := []byte("{}")
 	if metadata != nil {
 		metadataBytes, err = json.Marshal(metadata)
@@ -1164,11 +1373,13 @@ func (n *RuntimeGoNakamaModule) WalletUpdate(ctx context.Context, userID string,
 		}
 	}
 
-	results, err := UpdateWallets(ctx, n.logger, n.db, []*walletUpdate{{
+	updates := []*walletUpdate{{
 		UserID:    uid,
 		Changeset: changeset,
 		Metadata:  string(metadataBytes),
-	}}, updateLedger)
+		Limits:    limits,
+	}}
+	results, err := UpdateWallets(ctx, n.logger, n.db, updates, updateLedger)
 	if err != nil {
 		if len(results) == 0 {
 			return nil, nil, err
@@ -1176,6 +1387,11 @@ func (n *RuntimeGoNakamaModule) WalletUpdate(ctx context.Context, userID string,
 		return results[0].Updated, results[0].Previous, err
 	}
 
+	n.RLock()
+	eventFn := n.eventFn
+	n.RUnlock()
+	EmitWalletUpdateEvents(ctx, n.logger, n.config, eventFn, updates, results)
+
 	return results[0].Updated, results[0].Previous, nil
 }
 
@@ -1208,7 +1424,17 @@ func (n *RuntimeGoNakamaModule) WalletsUpdate(ctx context.Context, updates []*ru
 		}
 	}
 
-	return UpdateWallets(ctx, n.logger, n.db, walletUpdates, updateLedger)
+	results, err := UpdateWallets(ctx, n.logger, n.db, walletUpdates, updateLedger)
+	if err != nil {
+		return results, err
+	}
+
+	n.RLock()
+	eventFn := n.eventFn
+	n.RUnlock()
+	EmitWalletUpdateEvents(ctx, n.logger, n.config, eventFn, walletUpdates, results)
+
+	return results, nil
 }
 
 func (n *RuntimeGoNakamaModule) WalletLedgerUpdate(ctx context.Context, itemID string, metadata map[string]interface{}) (runtime.WalletLedgerItem, error) {
@@ -1247,6 +1473,45 @@ func (n *RuntimeGoNakamaModule) WalletLedgerList(ctx context.Context, userID str
 	return runtimeItems, newCursor, nil
 }
 
+// WalletLedgerTotals returns the credit, debit and net totals per currency of ledger items in a time range.
+func (n *RuntimeGoNakamaModule) WalletLedgerTotals(ctx context.Context, userID string, startTime, endTime int64) (map[string]*WalletLedgerTotal, error) {
+	uid, err := uuid.FromString(userID)
+	if err != nil {
+		return nil, errors.New("expects a valid user id")
+	}
+
+	if startTime < 0 || endTime < 0 {
+		return nil, errors.New("expects start and end time to be >= 0")
+	}
+
+	var start, end time.Time
+	if startTime > 0 {
+		start = time.Unix(startTime, 0).UTC()
+	}
+	if endTime > 0 {
+		end = time.Unix(endTime, 0).UTC()
+	}
+
+	return WalletLedgerTotals(ctx, n.logger, n.db, uid, start, end)
+}
+
+// UsersGetByWalletBalance returns a page of IDs of users whose balance of a currency is between min and max.
+func (n *RuntimeGoNakamaModule) UsersGetByWalletBalance(ctx context.Context, currency string, min, max int64, limit int, cursor string) ([]string, string, error) {
+	if currency == "" {
+		return nil, "", errors.New("expects a currency")
+	}
+
+	if min > max {
+		return nil, "", errors.New("expects max to be >= min")
+	}
+
+	if limit < 1 || limit > 100 {
+		return nil, "", errors.New("expects limit to be 1-100")
+	}
+
+	return GetUsersByWalletBalance(ctx, n.logger, n.db, currency, min, max, limit, cursor)
+}
+
 func (n *RuntimeGoNakamaModule) StorageList(ctx context.Context, userID, collection string, limit int, cursor string) ([]*api.StorageObject, string, error) {
 	var uid *uuid.UUID
 	if userID != "" {
@@ -1257,7 +1522,7 @@ func (n *RuntimeGoNakamaModule) StorageList(ctx context.Context, userID, collect
 		uid = &u
 	}
 
-	objectList, _, err := StorageListObjects(ctx, n.logger, n.db, uuid.Nil, uid, collection, limit, cursor)
+	objectList, _, err := StorageListObjects(ctx, n.logger, n.db, uuid.Nil, uid, collection, limit, cursor, StorageListOrderDefault)
 	if err != nil {
 		return nil, "", err
 	}
@@ -1346,7 +1611,7 @@ func (n *RuntimeGoNakamaModule) StorageWrite(ctx context.Context, writes []*runt
 		ops = append(ops, op)
 	}
 
-	acks, _, err := StorageWriteObjects(ctx, n.logger, n.db, true, ops)
+	acks, _, err := StorageWriteObjects(ctx, n.logger, n.db, true, n.config.GetRuntime().StorageMaxObjectBytes, ops)
 	if err != nil {
 		return nil, err
 	}
@@ -1354,6 +1619,42 @@ func (n *RuntimeGoNakamaModule) StorageWrite(ctx context.Context, writes []*runt
 	return acks.Acks, nil
 }
 
+// StoragePatch applies JSON Patch operations to an existing storage object and returns its new version.
+func (n *RuntimeGoNakamaModule) StoragePatch(ctx context.Context, collection, key, userID, version string, ops []*StoragePatchOp) (string, error) {
+	if collection == "" {
+		return "", errors.New("expects collection to be a non-empty string")
+	}
+	if key == "" {
+		return "", errors.New("expects key to be a non-empty string")
+	}
+	ownerID := uuid.Nil
+	if userID != "" {
+		var err error
+		if ownerID, err = uuid.FromString(userID); err != nil {
+			return "", errors.New("expects an empty or valid user id")
+		}
+	}
+	if len(ops) == 0 {
+		return "", errors.New("expects at least one patch operation")
+	}
+
+	ack, _, err := StoragePatchObject(ctx, n.logger, n.db, n.config.GetRuntime().StorageMaxObjectBytes, ownerID.String(), collection, key, version, ops)
+	if err != nil {
+		return "", err
+	}
+	return ack.Version, nil
+}
+
+// StorageSize returns the total value size in bytes and the number of a user's storage objects.
+func (n *RuntimeGoNakamaModule) StorageSize(ctx context.Context, userID, collection string) (int64, int64, error) {
+	uid, err := uuid.FromString(userID)
+	if err != nil {
+		return 0, 0, errors.New("expects a valid user id")
+	}
+
+	return StorageSize(ctx, n.logger, n.db, uid, collection)
+}
+
 func (n *RuntimeGoNakamaModule) StorageDelete(ctx context.Context, deletes []*runtime.StorageDelete) error {
 	size := len(deletes)
 	if size == 0 {
@@ -1570,6 +1871,26 @@ func (n *RuntimeGoNakamaModule) LeaderboardDelete(ctx context.Context, id string
 	return n.leaderboardCache.Delete(ctx, id)
 }
 
+// LeaderboardSetReset replaces or removes the reset schedule of a leaderboard.
+func (n *RuntimeGoNakamaModule) LeaderboardSetReset(ctx context.Context, id, resetSchedule string) error {
+	if id == "" {
+		return errors.New("expects a leaderboard ID string")
+	}
+
+	if resetSchedule != "" {
+		if _, err := cronexpr.Parse(resetSchedule); err != nil {
+			return errors.New("expects reset schedule to be a valid CRON expression")
+		}
+	}
+
+	if _, err := n.leaderboardCache.SetResetSchedule(ctx, id, resetSchedule); err != nil {
+		return err
+	}
+
+	n.leaderboardScheduler.Update()
+	return nil
+}
+
 func (n *RuntimeGoNakamaModule) LeaderboardRecordsList(ctx context.Context, id string, ownerIDs []string, limit int, cursor string, expiry int64) ([]*api.LeaderboardRecord, []*api.LeaderboardRecord, string, string, error) {
 	if id == "" {
 		return nil, nil, "", "", errors.New("expects a leaderboard ID string")
@@ -1599,6 +1920,58 @@ func (n *RuntimeGoNakamaModule) LeaderboardRecordsList(ctx context.Context, id s
 	return list.Records, list.OwnerRecords, list.NextCursor, list.PrevCursor, nil
 }
 
+// LeaderboardRecordsListExpired lists records in rank order from a leaderboard window that ended at the given expiry.
+func (n *RuntimeGoNakamaModule) LeaderboardRecordsListExpired(ctx context.Context, id string, expiry int64, limit int, cursor string) ([]*api.LeaderboardRecord, string, string, error) {
+	if id == "" {
+		return nil, "", "", errors.New("expects a leaderboard ID string")
+	}
+
+	if expiry <= 0 {
+		return nil, "", "", errors.New("expects expiry to be greater than 0")
+	}
+
+	if limit < 1 || limit > 100 {
+		return nil, "", "", errors.New("expects limit to be 1-100")
+	}
+
+	list, err := LeaderboardRecordsListExpired(ctx, n.logger, n.db, n.leaderboardCache, n.leaderboardRankCache, id, expiry, limit, cursor)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	return list.Records, list.NextCursor, list.PrevCursor, nil
+}
+
+// LeaderboardRecordsSample returns up to count records chosen at random from the current leaderboard window.
+func (n *RuntimeGoNakamaModule) LeaderboardRecordsSample(ctx context.Context, id string, count int) ([]*api.LeaderboardRecord, error) {
+	if id == "" {
+		return nil, errors.New("expects a leaderboard ID string")
+	}
+
+	if count < 1 || count > 100 {
+		return nil, errors.New("expects count to be 1-100")
+	}
+
+	return LeaderboardRecordsSample(ctx, n.logger, n.db, n.leaderboardCache, n.leaderboardRankCache, id, count)
+}
+
+// LeaderboardRecordsAroundScore lists up to limit records bracketing the given score and subscore, in rank order.
+func (n *RuntimeGoNakamaModule) LeaderboardRecordsAroundScore(ctx context.Context, id string, score, subscore int64, limit int, expiry int64) ([]*api.LeaderboardRecord, error) {
+	if id == "" {
+		return nil, errors.New("expects a leaderboard ID string")
+	}
+
+	if limit < 1 || limit > 100 {
+		return nil, errors.New("expects limit to be 1-100")
+	}
+
+	if expiry < 0 {
+		return nil, errors.New("expects expiry to equal or greater than 0")
+	}
+
+	return LeaderboardRecordsAroundScore(ctx, n.logger, n.db, n.leaderboardCache, n.leaderboardRankCache, id, score, subscore, limit, expiry)
+}
+
 func (n *RuntimeGoNakamaModule) LeaderboardRecordWrite(ctx context.Context, id, ownerID, username string, score, subscore int64, metadata map[string]interface{}) (*api.LeaderboardRecord, error) {
 	if id == "" {
 		return nil, errors.New("expects a leaderboard ID string")
@@ -1731,7 +2104,8 @@ func (n *RuntimeGoNakamaModule) TournamentAddAttempt(ctx context.Context, id, ow
 		return errors.New("expects an attempt count number != 0")
 	}
 
-	return TournamentAddAttempt(ctx, n.logger, n.db, n.leaderboardCache, id, ownerID, count)
+	_, err := TournamentAddAttempt(ctx, n.logger, n.db, n.leaderboardCache, id, ownerID, count)
+	return err
 }
 
 func (n *RuntimeGoNakamaModule) TournamentJoin(ctx context.Context, id, ownerID, username string) error {
@@ -1752,6 +2126,38 @@ func (n *RuntimeGoNakamaModule) TournamentJoin(ctx context.Context, id, ownerID,
 	return TournamentJoin(ctx, n.logger, n.db, n.leaderboardCache, ownerID, username, id)
 }
 
+// TournamentJoinWaitlist joins a tournament, or its waitlist if it is full, and reports which happened.
+func (n *RuntimeGoNakamaModule) TournamentJoinWaitlist(ctx context.Context, id, ownerID, username string) (TournamentJoinStatus, error) {
+	if id == "" {
+		return TournamentJoinStatusJoined, errors.New("expects a tournament ID string")
+	}
+
+	if ownerID == "" {
+		return TournamentJoinStatusJoined, errors.New("expects a owner ID string")
+	} else if _, err := uuid.FromString(ownerID); err != nil {
+		return TournamentJoinStatusJoined, errors.New("expects owner ID to be a valid identifier")
+	}
+
+	if username == "" {
+		return TournamentJoinStatusJoined, errors.New("expects a username string")
+	}
+
+	return TournamentJoinWaitlist(ctx, n.logger, n.db, n.leaderboardCache, ownerID, username, id)
+}
+
+// TournamentLeave removes the owner from a tournament period or its waitlist, promoting the next waitlisted user.
+func (n *RuntimeGoNakamaModule) TournamentLeave(ctx context.Context, id, ownerID string) error {
+	if id == "" {
+		return errors.New("expects a tournament ID string")
+	}
+
+	if _, err := uuid.FromString(ownerID); err != nil {
+		return errors.New("expects owner ID to be a valid identifier")
+	}
+
+	return TournamentLeave(ctx, n.logger, n.db, n.leaderboardCache, n.leaderboardRankCache, ownerID, id)
+}
+
 func (n *RuntimeGoNakamaModule) TournamentsGetId(ctx context.Context, tournamentIDs []string) ([]*api.Tournament, error) {
 	if len(tournamentIDs) == 0 {
 		return []*api.Tournament{}, nil
@@ -2009,6 +2415,16 @@ func (n *RuntimeGoNakamaModule) GroupUsersKick(ctx context.Context, groupID stri
 	return KickGroupUsers(ctx, n.logger, n.db, n.router, uuid.Nil, group, users)
 }
 
+// GroupCount returns the number of members in a group, excluding join requests and banned users.
+func (n *RuntimeGoNakamaModule) GroupCount(ctx context.Context, id string) (int, error) {
+	groupID, err := uuid.FromString(id)
+	if err != nil {
+		return 0, errors.New("expects group ID to be a valid identifier")
+	}
+
+	return GetGroupEdgeCount(ctx, n.logger, n.db, groupID)
+}
+
 func (n *RuntimeGoNakamaModule) GroupUsersList(ctx context.Context, id string, limit int, state *int, cursor string) ([]*api.GroupUserList_GroupUser, string, error) {
 	groupID, err := uuid.FromString(id)
 	if err != nil {
@@ -2119,3 +2535,31 @@ func (n *RuntimeGoNakamaModule) SetMatchCreateFn(fn RuntimeMatchCreateFunction)
 	n.matchCreateFn = fn
 	n.Unlock()
 }
+
+func (n *RuntimeGoNakamaModule) SetEntitlementValidators(validators *RuntimeEntitlementValidators) {
+	n.Lock()
+	n.entitlementValidators = validators
+	n.Unlock()
+}
+
+// ValidateEntitlement validates a receipt for a user with the validator registered for the given provider, and records
+// the resulting entitlement.
+func (n *RuntimeGoNakamaModule) ValidateEntitlement(ctx context.Context, provider, userID, receipt string) (*ValidatedEntitlement, error) {
+	if provider == "" {
+		return nil, errors.New("expects a provider name")
+	}
+
+	uid, err := uuid.FromString(userID)
+	if err != nil {
+		return nil, errors.New("expects a valid user id")
+	}
+
+	n.RLock()
+	validators := n.entitlementValidators
+	n.RUnlock()
+	if validators == nil {
+		return nil, ErrEntitlementProviderNotFound
+	}
+
+	return ValidateEntitlement(ctx, n.logger, n.db, validators, provider, uid, receipt)
+}
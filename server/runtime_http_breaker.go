@@ -0,0 +1,138 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var ErrRuntimeHTTPBreakerOpen = errors.New("circuit breaker open for host")
+
+type RuntimeHTTPBreakerState int
+
+const (
+	// Requests are allowed through and failures counted.
+	RuntimeHTTPBreakerClosed RuntimeHTTPBreakerState = iota
+	// Requests fail fast without reaching the host.
+	RuntimeHTTPBreakerOpen
+	// A single probe request is allowed through to check if the host has recovered.
+	RuntimeHTTPBreakerHalfOpen
+)
+
+type runtimeHTTPBreakerHost struct {
+	state    RuntimeHTTPBreakerState
+	failures int
+	openedAt time.Time
+}
+
+// RuntimeHTTPBreaker wraps a transport with a circuit breaker per host. Consecutive transport errors or 5xx responses
+// from a host trip its breaker, after which requests to that host fail fast until the open period expires and a probe
+// request succeeds.
+type RuntimeHTTPBreaker struct {
+	sync.Mutex
+	transport http.RoundTripper
+	metrics   *Metrics
+	failures  int
+	openFor   time.Duration
+	hosts     map[string]*runtimeHTTPBreakerHost
+}
+
+func NewRuntimeHTTPBreaker(transport http.RoundTripper, metrics *Metrics, failures int, openFor time.Duration) *RuntimeHTTPBreaker {
+	return &RuntimeHTTPBreaker{
+		transport: transport,
+		metrics:   metrics,
+		failures:  failures,
+		openFor:   openFor,
+		hosts:     make(map[string]*runtimeHTTPBreakerHost),
+	}
+}
+
+func (b *RuntimeHTTPBreaker) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	if err := b.allow(host); err != nil {
+		return nil, err
+	}
+
+	resp, err := b.transport.RoundTrip(req)
+	b.record(host, err == nil && resp.StatusCode < http.StatusInternalServerError)
+	return resp, err
+}
+
+// State returns the current breaker state for the given host.
+func (b *RuntimeHTTPBreaker) State(host string) RuntimeHTTPBreakerState {
+	b.Lock()
+	defer b.Unlock()
+	if h, found := b.hosts[host]; found {
+		return h.state
+	}
+	return RuntimeHTTPBreakerClosed
+}
+
+func (b *RuntimeHTTPBreaker) allow(host string) error {
+	b.Lock()
+	defer b.Unlock()
+
+	h, found := b.hosts[host]
+	if !found {
+		return nil
+	}
+	switch h.state {
+	case RuntimeHTTPBreakerOpen:
+		if time.Since(h.openedAt) < b.openFor {
+			return ErrRuntimeHTTPBreakerOpen
+		}
+		// Let this request through as a probe, any others wait for its outcome.
+		b.setState(host, h, RuntimeHTTPBreakerHalfOpen)
+	case RuntimeHTTPBreakerHalfOpen:
+		return ErrRuntimeHTTPBreakerOpen
+	}
+	return nil
+}
+
+func (b *RuntimeHTTPBreaker) record(host string, success bool) {
+	b.Lock()
+	defer b.Unlock()
+
+	h, found := b.hosts[host]
+	if success {
+		if found {
+			// Forget healthy hosts, there's nothing to track until they fail again.
+			b.setState(host, h, RuntimeHTTPBreakerClosed)
+			delete(b.hosts, host)
+		}
+		return
+	}
+
+	if !found {
+		h = &runtimeHTTPBreakerHost{}
+		b.hosts[host] = h
+	}
+	h.failures++
+	if h.state == RuntimeHTTPBreakerHalfOpen || h.failures >= b.failures {
+		h.openedAt = time.Now()
+		b.setState(host, h, RuntimeHTTPBreakerOpen)
+	}
+}
+
+// Must be called with the lock held.
+func (b *RuntimeHTTPBreaker) setState(host string, h *runtimeHTTPBreakerHost, state RuntimeHTTPBreakerState) {
+	h.state = state
+	if b.metrics != nil {
+		b.metrics.GaugeRuntimeHTTPBreaker(host, float64(state))
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/atomic"
+)
+
+func TestRuntimeHTTPBreaker(t *testing.T) {
+	var hits atomic.Int32
+	var healthy atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Inc()
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	host := server.Listener.Addr().String()
+
+	breaker := NewRuntimeHTTPBreaker(http.DefaultTransport, metrics, 2, 100*time.Millisecond)
+	client := &http.Client{Transport: breaker}
+	get := func() (*http.Response, error) {
+		resp, err := client.Get(server.URL)
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return resp, err
+	}
+
+	// Failures up to the threshold still reach the host, then the breaker trips.
+	for i := 0; i < 2; i++ {
+		resp, err := get()
+		if err != nil {
+			t.Fatalf("error sending request: %v", err.Error())
+		}
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	}
+	assert.Equal(t, RuntimeHTTPBreakerOpen, breaker.State(host))
+
+	// Open breakers fail fast without reaching the host.
+	_, err := get()
+	var urlErr *url.Error
+	if assert.True(t, errors.As(err, &urlErr)) {
+		assert.Equal(t, ErrRuntimeHTTPBreakerOpen, urlErr.Err)
+	}
+	assert.Equal(t, int32(2), hits.Load())
+
+	// A failed probe once the open period expires trips the breaker again straight away.
+	time.Sleep(150 * time.Millisecond)
+	resp, err := get()
+	if err != nil {
+		t.Fatalf("error sending request: %v", err.Error())
+	}
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, RuntimeHTTPBreakerOpen, breaker.State(host))
+	assert.Equal(t, int32(3), hits.Load())
+
+	// A successful probe closes the breaker.
+	healthy.Store(true)
+	time.Sleep(150 * time.Millisecond)
+	resp, err = get()
+	if err != nil {
+		t.Fatalf("error sending request: %v", err.Error())
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, RuntimeHTTPBreakerClosed, breaker.State(host))
+
+	resp, err = get()
+	if err != nil {
+		t.Fatalf("error sending request: %v", err.Error())
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int32(5), hits.Load())
+}
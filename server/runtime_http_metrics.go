@@ -0,0 +1,45 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+	"time"
+)
+
+// RuntimeHTTPMetrics wraps a transport to record the latency and response status of each request, tagged by method and
+// host. Full URLs are deliberately left out of the tags so paths and query strings can't blow up metric cardinality.
+type RuntimeHTTPMetrics struct {
+	transport http.RoundTripper
+	metrics   *Metrics
+}
+
+func NewRuntimeHTTPMetrics(transport http.RoundTripper, metrics *Metrics) *RuntimeHTTPMetrics {
+	return &RuntimeHTTPMetrics{
+		transport: transport,
+		metrics:   metrics,
+	}
+}
+
+func (m *RuntimeHTTPMetrics) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := m.transport.RoundTrip(req)
+	var statusCode int
+	if err == nil {
+		statusCode = resp.StatusCode
+	}
+	m.metrics.RuntimeHTTPRequest(req.Method, req.URL.Host, statusCode, time.Since(start))
+	return resp, err
+}
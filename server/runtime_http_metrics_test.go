@@ -0,0 +1,72 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/tally"
+)
+
+func TestRuntimeHTTPMetrics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	host := server.Listener.Addr().String()
+
+	scope := tally.NewTestScope("", nil)
+	client := &http.Client{Transport: NewRuntimeHTTPMetrics(http.DefaultTransport, &Metrics{prometheusScope: scope})}
+	for _, path := range []string{"/a?q=1", "/b", "/missing"} {
+		resp, err := client.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("error sending request: %v", err.Error())
+		}
+		resp.Body.Close()
+	}
+	// Nothing listens on the closed server's address any more.
+	server.Close()
+	if _, err := client.Get(server.URL); err == nil {
+		t.Fatal("expected request to a closed server to fail")
+	}
+
+	// Requests are counted per method, host and status, never per path.
+	snapshot := scope.Snapshot()
+	counts := make(map[string]int64)
+	for _, counter := range snapshot.Counters() {
+		if counter.Name() != "runtime_http_request_count" {
+			continue
+		}
+		tags := counter.Tags()
+		assert.Equal(t, "GET", tags["method"])
+		assert.Equal(t, host, tags["host"])
+		counts[tags["status"]] += counter.Value()
+	}
+	assert.Equal(t, map[string]int64{"200": 2, "404": 1, "error": 1}, counts)
+
+	var latencies int
+	for _, timer := range snapshot.Timers() {
+		if timer.Name() == "runtime_http_request_latency_ms" {
+			assert.Equal(t, map[string]string{"method": "GET", "host": host}, timer.Tags())
+			latencies += len(timer.Values())
+		}
+	}
+	assert.Equal(t, 4, latencies)
+}
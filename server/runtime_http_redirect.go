@@ -0,0 +1,75 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Redirect policies for runtime HTTP requests.
+const (
+	// Follow redirects to any host, dropping credential headers once a redirect leaves the original host.
+	RuntimeHTTPRedirectFollow = "follow"
+	// Never follow redirects, the redirect response itself is returned.
+	RuntimeHTTPRedirectNone = "none"
+	// Follow redirects only while they stay on the original host, fail the request otherwise.
+	RuntimeHTTPRedirectSameHost = "same_host"
+)
+
+// Same limit the standard library applies by default.
+const runtimeHTTPMaxRedirects = 10
+
+var ErrRuntimeHTTPRedirectCrossHost = errors.New("redirect to a different host not allowed")
+
+// Request headers that may carry credentials, never sent to a host other than the one the request was made to.
+var runtimeHTTPSensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Cookie2"}
+
+// RuntimeHTTPCheckRedirect returns the redirect handling for an http.Client implementing the given policy, where an
+// empty policy is the same as RuntimeHTTPRedirectFollow.
+func RuntimeHTTPCheckRedirect(policy string) (func(req *http.Request, via []*http.Request) error, error) {
+	switch policy {
+	case "", RuntimeHTTPRedirectFollow:
+		return func(req *http.Request, via []*http.Request) error {
+			if len(via) >= runtimeHTTPMaxRedirects {
+				return fmt.Errorf("stopped after %v redirects", runtimeHTTPMaxRedirects)
+			}
+			if !strings.EqualFold(req.URL.Host, via[0].URL.Host) {
+				for _, header := range runtimeHTTPSensitiveHeaders {
+					req.Header.Del(header)
+				}
+			}
+			return nil
+		}, nil
+	case RuntimeHTTPRedirectNone:
+		return func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}, nil
+	case RuntimeHTTPRedirectSameHost:
+		return func(req *http.Request, via []*http.Request) error {
+			if len(via) >= runtimeHTTPMaxRedirects {
+				return fmt.Errorf("stopped after %v redirects", runtimeHTTPMaxRedirects)
+			}
+			if !strings.EqualFold(req.URL.Host, via[0].URL.Host) {
+				return ErrRuntimeHTTPRedirectCrossHost
+			}
+			return nil
+		}, nil
+	default:
+		return nil, fmt.Errorf("redirect policy must be one of '%v', '%v' or '%v'", RuntimeHTTPRedirectFollow, RuntimeHTTPRedirectNone, RuntimeHTTPRedirectSameHost)
+	}
+}
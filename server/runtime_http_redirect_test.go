@@ -0,0 +1,99 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRuntimeHTTPCheckRedirect(t *testing.T) {
+	// Echoes the Authorization header it receives.
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("other:" + r.Header.Get("Authorization")))
+	}))
+	defer other.Close()
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cross":
+			http.Redirect(w, r, other.URL, http.StatusFound)
+		case "/same":
+			http.Redirect(w, r, "/target", http.StatusFound)
+		default:
+			_, _ = w.Write([]byte("origin:" + r.Header.Get("Authorization")))
+		}
+	}))
+	defer origin.Close()
+
+	request := func(policy, path string) (int, string, error) {
+		checkRedirect, err := RuntimeHTTPCheckRedirect(policy)
+		if err != nil {
+			t.Fatalf("error creating redirect policy: %v", err.Error())
+		}
+		client := &http.Client{CheckRedirect: checkRedirect}
+		req, err := http.NewRequest("GET", origin.URL+path, nil)
+		if err != nil {
+			t.Fatalf("error creating request: %v", err.Error())
+		}
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, "", err
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("error reading response: %v", err.Error())
+		}
+		return resp.StatusCode, string(body), nil
+	}
+
+	// Follow keeps credentials on the same host, but drops them when leaving it.
+	for _, policy := range []string{"", RuntimeHTTPRedirectFollow} {
+		code, body, err := request(policy, "/same")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "origin:Bearer secret", body)
+		code, body, err = request(policy, "/cross")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "other:", body)
+	}
+
+	// None returns the redirect response itself.
+	code, _, err := request(RuntimeHTTPRedirectNone, "/cross")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusFound, code)
+
+	// Same host follows redirects on the original host only.
+	code, body, err := request(RuntimeHTTPRedirectSameHost, "/same")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "origin:Bearer secret", body)
+	_, _, err = request(RuntimeHTTPRedirectSameHost, "/cross")
+	var urlErr *url.Error
+	if assert.True(t, errors.As(err, &urlErr)) {
+		assert.Equal(t, ErrRuntimeHTTPRedirectCrossHost, urlErr.Err)
+	}
+
+	_, err = RuntimeHTTPCheckRedirect("sometimes")
+	assert.EqualError(t, err, "redirect policy must be one of 'follow', 'none' or 'same_host'")
+}
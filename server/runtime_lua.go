@@ -22,6 +22,7 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"path/filepath"
 	"sort"
@@ -55,13 +56,14 @@ func (s *LSentinelType) Type() lua.LValueType { return LTSentinel }
 var LSentinel = lua.LValue(&LSentinelType{})
 
 type RuntimeLuaCallbacks struct {
-	RPC              map[string]*lua.LFunction
-	Before           map[string]*lua.LFunction
-	After            map[string]*lua.LFunction
-	Matchmaker       *lua.LFunction
-	TournamentEnd    *lua.LFunction
-	TournamentReset  *lua.LFunction
-	LeaderboardReset *lua.LFunction
+	RPC                map[string]*lua.LFunction
+	Before             map[string]*lua.LFunction
+	After              map[string]*lua.LFunction
+	Matchmaker         *lua.LFunction
+	MatchmakerOverride *lua.LFunction
+	TournamentEnd      *lua.LFunction
+	TournamentReset    *lua.LFunction
+	LeaderboardReset   *lua.LFunction
 }
 
 type RuntimeLuaModule struct {
@@ -108,7 +110,7 @@ type RuntimeProviderLua struct {
 	statsCtx context.Context
 }
 
-func NewRuntimeProviderLua(logger, startupLogger *zap.Logger, db *sql.DB, jsonpbMarshaler *jsonpb.Marshaler, jsonpbUnmarshaler *jsonpb.Unmarshaler, config Config, socialClient *social.Client, leaderboardCache LeaderboardCache, leaderboardRankCache LeaderboardRankCache, leaderboardScheduler LeaderboardScheduler, sessionRegistry SessionRegistry, matchRegistry MatchRegistry, tracker Tracker, metrics *Metrics, streamManager StreamManager, router MessageRouter, goMatchCreateFn RuntimeMatchCreateFunction, eventFn RuntimeEventCustomFunction, rootPath string, paths []string) ([]string, map[string]RuntimeRpcFunction, map[string]RuntimeBeforeRtFunction, map[string]RuntimeAfterRtFunction, *RuntimeBeforeReqFunctions, *RuntimeAfterReqFunctions, RuntimeMatchmakerMatchedFunction, RuntimeMatchCreateFunction, RuntimeTournamentEndFunction, RuntimeTournamentResetFunction, RuntimeLeaderboardResetFunction, error) {
+func NewRuntimeProviderLua(logger, startupLogger *zap.Logger, db *sql.DB, jsonpbMarshaler *jsonpb.Marshaler, jsonpbUnmarshaler *jsonpb.Unmarshaler, config Config, socialClient *social.Client, leaderboardCache LeaderboardCache, leaderboardRankCache LeaderboardRankCache, leaderboardScheduler LeaderboardScheduler, sessionRegistry SessionRegistry, sessionCache SessionCache, matchRegistry MatchRegistry, matchmaker Matchmaker, tracker Tracker, metrics *Metrics, streamManager StreamManager, router MessageRouter, pushQueue *PushQueue, entitlementValidators *RuntimeEntitlementValidators, goMatchCreateFn RuntimeMatchCreateFunction, eventFn RuntimeEventCustomFunction, rootPath string, paths []string) ([]string, map[string]RuntimeRpcFunction, map[string]RuntimeBeforeRtFunction, map[string]RuntimeAfterRtFunction, *RuntimeBeforeReqFunctions, *RuntimeAfterReqFunctions, RuntimeMatchmakerMatchedFunction, RuntimeMatchCreateFunction, RuntimeTournamentEndFunction, RuntimeTournamentResetFunction, RuntimeLeaderboardResetFunction, error) {
 	startupLogger.Info("Initialising Lua runtime provider", zap.String("path", rootPath))
 
 	// Load Lua modules into memory by reading the file contents. No evaluation/execution at this stage.
@@ -120,6 +122,8 @@ func NewRuntimeProviderLua(logger, startupLogger *zap.Logger, db *sql.DB, jsonpb
 
 	once := &sync.Once{}
 	localCache := NewRuntimeLuaLocalCache()
+	configFileCache := NewRuntimeConfigFileCache(runtimeConfigFileCheckInterval)
+	httpClient := NewRuntimeHTTPClient(config.GetRuntime(), metrics)
 	rpcFunctions := make(map[string]RuntimeRpcFunction, 0)
 	beforeRtFunctions := make(map[string]RuntimeBeforeRtFunction, 0)
 	afterRtFunctions := make(map[string]RuntimeAfterRtFunction, 0)
@@ -141,7 +145,7 @@ func NewRuntimeProviderLua(logger, startupLogger *zap.Logger, db *sql.DB, jsonpb
 		if core != nil {
 			return core, nil
 		}
-		return NewRuntimeLuaMatchCore(logger, db, jsonpbMarshaler, jsonpbUnmarshaler, config, socialClient, leaderboardCache, leaderboardRankCache, leaderboardScheduler, sessionRegistry, matchRegistry, tracker, streamManager, router, stdLibs, once, localCache, goMatchCreateFn, eventFn, sharedReg, sharedGlobals, id, node, stopped, name)
+		return NewRuntimeLuaMatchCore(logger, db, jsonpbMarshaler, jsonpbUnmarshaler, config, socialClient, leaderboardCache, leaderboardRankCache, leaderboardScheduler, sessionRegistry, sessionCache, matchRegistry, matchmaker, tracker, metrics, streamManager, router, pushQueue, stdLibs, once, localCache, configFileCache, httpClient, entitlementValidators, goMatchCreateFn, eventFn, sharedReg, sharedGlobals, id, node, stopped, name)
 	}
 
 	runtimeProviderLua := &RuntimeProviderLua{
@@ -169,7 +173,7 @@ func NewRuntimeProviderLua(logger, startupLogger *zap.Logger, db *sql.DB, jsonpb
 		statsCtx: context.Background(),
 	}
 
-	r, err := newRuntimeLuaVM(logger, db, jsonpbMarshaler, jsonpbUnmarshaler, config, socialClient, leaderboardCache, leaderboardRankCache, leaderboardScheduler, sessionRegistry, matchRegistry, tracker, streamManager, router, stdLibs, moduleCache, once, localCache, allMatchCreateFn, eventFn, func(execMode RuntimeExecutionMode, id string) {
+	r, err := newRuntimeLuaVM(logger, db, jsonpbMarshaler, jsonpbUnmarshaler, config, socialClient, leaderboardCache, leaderboardRankCache, leaderboardScheduler, sessionRegistry, sessionCache, matchRegistry, matchmaker, tracker, metrics, streamManager, router, pushQueue, stdLibs, moduleCache, once, localCache, configFileCache, httpClient, entitlementValidators, allMatchCreateFn, eventFn, func(execMode RuntimeExecutionMode, id string) {
 		switch execMode {
 		case RuntimeExecutionModeRPC:
 			rpcFunctions[id] = func(ctx context.Context, queryParams map[string][]string, userID, username string, vars map[string]string, expiry int64, sessionID, clientIP, clientPort, payload string) (string, error, codes.Code) {
@@ -991,6 +995,10 @@ func NewRuntimeProviderLua(logger, startupLogger *zap.Logger, db *sql.DB, jsonpb
 			matchmakerMatchedFunction = func(ctx context.Context, entries []*MatchmakerEntry) (string, bool, error) {
 				return runtimeProviderLua.MatchmakerMatched(ctx, entries)
 			}
+		case RuntimeExecutionModeMatchmakerOverride:
+			matchmaker.SetOverrideFunction(func(ctx context.Context, entries []*MatchmakerEntry) ([]*MatchmakerEntry, error) {
+				return runtimeProviderLua.MatchmakerOverride(ctx, entries)
+			})
 		case RuntimeExecutionModeTournamentEnd:
 			tournamentEndFunction = func(ctx context.Context, tournament *api.Tournament, end, reset int64) error {
 				return runtimeProviderLua.TournamentEnd(ctx, tournament, end, reset)
@@ -1045,6 +1053,8 @@ func NewRuntimeProviderLua(logger, startupLogger *zap.Logger, db *sql.DB, jsonpb
 				vm:        vm,
 				luaEnv:    RuntimeLuaConvertMapString(vm, config.GetRuntime().Environment),
 				callbacks: callbacksGlobals,
+
+				instructionLimit: config.GetRuntime().InstructionLimit,
 			}
 			return r
 		}
@@ -1052,7 +1062,7 @@ func NewRuntimeProviderLua(logger, startupLogger *zap.Logger, db *sql.DB, jsonpb
 		r.Stop()
 
 		runtimeProviderLua.newFn = func() *RuntimeLua {
-			r, err := newRuntimeLuaVM(logger, db, jsonpbMarshaler, jsonpbUnmarshaler, config, socialClient, leaderboardCache, leaderboardRankCache, leaderboardScheduler, sessionRegistry, matchRegistry, tracker, streamManager, router, stdLibs, moduleCache, once, localCache, allMatchCreateFn, eventFn, nil)
+			r, err := newRuntimeLuaVM(logger, db, jsonpbMarshaler, jsonpbUnmarshaler, config, socialClient, leaderboardCache, leaderboardRankCache, leaderboardScheduler, sessionRegistry, sessionCache, matchRegistry, matchmaker, tracker, metrics, streamManager, router, pushQueue, stdLibs, moduleCache, once, localCache, configFileCache, httpClient, entitlementValidators, allMatchCreateFn, eventFn, nil)
 			if err != nil {
 				logger.Fatal("Failed to initialize Lua runtime", zap.Error(err))
 			}
@@ -1488,28 +1498,7 @@ func (rp *RuntimeProviderLua) MatchmakerMatched(ctx context.Context, entries []*
 
 	luaCtx := NewRuntimeLuaContext(r.vm, r.node, r.luaEnv, RuntimeExecutionModeMatchmaker, nil, 0, "", "", nil, "", "", "")
 
-	entriesTable := r.vm.CreateTable(len(entries), 0)
-	for i, entry := range entries {
-		presenceTable := r.vm.CreateTable(0, 4)
-		presenceTable.RawSetString("user_id", lua.LString(entry.Presence.UserId))
-		presenceTable.RawSetString("session_id", lua.LString(entry.Presence.SessionId))
-		presenceTable.RawSetString("username", lua.LString(entry.Presence.Username))
-		presenceTable.RawSetString("node", lua.LString(entry.Presence.Node))
-
-		propertiesTable := r.vm.CreateTable(0, len(entry.StringProperties)+len(entry.NumericProperties))
-		for k, v := range entry.StringProperties {
-			propertiesTable.RawSetString(k, lua.LString(v))
-		}
-		for k, v := range entry.NumericProperties {
-			propertiesTable.RawSetString(k, lua.LNumber(v))
-		}
-
-		entryTable := r.vm.CreateTable(0, 2)
-		entryTable.RawSetString("presence", presenceTable)
-		entryTable.RawSetString("properties", propertiesTable)
-
-		entriesTable.RawSetInt(i+1, entryTable)
-	}
+	entriesTable := matchmakerEntriesToLuaTable(r.vm, entries)
 
 	retValue, err, _ := r.invokeFunction(r.vm, lf, luaCtx, entriesTable)
 	rp.Put(r)
@@ -1542,6 +1531,69 @@ func (rp *RuntimeProviderLua) MatchmakerMatched(ctx context.Context, entries []*
 	return "", false, errors.New("Unexpected return type from runtime Matchmaker Matched hook, must be string or nil.")
 }
 
+func (rp *RuntimeProviderLua) MatchmakerOverride(ctx context.Context, entries []*MatchmakerEntry) ([]*MatchmakerEntry, error) {
+	r, err := rp.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	lf := r.GetCallback(RuntimeExecutionModeMatchmakerOverride, "")
+	if lf == nil {
+		rp.Put(r)
+		return nil, errors.New("Runtime Matchmaker Override function not found.")
+	}
+
+	luaCtx := NewRuntimeLuaContext(r.vm, r.node, r.luaEnv, RuntimeExecutionModeMatchmakerOverride, nil, 0, "", "", nil, "", "", "")
+
+	entriesTable := matchmakerEntriesToLuaTable(r.vm, entries)
+
+	retValue, err, _ := r.invokeFunction(r.vm, lf, luaCtx, entriesTable)
+	rp.Put(r)
+	if err != nil {
+		return nil, fmt.Errorf("Error running runtime Matchmaker Override hook: %v", err.Error())
+	}
+
+	if retValue == nil || retValue == lua.LNil || retValue == lua.LFalse {
+		// Hook rejected the grouping.
+		return nil, nil
+	}
+
+	retTable, ok := retValue.(*lua.LTable)
+	if !ok {
+		return nil, errors.New("Unexpected return type from runtime Matchmaker Override hook, must be table or nil.")
+	}
+
+	// Returned entries are matched back to the candidates by ticket.
+	overridden := make([]*MatchmakerEntry, 0, retTable.Len())
+	var conversionError error
+	retTable.ForEach(func(k, v lua.LValue) {
+		if conversionError != nil {
+			return
+		}
+		entryTable, ok := v.(*lua.LTable)
+		if !ok {
+			conversionError = errors.New("Invalid return value from runtime Matchmaker Override hook, entries must be tables.")
+			return
+		}
+		ticket, ok := entryTable.RawGetString("ticket").(lua.LString)
+		if !ok || ticket == "" {
+			conversionError = errors.New("Invalid return value from runtime Matchmaker Override hook, entries must have a ticket.")
+			return
+		}
+		for _, entry := range entries {
+			if entry.Ticket == string(ticket) {
+				overridden = append(overridden, entry)
+				return
+			}
+		}
+		conversionError = ErrMatchmakerOverrideTicketUnknown
+	})
+	if conversionError != nil {
+		return nil, conversionError
+	}
+
+	return overridden, nil
+}
+
 func (rp *RuntimeProviderLua) TournamentEnd(ctx context.Context, tournament *api.Tournament, end, reset int64) error {
 	r, err := rp.Get(ctx)
 	if err != nil {
@@ -1754,6 +1806,8 @@ type RuntimeLua struct {
 	vm        *lua.LState
 	luaEnv    *lua.LTable
 	callbacks *RuntimeLuaCallbacks
+
+	instructionLimit int
 }
 
 func (r *RuntimeLua) loadModules(moduleCache *RuntimeLuaModuleCache) error {
@@ -1816,17 +1870,74 @@ func (r *RuntimeLua) loadModules(moduleCache *RuntimeLuaModuleCache) error {
 			continue
 		}
 
+		top := r.vm.GetTop()
 		r.vm.Push(fn)
 		fnErr := r.vm.PCall(0, -1, nil)
 		if fnErr != nil {
 			r.logger.Error("Could not complete runtime invocation", zap.Error(fnErr))
 			return fnErr
 		}
+
+		// Modules returning any match handler must return all the required ones, so failures surface at startup
+		// rather than when a match is first created.
+		if r.vm.GetTop() > top {
+			if tab, ok := r.vm.Get(top + 1).(*lua.LTable); ok {
+				if err := checkLuaMatchHandlers(name, tab); err != nil {
+					r.logger.Error("Invalid match module", zap.String("name", name), zap.Error(err))
+					return err
+				}
+			}
+		}
+		r.vm.SetTop(top)
 	}
 
 	return nil
 }
 
+var runtimeLuaMatchRequiredHandlers = []string{"match_init", "match_join_attempt", "match_join", "match_leave", "match_loop", "match_terminate"}
+
+// checkLuaMatchHandlers reports an error if a module table defines some, but not all, of the required match handlers.
+// Tables defining none of them are not match modules and are accepted as they are.
+func checkLuaMatchHandlers(name string, tab *lua.LTable) error {
+	missing := make([]string, 0, len(runtimeLuaMatchRequiredHandlers))
+	for _, handler := range runtimeLuaMatchRequiredHandlers {
+		if tab.RawGetString(handler).Type() != lua.LTFunction {
+			missing = append(missing, handler)
+		}
+	}
+	if len(missing) == 0 || len(missing) == len(runtimeLuaMatchRequiredHandlers) {
+		return nil
+	}
+	return fmt.Errorf("match module '%v' is missing required handlers: %v", name, strings.Join(missing, ", "))
+}
+
+func matchmakerEntriesToLuaTable(l *lua.LState, entries []*MatchmakerEntry) *lua.LTable {
+	entriesTable := l.CreateTable(len(entries), 0)
+	for i, entry := range entries {
+		presenceTable := l.CreateTable(0, 4)
+		presenceTable.RawSetString("user_id", lua.LString(entry.Presence.UserId))
+		presenceTable.RawSetString("session_id", lua.LString(entry.Presence.SessionId))
+		presenceTable.RawSetString("username", lua.LString(entry.Presence.Username))
+		presenceTable.RawSetString("node", lua.LString(entry.Presence.Node))
+
+		propertiesTable := l.CreateTable(0, len(entry.StringProperties)+len(entry.NumericProperties))
+		for k, v := range entry.StringProperties {
+			propertiesTable.RawSetString(k, lua.LString(v))
+		}
+		for k, v := range entry.NumericProperties {
+			propertiesTable.RawSetString(k, lua.LNumber(v))
+		}
+
+		entryTable := l.CreateTable(0, 3)
+		entryTable.RawSetString("ticket", lua.LString(entry.Ticket))
+		entryTable.RawSetString("presence", presenceTable)
+		entryTable.RawSetString("properties", propertiesTable)
+
+		entriesTable.RawSetInt(i+1, entryTable)
+	}
+	return entriesTable
+}
+
 func (r *RuntimeLua) GetCallback(e RuntimeExecutionMode, key string) *lua.LFunction {
 	switch e {
 	case RuntimeExecutionModeRPC:
@@ -1837,6 +1948,8 @@ func (r *RuntimeLua) GetCallback(e RuntimeExecutionMode, key string) *lua.LFunct
 		return r.callbacks.After[key]
 	case RuntimeExecutionModeMatchmaker:
 		return r.callbacks.Matchmaker
+	case RuntimeExecutionModeMatchmakerOverride:
+		return r.callbacks.MatchmakerOverride
 	case RuntimeExecutionModeTournamentEnd:
 		return r.callbacks.TournamentEnd
 	case RuntimeExecutionModeTournamentReset:
@@ -1879,7 +1992,7 @@ func (r *RuntimeLua) invokeFunction(l *lua.LState, fn *lua.LFunction, ctx *lua.L
 		nargs++
 	}
 
-	err := l.PCall(nargs, lua.MultRet, nil)
+	err := runtimeLuaPCall(l, r.instructionLimit, nargs)
 	if err != nil {
 		// Unwind the stack up to and including our sentinel value, effectively discarding any other returned parameters.
 		for {
@@ -1948,7 +2061,7 @@ func checkRuntimeLuaVM(logger *zap.Logger, config Config, stdLibs map[string]lua
 		vm.Push(lua.LString(name))
 		vm.Call(1, 0)
 	}
-	nakamaModule := NewRuntimeLuaNakamaModule(nil, nil, nil, nil, config, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	nakamaModule := NewRuntimeLuaNakamaModule(nil, nil, nil, nil, config, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
 	vm.PreloadModule("nakama", nakamaModule.Loader)
 
 	preload := vm.GetField(vm.GetField(vm.Get(lua.EnvironIndex), "package"), "preload")
@@ -1969,7 +2082,7 @@ func checkRuntimeLuaVM(logger *zap.Logger, config Config, stdLibs map[string]lua
 	return nil
 }
 
-func newRuntimeLuaVM(logger *zap.Logger, db *sql.DB, jsonpbMarshaler *jsonpb.Marshaler, jsonpbUnmarshaler *jsonpb.Unmarshaler, config Config, socialClient *social.Client, leaderboardCache LeaderboardCache, rankCache LeaderboardRankCache, leaderboardScheduler LeaderboardScheduler, sessionRegistry SessionRegistry, matchRegistry MatchRegistry, tracker Tracker, streamManager StreamManager, router MessageRouter, stdLibs map[string]lua.LGFunction, moduleCache *RuntimeLuaModuleCache, once *sync.Once, localCache *RuntimeLuaLocalCache, matchCreateFn RuntimeMatchCreateFunction, eventFn RuntimeEventCustomFunction, announceCallbackFn func(RuntimeExecutionMode, string)) (*RuntimeLua, error) {
+func newRuntimeLuaVM(logger *zap.Logger, db *sql.DB, jsonpbMarshaler *jsonpb.Marshaler, jsonpbUnmarshaler *jsonpb.Unmarshaler, config Config, socialClient *social.Client, leaderboardCache LeaderboardCache, rankCache LeaderboardRankCache, leaderboardScheduler LeaderboardScheduler, sessionRegistry SessionRegistry, sessionCache SessionCache, matchRegistry MatchRegistry, matchmaker Matchmaker, tracker Tracker, metrics *Metrics, streamManager StreamManager, router MessageRouter, pushQueue *PushQueue, stdLibs map[string]lua.LGFunction, moduleCache *RuntimeLuaModuleCache, once *sync.Once, localCache *RuntimeLuaLocalCache, configFileCache *RuntimeConfigFileCache, httpClient *http.Client, entitlementValidators *RuntimeEntitlementValidators, matchCreateFn RuntimeMatchCreateFunction, eventFn RuntimeEventCustomFunction, announceCallbackFn func(RuntimeExecutionMode, string)) (*RuntimeLua, error) {
 	vm := lua.NewState(lua.Options{
 		CallStackSize:       config.GetRuntime().CallStackSize,
 		RegistrySize:        config.GetRuntime().RegistrySize,
@@ -1997,6 +2110,8 @@ func newRuntimeLuaVM(logger *zap.Logger, db *sql.DB, jsonpbMarshaler *jsonpb.Mar
 			callbacks.After[key] = fn
 		case RuntimeExecutionModeMatchmaker:
 			callbacks.Matchmaker = fn
+		case RuntimeExecutionModeMatchmakerOverride:
+			callbacks.MatchmakerOverride = fn
 		case RuntimeExecutionModeTournamentEnd:
 			callbacks.TournamentEnd = fn
 		case RuntimeExecutionModeTournamentReset:
@@ -2005,7 +2120,7 @@ func newRuntimeLuaVM(logger *zap.Logger, db *sql.DB, jsonpbMarshaler *jsonpb.Mar
 			callbacks.LeaderboardReset = fn
 		}
 	}
-	nakamaModule := NewRuntimeLuaNakamaModule(logger, db, jsonpbMarshaler, jsonpbUnmarshaler, config, socialClient, leaderboardCache, rankCache, leaderboardScheduler, sessionRegistry, matchRegistry, tracker, streamManager, router, once, localCache, matchCreateFn, eventFn, registerCallbackFn, announceCallbackFn)
+	nakamaModule := NewRuntimeLuaNakamaModule(logger, db, jsonpbMarshaler, jsonpbUnmarshaler, config, socialClient, leaderboardCache, rankCache, leaderboardScheduler, sessionRegistry, sessionCache, matchRegistry, matchmaker, tracker, metrics, streamManager, router, pushQueue, once, localCache, configFileCache, httpClient, entitlementValidators, matchCreateFn, eventFn, registerCallbackFn, announceCallbackFn)
 	vm.PreloadModule("nakama", nakamaModule.Loader)
 	r := &RuntimeLua{
 		logger:    logger,
@@ -2013,6 +2128,8 @@ func newRuntimeLuaVM(logger *zap.Logger, db *sql.DB, jsonpbMarshaler *jsonpb.Mar
 		vm:        vm,
 		luaEnv:    RuntimeLuaConvertMapString(vm, config.GetRuntime().Environment),
 		callbacks: callbacks,
+
+		instructionLimit: config.GetRuntime().InstructionLimit,
 	}
 
 	return r, r.loadModules(moduleCache)
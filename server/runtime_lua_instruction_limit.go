@@ -0,0 +1,69 @@
+// Copyright 2018 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/heroiclabs/nakama/v2/internal/gopher-lua"
+	"go.uber.org/atomic"
+)
+
+var ErrRuntimeLuaInstructionLimit = errors.New("runtime instruction limit exceeded")
+
+// runtimeLuaInstructionLimitContext counts instructions executed by a Lua VM. The VM checks its context's Done channel
+// before every instruction it executes, so the count of those checks is the number of instructions run so far. Once it
+// passes the limit the context reports itself done, and the VM stops the call with an error.
+type runtimeLuaInstructionLimitContext struct {
+	context.Context
+	remaining *atomic.Int64
+	once      sync.Once
+	limitCh   chan struct{}
+}
+
+func (c *runtimeLuaInstructionLimitContext) Done() <-chan struct{} {
+	if c.remaining.Dec() < 0 {
+		c.once.Do(func() { close(c.limitCh) })
+		return c.limitCh
+	}
+	return c.Context.Done()
+}
+
+func (c *runtimeLuaInstructionLimitContext) Err() error {
+	if c.remaining.Load() < 0 {
+		return ErrRuntimeLuaInstructionLimit
+	}
+	return c.Context.Err()
+}
+
+// Call the function on the stack as PCall does, stopping it with an error if it executes more than the given number of
+// instructions. A limit of 0 leaves the call unbounded.
+func runtimeLuaPCall(l *lua.LState, instructionLimit int, nargs int) error {
+	if instructionLimit > 0 {
+		ctx := l.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		l.SetContext(&runtimeLuaInstructionLimitContext{
+			Context:   ctx,
+			remaining: atomic.NewInt64(int64(instructionLimit)),
+			limitCh:   make(chan struct{}),
+		})
+		defer l.SetContext(ctx)
+	}
+	return l.PCall(nargs, lua.MultRet, nil)
+}
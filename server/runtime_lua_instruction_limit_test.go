@@ -0,0 +1,57 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/heroiclabs/nakama/v2/internal/gopher-lua"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRuntimeLuaPCallInstructionLimit(t *testing.T) {
+	vm := lua.NewState()
+	defer vm.Close()
+	ctx := context.Background()
+	vm.SetContext(ctx)
+
+	call := func(script string, instructionLimit int) error {
+		fn, err := vm.LoadString(script)
+		if err != nil {
+			t.Fatalf("error loading script: %v", err)
+		}
+		vm.Push(fn)
+		return runtimeLuaPCall(vm, instructionLimit, 0)
+	}
+
+	// A runaway script is stopped once it passes the limit.
+	err := call(`while true do end`, 10000)
+	if err == nil {
+		t.Fatal("expected runaway script to be stopped")
+	}
+	assert.True(t, strings.Contains(err.Error(), ErrRuntimeLuaInstructionLimit.Error()), err.Error())
+	assert.Equal(t, ctx, vm.Context(), "expected the VM context to be restored")
+
+	// Scripts within the limit, or calls without a limit, run to completion.
+	assert.NoError(t, call(`local n = 0 for i = 1, 100 do n = n + i end`, 10000))
+	assert.NoError(t, call(`local n = 0 for i = 1, 100000 do n = n + i end`, 0))
+
+	// Each call gets the full limit, so calls that together exceed it still succeed.
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, call(`local n = 0 for i = 1, 2000 do n = n + i end`, 5000))
+	}
+}
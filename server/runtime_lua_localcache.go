@@ -16,18 +16,47 @@ package server
 
 import (
 	"sync"
+	"time"
 
 	lua "github.com/heroiclabs/nakama/v2/internal/gopher-lua"
 )
 
+// How often idle rate limit buckets are swept from the cache.
+const runtimeLuaRateLimitSweepInterval = time.Minute
+
+type runtimeLuaRateLimitBucket struct {
+	tokens float64
+	last   time.Time
+	limit  int
+	window time.Duration
+}
+
+// Refill the bucket for the time elapsed since it was last used.
+func (b *runtimeLuaRateLimitBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += float64(b.limit) * elapsed.Seconds() / b.window.Seconds()
+		if b.tokens > float64(b.limit) {
+			b.tokens = float64(b.limit)
+		}
+		b.last = now
+	}
+}
+
 type RuntimeLuaLocalCache struct {
 	sync.RWMutex
 	data map[string]lua.LValue
+
+	rateLimitMutex     sync.Mutex
+	rateLimitBuckets   map[string]*runtimeLuaRateLimitBucket
+	rateLimitLastSweep time.Time
 }
 
 func NewRuntimeLuaLocalCache() *RuntimeLuaLocalCache {
 	return &RuntimeLuaLocalCache{
 		data: make(map[string]lua.LValue),
+
+		rateLimitBuckets:   make(map[string]*runtimeLuaRateLimitBucket),
+		rateLimitLastSweep: time.Now(),
 	}
 }
 
@@ -49,3 +78,45 @@ func (lc *RuntimeLuaLocalCache) Delete(key string) {
 	delete(lc.data, key)
 	lc.Unlock()
 }
+
+// RateLimit takes a token from the bucket for the given key, which holds up to limit tokens and refills at a rate
+// of limit tokens per window. Returns whether the action is allowed, and the number of whole tokens remaining.
+func (lc *RuntimeLuaLocalCache) RateLimit(key string, limit int, window time.Duration) (bool, int) {
+	return lc.rateLimit(key, limit, window, time.Now())
+}
+
+func (lc *RuntimeLuaLocalCache) rateLimit(key string, limit int, window time.Duration, now time.Time) (bool, int) {
+	lc.rateLimitMutex.Lock()
+	defer lc.rateLimitMutex.Unlock()
+
+	if now.Sub(lc.rateLimitLastSweep) >= runtimeLuaRateLimitSweepInterval {
+		// Drop buckets that have refilled completely, they are equivalent to new ones.
+		for k, b := range lc.rateLimitBuckets {
+			b.refill(now)
+			if b.tokens >= float64(b.limit) {
+				delete(lc.rateLimitBuckets, k)
+			}
+		}
+		lc.rateLimitLastSweep = now
+	}
+
+	bucket, found := lc.rateLimitBuckets[key]
+	if !found || bucket.limit != limit || bucket.window != window {
+		// New key, or the key is now used with different parameters.
+		bucket = &runtimeLuaRateLimitBucket{
+			tokens: float64(limit),
+			last:   now,
+			limit:  limit,
+			window: window,
+		}
+		lc.rateLimitBuckets[key] = bucket
+	} else {
+		bucket.refill(now)
+	}
+
+	if bucket.tokens < 1 {
+		return false, 0
+	}
+	bucket.tokens--
+	return true, int(bucket.tokens)
+}
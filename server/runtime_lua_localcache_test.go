@@ -0,0 +1,76 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRuntimeLuaLocalCacheRateLimit(t *testing.T) {
+	lc := NewRuntimeLuaLocalCache()
+	now := time.Now()
+
+	// Exhaust the bucket.
+	for i := 2; i >= 0; i-- {
+		allowed, remaining := lc.rateLimit("user", 3, 10*time.Second, now)
+		assert.True(t, allowed)
+		assert.Equal(t, i, remaining)
+	}
+	allowed, remaining := lc.rateLimit("user", 3, 10*time.Second, now)
+	assert.False(t, allowed, "exhausted bucket should not allow actions")
+	assert.Equal(t, 0, remaining)
+
+	// Other keys have their own budget.
+	allowed, _ = lc.rateLimit("other", 3, 10*time.Second, now)
+	assert.True(t, allowed)
+
+	// A third of the window refills one token.
+	allowed, remaining = lc.rateLimit("user", 3, 10*time.Second, now.Add(4*time.Second))
+	assert.True(t, allowed)
+	assert.Equal(t, 0, remaining)
+	allowed, _ = lc.rateLimit("user", 3, 10*time.Second, now.Add(4*time.Second))
+	assert.False(t, allowed)
+
+	// Refilling never exceeds the limit.
+	allowed, remaining = lc.rateLimit("user", 3, 10*time.Second, now.Add(time.Hour))
+	assert.True(t, allowed)
+	assert.Equal(t, 2, remaining)
+}
+
+func TestRuntimeLuaLocalCacheRateLimitConcurrent(t *testing.T) {
+	lc := NewRuntimeLuaLocalCache()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var allowedCount int
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if allowed, _ := lc.RateLimit("user", 10, time.Hour); allowed {
+				mu.Lock()
+				allowedCount++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 10, allowedCount, "exactly the limit should be allowed")
+}
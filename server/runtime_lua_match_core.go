@@ -15,6 +15,8 @@
 package server
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"database/sql"
 	"errors"
@@ -26,16 +28,42 @@ import (
 	"github.com/heroiclabs/nakama/v2/social"
 	"go.uber.org/atomic"
 	"go.uber.org/zap"
+	"net/http"
 	"sync"
+	"time"
 )
 
+const (
+	// Header byte prefixed to match data sent with compression requested, marking the rest of the payload as uncompressed.
+	MatchDataCompressionNone byte = 0
+	// Header byte prefixed to match data sent with compression requested, marking the rest of the payload as gzip compressed.
+	MatchDataCompressionGzip byte = 1
+	// Payloads at or below this size in bytes are always sent uncompressed.
+	matchDataCompressionThreshold = 1024
+
+	runtimeLuaMatchLogContextKey = "_MATCH_LOG_CONTEXT"
+)
+
+// Bound to the registry of a match VM so log output from match callbacks includes the match ID and current tick.
+type runtimeLuaMatchLogContext struct {
+	matchID string
+	tick    int64
+}
+
 type RuntimeLuaMatchCore struct {
 	logger        *zap.Logger
+	db            *sql.DB
+	config        Config
 	matchRegistry MatchRegistry
 	router        MessageRouter
+	eventFn       RuntimeEventCustomFunction
 
 	deferMessageFn RuntimeMatchDeferMessageFunction
 	presenceList   *MatchPresenceList
+	opCodeFilter   *MatchOpCodeFilter
+	dedupFilter    *MatchDedupFilter
+	receipts       *MatchReceiptTracker
+	finalMessage   *MatchFinalMessage
 
 	id      uuid.UUID
 	node    string
@@ -51,13 +79,22 @@ type RuntimeLuaMatchCore struct {
 	leaveFn       lua.LValue
 	loopFn        lua.LValue
 	terminateFn   lua.LValue
+	signalFn      lua.LValue
+	paramsSchema  MatchParamsSchema
 	ctx           *lua.LTable
 	dispatcher    *lua.LTable
+	logContext    *runtimeLuaMatchLogContext
+
+	// Input queue depth when the current match loop started, and the queue size.
+	inputQueueDepth int
+	inputQueueSize  int
+	// Receipts that expired before the current match loop started.
+	expiredReceipts []string
 
 	ctxCancelFn context.CancelFunc
 }
 
-func NewRuntimeLuaMatchCore(logger *zap.Logger, db *sql.DB, jsonpbMarshaler *jsonpb.Marshaler, jsonpbUnmarshaler *jsonpb.Unmarshaler, config Config, socialClient *social.Client, leaderboardCache LeaderboardCache, rankCache LeaderboardRankCache, leaderboardScheduler LeaderboardScheduler, sessionRegistry SessionRegistry, matchRegistry MatchRegistry, tracker Tracker, streamManager StreamManager, router MessageRouter, stdLibs map[string]lua.LGFunction, once *sync.Once, localCache *RuntimeLuaLocalCache, goMatchCreateFn RuntimeMatchCreateFunction, eventFn RuntimeEventCustomFunction, sharedReg, sharedGlobals *lua.LTable, id uuid.UUID, node string, stopped *atomic.Bool, name string) (RuntimeMatchCore, error) {
+func NewRuntimeLuaMatchCore(logger *zap.Logger, db *sql.DB, jsonpbMarshaler *jsonpb.Marshaler, jsonpbUnmarshaler *jsonpb.Unmarshaler, config Config, socialClient *social.Client, leaderboardCache LeaderboardCache, rankCache LeaderboardRankCache, leaderboardScheduler LeaderboardScheduler, sessionRegistry SessionRegistry, sessionCache SessionCache, matchRegistry MatchRegistry, matchmaker Matchmaker, tracker Tracker, metrics *Metrics, streamManager StreamManager, router MessageRouter, pushQueue *PushQueue, stdLibs map[string]lua.LGFunction, once *sync.Once, localCache *RuntimeLuaLocalCache, configFileCache *RuntimeConfigFileCache, httpClient *http.Client, entitlementValidators *RuntimeEntitlementValidators, goMatchCreateFn RuntimeMatchCreateFunction, eventFn RuntimeEventCustomFunction, sharedReg, sharedGlobals *lua.LTable, id uuid.UUID, node string, stopped *atomic.Bool, name string) (RuntimeMatchCore, error) {
 	// Set up the Lua VM that will handle this match.
 	vm := lua.NewState(lua.Options{
 		CallStackSize:       config.GetRuntime().CallStackSize,
@@ -95,13 +132,17 @@ func NewRuntimeLuaMatchCore(logger *zap.Logger, db *sql.DB, jsonpbMarshaler *jso
 			if core != nil {
 				return core, nil
 			}
-			return NewRuntimeLuaMatchCore(logger, db, jsonpbMarshaler, jsonpbUnmarshaler, config, socialClient, leaderboardCache, rankCache, leaderboardScheduler, sessionRegistry, matchRegistry, tracker, streamManager, router, stdLibs, once, localCache, goMatchCreateFn, eventFn, nil, nil, id, node, stopped, name)
+			return NewRuntimeLuaMatchCore(logger, db, jsonpbMarshaler, jsonpbUnmarshaler, config, socialClient, leaderboardCache, rankCache, leaderboardScheduler, sessionRegistry, sessionCache, matchRegistry, matchmaker, tracker, metrics, streamManager, router, pushQueue, stdLibs, once, localCache, configFileCache, httpClient, entitlementValidators, goMatchCreateFn, eventFn, nil, nil, id, node, stopped, name)
 		}
 
-		nakamaModule := NewRuntimeLuaNakamaModule(logger, db, jsonpbMarshaler, jsonpbUnmarshaler, config, socialClient, leaderboardCache, rankCache, leaderboardScheduler, sessionRegistry, matchRegistry, tracker, streamManager, router, once, localCache, allMatchCreateFn, eventFn, nil, nil)
+		nakamaModule := NewRuntimeLuaNakamaModule(logger, db, jsonpbMarshaler, jsonpbUnmarshaler, config, socialClient, leaderboardCache, rankCache, leaderboardScheduler, sessionRegistry, sessionCache, matchRegistry, matchmaker, tracker, metrics, streamManager, router, pushQueue, once, localCache, configFileCache, httpClient, entitlementValidators, allMatchCreateFn, eventFn, nil, nil)
 		vm.PreloadModule("nakama", nakamaModule.Loader)
 	}
 
+	// Bind the log context to this match VM only, even when running with read-only globals.
+	logContext := &runtimeLuaMatchLogContext{matchID: fmt.Sprintf("%v.%v", id.String(), node)}
+	vm.Get(lua.RegistryIndex).(*lua.LTable).RawSetString(runtimeLuaMatchLogContextKey, &lua.LUserData{Value: logContext})
+
 	// Create the context to be used throughout this match.
 	ctx := vm.CreateTable(0, 7)
 	ctx.RawSetString(__RUNTIME_LUA_CTX_ENV, RuntimeLuaConvertMapString(vm, config.GetRuntime().Environment))
@@ -156,11 +197,45 @@ func NewRuntimeLuaMatchCore(logger *zap.Logger, db *sql.DB, jsonpbMarshaler *jso
 		ctxCancelFn()
 		return nil, errors.New("match_terminate not found or not a function")
 	}
+	// Signal handling is optional.
+	signalFn := tab.RawGet(lua.LString("match_signal"))
+	if signalFn.Type() != lua.LTNil && signalFn.Type() != lua.LTFunction {
+		ctxCancelFn()
+		return nil, errors.New("match_signal not a function")
+	}
+	// Params schema is optional.
+	var paramsSchema MatchParamsSchema
+	switch schemaValue := tab.RawGet(lua.LString("match_params_schema")); schemaValue.Type() {
+	case lua.LTNil:
+	case lua.LTTable:
+		paramsSchema = make(MatchParamsSchema)
+		var schemaErr error
+		schemaValue.(*lua.LTable).ForEach(func(k, v lua.LValue) {
+			if k.Type() != lua.LTString || v.Type() != lua.LTString {
+				schemaErr = errors.New("match_params_schema must be a table of param name strings to type strings")
+				return
+			}
+			paramsSchema[k.String()] = v.String()
+		})
+		if schemaErr == nil {
+			schemaErr = paramsSchema.Validate()
+		}
+		if schemaErr != nil {
+			ctxCancelFn()
+			return nil, schemaErr
+		}
+	default:
+		ctxCancelFn()
+		return nil, errors.New("match_params_schema not a table")
+	}
 
 	core := &RuntimeLuaMatchCore{
 		logger:        logger,
+		db:            db,
+		config:        config,
 		matchRegistry: matchRegistry,
 		router:        router,
+		eventFn:       eventFn,
 
 		// deferMessageFn set in MatchInit.
 		// presenceList set in MatchInit.
@@ -183,23 +258,46 @@ func NewRuntimeLuaMatchCore(logger *zap.Logger, db *sql.DB, jsonpbMarshaler *jso
 		leaveFn:       leaveFn,
 		loopFn:        loopFn,
 		terminateFn:   terminateFn,
+		signalFn:      signalFn,
+		paramsSchema:  paramsSchema,
 		ctx:           ctx,
+		logContext:    logContext,
+		opCodeFilter:  NewMatchOpCodeFilter(metrics),
+		dedupFilter:   NewMatchDedupFilter(config.GetMatch().DedupWindow),
+		receipts:      NewMatchReceiptTracker(config.GetMatch().MaxPendingReceipts, time.Duration(config.GetMatch().ReceiptTimeoutMs)*time.Millisecond),
+		finalMessage:  &MatchFinalMessage{},
 		// dispatcher set below.
 
 		ctxCancelFn: ctxCancelFn,
 	}
 
-	core.dispatcher = vm.SetFuncs(vm.CreateTable(0, 4), map[string]lua.LGFunction{
+	core.dispatcher = vm.SetFuncs(vm.CreateTable(0, 14), map[string]lua.LGFunction{
 		"broadcast_message":          core.broadcastMessage,
 		"broadcast_message_deferred": core.broadcastMessageDeferred,
+		"broadcast_final_message":    core.broadcastFinalMessage,
+		"broadcast_message_by_var":   core.broadcastMessageByVar,
+		"match_presence_list":        core.matchPresenceList,
 		"match_kick":                 core.matchKick,
 		"match_label_update":         core.matchLabelUpdate,
+		"match_allowed_op_codes":     core.matchAllowedOpCodes,
+		"match_dedup":                core.matchDedup,
+		"match_input_queue_depth":    core.matchInputQueueDepth,
+		"match_expired_receipts":     core.matchExpiredReceipts,
+		"match_has_space":            core.matchHasSpace,
+		"match_max_size":             core.matchMaxSize,
 	})
 
 	return core, nil
 }
 
 func (r *RuntimeLuaMatchCore) MatchInit(presenceList *MatchPresenceList, deferMessageFn RuntimeMatchDeferMessageFunction, params map[string]interface{}) (interface{}, int, error) {
+	// Reject params that don't match the declared schema before the handler sees them.
+	if r.paramsSchema != nil {
+		if err := r.paramsSchema.ValidateParams(params); err != nil {
+			return nil, 0, err
+		}
+	}
+
 	// Run the match_init sequence.
 	r.vm.Push(LSentinel)
 	r.vm.Push(r.initFn)
@@ -210,7 +308,7 @@ func (r *RuntimeLuaMatchCore) MatchInit(presenceList *MatchPresenceList, deferMe
 		r.vm.Push(RuntimeLuaConvertMap(r.vm, params))
 	}
 
-	err := r.vm.PCall(2, lua.MultRet, nil)
+	err := runtimeLuaPCall(r.vm, r.config.GetRuntime().InstructionLimit, 2)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -246,9 +344,14 @@ func (r *RuntimeLuaMatchCore) MatchInit(presenceList *MatchPresenceList, deferMe
 	// Extract initial state.
 	state := r.vm.Get(-1)
 	if state.Type() == LTSentinel {
-		return nil, 0, errors.New("match_init returned unexpected first value, must be a state")
+		if r.config.GetMatch().StrictInitState {
+			return nil, 0, errors.New("match_init returned unexpected first value, must be a state")
+		}
+		// Lenient mode allows matches with no meaningful initial state to omit it.
+		state = r.vm.NewTable()
+	} else {
+		r.vm.Pop(1)
 	}
-	r.vm.Pop(1)
 
 	// Drop the sentinel value from the stack.
 	if sentinel := r.vm.Get(-1); sentinel.Type() != LTSentinel {
@@ -271,12 +374,15 @@ func (r *RuntimeLuaMatchCore) MatchInit(presenceList *MatchPresenceList, deferMe
 	return state, rateInt, nil
 }
 
-func (r *RuntimeLuaMatchCore) MatchJoinAttempt(tick int64, state interface{}, userID, sessionID uuid.UUID, username string, sessionExpiry int64, vars map[string]string, clientIP, clientPort, node string, metadata map[string]string) (interface{}, bool, string, error) {
-	presence := r.vm.CreateTable(0, 4)
+func (r *RuntimeLuaMatchCore) MatchJoinAttempt(tick int64, state interface{}, userID, sessionID uuid.UUID, username string, sessionExpiry int64, vars map[string]string, clientIP, clientPort, node string, metadata map[string]string, reserved bool) (interface{}, bool, string, error) {
+	r.logContext.tick = tick
+
+	presence := r.vm.CreateTable(0, 5)
 	presence.RawSetString("user_id", lua.LString(userID.String()))
 	presence.RawSetString("session_id", lua.LString(sessionID.String()))
 	presence.RawSetString("username", lua.LString(username))
 	presence.RawSetString("node", lua.LString(node))
+	presence.RawSetString("reserved", lua.LBool(reserved))
 
 	metadataTable := r.vm.CreateTable(0, len(metadata))
 	for k, v := range metadata {
@@ -316,7 +422,7 @@ func (r *RuntimeLuaMatchCore) MatchJoinAttempt(tick int64, state interface{}, us
 	r.vm.Push(presence)
 	r.vm.Push(metadataTable)
 
-	err := r.vm.PCall(6, lua.MultRet, nil)
+	err := runtimeLuaPCall(r.vm, r.config.GetRuntime().InstructionLimit, 6)
 	if err != nil {
 		return nil, false, "", err
 	}
@@ -369,17 +475,23 @@ func (r *RuntimeLuaMatchCore) MatchJoinAttempt(tick int64, state interface{}, us
 }
 
 func (r *RuntimeLuaMatchCore) MatchJoin(tick int64, state interface{}, joins []*MatchPresence) (interface{}, error) {
+	r.logContext.tick = tick
+
 	if r.joinFn == nil {
 		return state, nil
 	}
 
 	presences := r.vm.CreateTable(len(joins), 0)
 	for i, p := range joins {
-		presence := r.vm.CreateTable(0, 4)
+		presence := r.vm.CreateTable(0, 6)
 		presence.RawSetString("user_id", lua.LString(p.UserID.String()))
 		presence.RawSetString("session_id", lua.LString(p.SessionID.String()))
 		presence.RawSetString("username", lua.LString(p.Username))
 		presence.RawSetString("node", lua.LString(p.Node))
+		presence.RawSetString("reserved", lua.LBool(p.Reserved))
+		if p.Metadata != nil {
+			presence.RawSetString("metadata", RuntimeLuaConvertMapString(r.vm, p.Metadata))
+		}
 
 		presences.RawSetInt(i+1, presence)
 	}
@@ -393,7 +505,7 @@ func (r *RuntimeLuaMatchCore) MatchJoin(tick int64, state interface{}, joins []*
 	r.vm.Push(state.(lua.LValue))
 	r.vm.Push(presences)
 
-	err := r.vm.PCall(5, lua.MultRet, nil)
+	err := runtimeLuaPCall(r.vm, r.config.GetRuntime().InstructionLimit, 5)
 	if err != nil {
 		return nil, err
 	}
@@ -414,13 +526,18 @@ func (r *RuntimeLuaMatchCore) MatchJoin(tick int64, state interface{}, joins []*
 }
 
 func (r *RuntimeLuaMatchCore) MatchLeave(tick int64, state interface{}, leaves []*MatchPresence) (interface{}, error) {
+	r.logContext.tick = tick
+	r.receipts.Leave(leaves)
+	r.dedupFilter.Leave(leaves)
+
 	presences := r.vm.CreateTable(len(leaves), 0)
 	for i, p := range leaves {
-		presence := r.vm.CreateTable(0, 4)
+		presence := r.vm.CreateTable(0, 5)
 		presence.RawSetString("user_id", lua.LString(p.UserID.String()))
 		presence.RawSetString("session_id", lua.LString(p.SessionID.String()))
 		presence.RawSetString("username", lua.LString(p.Username))
 		presence.RawSetString("node", lua.LString(p.Node))
+		presence.RawSetString("reason", lua.LNumber(p.Reason))
 
 		presences.RawSetInt(i+1, presence)
 	}
@@ -434,7 +551,7 @@ func (r *RuntimeLuaMatchCore) MatchLeave(tick int64, state interface{}, leaves [
 	r.vm.Push(state.(lua.LValue))
 	r.vm.Push(presences)
 
-	err := r.vm.PCall(5, lua.MultRet, nil)
+	err := runtimeLuaPCall(r.vm, r.config.GetRuntime().InstructionLimit, 5)
 	if err != nil {
 		return nil, err
 	}
@@ -454,12 +571,29 @@ func (r *RuntimeLuaMatchCore) MatchLeave(tick int64, state interface{}, leaves [
 	return newState, nil
 }
 
-func (r *RuntimeLuaMatchCore) MatchLoop(tick int64, state interface{}, inputCh <-chan *MatchDataMessage) (interface{}, error) {
-	// Drain the input queue into a Lua table.
+func (r *RuntimeLuaMatchCore) MatchLoop(tick int64, state interface{}, inputCh <-chan *MatchDataMessage, delta time.Duration) (interface{}, error) {
+	r.logContext.tick = tick
+
+	// Drain the input queue into a Lua table, dropping any duplicates or op codes the match does not accept.
 	size := len(inputCh)
+	r.inputQueueDepth, r.inputQueueSize = size, cap(inputCh)
+	r.expiredReceipts = r.receipts.Expire(time.Now())
 	input := r.vm.CreateTable(size, 0)
 	for i := 1; i <= size; i++ {
 		msg := <-inputCh
+		if !r.dedupFilter.Allow(msg.SessionID, msg.Seq) {
+			r.logger.Debug("Dropping duplicate match data", zap.Uint64("seq", msg.Seq), zap.String("uid", msg.UserID.String()))
+			continue
+		}
+		if r.receipts.Intercepts(msg.OpCode, r.config.GetMatch().ReceiptOpCode) {
+			if !r.receipts.Ack(string(msg.Data), msg.SessionID) {
+				r.logger.Debug("Dropping unknown match receipt acknowledgement", zap.String("uid", msg.UserID.String()))
+				continue
+			}
+		} else if !r.opCodeFilter.Allow(msg.OpCode) {
+			r.logger.Debug("Dropping match data with disallowed op code", zap.Int64("op_code", msg.OpCode), zap.String("uid", msg.UserID.String()))
+			continue
+		}
 
 		presence := r.vm.CreateTable(0, 4)
 		presence.RawSetString("user_id", lua.LString(msg.UserID.String()))
@@ -478,7 +612,7 @@ func (r *RuntimeLuaMatchCore) MatchLoop(tick int64, state interface{}, inputCh <
 		in.RawSetString("reliable", lua.LBool(msg.Reliable))
 		in.RawSetString("receive_time_ms", lua.LNumber(msg.ReceiveTime))
 
-		input.RawSetInt(i, in)
+		input.Append(in)
 	}
 
 	// Execute the match_loop call.
@@ -489,8 +623,10 @@ func (r *RuntimeLuaMatchCore) MatchLoop(tick int64, state interface{}, inputCh <
 	r.vm.Push(lua.LNumber(tick))
 	r.vm.Push(state.(lua.LValue))
 	r.vm.Push(input)
+	// Real time elapsed since the previous loop in milliseconds, including any fractional part.
+	r.vm.Push(lua.LNumber(float64(delta) / float64(time.Millisecond)))
 
-	err := r.vm.PCall(5, lua.MultRet, nil)
+	err := runtimeLuaPCall(r.vm, r.config.GetRuntime().InstructionLimit, 6)
 	if err != nil {
 		return nil, err
 	}
@@ -511,6 +647,8 @@ func (r *RuntimeLuaMatchCore) MatchLoop(tick int64, state interface{}, inputCh <
 }
 
 func (r *RuntimeLuaMatchCore) MatchTerminate(tick int64, state interface{}, graceSeconds int) (interface{}, error) {
+	r.logContext.tick = tick
+
 	// Execute the match_terminate call.
 	r.vm.Push(LSentinel)
 	r.vm.Push(r.terminateFn)
@@ -520,7 +658,7 @@ func (r *RuntimeLuaMatchCore) MatchTerminate(tick int64, state interface{}, grac
 	r.vm.Push(state.(lua.LValue))
 	r.vm.Push(lua.LNumber(graceSeconds))
 
-	err := r.vm.PCall(5, lua.MultRet, nil)
+	err := runtimeLuaPCall(r.vm, r.config.GetRuntime().InstructionLimit, 5)
 	if err != nil {
 		return nil, err
 	}
@@ -540,6 +678,61 @@ func (r *RuntimeLuaMatchCore) MatchTerminate(tick int64, state interface{}, grac
 	return newState, nil
 }
 
+func (r *RuntimeLuaMatchCore) MatchSignal(tick int64, state interface{}, data string) (interface{}, string, error) {
+	if r.signalFn.Type() == lua.LTNil {
+		// Match does not handle signals, keep the state unchanged.
+		return state, "", nil
+	}
+
+	r.logContext.tick = tick
+
+	// Get state signals keep the match running after an error or no state, leave the VM stack as it was found.
+	defer r.vm.SetTop(r.vm.GetTop())
+
+	// Execute the match_signal call.
+	r.vm.Push(LSentinel)
+	r.vm.Push(r.signalFn)
+	r.vm.Push(r.ctx)
+	r.vm.Push(r.dispatcher)
+	r.vm.Push(lua.LNumber(tick))
+	r.vm.Push(state.(lua.LValue))
+	r.vm.Push(lua.LString(data))
+
+	err := runtimeLuaPCall(r.vm, r.config.GetRuntime().InstructionLimit, 5)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Extract the optional result string.
+	var result string
+	resultOrState := r.vm.Get(-1)
+	if resultOrState.Type() == LTSentinel {
+		return nil, "", nil
+	}
+	if resultOrState.Type() == lua.LTString && r.vm.Get(-2).Type() != LTSentinel {
+		result = resultOrState.String()
+		r.vm.Pop(1)
+	}
+
+	// Extract the resulting state.
+	newState := r.vm.Get(-1)
+	if newState.Type() == lua.LTNil || newState.Type() == LTSentinel {
+		return nil, "", nil
+	}
+	r.vm.Pop(1)
+	// Check for and remove the sentinel value, will fail if there are any extra return values.
+	if sentinel := r.vm.Get(-1); sentinel.Type() != LTSentinel {
+		return nil, "", errors.New("Match signal returned too many values, stopping match")
+	}
+	r.vm.Pop(1)
+
+	return newState, result, nil
+}
+
+func (r *RuntimeLuaMatchCore) MatchFinalMessage() ([]*PresenceID, *rtapi.Envelope) {
+	return r.finalMessage.Resolve(r.presenceList)
+}
+
 func (r *RuntimeLuaMatchCore) Label() string {
 	return r.label.Load()
 }
@@ -556,6 +749,21 @@ func (r *RuntimeLuaMatchCore) broadcastMessage(l *lua.LState) int {
 	}
 
 	presenceIDs, msg, reliable := r.validateBroadcast(l)
+
+	// An optional receipt ID requests acknowledgements from the recipients of a reliable message.
+	if receiptID := l.OptString(7, ""); receiptID != "" {
+		if !reliable {
+			l.ArgError(7, "expects reliable to be true when requesting a receipt")
+			return 0
+		}
+		if len(presenceIDs) != 0 {
+			if err := r.receipts.Add(receiptID, presenceIDs); err != nil {
+				l.RaiseError("error requesting receipt: %v", err.Error())
+				return 0
+			}
+		}
+	}
+
 	if len(presenceIDs) != 0 {
 		r.router.SendToPresenceIDs(r.logger, presenceIDs, msg, reliable)
 	}
@@ -563,6 +771,22 @@ func (r *RuntimeLuaMatchCore) broadcastMessage(l *lua.LState) int {
 	return 0
 }
 
+func (r *RuntimeLuaMatchCore) broadcastFinalMessage(l *lua.LState) int {
+	if r.stopped.Load() {
+		l.RaiseError("match stopped")
+		return 0
+	}
+
+	presenceIDs, msg, _ := r.validateBroadcast(l)
+	if msg != nil {
+		// Final messages are always reliable.
+		msg.GetMatchData().Reliable = true
+	}
+	r.finalMessage.Set(presenceIDs, msg, l.Get(3) == lua.LNil)
+
+	return 0
+}
+
 func (r *RuntimeLuaMatchCore) broadcastMessageDeferred(l *lua.LState) int {
 	if r.stopped.Load() {
 		l.RaiseError("match stopped")
@@ -583,6 +807,67 @@ func (r *RuntimeLuaMatchCore) broadcastMessageDeferred(l *lua.LState) int {
 	return 0
 }
 
+func (r *RuntimeLuaMatchCore) broadcastMessageByVar(l *lua.LState) int {
+	if r.stopped.Load() {
+		l.RaiseError("match stopped")
+		return 0
+	}
+
+	key := l.CheckString(1)
+	if key == "" {
+		l.ArgError(1, "expects var key")
+		return 0
+	}
+	value := l.CheckString(2)
+
+	presences := r.presenceList.ListPresencesByVar(key, value)
+	if len(presences) == 0 {
+		return 0
+	}
+	filter := l.CreateTable(len(presences), 0)
+	for i, p := range presences {
+		presence := l.CreateTable(0, 2)
+		presence.RawSetString("session_id", lua.LString(p.SessionID.String()))
+		presence.RawSetString("node", lua.LString(p.Node))
+		filter.RawSetInt(i+1, presence)
+	}
+
+	// Rearrange the arguments into the regular broadcast form, with the matching presences as the filter.
+	args := []lua.LValue{l.Get(3), l.Get(4), filter, l.Get(5), l.Get(6), l.Get(7)}
+	l.SetTop(0)
+	for _, arg := range args {
+		l.Push(arg)
+	}
+
+	presenceIDs, msg, reliable := r.validateBroadcast(l)
+	if len(presenceIDs) != 0 {
+		r.router.SendToPresenceIDs(r.logger, presenceIDs, msg, reliable)
+	}
+
+	return 0
+}
+
+func (r *RuntimeLuaMatchCore) matchPresenceList(l *lua.LState) int {
+	matchPresences := r.presenceList.ListPresences()
+
+	presences := l.CreateTable(len(matchPresences), 0)
+	for i, p := range matchPresences {
+		presence := l.CreateTable(0, 5)
+		presence.RawSetString("user_id", lua.LString(p.UserID.String()))
+		presence.RawSetString("session_id", lua.LString(p.SessionID.String()))
+		presence.RawSetString("username", lua.LString(p.Username))
+		presence.RawSetString("node", lua.LString(p.Node))
+		if p.Vars != nil {
+			presence.RawSetString("vars", RuntimeLuaConvertMapString(l, p.Vars))
+		}
+
+		presences.RawSetInt(i+1, presence)
+	}
+
+	l.Push(presences)
+	return 1
+}
+
 func (r *RuntimeLuaMatchCore) validateBroadcast(l *lua.LState) ([]*PresenceID, *rtapi.Envelope, bool) {
 	opCode := l.CheckInt64(1)
 
@@ -756,6 +1041,15 @@ func (r *RuntimeLuaMatchCore) validateBroadcast(l *lua.LState) ([]*PresenceID, *
 
 	reliable := l.OptBool(5, true)
 
+	if compress := l.OptBool(6, false); compress {
+		var err error
+		dataBytes, err = compressMatchData(dataBytes)
+		if err != nil {
+			l.RaiseError("error compressing message data: %v", err)
+			return nil, nil, false
+		}
+	}
+
 	msg := &rtapi.Envelope{Message: &rtapi.Envelope_MatchData{MatchData: &rtapi.MatchData{
 		MatchId:  r.idStr,
 		Presence: presence,
@@ -771,6 +1065,29 @@ func (r *RuntimeLuaMatchCore) validateBroadcast(l *lua.LState) ([]*PresenceID, *
 	return presenceIDs, msg, reliable
 }
 
+// Gzip compress match data if it's above the compression threshold and compressing actually reduces its size.
+// The result always starts with a header byte flagging the compression applied to the rest of the payload.
+func compressMatchData(data []byte) ([]byte, error) {
+	if len(data) <= matchDataCompressionThreshold {
+		return append([]byte{MatchDataCompressionNone}, data...), nil
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(MatchDataCompressionGzip)
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	if buf.Len() > len(data) {
+		return append([]byte{MatchDataCompressionNone}, data...), nil
+	}
+	return buf.Bytes(), nil
+}
+
 func (r *RuntimeLuaMatchCore) matchKick(l *lua.LState) int {
 	if r.stopped.Load() {
 		l.RaiseError("match stopped")
@@ -838,10 +1155,98 @@ func (r *RuntimeLuaMatchCore) matchKick(l *lua.LState) int {
 		return 0
 	}
 
+	// Optional reason and kicker, recorded in the kick audit if auditing is enabled.
+	reason := l.OptString(2, "")
+	kicker := l.OptString(3, "")
+
+	AuditMatchKick(r.logger, r.db, r.config.GetMatch(), r.idStr, kicker, reason, presences)
 	r.matchRegistry.Kick(r.stream, presences)
 	return 0
 }
 
+func (r *RuntimeLuaMatchCore) matchAllowedOpCodes(l *lua.LState) int {
+	input := l.OptTable(1, nil)
+	if input == nil {
+		// Remove the filter, all op codes are accepted again.
+		r.opCodeFilter.SetAllowed(nil)
+		return 0
+	}
+
+	opCodes := make([]int64, 0, input.Len())
+	var conversionError bool
+	input.ForEach(func(k lua.LValue, v lua.LValue) {
+		if conversionError {
+			return
+		}
+		opCode, ok := v.(lua.LNumber)
+		if !ok {
+			conversionError = true
+			return
+		}
+		opCodes = append(opCodes, int64(opCode))
+	})
+	if conversionError {
+		l.ArgError(1, "expects a table of op code numbers")
+		return 0
+	}
+
+	r.opCodeFilter.SetAllowed(opCodes)
+	return 0
+}
+
+func (r *RuntimeLuaMatchCore) matchDedup(l *lua.LState) int {
+	r.dedupFilter.SetEnabled(l.OptBool(1, true))
+	return 0
+}
+
+func (r *RuntimeLuaMatchCore) matchInputQueueDepth(l *lua.LState) int {
+	l.Push(lua.LNumber(r.inputQueueDepth))
+	l.Push(lua.LNumber(r.inputQueueSize))
+	return 2
+}
+
+func (r *RuntimeLuaMatchCore) matchExpiredReceipts(l *lua.LState) int {
+	receiptIDs := l.CreateTable(len(r.expiredReceipts), 0)
+	for i, receiptID := range r.expiredReceipts {
+		receiptIDs.RawSetInt(i+1, lua.LString(receiptID))
+	}
+	l.Push(receiptIDs)
+	return 1
+}
+
+func (r *RuntimeLuaMatchCore) matchHasSpace(l *lua.LState) int {
+	max := l.CheckInt(1)
+	if max < 0 {
+		l.ArgError(1, "expects max to be >= 0")
+		return 0
+	}
+
+	// Optional session ID of the joining presence, so its own reservation is not counted against it.
+	var sessionID uuid.UUID
+	if sessionIDString := l.OptString(2, ""); sessionIDString != "" {
+		var err error
+		sessionID, err = uuid.FromString(sessionIDString)
+		if err != nil {
+			l.ArgError(2, "expects valid session id")
+			return 0
+		}
+	}
+
+	l.Push(lua.LBool(r.presenceList.HasSpace(sessionID, max)))
+	return 1
+}
+
+func (r *RuntimeLuaMatchCore) matchMaxSize(l *lua.LState) int {
+	max := l.CheckInt(1)
+	if max < 0 {
+		l.ArgError(1, "expects max size to be >= 0")
+		return 0
+	}
+
+	r.presenceList.SetMaxSize(max)
+	return 0
+}
+
 func (r *RuntimeLuaMatchCore) matchLabelUpdate(l *lua.LState) int {
 	if r.stopped.Load() {
 		l.RaiseError("match stopped")
@@ -854,7 +1259,9 @@ func (r *RuntimeLuaMatchCore) matchLabelUpdate(l *lua.LState) int {
 		l.RaiseError("error updating match label: %v", err.Error())
 		return 0
 	}
+	previous := r.label.Load()
 	r.label.Store(input)
+	EmitMatchLabelUpdateEvent(l.Context(), r.config, r.eventFn, r.idStr, previous, input)
 
 	// This must be executed from inside a match call so safe to update here.
 	r.ctx.RawSetString(__RUNTIME_LUA_CTX_MATCH_LABEL, lua.LString(input))
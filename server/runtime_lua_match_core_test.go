@@ -0,0 +1,493 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/heroiclabs/nakama/v2/internal/gopher-lua"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/tally"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+// Match module callbacks that do nothing, tests override only the callbacks they exercise.
+const testLuaMatchCallbacks = `
+local M = {}
+function M.match_init(context, params)
+	return {}, 10, ""
+end
+function M.match_join_attempt(context, dispatcher, tick, state, presence, metadata)
+	return state, true
+end
+function M.match_join(context, dispatcher, tick, state, presences)
+	return state
+end
+function M.match_leave(context, dispatcher, tick, state, presences)
+	return state
+end
+function M.match_loop(context, dispatcher, tick, state, messages)
+	return state
+end
+function M.match_terminate(context, dispatcher, tick, state, grace_seconds)
+	return state
+end
+`
+
+// Create Lua match cores running a match module with the given callbacks, defined in Lua as functions on M, in place of
+// the do-nothing defaults. Runs without a runtime or database.
+func newTestLuaMatchCreateFn(config Config, matchRegistry MatchRegistry, metrics *Metrics, router MessageRouter, callbacks string) RuntimeMatchCreateFunction {
+	moduleCache := &RuntimeLuaModuleCache{
+		Names:   make([]string, 0),
+		Modules: make(map[string]*RuntimeLuaModule, 0),
+	}
+	source := testLuaMatchCallbacks + callbacks + "\nreturn M\n"
+	moduleCache.Add(&RuntimeLuaModule{Name: "match", Path: "match.lua", Content: []byte(source)})
+	stdLibs := map[string]lua.LGFunction{
+		lua.LoadLibName:   OpenPackage(moduleCache),
+		lua.BaseLibName:   lua.OpenBase,
+		lua.TabLibName:    lua.OpenTable,
+		lua.StringLibName: lua.OpenString,
+		lua.MathLibName:   lua.OpenMath,
+	}
+	goMatchCreateFn := func(ctx context.Context, logger *zap.Logger, id uuid.UUID, node string, stopped *atomic.Bool, name string) (RuntimeMatchCore, error) {
+		return nil, nil
+	}
+
+	return func(ctx context.Context, logger *zap.Logger, id uuid.UUID, node string, stopped *atomic.Bool, name string) (RuntimeMatchCore, error) {
+		return NewRuntimeLuaMatchCore(logger, nil, jsonpbMarshaler, jsonpbUnmarshaler, config, nil, nil, nil, nil, nil, nil, matchRegistry, nil, nil, metrics, nil, router, nil, stdLibs, &sync.Once{}, NewRuntimeLuaLocalCache(), nil, nil, nil, goMatchCreateFn, nil, nil, nil, id, node, stopped, "match")
+	}
+}
+
+func TestCompressMatchDataSmallPayload(t *testing.T) {
+	data := []byte(strings.Repeat("a", matchDataCompressionThreshold))
+
+	result, err := compressMatchData(data)
+	if err != nil {
+		t.Fatalf("error compressing match data: %v", err.Error())
+	}
+
+	assert.Equal(t, MatchDataCompressionNone, result[0], "small payload should not be flagged as compressed")
+	assert.Equal(t, data, result[1:])
+}
+
+func TestCompressMatchDataLargePayload(t *testing.T) {
+	data := []byte(strings.Repeat("a", matchDataCompressionThreshold*4))
+
+	result, err := compressMatchData(data)
+	if err != nil {
+		t.Fatalf("error compressing match data: %v", err.Error())
+	}
+
+	assert.Equal(t, MatchDataCompressionGzip, result[0], "large payload should be flagged as compressed")
+	assert.True(t, len(result) < len(data), "compressed payload should be smaller")
+
+	r, err := gzip.NewReader(bytes.NewReader(result[1:]))
+	if err != nil {
+		t.Fatalf("error reading compressed match data: %v", err.Error())
+	}
+	decompressed, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("error decompressing match data: %v", err.Error())
+	}
+	assert.Equal(t, data, decompressed)
+}
+
+func TestCompressMatchDataIncompressiblePayload(t *testing.T) {
+	data := make([]byte, matchDataCompressionThreshold*4)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatalf("error generating match data: %v", err.Error())
+	}
+
+	result, err := compressMatchData(data)
+	if err != nil {
+		t.Fatalf("error compressing match data: %v", err.Error())
+	}
+
+	assert.Equal(t, MatchDataCompressionNone, result[0], "payload that doesn't shrink should not be flagged as compressed")
+	assert.Equal(t, data, result[1:])
+}
+
+func TestRuntimeLuaMatchCoreAllowedOpCodes(t *testing.T) {
+	scope := tally.NewTestScope("", nil)
+	matchRegistry, _, _ := newTestMatchRegistry(nil)
+	createFn := newTestLuaMatchCreateFn(cfg, matchRegistry, &Metrics{prometheusScope: scope}, &testMessageRouter{}, `
+function M.match_init(context, params)
+	return {received = {}}, 10, ""
+end
+function M.match_loop(context, dispatcher, tick, state, messages)
+	state.received = {}
+	for _, message in ipairs(messages) do
+		table.insert(state.received, message.op_code)
+	end
+	if tick == 1 then
+		dispatcher.match_allowed_op_codes({1, 2})
+	elseif tick == 3 then
+		dispatcher.match_allowed_op_codes()
+	end
+	return state
+end
+`)
+	core, err := createFn(context.Background(), logger, uuid.Must(uuid.NewV4()), cfg.GetName(), atomic.NewBool(false), "match")
+	if err != nil {
+		t.Fatalf("error creating match core: %v", err)
+	}
+	state, _, err := core.MatchInit(NewMatchPresenceList(), nil, nil)
+	if err != nil {
+		t.Fatalf("error initialising match: %v", err)
+	}
+
+	tick := int64(0)
+	loopOpCodes := func(opCodes ...int64) []int64 {
+		tick++
+		inputCh := make(chan *MatchDataMessage, len(opCodes))
+		for _, opCode := range opCodes {
+			inputCh <- &MatchDataMessage{UserID: uuid.Must(uuid.NewV4()), SessionID: uuid.Must(uuid.NewV4()), OpCode: opCode}
+		}
+		if state, err = core.MatchLoop(tick, state, inputCh, 0); err != nil {
+			t.Fatalf("error running match loop: %v", err)
+		}
+		received := make([]int64, 0, len(opCodes))
+		state.(*lua.LTable).RawGetString("received").(*lua.LTable).ForEach(func(_, v lua.LValue) {
+			received = append(received, int64(v.(lua.LNumber)))
+		})
+		return received
+	}
+
+	// All op codes are accepted until the match configures an allowed set.
+	assert.Equal(t, []int64{1, 2, 3}, loopOpCodes(1, 2, 3))
+	assert.Equal(t, []int64{1, 2, 1}, loopOpCodes(1, 2, 3, 1, 4))
+	assert.EqualValues(t, 2, countMatchOpCodeDropped(scope))
+
+	// The filter is removed during this loop, so it still applies to this loop's input but not the next.
+	assert.Equal(t, []int64{2}, loopOpCodes(2, 4))
+	assert.Equal(t, []int64{3, 4}, loopOpCodes(3, 4))
+	assert.EqualValues(t, 3, countMatchOpCodeDropped(scope))
+}
+
+func TestRuntimeLuaMatchCoreGetState(t *testing.T) {
+	const initCallback = `
+function M.match_init(context, params)
+	return {moves = 3}, 10, ""
+end
+`
+	matchRegistry, _, _ := newTestMatchRegistry(nil)
+
+	// The match signal handler returns the snapshot when asked for it.
+	createFn := newTestLuaMatchCreateFn(cfg, matchRegistry, metrics, &testMessageRouter{}, initCallback+`
+function M.match_signal(context, dispatcher, tick, state, data)
+	if data == "`+MatchGetStateSignal+`" then
+		return state, "moves:" .. state.moves
+	end
+	return state
+end
+`)
+	id, err := matchRegistry.CreateMatch(context.Background(), logger, createFn, "match", nil)
+	if err != nil {
+		t.Fatalf("error creating match: %v", err)
+	}
+	defer matchRegistry.TerminateMatch(context.Background(), id, 0)
+
+	snapshot, ok, err := matchRegistry.GetMatchState(context.Background(), id)
+	if err != nil {
+		t.Fatalf("error getting match state: %v", err)
+	}
+	assert.True(t, ok, "expected match to provide a snapshot")
+	assert.Equal(t, "moves:3", snapshot)
+
+	// Matches without a signal handler provide no snapshot.
+	createFn = newTestLuaMatchCreateFn(cfg, matchRegistry, metrics, &testMessageRouter{}, initCallback)
+	id, err = matchRegistry.CreateMatch(context.Background(), logger, createFn, "match", nil)
+	if err != nil {
+		t.Fatalf("error creating match: %v", err)
+	}
+	defer matchRegistry.TerminateMatch(context.Background(), id, 0)
+
+	snapshot, ok, err = matchRegistry.GetMatchState(context.Background(), id)
+	if err != nil {
+		t.Fatalf("error getting match state: %v", err)
+	}
+	assert.False(t, ok, "expected match to provide no snapshot")
+	assert.Empty(t, snapshot)
+
+	// A failing signal handler provides no snapshot, but leaves the match running.
+	createFn = newTestLuaMatchCreateFn(cfg, matchRegistry, metrics, &testMessageRouter{}, initCallback+`
+function M.match_signal(context, dispatcher, tick, state, data)
+	error("no snapshot")
+end
+`)
+	id, err = matchRegistry.CreateMatch(context.Background(), logger, createFn, "match", nil)
+	if err != nil {
+		t.Fatalf("error creating match: %v", err)
+	}
+	defer matchRegistry.TerminateMatch(context.Background(), id, 0)
+
+	snapshot, ok, err = matchRegistry.GetMatchState(context.Background(), id)
+	if err != nil {
+		t.Fatalf("error getting match state: %v", err)
+	}
+	assert.False(t, ok, "expected match to provide no snapshot")
+	assert.Empty(t, snapshot)
+
+	match, err := matchRegistry.GetMatch(context.Background(), id)
+	if err != nil {
+		t.Fatalf("error getting match: %v", err)
+	}
+	assert.NotNil(t, match, "expected match to keep running")
+}
+
+func TestRuntimeLuaMatchCoreReceipts(t *testing.T) {
+	receiptCfg, err := cfg.Clone()
+	if err != nil {
+		t.Fatalf("error cloning config: %v", err)
+	}
+	receiptCfg.GetMatch().ReceiptOpCode = 99
+	receiptCfg.GetMatch().ReceiptTimeoutMs = 200
+
+	matchRegistry, _, _ := newTestMatchRegistry(nil)
+	createFn := newTestLuaMatchCreateFn(receiptCfg, matchRegistry, metrics, &testMessageRouter{}, `
+function M.match_loop(context, dispatcher, tick, state, messages)
+	if tick == 1 then
+		dispatcher.broadcast_message(1, "r1", nil, nil, true, false, "r1")
+	end
+	state.acks = {}
+	for _, message in ipairs(messages) do
+		if message.op_code == 99 then
+			table.insert(state.acks, message.sender.username .. ":" .. message.data)
+		end
+	end
+	state.expired = dispatcher.match_expired_receipts()
+	return state
+end
+`)
+	core, err := createFn(context.Background(), logger, uuid.Must(uuid.NewV4()), cfg.GetName(), atomic.NewBool(false), "match")
+	if err != nil {
+		t.Fatalf("error creating match core: %v", err)
+	}
+	presenceList := NewMatchPresenceList()
+	state, _, err := core.MatchInit(presenceList, nil, nil)
+	if err != nil {
+		t.Fatalf("error initialising match: %v", err)
+	}
+	alice := &MatchPresence{Node: cfg.GetName(), UserID: uuid.Must(uuid.NewV4()), SessionID: uuid.Must(uuid.NewV4()), Username: "alice"}
+	bob := &MatchPresence{Node: cfg.GetName(), UserID: uuid.Must(uuid.NewV4()), SessionID: uuid.Must(uuid.NewV4()), Username: "bob"}
+	presenceList.Join([]*MatchPresence{alice, bob})
+
+	tick := int64(0)
+	strings := func(key string) []string {
+		values := make([]string, 0)
+		state.(*lua.LTable).RawGetString(key).(*lua.LTable).ForEach(func(_, v lua.LValue) {
+			values = append(values, v.String())
+		})
+		return values
+	}
+	loop := func(messages ...*MatchDataMessage) {
+		tick++
+		inputCh := make(chan *MatchDataMessage, len(messages))
+		for _, message := range messages {
+			inputCh <- message
+		}
+		if state, err = core.MatchLoop(tick, state, inputCh, 0); err != nil {
+			t.Fatalf("error running match loop: %v", err)
+		}
+	}
+	ack := func(presence *MatchPresence, receiptID string) *MatchDataMessage {
+		return &MatchDataMessage{UserID: presence.UserID, SessionID: presence.SessionID, Username: presence.Username, OpCode: 99, Data: []byte(receiptID)}
+	}
+
+	// The match requests a receipt on its first loop, and acks reach the match loop.
+	loop()
+	loop(ack(alice, "r1"), ack(alice, "unknown"))
+	assert.Equal(t, []string{"alice:r1"}, strings("acks"))
+	assert.Empty(t, strings("expired"))
+
+	// Without an ack from every recipient the receipt expires and is reported once.
+	time.Sleep(250 * time.Millisecond)
+	loop(ack(bob, "r1"))
+	assert.Empty(t, strings("acks"))
+	assert.Equal(t, []string{"r1"}, strings("expired"))
+	loop()
+	assert.Empty(t, strings("expired"))
+}
+
+func TestRuntimeLuaMatchCoreMaxSize(t *testing.T) {
+	matchRegistry, _, _ := newTestMatchRegistry(nil)
+	createFn := newTestLuaMatchCreateFn(cfg, matchRegistry, metrics, &testMessageRouter{}, `
+function M.match_join_attempt(context, dispatcher, tick, state, presence, metadata)
+	return state, dispatcher.match_has_space(2, presence.session_id)
+end
+function M.match_join(context, dispatcher, tick, state, presences)
+	state.reserved = presences[1].reserved
+	return state
+end
+function M.match_loop(context, dispatcher, tick, state, messages)
+	dispatcher.match_max_size(2)
+	return state
+end
+`)
+	core, err := createFn(context.Background(), logger, uuid.Must(uuid.NewV4()), cfg.GetName(), atomic.NewBool(false), "match")
+	if err != nil {
+		t.Fatalf("error creating match core: %v", err)
+	}
+	presenceList := NewMatchPresenceList()
+	state, _, err := core.MatchInit(presenceList, nil, nil)
+	if err != nil {
+		t.Fatalf("error initialising match: %v", err)
+	}
+
+	// The max size is kept as match state rather than read from the label.
+	if state, err = core.MatchLoop(1, state, make(chan *MatchDataMessage), 0); err != nil {
+		t.Fatalf("error running match loop: %v", err)
+	}
+	assert.Equal(t, 2, presenceList.MaxSize())
+
+	// With both places reserved only the reserved sessions have space.
+	reserved := uuid.Must(uuid.NewV4())
+	presenceList.Reserve([]uuid.UUID{reserved, uuid.Must(uuid.NewV4())}, time.Now().Add(time.Minute))
+	joinAttempt := func(sessionID uuid.UUID) bool {
+		var allow bool
+		state, allow, _, err = core.MatchJoinAttempt(2, state, uuid.Must(uuid.NewV4()), sessionID, "user", 0, nil, "", "", cfg.GetName(), nil, sessionID == reserved)
+		if err != nil {
+			t.Fatalf("error running match join attempt: %v", err)
+		}
+		return allow
+	}
+	assert.True(t, joinAttempt(reserved))
+	assert.False(t, joinAttempt(uuid.Must(uuid.NewV4())))
+
+	// Join presences carry the reserved flag.
+	if state, err = core.MatchJoin(2, state, []*MatchPresence{{Node: cfg.GetName(), UserID: uuid.Must(uuid.NewV4()), SessionID: reserved, Username: "user", Reserved: true}}); err != nil {
+		t.Fatalf("error running match join: %v", err)
+	}
+	assert.Equal(t, lua.LTrue, state.(*lua.LTable).RawGetString("reserved"))
+}
+
+func TestRuntimeLuaMatchCoreLeaveReason(t *testing.T) {
+	matchRegistry, _, _ := newTestMatchRegistry(nil)
+	createFn := newTestLuaMatchCreateFn(cfg, matchRegistry, metrics, &testMessageRouter{}, `
+function M.match_leave(context, dispatcher, tick, state, presences)
+	state.reasons = {}
+	for _, presence in ipairs(presences) do
+		state.reasons[presence.username] = presence.reason
+	end
+	return state
+end
+`)
+	core, err := createFn(context.Background(), logger, uuid.Must(uuid.NewV4()), cfg.GetName(), atomic.NewBool(false), "match")
+	if err != nil {
+		t.Fatalf("error creating match core: %v", err)
+	}
+	state, _, err := core.MatchInit(NewMatchPresenceList(), nil, nil)
+	if err != nil {
+		t.Fatalf("error initialising match: %v", err)
+	}
+
+	// Each leave presence tells the match why it left.
+	leaves := []*MatchPresence{
+		{Node: cfg.GetName(), UserID: uuid.Must(uuid.NewV4()), SessionID: uuid.Must(uuid.NewV4()), Username: "alice", Reason: PresenceReasonLeave},
+		{Node: cfg.GetName(), UserID: uuid.Must(uuid.NewV4()), SessionID: uuid.Must(uuid.NewV4()), Username: "bob", Reason: PresenceReasonDisconnect},
+		{Node: cfg.GetName(), UserID: uuid.Must(uuid.NewV4()), SessionID: uuid.Must(uuid.NewV4()), Username: "carol", Reason: PresenceReasonKick},
+	}
+	if state, err = core.MatchLeave(1, state, leaves); err != nil {
+		t.Fatalf("error running match leave: %v", err)
+	}
+	reasons := state.(*lua.LTable).RawGetString("reasons").(*lua.LTable)
+	assert.Equal(t, lua.LNumber(PresenceReasonLeave), reasons.RawGetString("alice"))
+	assert.Equal(t, lua.LNumber(PresenceReasonDisconnect), reasons.RawGetString("bob"))
+	assert.Equal(t, lua.LNumber(PresenceReasonKick), reasons.RawGetString("carol"))
+}
+
+func TestRuntimeLuaMatchCoreJoinMetadata(t *testing.T) {
+	matchRegistry, _, _ := newTestMatchRegistry(nil)
+	createFn := newTestLuaMatchCreateFn(cfg, matchRegistry, metrics, &testMessageRouter{}, `
+function M.match_join(context, dispatcher, tick, state, presences)
+	state.teams = {}
+	for _, presence in ipairs(presences) do
+		state.teams[presence.username] = presence.metadata and presence.metadata.team or "none"
+	end
+	return state
+end
+`)
+	core, err := createFn(context.Background(), logger, uuid.Must(uuid.NewV4()), cfg.GetName(), atomic.NewBool(false), "match")
+	if err != nil {
+		t.Fatalf("error creating match core: %v", err)
+	}
+	state, _, err := core.MatchInit(NewMatchPresenceList(), nil, nil)
+	if err != nil {
+		t.Fatalf("error initialising match: %v", err)
+	}
+
+	// Join attempt metadata reaches the match join presences, presences without it have no metadata field.
+	joins := []*MatchPresence{
+		{Node: cfg.GetName(), UserID: uuid.Must(uuid.NewV4()), SessionID: uuid.Must(uuid.NewV4()), Username: "alice", Metadata: map[string]string{"team": "red"}},
+		{Node: cfg.GetName(), UserID: uuid.Must(uuid.NewV4()), SessionID: uuid.Must(uuid.NewV4()), Username: "bob"},
+	}
+	if state, err = core.MatchJoin(1, state, joins); err != nil {
+		t.Fatalf("error running match join: %v", err)
+	}
+	teams := state.(*lua.LTable).RawGetString("teams").(*lua.LTable)
+	assert.Equal(t, lua.LString("red"), teams.RawGetString("alice"))
+	assert.Equal(t, lua.LString("none"), teams.RawGetString("bob"))
+}
+
+func TestRuntimeLuaMatchCoreParamsSchema(t *testing.T) {
+	const initCallback = `
+function M.match_init(context, params)
+	return {rounds = params.rounds}, 10, ""
+end
+`
+	matchRegistry, _, _ := newTestMatchRegistry(nil)
+	newCore := func(schema string) (RuntimeMatchCore, error) {
+		createFn := newTestLuaMatchCreateFn(cfg, matchRegistry, metrics, &testMessageRouter{}, initCallback+"M.match_params_schema = "+schema+"\n")
+		return createFn(context.Background(), logger, uuid.Must(uuid.NewV4()), cfg.GetName(), atomic.NewBool(false), "match")
+	}
+
+	// Malformed schemas are rejected when the match is created.
+	_, err := newCore(`{rounds = "integer"}`)
+	assert.Error(t, err)
+	_, err = newCore(`"rounds"`)
+	assert.Error(t, err)
+
+	core, err := newCore(`{rounds = "number", mode = "string?"}`)
+	if err != nil {
+		t.Fatalf("error creating match core: %v", err)
+	}
+	state, _, err := core.MatchInit(NewMatchPresenceList(), nil, map[string]interface{}{"rounds": float64(3)})
+	if err != nil {
+		t.Fatalf("error initialising match: %v", err)
+	}
+	assert.Equal(t, lua.LNumber(3), state.(*lua.LTable).RawGetString("rounds"))
+
+	// Params that don't match the schema never reach match init.
+	for _, params := range []map[string]interface{}{
+		{},
+		{"rounds": "3"},
+		{"rounds": float64(3), "mode": true},
+		{"rounds": float64(3), "map": "desert"},
+	} {
+		_, _, err = core.MatchInit(NewMatchPresenceList(), nil, params)
+		assert.Error(t, err, "expected params to be rejected: %v", params)
+	}
+}
@@ -16,6 +16,7 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"crypto"
 	"crypto/aes"
 	"crypto/cipher"
@@ -34,7 +35,11 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
+	"net"
 	"net/http"
+	"net/http/cookiejar"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
@@ -47,6 +52,7 @@ import (
 	"github.com/golang/protobuf/ptypes/wrappers"
 	"github.com/heroiclabs/nakama-common/api"
 	"github.com/heroiclabs/nakama-common/rtapi"
+	"github.com/heroiclabs/nakama-common/runtime"
 	"github.com/heroiclabs/nakama/v2/internal/cronexpr"
 	"github.com/heroiclabs/nakama/v2/internal/gopher-lua"
 	"github.com/heroiclabs/nakama/v2/social"
@@ -54,6 +60,25 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+const (
+	runtimeLuaHTTPCookieJarsKey = "_HTTP_COOKIE_JARS"
+	// Current nesting depth of in-process RPC calls made on a Lua state.
+	runtimeLuaRPCDepthKey = "_RPC_DEPTH"
+	// Maximum nesting depth of in-process RPC calls, guards against unbounded recursion between RPCs.
+	runtimeLuaRPCMaxDepth = 8
+	// Match op code handlers are registered per Lua state, so each match VM has its own set.
+	runtimeLuaMatchOpCodeHandlersKey = "_MATCH_OP_CODE_HANDLERS"
+
+	// Default limit on the size of a response body downloaded into storage, in bytes.
+	runtimeLuaHTTPToStorageMaxSize = 1024 * 1024
+)
+
+// Named cookie jars, only valid for the duration of a single function invocation.
+type runtimeLuaHTTPCookieJars struct {
+	ctx  context.Context
+	jars map[string]http.CookieJar
+}
+
 type RuntimeLuaNakamaModule struct {
 	logger               *zap.Logger
 	db                   *sql.DB
@@ -65,8 +90,11 @@ type RuntimeLuaNakamaModule struct {
 	rankCache            LeaderboardRankCache
 	leaderboardScheduler LeaderboardScheduler
 	sessionRegistry      SessionRegistry
+	sessionCache         SessionCache
 	matchRegistry        MatchRegistry
+	matchmaker           Matchmaker
 	tracker              Tracker
+	metrics              *Metrics
 	streamManager        StreamManager
 	router               MessageRouter
 	once                 *sync.Once
@@ -75,12 +103,60 @@ type RuntimeLuaNakamaModule struct {
 	announceCallbackFn   func(RuntimeExecutionMode, string)
 	client               *http.Client
 
-	node          string
-	matchCreateFn RuntimeMatchCreateFunction
-	eventFn       RuntimeEventCustomFunction
+	node                  string
+	matchCreateFn         RuntimeMatchCreateFunction
+	eventFn               RuntimeEventCustomFunction
+	entitlementValidators *RuntimeEntitlementValidators
+
+	// RPC functions registered by modules loaded with this module instance, for in-process invocation.
+	rpcFunctions    map[string]*lua.LFunction
+	configFileCache *RuntimeConfigFileCache
+	featureFlags    map[string][]string
+	pushQueue       *PushQueue
+}
+
+// NewRuntimeHTTPClient creates the HTTP client runtime modules use for outgoing requests. A single client should be
+// shared by all runtime instances so connections to the same host are pooled and reused across requests, and so
+// repeated failures to a host trip the same circuit breaker. Every request is recorded in per-host metrics.
+func NewRuntimeHTTPClient(config *RuntimeConfig, metrics *Metrics) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: time.Duration(config.HTTPKeepAliveMs) * time.Millisecond,
+	}
+	var transport http.RoundTripper = &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          config.HTTPMaxIdleConns,
+		MaxIdleConnsPerHost:   config.HTTPMaxIdleConnsPerHost,
+		IdleConnTimeout:       time.Duration(config.HTTPIdleConnTimeoutMs) * time.Millisecond,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	if config.HTTPBreakerFailures > 0 {
+		transport = NewRuntimeHTTPBreaker(transport, metrics, config.HTTPBreakerFailures, time.Duration(config.HTTPBreakerOpenMs)*time.Millisecond)
+	}
+	// Outermost, so requests rejected by an open circuit breaker are recorded too.
+	transport = NewRuntimeHTTPMetrics(transport, metrics)
+	// The default policy is always valid.
+	checkRedirect, _ := RuntimeHTTPCheckRedirect(RuntimeHTTPRedirectFollow)
+	return &http.Client{
+		Timeout:       5 * time.Second,
+		Transport:     transport,
+		CheckRedirect: checkRedirect,
+	}
 }
 
-func NewRuntimeLuaNakamaModule(logger *zap.Logger, db *sql.DB, jsonpbMarshaler *jsonpb.Marshaler, jsonpbUnmarshaler *jsonpb.Unmarshaler, config Config, socialClient *social.Client, leaderboardCache LeaderboardCache, rankCache LeaderboardRankCache, leaderboardScheduler LeaderboardScheduler, sessionRegistry SessionRegistry, matchRegistry MatchRegistry, tracker Tracker, streamManager StreamManager, router MessageRouter, once *sync.Once, localCache *RuntimeLuaLocalCache, matchCreateFn RuntimeMatchCreateFunction, eventFn RuntimeEventCustomFunction, registerCallbackFn func(RuntimeExecutionMode, string, *lua.LFunction), announceCallbackFn func(RuntimeExecutionMode, string)) *RuntimeLuaNakamaModule {
+func NewRuntimeLuaNakamaModule(logger *zap.Logger, db *sql.DB, jsonpbMarshaler *jsonpb.Marshaler, jsonpbUnmarshaler *jsonpb.Unmarshaler, config Config, socialClient *social.Client, leaderboardCache LeaderboardCache, rankCache LeaderboardRankCache, leaderboardScheduler LeaderboardScheduler, sessionRegistry SessionRegistry, sessionCache SessionCache, matchRegistry MatchRegistry, matchmaker Matchmaker, tracker Tracker, metrics *Metrics, streamManager StreamManager, router MessageRouter, pushQueue *PushQueue, once *sync.Once, localCache *RuntimeLuaLocalCache, configFileCache *RuntimeConfigFileCache, httpClient *http.Client, entitlementValidators *RuntimeEntitlementValidators, matchCreateFn RuntimeMatchCreateFunction, eventFn RuntimeEventCustomFunction, registerCallbackFn func(RuntimeExecutionMode, string, *lua.LFunction), announceCallbackFn func(RuntimeExecutionMode, string)) *RuntimeLuaNakamaModule {
+	// Feature flags are validated on startup, so this can't fail here.
+	featureFlags, _ := ParseFeatureFlags(config.GetRuntime().FeatureFlags)
+	if httpClient == nil {
+		httpClient = NewRuntimeHTTPClient(config.GetRuntime(), metrics)
+	}
+	if configFileCache == nil {
+		configFileCache = NewRuntimeConfigFileCache(runtimeConfigFileCheckInterval)
+	}
+
 	return &RuntimeLuaNakamaModule{
 		logger:               logger,
 		db:                   db,
@@ -92,32 +168,41 @@ func NewRuntimeLuaNakamaModule(logger *zap.Logger, db *sql.DB, jsonpbMarshaler *
 		rankCache:            rankCache,
 		leaderboardScheduler: leaderboardScheduler,
 		sessionRegistry:      sessionRegistry,
+		sessionCache:         sessionCache,
 		matchRegistry:        matchRegistry,
+		matchmaker:           matchmaker,
 		tracker:              tracker,
+		metrics:              metrics,
 		streamManager:        streamManager,
 		router:               router,
 		once:                 once,
 		localCache:           localCache,
 		registerCallbackFn:   registerCallbackFn,
 		announceCallbackFn:   announceCallbackFn,
-		client: &http.Client{
-			Timeout: 5 * time.Second,
-		},
+		client:               httpClient,
+
+		node:                  config.GetName(),
+		matchCreateFn:         matchCreateFn,
+		eventFn:               eventFn,
+		entitlementValidators: entitlementValidators,
 
-		node:          config.GetName(),
-		matchCreateFn: matchCreateFn,
-		eventFn:       eventFn,
+		rpcFunctions:    make(map[string]*lua.LFunction),
+		configFileCache: configFileCache,
+		featureFlags:    featureFlags,
+		pushQueue:       pushQueue,
 	}
 }
 
 func (n *RuntimeLuaNakamaModule) Loader(l *lua.LState) int {
 	functions := map[string]lua.LGFunction{
 		"register_rpc":                       n.registerRPC,
+		"rpc":                                n.rpc,
 		"register_req_before":                n.registerReqBefore,
 		"register_req_after":                 n.registerReqAfter,
 		"register_rt_before":                 n.registerRTBefore,
 		"register_rt_after":                  n.registerRTAfter,
 		"register_matchmaker_matched":        n.registerMatchmakerMatched,
+		"register_matchmaker_override":       n.registerMatchmakerOverride,
 		"register_tournament_end":            n.registerTournamentEnd,
 		"register_tournament_reset":          n.registerTournamentReset,
 		"register_leaderboard_reset":         n.registerLeaderboardReset,
@@ -127,14 +212,22 @@ func (n *RuntimeLuaNakamaModule) Loader(l *lua.LState) int {
 		"localcache_get":                     n.localcacheGet,
 		"localcache_put":                     n.localcachePut,
 		"localcache_delete":                  n.localcacheDelete,
+		"rate_limit":                         n.rateLimit,
 		"time":                               n.time,
 		"cron_next":                          n.cronNext,
 		"sql_exec":                           n.sqlExec,
 		"sql_query":                          n.sqlQuery,
+		"sql_query_stream":                   n.sqlQueryStream,
+		"config_get":                         n.configGet,
+		"feature_flag":                       n.featureFlag,
+		"get_config":                         n.getConfig,
 		"uuid_v4":                            n.uuidV4,
 		"uuid_bytes_to_string":               n.uuidBytesToString,
 		"uuid_string_to_bytes":               n.uuidStringToBytes,
+		"random_string":                      n.randomString,
+		"invite_code":                        n.inviteCode,
 		"http_request":                       n.httpRequest,
+		"http_request_to_storage":            n.httpRequestToStorage,
 		"jwt_generate":                       n.jwtGenerate,
 		"json_encode":                        n.jsonEncode,
 		"json_decode":                        n.jsonDecode,
@@ -144,6 +237,10 @@ func (n *RuntimeLuaNakamaModule) Loader(l *lua.LState) int {
 		"base64url_decode":                   n.base64URLDecode,
 		"base16_encode":                      n.base16Encode,
 		"base16_decode":                      n.base16Decode,
+		"gzip_compress":                      n.gzipCompress,
+		"gzip_decompress":                    n.gzipDecompress,
+		"zlib_compress":                      n.zlibCompress,
+		"zlib_decompress":                    n.zlibDecompress,
 		"aes128_encrypt":                     n.aes128Encrypt,
 		"aes128_decrypt":                     n.aes128Decrypt,
 		"aes256_encrypt":                     n.aes256Encrypt,
@@ -164,19 +261,33 @@ func (n *RuntimeLuaNakamaModule) Loader(l *lua.LState) int {
 		"authenticate_google":                n.authenticateGoogle,
 		"authenticate_steam":                 n.authenticateSteam,
 		"authenticate_token_generate":        n.authenticateTokenGenerate,
+		"session_refresh_generate":           n.sessionRefreshGenerate,
+		"session_refresh":                    n.sessionRefresh,
 		"logger_debug":                       n.loggerDebug,
 		"logger_info":                        n.loggerInfo,
 		"logger_warn":                        n.loggerWarn,
 		"logger_error":                       n.loggerError,
 		"account_get_id":                     n.accountGetId,
+		"account_get_by_device":              n.accountGetByDevice,
+		"account_get_by_email":               n.accountGetByEmail,
+		"account_get_by_custom":              n.accountGetByCustom,
 		"accounts_get_id":                    n.accountsGetId,
 		"account_update_id":                  n.accountUpdateId,
 		"account_delete_id":                  n.accountDeleteId,
 		"account_export_id":                  n.accountExportId,
+		"account_export_to_storage":          n.accountExportToStorage,
 		"users_get_id":                       n.usersGetId,
 		"users_get_username":                 n.usersGetUsername,
+		"users_get_presence":                 n.usersGetPresence,
+		"users_count":                        n.usersCount,
+		"users_count_by_date":                n.usersCountByDate,
 		"users_ban_id":                       n.usersBanId,
 		"users_unban_id":                     n.usersUnbanId,
+		"username_validate":                  n.usernameValidate,
+		"username_normalize":                 n.usernameNormalize,
+		"email_validate":                     n.emailValidate,
+		"uuid_validate":                      n.uuidValidate,
+		"url_validate":                       n.urlValidate,
 		"link_apple":                         n.linkApple,
 		"link_custom":                        n.linkCustom,
 		"link_device":                        n.linkDevice,
@@ -205,42 +316,75 @@ func (n *RuntimeLuaNakamaModule) Loader(l *lua.LState) int {
 		"stream_close":                       n.streamClose,
 		"stream_send":                        n.streamSend,
 		"stream_send_raw":                    n.streamSendRaw,
+		"channel_message_update":             n.channelMessageUpdate,
+		"channel_message_remove":             n.channelMessageRemove,
 		"session_disconnect":                 n.sessionDisconnect,
+		"session_list":                       n.sessionList,
+		"session_vars_update":                n.sessionVarsUpdate,
+		"users_online_count":                 n.usersOnlineCount,
 		"match_create":                       n.matchCreate,
 		"match_get":                          n.matchGet,
 		"match_list":                         n.matchList,
+		"match_get_random":                   n.matchGetRandom,
+		"match_terminate":                    n.matchTerminate,
+		"match_signal_by_label":              n.matchSignalByLabel,
+		"match_get_state":                    n.matchGetState,
+		"match_op_code_handler":              n.matchOpCodeHandler,
+		"match_op_code_dispatch":             n.matchOpCodeDispatch,
+		"matchmaker_add_batch":               n.matchmakerAddBatch,
+		"matchmaker_stats":                   n.matchmakerStats,
+		"matchmaker_ticket_get":              n.matchmakerTicketGet,
+		"matchmaker_user_tickets":            n.matchmakerUserTickets,
 		"notification_send":                  n.notificationSend,
 		"notifications_send":                 n.notificationsSend,
 		"wallet_update":                      n.walletUpdate,
+		"wallet_metadata_get":                n.walletMetadataGet,
 		"wallets_update":                     n.walletsUpdate,
 		"wallet_ledger_update":               n.walletLedgerUpdate,
 		"wallet_ledger_list":                 n.walletLedgerList,
+		"wallet_ledger_totals":               n.walletLedgerTotals,
+		"users_get_by_wallet_balance":        n.usersGetByWalletBalance,
 		"storage_list":                       n.storageList,
 		"storage_read":                       n.storageRead,
 		"storage_write":                      n.storageWrite,
+		"storage_write_if":                   n.storageWriteIf,
+		"storage_patch":                      n.storagePatch,
 		"storage_delete":                     n.storageDelete,
+		"storage_size":                       n.storageSize,
+		"validate_entitlement":               n.validateEntitlement,
 		"multi_update":                       n.multiUpdate,
 		"leaderboard_create":                 n.leaderboardCreate,
 		"leaderboard_delete":                 n.leaderboardDelete,
+		"leaderboard_set_reset":              n.leaderboardSetReset,
 		"leaderboard_records_list":           n.leaderboardRecordsList,
+		"leaderboard_records_list_expired":   n.leaderboardRecordsListExpired,
+		"leaderboard_records_sample":         n.leaderboardRecordsSample,
+		"leaderboard_records_around_score":   n.leaderboardRecordsAroundScore,
 		"leaderboard_record_write":           n.leaderboardRecordWrite,
 		"leaderboard_record_delete":          n.leaderboardRecordDelete,
+		"leaderboard_records_delete":         n.leaderboardRecordsDelete,
 		"tournament_create":                  n.tournamentCreate,
 		"tournament_delete":                  n.tournamentDelete,
 		"tournament_add_attempt":             n.tournamentAddAttempt,
 		"tournament_join":                    n.tournamentJoin,
+		"tournament_leave":                   n.tournamentLeave,
 		"tournament_list":                    n.tournamentList,
 		"tournaments_get_id":                 n.tournamentsGetId,
 		"tournament_records_list":            n.tournamentRecordsList,
 		"tournament_record_write":            n.tournamentRecordWrite,
+		"tournament_record_delete":           n.tournamentRecordDelete,
 		"tournament_records_haystack":        n.tournamentRecordsHaystack,
 		"groups_get_id":                      n.groupsGetId,
+		"groups_get_random":                  n.groupsGetRandom,
 		"group_create":                       n.groupCreate,
 		"group_update":                       n.groupUpdate,
 		"group_delete":                       n.groupDelete,
 		"group_users_list":                   n.groupUsersList,
+		"group_count":                        n.groupCount,
 		"user_groups_list":                   n.userGroupsList,
 		"friends_list":                       n.friendsList,
+		"friends_count":                      n.friendsCount,
+		"friends_import_steam":               n.friendsImportSteam,
 	}
 	mod := l.SetFuncs(l.CreateTable(0, len(functions)), functions)
 
@@ -259,6 +403,7 @@ func (n *RuntimeLuaNakamaModule) registerRPC(l *lua.LState) int {
 
 	id = strings.ToLower(id)
 
+	n.rpcFunctions[id] = fn
 	if n.registerCallbackFn != nil {
 		n.registerCallbackFn(RuntimeExecutionModeRPC, id, fn)
 	}
@@ -268,6 +413,51 @@ func (n *RuntimeLuaNakamaModule) registerRPC(l *lua.LState) int {
 	return 0
 }
 
+func (n *RuntimeLuaNakamaModule) rpc(l *lua.LState) int {
+	ctx := l.CheckTable(1)
+	id := l.CheckString(2)
+	if id == "" {
+		l.ArgError(2, "expects rpc id")
+		return 0
+	}
+	payload := l.OptString(3, "")
+
+	fn, found := n.rpcFunctions[strings.ToLower(id)]
+	if !found {
+		l.ArgError(2, "rpc function not found")
+		return 0
+	}
+
+	// Track nesting depth in the registry of the Lua state running the current invocation.
+	registry := l.Get(lua.RegistryIndex).(*lua.LTable)
+	depth, _ := registry.RawGetString(runtimeLuaRPCDepthKey).(lua.LNumber)
+	if depth >= runtimeLuaRPCMaxDepth {
+		l.RaiseError(fmt.Sprintf("rpc call depth exceeds maximum of %v", runtimeLuaRPCMaxDepth))
+		return 0
+	}
+	registry.RawSetString(runtimeLuaRPCDepthKey, depth+1)
+
+	l.Push(fn)
+	l.Push(ctx)
+	l.Push(lua.LString(payload))
+	err := l.PCall(2, 1, nil)
+	registry.RawSetString(runtimeLuaRPCDepthKey, depth)
+	if err != nil {
+		l.RaiseError(fmt.Sprintf("error in rpc function %v: %v", id, err.Error()))
+		return 0
+	}
+
+	result := l.Get(-1)
+	l.Pop(1)
+	if result.Type() != lua.LTString && result != lua.LNil {
+		l.RaiseError(fmt.Sprintf("rpc function %v returned invalid result, expects string or nil", id))
+		return 0
+	}
+
+	l.Push(result)
+	return 1
+}
+
 func (n *RuntimeLuaNakamaModule) registerReqBefore(l *lua.LState) int {
 	fn := l.CheckFunction(1)
 	id := l.CheckString(2)
@@ -360,6 +550,18 @@ func (n *RuntimeLuaNakamaModule) registerMatchmakerMatched(l *lua.LState) int {
 	return 0
 }
 
+func (n *RuntimeLuaNakamaModule) registerMatchmakerOverride(l *lua.LState) int {
+	fn := l.CheckFunction(1)
+
+	if n.registerCallbackFn != nil {
+		n.registerCallbackFn(RuntimeExecutionModeMatchmakerOverride, "", fn)
+	}
+	if n.announceCallbackFn != nil {
+		n.announceCallbackFn(RuntimeExecutionModeMatchmakerOverride, "")
+	}
+	return 0
+}
+
 func (n *RuntimeLuaNakamaModule) registerTournamentEnd(l *lua.LState) int {
 	fn := l.CheckFunction(1)
 
@@ -540,6 +742,32 @@ func (n *RuntimeLuaNakamaModule) localcacheDelete(l *lua.LState) int {
 	return 0
 }
 
+func (n *RuntimeLuaNakamaModule) rateLimit(l *lua.LState) int {
+	key := l.CheckString(1)
+	if key == "" {
+		l.ArgError(1, "expects key string")
+		return 0
+	}
+
+	limit := l.CheckInt(2)
+	if limit < 1 {
+		l.ArgError(2, "expects limit to be >= 1")
+		return 0
+	}
+
+	windowSeconds := l.CheckNumber(3)
+	if windowSeconds <= 0 {
+		l.ArgError(3, "expects window seconds to be > 0")
+		return 0
+	}
+
+	allowed, remaining := n.localCache.RateLimit(key, limit, time.Duration(float64(windowSeconds)*float64(time.Second)))
+
+	l.Push(lua.LBool(allowed))
+	l.Push(lua.LNumber(remaining))
+	return 2
+}
+
 func (n *RuntimeLuaNakamaModule) time(l *lua.LState) int {
 	if l.GetTop() == 0 {
 		l.Push(lua.LNumber(time.Now().UTC().UnixNano() / int64(time.Millisecond)))
@@ -587,6 +815,10 @@ func (n *RuntimeLuaNakamaModule) cronNext(l *lua.LState) int {
 	return 1
 }
 
+func (n *RuntimeLuaNakamaModule) slowQueryThreshold() time.Duration {
+	return time.Duration(n.config.GetRuntime().SlowQueryMs) * time.Millisecond
+}
+
 func (n *RuntimeLuaNakamaModule) sqlExec(l *lua.LState) int {
 	query := l.CheckString(1)
 	if query == "" {
@@ -606,7 +838,7 @@ func (n *RuntimeLuaNakamaModule) sqlExec(l *lua.LState) int {
 
 	var result sql.Result
 	var err error
-	err = ExecuteRetryable(func() error {
+	err = ExecuteRetryableReportSlow(n.logger, n.metrics, n.slowQueryThreshold(), query, len(params), func() error {
 		result, err = n.db.ExecContext(l.Context(), query, params...)
 		return err
 	})
@@ -643,7 +875,7 @@ func (n *RuntimeLuaNakamaModule) sqlQuery(l *lua.LState) int {
 
 	var rows *sql.Rows
 	var err error
-	err = ExecuteRetryable(func() error {
+	err = ExecuteRetryableReportSlow(n.logger, n.metrics, n.slowQueryThreshold(), query, len(params), func() error {
 		rows, err = n.db.QueryContext(l.Context(), query, params...)
 		return err
 	})
@@ -689,11 +921,182 @@ func (n *RuntimeLuaNakamaModule) sqlQuery(l *lua.LState) int {
 	return 1
 }
 
+func (n *RuntimeLuaNakamaModule) sqlQueryStream(l *lua.LState) int {
+	query := l.CheckString(1)
+	if query == "" {
+		l.ArgError(1, "expects query string")
+		return 0
+	}
+	paramsTable := l.OptTable(2, nil)
+	var params []interface{}
+	if paramsTable != nil && paramsTable.Len() != 0 {
+		var ok bool
+		params, ok = RuntimeLuaConvertLuaValue(paramsTable).([]interface{})
+		if !ok {
+			l.ArgError(2, "expects a list of params as a table")
+			return 0
+		}
+	}
+	rowFn := l.CheckFunction(3)
+
+	var rows *sql.Rows
+	var err error
+	err = ExecuteRetryableReportSlow(n.logger, n.metrics, n.slowQueryThreshold(), query, len(params), func() error {
+		rows, err = n.db.QueryContext(l.Context(), query, params...)
+		return err
+	})
+	if err != nil {
+		l.RaiseError("sql query error: %v", err.Error())
+		return 0
+	}
+	defer rows.Close()
+
+	resultColumns, err := rows.Columns()
+	if err != nil {
+		l.RaiseError("sql query column lookup error: %v", err.Error())
+		return 0
+	}
+	resultColumnCount := len(resultColumns)
+
+	// Each row is handed to the callback as soon as it's scanned, so the full result set is never held in memory.
+	var count int
+	resultRowValues := make([]interface{}, resultColumnCount)
+	resultRowPointers := make([]interface{}, resultColumnCount)
+	for i := range resultRowValues {
+		resultRowPointers[i] = &resultRowValues[i]
+	}
+	for rows.Next() {
+		if err = l.Context().Err(); err != nil {
+			l.RaiseError("sql query stream cancelled: %v", err.Error())
+			return 0
+		}
+
+		if err = rows.Scan(resultRowPointers...); err != nil {
+			l.RaiseError("sql query scan error: %v", err.Error())
+			return 0
+		}
+		rowTable := l.CreateTable(0, resultColumnCount)
+		for j, col := range resultColumns {
+			rowTable.RawSetString(col, RuntimeLuaConvertValue(l, resultRowValues[j]))
+		}
+		count++
+
+		l.Push(rowFn)
+		l.Push(rowTable)
+		l.Call(1, 1)
+		ret := l.Get(-1)
+		l.Pop(1)
+		if ret == lua.LFalse {
+			// The callback asked to stop early.
+			break
+		}
+	}
+	if err = rows.Err(); err != nil {
+		l.RaiseError("sql query row scan error: %v", err.Error())
+		return 0
+	}
+
+	l.Push(lua.LNumber(count))
+	return 1
+}
+
+func (n *RuntimeLuaNakamaModule) configGet(l *lua.LState) int {
+	path := l.CheckString(1)
+	if path == "" {
+		l.ArgError(1, "expects config file path")
+		return 0
+	}
+
+	// Config files are resolved relative to the runtime path, and may not reference files outside it.
+	fullPath := filepath.Join(n.config.GetRuntime().Path, filepath.Clean(string(filepath.Separator)+path))
+
+	data, err := n.configFileCache.Get(fullPath)
+	if err != nil {
+		l.RaiseError(fmt.Sprintf("failed to read config file: %s", err.Error()))
+		return 0
+	}
+
+	l.Push(RuntimeLuaConvertValue(l, data))
+	return 1
+}
+
+func (n *RuntimeLuaNakamaModule) featureFlag(l *lua.LState) int {
+	userID := l.CheckString(1)
+	if userID == "" {
+		l.ArgError(1, "expects user ID string")
+		return 0
+	}
+	flag := l.CheckString(2)
+	if flag == "" {
+		l.ArgError(2, "expects feature flag name string")
+		return 0
+	}
+
+	variants, found := n.featureFlags[flag]
+	if !found {
+		l.Push(lua.LNil)
+		return 1
+	}
+
+	l.Push(lua.LString(FeatureFlagVariant(userID, flag, variants)))
+	return 1
+}
+
+func (n *RuntimeLuaNakamaModule) getConfig(l *lua.LState) int {
+	key := l.CheckString(1)
+	if key == "" {
+		l.ArgError(1, "expects config key string")
+		return 0
+	}
+
+	value, err := GetSafeConfigValue(n.config, key)
+	if err != nil {
+		l.RaiseError(fmt.Sprintf("failed to get config value: %s", err.Error()))
+		return 0
+	}
+
+	l.Push(RuntimeLuaConvertValue(l, value))
+	return 1
+}
+
 func (n *RuntimeLuaNakamaModule) uuidV4(l *lua.LState) int {
 	l.Push(lua.LString(uuid.Must(uuid.NewV4()).String()))
 	return 1
 }
 
+func (n *RuntimeLuaNakamaModule) randomString(l *lua.LState) int {
+	length := l.CheckInt(1)
+	if length < 1 || length > RandomStringMaxLength {
+		l.ArgError(1, fmt.Sprintf("expects length to be between 1 and %v", RandomStringMaxLength))
+		return 0
+	}
+	alphabet := l.OptString(2, RandomStringDefaultAlphabet)
+
+	s, err := RandomString(length, alphabet)
+	if err != nil {
+		if err == ErrRandomStringAlphabet {
+			l.ArgError(2, "expects alphabet to have between 2 and 256 distinct characters")
+			return 0
+		}
+		l.RaiseError("failed to generate random string: %v", err.Error())
+		return 0
+	}
+
+	l.Push(lua.LString(s))
+	return 1
+}
+
+func (n *RuntimeLuaNakamaModule) inviteCode(l *lua.LState) int {
+	code, err := InviteCode()
+	if err != nil {
+		l.RaiseError("failed to generate invite code: %v", err.Error())
+		return 0
+	}
+
+	l.Push(lua.LString(code))
+	return 1
+}
+
 func (n *RuntimeLuaNakamaModule) uuidBytesToString(l *lua.LState) int {
 	uuidBytes := l.CheckString(1)
 	if uuidBytes == "" {
@@ -740,15 +1143,24 @@ func (n *RuntimeLuaNakamaModule) httpRequest(l *lua.LState) int {
 
 	// Set a custom timeout if one is provided, or use the default.
 	timeoutMs := l.OptInt64(5, 5000)
-	n.client.Timeout = time.Duration(timeoutMs) * time.Millisecond
+
+	// Use a named cookie jar if one is provided, by default cookies are not retained between requests.
+	cookieJarName := l.OptString(6, "")
+
+	// Redirects are followed by default, without credential headers once they leave the original host.
+	checkRedirect, err := RuntimeHTTPCheckRedirect(l.OptString(7, RuntimeHTTPRedirectFollow))
+	if err != nil {
+		l.ArgError(7, err.Error())
+		return 0
+	}
 
 	// Prepare request body, if any.
 	var requestBody io.Reader
 	if body != "" {
 		requestBody = strings.NewReader(body)
 	}
-	// Prepare the request.
-	req, err := http.NewRequest(method, url, requestBody)
+	// Prepare the request, bound to the execution context so it's aborted mid-flight if the caller is cancelled.
+	req, err := http.NewRequestWithContext(runtimeLuaContext(l), method, url, requestBody)
 	if err != nil {
 		l.RaiseError("HTTP request error: %v", err.Error())
 		return 0
@@ -763,8 +1175,15 @@ func (n *RuntimeLuaNakamaModule) httpRequest(l *lua.LState) int {
 		}
 		req.Header.Add(k, vs)
 	}
-	// Execute the request.
-	resp, err := n.client.Do(req)
+	// Execute the request. Shallow copy the client so the timeout and cookie jar only apply to this request, while the
+	// shared transport still pools connections across requests.
+	client := *n.client
+	client.Timeout = time.Duration(timeoutMs) * time.Millisecond
+	client.CheckRedirect = checkRedirect
+	if cookieJarName != "" {
+		client.Jar = n.httpCookieJar(l, cookieJarName)
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		l.RaiseError("HTTP request error: %v", err.Error())
 		return 0
@@ -786,89 +1205,254 @@ func (n *RuntimeLuaNakamaModule) httpRequest(l *lua.LState) int {
 		}
 	}
 
+	responseHeadersTable := RuntimeLuaConvertMap(l, responseHeaders)
+	// Header names are stored in canonical form, but lookups in any case should find them.
+	responseHeadersMetatable := l.CreateTable(0, 1)
+	responseHeadersMetatable.RawSetString("__index", l.NewFunction(func(l *lua.LState) int {
+		key := l.CheckString(2)
+		l.Push(l.CheckTable(1).RawGetString(http.CanonicalHeaderKey(key)))
+		return 1
+	}))
+	responseHeadersTable.Metatable = responseHeadersMetatable
+
 	l.Push(lua.LNumber(resp.StatusCode))
-	l.Push(RuntimeLuaConvertMap(l, responseHeaders))
+	l.Push(responseHeadersTable)
 	l.Push(lua.LString(string(responseBody)))
 	return 3
 }
 
-func (n *RuntimeLuaNakamaModule) jwtGenerate(l *lua.LState) int {
-	algoType := l.CheckString(1)
-	if algoType == "" {
-		l.ArgError(1, "expects string")
-		return 0
+// runtimeLuaContext returns the context of the current execution, VMs outside of any execution have none set.
+func runtimeLuaContext(l *lua.LState) context.Context {
+	if ctx := l.Context(); ctx != nil {
+		return ctx
 	}
+	return context.Background()
+}
 
-	var signingMethod jwt.SigningMethod
-	switch algoType {
-	case "HS256":
-		signingMethod = jwt.SigningMethodHS256
-	case "RS256":
-		signingMethod = jwt.SigningMethodRS256
-	default:
-		l.ArgError(1, "unsupported algo type - only allowed 'HS256', 'RS256'.")
+func (n *RuntimeLuaNakamaModule) httpRequestToStorage(l *lua.LState) int {
+	url := l.CheckString(1)
+	if url == "" {
+		l.ArgError(1, "expects URL string")
 		return 0
 	}
-
-	signingKey := l.CheckString(2)
-	if signingKey == "" {
-		l.ArgError(2, "expects string")
+	collection := l.CheckString(2)
+	if collection == "" {
+		l.ArgError(2, "expects collection string")
 		return 0
 	}
-
-	claimsetTable := l.CheckTable(3)
-	if claimsetTable == nil {
-		l.ArgError(3, "expects nil")
+	key := l.CheckString(3)
+	if key == "" {
+		l.ArgError(3, "expects key string")
 		return 0
 	}
-
-	claimset := RuntimeLuaConvertLuaValue(claimsetTable).(map[string]interface{})
-	jwtClaims := jwt.MapClaims{}
-	for k, v := range claimset {
-		jwtClaims[k] = v
-	}
-
-	var pk interface{}
-	switch signingMethod {
-	case jwt.SigningMethodRS256:
-		block, _ := pem.Decode([]byte(signingKey))
-		if block == nil {
-			l.RaiseError("could not parse private key: no valid blocks found")
-			return 0
-		}
-
-		var err error
-		pk, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	userID := uuid.Nil
+	if u := l.OptString(4, ""); u != "" {
+		uid, err := uuid.FromString(u)
 		if err != nil {
-			l.RaiseError("could not parse private key: %v", err.Error())
+			l.ArgError(4, "expects user_id to be a valid UUID")
 			return 0
 		}
-	case jwt.SigningMethodHS256:
-		pk = []byte(signingKey)
+		userID = uid
 	}
-
-	token := jwt.NewWithClaims(signingMethod, jwtClaims)
-	signedToken, err := token.SignedString(pk)
-	if err != nil {
-		l.RaiseError("failed to sign token: %v", err.Error())
+	headers := l.OptTable(5, nil)
+	timeoutMs := l.OptInt64(6, 5000)
+	maxSize := l.OptInt64(7, runtimeLuaHTTPToStorageMaxSize)
+	if maxSize <= 0 {
+		l.ArgError(7, "expects max size to be > 0")
 		return 0
 	}
-
-	l.Push(lua.LString(signedToken))
-	return 1
-}
-
-func (n *RuntimeLuaNakamaModule) jsonEncode(l *lua.LState) int {
-	value := l.Get(1)
-	if value == nil {
-		l.ArgError(1, "expects a non-nil value to encode")
-		return 0
+	// The body is stored as-is, so it can never be larger than the configured storage object size limit.
+	if storageMax := int64(n.config.GetRuntime().StorageMaxObjectBytes); storageMax > 0 && storageMax < maxSize {
+		maxSize = storageMax
 	}
 
-	jsonData := RuntimeLuaConvertLuaValue(value)
-	jsonBytes, err := json.Marshal(jsonData)
+	// Prepare the request, bound to the execution context so it's aborted mid-flight if the caller is cancelled.
+	req, err := http.NewRequestWithContext(runtimeLuaContext(l), "GET", url, nil)
 	if err != nil {
-		l.RaiseError("error encoding to JSON: %v", err.Error())
+		l.RaiseError("HTTP request error: %v", err.Error())
+		return 0
+	}
+	// Apply any request headers.
+	if headers != nil {
+		httpHeaders := RuntimeLuaConvertLuaTable(headers)
+		for k, v := range httpHeaders {
+			vs, ok := v.(string)
+			if !ok {
+				l.RaiseError("HTTP header values must be strings")
+				return 0
+			}
+			req.Header.Add(k, vs)
+		}
+	}
+	// Execute the request.
+	client := *n.client
+	client.Timeout = time.Duration(timeoutMs) * time.Millisecond
+	resp, err := client.Do(req)
+	if err != nil {
+		l.RaiseError("HTTP request error: %v", err.Error())
+		return 0
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		l.RaiseError("HTTP request error: unexpected status code %v", resp.StatusCode)
+		return 0
+	}
+	if resp.ContentLength > maxSize {
+		l.RaiseError("HTTP response body error: exceeds max size of %v bytes", maxSize)
+		return 0
+	}
+
+	// Storage values are written whole, so the body is read into memory. Read at most one byte over the limit to detect
+	// oversized bodies without buffering them in full, including those sent without a content length.
+	var value strings.Builder
+	if resp.ContentLength > 0 {
+		value.Grow(int(resp.ContentLength))
+	}
+	read, err := io.Copy(&value, io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		l.RaiseError("HTTP response body error: %v", err.Error())
+		return 0
+	}
+	if read > maxSize {
+		l.RaiseError("HTTP response body error: exceeds max size of %v bytes", maxSize)
+		return 0
+	}
+
+	acks, _, err := StorageWriteObjects(l.Context(), n.logger, n.db, true, n.config.GetRuntime().StorageMaxObjectBytes, StorageOpWrites{&StorageOpWrite{
+		OwnerID: userID.String(),
+		Object: &api.WriteStorageObject{
+			Collection:      collection,
+			Key:             key,
+			Value:           value.String(),
+			PermissionRead:  &wrappers.Int32Value{Value: 1},
+			PermissionWrite: &wrappers.Int32Value{Value: 1},
+		},
+	}})
+	if err != nil {
+		l.RaiseError(fmt.Sprintf("failed to write storage object: %s", err.Error()))
+		return 0
+	}
+
+	ack := acks.Acks[0]
+	kt := l.CreateTable(0, 4)
+	kt.RawSetString("key", lua.LString(ack.Key))
+	kt.RawSetString("collection", lua.LString(ack.Collection))
+	if ack.UserId != "" {
+		kt.RawSetString("user_id", lua.LString(ack.UserId))
+	} else {
+		kt.RawSetString("user_id", lua.LNil)
+	}
+	kt.RawSetString("version", lua.LString(ack.Version))
+
+	l.Push(kt)
+	return 1
+}
+
+func (n *RuntimeLuaNakamaModule) httpCookieJar(l *lua.LState, name string) http.CookieJar {
+	// Cookie jars are kept in the registry of the Lua state executing the current invocation.
+	registry := l.Get(lua.RegistryIndex).(*lua.LTable)
+
+	var cookieJars *runtimeLuaHTTPCookieJars
+	if ud, ok := registry.RawGetString(runtimeLuaHTTPCookieJarsKey).(*lua.LUserData); ok {
+		cookieJars, _ = ud.Value.(*runtimeLuaHTTPCookieJars)
+	}
+	if cookieJars == nil || cookieJars.ctx != l.Context() {
+		// No cookie jars yet, or they belong to a previous invocation on this Lua state.
+		cookieJars = &runtimeLuaHTTPCookieJars{
+			ctx:  l.Context(),
+			jars: make(map[string]http.CookieJar, 1),
+		}
+		ud := l.NewUserData()
+		ud.Value = cookieJars
+		registry.RawSetString(runtimeLuaHTTPCookieJarsKey, ud)
+	}
+
+	jar, found := cookieJars.jars[name]
+	if !found {
+		// Only returns an error if given public suffix list options.
+		jar, _ = cookiejar.New(nil)
+		cookieJars.jars[name] = jar
+	}
+	return jar
+}
+
+func (n *RuntimeLuaNakamaModule) jwtGenerate(l *lua.LState) int {
+	algoType := l.CheckString(1)
+	if algoType == "" {
+		l.ArgError(1, "expects string")
+		return 0
+	}
+
+	var signingMethod jwt.SigningMethod
+	switch algoType {
+	case "HS256":
+		signingMethod = jwt.SigningMethodHS256
+	case "RS256":
+		signingMethod = jwt.SigningMethodRS256
+	default:
+		l.ArgError(1, "unsupported algo type - only allowed 'HS256', 'RS256'.")
+		return 0
+	}
+
+	signingKey := l.CheckString(2)
+	if signingKey == "" {
+		l.ArgError(2, "expects string")
+		return 0
+	}
+
+	claimsetTable := l.CheckTable(3)
+	if claimsetTable == nil {
+		l.ArgError(3, "expects nil")
+		return 0
+	}
+
+	claimset := RuntimeLuaConvertLuaValue(claimsetTable).(map[string]interface{})
+	jwtClaims := jwt.MapClaims{}
+	for k, v := range claimset {
+		jwtClaims[k] = v
+	}
+
+	var pk interface{}
+	switch signingMethod {
+	case jwt.SigningMethodRS256:
+		block, _ := pem.Decode([]byte(signingKey))
+		if block == nil {
+			l.RaiseError("could not parse private key: no valid blocks found")
+			return 0
+		}
+
+		var err error
+		pk, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			l.RaiseError("could not parse private key: %v", err.Error())
+			return 0
+		}
+	case jwt.SigningMethodHS256:
+		pk = []byte(signingKey)
+	}
+
+	token := jwt.NewWithClaims(signingMethod, jwtClaims)
+	signedToken, err := token.SignedString(pk)
+	if err != nil {
+		l.RaiseError("failed to sign token: %v", err.Error())
+		return 0
+	}
+
+	l.Push(lua.LString(signedToken))
+	return 1
+}
+
+func (n *RuntimeLuaNakamaModule) jsonEncode(l *lua.LState) int {
+	value := l.Get(1)
+	if value == nil {
+		l.ArgError(1, "expects a non-nil value to encode")
+		return 0
+	}
+
+	jsonData := RuntimeLuaConvertLuaValue(value)
+	jsonBytes, err := json.Marshal(jsonData)
+	if err != nil {
+		l.RaiseError("error encoding to JSON: %v", err.Error())
 		return 0
 	}
 
@@ -919,6 +1503,8 @@ func (n *RuntimeLuaNakamaModule) base64Decode(l *lua.LState) int {
 	}
 
 	padding := l.OptBool(2, false)
+	// Tolerant mode accepts input in either the standard or URL-safe alphabet.
+	tolerant := l.OptBool(3, false)
 
 	if !padding {
 		// Pad string up to length multiple of 4 if needed to effectively make padding optional.
@@ -927,7 +1513,13 @@ func (n *RuntimeLuaNakamaModule) base64Decode(l *lua.LState) int {
 		}
 	}
 
-	output, err := base64.StdEncoding.DecodeString(input)
+	encoding := base64.StdEncoding
+	if tolerant && strings.ContainsAny(input, "-_") {
+		// Characters only found in the URL-safe alphabet, the input can't be valid standard base64.
+		encoding = base64.URLEncoding
+	}
+
+	output, err := encoding.DecodeString(input)
 	if err != nil {
 		l.RaiseError("not a valid base64 string: %v", err.Error())
 		return 0
@@ -988,7 +1580,12 @@ func (n *RuntimeLuaNakamaModule) base16Encode(l *lua.LState) int {
 		return 0
 	}
 
+	uppercase := l.OptBool(2, false)
+
 	output := hex.EncodeToString([]byte(input))
+	if uppercase {
+		output = strings.ToUpper(output)
+	}
 	l.Push(lua.LString(output))
 	return 1
 }
@@ -1000,6 +1597,11 @@ func (n *RuntimeLuaNakamaModule) base16Decode(l *lua.LState) int {
 		return 0
 	}
 
+	// Both lowercase and uppercase digits are accepted.
+	if len(input)%2 != 0 {
+		l.RaiseError("not a valid base16 string: odd length %v, expects an even number of hex digits", len(input))
+		return 0
+	}
 	output, err := hex.DecodeString(input)
 	if err != nil {
 		l.RaiseError("not a valid base16 string: %v", err.Error())
@@ -1010,6 +1612,58 @@ func (n *RuntimeLuaNakamaModule) base16Decode(l *lua.LState) int {
 	return 1
 }
 
+func (n *RuntimeLuaNakamaModule) gzipCompress(l *lua.LState) int {
+	return runtimeLuaCompress(l, RuntimeGzipCompress)
+}
+
+func (n *RuntimeLuaNakamaModule) gzipDecompress(l *lua.LState) int {
+	return runtimeLuaDecompress(l, RuntimeGzipDecompress)
+}
+
+func (n *RuntimeLuaNakamaModule) zlibCompress(l *lua.LState) int {
+	return runtimeLuaCompress(l, RuntimeZlibCompress)
+}
+
+func (n *RuntimeLuaNakamaModule) zlibDecompress(l *lua.LState) int {
+	return runtimeLuaDecompress(l, RuntimeZlibDecompress)
+}
+
+func runtimeLuaCompress(l *lua.LState, compressFn func([]byte) ([]byte, error)) int {
+	input := l.CheckString(1)
+
+	output, err := compressFn([]byte(input))
+	if err != nil {
+		l.RaiseError("failed to compress: %v", err.Error())
+		return 0
+	}
+
+	l.Push(lua.LString(output))
+	return 1
+}
+
+func runtimeLuaDecompress(l *lua.LState, decompressFn func([]byte, int64) ([]byte, error)) int {
+	input := l.CheckString(1)
+	if input == "" {
+		l.ArgError(1, "expects string")
+		return 0
+	}
+
+	maxBytes := l.OptInt64(2, RuntimeDecompressMaxBytes)
+	if maxBytes < 1 || maxBytes > RuntimeDecompressMaxBytes {
+		l.ArgError(2, fmt.Sprintf("expects max bytes to be 1-%v", RuntimeDecompressMaxBytes))
+		return 0
+	}
+
+	output, err := decompressFn([]byte(input), maxBytes)
+	if err != nil {
+		l.RaiseError("failed to decompress: %v", err.Error())
+		return 0
+	}
+
+	l.Push(lua.LString(output))
+	return 1
+}
+
 func aesEncrypt(l *lua.LState, keySize int) int {
 	input := l.CheckString(1)
 	if input == "" {
@@ -1616,12 +2270,21 @@ func (n *RuntimeLuaNakamaModule) authenticateSteam(l *lua.LState) int {
 	// Parse create flag, if any.
 	create := l.OptBool(3, true)
 
-	dbUserID, dbUsername, created, err := AuthenticateSteam(l.Context(), n.logger, n.db, n.socialClient, n.config.GetSocial().Steam.AppID, n.config.GetSocial().Steam.PublisherKey, token, username, create)
+	// Parse import friends flag, if any.
+	importFriends := l.OptBool(4, false)
+
+	dbUserID, dbUsername, created, err := AuthenticateSteam(l.Context(), n.logger, n.db, n.socialClient, n.config.GetSocial().Steam, token, username, create)
 	if err != nil {
 		l.RaiseError("error authenticating: %v", err.Error())
 		return 0
 	}
 
+	// Import friends if requested.
+	if importFriends {
+		// Errors are logged before this point and failure here does not invalidate the whole operation.
+		_ = importSteamFriends(l.Context(), n.logger, n.db, n.router, n.socialClient, n.config.GetSocial().Steam.PublisherKey, uuid.FromStringOrNil(dbUserID), false)
+	}
+
 	l.Push(lua.LString(dbUserID))
 	l.Push(lua.LString(dbUsername))
 	l.Push(lua.LBool(created))
@@ -1689,80 +2352,259 @@ func (n *RuntimeLuaNakamaModule) authenticateTokenGenerate(l *lua.LState) int {
 	return 2
 }
 
-func (n *RuntimeLuaNakamaModule) getLuaModule(l *lua.LState) string {
-	// "path/to/module.lua:123:"
-	src := l.Where(-1)
-	// "path/to/module.lua:123"
-	return strings.TrimPrefix(src[:len(src)-1], n.config.GetRuntime().Path)
-}
-
-func (n *RuntimeLuaNakamaModule) loggerDebug(l *lua.LState) int {
-	message := l.CheckString(1)
-	if message == "" {
-		l.ArgError(1, "expects message string")
+func (n *RuntimeLuaNakamaModule) sessionRefreshGenerate(l *lua.LState) int {
+	userID, err := uuid.FromString(l.CheckString(1))
+	if err != nil {
+		l.ArgError(1, "expects valid user id")
 		return 0
 	}
-	n.logger.Debug(message, zap.String("runtime", "lua"))
-	l.Push(lua.LString(message))
-	return 1
-}
 
-func (n *RuntimeLuaNakamaModule) loggerInfo(l *lua.LState) int {
-	message := l.CheckString(1)
-	if message == "" {
-		l.ArgError(1, "expects message string")
+	username := l.CheckString(2)
+	if username == "" {
+		l.ArgError(2, "expects username")
 		return 0
 	}
-	n.logger.Info(message, zap.String("runtime", "lua"))
-	l.Push(lua.LString(message))
-	return 1
-}
 
-func (n *RuntimeLuaNakamaModule) loggerWarn(l *lua.LState) int {
-	message := l.CheckString(1)
-	if message == "" {
-		l.ArgError(1, "expects message string")
-		return 0
-	}
-	n.logger.Warn(message, zap.String("runtime", "lua"))
-	l.Push(lua.LString(message))
-	return 1
-}
+	vars := l.OptTable(3, nil)
+	var varsMap map[string]string
+	if vars != nil {
+		var conversionError string
+		varsMap = make(map[string]string, vars.Len())
+		vars.ForEach(func(k lua.LValue, v lua.LValue) {
+			if conversionError != "" {
+				return
+			}
 
-func (n *RuntimeLuaNakamaModule) loggerError(l *lua.LState) int {
-	message := l.CheckString(1)
-	if message == "" {
-		l.ArgError(1, "expects message string")
-		return 0
-	}
-	n.logger.Error(message, zap.String("runtime", "lua"), zap.String("source", n.getLuaModule(l)))
-	l.Push(lua.LString(message))
-	return 1
-}
+			if k.Type() != lua.LTString {
+				conversionError = "vars keys must be strings"
+				return
+			}
+			if v.Type() != lua.LTString {
+				conversionError = "vars values must be strings"
+				return
+			}
 
-func (n *RuntimeLuaNakamaModule) accountGetId(l *lua.LState) int {
-	input := l.CheckString(1)
-	if input == "" {
-		l.ArgError(1, "invalid user id")
-		return 0
+			varsMap[k.String()] = v.String()
+		})
+
+		if conversionError != "" {
+			l.ArgError(3, conversionError)
+			return 0
+		}
 	}
-	userID, err := uuid.FromString(input)
+
+	tokens, err := SessionRefreshGenerate(l.Context(), n.logger, n.db, n.config, userID, username, varsMap)
 	if err != nil {
-		l.ArgError(1, "invalid user id")
+		l.RaiseError("error generating session: %v", err.Error())
 		return 0
 	}
 
-	account, err := GetAccount(l.Context(), n.logger, n.db, n.tracker, userID)
-	if err != nil {
-		l.RaiseError("failed to get account for user_id %s: %s", userID, err.Error())
+	l.Push(lua.LString(tokens.Token))
+	l.Push(lua.LNumber(tokens.Exp))
+	l.Push(lua.LString(tokens.RefreshToken))
+	l.Push(lua.LNumber(tokens.RefreshExp))
+	return 4
+}
+
+func (n *RuntimeLuaNakamaModule) sessionRefresh(l *lua.LState) int {
+	refreshToken := l.CheckString(1)
+	if refreshToken == "" {
+		l.ArgError(1, "expects refresh token")
 		return 0
 	}
 
-	accountTable := l.CreateTable(0, 24)
-	accountTable.RawSetString("user_id", lua.LString(account.User.Id))
-	accountTable.RawSetString("username", lua.LString(account.User.Username))
-	accountTable.RawSetString("display_name", lua.LString(account.User.DisplayName))
-	accountTable.RawSetString("avatar_url", lua.LString(account.User.AvatarUrl))
+	vars := l.OptTable(2, nil)
+	var varsMap map[string]string
+	if vars != nil {
+		var conversionError string
+		varsMap = make(map[string]string, vars.Len())
+		vars.ForEach(func(k lua.LValue, v lua.LValue) {
+			if conversionError != "" {
+				return
+			}
+
+			if k.Type() != lua.LTString {
+				conversionError = "vars keys must be strings"
+				return
+			}
+			if v.Type() != lua.LTString {
+				conversionError = "vars values must be strings"
+				return
+			}
+
+			varsMap[k.String()] = v.String()
+		})
+
+		if conversionError != "" {
+			l.ArgError(2, conversionError)
+			return 0
+		}
+	}
+
+	tokens, err := SessionRefresh(l.Context(), n.logger, n.db, n.config, n.sessionCache, refreshToken, varsMap)
+	if err != nil {
+		l.RaiseError("error refreshing session: %v", err.Error())
+		return 0
+	}
+
+	l.Push(lua.LString(tokens.Token))
+	l.Push(lua.LNumber(tokens.Exp))
+	l.Push(lua.LString(tokens.RefreshToken))
+	l.Push(lua.LNumber(tokens.RefreshExp))
+	return 4
+}
+
+func (n *RuntimeLuaNakamaModule) getLuaModule(l *lua.LState) string {
+	// "path/to/module.lua:123:"
+	src := l.Where(-1)
+	// "path/to/module.lua:123"
+	return strings.TrimPrefix(src[:len(src)-1], n.config.GetRuntime().Path)
+}
+
+func (n *RuntimeLuaNakamaModule) loggerFields(l *lua.LState) []zap.Field {
+	fields := []zap.Field{zap.String("runtime", "lua")}
+
+	// Log output from authoritative match callbacks is tagged with the match ID and current tick.
+	registry := l.Get(lua.RegistryIndex).(*lua.LTable)
+	if ud, ok := registry.RawGetString(runtimeLuaMatchLogContextKey).(*lua.LUserData); ok {
+		if logContext, ok := ud.Value.(*runtimeLuaMatchLogContext); ok {
+			fields = append(fields, zap.String("match_id", logContext.matchID), zap.Int64("tick", logContext.tick))
+		}
+	}
+
+	return fields
+}
+
+func (n *RuntimeLuaNakamaModule) loggerDebug(l *lua.LState) int {
+	message := l.CheckString(1)
+	if message == "" {
+		l.ArgError(1, "expects message string")
+		return 0
+	}
+	n.logger.Debug(message, n.loggerFields(l)...)
+	l.Push(lua.LString(message))
+	return 1
+}
+
+func (n *RuntimeLuaNakamaModule) loggerInfo(l *lua.LState) int {
+	message := l.CheckString(1)
+	if message == "" {
+		l.ArgError(1, "expects message string")
+		return 0
+	}
+	n.logger.Info(message, n.loggerFields(l)...)
+	l.Push(lua.LString(message))
+	return 1
+}
+
+func (n *RuntimeLuaNakamaModule) loggerWarn(l *lua.LState) int {
+	message := l.CheckString(1)
+	if message == "" {
+		l.ArgError(1, "expects message string")
+		return 0
+	}
+	n.logger.Warn(message, n.loggerFields(l)...)
+	l.Push(lua.LString(message))
+	return 1
+}
+
+func (n *RuntimeLuaNakamaModule) loggerError(l *lua.LState) int {
+	message := l.CheckString(1)
+	if message == "" {
+		l.ArgError(1, "expects message string")
+		return 0
+	}
+	n.logger.Error(message, append(n.loggerFields(l), zap.String("source", n.getLuaModule(l)))...)
+	l.Push(lua.LString(message))
+	return 1
+}
+
+func (n *RuntimeLuaNakamaModule) accountGetId(l *lua.LState) int {
+	input := l.CheckString(1)
+	if input == "" {
+		l.ArgError(1, "invalid user id")
+		return 0
+	}
+	userID, err := uuid.FromString(input)
+	if err != nil {
+		l.ArgError(1, "invalid user id")
+		return 0
+	}
+
+	account, err := GetAccount(l.Context(), n.logger, n.db, n.tracker, userID)
+	if err != nil {
+		l.RaiseError("failed to get account for user_id %s: %s", userID, err.Error())
+		return 0
+	}
+
+	accountTable, err := accountToLuaTable(l, account)
+	if err != nil {
+		l.RaiseError(err.Error())
+		return 0
+	}
+
+	l.Push(accountTable)
+	return 1
+}
+
+func (n *RuntimeLuaNakamaModule) accountGetByDevice(l *lua.LState) int {
+	deviceID := l.CheckString(1)
+	if deviceID == "" {
+		l.ArgError(1, "expects device id")
+		return 0
+	}
+
+	account, err := GetAccountByDevice(l.Context(), n.logger, n.db, n.tracker, deviceID)
+	return n.pushAccountLookup(l, account, err)
+}
+
+func (n *RuntimeLuaNakamaModule) accountGetByEmail(l *lua.LState) int {
+	email := l.CheckString(1)
+	if !ValidateEmail(email) {
+		l.ArgError(1, "expects a valid email")
+		return 0
+	}
+
+	account, err := GetAccountByEmail(l.Context(), n.logger, n.db, n.tracker, email)
+	return n.pushAccountLookup(l, account, err)
+}
+
+func (n *RuntimeLuaNakamaModule) accountGetByCustom(l *lua.LState) int {
+	customID := l.CheckString(1)
+	if customID == "" {
+		l.ArgError(1, "expects custom id")
+		return 0
+	}
+
+	account, err := GetAccountByCustom(l.Context(), n.logger, n.db, n.tracker, customID)
+	return n.pushAccountLookup(l, account, err)
+}
+
+func (n *RuntimeLuaNakamaModule) pushAccountLookup(l *lua.LState, account *api.Account, err error) int {
+	if err != nil {
+		if err == ErrAccountNotFound {
+			l.Push(lua.LNil)
+			return 1
+		}
+		l.RaiseError("failed to get account: %s", err.Error())
+		return 0
+	}
+
+	accountTable, err := accountToLuaTable(l, account)
+	if err != nil {
+		l.RaiseError(err.Error())
+		return 0
+	}
+	l.Push(accountTable)
+	return 1
+}
+
+func accountToLuaTable(l *lua.LState, account *api.Account) (*lua.LTable, error) {
+	accountTable := l.CreateTable(0, 24)
+	accountTable.RawSetString("user_id", lua.LString(account.User.Id))
+	accountTable.RawSetString("username", lua.LString(account.User.Username))
+	accountTable.RawSetString("display_name", lua.LString(account.User.DisplayName))
+	accountTable.RawSetString("avatar_url", lua.LString(account.User.AvatarUrl))
 	accountTable.RawSetString("lang_tag", lua.LString(account.User.LangTag))
 	accountTable.RawSetString("location", lua.LString(account.User.Location))
 	accountTable.RawSetString("timezone", lua.LString(account.User.Timezone))
@@ -1790,10 +2632,9 @@ func (n *RuntimeLuaNakamaModule) accountGetId(l *lua.LState) int {
 	accountTable.RawSetString("update_time", lua.LNumber(account.User.UpdateTime.Seconds))
 
 	metadataMap := make(map[string]interface{})
-	err = json.Unmarshal([]byte(account.User.Metadata), &metadataMap)
+	err := json.Unmarshal([]byte(account.User.Metadata), &metadataMap)
 	if err != nil {
-		l.RaiseError(fmt.Sprintf("failed to convert metadata to json: %s", err.Error()))
-		return 0
+		return nil, fmt.Errorf("failed to convert metadata to json: %s", err.Error())
 	}
 	metadataTable := RuntimeLuaConvertMap(l, metadataMap)
 	accountTable.RawSetString("metadata", metadataTable)
@@ -1801,8 +2642,7 @@ func (n *RuntimeLuaNakamaModule) accountGetId(l *lua.LState) int {
 	walletMap := make(map[string]int64)
 	err = json.Unmarshal([]byte(account.Wallet), &walletMap)
 	if err != nil {
-		l.RaiseError(fmt.Sprintf("failed to convert wallet to json: %s", err.Error()))
-		return 0
+		return nil, fmt.Errorf("failed to convert wallet to json: %s", err.Error())
 	}
 	walletTable := RuntimeLuaConvertMapInt64(l, walletMap)
 	accountTable.RawSetString("wallet", walletTable)
@@ -1829,8 +2669,7 @@ func (n *RuntimeLuaNakamaModule) accountGetId(l *lua.LState) int {
 		accountTable.RawSetString("disable_time", lua.LNumber(account.DisableTime.Seconds))
 	}
 
-	l.Push(accountTable)
-	return 1
+	return accountTable, nil
 }
 
 func (n *RuntimeLuaNakamaModule) accountsGetId(l *lua.LState) int {
@@ -2135,6 +2974,104 @@ func (n *RuntimeLuaNakamaModule) usersGetUsername(l *lua.LState) int {
 	return 1
 }
 
+func (n *RuntimeLuaNakamaModule) usersGetPresence(l *lua.LState) int {
+	// Input table validation.
+	input := l.OptTable(1, nil)
+	if input == nil {
+		l.ArgError(1, "invalid user id list")
+		return 0
+	}
+	if input.Len() == 0 {
+		l.Push(l.CreateTable(0, 0))
+		return 1
+	}
+	userIDs, ok := RuntimeLuaConvertLuaValue(input).([]interface{})
+	if !ok {
+		l.ArgError(1, "invalid user id data")
+		return 0
+	}
+
+	// Input individual ID validation.
+	uuids := make([]uuid.UUID, 0, len(userIDs))
+	for _, id := range userIDs {
+		ids, ok := id.(string)
+		if !ok || ids == "" {
+			l.ArgError(1, "each user id must be a string")
+			return 0
+		}
+		uid, err := uuid.FromString(ids)
+		if err != nil {
+			l.ArgError(1, "each user id must be a valid id string")
+			return 0
+		}
+		uuids = append(uuids, uid)
+	}
+
+	usersTable := l.CreateTable(len(uuids), 0)
+	for i, userID := range uuids {
+		presences := n.tracker.ListByUserID(userID)
+
+		streamsTable := l.CreateTable(len(presences), 0)
+		for j, p := range presences {
+			streamTable := l.CreateTable(0, 7)
+			streamTable.RawSetString("mode", lua.LNumber(p.Stream.Mode))
+			if p.Stream.Subject != uuid.Nil {
+				streamTable.RawSetString("subject", lua.LString(p.Stream.Subject.String()))
+			}
+			if p.Stream.Subcontext != uuid.Nil {
+				streamTable.RawSetString("subcontext", lua.LString(p.Stream.Subcontext.String()))
+			}
+			if p.Stream.Label != "" {
+				streamTable.RawSetString("label", lua.LString(p.Stream.Label))
+			}
+			streamTable.RawSetString("session_id", lua.LString(p.ID.SessionID.String()))
+			streamTable.RawSetString("node_id", lua.LString(p.ID.Node))
+			streamTable.RawSetString("status", lua.LString(p.Meta.Status))
+
+			streamsTable.RawSetInt(j+1, streamTable)
+		}
+
+		userTable := l.CreateTable(0, 3)
+		userTable.RawSetString("user_id", lua.LString(userID.String()))
+		userTable.RawSetString("online", lua.LBool(len(presences) != 0))
+		userTable.RawSetString("streams", streamsTable)
+
+		usersTable.RawSetInt(i+1, userTable)
+	}
+
+	l.Push(usersTable)
+	return 1
+}
+
+func (n *RuntimeLuaNakamaModule) usersCount(l *lua.LState) int {
+	count, err := CountUsers(l.Context(), n.logger, n.db)
+	if err != nil {
+		l.RaiseError("failed to count users: %s", err.Error())
+		return 0
+	}
+
+	l.Push(lua.LNumber(count))
+	return 1
+}
+
+func (n *RuntimeLuaNakamaModule) usersCountByDate(l *lua.LState) int {
+	start := l.CheckInt64(1)
+	end := l.CheckInt64(2)
+	if end <= start {
+		l.ArgError(2, "expects end time to be after start time")
+		return 0
+	}
+
+	count, err := CountUsersByCreateTime(l.Context(), n.logger, n.db, time.Unix(start, 0), time.Unix(end, 0))
+	if err != nil {
+		l.RaiseError("failed to count users: %s", err.Error())
+		return 0
+	}
+
+	l.Push(lua.LNumber(count))
+	return 1
+}
+
 func (n *RuntimeLuaNakamaModule) usersBanId(l *lua.LState) int {
 	// Input table validation.
 	input := l.OptTable(1, nil)
@@ -2221,6 +3158,47 @@ func (n *RuntimeLuaNakamaModule) usersUnbanId(l *lua.LState) int {
 	return 0
 }
 
+func (n *RuntimeLuaNakamaModule) usernameValidate(l *lua.LState) int {
+	username := l.CheckString(1)
+
+	maxLength := l.OptInt(2, usernameMaxLength)
+	if maxLength < 1 || maxLength > usernameMaxLength {
+		l.ArgError(2, fmt.Sprintf("expects max length to be 1-%v", usernameMaxLength))
+		return 0
+	}
+
+	l.Push(lua.LBool(ValidateUsername(username, maxLength)))
+	return 1
+}
+
+func (n *RuntimeLuaNakamaModule) usernameNormalize(l *lua.LState) int {
+	username := l.CheckString(1)
+
+	l.Push(lua.LString(NormalizeUsername(username)))
+	return 1
+}
+
+func (n *RuntimeLuaNakamaModule) emailValidate(l *lua.LState) int {
+	email := l.CheckString(1)
+
+	l.Push(lua.LBool(ValidateEmail(email)))
+	return 1
+}
+
+func (n *RuntimeLuaNakamaModule) uuidValidate(l *lua.LState) int {
+	id := l.CheckString(1)
+
+	l.Push(lua.LBool(ValidateUUID(id)))
+	return 1
+}
+
+func (n *RuntimeLuaNakamaModule) urlValidate(l *lua.LState) int {
+	rawURL := l.CheckString(1)
+
+	l.Push(lua.LBool(ValidateURL(rawURL)))
+	return 1
+}
+
 func (n *RuntimeLuaNakamaModule) linkApple(l *lua.LState) int {
 	userID := l.CheckString(1)
 	id, err := uuid.FromString(userID)
@@ -2430,14 +3408,27 @@ func (n *RuntimeLuaNakamaModule) linkSteam(l *lua.LState) int {
 		l.ArgError(2, "expects token string")
 		return 0
 	}
+	importFriends := l.OptBool(3, false)
 
 	if err := LinkSteam(l.Context(), n.logger, n.db, n.config, n.socialClient, id, token); err != nil {
 		l.RaiseError("error linking: %v", err.Error())
+		return 0
+	}
+
+	// Import friends if requested.
+	if importFriends {
+		// Errors are logged before this point and failure here does not invalidate the whole operation.
+		_ = importSteamFriends(l.Context(), n.logger, n.db, n.router, n.socialClient, n.config.GetSocial().Steam.PublisherKey, id, false)
 	}
 	return 0
 }
 
-func (n *RuntimeLuaNakamaModule) unlinkApple(l *lua.LState) int {
+func (n *RuntimeLuaNakamaModule) friendsImportSteam(l *lua.LState) int {
+	if n.config.GetSocial().Steam.PublisherKey == "" || n.config.GetSocial().Steam.AppID == 0 {
+		l.RaiseError("Steam authentication is not configured")
+		return 0
+	}
+
 	userID := l.CheckString(1)
 	id, err := uuid.FromString(userID)
 	if err != nil {
@@ -2445,13 +3436,29 @@ func (n *RuntimeLuaNakamaModule) unlinkApple(l *lua.LState) int {
 		return 0
 	}
 
-	token := l.CheckString(2)
-	if token == "" {
-		l.ArgError(2, "expects token string")
-		return 0
-	}
+	reset := l.OptBool(2, false)
 
-	if err := UnlinkApple(l.Context(), n.logger, n.db, n.config, n.socialClient, id, token); err != nil {
+	if err := importSteamFriends(l.Context(), n.logger, n.db, n.router, n.socialClient, n.config.GetSocial().Steam.PublisherKey, id, reset); err != nil {
+		l.RaiseError("error importing friends: %v", err.Error())
+	}
+	return 0
+}
+
+func (n *RuntimeLuaNakamaModule) unlinkApple(l *lua.LState) int {
+	userID := l.CheckString(1)
+	id, err := uuid.FromString(userID)
+	if err != nil {
+		l.ArgError(1, "user ID must be a valid identifier")
+		return 0
+	}
+
+	token := l.CheckString(2)
+	if token == "" {
+		l.ArgError(2, "expects token string")
+		return 0
+	}
+
+	if err := UnlinkApple(l.Context(), n.logger, n.db, n.config, n.socialClient, id, token); err != nil {
 		l.RaiseError("error unlinking: %v", err.Error())
 	}
 	return 0
@@ -3685,6 +4692,70 @@ func (n *RuntimeLuaNakamaModule) streamSendRaw(l *lua.LState) int {
 	return 0
 }
 
+func (n *RuntimeLuaNakamaModule) channelMessageUpdate(l *lua.LState) int {
+	channelID := l.CheckString(1)
+	if channelID == "" {
+		l.ArgError(1, "expects channel id")
+		return 0
+	}
+
+	messageID := l.CheckString(2)
+	if _, err := uuid.FromString(messageID); err != nil {
+		l.ArgError(2, "expects valid message id")
+		return 0
+	}
+
+	contentMap := RuntimeLuaConvertLuaTable(l.CheckTable(3))
+	contentBytes, err := json.Marshal(contentMap)
+	if err != nil {
+		l.ArgError(3, fmt.Sprintf("failed to convert content: %s", err.Error()))
+		return 0
+	}
+
+	// Optional sender ID, if set only messages sent by this user may be updated.
+	senderID := uuid.Nil
+	if senderIDString := l.OptString(4, ""); senderIDString != "" {
+		if senderID, err = uuid.FromString(senderIDString); err != nil {
+			l.ArgError(4, "expects valid sender id")
+			return 0
+		}
+	}
+
+	if _, err := ChannelMessageUpdate(l.Context(), n.logger, n.db, n.router, senderID, channelID, messageID, string(contentBytes)); err != nil {
+		l.RaiseError("failed to update channel message: %s", err.Error())
+	}
+	return 0
+}
+
+func (n *RuntimeLuaNakamaModule) channelMessageRemove(l *lua.LState) int {
+	channelID := l.CheckString(1)
+	if channelID == "" {
+		l.ArgError(1, "expects channel id")
+		return 0
+	}
+
+	messageID := l.CheckString(2)
+	if _, err := uuid.FromString(messageID); err != nil {
+		l.ArgError(2, "expects valid message id")
+		return 0
+	}
+
+	// Optional sender ID, if set only messages sent by this user may be removed.
+	senderID := uuid.Nil
+	if senderIDString := l.OptString(3, ""); senderIDString != "" {
+		var err error
+		if senderID, err = uuid.FromString(senderIDString); err != nil {
+			l.ArgError(3, "expects valid sender id")
+			return 0
+		}
+	}
+
+	if _, err := ChannelMessageRemove(l.Context(), n.logger, n.db, n.router, senderID, channelID, messageID); err != nil {
+		l.RaiseError("failed to remove channel message: %s", err.Error())
+	}
+	return 0
+}
+
 func (n *RuntimeLuaNakamaModule) sessionDisconnect(l *lua.LState) int {
 	// Parse input Session ID.
 	sessionIDString := l.CheckString(1)
@@ -3704,6 +4775,98 @@ func (n *RuntimeLuaNakamaModule) sessionDisconnect(l *lua.LState) int {
 	return 0
 }
 
+func (n *RuntimeLuaNakamaModule) sessionList(l *lua.LState) int {
+	userID, err := uuid.FromString(l.CheckString(1))
+	if err != nil {
+		l.ArgError(1, "expects user ID to be a valid identifier")
+		return 0
+	}
+
+	sessions := ListUserSessions(n.tracker, n.sessionRegistry, userID)
+
+	sessionsTable := l.CreateTable(len(sessions), 0)
+	for i, session := range sessions {
+		sessionTable := l.CreateTable(0, 4)
+		sessionTable.RawSetString("session_id", lua.LString(session.ID.String()))
+		sessionTable.RawSetString("node", lua.LString(session.Node))
+		if session.CreateTime != 0 {
+			sessionTable.RawSetString("create_time", lua.LNumber(session.CreateTime))
+		}
+		if session.ClientIP != "" {
+			sessionTable.RawSetString("client_ip", lua.LString(session.ClientIP))
+		}
+		sessionsTable.RawSetInt(i+1, sessionTable)
+	}
+
+	l.Push(sessionsTable)
+	return 1
+}
+
+func (n *RuntimeLuaNakamaModule) usersOnlineCount(l *lua.LState) int {
+	l.Push(lua.LNumber(n.sessionRegistry.Count()))
+	return 1
+}
+
+func (n *RuntimeLuaNakamaModule) sessionVarsUpdate(l *lua.LState) int {
+	// Parse input Session ID.
+	sessionIDString := l.CheckString(1)
+	if sessionIDString == "" {
+		l.ArgError(1, "expects session id")
+		return 0
+	}
+	sessionID, err := uuid.FromString(sessionIDString)
+	if err != nil {
+		l.ArgError(1, "expects valid session id")
+		return 0
+	}
+
+	vars := l.CheckTable(2)
+	var conversionError string
+	varsMap := make(map[string]string, vars.Len())
+	vars.ForEach(func(k lua.LValue, v lua.LValue) {
+		if conversionError != "" {
+			return
+		}
+
+		if k.Type() != lua.LTString {
+			conversionError = "vars keys must be strings"
+			return
+		}
+		if v.Type() != lua.LTString {
+			conversionError = "vars values must be strings"
+			return
+		}
+
+		varsMap[k.String()] = v.String()
+	})
+	if conversionError != "" {
+		l.ArgError(2, conversionError)
+		return 0
+	}
+
+	refreshToken := l.OptBool(3, false)
+
+	session := n.sessionRegistry.Get(sessionID)
+	if session == nil {
+		l.RaiseError(fmt.Sprintf("failed to update session vars: %s", ErrSessionNotFound.Error()))
+		return 0
+	}
+	if err := n.sessionRegistry.UpdateVars(sessionID, varsMap); err != nil {
+		l.RaiseError(fmt.Sprintf("failed to update session vars: %s", err.Error()))
+		return 0
+	}
+
+	if !refreshToken {
+		return 0
+	}
+
+	// Issue a token carrying the new vars, so they survive the client reconnecting.
+	token, exp := generateToken(n.config, session.UserID().String(), session.Username(), varsMap)
+	l.Push(lua.LString(token))
+	l.Push(lua.LNumber(exp))
+	return 2
+}
+
 func (n *RuntimeLuaNakamaModule) matchCreate(l *lua.LState) int {
 	// Parse the name of the Lua module that should handle the match.
 	module := l.CheckString(1)
@@ -3723,7 +4886,28 @@ func (n *RuntimeLuaNakamaModule) matchCreate(l *lua.LState) int {
 		}
 	}
 
-	id, err := n.matchRegistry.CreateMatch(l.Context(), n.logger, n.matchCreateFn, module, paramsMap)
+	var reservedSessions []uuid.UUID
+	if reservedTable := l.OptTable(3, nil); reservedTable != nil {
+		reservedSessions = make([]uuid.UUID, 0, reservedTable.Len())
+		conversionError := false
+		reservedTable.ForEach(func(k lua.LValue, v lua.LValue) {
+			if conversionError {
+				return
+			}
+			sessionID, err := uuid.FromString(v.String())
+			if v.Type() != lua.LTString || err != nil {
+				conversionError = true
+				l.ArgError(3, "expects reserved sessions to be a table of session ID strings")
+				return
+			}
+			reservedSessions = append(reservedSessions, sessionID)
+		})
+		if conversionError {
+			return 0
+		}
+	}
+
+	id, err := n.matchRegistry.CreateMatchWithReservations(l.Context(), n.logger, n.matchCreateFn, module, paramsMap, reservedSessions)
 	if err != nil {
 		l.RaiseError(err.Error())
 		return 0
@@ -3815,7 +4999,10 @@ func (n *RuntimeLuaNakamaModule) matchList(l *lua.LState) int {
 		query = &wrappers.StringValue{Value: lua.LVAsString(v)}
 	}
 
-	results, err := n.matchRegistry.ListMatches(l.Context(), limit, authoritative, label, minSize, maxSize, query)
+	// Parse optional sort order.
+	sortBy := l.OptString(7, "")
+
+	results, err := n.matchRegistry.ListMatchesSorted(l.Context(), limit, sortBy, authoritative, label, minSize, maxSize, query)
 	if err != nil {
 		l.RaiseError(fmt.Sprintf("failed to list matches: %s", err.Error()))
 		return 0
@@ -3838,6 +5025,384 @@ func (n *RuntimeLuaNakamaModule) matchList(l *lua.LState) int {
 	return 1
 }
 
+func (n *RuntimeLuaNakamaModule) matchGetRandom(l *lua.LState) int {
+	// Parse label query.
+	query := l.OptString(1, "")
+
+	// Parse the number of free places required.
+	minSpace := l.OptInt(2, 1)
+	if minSpace < 0 {
+		l.ArgError(2, "expects min space to be >= 0")
+		return 0
+	}
+
+	result, err := n.matchRegistry.GetRandomMatch(l.Context(), query, minSpace)
+	if err != nil {
+		l.RaiseError(fmt.Sprintf("failed to get random match: %s", err.Error()))
+		return 0
+	}
+
+	if result == nil {
+		l.Push(lua.LNil)
+		return 1
+	}
+
+	match := l.CreateTable(0, 4)
+	match.RawSetString("match_id", lua.LString(result.MatchId))
+	match.RawSetString("authoritative", lua.LBool(result.Authoritative))
+	match.RawSetString("label", lua.LString(result.Label.Value))
+	match.RawSetString("size", lua.LNumber(result.Size))
+
+	l.Push(match)
+	return 1
+}
+
+func (n *RuntimeLuaNakamaModule) matchTerminate(l *lua.LState) int {
+	// Parse match ID.
+	id := l.CheckString(1)
+	if id == "" {
+		l.ArgError(1, "expects match id")
+		return 0
+	}
+
+	// Parse grace period.
+	graceSeconds := l.OptInt(2, 0)
+	if graceSeconds < 0 {
+		l.ArgError(2, "expects grace seconds to be >= 0")
+		return 0
+	}
+
+	if err := n.matchRegistry.TerminateMatch(l.Context(), id, graceSeconds); err != nil {
+		l.RaiseError(fmt.Sprintf("failed to terminate match: %s", err.Error()))
+	}
+	return 0
+}
+
+func (n *RuntimeLuaNakamaModule) matchSignalByLabel(l *lua.LState) int {
+	// Parse label query.
+	query := l.CheckString(1)
+
+	// Parse signal data.
+	data := l.OptString(2, "")
+
+	// Parse fan-out limit.
+	limit := l.OptInt(3, MatchSignalMaxMatches)
+	if limit < 1 || limit > MatchSignalMaxMatches {
+		l.ArgError(3, fmt.Sprintf("expects limit to be 1-%v", MatchSignalMaxMatches))
+		return 0
+	}
+
+	// Parse response timeout, if any. Defaults to the configured match signal timeout.
+	timeoutMs := l.OptInt(4, 0)
+	if timeoutMs < 0 {
+		l.ArgError(4, "expects timeout to be >= 0")
+		return 0
+	}
+
+	count, err := n.matchRegistry.SignalMatchesByLabel(l.Context(), query, data, limit, time.Duration(timeoutMs)*time.Millisecond)
+	if err != nil {
+		l.RaiseError(fmt.Sprintf("failed to signal matches: %s", err.Error()))
+		return 0
+	}
+
+	l.Push(lua.LNumber(count))
+	return 1
+}
+
+func (n *RuntimeLuaNakamaModule) matchGetState(l *lua.LState) int {
+	// Parse match ID.
+	id := l.CheckString(1)
+	if id == "" {
+		l.ArgError(1, "expects match id")
+		return 0
+	}
+
+	snapshot, ok, err := n.matchRegistry.GetMatchState(l.Context(), id)
+	if err != nil {
+		l.RaiseError(fmt.Sprintf("failed to get match state: %s", err.Error()))
+		return 0
+	}
+
+	if !ok {
+		l.Push(lua.LNil)
+	} else {
+		l.Push(lua.LString(snapshot))
+	}
+	return 1
+}
+
+func (n *RuntimeLuaNakamaModule) matchOpCodeHandler(l *lua.LState) int {
+	opCode := l.CheckInt64(1)
+	if opCode < 0 {
+		l.ArgError(1, "expects op code to be >= 0")
+		return 0
+	}
+	fn := l.CheckFunction(2)
+
+	registry := l.Get(lua.RegistryIndex).(*lua.LTable)
+	handlers, ok := registry.RawGetString(runtimeLuaMatchOpCodeHandlersKey).(*lua.LTable)
+	if !ok {
+		handlers = l.CreateTable(0, 1)
+		// Handlers registered when modules were loaded live in the shared registry, keep them visible without modifying them.
+		if shared, ok := l.GetField(registry, runtimeLuaMatchOpCodeHandlersKey).(*lua.LTable); ok {
+			mt := l.CreateTable(0, 1)
+			mt.RawSetString("__index", shared)
+			handlers.Metatable = mt
+		}
+		registry.RawSetString(runtimeLuaMatchOpCodeHandlersKey, handlers)
+	}
+	handlers.RawSet(lua.LNumber(opCode), fn)
+
+	return 0
+}
+
+func (n *RuntimeLuaNakamaModule) matchOpCodeDispatch(l *lua.LState) int {
+	ctx := l.CheckTable(1)
+	dispatcher := l.CheckTable(2)
+	tick := l.CheckInt64(3)
+	state := l.CheckAny(4)
+	messages := l.CheckTable(5)
+
+	handlers, ok := l.GetField(l.Get(lua.RegistryIndex), runtimeLuaMatchOpCodeHandlersKey).(*lua.LTable)
+	if !ok {
+		// No handlers registered, nothing to dispatch to.
+		l.Push(state)
+		return 1
+	}
+
+	for i := 1; i <= messages.Len(); i++ {
+		message, ok := messages.RawGetInt(i).(*lua.LTable)
+		if !ok {
+			l.ArgError(5, "expects messages to be a list of match data tables")
+			return 0
+		}
+		opCode, ok := message.RawGetString("op_code").(lua.LNumber)
+		if !ok {
+			l.ArgError(5, "expects each message to have a numeric op_code")
+			return 0
+		}
+
+		// Messages with no registered handler are skipped, they remain available in the raw messages list.
+		fn, ok := l.GetTable(handlers, opCode).(*lua.LFunction)
+		if !ok {
+			continue
+		}
+
+		l.Push(fn)
+		l.Push(ctx)
+		l.Push(dispatcher)
+		l.Push(lua.LNumber(tick))
+		l.Push(state)
+		l.Push(message)
+		l.Call(5, 1)
+		state = l.Get(-1)
+		l.Pop(1)
+
+		if state == lua.LNil {
+			// A handler ended the match, do not process any further messages.
+			break
+		}
+	}
+
+	l.Push(state)
+	return 1
+}
+
+func (n *RuntimeLuaNakamaModule) matchmakerAddBatch(l *lua.LState) int {
+	presences := l.CheckTable(1)
+	if presences.Len() == 0 {
+		l.ArgError(1, "expects at least one presence")
+		return 0
+	}
+	sessions := make([]Session, 0, presences.Len())
+	seen := make(map[uuid.UUID]struct{}, presences.Len())
+	conversionError := false
+	presences.ForEach(func(_, p lua.LValue) {
+		if conversionError {
+			return
+		}
+		pt, ok := p.(*lua.LTable)
+		if !ok {
+			conversionError = true
+			l.ArgError(1, "expects a valid set of presences")
+			return
+		}
+
+		userID, err := uuid.FromString(pt.RawGetString("user_id").String())
+		if err != nil {
+			conversionError = true
+			l.ArgError(1, "expects each presence to have a valid user_id")
+			return
+		}
+		sessionID, err := uuid.FromString(pt.RawGetString("session_id").String())
+		if err != nil {
+			conversionError = true
+			l.ArgError(1, "expects each presence to have a valid session_id")
+			return
+		}
+		if _, found := seen[sessionID]; found {
+			conversionError = true
+			l.ArgError(1, "expects each presence to have a distinct session_id")
+			return
+		}
+		seen[sessionID] = struct{}{}
+
+		// Party members must be connected to this node as the users they claim to be.
+		session := n.sessionRegistry.Get(sessionID)
+		if session == nil || session.UserID() != userID {
+			conversionError = true
+			l.ArgError(1, fmt.Sprintf("expects presence with session_id %v to be an active session of user_id %v", sessionID, userID))
+			return
+		}
+		sessions = append(sessions, session)
+	})
+	if conversionError {
+		return 0
+	}
+
+	query := l.OptString(2, "*")
+	if query == "" {
+		query = "*"
+	}
+
+	minCount := l.CheckInt(3)
+	if minCount < 2 {
+		l.ArgError(3, "expects min_count to be >= 2")
+		return 0
+	}
+	maxCount := l.CheckInt(4)
+	if maxCount < minCount {
+		l.ArgError(4, "expects max_count to be >= min_count")
+		return 0
+	}
+	if len(sessions) > maxCount {
+		l.ArgError(1, "expects no more presences than max_count")
+		return 0
+	}
+
+	var stringProperties map[string]string
+	if t := l.OptTable(5, nil); t != nil {
+		stringProperties = make(map[string]string, t.Len())
+		t.ForEach(func(k, v lua.LValue) {
+			if conversionError {
+				return
+			}
+			if v.Type() != lua.LTString {
+				conversionError = true
+				l.ArgError(5, "expects string_properties values to be strings")
+				return
+			}
+			stringProperties[k.String()] = v.String()
+		})
+	}
+	var numericProperties map[string]float64
+	if t := l.OptTable(6, nil); t != nil {
+		numericProperties = make(map[string]float64, t.Len())
+		t.ForEach(func(k, v lua.LValue) {
+			if conversionError {
+				return
+			}
+			if v.Type() != lua.LTNumber {
+				conversionError = true
+				l.ArgError(6, "expects numeric_properties values to be numbers")
+				return
+			}
+			numericProperties[k.String()] = float64(v.(lua.LNumber))
+		})
+	}
+	if conversionError {
+		return 0
+	}
+
+	ticket, entries, err := n.matchmaker.AddBatch(l.Context(), sessions, query, minCount, maxCount, stringProperties, numericProperties)
+	if err != nil {
+		l.RaiseError("error adding to matchmaker: %v", err.Error())
+		return 0
+	}
+
+	if entries != nil {
+		// Delivery may run the matched hook on another runtime instance, do not hold this one while it does.
+		go MatchmakerDeliver(n.logger, n.config, n.router, n.matchmaker.MatchedFunction(), entries)
+	}
+
+	l.Push(lua.LString(ticket))
+	return 1
+}
+
+func (n *RuntimeLuaNakamaModule) matchmakerStats(l *lua.LState) int {
+	stats := n.matchmaker.Stats()
+
+	agesTable := l.CreateTable(len(stats.TicketAges), 0)
+	for i, bucket := range stats.TicketAges {
+		bucketTable := l.CreateTable(0, 2)
+		if bucket.MaxAge > 0 {
+			bucketTable.RawSetString("max_age_sec", lua.LNumber(bucket.MaxAge/time.Second))
+		}
+		bucketTable.RawSetString("count", lua.LNumber(bucket.Count))
+		agesTable.RawSetInt(i+1, bucketTable)
+	}
+
+	statsTable := l.CreateTable(0, 2)
+	statsTable.RawSetString("active_tickets", lua.LNumber(stats.ActiveTickets))
+	statsTable.RawSetString("ticket_ages", agesTable)
+
+	l.Push(statsTable)
+	return 1
+}
+
+func (n *RuntimeLuaNakamaModule) matchmakerTicketGet(l *lua.LState) int {
+	ticket := l.CheckString(1)
+	if ticket == "" {
+		l.ArgError(1, "expects ticket to be a non-empty string")
+		return 0
+	}
+
+	status := n.matchmaker.GetTicket(ticket)
+	if status == nil {
+		l.Push(lua.LNil)
+		return 1
+	}
+
+	l.Push(matchmakerTicketToLuaTable(l, status.State, status.Entry))
+	return 1
+}
+
+func (n *RuntimeLuaNakamaModule) matchmakerUserTickets(l *lua.LState) int {
+	userID, err := uuid.FromString(l.CheckString(1))
+	if err != nil {
+		l.ArgError(1, "expects user_id to be a valid UUID")
+		return 0
+	}
+
+	entries := n.matchmaker.UserTickets(userID)
+
+	ticketsTable := l.CreateTable(len(entries), 0)
+	for i, entry := range entries {
+		ticketsTable.RawSetInt(i+1, matchmakerTicketToLuaTable(l, MatchmakerTicketStateWaiting, entry))
+	}
+
+	l.Push(ticketsTable)
+	return 1
+}
+
+func matchmakerTicketToLuaTable(l *lua.LState, state string, entry *MatchmakerEntry) *lua.LTable {
+	presenceTable := l.CreateTable(0, 4)
+	presenceTable.RawSetString("user_id", lua.LString(entry.Presence.UserId))
+	presenceTable.RawSetString("session_id", lua.LString(entry.Presence.SessionId))
+	presenceTable.RawSetString("username", lua.LString(entry.Presence.Username))
+	presenceTable.RawSetString("node", lua.LString(entry.Presence.Node))
+
+	ticketTable := l.CreateTable(0, 6)
+	ticketTable.RawSetString("ticket", lua.LString(entry.Ticket))
+	ticketTable.RawSetString("state", lua.LString(state))
+	ticketTable.RawSetString("query", lua.LString(entry.Query))
+	ticketTable.RawSetString("properties", RuntimeLuaConvertMap(l, entry.Properties))
+	ticketTable.RawSetString("presence", presenceTable)
+	ticketTable.RawSetString("create_time", lua.LNumber(entry.CreateTime.Unix()))
+
+	return ticketTable
+}
+
 func (n *RuntimeLuaNakamaModule) notificationSend(l *lua.LState) int {
 	u := l.CheckString(1)
 	userID, err := uuid.FromString(u)
@@ -3853,12 +5418,6 @@ func (n *RuntimeLuaNakamaModule) notificationSend(l *lua.LState) int {
 	}
 
 	contentMap := RuntimeLuaConvertLuaTable(l.CheckTable(3))
-	contentBytes, err := json.Marshal(contentMap)
-	if err != nil {
-		l.ArgError(1, fmt.Sprintf("failed to convert content: %s", err.Error()))
-		return 0
-	}
-	content := string(contentBytes)
 
 	code := l.CheckInt(4)
 	if code <= 0 {
@@ -3879,6 +5438,29 @@ func (n *RuntimeLuaNakamaModule) notificationSend(l *lua.LState) int {
 
 	persistent := l.OptBool(6, false)
 
+	// Localization key and params, optional.
+	if locKey := l.OptString(7, ""); locKey != "" {
+		var locParams map[string]interface{}
+		if locParamsTable := l.OptTable(8, nil); locParamsTable != nil {
+			locParams = RuntimeLuaConvertLuaTable(locParamsTable)
+		}
+		contentMap, err = NotificationContentLocalize(contentMap, locKey, locParams)
+		if err != nil {
+			l.ArgError(8, err.Error())
+			return 0
+		}
+	} else if l.OptTable(8, nil) != nil {
+		l.ArgError(7, "expects a localization key when localization params are given")
+		return 0
+	}
+
+	contentBytes, err := json.Marshal(contentMap)
+	if err != nil {
+		l.ArgError(3, fmt.Sprintf("failed to convert content: %s", err.Error()))
+		return 0
+	}
+	content := string(contentBytes)
+
 	nots := []*api.Notification{{
 		Id:         uuid.Must(uuid.NewV4()).String(),
 		Subject:    subject,
@@ -3895,6 +5477,7 @@ func (n *RuntimeLuaNakamaModule) notificationSend(l *lua.LState) int {
 	if err := NotificationSend(l.Context(), n.logger, n.db, n.router, notifications); err != nil {
 		l.RaiseError(fmt.Sprintf("failed to send notifications: %s", err.Error()))
 	}
+	n.pushQueue.Queue(notifications)
 
 	return 0
 }
@@ -4048,6 +5631,7 @@ func (n *RuntimeLuaNakamaModule) notificationsSend(l *lua.LState) int {
 	if err := NotificationSend(l.Context(), n.logger, n.db, n.router, notifications); err != nil {
 		l.RaiseError(fmt.Sprintf("failed to send notifications: %s", err.Error()))
 	}
+	n.pushQueue.Queue(notifications)
 
 	return 0
 }
@@ -4096,18 +5680,101 @@ func (n *RuntimeLuaNakamaModule) walletUpdate(l *lua.LState) int {
 
 	updateLedger := l.OptBool(4, true)
 
-	results, err := UpdateWallets(l.Context(), n.logger, n.db, []*walletUpdate{{
-		UserID:    userID,
-		Changeset: changesetMapInt64,
-		Metadata:  string(metadataBytes),
-	}}, updateLedger)
+	// Parse wallet-level metadata, optional.
+	var walletMetadata map[string]interface{}
+	if walletMetadataTable := l.OptTable(5, nil); walletMetadataTable != nil {
+		walletMetadata = RuntimeLuaConvertLuaTable(walletMetadataTable)
+	}
+
+	// Parse per-currency limits, optional.
+	var limits map[string]*WalletCurrencyLimit
+	if limitsTable := l.OptTable(6, nil); limitsTable != nil {
+		limits, err = walletLimitsFromLua(limitsTable)
+		if err != nil {
+			l.ArgError(6, err.Error())
+			return 0
+		}
+	}
+
+	updates := []*walletUpdate{{
+		UserID:         userID,
+		Changeset:      changesetMapInt64,
+		Metadata:       string(metadataBytes),
+		WalletMetadata: walletMetadata,
+		Limits:         limits,
+	}}
+	results, err := UpdateWallets(l.Context(), n.logger, n.db, updates, updateLedger)
 	if err != nil {
 		l.RaiseError(fmt.Sprintf("failed to update user wallet: %s", err.Error()))
+		return 0
 	}
+	EmitWalletUpdateEvents(l.Context(), n.logger, n.config, n.eventFn, updates, results)
 
 	l.Push(RuntimeLuaConvertMapInt64(l, results[0].Updated))
 	l.Push(RuntimeLuaConvertMapInt64(l, results[0].Previous))
-	return 2
+	if ledgerID := WalletUpdateLedgerIDs(updates, results)[0]; ledgerID != "" {
+		l.Push(lua.LString(ledgerID))
+	} else {
+		l.Push(lua.LNil)
+	}
+	return 3
+}
+
+// walletLimitsFromLua converts a table of currency names to tables with optional "min", "max" and "clamp" fields.
+func walletLimitsFromLua(limitsTable *lua.LTable) (map[string]*WalletCurrencyLimit, error) {
+	limits := make(map[string]*WalletCurrencyLimit)
+	for currency, v := range RuntimeLuaConvertLuaTable(limitsTable) {
+		limitMap, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expects limit for '%v' to be a table", currency)
+		}
+		limit := &WalletCurrencyLimit{}
+		for field, fv := range limitMap {
+			switch field {
+			case "min", "max":
+				bound, ok := fv.(int64)
+				if !ok {
+					return nil, fmt.Errorf("expects limit %v for '%v' to be a whole number", field, currency)
+				}
+				if field == "min" {
+					limit.Min = &bound
+				} else {
+					limit.Max = &bound
+				}
+			case "clamp":
+				clamp, ok := fv.(bool)
+				if !ok {
+					return nil, fmt.Errorf("expects limit clamp for '%v' to be a boolean", currency)
+				}
+				limit.Clamp = clamp
+			default:
+				return nil, fmt.Errorf("unknown limit field '%v' for '%v'", field, currency)
+			}
+		}
+		if limit.Min != nil && limit.Max != nil && *limit.Min > *limit.Max {
+			return nil, fmt.Errorf("expects limit min for '%v' to not exceed max", currency)
+		}
+		limits[currency] = limit
+	}
+	return limits, nil
+}
+
+func (n *RuntimeLuaNakamaModule) walletMetadataGet(l *lua.LState) int {
+	uid := l.CheckString(1)
+	userID, err := uuid.FromString(uid)
+	if err != nil {
+		l.ArgError(1, "expects a valid user id")
+		return 0
+	}
+
+	walletMetadata, err := GetWalletMetadata(l.Context(), n.logger, n.db, userID)
+	if err != nil {
+		l.RaiseError(fmt.Sprintf("failed to get user wallet metadata: %s", err.Error()))
+		return 0
+	}
+
+	l.Push(RuntimeLuaConvertMap(l, walletMetadata))
+	return 1
 }
 
 func (n *RuntimeLuaNakamaModule) walletsUpdate(l *lua.LState) int {
@@ -4186,6 +5853,19 @@ func (n *RuntimeLuaNakamaModule) walletsUpdate(l *lua.LState) int {
 					return
 				}
 				update.Metadata = string(metadataBytes)
+			case "limits":
+				if v.Type() != lua.LTTable {
+					conversionError = true
+					l.ArgError(1, "expects limits to be table")
+					return
+				}
+				limits, err := walletLimitsFromLua(v.(*lua.LTable))
+				if err != nil {
+					conversionError = true
+					l.ArgError(1, err.Error())
+					return
+				}
+				update.Limits = limits
 			}
 		})
 
@@ -4212,14 +5892,49 @@ func (n *RuntimeLuaNakamaModule) walletsUpdate(l *lua.LState) int {
 
 	updateLedger := l.OptBool(2, false)
 
-	results, err := UpdateWallets(l.Context(), n.logger, n.db, updates, updateLedger)
-	if err != nil {
-		l.RaiseError(fmt.Sprintf("failed to update user wallet: %s", err.Error()))
+	var results []*runtime.WalletUpdateResult
+	var errs []error
+	switch mode := l.OptString(3, "atomic"); mode {
+	case "atomic":
+		// All updates succeed or fail together in a single transaction.
+		var err error
+		results, err = UpdateWallets(l.Context(), n.logger, n.db, updates, updateLedger)
+		if err != nil {
+			l.RaiseError(fmt.Sprintf("failed to update user wallet: %s", err.Error()))
+			return 0
+		}
+	case "per_user":
+		// Each update is applied independently, failures are reported per entry.
+		results, errs = UpdateWalletsPerUser(l.Context(), n.logger, n.db, updates, updateLedger)
+	default:
+		l.ArgError(3, "expects mode to be 'atomic' or 'per_user'")
+		return 0
 	}
 
+	// Only report the updates that succeeded, per user results are in the same order as the updates.
+	appliedUpdates, appliedResults := updates, results
+	if errs != nil {
+		appliedUpdates = make([]*walletUpdate, 0, len(updates))
+		appliedResults = make([]*runtime.WalletUpdateResult, 0, len(results))
+		for i, err := range errs {
+			if err == nil {
+				appliedUpdates = append(appliedUpdates, updates[i])
+				appliedResults = append(appliedResults, results[i])
+			}
+		}
+	}
+	EmitWalletUpdateEvents(l.Context(), n.logger, n.config, n.eventFn, appliedUpdates, appliedResults)
+	ledgerIDs := WalletUpdateLedgerIDs(updates, results)
+
 	resultsTable := l.CreateTable(len(results), 0)
 	for i, result := range results {
-		resultTable := l.CreateTable(0, 3)
+		resultTable := l.CreateTable(0, 5)
+		if ledgerIDs[i] != "" {
+			resultTable.RawSetString("ledger_id", lua.LString(ledgerIDs[i]))
+		}
+		if errs != nil && errs[i] != nil {
+			resultTable.RawSetString("error", lua.LString(errs[i].Error()))
+		}
 		resultTable.RawSetString("user_id", lua.LString(result.UserID))
 		if result.Previous == nil {
 			resultTable.RawSetString("previous", lua.LNil)
@@ -4336,11 +6051,101 @@ func (n *RuntimeLuaNakamaModule) walletLedgerList(l *lua.LState) int {
 	return 2
 }
 
+func (n *RuntimeLuaNakamaModule) walletLedgerTotals(l *lua.LState) int {
+	// Parse user ID.
+	uid := l.CheckString(1)
+	if uid == "" {
+		l.ArgError(1, "expects a valid user id")
+		return 0
+	}
+	userID, err := uuid.FromString(uid)
+	if err != nil {
+		l.ArgError(1, "expects a valid user id")
+		return 0
+	}
+
+	// Parse optional time range, in unix seconds.
+	var startTime, endTime time.Time
+	if start := l.OptInt64(2, 0); start < 0 {
+		l.ArgError(2, "expects start time to be >= 0")
+		return 0
+	} else if start > 0 {
+		startTime = time.Unix(start, 0).UTC()
+	}
+	if end := l.OptInt64(3, 0); end < 0 {
+		l.ArgError(3, "expects end time to be >= 0")
+		return 0
+	} else if end > 0 {
+		endTime = time.Unix(end, 0).UTC()
+	}
+
+	totals, err := WalletLedgerTotals(l.Context(), n.logger, n.db, userID, startTime, endTime)
+	if err != nil {
+		l.RaiseError(fmt.Sprintf("failed to retrieve user wallet ledger totals: %s", err.Error()))
+		return 0
+	}
+
+	totalsTable := l.CreateTable(0, len(totals))
+	for currency, total := range totals {
+		totalTable := l.CreateTable(0, 3)
+		totalTable.RawSetString("credit", lua.LNumber(total.Credit))
+		totalTable.RawSetString("debit", lua.LNumber(total.Debit))
+		totalTable.RawSetString("net", lua.LNumber(total.Net))
+		totalsTable.RawSetString(currency, totalTable)
+	}
+
+	l.Push(totalsTable)
+	return 1
+}
+
+func (n *RuntimeLuaNakamaModule) usersGetByWalletBalance(l *lua.LState) int {
+	currency := l.CheckString(1)
+	if currency == "" {
+		l.ArgError(1, "expects a currency")
+		return 0
+	}
+
+	min := l.CheckInt64(2)
+	max := l.CheckInt64(3)
+	if min > max {
+		l.ArgError(3, "expects max to be >= min")
+		return 0
+	}
+
+	limit := l.OptInt(4, 100)
+	if limit < 1 || limit > 100 {
+		l.ArgError(4, "expects limit to be 1-100")
+		return 0
+	}
+
+	cursor := l.OptString(5, "")
+
+	userIDs, newCursor, err := GetUsersByWalletBalance(l.Context(), n.logger, n.db, currency, min, max, limit, cursor)
+	if err != nil {
+		l.RaiseError(fmt.Sprintf("failed to retrieve users by wallet balance: %s", err.Error()))
+		return 0
+	}
+
+	userIDsTable := l.CreateTable(len(userIDs), 0)
+	for i, userID := range userIDs {
+		userIDsTable.RawSetInt(i+1, lua.LString(userID))
+	}
+
+	l.Push(userIDsTable)
+	if newCursor == "" {
+		l.Push(lua.LNil)
+	} else {
+		l.Push(lua.LString(newCursor))
+	}
+	return 2
+}
+
 func (n *RuntimeLuaNakamaModule) storageList(l *lua.LState) int {
 	userIDString := l.OptString(1, "")
 	collection := l.OptString(2, "")
 	limit := l.CheckInt(3)
 	cursor := l.OptString(4, "")
+	order := StorageListOrder(l.OptString(5, ""))
 
 	var userID *uuid.UUID
 	if userIDString != "" {
@@ -4352,7 +6157,14 @@ func (n *RuntimeLuaNakamaModule) storageList(l *lua.LState) int {
 		userID = &uid
 	}
 
-	objectList, _, err := StorageListObjects(l.Context(), n.logger, n.db, uuid.Nil, userID, collection, limit, cursor)
+	switch order {
+	case StorageListOrderDefault, StorageListOrderKey, StorageListOrderUpdateTime:
+	default:
+		l.ArgError(5, "expects order to be empty, 'key', or 'update_time'")
+		return 0
+	}
+
+	objectList, _, err := StorageListObjects(l.Context(), n.logger, n.db, uuid.Nil, userID, collection, limit, cursor, order)
 	if err != nil {
 		l.RaiseError(fmt.Sprintf("failed to list storage objects: %s", err.Error()))
 		return 0
@@ -4518,7 +6330,7 @@ func (n *RuntimeLuaNakamaModule) storageRead(l *lua.LState) int {
 		vt.RawSetString("update_time", lua.LNumber(v.UpdateTime.Seconds))
 
 		valueMap := make(map[string]interface{})
-		err = json.Unmarshal([]byte(v.Value), &valueMap)
+		err := json.Unmarshal([]byte(v.Value), &valueMap)
 		if err != nil {
 			l.RaiseError(fmt.Sprintf("failed to convert value to json: %s", err.Error()))
 			return 0
@@ -4682,12 +6494,29 @@ func (n *RuntimeLuaNakamaModule) storageWrite(l *lua.LState) int {
 		return 0
 	}
 
-	acks, _, err := StorageWriteObjects(l.Context(), n.logger, n.db, true, ops)
+	// Optionally summarise the result as a map of collection, key and owner to version.
+	compact := l.OptBool(2, false)
+
+	acks, _, err := StorageWriteObjects(l.Context(), n.logger, n.db, true, n.config.GetRuntime().StorageMaxObjectBytes, ops)
 	if err != nil {
 		l.RaiseError(fmt.Sprintf("failed to write storage objects: %s", err.Error()))
 		return 0
 	}
 
+	if compact {
+		versions := StorageAckVersions(acks)
+		lv := l.CreateTable(0, len(versions))
+		for collection, keys := range versions {
+			kt := l.CreateTable(0, len(keys))
+			for key, owners := range keys {
+				kt.RawSetString(key, RuntimeLuaConvertMapString(l, owners))
+			}
+			lv.RawSetString(collection, kt)
+		}
+		l.Push(lv)
+		return 1
+	}
+
 	lv := l.CreateTable(len(acks.Acks), 0)
 	for i, k := range acks.Acks {
 		kt := l.CreateTable(0, 4)
@@ -4706,6 +6535,217 @@ func (n *RuntimeLuaNakamaModule) storageWrite(l *lua.LState) int {
 	return 1
 }
 
+func (n *RuntimeLuaNakamaModule) storageWriteIf(l *lua.LState) int {
+	objectTable := l.CheckTable(1)
+
+	var userID uuid.UUID
+	d := &api.WriteStorageObject{
+		// Default to owner read and write, as with regular storage writes.
+		PermissionRead:  &wrappers.Int32Value{Value: 1},
+		PermissionWrite: &wrappers.Int32Value{Value: 1},
+	}
+	conversionError := false
+	objectTable.ForEach(func(k, v lua.LValue) {
+		if conversionError {
+			return
+		}
+
+		switch k.String() {
+		case "collection":
+			if v.Type() != lua.LTString || v.String() == "" {
+				conversionError = true
+				l.ArgError(1, "expects collection to be a non-empty string")
+				return
+			}
+			d.Collection = v.String()
+		case "key":
+			if v.Type() != lua.LTString || v.String() == "" {
+				conversionError = true
+				l.ArgError(1, "expects key to be a non-empty string")
+				return
+			}
+			d.Key = v.String()
+		case "user_id":
+			if v.Type() != lua.LTString {
+				conversionError = true
+				l.ArgError(1, "expects user_id to be string")
+				return
+			}
+			var err error
+			if userID, err = uuid.FromString(v.String()); err != nil {
+				conversionError = true
+				l.ArgError(1, "expects user_id to be a valid ID")
+				return
+			}
+		case "permission_read":
+			if v.Type() != lua.LTNumber {
+				conversionError = true
+				l.ArgError(1, "expects permission_read to be number")
+				return
+			}
+			d.PermissionRead = &wrappers.Int32Value{Value: int32(v.(lua.LNumber))}
+		case "permission_write":
+			if v.Type() != lua.LTNumber {
+				conversionError = true
+				l.ArgError(1, "expects permission_write to be number")
+				return
+			}
+			d.PermissionWrite = &wrappers.Int32Value{Value: int32(v.(lua.LNumber))}
+		}
+	})
+	if conversionError {
+		return 0
+	}
+	if d.Collection == "" {
+		l.ArgError(1, "expects collection to be supplied")
+		return 0
+	} else if d.Key == "" {
+		l.ArgError(1, "expects key to be supplied")
+		return 0
+	}
+
+	// An expected version of "*" only succeeds if the object does not exist yet.
+	d.Version = l.CheckString(2)
+	if d.Version == "" {
+		l.ArgError(2, "expects expected version to be a non-empty string")
+		return 0
+	}
+
+	valueBytes, err := json.Marshal(RuntimeLuaConvertLuaTable(l.CheckTable(3)))
+	if err != nil {
+		l.ArgError(3, fmt.Sprintf("failed to convert value: %s", err.Error()))
+		return 0
+	}
+	d.Value = string(valueBytes)
+
+	ops := StorageOpWrites{{OwnerID: userID.String(), Object: d}}
+
+	// The version check and write happen in a single conditional statement, so no separate read is needed.
+	acks, _, err := StorageWriteObjects(l.Context(), n.logger, n.db, true, n.config.GetRuntime().StorageMaxObjectBytes, ops)
+	if err != nil {
+		if err == ErrStorageRejectedVersion {
+			l.RaiseError("failed to write storage object: version mismatch")
+			return 0
+		}
+		l.RaiseError(fmt.Sprintf("failed to write storage object: %s", err.Error()))
+		return 0
+	}
+
+	l.Push(lua.LString(acks.Acks[0].Version))
+	return 1
+}
+
+func (n *RuntimeLuaNakamaModule) storagePatch(l *lua.LState) int {
+	objectTable := l.CheckTable(1)
+
+	var collection, key, version string
+	var userID uuid.UUID
+	conversionError := false
+	objectTable.ForEach(func(k, v lua.LValue) {
+		if conversionError {
+			return
+		}
+
+		switch k.String() {
+		case "collection":
+			if v.Type() != lua.LTString || v.String() == "" {
+				conversionError = true
+				l.ArgError(1, "expects collection to be a non-empty string")
+				return
+			}
+			collection = v.String()
+		case "key":
+			if v.Type() != lua.LTString || v.String() == "" {
+				conversionError = true
+				l.ArgError(1, "expects key to be a non-empty string")
+				return
+			}
+			key = v.String()
+		case "user_id":
+			if v.Type() != lua.LTString {
+				conversionError = true
+				l.ArgError(1, "expects user_id to be string")
+				return
+			}
+			var err error
+			if userID, err = uuid.FromString(v.String()); err != nil {
+				conversionError = true
+				l.ArgError(1, "expects user_id to be a valid ID")
+				return
+			}
+		case "version":
+			if v.Type() != lua.LTString {
+				conversionError = true
+				l.ArgError(1, "expects version to be string")
+				return
+			}
+			version = v.String()
+		}
+	})
+	if conversionError {
+		return 0
+	}
+	if collection == "" {
+		l.ArgError(1, "expects collection to be supplied")
+		return 0
+	} else if key == "" {
+		l.ArgError(1, "expects key to be supplied")
+		return 0
+	}
+
+	opsTable := l.CheckTable(2)
+	ops := make([]*StoragePatchOp, 0, opsTable.Len())
+	opsTable.ForEach(func(_, v lua.LValue) {
+		if conversionError {
+			return
+		}
+
+		opTable, ok := v.(*lua.LTable)
+		if !ok {
+			conversionError = true
+			l.ArgError(2, "expects a valid set of patch operations")
+			return
+		}
+		op := &StoragePatchOp{
+			Op:   opTable.RawGetString("op").String(),
+			Path: opTable.RawGetString("path").String(),
+		}
+		if from := opTable.RawGetString("from"); from != lua.LNil {
+			op.From = from.String()
+		}
+		if value := opTable.RawGetString("value"); value != lua.LNil {
+			op.Value = RuntimeLuaConvertLuaValue(value)
+			op.HasValue = true
+		}
+		if err := op.Validate(); err != nil {
+			conversionError = true
+			l.ArgError(2, err.Error())
+			return
+		}
+		ops = append(ops, op)
+	})
+	if conversionError {
+		return 0
+	}
+	if len(ops) == 0 {
+		l.ArgError(2, "expects at least one patch operation")
+		return 0
+	}
+
+	ack, _, err := StoragePatchObject(l.Context(), n.logger, n.db, n.config.GetRuntime().StorageMaxObjectBytes, userID.String(), collection, key, version, ops)
+	if err != nil {
+		if err == ErrStorageRejectedVersion {
+			l.RaiseError("failed to patch storage object: version mismatch")
+			return 0
+		}
+		l.RaiseError(fmt.Sprintf("failed to patch storage object: %s", err.Error()))
+		return 0
+	}
+
+	l.Push(lua.LString(ack.Version))
+	return 1
+}
+
 func (n *RuntimeLuaNakamaModule) storageDelete(l *lua.LState) int {
 	keysTable := l.CheckTable(1)
 	if keysTable == nil {
@@ -4821,6 +6861,64 @@ func (n *RuntimeLuaNakamaModule) storageDelete(l *lua.LState) int {
 	return 0
 }
 
+func (n *RuntimeLuaNakamaModule) storageSize(l *lua.LState) int {
+	userID, err := uuid.FromString(l.CheckString(1))
+	if err != nil {
+		l.ArgError(1, "expects a valid user ID")
+		return 0
+	}
+
+	collection := l.OptString(2, "")
+
+	size, count, err := StorageSize(l.Context(), n.logger, n.db, userID, collection)
+	if err != nil {
+		l.RaiseError(fmt.Sprintf("failed to read storage size: %s", err.Error()))
+		return 0
+	}
+
+	l.Push(lua.LNumber(size))
+	l.Push(lua.LNumber(count))
+	return 2
+}
+
+func (n *RuntimeLuaNakamaModule) validateEntitlement(l *lua.LState) int {
+	provider := l.CheckString(1)
+	if provider == "" {
+		l.ArgError(1, "expects a provider name")
+		return 0
+	}
+
+	userID, err := uuid.FromString(l.CheckString(2))
+	if err != nil {
+		l.ArgError(2, "expects a valid user ID")
+		return 0
+	}
+
+	receipt := l.CheckString(3)
+
+	if n.entitlementValidators == nil {
+		l.RaiseError(fmt.Sprintf("failed to validate entitlement: %s", ErrEntitlementProviderNotFound.Error()))
+		return 0
+	}
+	validated, err := ValidateEntitlement(l.Context(), n.logger, n.db, n.entitlementValidators, provider, userID, receipt)
+	if err != nil {
+		l.RaiseError(fmt.Sprintf("failed to validate entitlement: %s", err.Error()))
+		return 0
+	}
+
+	entitlementTable := l.CreateTable(0, 7)
+	entitlementTable.RawSetString("provider", lua.LString(validated.Provider))
+	entitlementTable.RawSetString("user_id", lua.LString(validated.UserID))
+	entitlementTable.RawSetString("product_id", lua.LString(validated.ProductID))
+	entitlementTable.RawSetString("transaction_id", lua.LString(validated.TransactionID))
+	entitlementTable.RawSetString("expiry_time", lua.LNumber(validated.ExpiryTime))
+	entitlementTable.RawSetString("metadata", RuntimeLuaConvertMap(l, validated.Metadata))
+	entitlementTable.RawSetString("validate_time", lua.LNumber(validated.ValidateTime))
+
+	l.Push(entitlementTable)
+	return 1
+}
+
 func (n *RuntimeLuaNakamaModule) multiUpdate(l *lua.LState) int {
 	// Process account update inputs.
 	var accountUpdates []*accountUpdate
@@ -5302,6 +7400,30 @@ func (n *RuntimeLuaNakamaModule) leaderboardDelete(l *lua.LState) int {
 	return 0
 }
 
+func (n *RuntimeLuaNakamaModule) leaderboardSetReset(l *lua.LState) int {
+	id := l.CheckString(1)
+	if id == "" {
+		l.ArgError(1, "expects a leaderboard ID string")
+		return 0
+	}
+
+	resetSchedule := l.OptString(2, "")
+	if resetSchedule != "" {
+		if _, err := cronexpr.Parse(resetSchedule); err != nil {
+			l.ArgError(2, "expects reset schedule to be a valid CRON expression")
+			return 0
+		}
+	}
+
+	if _, err := n.leaderboardCache.SetResetSchedule(l.Context(), id, resetSchedule); err != nil {
+		l.RaiseError("error setting leaderboard reset schedule: %v", err.Error())
+		return 0
+	}
+
+	n.leaderboardScheduler.Update()
+	return 0
+}
+
 func (n *RuntimeLuaNakamaModule) leaderboardRecordsList(l *lua.LState) int {
 	id := l.CheckString(1)
 	if id == "" {
@@ -5348,21 +7470,113 @@ func (n *RuntimeLuaNakamaModule) leaderboardRecordsList(l *lua.LState) int {
 		l.ArgError(3, "expects limit to be 0-10000")
 		return 0
 	}
-	var limit *wrappers.Int32Value
-	if limitNumber != 0 {
-		limit = &wrappers.Int32Value{Value: int32(limitNumber)}
-	}
+	var limit *wrappers.Int32Value
+	if limitNumber != 0 {
+		limit = &wrappers.Int32Value{Value: int32(limitNumber)}
+	}
+
+	cursor := l.OptString(4, "")
+	overrideExpiry := l.OptInt64(5, 0)
+
+	records, err := LeaderboardRecordsList(l.Context(), n.logger, n.db, n.leaderboardCache, n.rankCache, id, limit, cursor, ownerIds, overrideExpiry)
+	if err != nil {
+		l.RaiseError("error listing leaderboard records: %v", err.Error())
+		return 0
+	}
+
+	return leaderboardRecordsToLua(l, records.Records, records.OwnerRecords, records.PrevCursor, records.NextCursor)
+}
+
+func (n *RuntimeLuaNakamaModule) leaderboardRecordsListExpired(l *lua.LState) int {
+	id := l.CheckString(1)
+	if id == "" {
+		l.ArgError(1, "expects a leaderboard ID string")
+		return 0
+	}
+
+	expiry := l.CheckInt64(2)
+	if expiry <= 0 {
+		l.ArgError(2, "expects expiry to be greater than 0")
+		return 0
+	}
+
+	limit := l.OptInt(3, 100)
+	if limit < 1 || limit > 100 {
+		l.ArgError(3, "expects limit to be 1-100")
+		return 0
+	}
+
+	cursor := l.OptString(4, "")
+
+	records, err := LeaderboardRecordsListExpired(l.Context(), n.logger, n.db, n.leaderboardCache, n.rankCache, id, expiry, limit, cursor)
+	if err != nil {
+		l.RaiseError("error listing expired leaderboard records: %v", err.Error())
+		return 0
+	}
+
+	l.Push(leaderboardRecordListToLua(l, records.Records))
+	if records.NextCursor != "" {
+		l.Push(lua.LString(records.NextCursor))
+	} else {
+		l.Push(lua.LNil)
+	}
+	if records.PrevCursor != "" {
+		l.Push(lua.LString(records.PrevCursor))
+	} else {
+		l.Push(lua.LNil)
+	}
+	return 3
+}
+
+func (n *RuntimeLuaNakamaModule) leaderboardRecordsSample(l *lua.LState) int {
+	id := l.CheckString(1)
+	if id == "" {
+		l.ArgError(1, "expects a leaderboard ID string")
+		return 0
+	}
+
+	count := l.OptInt(2, 10)
+	if count < 1 || count > 100 {
+		l.ArgError(2, "expects count to be 1-100")
+		return 0
+	}
+
+	records, err := LeaderboardRecordsSample(l.Context(), n.logger, n.db, n.leaderboardCache, n.rankCache, id, count)
+	if err != nil {
+		l.RaiseError("error sampling leaderboard records: %v", err.Error())
+		return 0
+	}
+
+	l.Push(leaderboardRecordListToLua(l, records))
+	return 1
+}
+
+func (n *RuntimeLuaNakamaModule) leaderboardRecordsAroundScore(l *lua.LState) int {
+	id := l.CheckString(1)
+	if id == "" {
+		l.ArgError(1, "expects a leaderboard ID string")
+		return 0
+	}
+
+	score := l.CheckInt64(2)
+	subscore := l.OptInt64(3, 0)
+
+	limit := l.OptInt(4, 10)
+	if limit < 1 || limit > 100 {
+		l.ArgError(4, "expects limit to be 1-100")
+		return 0
+	}
 
-	cursor := l.OptString(4, "")
 	overrideExpiry := l.OptInt64(5, 0)
 
-	records, err := LeaderboardRecordsList(l.Context(), n.logger, n.db, n.leaderboardCache, n.rankCache, id, limit, cursor, ownerIds, overrideExpiry)
+	records, err := LeaderboardRecordsAroundScore(l.Context(), n.logger, n.db, n.leaderboardCache, n.rankCache, id, score, subscore, limit, overrideExpiry)
 	if err != nil {
-		l.RaiseError("error listing leaderboard records: %v", err.Error())
+		l.RaiseError("error listing leaderboard records around score: %v", err.Error())
 		return 0
 	}
 
-	return leaderboardRecordsToLua(l, records.Records, records.OwnerRecords, records.PrevCursor, records.NextCursor)
+	l.Push(leaderboardRecordListToLua(l, records))
+	return 1
 }
 
 func (n *RuntimeLuaNakamaModule) leaderboardRecordWrite(l *lua.LState) int {
@@ -5380,15 +7594,21 @@ func (n *RuntimeLuaNakamaModule) leaderboardRecordWrite(l *lua.LState) int {
 
 	username := l.OptString(3, "")
 
-	score := l.OptInt64(4, 0)
+	score, ok := optScoreFromLua(l, 4, "score")
+	if !ok {
+		return 0
+	}
 	if score < 0 {
 		l.ArgError(4, "expects score to be >= 0")
 		return 0
 	}
 
-	subscore := l.OptInt64(5, 0)
+	subscore, ok := optScoreFromLua(l, 5, "subscore")
+	if !ok {
+		return 0
+	}
 	if subscore < 0 {
-		l.ArgError(4, "expects subscore to be >= 0")
+		l.ArgError(5, "expects subscore to be >= 0")
 		return 0
 	}
 
@@ -5462,6 +7682,46 @@ func (n *RuntimeLuaNakamaModule) leaderboardRecordDelete(l *lua.LState) int {
 	return 0
 }
 
+func (n *RuntimeLuaNakamaModule) leaderboardRecordsDelete(l *lua.LState) int {
+	id := l.CheckString(1)
+	if id == "" {
+		l.ArgError(1, "expects a leaderboard ID string")
+		return 0
+	}
+
+	ownerIDsTable := l.CheckTable(2)
+	if ownerIDsTable.Len() > LeaderboardRecordsDeleteMaxOwners {
+		l.ArgError(2, fmt.Sprintf("expects at most %v owner IDs", LeaderboardRecordsDeleteMaxOwners))
+		return 0
+	}
+	ownerIDs := make([]string, 0, ownerIDsTable.Len())
+	conversionError := false
+	ownerIDsTable.ForEach(func(k, v lua.LValue) {
+		if conversionError {
+			return
+		}
+		if v.Type() != lua.LTString {
+			conversionError = true
+			l.ArgError(2, "expects owner IDs to be strings")
+			return
+		}
+		if _, err := uuid.FromString(v.String()); err != nil {
+			conversionError = true
+			l.ArgError(2, "expects owner IDs to be valid identifiers")
+			return
+		}
+		ownerIDs = append(ownerIDs, v.String())
+	})
+	if conversionError {
+		return 0
+	}
+
+	if err := LeaderboardRecordsDelete(l.Context(), n.logger, n.db, n.leaderboardCache, n.rankCache, uuid.Nil, id, ownerIDs); err != nil {
+		l.RaiseError("error deleting leaderboard records: %v", err.Error())
+	}
+	return 0
+}
+
 func (n *RuntimeLuaNakamaModule) tournamentCreate(l *lua.LState) int {
 	id := l.CheckString(1)
 	if id == "" {
@@ -5591,10 +7851,14 @@ func (n *RuntimeLuaNakamaModule) tournamentAddAttempt(l *lua.LState) int {
 		return 0
 	}
 
-	if err := TournamentAddAttempt(l.Context(), n.logger, n.db, n.leaderboardCache, id, owner, count); err != nil {
+	maxNumScore, err := TournamentAddAttempt(l.Context(), n.logger, n.db, n.leaderboardCache, id, owner, count)
+	if err != nil {
 		l.RaiseError("error adding tournament attempts: %v", err.Error())
+		return 0
 	}
-	return 0
+
+	l.Push(lua.LNumber(maxNumScore))
+	return 1
 }
 
 func (n *RuntimeLuaNakamaModule) tournamentJoin(l *lua.LState) int {
@@ -5619,8 +7883,40 @@ func (n *RuntimeLuaNakamaModule) tournamentJoin(l *lua.LState) int {
 		return 0
 	}
 
+	// Optionally waitlist the user instead of rejecting them when the tournament is full.
+	if l.OptBool(4, false) {
+		status, err := TournamentJoinWaitlist(l.Context(), n.logger, n.db, n.leaderboardCache, userID, username, id)
+		if err != nil {
+			l.RaiseError("error joining tournament: %v", err.Error())
+			return 0
+		}
+		l.Push(lua.LString(status.String()))
+		return 1
+	}
+
 	if err := TournamentJoin(l.Context(), n.logger, n.db, n.leaderboardCache, userID, username, id); err != nil {
 		l.RaiseError("error joining tournament: %v", err.Error())
+		return 0
+	}
+	l.Push(lua.LString(TournamentJoinStatusJoined.String()))
+	return 1
+}
+
+func (n *RuntimeLuaNakamaModule) tournamentLeave(l *lua.LState) int {
+	id := l.CheckString(1)
+	if id == "" {
+		l.ArgError(1, "expects a tournament ID string")
+		return 0
+	}
+
+	userID := l.CheckString(2)
+	if _, err := uuid.FromString(userID); err != nil {
+		l.ArgError(2, "expects user ID to be a valid identifier")
+		return 0
+	}
+
+	if err := TournamentLeave(l.Context(), n.logger, n.db, n.leaderboardCache, n.rankCache, userID, id); err != nil {
+		l.RaiseError("error leaving tournament: %v", err.Error())
 	}
 	return 0
 }
@@ -5772,44 +8068,48 @@ func (n *RuntimeLuaNakamaModule) tournamentRecordsList(l *lua.LState) int {
 }
 
 func leaderboardRecordsToLua(l *lua.LState, records []*api.LeaderboardRecord, ownerRecords []*api.LeaderboardRecord, prevCursor, nextCursor string) int {
-	recordsTable := l.CreateTable(len(records), 0)
-	for i, record := range records {
-		recordTable := l.CreateTable(0, 11)
-		recordTable.RawSetString("leaderboard_id", lua.LString(record.LeaderboardId))
-		recordTable.RawSetString("owner_id", lua.LString(record.OwnerId))
-		if record.Username != nil {
-			recordTable.RawSetString("username", lua.LString(record.Username.Value))
-		} else {
-			recordTable.RawSetString("username", lua.LNil)
-		}
-		recordTable.RawSetString("score", lua.LNumber(record.Score))
-		recordTable.RawSetString("subscore", lua.LNumber(record.Subscore))
-		recordTable.RawSetString("num_score", lua.LNumber(record.NumScore))
-
-		metadataMap := make(map[string]interface{})
-		err := json.Unmarshal([]byte(record.Metadata), &metadataMap)
-		if err != nil {
-			l.RaiseError(fmt.Sprintf("failed to convert metadata to json: %s", err.Error()))
-			return 0
-		}
-		metadataTable := RuntimeLuaConvertMap(l, metadataMap)
-		recordTable.RawSetString("metadata", metadataTable)
+	recordsTable := leaderboardRecordListToLua(l, records)
+	ownerRecordsTable := leaderboardRecordListToLua(l, ownerRecords)
 
-		recordTable.RawSetString("create_time", lua.LNumber(record.CreateTime.Seconds))
-		recordTable.RawSetString("update_time", lua.LNumber(record.UpdateTime.Seconds))
-		if record.ExpiryTime != nil {
-			recordTable.RawSetString("expiry_time", lua.LNumber(record.ExpiryTime.Seconds))
-		} else {
-			recordTable.RawSetString("expiry_time", lua.LNil)
-		}
+	l.Push(recordsTable)
+	l.Push(ownerRecordsTable)
+	if nextCursor != "" {
+		l.Push(lua.LString(nextCursor))
+	} else {
+		l.Push(lua.LNil)
+	}
+	if prevCursor != "" {
+		l.Push(lua.LString(prevCursor))
+	} else {
+		l.Push(lua.LNil)
+	}
 
-		recordTable.RawSetString("rank", lua.LNumber(record.Rank))
+	return 4
+}
 
-		recordsTable.RawSetInt(i+1, recordTable)
+// optScoreFromLua reads an optional score or subscore argument. Lua numbers outside the int64 range are rejected
+// rather than wrapping around when converted.
+func optScoreFromLua(l *lua.LState, n int, name string) (int64, bool) {
+	v := l.Get(n)
+	if v == lua.LNil {
+		return 0, true
+	}
+	number, ok := v.(lua.LNumber)
+	if !ok {
+		l.ArgError(n, fmt.Sprintf("expects %v to be a number", name))
+		return 0, false
+	}
+	// The upper bound converts to exactly 2^63, which is itself out of range.
+	if f := float64(number); math.IsNaN(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		l.ArgError(n, fmt.Sprintf("expects %v to be between %v and %v", name, int64(math.MinInt64), int64(math.MaxInt64)))
+		return 0, false
 	}
+	return int64(number), true
+}
 
-	ownerRecordsTable := l.CreateTable(len(ownerRecords), 0)
-	for i, record := range ownerRecords {
+func leaderboardRecordListToLua(l *lua.LState, records []*api.LeaderboardRecord) *lua.LTable {
+	recordsTable := l.CreateTable(len(records), 0)
+	for i, record := range records {
 		recordTable := l.CreateTable(0, 11)
 		recordTable.RawSetString("leaderboard_id", lua.LString(record.LeaderboardId))
 		recordTable.RawSetString("owner_id", lua.LString(record.OwnerId))
@@ -5826,7 +8126,7 @@ func leaderboardRecordsToLua(l *lua.LState, records []*api.LeaderboardRecord, ow
 		err := json.Unmarshal([]byte(record.Metadata), &metadataMap)
 		if err != nil {
 			l.RaiseError(fmt.Sprintf("failed to convert metadata to json: %s", err.Error()))
-			return 0
+			return nil
 		}
 		metadataTable := RuntimeLuaConvertMap(l, metadataMap)
 		recordTable.RawSetString("metadata", metadataTable)
@@ -5841,23 +8141,9 @@ func leaderboardRecordsToLua(l *lua.LState, records []*api.LeaderboardRecord, ow
 
 		recordTable.RawSetString("rank", lua.LNumber(record.Rank))
 
-		ownerRecordsTable.RawSetInt(i+1, recordTable)
-	}
-
-	l.Push(recordsTable)
-	l.Push(ownerRecordsTable)
-	if nextCursor != "" {
-		l.Push(lua.LString(nextCursor))
-	} else {
-		l.Push(lua.LNil)
-	}
-	if prevCursor != "" {
-		l.Push(lua.LString(prevCursor))
-	} else {
-		l.Push(lua.LNil)
+		recordsTable.RawSetInt(i+1, recordTable)
 	}
-
-	return 4
+	return recordsTable
 }
 
 func (n *RuntimeLuaNakamaModule) tournamentList(l *lua.LState) int {
@@ -5982,8 +8268,14 @@ func (n *RuntimeLuaNakamaModule) tournamentRecordWrite(l *lua.LState) int {
 
 	username := l.OptString(3, "")
 
-	score := l.OptInt64(4, 0)
-	subscore := l.OptInt64(5, 0)
+	score, ok := optScoreFromLua(l, 4, "score")
+	if !ok {
+		return 0
+	}
+	subscore, ok := optScoreFromLua(l, 5, "subscore")
+	if !ok {
+		return 0
+	}
 
 	metadata := l.OptTable(6, nil)
 	metadataStr := ""
@@ -6036,6 +8328,25 @@ func (n *RuntimeLuaNakamaModule) tournamentRecordWrite(l *lua.LState) int {
 	return 1
 }
 
+func (n *RuntimeLuaNakamaModule) tournamentRecordDelete(l *lua.LState) int {
+	id := l.CheckString(1)
+	if id == "" {
+		l.ArgError(1, "expects a tournament ID string")
+		return 0
+	}
+
+	ownerID := l.CheckString(2)
+	if _, err := uuid.FromString(ownerID); err != nil {
+		l.ArgError(2, "expects owner ID to be a valid identifier")
+		return 0
+	}
+
+	if err := TournamentRecordDelete(l.Context(), n.logger, n.db, n.leaderboardCache, n.rankCache, id, ownerID); err != nil {
+		l.RaiseError("error deleting tournament record: %v", err.Error())
+	}
+	return 0
+}
+
 func (n *RuntimeLuaNakamaModule) tournamentRecordsHaystack(l *lua.LState) int {
 	id := l.CheckString(1)
 	if id == "" {
@@ -6149,6 +8460,40 @@ func (n *RuntimeLuaNakamaModule) groupsGetId(l *lua.LState) int {
 		return 0
 	}
 
+	groupsTable, err := groupsToLuaTable(l, groups)
+	if err != nil {
+		l.RaiseError(fmt.Sprintf("failed to convert metadata to json: %s", err.Error()))
+		return 0
+	}
+
+	l.Push(groupsTable)
+	return 1
+}
+
+func (n *RuntimeLuaNakamaModule) groupsGetRandom(l *lua.LState) int {
+	count := l.OptInt(1, 10)
+	if count < 1 || count > 100 {
+		l.ArgError(1, "expects count to be 1-100")
+		return 0
+	}
+
+	groups, err := GetRandomGroups(l.Context(), n.logger, n.db, count)
+	if err != nil {
+		l.RaiseError(fmt.Sprintf("failed to get groups: %s", err.Error()))
+		return 0
+	}
+
+	groupsTable, err := groupsToLuaTable(l, groups)
+	if err != nil {
+		l.RaiseError(fmt.Sprintf("failed to convert metadata to json: %s", err.Error()))
+		return 0
+	}
+
+	l.Push(groupsTable)
+	return 1
+}
+
+func groupsToLuaTable(l *lua.LState, groups []*api.Group) (*lua.LTable, error) {
 	groupsTable := l.CreateTable(len(groups), 0)
 	for i, g := range groups {
 		gt := l.CreateTable(0, 12)
@@ -6165,19 +8510,15 @@ func (n *RuntimeLuaNakamaModule) groupsGetId(l *lua.LState) int {
 		gt.RawSetString("update_time", lua.LNumber(g.UpdateTime.Seconds))
 
 		metadataMap := make(map[string]interface{})
-		err = json.Unmarshal([]byte(g.Metadata), &metadataMap)
-		if err != nil {
-			l.RaiseError(fmt.Sprintf("failed to convert metadata to json: %s", err.Error()))
-			return 0
+		if err := json.Unmarshal([]byte(g.Metadata), &metadataMap); err != nil {
+			return nil, err
 		}
 		metadataTable := RuntimeLuaConvertMap(l, metadataMap)
 		gt.RawSetString("metadata", metadataTable)
 
 		groupsTable.RawSetInt(i+1, gt)
 	}
-
-	l.Push(groupsTable)
-	return 1
+	return groupsTable, nil
 }
 
 func (n *RuntimeLuaNakamaModule) groupCreate(l *lua.LState) int {
@@ -6394,6 +8735,23 @@ func (n *RuntimeLuaNakamaModule) groupUsersKick(l *lua.LState) int {
 	return 0
 }
 
+func (n *RuntimeLuaNakamaModule) groupCount(l *lua.LState) int {
+	groupID, err := uuid.FromString(l.CheckString(1))
+	if err != nil {
+		l.ArgError(1, "expects group ID to be a valid identifier")
+		return 0
+	}
+
+	count, err := GetGroupEdgeCount(l.Context(), n.logger, n.db, groupID)
+	if err != nil {
+		l.RaiseError("error getting group count: %v", err.Error())
+		return 0
+	}
+
+	l.Push(lua.LNumber(count))
+	return 1
+}
+
 func (n *RuntimeLuaNakamaModule) groupUsersList(l *lua.LState) int {
 	groupID, err := uuid.FromString(l.CheckString(1))
 	if err != nil {
@@ -6419,12 +8777,20 @@ func (n *RuntimeLuaNakamaModule) groupUsersList(l *lua.LState) int {
 
 	cursor := l.OptString(4, "")
 
+	// Presence lookups require a tracker check per member, so are only done when requested.
+	includePresences := l.OptBool(5, false)
+
 	res, err := ListGroupUsers(l.Context(), n.logger, n.db, n.tracker, groupID, limit, stateWrapper, cursor)
 	if err != nil {
 		l.RaiseError("error while trying to list users in a group: %v", err.Error())
 		return 0
 	}
 
+	var presences map[string][]*Presence
+	if includePresences {
+		presences = ListGroupUsersPresences(n.tracker, res.GroupUsers)
+	}
+
 	groupUsers := l.CreateTable(len(res.GroupUsers), 0)
 	for i, ug := range res.GroupUsers {
 		u := ug.User
@@ -6469,6 +8835,19 @@ func (n *RuntimeLuaNakamaModule) groupUsersList(l *lua.LState) int {
 		metadataTable := RuntimeLuaConvertMap(l, metadataMap)
 		ut.RawSetString("metadata", metadataTable)
 
+		if includePresences {
+			userPresences := presences[u.Id]
+			presencesTable := l.CreateTable(len(userPresences), 0)
+			for j, p := range userPresences {
+				presenceTable := l.CreateTable(0, 3)
+				presenceTable.RawSetString("session_id", lua.LString(p.ID.SessionID.String()))
+				presenceTable.RawSetString("node", lua.LString(p.ID.Node))
+				presenceTable.RawSetString("status", lua.LString(p.Meta.Status))
+				presencesTable.RawSetInt(j+1, presenceTable)
+			}
+			ut.RawSetString("presences", presencesTable)
+		}
+
 		gt := l.CreateTable(0, 2)
 		gt.RawSetString("user", ut)
 		gt.RawSetString("state", lua.LNumber(ug.State.Value))
@@ -6632,9 +9011,25 @@ func (n *RuntimeLuaNakamaModule) accountDeleteId(l *lua.LState) int {
 		return 0
 	}
 
-	recorded := l.OptBool(2, false)
+	// Either a recorded flag, or a table of options controlling what is deleted along with the account.
+	options := NewDeleteAccountOptions(false)
+	switch v := l.Get(2); v.Type() {
+	case lua.LTNil:
+	case lua.LTBool:
+		options.Recorded = lua.LVAsBool(v)
+	case lua.LTTable:
+		optionsTable := v.(*lua.LTable)
+		options.Storage = getBoolField(l, optionsTable, "storage", true)
+		options.Wallet = getBoolField(l, optionsTable, "wallet", true)
+		options.LeaderboardRecords = getBoolField(l, optionsTable, "leaderboard_records", true)
+		options.GroupMemberships = getBoolField(l, optionsTable, "group_memberships", true)
+		options.Recorded = getBoolField(l, optionsTable, "recorded", false)
+	default:
+		l.ArgError(2, "expects recorded boolean or options table")
+		return 0
+	}
 
-	if err := DeleteAccount(l.Context(), n.logger, n.db, userID, recorded); err != nil {
+	if err := DeleteAccountWithOptions(l.Context(), n.logger, n.db, userID, options); err != nil {
 		l.RaiseError("error while trying to delete account: %v", err.Error())
 	}
 
@@ -6664,6 +9059,76 @@ func (n *RuntimeLuaNakamaModule) accountExportId(l *lua.LState) int {
 	return 1
 }
 
+func (n *RuntimeLuaNakamaModule) accountExportToStorage(l *lua.LState) int {
+	userID, err := uuid.FromString(l.CheckString(1))
+	if err != nil {
+		l.ArgError(1, "expects user ID to be a valid identifier")
+		return 0
+	}
+	collection := l.CheckString(2)
+	if collection == "" {
+		l.ArgError(2, "expects collection string")
+		return 0
+	}
+	key := l.CheckString(3)
+	if key == "" {
+		l.ArgError(3, "expects key string")
+		return 0
+	}
+	// An explicit max size may only narrow the configured storage object size limit.
+	maxSize := n.config.GetRuntime().StorageMaxObjectBytes
+	if size := l.OptInt(4, 0); size < 0 {
+		l.ArgError(4, "expects max size to be >= 0")
+		return 0
+	} else if size > 0 && (maxSize == 0 || size < maxSize) {
+		maxSize = size
+	}
+	if maxSize == 0 || maxSize > accountExportToStorageMaxBytes {
+		maxSize = accountExportToStorageMaxBytes
+	}
+
+	ack, err := ExportAccountToStorage(l.Context(), n.logger, n.db, n.jsonpbMarshaler, userID, collection, key, maxSize)
+	if err != nil {
+		if err == ErrAccountExportTooLarge {
+			l.RaiseError("error exporting account: exceeds max size of %v bytes", maxSize)
+			return 0
+		}
+		l.RaiseError("error exporting account: %v", err.Error())
+		return 0
+	}
+
+	kt := l.CreateTable(0, 4)
+	kt.RawSetString("key", lua.LString(ack.Key))
+	kt.RawSetString("collection", lua.LString(ack.Collection))
+	kt.RawSetString("user_id", lua.LNil)
+	kt.RawSetString("version", lua.LString(ack.Version))
+
+	l.Push(kt)
+	return 1
+}
+
+func (n *RuntimeLuaNakamaModule) friendsCount(l *lua.LState) int {
+	userID, err := uuid.FromString(l.CheckString(1))
+	if err != nil {
+		l.ArgError(1, "expects user ID to be a valid identifier")
+		return 0
+	}
+
+	counts, err := CountFriends(l.Context(), n.logger, n.db, userID)
+	if err != nil {
+		l.RaiseError("error while trying to count friends for a user: %v", err.Error())
+		return 0
+	}
+
+	countsTable := l.CreateTable(0, 4)
+	countsTable.RawSetString("friends", lua.LNumber(counts.Friends))
+	countsTable.RawSetString("invite_sent", lua.LNumber(counts.InviteSent))
+	countsTable.RawSetString("invite_received", lua.LNumber(counts.InviteReceived))
+	countsTable.RawSetString("blocked", lua.LNumber(counts.Blocked))
+	l.Push(countsTable)
+	return 1
+}
+
 func (n *RuntimeLuaNakamaModule) friendsList(l *lua.LState) int {
 	userID, err := uuid.FromString(l.CheckString(1))
 	if err != nil {
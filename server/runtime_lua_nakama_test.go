@@ -0,0 +1,196 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/heroiclabs/nakama/v2/internal/gopher-lua"
+	"github.com/stretchr/testify/assert"
+)
+
+// Load the nakama module into a fresh Lua state, for calling module functions directly without a runtime or RPC.
+func newRuntimeLuaNakamaTestState(nakamaModule *RuntimeLuaNakamaModule) *lua.LState {
+	vm := lua.NewState()
+	vm.PreloadModule("nakama", nakamaModule.Loader)
+	return vm
+}
+
+func TestRuntimeLuaNakamaMatchOpCodeDispatch(t *testing.T) {
+	nakamaModule := NewRuntimeLuaNakamaModule(logger, nil, nil, nil, cfg, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+
+	// Without handlers the state passes through untouched.
+	vm := newRuntimeLuaNakamaTestState(nakamaModule)
+	defer vm.Close()
+	if err := vm.DoString(`
+local nk = require("nakama")
+local state = {moves = 0}
+assert(nk.match_op_code_dispatch({}, {}, 0, state, {{op_code = 1, data = ""}}) == state)
+`); err != nil {
+		t.Fatalf("error dispatching without handlers: %v", err)
+	}
+
+	vm = newRuntimeLuaNakamaTestState(nakamaModule)
+	defer vm.Close()
+	if err := vm.DoString(`
+local nk = require("nakama")
+nk.match_op_code_handler(1, function(context, dispatcher, tick, state, message)
+	state.moves = state.moves + tick
+	return state
+end)
+nk.match_op_code_handler(2, function(context, dispatcher, tick, state, message)
+	state.chat = state.chat .. message.data
+	return state
+end)
+nk.match_op_code_handler(9, function(context, dispatcher, tick, state, message)
+	return nil
+end)
+
+-- Messages are dispatched in order, and messages without a handler are skipped.
+local messages = {
+	{op_code = 1, data = ""},
+	{op_code = 2, data = "hello"},
+	{op_code = 3, data = "unhandled"},
+	{op_code = 1, data = ""},
+	{op_code = 2, data = "world"}
+}
+local state = nk.match_op_code_dispatch({}, {}, 5, {moves = 0, chat = ""}, messages)
+assert(state.moves == 10, "expected both moves to be handled")
+assert(state.chat == "helloworld", "expected chat to be handled in order")
+assert(#messages == 5, "expected raw messages to be untouched")
+
+-- A handler returning nil ends the match, and later messages are not dispatched.
+state = nk.match_op_code_dispatch({}, {}, 1, {moves = 0, chat = ""}, {{op_code = 9, data = ""}, {op_code = 1, data = ""}})
+assert(state == nil, "expected dispatch to stop on a nil state")
+`); err != nil {
+		t.Fatalf("error dispatching to handlers: %v", err)
+	}
+
+	// Op codes must be non-negative and messages must carry one.
+	if err := vm.DoString(`require("nakama").match_op_code_handler(-1, function() end)`); err == nil {
+		t.Fatal("expected negative op code to be rejected")
+	}
+	if err := vm.DoString(`require("nakama").match_op_code_dispatch({}, {}, 0, {}, {{data = ""}})`); err == nil {
+		t.Fatal("expected message without op code to be rejected")
+	}
+}
+
+func TestRuntimeLuaNakamaConfigGet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nakama_runtime_lua_config_get")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "settings.json")
+	if err := ioutil.WriteFile(path, []byte(`{"rounds": 3, "modes": ["ffa", "teams"]}`), 0644); err != nil {
+		t.Fatalf("error writing config file: %v", err)
+	}
+
+	config := NewConfig(logger)
+	config.Runtime.Path = dir
+	configFileCache := NewRuntimeConfigFileCache(time.Hour)
+	newModule := func() *RuntimeLuaNakamaModule {
+		return NewRuntimeLuaNakamaModule(logger, nil, nil, nil, config, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, configFileCache, nil, nil, nil, nil, nil, nil)
+	}
+
+	vm := newRuntimeLuaNakamaTestState(newModule())
+	defer vm.Close()
+	if err := vm.DoString(`
+local nk = require("nakama")
+local settings = nk.config_get("settings.json")
+assert(settings.rounds == 3, "expected rounds")
+assert(settings.modes[2] == "teams", "expected modes")
+
+-- Paths are resolved within the runtime path.
+assert(nk.config_get("../../settings.json").rounds == 3, "expected path to stay within the runtime path")
+`); err != nil {
+		t.Fatalf("error reading config file: %v", err)
+	}
+	if err := vm.DoString(`require("nakama").config_get("missing.json")`); err == nil {
+		t.Fatal("expected missing config file to be rejected")
+	}
+
+	// Other VMs share the same cache, so the file is not read again until the check interval passes.
+	if err := ioutil.WriteFile(path, []byte(`{"rounds": 5}`), 0644); err != nil {
+		t.Fatalf("error writing config file: %v", err)
+	}
+	vm = newRuntimeLuaNakamaTestState(newModule())
+	defer vm.Close()
+	if err := vm.DoString(`assert(require("nakama").config_get("settings.json").rounds == 3, "expected cached config")`); err != nil {
+		t.Fatalf("error reading cached config file: %v", err)
+	}
+}
+
+func TestRuntimeLuaNakamaAccountGetByEmailValidation(t *testing.T) {
+	nakamaModule := NewRuntimeLuaNakamaModule(logger, nil, nil, nil, cfg, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	vm := newRuntimeLuaNakamaTestState(nakamaModule)
+	defer vm.Close()
+
+	if err := vm.DoString(`require("nakama").account_get_by_email("not an email")`); err == nil || !strings.Contains(err.Error(), "expects a valid email") {
+		t.Fatalf("expected invalid email to be rejected, got: %v", err)
+	}
+
+	// Short addresses are valid, so the lookup gets past validation.
+	if err := vm.DoString(`require("nakama").account_get_by_email("a@b.co")`); err != nil && strings.Contains(err.Error(), "expects a valid email") {
+		t.Fatalf("expected short email to be accepted: %v", err)
+	}
+}
+
+func TestRuntimeLuaNakamaSessionVarsUpdate(t *testing.T) {
+	sessionRegistry := NewLocalSessionRegistry(metrics)
+	session := newTestSession()
+	session.vars = map[string]string{"tier": "free"}
+	sessionRegistry.Add(session)
+	defer sessionRegistry.Remove(session.ID())
+
+	nakamaModule := NewRuntimeLuaNakamaModule(logger, nil, nil, nil, cfg, nil, nil, nil, nil, sessionRegistry, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	vm := newRuntimeLuaNakamaTestState(nakamaModule)
+	defer vm.Close()
+	vm.SetGlobal("session_id", lua.LString(session.ID().String()))
+
+	// The live session sees the new vars, and no token is issued unless asked for.
+	if err := vm.DoString(`assert(require("nakama").session_vars_update(session_id, {tier = "gold"}) == nil, "expected no token")`); err != nil {
+		t.Fatalf("error updating session vars: %v", err)
+	}
+	assert.Equal(t, map[string]string{"tier": "gold"}, session.Vars())
+
+	// A refreshed token carries the new vars.
+	if err := vm.DoString(`token, exp = require("nakama").session_vars_update(session_id, {tier = "platinum"}, true)`); err != nil {
+		t.Fatalf("error updating session vars: %v", err)
+	}
+	sessionCache := NewLocalSessionCache(cfg)
+	defer sessionCache.Stop()
+	userID, _, vars, exp, ok := parseToken([]byte(cfg.GetSession().EncryptionKey), sessionCache, vm.GetGlobal("token").String())
+	if assert.True(t, ok) {
+		assert.Equal(t, session.UserID(), userID)
+		assert.Equal(t, map[string]string{"tier": "platinum"}, vars)
+		assert.Equal(t, lua.LNumber(exp), vm.GetGlobal("exp"))
+	}
+
+	// Unknown sessions and non-string vars are rejected.
+	if err := vm.DoString(`require("nakama").session_vars_update("` + uuid.Must(uuid.NewV4()).String() + `", {tier = "gold"})`); err == nil {
+		t.Fatal("expected unknown session to be rejected")
+	}
+	if err := vm.DoString(`require("nakama").session_vars_update(session_id, {tier = 1})`); err == nil {
+		t.Fatal("expected non-string vars to be rejected")
+	}
+	assert.Equal(t, map[string]string{"tier": "platinum"}, session.Vars())
+}
@@ -18,11 +18,14 @@ import (
 	"context"
 	"errors"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"fmt"
 
@@ -30,6 +33,8 @@ import (
 	"github.com/golang/protobuf/ptypes/empty"
 	"github.com/heroiclabs/nakama-common/api"
 	"github.com/heroiclabs/nakama-common/rtapi"
+	"github.com/heroiclabs/nakama/v2/internal/gopher-lua"
+	"go.uber.org/atomic"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -77,7 +82,7 @@ func runtimeWithModules(t *testing.T, modules map[string]string) (*Runtime, erro
 	cfg := NewConfig(logger)
 	cfg.Runtime.Path = dir
 
-	return NewRuntime(logger, logger, NewDB(t), jsonpbMarshaler, jsonpbUnmarshaler, cfg, nil, nil, nil, nil, nil, nil, nil, metrics, nil, &DummyMessageRouter{})
+	return NewRuntime(logger, logger, NewDB(t), jsonpbMarshaler, jsonpbUnmarshaler, cfg, nil, nil, nil, nil, nil, nil, nil, nil, nil, metrics, nil, &DummyMessageRouter{}, nil)
 }
 
 func TestRuntimeSampleScript(t *testing.T) {
@@ -355,7 +360,7 @@ nakama.register_rpc(test.printWorld, "helloworld")`,
 
 	db := NewDB(t)
 	pipeline := NewPipeline(logger, cfg, db, jsonpbMarshaler, jsonpbUnmarshaler, nil, nil, nil, nil, nil, runtime)
-	apiServer := StartApiServer(logger, logger, db, jsonpbMarshaler, jsonpbUnmarshaler, cfg, nil, nil, nil, nil, nil, nil, nil, nil, metrics, pipeline, runtime)
+	apiServer := StartApiServer(logger, logger, db, jsonpbMarshaler, jsonpbUnmarshaler, cfg, nil, nil, nil, nil, NewLocalSessionCache(cfg), nil, nil, nil, nil, metrics, pipeline, runtime)
 	defer apiServer.Stop()
 
 	payload := "\"Hello World\""
@@ -408,6 +413,93 @@ nakama.register_rpc(test, "test")`,
 	}
 }
 
+func TestRuntimeHTTPRequestCookieJar(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/"})
+		case "/check":
+			if c, err := r.Cookie("session"); err == nil {
+				w.Write([]byte(c.Value))
+			}
+		}
+	}))
+	defer srv.Close()
+
+	modules := map[string]string{
+		"test": `
+local nakama = require("nakama")
+function test(ctx, payload)
+	nakama.http_request(payload .. "/login", "GET", {}, nil, nil, "jar")
+	local _, _, without_jar = nakama.http_request(payload .. "/check", "GET", {})
+	local _, _, with_jar = nakama.http_request(payload .. "/check", "GET", {}, nil, nil, "jar")
+	return without_jar .. "," .. with_jar
+end
+nakama.register_rpc(test, "test")`,
+	}
+
+	runtime, err := runtimeWithModules(t, modules)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	fn := runtime.Rpc("test")
+	if fn == nil {
+		t.Fatal("Expected RPC function to be registered")
+	}
+
+	result, err, _ := fn(context.Background(), nil, "", "", nil, 0, "", "", "", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result != ",abc" {
+		t.Fatal("Invocation failed. Return result not expected", result)
+	}
+}
+
+func TestRuntimeHTTPRequestHeadersCaseInsensitive(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{}"))
+	}))
+	defer srv.Close()
+
+	modules := map[string]string{
+		"test": `
+local nakama = require("nakama")
+function test(ctx, payload)
+	local _, headers, _ = nakama.http_request(payload, "GET", {})
+	local values = {headers["content-type"], headers["Content-Type"], headers["CONTENT-TYPE"]}
+	assert(headers["x-missing"] == nil, "expected missing header to be nil")
+	for k, _ in pairs(headers) do
+		assert(k ~= "content-type", "expected header names to keep their canonical form")
+	end
+	return table.concat(values, ",")
+end
+nakama.register_rpc(test, "test")`,
+	}
+
+	runtime, err := runtimeWithModules(t, modules)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	fn := runtime.Rpc("test")
+	if fn == nil {
+		t.Fatal("Expected RPC function to be registered")
+	}
+
+	result, err, _ := fn(context.Background(), nil, "", "", nil, 0, "", "", "", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result != "application/json,application/json,application/json" {
+		t.Fatal("Invocation failed. Return result not expected", result)
+	}
+}
+
 func TestRuntimeJson(t *testing.T) {
 	modules := map[string]string{
 		"test": `
@@ -470,6 +562,54 @@ nakama.register_rpc(test, "test")`,
 	}
 }
 
+func TestRuntimeBase64DecodeTolerant(t *testing.T) {
+	modules := map[string]string{
+		"test": `
+local nakama = require("nakama")
+function test(ctx, payload)
+	-- Bytes that encode to different characters in the standard and URL-safe alphabets.
+	local input = "\251\255\191\254"
+	assert(nakama.base64_encode(input) == "+/+//g==")
+	assert(nakama.base64url_encode(input) == "-_-__g==")
+
+	assert(nakama.base64_decode("+/+//g==", true, true) == input, "expected standard alphabet to decode")
+	assert(nakama.base64_decode("+/+//g", false, true) == input, "expected unpadded standard alphabet to decode")
+	assert(nakama.base64_decode("-_-__g==", true, true) == input, "expected URL-safe alphabet to decode")
+	assert(nakama.base64_decode("-_-__g", false, true) == input, "expected unpadded URL-safe alphabet to decode")
+
+	-- Strict mode only accepts the standard alphabet.
+	local ok = pcall(nakama.base64_decode, "-_-__g==")
+	assert(not ok, "expected strict decode of URL-safe alphabet to fail")
+
+	-- Mixed alphabets are not valid in either.
+	ok = pcall(nakama.base64_decode, "+_-/g", false, true)
+	assert(not ok, "expected tolerant decode of mixed alphabets to fail")
+
+	return "ok"
+end
+nakama.register_rpc(test, "test")`,
+	}
+
+	runtime, err := runtimeWithModules(t, modules)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	fn := runtime.Rpc("test")
+	if fn == nil {
+		t.Fatal("Expected RPC function to be registered")
+	}
+
+	result, err, _ := fn(context.Background(), nil, "", "", nil, 0, "", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result != "ok" {
+		t.Fatal("Invocation failed. Return result not expected", result)
+	}
+}
+
 func TestRuntimeBase16(t *testing.T) {
 	modules := map[string]string{
 		"test": `
@@ -501,6 +641,52 @@ nakama.register_rpc(test, "test")`,
 	}
 }
 
+func TestRuntimeBase16Case(t *testing.T) {
+	modules := map[string]string{
+		"test": `
+local nakama = require("nakama")
+function test(ctx, payload)
+	local input = "\171\205\239"
+	assert(nakama.base16_encode(input) == "abcdef", "expected lowercase by default")
+	assert(nakama.base16_encode(input, false) == "abcdef", "expected lowercase")
+	assert(nakama.base16_encode(input, true) == "ABCDEF", "expected uppercase")
+
+	assert(nakama.base16_decode("abcdef") == input, "expected lowercase to decode")
+	assert(nakama.base16_decode("ABCDEF") == input, "expected uppercase to decode")
+	assert(nakama.base16_decode("AbCdEf") == input, "expected mixed case to decode")
+
+	local ok, err = pcall(nakama.base16_decode, "abcde")
+	assert(not ok, "expected odd length to fail")
+	assert(string.find(err, "odd length", 1, true), "expected odd length error, got: " .. err)
+
+	ok = pcall(nakama.base16_decode, "abcdeg")
+	assert(not ok, "expected invalid digit to fail")
+
+	return "ok"
+end
+nakama.register_rpc(test, "test")`,
+	}
+
+	runtime, err := runtimeWithModules(t, modules)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	fn := runtime.Rpc("test")
+	if fn == nil {
+		t.Fatal("Expected RPC function to be registered")
+	}
+
+	result, err, _ := fn(context.Background(), nil, "", "", nil, 0, "", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result != "ok" {
+		t.Fatal("Invocation failed. Return result not expected", result)
+	}
+}
+
 func TestRuntimeAes128(t *testing.T) {
 	modules := map[string]string{
 		"test": `
@@ -732,6 +918,376 @@ end`,
 	}
 }
 
+func TestRuntimeSqlQueryStream(t *testing.T) {
+	modules := map[string]string{
+		"test": `
+local nk = require("nakama")
+function test(ctx, payload)
+	local sum = 0
+	local count = nk.sql_query_stream("SELECT n FROM generate_series(1, 100000) AS n", {}, function(row)
+		sum = sum + row.n
+		return row.n < 10
+	end)
+	assert(count == 10, "expected streaming to stop early")
+
+	local total = nk.sql_query_stream("SELECT n FROM generate_series(1, 1000) AS n", {}, function(row) end)
+	assert(total == 1000, "expected all rows to be streamed")
+
+	return tostring(sum)
+end
+nk.register_rpc(test, "test")`,
+	}
+
+	runtime, err := runtimeWithModules(t, modules)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	fn := runtime.Rpc("test")
+	if fn == nil {
+		t.Fatal("Expected RPC function to be registered")
+	}
+
+	result, err, _ := fn(context.Background(), nil, "", "", nil, 0, "", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result != "55" {
+		t.Fatal("Invocation failed. Return result not expected", result)
+	}
+}
+
+func TestRuntimeStorageWriteIf(t *testing.T) {
+	modules := map[string]string{
+		"test": `
+local nk = require("nakama")
+function test(ctx, payload)
+	local object_id = {collection = "settings", key = nk.uuid_v4(), user_id = nil}
+
+	local version = nk.storage_write_if(object_id, "*", {count = 1})
+	local next_version = nk.storage_write_if(object_id, version, {count = 2})
+	assert(next_version ~= version, "expected new version")
+
+	local ok, err = pcall(nk.storage_write_if, object_id, version, {count = 3})
+	assert(not ok, "expected stale version to be rejected")
+	assert(string.find(err, "version mismatch"), "expected version mismatch error")
+
+	local objects = nk.storage_read({object_id})
+	assert(objects[1].version == next_version, "expected current version")
+	return tostring(objects[1].value.count)
+end
+nk.register_rpc(test, "test")`,
+	}
+
+	runtime, err := runtimeWithModules(t, modules)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	fn := runtime.Rpc("test")
+	if fn == nil {
+		t.Fatal("Expected RPC function to be registered")
+	}
+
+	result, err, _ := fn(context.Background(), nil, "", "", nil, 0, "", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result != "2" {
+		t.Fatal("Invocation failed. Return result not expected", result)
+	}
+}
+
+func TestRuntimeStorageDeleteIf(t *testing.T) {
+	modules := map[string]string{
+		"test": `
+local nk = require("nakama")
+function test(ctx, payload)
+	local object_id = {collection = "settings", key = nk.uuid_v4(), user_id = nil}
+
+	local version = nk.storage_write_if(object_id, "*", {count = 1})
+	local next_version = nk.storage_write_if(object_id, version, {count = 2})
+
+	local ok, err = pcall(nk.storage_delete, {{collection = object_id.collection, key = object_id.key, version = version}})
+	assert(not ok, "expected stale version to be rejected")
+	assert(string.find(err, "version check failed"), "expected version check error")
+	assert(#nk.storage_read({object_id}) == 1, "expected object to remain")
+
+	nk.storage_delete({{collection = object_id.collection, key = object_id.key, version = next_version}})
+	return tostring(#nk.storage_read({object_id}))
+end
+nk.register_rpc(test, "test")`,
+	}
+
+	runtime, err := runtimeWithModules(t, modules)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	fn := runtime.Rpc("test")
+	if fn == nil {
+		t.Fatal("Expected RPC function to be registered")
+	}
+
+	result, err, _ := fn(context.Background(), nil, "", "", nil, 0, "", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result != "0" {
+		t.Fatal("Invocation failed. Return result not expected", result)
+	}
+}
+
+func TestRuntimeMatchModuleIncompleteHandlers(t *testing.T) {
+	modules := map[string]string{
+		"match": `
+local M = {}
+function M.match_init(context, params) return {}, 1, "" end
+function M.match_join_attempt(context, dispatcher, tick, state, presence, metadata) return state, true end
+function M.match_join(context, dispatcher, tick, state, presences) return state end
+function M.match_leave(context, dispatcher, tick, state, presences) return state end
+function M.match_terminate(context, dispatcher, tick, state, grace_seconds) return state end
+return M`,
+		"helpers": `
+return {match_helper = function() end}`,
+	}
+
+	_, err := runtimeWithModules(t, modules)
+	if err == nil {
+		t.Fatal("expected an error for a match module missing match_loop")
+	}
+	if err.Error() != "match module 'match' is missing required handlers: match_loop" {
+		t.Fatalf("unexpected error: %v", err.Error())
+	}
+}
+
+func TestCheckLuaMatchHandlers(t *testing.T) {
+	vm := lua.NewState()
+	defer vm.Close()
+	fn := vm.NewFunction(func(l *lua.LState) int { return 0 })
+
+	tab := vm.CreateTable(0, 0)
+	if err := checkLuaMatchHandlers("empty", tab); err != nil {
+		t.Fatalf("expected non-match modules to be accepted, got: %v", err.Error())
+	}
+
+	tab.RawSetString("match_init", fn)
+	tab.RawSetString("match_loop", lua.LString("not a function"))
+	err := checkLuaMatchHandlers("partial", tab)
+	if err == nil || err.Error() != "match module 'partial' is missing required handlers: match_join_attempt, match_join, match_leave, match_loop, match_terminate" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, handler := range runtimeLuaMatchRequiredHandlers {
+		tab.RawSetString(handler, fn)
+	}
+	if err := checkLuaMatchHandlers("complete", tab); err != nil {
+		t.Fatalf("expected complete match modules to be accepted, got: %v", err.Error())
+	}
+}
+
+func TestRuntimeMatchOpCodeDispatch(t *testing.T) {
+	modules := map[string]string{
+		"test": `
+local nk = require("nakama")
+
+nk.match_op_code_handler(1, function(context, dispatcher, tick, state, message)
+	state.moves = state.moves + 1
+	return state
+end)
+nk.match_op_code_handler(2, function(context, dispatcher, tick, state, message)
+	state.chat = state.chat .. message.data
+	return state
+end)
+
+function test(ctx, payload)
+	local messages = {
+		{op_code = 1, data = ""},
+		{op_code = 2, data = "hello"},
+		{op_code = 3, data = "unhandled"},
+		{op_code = 1, data = ""},
+		{op_code = 2, data = "world"}
+	}
+	local state = nk.match_op_code_dispatch(ctx, {}, 0, {moves = 0, chat = ""}, messages)
+	assert(#messages == 5, "expected raw messages to be untouched")
+	return tostring(state.moves) .. " " .. state.chat
+end
+nk.register_rpc(test, "test")`,
+	}
+
+	runtime, err := runtimeWithModules(t, modules)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	fn := runtime.Rpc("test")
+	if fn == nil {
+		t.Fatal("Expected RPC function to be registered")
+	}
+
+	result, err, _ := fn(context.Background(), nil, "", "", nil, 0, "", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result != "2 helloworld" {
+		t.Fatal("Invocation failed. Return result not expected", result)
+	}
+}
+
+func TestRuntimeCallStackSizeLimit(t *testing.T) {
+	modules := map[string]string{
+		"test": `
+local nk = require("nakama")
+local function recurse(depth)
+	return 1 + recurse(depth + 1)
+end
+function test(ctx, payload)
+	return tostring(recurse(0))
+end
+nk.register_rpc(test, "test")`,
+	}
+
+	runtime, err := runtimeWithModules(t, modules)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	fn := runtime.Rpc("test")
+	if fn == nil {
+		t.Fatal("Expected RPC function to be registered")
+	}
+
+	// Unbounded recursion must fail once the configured call stack size is exceeded.
+	_, err, _ = fn(context.Background(), nil, "", "", nil, 0, "", "", "", "")
+	if err == nil {
+		t.Fatal("Expected call stack size limit to be exceeded")
+	}
+	if !strings.Contains(err.Error(), "stack overflow") {
+		t.Fatal("Expected stack overflow error", err.Error())
+	}
+}
+
+func TestRuntimeRpcInProcess(t *testing.T) {
+	modules := map[string]string{
+		"test": `
+local nk = require("nakama")
+function greet(ctx, payload)
+	return "hello " .. payload
+end
+nk.register_rpc(greet, "greet")
+
+function shout(ctx, payload)
+	return string.upper(nk.rpc(ctx, "greet", payload)) .. "!"
+end
+nk.register_rpc(shout, "shout")
+
+function loop(ctx, payload)
+	return nk.rpc(ctx, "loop", payload)
+end
+nk.register_rpc(loop, "loop")
+
+function test(ctx, payload)
+	local ok, err = pcall(nk.rpc, ctx, "loop", "")
+	assert(not ok, "expected recursion depth to be bounded")
+	assert(string.find(err, "rpc call depth exceeds maximum"), "expected call depth error")
+
+	return nk.rpc(ctx, "shout", payload)
+end
+nk.register_rpc(test, "test")`,
+	}
+
+	runtime, err := runtimeWithModules(t, modules)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	fn := runtime.Rpc("test")
+	if fn == nil {
+		t.Fatal("Expected RPC function to be registered")
+	}
+
+	result, err, _ := fn(context.Background(), nil, "", "", nil, 0, "", "", "", "world")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result != "HELLO WORLD!" {
+		t.Fatal("Invocation failed. Return result not expected", result)
+	}
+}
+
+func TestRuntimeHTTPRequestToStorage(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":"` + strings.Repeat("a", 4096) + `"}`))
+	}))
+	defer srv.Close()
+
+	modules := map[string]string{
+		"test": `
+local nk = require("nakama")
+function test(ctx, payload)
+	local key = nk.uuid_v4()
+	local ack = nk.http_request_to_storage(payload, "downloads", key)
+	local objects = nk.storage_read({{collection = "downloads", key = key, user_id = nil}})
+	assert(#objects == 1, "expected stored object")
+	assert(objects[1].version == ack.version, "expected matching version")
+
+	local ok = pcall(nk.http_request_to_storage, payload, "downloads", nk.uuid_v4(), nil, nil, nil, 1024)
+	assert(not ok, "expected max size to be exceeded")
+
+	return tostring(#objects[1].value.data)
+end
+nk.register_rpc(test, "test")`,
+	}
+
+	runtime, err := runtimeWithModules(t, modules)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	fn := runtime.Rpc("test")
+	if fn == nil {
+		t.Fatal("Expected RPC function to be registered")
+	}
+
+	result, err, _ := fn(context.Background(), nil, "", "", nil, 0, "", "", "", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result != "4096" {
+		t.Fatal("Invocation failed. Return result not expected", result)
+	}
+}
+
+func TestRuntimeHTTPRequestToStorageObjectLimit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Flush to send the body chunked, without a content length to check up front.
+		w.Write([]byte(`{"data":"`))
+		w.(http.Flusher).Flush()
+		w.Write([]byte(strings.Repeat("a", 256) + `"}`))
+	}))
+	defer srv.Close()
+
+	config := NewConfig(logger)
+	config.Runtime.StorageMaxObjectBytes = 64
+	nakamaModule := NewRuntimeLuaNakamaModule(logger, nil, nil, nil, config, nil, nil, nil, nil, nil, nil, nil, nil, nil, metrics, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+
+	vm := lua.NewState()
+	defer vm.Close()
+	vm.PreloadModule("nakama", nakamaModule.Loader)
+
+	// The storage object limit applies while reading the body even when the requested max size is larger.
+	err := vm.DoString(fmt.Sprintf(`require("nakama").http_request_to_storage(%q, "downloads", "key", nil, nil, nil, 1024)`, srv.URL))
+	if err == nil || !strings.Contains(err.Error(), "HTTP response body error: exceeds max size of 64 bytes") {
+		t.Fatalf("expected storage object size limit to be exceeded, got: %v", err)
+	}
+}
+
 func TestRuntimeReqBeforeHook(t *testing.T) {
 	modules := map[string]string{
 		"test": `
@@ -999,3 +1555,203 @@ nk.group_delete(group.id)`,
 		t.Fatal(err.Error())
 	}
 }
+
+func TestRuntimeUsernameValidate(t *testing.T) {
+	modules := map[string]string{
+		"test": `
+local nakama = require("nakama")
+function test(ctx, payload)
+	local results = {
+		tostring(nakama.username_validate("Player_One")),
+		tostring(nakama.username_validate(string.rep("a", 129))),
+		tostring(nakama.username_validate("abcdef", 5)),
+		tostring(nakama.username_validate("bad\tname")),
+		tostring(nakama.username_validate("bad\0name")),
+		tostring(nakama.username_validate("")),
+		nakama.username_normalize("Player_One")
+	}
+	return table.concat(results, ",")
+end
+nakama.register_rpc(test, "test")`,
+	}
+
+	runtime, err := runtimeWithModules(t, modules)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	fn := runtime.Rpc("test")
+	if fn == nil {
+		t.Fatal("Expected RPC function to be registered")
+	}
+
+	result, err, _ := fn(context.Background(), nil, "", "", nil, 0, "", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result != "true,false,false,false,false,false,player_one" {
+		t.Fatal("Invocation failed. Return result not expected", result)
+	}
+}
+
+func TestRuntimeGetConfig(t *testing.T) {
+	modules := map[string]string{
+		"test": `
+local nakama = require("nakama")
+function test(ctx, payload)
+	local name = nakama.get_config("name")
+	local ok, err = pcall(nakama.get_config, "session.encryption_key")
+	if ok then
+		error("expected secret config key to be rejected")
+	end
+	return name .. "," .. tostring(nakama.get_config("match.input_queue_size"))
+end
+nakama.register_rpc(test, "test")`,
+	}
+
+	runtime, err := runtimeWithModules(t, modules)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	fn := runtime.Rpc("test")
+	if fn == nil {
+		t.Fatal("Expected RPC function to be registered")
+	}
+
+	result, err, _ := fn(context.Background(), nil, "", "", nil, 0, "", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := fmt.Sprintf("%v,%v", cfg.GetName(), cfg.GetMatch().InputQueueSize)
+	if result != expected {
+		t.Fatal("Invocation failed. Return result not expected", result)
+	}
+}
+
+func TestRuntimeHTTPRequestContextCancel(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Respond far slower than the test allows, unless the client goes away.
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	modules := map[string]string{
+		"test": `
+local nk = require("nakama")
+function test(ctx, payload)
+	nk.http_request(payload, "GET", {}, nil, 30000)
+	return "completed"
+end
+nk.register_rpc(test, "test")`,
+	}
+
+	runtime, err := runtimeWithModules(t, modules)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	fn := runtime.Rpc("test")
+	if fn == nil {
+		t.Fatal("Expected RPC function to be registered")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	result, err, _ := fn(ctx, nil, "", "", nil, 0, "", "", "", srv.URL)
+	if err == nil {
+		t.Fatal("Expected cancelled request to return an error, got result", result)
+	}
+
+	// The request is aborted when the context is cancelled, well before its own timeout.
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Expected cancelled request to return promptly, took %v", elapsed)
+	}
+}
+
+func TestRuntimeHTTPRequestConnectionReuse(t *testing.T) {
+	var newConns atomic.Int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	srv.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			newConns.Inc()
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	modules := map[string]string{
+		"test": `
+local nk = require("nakama")
+function test(ctx, payload)
+	for i = 1, 5 do
+		local code, _, body = nk.http_request(payload, "GET", {})
+		assert(code == 200 and body == "ok", "unexpected response")
+	end
+	return "ok"
+end
+nk.register_rpc(test, "test")`,
+	}
+
+	runtime, err := runtimeWithModules(t, modules)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	fn := runtime.Rpc("test")
+	if fn == nil {
+		t.Fatal("Expected RPC function to be registered")
+	}
+
+	if _, err, _ := fn(context.Background(), nil, "", "", nil, 0, "", "", "", srv.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	// Sequential requests to the same host share a single pooled connection.
+	if count := newConns.Load(); count != 1 {
+		t.Fatalf("expected 1 connection, got %v", count)
+	}
+}
+
+func TestOptScoreFromLua(t *testing.T) {
+	vm := lua.NewState()
+	defer vm.Close()
+	vm.SetGlobal("score", vm.NewFunction(func(l *lua.LState) int {
+		score, ok := optScoreFromLua(l, 1, "score")
+		if !ok {
+			return 0
+		}
+		l.Push(lua.LString(fmt.Sprintf("%d", score)))
+		return 1
+	}))
+
+	// The largest number Lua can represent below the int64 maximum converts exactly.
+	if err := vm.DoString(`assert(score(9223372036854774784) == "9223372036854774784")`); err != nil {
+		t.Fatalf("expected max score to be accepted, got: %v", err.Error())
+	}
+	if err := vm.DoString(`assert(score(-9223372036854775808) == "-9223372036854775808")`); err != nil {
+		t.Fatalf("expected min score to be accepted, got: %v", err.Error())
+	}
+	if err := vm.DoString(`assert(score() == "0")`); err != nil {
+		t.Fatalf("expected omitted score to default to 0, got: %v", err.Error())
+	}
+
+	// Numbers past the int64 range are rejected rather than wrapping to negative values.
+	for _, value := range []string{"9223372036854775807", "1e19", "-1e19", "0/0"} {
+		err := vm.DoString(fmt.Sprintf("score(%v)", value))
+		if err == nil || !strings.Contains(err.Error(), "expects score to be between -9223372036854775808 and 9223372036854775807") {
+			t.Fatalf("expected score %v to be rejected, got: %v", value, err)
+		}
+	}
+}
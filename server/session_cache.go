@@ -0,0 +1,94 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// SessionCache tracks session token revocations, so tokens can be rejected before they expire.
+type SessionCache interface {
+	Stop()
+	// Check if a session token issued to the user at the given time, in UTC seconds, has not been revoked since.
+	IsValidSession(userID uuid.UUID, issuedAt int64) bool
+	// Revoke all session tokens issued to the user up to now.
+	RemoveAll(userID uuid.UUID)
+}
+
+type LocalSessionCache struct {
+	sync.RWMutex
+	ctx         context.Context
+	ctxCancelFn context.CancelFunc
+
+	tokenExpirySec int64
+	// Time each user's session tokens were last revoked, in UTC seconds.
+	revoked map[uuid.UUID]int64
+}
+
+func NewLocalSessionCache(config Config) SessionCache {
+	ctx, ctxCancelFn := context.WithCancel(context.Background())
+
+	s := &LocalSessionCache{
+		ctx:         ctx,
+		ctxCancelFn: ctxCancelFn,
+
+		tokenExpirySec: config.GetSession().TokenExpirySec,
+		revoked:        make(map[uuid.UUID]int64),
+	}
+
+	go func() {
+		ticker := time.NewTicker(2 * time.Duration(s.tokenExpirySec) * time.Second)
+		for {
+			select {
+			case <-s.ctx.Done():
+				ticker.Stop()
+				return
+			case t := <-ticker.C:
+				// Tokens issued before a revocation have all expired once the token expiry has passed.
+				cutoff := t.UTC().Unix() - s.tokenExpirySec
+				s.Lock()
+				for userID, revokeTime := range s.revoked {
+					if revokeTime < cutoff {
+						delete(s.revoked, userID)
+					}
+				}
+				s.Unlock()
+			}
+		}
+	}()
+
+	return s
+}
+
+func (s *LocalSessionCache) Stop() {
+	s.ctxCancelFn()
+}
+
+func (s *LocalSessionCache) IsValidSession(userID uuid.UUID, issuedAt int64) bool {
+	s.RLock()
+	revokeTime, found := s.revoked[userID]
+	s.RUnlock()
+	return !found || issuedAt > revokeTime
+}
+
+func (s *LocalSessionCache) RemoveAll(userID uuid.UUID) {
+	s.Lock()
+	s.revoked[userID] = time.Now().UTC().Unix()
+	s.Unlock()
+}
@@ -0,0 +1,54 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalSessionCacheRemoveAll(t *testing.T) {
+	sessionCache := NewLocalSessionCache(cfg)
+	defer sessionCache.Stop()
+
+	userID := uuid.Must(uuid.NewV4())
+	otherUserID := uuid.Must(uuid.NewV4())
+	issuedAt := time.Now().UTC().Unix() - 10
+	assert.True(t, sessionCache.IsValidSession(userID, issuedAt))
+
+	// Tokens issued before the revocation are rejected, later ones and other users' are not.
+	sessionCache.RemoveAll(userID)
+	assert.False(t, sessionCache.IsValidSession(userID, issuedAt))
+	assert.True(t, sessionCache.IsValidSession(userID, time.Now().UTC().Unix()+1))
+	assert.True(t, sessionCache.IsValidSession(otherUserID, issuedAt))
+}
+
+func TestParseTokenRevoked(t *testing.T) {
+	sessionCache := NewLocalSessionCache(cfg)
+	defer sessionCache.Stop()
+
+	userID := uuid.Must(uuid.NewV4())
+	token, _ := generateToken(cfg, userID.String(), "alice", nil)
+	_, _, _, _, ok := parseToken([]byte(cfg.GetSession().EncryptionKey), sessionCache, token)
+	assert.True(t, ok)
+
+	// Revocation takes effect for tokens issued up to the same second.
+	sessionCache.RemoveAll(userID)
+	_, _, _, _, ok = parseToken([]byte(cfg.GetSession().EncryptionKey), sessionCache, token)
+	assert.False(t, ok)
+}
@@ -37,8 +37,11 @@ type Session interface {
 	ID() uuid.UUID
 	UserID() uuid.UUID
 	Vars() map[string]string
+	SetVars(map[string]string)
 	ClientIP() string
 	ClientPort() string
+	// Time the session connected, in UTC seconds.
+	CreateTime() int64
 
 	Context() context.Context
 
@@ -62,6 +65,37 @@ type SessionRegistry interface {
 	Add(session Session)
 	Remove(sessionID uuid.UUID)
 	Disconnect(ctx context.Context, sessionID uuid.UUID) error
+	// Replace the vars of a live session, subsequent calls made on that session will see the new values.
+	UpdateVars(sessionID uuid.UUID, vars map[string]string) error
+}
+
+// SessionInfo describes an active session of a user.
+type SessionInfo struct {
+	ID   uuid.UUID
+	Node string
+	// Only known for sessions connected to this node, otherwise 0.
+	CreateTime int64
+	// Only known for sessions connected to this node, otherwise empty.
+	ClientIP string
+}
+
+// ListUserSessions lists the active sessions of a user on any node, found through the notification stream every session
+// joins when it connects.
+func ListUserSessions(tracker Tracker, sessionRegistry SessionRegistry, userID uuid.UUID) []*SessionInfo {
+	presenceIDs := tracker.ListPresenceIDByStream(PresenceStream{Mode: StreamModeNotifications, Subject: userID})
+	sessions := make([]*SessionInfo, 0, len(presenceIDs))
+	for _, presenceID := range presenceIDs {
+		info := &SessionInfo{
+			ID:   presenceID.SessionID,
+			Node: presenceID.Node,
+		}
+		if session := sessionRegistry.Get(presenceID.SessionID); session != nil {
+			info.CreateTime = session.CreateTime()
+			info.ClientIP = session.ClientIP()
+		}
+		sessions = append(sessions, info)
+	}
+	return sessions
 }
 
 type LocalSessionRegistry struct {
@@ -114,3 +148,12 @@ func (r *LocalSessionRegistry) Disconnect(ctx context.Context, sessionID uuid.UU
 	}
 	return nil
 }
+
+func (r *LocalSessionRegistry) UpdateVars(sessionID uuid.UUID, vars map[string]string) error {
+	session, ok := r.sessions.Load(sessionID)
+	if !ok {
+		return ErrSessionNotFound
+	}
+	session.(Session).SetVars(vars)
+	return nil
+}
@@ -0,0 +1,96 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalSessionRegistryUpdateVars(t *testing.T) {
+	sessionRegistry := NewLocalSessionRegistry(metrics)
+
+	session := newTestSession()
+	session.vars = map[string]string{"tier": "free"}
+	sessionRegistry.Add(session)
+	defer sessionRegistry.Remove(session.ID())
+
+	err := sessionRegistry.UpdateVars(session.ID(), map[string]string{"tier": "gold", "region": "eu"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"tier": "gold", "region": "eu"}, sessionRegistry.Get(session.ID()).Vars())
+
+	err = sessionRegistry.UpdateVars(uuid.Must(uuid.NewV4()), map[string]string{"tier": "gold"})
+	assert.Equal(t, ErrSessionNotFound, err)
+}
+
+func TestLocalSessionRegistryCount(t *testing.T) {
+	sessionRegistry := NewLocalSessionRegistry(metrics)
+	assert.Equal(t, 0, sessionRegistry.Count())
+
+	sessions := make([]*testSession, 0, 3)
+	for i := 0; i < 3; i++ {
+		session := newTestSession()
+		sessionRegistry.Add(session)
+		sessions = append(sessions, session)
+		assert.Equal(t, i+1, sessionRegistry.Count())
+	}
+
+	sessionRegistry.Remove(sessions[1].ID())
+	assert.Equal(t, 2, sessionRegistry.Count())
+
+	sessionRegistry.Remove(sessions[0].ID())
+	sessionRegistry.Remove(sessions[2].ID())
+	assert.Equal(t, 0, sessionRegistry.Count())
+}
+
+func TestListUserSessions(t *testing.T) {
+	sessionRegistry := NewLocalSessionRegistry(metrics)
+	tracker := StartLocalTracker(logger, cfg, sessionRegistry, metrics, jsonpbMarshaler)
+	defer tracker.Stop()
+
+	userID := uuid.Must(uuid.NewV4())
+	sessions := []*testSession{
+		{id: uuid.Must(uuid.NewV4()), userID: userID, clientIP: "10.0.0.1", createTime: 1000},
+		{id: uuid.Must(uuid.NewV4()), userID: userID, clientIP: "10.0.0.2", createTime: 2000},
+		{id: uuid.Must(uuid.NewV4()), userID: userID, clientIP: "10.0.0.3", createTime: 3000},
+		// Another user's session is not listed.
+		newTestSession(),
+	}
+	for _, session := range sessions {
+		sessionRegistry.Add(session)
+		tracker.Track(session.ID(), PresenceStream{Mode: StreamModeNotifications, Subject: session.UserID()}, session.UserID(), PresenceMeta{Format: SessionFormatJson, Username: session.Username(), Hidden: true}, true)
+	}
+
+	listed := ListUserSessions(tracker, sessionRegistry, userID)
+	if assert.Len(t, listed, 3) {
+		expected := make(map[uuid.UUID]*testSession, 3)
+		for _, session := range sessions[:3] {
+			expected[session.id] = session
+		}
+		for _, info := range listed {
+			session, found := expected[info.ID]
+			if assert.True(t, found, "unexpected session %v", info.ID) {
+				assert.Equal(t, cfg.GetName(), info.Node)
+				assert.Equal(t, session.clientIP, info.ClientIP)
+				assert.Equal(t, session.createTime, info.CreateTime)
+				delete(expected, info.ID)
+			}
+		}
+	}
+
+	assert.Len(t, ListUserSessions(tracker, sessionRegistry, uuid.Must(uuid.NewV4())), 0)
+}
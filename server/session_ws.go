@@ -44,10 +44,11 @@ type sessionWS struct {
 	format     SessionFormat
 	userID     uuid.UUID
 	username   *atomic.String
-	vars       map[string]string
+	vars       *atomic.Value
 	expiry     int64
 	clientIP   string
 	clientPort string
+	createTime int64
 
 	ctx         context.Context
 	ctxCancelFn context.CancelFunc
@@ -86,6 +87,9 @@ func NewSessionWS(logger *zap.Logger, config Config, format SessionFormat, sessi
 		wsMessageType = websocket.BinaryMessage
 	}
 
+	sessionVars := &atomic.Value{}
+	sessionVars.Store(vars)
+
 	return &sessionWS{
 		logger:     sessionLogger,
 		config:     config,
@@ -93,10 +97,11 @@ func NewSessionWS(logger *zap.Logger, config Config, format SessionFormat, sessi
 		format:     format,
 		userID:     userID,
 		username:   atomic.NewString(username),
-		vars:       vars,
+		vars:       sessionVars,
 		expiry:     expiry,
 		clientIP:   clientIP,
 		clientPort: clientPort,
+		createTime: time.Now().UTC().Unix(),
 
 		ctx:         ctx,
 		ctxCancelFn: ctxCancelFn,
@@ -144,6 +149,10 @@ func (s *sessionWS) ClientPort() string {
 	return s.clientPort
 }
 
+func (s *sessionWS) CreateTime() int64 {
+	return s.createTime
+}
+
 func (s *sessionWS) Context() context.Context {
 	return s.ctx
 }
@@ -157,7 +166,11 @@ func (s *sessionWS) SetUsername(username string) {
 }
 
 func (s *sessionWS) Vars() map[string]string {
-	return s.vars
+	return s.vars.Load().(map[string]string)
+}
+
+func (s *sessionWS) SetVars(vars map[string]string) {
+	s.vars.Store(vars)
 }
 
 func (s *sessionWS) Expiry() int64 {
@@ -167,7 +180,7 @@ func (s *sessionWS) Expiry() int64 {
 func (s *sessionWS) Consume() {
 	// Fire an event for session start.
 	if fn := s.runtime.EventSessionStart(); fn != nil {
-		fn(s.userID.String(), s.username.Load(), s.vars, s.expiry, s.id.String(), s.clientIP, s.clientPort, time.Now().UTC().Unix())
+		fn(s.userID.String(), s.username.Load(), s.Vars(), s.expiry, s.id.String(), s.clientIP, s.clientPort, time.Now().UTC().Unix())
 	}
 
 	s.conn.SetReadLimit(s.config.GetSocket().MaxMessageSizeBytes)
@@ -440,7 +453,7 @@ func (s *sessionWS) Close(reason string) {
 	if s.logger.Core().Enabled(zap.DebugLevel) {
 		s.logger.Info("Cleaned up closed connection matchmaker")
 	}
-	s.tracker.UntrackAll(s.id)
+	s.tracker.UntrackAll(s.id, PresenceReasonDisconnect)
 	if s.logger.Core().Enabled(zap.DebugLevel) {
 		s.logger.Info("Cleaned up closed connection tracker")
 	}
@@ -467,6 +480,6 @@ func (s *sessionWS) Close(reason string) {
 
 	// Fire an event for session end.
 	if fn := s.runtime.EventSessionEnd(); fn != nil {
-		fn(s.userID.String(), s.username.Load(), s.vars, s.expiry, s.id.String(), s.clientIP, s.clientPort, time.Now().UTC().Unix(), reason)
+		fn(s.userID.String(), s.username.Load(), s.Vars(), s.expiry, s.id.String(), s.clientIP, s.clientPort, time.Now().UTC().Unix(), reason)
 	}
 }
@@ -95,7 +95,7 @@ func (m *LocalStreamManager) UserLeave(stream PresenceStream, userID, sessionID
 		return ErrNodeNotFound
 	}
 
-	m.tracker.Untrack(sessionID, stream, userID)
+	m.tracker.Untrack(sessionID, stream, userID, PresenceReasonLeave)
 
 	return nil
 }
@@ -40,6 +40,17 @@ const (
 	StreamModeMatchAuthoritative
 )
 
+type PresenceReason uint8
+
+const (
+	PresenceReasonUnknown PresenceReason = iota
+	PresenceReasonJoin
+	PresenceReasonUpdate
+	PresenceReasonLeave
+	PresenceReasonDisconnect
+	PresenceReasonKick
+)
+
 type PresenceID struct {
 	Node      string
 	SessionID uuid.UUID
@@ -58,6 +69,7 @@ type PresenceMeta struct {
 	Persistence bool
 	Username    string
 	Status      string
+	Reason      PresenceReason
 }
 
 func (pm *PresenceMeta) GetHidden() bool {
@@ -114,8 +126,8 @@ type Tracker interface {
 
 	// Track returns success true/false, and new presence true/false.
 	Track(sessionID uuid.UUID, stream PresenceStream, userID uuid.UUID, meta PresenceMeta, allowIfFirstForSession bool) (bool, bool)
-	Untrack(sessionID uuid.UUID, stream PresenceStream, userID uuid.UUID)
-	UntrackAll(sessionID uuid.UUID)
+	Untrack(sessionID uuid.UUID, stream PresenceStream, userID uuid.UUID, reason PresenceReason)
+	UntrackAll(sessionID uuid.UUID, reason PresenceReason)
 	// Update returns success true/false - will only fail if the user has no presence and allowIfFirstForSession is false, otherwise is an upsert.
 	Update(sessionID uuid.UUID, stream PresenceStream, userID uuid.UUID, meta PresenceMeta, allowIfFirstForSession bool) bool
 
@@ -141,6 +153,8 @@ type Tracker interface {
 	GetBySessionIDStreamUserID(node string, sessionID uuid.UUID, stream PresenceStream, userID uuid.UUID) *PresenceMeta
 	// List presences by stream, optionally include hidden ones and not hidden ones.
 	ListByStream(stream PresenceStream, includeHidden bool, includeNotHidden bool) []*Presence
+	// List all presences of the given user across all their sessions and streams.
+	ListByUserID(userID uuid.UUID) []*Presence
 
 	// Fast lookup of local session IDs to use for message delivery.
 	ListLocalSessionIDByStream(stream PresenceStream) []uuid.UUID
@@ -274,7 +288,7 @@ func (t *LocalTracker) Track(sessionID uuid.UUID, stream PresenceStream, userID
 	return true, true
 }
 
-func (t *LocalTracker) Untrack(sessionID uuid.UUID, stream PresenceStream, userID uuid.UUID) {
+func (t *LocalTracker) Untrack(sessionID uuid.UUID, stream PresenceStream, userID uuid.UUID, reason PresenceReason) {
 	pc := presenceCompact{ID: PresenceID{Node: t.name, SessionID: sessionID}, Stream: stream, UserID: userID}
 	t.Lock()
 
@@ -324,6 +338,7 @@ func (t *LocalTracker) Untrack(sessionID uuid.UUID, stream PresenceStream, userI
 
 	t.Unlock()
 	if !meta.Hidden {
+		meta.Reason = reason
 		t.queueEvent(
 			nil,
 			[]Presence{
@@ -333,7 +348,7 @@ func (t *LocalTracker) Untrack(sessionID uuid.UUID, stream PresenceStream, userI
 	}
 }
 
-func (t *LocalTracker) UntrackAll(sessionID uuid.UUID) {
+func (t *LocalTracker) UntrackAll(sessionID uuid.UUID, reason PresenceReason) {
 	t.Lock()
 
 	bySession, anyTracked := t.presencesBySession[sessionID]
@@ -368,6 +383,7 @@ func (t *LocalTracker) UntrackAll(sessionID uuid.UUID) {
 
 		// Check if there should be an event for this presence.
 		if !meta.Hidden {
+			meta.Reason = reason
 			leaves = append(leaves, Presence{ID: pc.ID, Stream: pc.Stream, UserID: pc.UserID, Meta: meta})
 		}
 
@@ -618,6 +634,27 @@ func (t *LocalTracker) ListByStream(stream PresenceStream, includeHidden bool, i
 	return ps
 }
 
+func (t *LocalTracker) ListByUserID(userID uuid.UUID) []*Presence {
+	t.RLock()
+	// Every session of an online user is present on that user's notification stream.
+	sessions, anyTracked := t.presencesByStream[StreamModeNotifications][PresenceStream{Mode: StreamModeNotifications, Subject: userID}]
+	if !anyTracked {
+		t.RUnlock()
+		return []*Presence{}
+	}
+	ps := make([]*Presence, 0, len(sessions))
+	for sessionPc := range sessions {
+		for pc, meta := range t.presencesBySession[sessionPc.ID.SessionID] {
+			if pc.UserID != userID {
+				continue
+			}
+			ps = append(ps, &Presence{ID: pc.ID, Stream: pc.Stream, UserID: pc.UserID, Meta: meta})
+		}
+	}
+	t.RUnlock()
+	return ps
+}
+
 func (t *LocalTracker) ListLocalSessionIDByStream(stream PresenceStream) []uuid.UUID {
 	t.RLock()
 	byStream, anyTracked := t.presencesByStream[stream.Mode][stream]
@@ -736,6 +773,7 @@ func (t *LocalTracker) processEvent(e *PresenceEvent) {
 				UserID:    p.UserID,
 				SessionID: p.ID.SessionID,
 				Username:  p.Meta.Username,
+				Reason:    p.Meta.Reason,
 			}
 			if l, ok := matchLeaves[p.Stream.Subject]; ok {
 				matchLeaves[p.Stream.Subject] = append(l, mp)
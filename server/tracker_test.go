@@ -0,0 +1,62 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrackerListByUserID(t *testing.T) {
+	tracker := StartLocalTracker(logger, cfg, NewLocalSessionRegistry(metrics), metrics, jsonpbMarshaler)
+	defer tracker.Stop()
+
+	onlineUserID := uuid.Must(uuid.NewV4())
+	otherUserID := uuid.Must(uuid.NewV4())
+	offlineUserID := uuid.Must(uuid.NewV4())
+	sessionID := uuid.Must(uuid.NewV4())
+	otherSessionID := uuid.Must(uuid.NewV4())
+
+	notificationStream := PresenceStream{Mode: StreamModeNotifications, Subject: onlineUserID}
+	statusStream := PresenceStream{Mode: StreamModeStatus, Subject: onlineUserID}
+	channelStream := PresenceStream{Mode: StreamModeChannel, Label: "lobby"}
+	tracker.Track(sessionID, notificationStream, onlineUserID, PresenceMeta{Format: SessionFormatJson, Username: "online", Hidden: true}, true)
+	tracker.Track(sessionID, statusStream, onlineUserID, PresenceMeta{Format: SessionFormatJson, Username: "online", Status: "playing"}, false)
+	tracker.Track(sessionID, channelStream, onlineUserID, PresenceMeta{Format: SessionFormatJson, Username: "online"}, false)
+	// Presences of other users on shared streams must not be attributed to the user.
+	tracker.Track(otherSessionID, PresenceStream{Mode: StreamModeNotifications, Subject: otherUserID}, otherUserID, PresenceMeta{Format: SessionFormatJson, Username: "other", Hidden: true}, true)
+	tracker.Track(otherSessionID, channelStream, otherUserID, PresenceMeta{Format: SessionFormatJson, Username: "other"}, false)
+
+	presences := tracker.ListByUserID(onlineUserID)
+	assert.Len(t, presences, 3)
+	streams := make(map[PresenceStream]*Presence, len(presences))
+	for _, p := range presences {
+		assert.Equal(t, onlineUserID, p.UserID)
+		assert.Equal(t, sessionID, p.ID.SessionID)
+		streams[p.Stream] = p
+	}
+	assert.Contains(t, streams, notificationStream)
+	assert.Contains(t, streams, channelStream)
+	if assert.Contains(t, streams, statusStream) {
+		assert.Equal(t, "playing", streams[statusStream].Meta.Status)
+	}
+
+	assert.Empty(t, tracker.ListByUserID(offlineUserID))
+
+	tracker.UntrackAll(sessionID, PresenceReasonDisconnect)
+	assert.Empty(t, tracker.ListByUserID(onlineUserID))
+}
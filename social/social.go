@@ -44,6 +44,7 @@ type Client struct {
 	logger *zap.Logger
 
 	client               *http.Client
+	steamURL             string
 	googleMutex          sync.RWMutex
 	googleCerts          []*rsa.PublicKey
 	googleCertsRefreshAt int64
@@ -140,6 +141,19 @@ type SteamProfileWrapper struct {
 	} `json:"response"`
 }
 
+type steamFriendsWrapper struct {
+	FriendsList struct {
+		Friends []SteamProfile `json:"friends"`
+	} `json:"friendslist"`
+}
+
+type steamAppOwnershipWrapper struct {
+	AppOwnership struct {
+		OwnsApp bool   `json:"ownsapp"`
+		Result  string `json:"result"`
+	} `json:"appownership"`
+}
+
 // NewClient creates a new Social Client
 func NewClient(logger *zap.Logger, timeout time.Duration) *Client {
 	// From https://knowledge.symantec.com/support/code-signing-support/index?page=content&actp=CROSSLINK&id=AR2170
@@ -186,6 +200,7 @@ dAUK75fDiSKxH3fzvc1D1PFMqT+1i4SvZPLQFCE=
 		client: &http.Client{
 			Timeout: timeout,
 		},
+		steamURL:         "https://api.steampowered.com",
 		gamecenterCaCert: caCert,
 	}
 }
@@ -527,13 +542,18 @@ func (c *Client) CheckGameCenterID(ctx context.Context, playerID string, bundleI
 	return true, nil
 }
 
+// SetSteamURL overrides the base URL of the Steam Web API, for example to use a mock API in tests.
+func (c *Client) SetSteamURL(steamURL string) {
+	c.steamURL = steamURL
+}
+
 // GetSteamProfile retrieves the user's Steam Profile.
 // Key and App ID should be configured at the application level.
 // See: https://partner.steamgames.com/documentation/auth#client_to_backend_webapi
 func (c *Client) GetSteamProfile(ctx context.Context, publisherKey string, appID int, ticket string) (*SteamProfile, error) {
 	c.logger.Debug("Getting Steam profile", zap.String("publisherKey", publisherKey), zap.Int("appID", appID), zap.String("ticket", ticket))
 
-	path := "https://api.steampowered.com/ISteamUserAuth/AuthenticateUserTicket/v1/?format=json" +
+	path := c.steamURL + "/ISteamUserAuth/AuthenticateUserTicket/v1/?format=json" +
 		"&key=" + url.QueryEscape(publisherKey) + "&appid=" + strconv.Itoa(appID) + "&ticket=" + url.QueryEscape(ticket)
 	var profileWrapper SteamProfileWrapper
 	err := c.request(ctx, "steam profile", path, nil, &profileWrapper)
@@ -549,6 +569,40 @@ func (c *Client) GetSteamProfile(ctx context.Context, publisherKey string, appID
 	return profileWrapper.Response.Params, nil
 }
 
+// GetSteamFriends retrieves the Steam IDs of the user's friends.
+// The user's Steam profile friend list must be public, unless they are a user of the publisher's apps.
+// See: https://partner.steamgames.com/doc/webapi/ISteamUser#GetFriendList
+func (c *Client) GetSteamFriends(ctx context.Context, publisherKey string, steamID uint64) ([]SteamProfile, error) {
+	c.logger.Debug("Getting Steam friends", zap.String("publisherKey", publisherKey), zap.Uint64("steamID", steamID))
+
+	path := c.steamURL + "/ISteamUser/GetFriendList/v1/?format=json&relationship=friend" +
+		"&key=" + url.QueryEscape(publisherKey) + "&steamid=" + strconv.FormatUint(steamID, 10)
+	var friendsWrapper steamFriendsWrapper
+	err := c.request(ctx, "steam friends", path, nil, &friendsWrapper)
+	if err != nil {
+		return nil, err
+	}
+	return friendsWrapper.FriendsList.Friends, nil
+}
+
+// CheckSteamAppOwnership checks if the user owns the given app, including through family sharing.
+// See: https://partner.steamgames.com/doc/webapi/ISteamUser#CheckAppOwnership
+func (c *Client) CheckSteamAppOwnership(ctx context.Context, publisherKey string, appID int, steamID uint64) (bool, error) {
+	c.logger.Debug("Checking Steam app ownership", zap.String("publisherKey", publisherKey), zap.Int("appID", appID), zap.Uint64("steamID", steamID))
+
+	path := c.steamURL + "/ISteamUser/CheckAppOwnership/v2/?format=json" +
+		"&key=" + url.QueryEscape(publisherKey) + "&appid=" + strconv.Itoa(appID) + "&steamid=" + strconv.FormatUint(steamID, 10)
+	var ownershipWrapper steamAppOwnershipWrapper
+	err := c.request(ctx, "steam app ownership", path, nil, &ownershipWrapper)
+	if err != nil {
+		return false, err
+	}
+	if ownershipWrapper.AppOwnership.Result != "OK" {
+		return false, fmt.Errorf("steam app ownership check failed: %v", ownershipWrapper.AppOwnership.Result)
+	}
+	return ownershipWrapper.AppOwnership.OwnsApp, nil
+}
+
 func (c *Client) CheckAppleToken(ctx context.Context, bundleId string, idToken string) (*AppleProfile, error) {
 	c.logger.Debug("Checking Apple Sign In", zap.String("bundleId", bundleId), zap.String("idToken", idToken))
 
@@ -0,0 +1,116 @@
+// Copyright 2020 The Nakama Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package social
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// Start a mock Steam Web API, responding to each known path with the given body and to any other path with a 404.
+func newTestSteamClient(t *testing.T, responses map[string]string) *Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("key") != "publisher-key" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		body, ok := responses[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+
+	client := NewClient(zap.NewNop(), 5*time.Second)
+	client.steamURL = server.URL
+	return client
+}
+
+func TestGetSteamProfile(t *testing.T) {
+	client := newTestSteamClient(t, map[string]string{
+		"/ISteamUserAuth/AuthenticateUserTicket/v1/": `{"response":{"params":{"result":"OK","steamid":"76561197960287930","ownersteamid":"76561197960287930"}}}`,
+	})
+
+	profile, err := client.GetSteamProfile(context.Background(), "publisher-key", 480, "ticket")
+	if err != nil {
+		t.Fatalf("error getting steam profile: %v", err)
+	}
+	assert.Equal(t, uint64(76561197960287930), profile.SteamID)
+
+	// Invalid publisher keys are rejected by Steam.
+	_, err = client.GetSteamProfile(context.Background(), "invalid", 480, "ticket")
+	assert.Error(t, err)
+}
+
+func TestGetSteamProfileInvalidTicket(t *testing.T) {
+	client := newTestSteamClient(t, map[string]string{
+		"/ISteamUserAuth/AuthenticateUserTicket/v1/": `{"response":{"error":{"errorcode":101,"errordesc":"Invalid ticket"}}}`,
+	})
+
+	_, err := client.GetSteamProfile(context.Background(), "publisher-key", 480, "ticket")
+	assert.EqualError(t, err, "Invalid ticket, 101")
+}
+
+func TestCheckSteamAppOwnership(t *testing.T) {
+	client := newTestSteamClient(t, map[string]string{
+		"/ISteamUser/CheckAppOwnership/v2/": `{"appownership":{"ownsapp":true,"permanent":true,"timestamp":"2020-01-01T00:00:00Z","ownersteamid":"76561197960287930","result":"OK"}}`,
+	})
+	ownsApp, err := client.CheckSteamAppOwnership(context.Background(), "publisher-key", 480, 76561197960287930)
+	if err != nil {
+		t.Fatalf("error checking steam app ownership: %v", err)
+	}
+	assert.True(t, ownsApp)
+
+	client = newTestSteamClient(t, map[string]string{
+		"/ISteamUser/CheckAppOwnership/v2/": `{"appownership":{"ownsapp":false,"permanent":false,"result":"OK"}}`,
+	})
+	ownsApp, err = client.CheckSteamAppOwnership(context.Background(), "publisher-key", 480, 76561197960287930)
+	if err != nil {
+		t.Fatalf("error checking steam app ownership: %v", err)
+	}
+	assert.False(t, ownsApp)
+
+	// Steam could not look up ownership, for example for an unknown app.
+	client = newTestSteamClient(t, map[string]string{
+		"/ISteamUser/CheckAppOwnership/v2/": `{"appownership":{"ownsapp":false,"result":"Failure"}}`,
+	})
+	_, err = client.CheckSteamAppOwnership(context.Background(), "publisher-key", 480, 76561197960287930)
+	assert.Error(t, err)
+}
+
+func TestGetSteamFriends(t *testing.T) {
+	client := newTestSteamClient(t, map[string]string{
+		"/ISteamUser/GetFriendList/v1/": `{"friendslist":{"friends":[{"steamid":"76561197960265731","relationship":"friend","friend_since":0},{"steamid":"76561197960265738","relationship":"friend","friend_since":1500000000}]}}`,
+	})
+
+	friends, err := client.GetSteamFriends(context.Background(), "publisher-key", 76561197960287930)
+	if err != nil {
+		t.Fatalf("error getting steam friends: %v", err)
+	}
+	assert.Equal(t, []SteamProfile{{SteamID: 76561197960265731}, {SteamID: 76561197960265738}}, friends)
+
+	// Private friend lists are rejected by Steam.
+	client = newTestSteamClient(t, nil)
+	_, err = client.GetSteamFriends(context.Background(), "publisher-key", 76561197960287930)
+	assert.Error(t, err)
+}